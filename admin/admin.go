@@ -0,0 +1,667 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dianpeng/moons/fault"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/kv"
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/ratelimit"
+	"github.com/dianpeng/moons/server"
+	"github.com/dianpeng/moons/util"
+
+	"github.com/gorilla/mux"
+)
+
+// Admin listener, the control plane of the server over http. It hosts no
+// vhost, but inspects and controls the server it runs in, ie
+//
+//   {"type": "admin", "name": "admin", "endpoint": "127.0.0.1:9901", "token": "secret"}
+//
+// or admin,admin,127.0.0.1:9901[,token] in compact form. Once the token is
+// set the request must carry it as "Authorization: Bearer <token>". Without
+// token the endpoint must be loopback or unix socket, so the control plane is
+// never exposed to the network unauthenticated. The endpoints reply JSON, the
+// error is {"error": "..."}
+//
+//   GET  /listeners                   listeners of the server
+//   GET  /vhosts                      vhosts of the server
+//   GET  /vhosts/{name}               services, routes and modules of vhost
+//   GET  /vhosts/{name}/bytecode      dump of the compiled module, ?service=
+//   GET  /vhosts/{name}/health        health of the upstreams of vhost
+//   GET  /vhosts/{name}/metrics       counters of vhost
+//   POST /vhosts/{name}/drain         drains vhost, ?timeout= in seconds
+//   POST /vhosts/{name}/resume        resumes the drained vhost
+//   GET  /vhosts/{name}/config        config phase calls, ?service= ?op=
+//                                     ?scope= ?name= filter the calls
+//   GET  /vhosts/{name}/rules         hot rules of vhost, ?top= the first N
+//   POST /vhosts/{name}/rules/reset   clears the rule statistics of vhost
+//   GET  /modules                     registered http modules
+//   GET  /faults                      fault injectors with their counters
+//   GET  /faults/{name}               fault injector of name
+//   PUT  /faults/{name}               changes the faults, JSON body
+//   GET  /health                      health of all the vhosts
+//   GET  /metrics                     process wide counters
+//   POST /reload                      reloads all the vhosts
+//   GET  /log/level                   current log level
+//   PUT  /log/level                   changes log level, ?level=
+
+const (
+	defaultDrainTimeout = 30
+)
+
+type listenerConfig struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Endpoint string `json:"endpoint"`
+	Token    string `json:"token"`
+}
+
+func (lc *listenerConfig) TypeName() string {
+	return lc.Type
+}
+
+type listener struct {
+	name   string
+	token  string
+	server *http.Server
+	srv    *server.Server
+	start  time.Time
+
+	socketLock sync.Mutex
+	socket     *server.DrainListener
+}
+
+type fac struct{}
+
+// whether the endpoint is only reachable from the local host
+func isLocalEndpoint(endpoint string) bool {
+	if strings.HasPrefix(endpoint, "unix://") {
+		return true
+	}
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (f *fac) New(sopt server.ListenerConfig) (server.Listener, error) {
+	opt := sopt.(*listenerConfig)
+	if opt.Token == "" && !isLocalEndpoint(opt.Endpoint) {
+		return nil, fmt.Errorf("listener %s: admin endpoint %s is not loopback, token is required",
+			opt.Name, opt.Endpoint)
+	}
+	l := &listener{
+		name:  opt.Name,
+		token: opt.Token,
+		start: time.Now(),
+	}
+	l.server = &http.Server{
+		Addr:    opt.Endpoint,
+		Handler: l.router(),
+	}
+	return l, nil
+}
+
+func (f *fac) ParseConfigJSON(input string) (server.ListenerConfig, error) {
+	o := &listenerConfig{}
+	if err := json.Unmarshal([]byte(input), o); err != nil {
+		return o, err
+	}
+	if o.Name == "" {
+		return o, fmt.Errorf("must specify Name for listener config")
+	}
+	if o.Endpoint == "" {
+		return o, fmt.Errorf("must specify Endpoint for listener config")
+	}
+	return o, nil
+}
+
+func (f *fac) ParseConfigCompact(input string) (server.ListenerConfig, error) {
+	conf := &listenerConfig{}
+	x := strings.Split(input, ",")
+	if len(x) < 3 {
+		return conf, fmt.Errorf("invalid listener config: %s, at least 3 elements are needed", input)
+	}
+	conf.Type = x[0]
+	conf.Name = x[1]
+	conf.Endpoint = x[2]
+	if len(x) > 3 {
+		conf.Token = x[3]
+	}
+	return conf, nil
+}
+
+func (l *listener) AttachServer(s *server.Server) {
+	l.srv = s
+}
+
+func (l *listener) Name() string {
+	return l.name
+}
+
+func (l *listener) Type() string {
+	return "admin"
+}
+
+func (l *listener) AddVHost(server.VHost) error {
+	return fmt.Errorf("listener %s: admin listener hosts no vhost", l.name)
+}
+
+func (l *listener) UpdateVHost(server.VHost) error {
+	return fmt.Errorf("listener %s: admin listener hosts no vhost", l.name)
+}
+
+func (l *listener) RemoveVHost(string) {
+}
+
+func (l *listener) GetVHost(string) server.VHost {
+	return nil
+}
+
+func (l *listener) Run() error {
+	ln, err := server.Listen(l.name, l.server.Addr)
+	if err != nil {
+		return err
+	}
+	socket := server.NewDrainListener(ln)
+	l.socketLock.Lock()
+	l.socket = socket
+	l.socketLock.Unlock()
+
+	if err := l.server.Serve(socket); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (l *listener) Shutdown(ctx context.Context) error {
+	l.socketLock.Lock()
+	if l.socket != nil {
+		l.socket.Stop()
+	}
+	l.socketLock.Unlock()
+
+	err := l.server.Shutdown(ctx)
+	if err != nil {
+		l.server.Close()
+	}
+	return err
+}
+
+// -----------------------------------------------------------------------------
+// endpoints
+
+func reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func replyError(w http.ResponseWriter, status int, err error) {
+	reply(w, status, map[string]interface{}{
+		"error": err.Error(),
+	})
+}
+
+func (l *listener) router() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/listeners", l.listListeners).Methods("GET")
+	r.HandleFunc("/vhosts", l.listVHosts).Methods("GET")
+	r.HandleFunc("/vhosts/{name}", l.inspectVHost).Methods("GET")
+	r.HandleFunc("/vhosts/{name}/bytecode", l.bytecode).Methods("GET")
+	r.HandleFunc("/vhosts/{name}/health", l.vhostHealth).Methods("GET")
+	r.HandleFunc("/vhosts/{name}/metrics", l.vhostMetrics).Methods("GET")
+	r.HandleFunc("/vhosts/{name}/drain", l.drain).Methods("POST")
+	r.HandleFunc("/vhosts/{name}/resume", l.resume).Methods("POST")
+	r.HandleFunc("/vhosts/{name}/config", l.configAudit).Methods("GET")
+	r.HandleFunc("/vhosts/{name}/rules", l.ruleStats).Methods("GET")
+	r.HandleFunc("/vhosts/{name}/rules/reset", l.resetRuleStats).Methods("POST")
+	r.HandleFunc("/vhosts/{name}/log/level", l.getVHostLogLevel).Methods("GET")
+	r.HandleFunc("/vhosts/{name}/log/level", l.setVHostLogLevel).Methods("PUT", "POST")
+	r.HandleFunc("/modules", l.listModules).Methods("GET")
+	r.HandleFunc("/faults", l.listFaults).Methods("GET")
+	r.HandleFunc("/faults/{name}", l.getFault).Methods("GET")
+	r.HandleFunc("/faults/{name}", l.setFault).Methods("PUT", "POST")
+	r.HandleFunc("/health", l.health).Methods("GET")
+	r.HandleFunc("/metrics", l.metrics).Methods("GET")
+	r.HandleFunc("/reload", l.reload).Methods("POST")
+	r.HandleFunc("/log/level", l.getLogLevel).Methods("GET")
+	r.HandleFunc("/log/level", l.setLogLevel).Methods("PUT", "POST")
+	return l.auth(r)
+}
+
+func (l *listener) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.token != "" && subtle.ConstantTimeCompare(
+			[]byte(r.Header.Get("Authorization")),
+			[]byte("Bearer "+l.token),
+		) != 1 {
+			replyError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *listener) listListeners(w http.ResponseWriter, _ *http.Request) {
+	o := []interface{}{}
+	for _, x := range l.srv.Listeners() {
+		o = append(o, map[string]interface{}{
+			"name": x.Name(),
+			"type": x.Type(),
+		})
+	}
+	reply(w, http.StatusOK, o)
+}
+
+func (l *listener) listVHosts(w http.ResponseWriter, _ *http.Request) {
+	o := []interface{}{}
+	for _, x := range l.srv.VHosts() {
+		v := map[string]interface{}{
+			"name":     x.Name(),
+			"type":     x.ListenerType(),
+			"listener": x.ListenerName(),
+		}
+		if d, ok := x.(server.Drainer); ok {
+			v["draining"] = d.Draining()
+		}
+		o = append(o, v)
+	}
+	reply(w, http.StatusOK, o)
+}
+
+// calls the function with the inspector of the vhost of the request
+func (l *listener) withInspector(
+	w http.ResponseWriter,
+	r *http.Request,
+	f func(server.Inspector) (interface{}, error),
+) {
+	name := mux.Vars(r)["name"]
+	var result interface{}
+	err := l.srv.InspectVHost(name, func(v server.VHost) error {
+		x, ok := v.(server.Inspector)
+		if !ok {
+			return fmt.Errorf("vhost %s cannot be inspected", name)
+		}
+		var err error
+		result, err = f(x)
+		return err
+	})
+	if err != nil {
+		replyError(w, http.StatusNotFound, err)
+		return
+	}
+	reply(w, http.StatusOK, result)
+}
+
+func (l *listener) inspectVHost(w http.ResponseWriter, r *http.Request) {
+	l.withInspector(w, r, func(x server.Inspector) (interface{}, error) {
+		return x.Inspect(), nil
+	})
+}
+
+func (l *listener) bytecode(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("service")
+	l.withInspector(w, r, func(x server.Inspector) (interface{}, error) {
+		dump, err := x.Bytecode(service)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"service":  service,
+			"bytecode": dump,
+		}, nil
+	})
+}
+
+func (l *listener) vhostHealth(w http.ResponseWriter, r *http.Request) {
+	l.withInspector(w, r, func(x server.Inspector) (interface{}, error) {
+		return x.Health(), nil
+	})
+}
+
+func (l *listener) vhostMetrics(w http.ResponseWriter, r *http.Request) {
+	l.withInspector(w, r, func(x server.Inspector) (interface{}, error) {
+		return x.Metrics(), nil
+	})
+}
+
+func (l *listener) drain(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	timeout := int64(defaultDrainTimeout)
+	if x := r.URL.Query().Get("timeout"); x != "" {
+		v, err := strconv.ParseInt(x, 10, 64)
+		if err != nil || v <= 0 {
+			replyError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout %s", x))
+			return
+		}
+		timeout = v
+	}
+	if err := l.srv.DrainVHost(name, time.Duration(timeout)*time.Second); err != nil {
+		replyError(w, http.StatusConflict, err)
+		return
+	}
+	reply(w, http.StatusOK, map[string]interface{}{
+		"name":     name,
+		"draining": true,
+	})
+}
+
+func (l *listener) resume(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if err := l.srv.ResumeVHost(name); err != nil {
+		replyError(w, http.StatusNotFound, err)
+		return
+	}
+	reply(w, http.StatusOK, map[string]interface{}{
+		"name":     name,
+		"draining": false,
+	})
+}
+
+func (l *listener) configAudit(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	q := r.URL.Query()
+	match := func(x pl.ConfigAuditEntry) bool {
+		for k, v := range map[string]string{
+			"op":    x.Op,
+			"scope": x.Scope,
+			"name":  x.Name,
+		} {
+			if want := q.Get(k); want != "" && want != v {
+				return false
+			}
+		}
+		return true
+	}
+
+	o := []pl.ConfigAuditEntry{}
+	err := l.srv.InspectVHost(name, func(v server.VHost) error {
+		x, ok := v.(server.ConfigAuditor)
+		if !ok {
+			return fmt.Errorf("vhost %s does not have config audit", name)
+		}
+		list, err := x.ConfigAudit(q.Get("service"))
+		if err != nil {
+			return err
+		}
+		for _, e := range list {
+			if match(e) {
+				o = append(o, e)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		replyError(w, http.StatusNotFound, err)
+		return
+	}
+	reply(w, http.StatusOK, o)
+}
+
+// calls the function with the profiler of the vhost of the request
+func (l *listener) withProfiler(
+	w http.ResponseWriter,
+	r *http.Request,
+	f func(server.Profiler) interface{},
+) {
+	name := mux.Vars(r)["name"]
+	var result interface{}
+	err := l.srv.InspectVHost(name, func(v server.VHost) error {
+		x, ok := v.(server.Profiler)
+		if !ok {
+			return fmt.Errorf("vhost %s does not have rule statistics", name)
+		}
+		result = f(x)
+		return nil
+	})
+	if err != nil {
+		replyError(w, http.StatusNotFound, err)
+		return
+	}
+	reply(w, http.StatusOK, result)
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (l *listener) ruleStats(w http.ResponseWriter, r *http.Request) {
+	top := 0
+	if x := r.URL.Query().Get("top"); x != "" {
+		v, err := strconv.Atoi(x)
+		if err != nil || v <= 0 {
+			replyError(w, http.StatusBadRequest, fmt.Errorf("invalid top %s", x))
+			return
+		}
+		top = v
+	}
+	l.withProfiler(w, r, func(x server.Profiler) interface{} {
+		list := x.RuleStats()
+		if top > 0 && len(list) > top {
+			list = list[:top]
+		}
+		o := []interface{}{}
+		for _, s := range list {
+			o = append(o, map[string]interface{}{
+				"service": s.Service,
+				"name":    s.Name,
+				"kind":    s.Kind,
+				"count":   s.Count,
+				"errors":  s.Errors,
+				"time":    durationMs(s.Time),
+				"average": durationMs(s.Average()),
+			})
+		}
+		return o
+	})
+}
+
+func (l *listener) resetRuleStats(w http.ResponseWriter, r *http.Request) {
+	l.withProfiler(w, r, func(x server.Profiler) interface{} {
+		x.ResetRuleStats()
+		return map[string]interface{}{
+			"name":  mux.Vars(r)["name"],
+			"reset": true,
+		}
+	})
+}
+
+func (l *listener) listModules(w http.ResponseWriter, _ *http.Request) {
+	o := []interface{}{}
+	for _, x := range framework.ListFactories() {
+		o = append(o, map[string]interface{}{
+			"kind":    x.Kind,
+			"name":    x.Name,
+			"comment": x.Comment,
+		})
+	}
+	reply(w, http.StatusOK, o)
+}
+
+func faultInfo(x *fault.Injector) interface{} {
+	return map[string]interface{}{
+		"name":   x.Name(),
+		"option": x.Option(),
+		"stats":  x.Stats(),
+	}
+}
+
+func (l *listener) listFaults(w http.ResponseWriter, _ *http.Request) {
+	o := []interface{}{}
+	for _, name := range fault.Names() {
+		if x := fault.Find(name); x != nil {
+			o = append(o, faultInfo(x))
+		}
+	}
+	reply(w, http.StatusOK, o)
+}
+
+func (l *listener) getFault(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	x := fault.Find(name)
+	if x == nil {
+		replyError(w, http.StatusNotFound, fmt.Errorf("fault %s is not existed", name))
+		return
+	}
+	reply(w, http.StatusOK, faultInfo(x))
+}
+
+// the faults changed last until the config of the injector changes, ie the
+// vhost is reloaded with another config
+func (l *listener) setFault(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	x := fault.Find(name)
+	if x == nil {
+		replyError(w, http.StatusNotFound, fmt.Errorf("fault %s is not existed", name))
+		return
+	}
+	option := &fault.Option{}
+	if err := json.NewDecoder(r.Body).Decode(option); err != nil {
+		replyError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := x.SetOption(option); err != nil {
+		replyError(w, http.StatusBadRequest, err)
+		return
+	}
+	reply(w, http.StatusOK, faultInfo(x))
+}
+
+func (l *listener) health(w http.ResponseWriter, _ *http.Request) {
+	o := make(map[string]interface{})
+	for _, v := range l.srv.VHosts() {
+		if x, ok := v.(server.Inspector); ok {
+			o[v.Name()] = x.Health()
+		}
+	}
+	reply(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+		"vhosts": o,
+	})
+}
+
+func (l *listener) metrics(w http.ResponseWriter, _ *http.Request) {
+	concurrency := make(map[string]interface{})
+	for _, name := range ratelimit.ConcurrencyNames() {
+		if c := ratelimit.FindConcurrency(name); c != nil {
+			s := c.Stats()
+			concurrency[name] = map[string]interface{}{
+				"limit":    s.Limit,
+				"inflight": s.InFlight,
+				"queued":   s.Queued,
+				"admitted": s.Admitted,
+				"rejected": s.Rejected,
+				"timedOut": s.TimedOut,
+			}
+		}
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	reply(w, http.StatusOK, map[string]interface{}{
+		"uptime":      int64(time.Since(l.start).Seconds()),
+		"goroutines":  runtime.NumGoroutine(),
+		"heapAlloc":   mem.HeapAlloc,
+		"heapObjects": mem.HeapObjects,
+		"numGC":       mem.NumGC,
+		"kv": map[string]interface{}{
+			"entries": kv.Default.Len(),
+			"size":    kv.Default.Size(),
+		},
+		"concurrency": concurrency,
+	})
+}
+
+func (l *listener) reload(w http.ResponseWriter, _ *http.Request) {
+	if err := l.srv.Reload(); err != nil {
+		replyError(w, http.StatusInternalServerError, err)
+		return
+	}
+	reply(w, http.StatusOK, map[string]interface{}{
+		"reloaded": true,
+	})
+}
+
+func (l *listener) getLogLevel(w http.ResponseWriter, _ *http.Request) {
+	reply(w, http.StatusOK, map[string]interface{}{
+		"level": util.LogLevel(),
+	})
+}
+
+func (l *listener) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	if err := util.SetLogLevel(r.URL.Query().Get("level")); err != nil {
+		replyError(w, http.StatusBadRequest, err)
+		return
+	}
+	l.getLogLevel(w, r)
+}
+
+// calls the function with the log leveler of the vhost of the request
+func (l *listener) withLogLeveler(
+	w http.ResponseWriter,
+	r *http.Request,
+	f func(server.LogLeveler) error,
+) {
+	name := mux.Vars(r)["name"]
+	status := http.StatusNotFound
+	var level string
+	err := l.srv.InspectVHost(name, func(v server.VHost) error {
+		x, ok := v.(server.LogLeveler)
+		if !ok {
+			return fmt.Errorf("vhost %s does not have log level", name)
+		}
+		if err := f(x); err != nil {
+			status = http.StatusBadRequest
+			return err
+		}
+		level = x.LogLevel()
+		return nil
+	})
+	if err != nil {
+		replyError(w, status, err)
+		return
+	}
+	reply(w, http.StatusOK, map[string]interface{}{
+		"name":  name,
+		"level": level,
+	})
+}
+
+func (l *listener) getVHostLogLevel(w http.ResponseWriter, r *http.Request) {
+	l.withLogLeveler(w, r, func(server.LogLeveler) error {
+		return nil
+	})
+}
+
+func (l *listener) setVHostLogLevel(w http.ResponseWriter, r *http.Request) {
+	level := r.URL.Query().Get("level")
+	l.withLogLeveler(w, r, func(x server.LogLeveler) error {
+		return x.SetLogLevel(level)
+	})
+}
+
+func init() {
+	server.AddListenerFactory(
+		"admin",
+		&fac{},
+	)
+}
@@ -1,13 +1,40 @@
 package alog
 
+import (
+	"fmt"
+	"time"
+)
+
 type Format struct {
-	Raw string
-	bc  program
+	Raw      string
+	Encoding string
+	bc       program
 }
 
 type Log struct {
 	Format   *Format
 	Appendix []string
+
+	// attempts of the upstream calls made by the transaction, in the order
+	// they finish
+	Attempts []Attempt
+
+	// custom fields computed at the end of the transaction, see Field
+	Fields []Field
+
+	// diagnostic lines emitted by the script, see level.go
+	Messages []Message
+
+	// the transaction failed, ie replied 5xx or error, see Sampler
+	Failed bool
+}
+
+// Attempt is one attempt of an upstream call, either Status or Error is set
+type Attempt struct {
+	Upstream string
+	Status   int
+	Error    string
+	Duration time.Duration
 }
 
 func NewLog(
@@ -19,13 +46,28 @@ func NewLog(
 }
 
 func CompileFormat(input string) (*Format, error) {
+	return CompileFormatEncoding(input, EncodingText)
+}
+
+// CompileFormatEncoding compiles the format whose line is encoded as text,
+// JSON or logfmt, see structured.go
+func CompileFormatEncoding(input string, encoding string) (*Format, error) {
+	switch encoding {
+	case "":
+		encoding = EncodingText
+	case EncodingText, EncodingJSON, EncodingLogfmt:
+		break
+	default:
+		return nil, fmt.Errorf("access log encoding %s is unknown", encoding)
+	}
 	p := formatParser{}
 	if err := p.parse(input); err != nil {
 		return nil, err
 	}
 	return &Format{
-		Raw: input,
-		bc:  p.prog,
+		Raw:      input,
+		Encoding: encoding,
+		bc:       p.prog,
 	}, nil
 }
 
@@ -45,6 +87,10 @@ func (l *Log) ToText(
 		buf.WriteString(a)
 		buf.WriteString(delimiter)
 	}
+	for _, m := range l.Messages {
+		buf.WriteString("[" + LevelName(m.Level) + "] " + m.Text)
+		buf.WriteString(delimiter)
+	}
 
 	return buf.String()
 }
@@ -65,12 +65,16 @@ const (
 	fClientIp
 	fProtocol
 	fScheme
+	fRequestId
 )
 
 type bytecode struct {
 	op     int
 	param  interface{}
 	length int // if < 0 means not in used
+
+	// key of the field in JSON and logfmt, empty for the text
+	key string
 }
 
 // for different types of formatter we need to extract different types of information out
@@ -150,6 +154,7 @@ var cmdMap = map[string]int{
 	"CLIENT_IP":                      fClientIp,
 	"PROTOCOL":                       fProtocol,
 	"SCHEME":                         fScheme,
+	"REQUEST_ID":                     fRequestId,
 }
 
 var paramMap = map[int]int{
@@ -181,6 +186,7 @@ var paramMap = map[int]int{
 	fClientIp:    cmdOnly,
 	fProtocol:    cmdOnly,
 	fScheme:      cmdOnly,
+	fRequestId:   cmdOnly,
 }
 
 var wellknownHttp = map[string]int{
@@ -344,6 +350,7 @@ func (p *formatParser) parse(f string) error {
 			if err := p.parseCommand(cmd, param, length); err != nil {
 				return err
 			}
+			p.prog[len(p.prog)-1].key = fieldKey(format[:percentBeg], name, param)
 		}
 
 		start += percentEnd + 1
@@ -528,7 +535,7 @@ func (p *formatParser) parseCommand(flag int, param string, length int) error {
 	case fRequestMiddleware, fResponseMiddleware, fApplicationMiddleware:
 		return p.parseMiddleware(flag, param, length)
 
-	case fServiceName, fClientIp, fProtocol, fScheme:
+	case fServiceName, fClientIp, fProtocol, fScheme, fRequestId:
 		return p.parseToggle(flag, param, length)
 
 	default:
@@ -0,0 +1,99 @@
+package alog
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Diagnostic lines emitted by the script, ie log::warn("cache miss"). The line
+// is kept in the access log record of the transaction, so it is shipped by
+// the sinks along with the record. The line below the level of the vhost is
+// dropped, the level can be changed at runtime, ie by the admin API.
+
+const (
+	LevelDebug = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelName = []string{
+	"debug",
+	"info",
+	"warn",
+	"error",
+}
+
+func ParseLevel(name string) (int32, error) {
+	for i, x := range levelName {
+		if x == name {
+			return int32(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("log level %s is unknown, expect debug, info, warn or error", name)
+}
+
+func LevelName(level int32) string {
+	if level < 0 || int(level) >= len(levelName) {
+		return "unknown"
+	}
+	return levelName[level]
+}
+
+// Level is the level of the diagnostic lines of the vhost, safe to be changed
+// while the transactions are running
+type Level struct {
+	v int32
+}
+
+// NewLevel creates the level by its name, info if the name is empty
+func NewLevel(name string) (*Level, error) {
+	l := &Level{v: LevelInfo}
+	if name == "" {
+		return l, nil
+	}
+	if err := l.Set(name); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Level) Set(name string) error {
+	v, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&l.v, v)
+	return nil
+}
+
+func (l *Level) String() string {
+	return LevelName(atomic.LoadInt32(&l.v))
+}
+
+// Enabled returns whether the line of the level is kept, the nil level keeps
+// the line of info and above
+func (l *Level) Enabled(level int32) bool {
+	if l == nil {
+		return level >= LevelInfo
+	}
+	return level >= atomic.LoadInt32(&l.v)
+}
+
+// Message is one diagnostic line of the transaction
+type Message struct {
+	Level int32
+	Text  string
+}
+
+// AddMessage appends the line to the log, the line of error level marks the
+// transaction failed, see Sampler
+func (l *Log) AddMessage(level int32, text string) {
+	l.Messages = append(l.Messages, Message{
+		Level: level,
+		Text:  text,
+	})
+	if level >= LevelError {
+		l.Failed = true
+	}
+}
@@ -38,4 +38,5 @@ type Provider interface {
 	ClientIp() (string, bool)
 	Protocol() (string, bool)
 	Scheme() (string, bool)
+	RequestId() (string, bool)
 }
@@ -0,0 +1,103 @@
+package alog
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+)
+
+// Sampling of the access log of the vhost. The record of the request carrying
+// the trigger header is always kept, ie to debug one request in production,
+// the record of the failed transaction is kept by the error rate, and the
+// others by the rate. The failed transaction is the one replied 5xx or error,
+// or emitting the diagnostic line of error level.
+
+// SampleOption is the sampling of the vhost, the rates are in [0, 1]
+type SampleOption struct {
+	Rate      float64
+	ErrorRate float64
+	Header    string
+}
+
+// SampleStats is the counters of the sampler
+type SampleStats struct {
+	Kept    int64 `json:"kept"`
+	Dropped int64 `json:"dropped"`
+	Forced  int64 `json:"forced"`
+}
+
+type Sampler struct {
+	// accessed atomically, kept first for the 64 bit alignment
+	kept    int64
+	dropped int64
+	forced  int64
+
+	option SampleOption
+}
+
+func NewSampler(option *SampleOption) (*Sampler, error) {
+	if option.Rate < 0 || option.Rate > 1 {
+		return nil, fmt.Errorf("access log sample rate %v is not in [0, 1]", option.Rate)
+	}
+	if option.ErrorRate < 0 || option.ErrorRate > 1 {
+		return nil, fmt.Errorf("access log sample error_rate %v is not in [0, 1]", option.ErrorRate)
+	}
+	return &Sampler{
+		option: *option,
+	}, nil
+}
+
+// Header is the request header which forces the record to be kept, empty if
+// not configured
+func (s *Sampler) Header() string {
+	if s == nil {
+		return ""
+	}
+	return s.option.Header
+}
+
+func sampleHit(rate float64) bool {
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	default:
+		return rand.Float64() < rate
+	}
+}
+
+// Keep returns whether the record is uploaded, forced is whether the trigger
+// header is present. The nil sampler keeps all the records
+func (s *Sampler) Keep(l *Log, forced bool) bool {
+	if s == nil {
+		return true
+	}
+	keep := false
+	switch {
+	case forced:
+		atomic.AddInt64(&s.forced, 1)
+		keep = true
+	case l.Failed:
+		keep = sampleHit(s.option.ErrorRate)
+	default:
+		keep = sampleHit(s.option.Rate)
+	}
+	if keep {
+		atomic.AddInt64(&s.kept, 1)
+	} else {
+		atomic.AddInt64(&s.dropped, 1)
+	}
+	return keep
+}
+
+func (s *Sampler) Stats() *SampleStats {
+	if s == nil {
+		return nil
+	}
+	return &SampleStats{
+		Kept:    atomic.LoadInt64(&s.kept),
+		Dropped: atomic.LoadInt64(&s.dropped),
+		Forced:  atomic.LoadInt64(&s.forced),
+	}
+}
@@ -0,0 +1,365 @@
+package alog
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dianpeng/moons/mq"
+)
+
+// Sink of the access log. The line encoded by the vhost is pushed into the
+// bounded queue of each sink and written by the background worker of the
+// sink in batches, so the request never waits for the sink. The batch failed
+// is retried with the exponential backoff, meanwhile the new lines are queued,
+// so the lines survive the outage of the sink as long as the queue holds. Once
+// the queue is full, the overflow policy applies
+//
+//   drop          the new line is dropped, the default
+//   drop_oldest   the oldest line queued is dropped for the new one
+//   block         the request waits the room of the queue up to
+//                 block_timeout, and the line is dropped after it
+//
+// The sinks are registered by type, see sink_file.go, sink_syslog.go,
+// sink_http.go and sink_mq.go.
+
+const (
+	OverflowDrop       = "drop"
+	OverflowDropOldest = "drop_oldest"
+	OverflowBlock      = "block"
+
+	sinkBufferSize    = 4096
+	sinkBatchSize     = 100
+	sinkFlushInterval = time.Second
+	sinkRetryWait     = time.Second
+	sinkMaxRetryWait  = 30 * time.Second
+	sinkBlockTimeout  = 100 * time.Millisecond
+)
+
+// SinkOption is the option of one sink, the fields used depend on the type
+type SinkOption struct {
+	Type string
+
+	// file, the file is rotated once it is larger than MaxSize, and at most
+	// MaxBackups rotated files are kept as path.1, path.2 ...
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+
+	// syslog, the network is udp, tcp, unix or unixgram, the local syslog is
+	// used if the address is empty
+	Network  string
+	Address  string
+	Tag      string
+	Facility string
+
+	// http, the batch is posted as the lines separated by newline
+	URL     string
+	Header  map[string]string
+	Timeout time.Duration
+
+	// mq, the URL of the broker and the topic, each line is one message
+	Topic    string
+	MQOption *mq.Option
+
+	// pipeline, the batch is retried until it is written unless MaxRetry > 0
+	BufferSize    int
+	BatchSize     int
+	FlushInterval time.Duration
+	MaxRetry      int
+	RetryWait     time.Duration
+	Overflow      string
+	BlockTimeout  time.Duration
+}
+
+// Sink writes the batch of lines, the batch failed is retried as whole
+type Sink interface {
+	Write([]string) error
+	Close() error
+}
+
+type SinkFactory func(*SinkOption) (Sink, error)
+
+var (
+	sinkLock    sync.Mutex
+	sinkFactory = make(map[string]SinkFactory)
+)
+
+func RegisterSink(t string, f SinkFactory) {
+	sinkLock.Lock()
+	defer sinkLock.Unlock()
+	sinkFactory[t] = f
+}
+
+func SinkTypes() []string {
+	sinkLock.Lock()
+	defer sinkLock.Unlock()
+	o := []string{}
+	for k := range sinkFactory {
+		o = append(o, k)
+	}
+	return o
+}
+
+// SinkStats is the counters of the pipeline of the sink
+type SinkStats struct {
+	Type    string `json:"type"`
+	Queued  int    `json:"queued"`
+	Written int64  `json:"written"`
+	Dropped int64  `json:"dropped"`
+	Failed  int64  `json:"failed"`
+	Retried int64  `json:"retried"`
+}
+
+// Pipeline is the queue and the worker of one sink
+type Pipeline struct {
+	// accessed atomically, kept first for the 64 bit alignment
+	written int64
+	dropped int64
+	failed  int64
+	retried int64
+	closed  int32
+
+	option *SinkOption
+	sink   Sink
+	queue  chan string
+	done   chan struct{}
+	exit   chan struct{}
+	once   sync.Once
+}
+
+func NewPipeline(option *SinkOption) (*Pipeline, error) {
+	sinkLock.Lock()
+	f, ok := sinkFactory[option.Type]
+	sinkLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("access log sink type %s is unknown", option.Type)
+	}
+	switch option.Overflow {
+	case "":
+		option.Overflow = OverflowDrop
+	case OverflowDrop, OverflowDropOldest, OverflowBlock:
+		break
+	default:
+		return nil, fmt.Errorf("access log sink overflow %s is unknown", option.Overflow)
+	}
+	if option.BufferSize <= 0 {
+		option.BufferSize = sinkBufferSize
+	}
+	if option.BatchSize <= 0 {
+		option.BatchSize = sinkBatchSize
+	}
+	if option.FlushInterval <= 0 {
+		option.FlushInterval = sinkFlushInterval
+	}
+	if option.RetryWait <= 0 {
+		option.RetryWait = sinkRetryWait
+	}
+	if option.BlockTimeout <= 0 {
+		option.BlockTimeout = sinkBlockTimeout
+	}
+
+	sink, err := f(option)
+	if err != nil {
+		return nil, fmt.Errorf("access log sink %s: %s", option.Type, err.Error())
+	}
+	p := &Pipeline{
+		option: option,
+		sink:   sink,
+		queue:  make(chan string, option.BufferSize),
+		done:   make(chan struct{}),
+		exit:   make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+// Push queues the line, it never blocks unless the overflow policy is block
+func (p *Pipeline) Push(line string) {
+	if atomic.LoadInt32(&p.closed) == 1 {
+		atomic.AddInt64(&p.dropped, 1)
+		return
+	}
+	select {
+	case p.queue <- line:
+		return
+	default:
+		break
+	}
+
+	switch p.option.Overflow {
+	case OverflowDropOldest:
+		select {
+		case <-p.queue:
+			atomic.AddInt64(&p.dropped, 1)
+		default:
+			break
+		}
+		select {
+		case p.queue <- line:
+			return
+		default:
+			break
+		}
+	case OverflowBlock:
+		t := time.NewTimer(p.option.BlockTimeout)
+		defer t.Stop()
+		select {
+		case p.queue <- line:
+			return
+		case <-t.C:
+			break
+		case <-p.done:
+			break
+		}
+	}
+	atomic.AddInt64(&p.dropped, 1)
+}
+
+func (p *Pipeline) run() {
+	defer close(p.exit)
+
+	tick := time.NewTicker(p.option.FlushInterval)
+	defer tick.Stop()
+
+	batch := make([]string, 0, p.option.BatchSize)
+	for {
+		select {
+		case line := <-p.queue:
+			batch = append(batch, line)
+			if len(batch) < p.option.BatchSize {
+				continue
+			}
+		case <-tick.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-p.done:
+			p.drain(batch)
+			return
+		}
+		if !p.write(batch) {
+			p.drain(batch)
+			return
+		}
+		batch = make([]string, 0, p.option.BatchSize)
+	}
+}
+
+// writes the batch with retry, returns false if the pipeline is closed in
+// the middle, the batch is not written then
+func (p *Pipeline) write(batch []string) bool {
+	wait := p.option.RetryWait
+	for i := 0; ; i++ {
+		err := p.sink.Write(batch)
+		if err == nil {
+			atomic.AddInt64(&p.written, int64(len(batch)))
+			return true
+		}
+		if p.option.MaxRetry > 0 && i >= p.option.MaxRetry {
+			atomic.AddInt64(&p.failed, int64(len(batch)))
+			return true
+		}
+		atomic.AddInt64(&p.retried, 1)
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+			break
+		case <-p.done:
+			t.Stop()
+			return false
+		}
+		if wait *= 2; wait > sinkMaxRetryWait {
+			wait = sinkMaxRetryWait
+		}
+	}
+}
+
+// writes the batch and the lines queued without retry, the rest is given up
+// once the sink fails, so the closing does not wait the sink in outage
+func (p *Pipeline) drain(batch []string) {
+	for {
+	fill:
+		for len(batch) < p.option.BatchSize {
+			select {
+			case line := <-p.queue:
+				batch = append(batch, line)
+			default:
+				break fill
+			}
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := p.sink.Write(batch); err != nil {
+			atomic.AddInt64(&p.failed, int64(len(batch)+len(p.queue)))
+			return
+		}
+		atomic.AddInt64(&p.written, int64(len(batch)))
+		batch = batch[:0]
+	}
+}
+
+// Close flushes the lines queued and closes the sink
+func (p *Pipeline) Close() {
+	p.once.Do(func() {
+		atomic.StoreInt32(&p.closed, 1)
+		close(p.done)
+		<-p.exit
+		p.sink.Close()
+	})
+}
+
+func (p *Pipeline) Stats() SinkStats {
+	return SinkStats{
+		Type:    p.option.Type,
+		Queued:  len(p.queue),
+		Written: atomic.LoadInt64(&p.written),
+		Dropped: atomic.LoadInt64(&p.dropped),
+		Failed:  atomic.LoadInt64(&p.failed),
+		Retried: atomic.LoadInt64(&p.retried),
+	}
+}
+
+// SinkGroup is the sinks of the vhost, the line is pushed to all of them
+type SinkGroup []*Pipeline
+
+func NewSinkGroup(options []*SinkOption) (SinkGroup, error) {
+	o := SinkGroup{}
+	for _, x := range options {
+		p, err := NewPipeline(x)
+		if err != nil {
+			o.Close()
+			return nil, err
+		}
+		o = append(o, p)
+	}
+	return o, nil
+}
+
+// Upload encodes the log and pushes it to the sinks
+func (g SinkGroup) Upload(l *Log, p Provider) {
+	if len(g) == 0 || l == nil || l.Format == nil {
+		return
+	}
+	line := l.Encode(p)
+	for _, x := range g {
+		x.Push(line)
+	}
+}
+
+func (g SinkGroup) Close() {
+	for _, x := range g {
+		x.Close()
+	}
+}
+
+func (g SinkGroup) Stats() []SinkStats {
+	o := []SinkStats{}
+	for _, x := range g {
+		o = append(o, x.Stats())
+	}
+	return o
+}
@@ -0,0 +1,110 @@
+package alog
+
+import (
+	"fmt"
+	"os"
+)
+
+// file sink, the line is appended to the file, which is rotated by size. The
+// file is opened again once the write fails, ie the directory is recreated
+
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+func newFileSink(option *SinkOption) (Sink, error) {
+	if option.Path == "" {
+		return nil, fmt.Errorf("path is not specified")
+	}
+	s := &fileSink{
+		path:       option.Path,
+		maxSize:    option.MaxSize,
+		maxBackups: option.MaxBackups,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.size = st.Size()
+	return nil
+}
+
+func (s *fileSink) backup(i int) string {
+	return fmt.Sprintf("%s.%d", s.path, i)
+}
+
+// path.1 is the newest backup, the oldest one beyond max_backups is removed
+func (s *fileSink) rotate() error {
+	s.f.Close()
+	s.f = nil
+	if s.maxBackups > 0 {
+		os.Remove(s.backup(s.maxBackups))
+		for i := s.maxBackups - 1; i >= 1; i-- {
+			os.Rename(s.backup(i), s.backup(i+1))
+		}
+		if err := os.Rename(s.path, s.backup(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileSink) Write(lines []string) error {
+	if s.f == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, 0, 4096)
+	for _, x := range lines {
+		buf = append(buf, x...)
+		buf = append(buf, '\n')
+	}
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(buf)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(buf)
+	s.size += int64(n)
+	if err != nil {
+		s.f.Close()
+		s.f = nil
+		return err
+	}
+	return nil
+}
+
+func (s *fileSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+func init() {
+	RegisterSink("file", newFileSink)
+}
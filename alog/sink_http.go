@@ -0,0 +1,79 @@
+package alog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// http sink, the batch is posted to the url as the lines separated by
+// newline, ie NDJSON once the log is encoded as JSON. The reply other than 2xx
+// fails the batch
+
+const (
+	httpSinkTimeout     = 10 * time.Second
+	httpSinkContentType = "text/plain; charset=utf-8"
+)
+
+type httpSink struct {
+	url    string
+	header map[string]string
+	client *http.Client
+}
+
+func newHttpSink(option *SinkOption) (Sink, error) {
+	if option.URL == "" {
+		return nil, fmt.Errorf("url is not specified")
+	}
+	timeout := option.Timeout
+	if timeout <= 0 {
+		timeout = httpSinkTimeout
+	}
+	return &httpSink{
+		url:    option.URL,
+		header: option.Header,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}, nil
+}
+
+func (s *httpSink) Write(lines []string) error {
+	buf := new(bytes.Buffer)
+	for _, x := range lines {
+		buf.WriteString(x)
+		buf.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest("POST", s.url, buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", httpSinkContentType)
+	for k, v := range s.header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s replied status %d", s.url, resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+func init() {
+	RegisterSink("http", newHttpSink)
+}
@@ -0,0 +1,66 @@
+package alog
+
+import (
+	"fmt"
+
+	"github.com/dianpeng/moons/mq"
+)
+
+// mq sink, each line is one message of the topic, the broker is picked by the
+// scheme of the url, ie kafka:// or nats://. The broker publishing the batch
+// in one round trip, ie kafka, gets the batch as whole
+
+type mqSink struct {
+	url    string
+	topic  string
+	option *mq.Option
+	broker mq.Broker
+}
+
+func newMQSink(option *SinkOption) (Sink, error) {
+	if option.URL == "" || option.Topic == "" {
+		return nil, fmt.Errorf("url and topic are required")
+	}
+	return &mqSink{
+		url:    option.URL,
+		topic:  option.Topic,
+		option: option.MQOption,
+	}, nil
+}
+
+func (s *mqSink) Write(lines []string) error {
+	if s.broker == nil {
+		b, err := mq.Dial(s.url, s.option)
+		if err != nil {
+			return err
+		}
+		s.broker = b
+	}
+
+	if b, ok := s.broker.(mq.BatchPublisher); ok {
+		data := make([][]byte, 0, len(lines))
+		for _, x := range lines {
+			data = append(data, []byte(x))
+		}
+		return b.PublishBatch(s.topic, data)
+	}
+	for _, x := range lines {
+		if err := s.broker.Publish(s.topic, []byte(x), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *mqSink) Close() error {
+	if s.broker == nil {
+		return nil
+	}
+	err := s.broker.Close()
+	s.broker = nil
+	return err
+}
+
+func init() {
+	RegisterSink("mq", newMQSink)
+}
@@ -0,0 +1,153 @@
+package alog
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// syslog sink, each line is one message of RFC 5424 in severity info. The
+// stream network, ie tcp and unix, frames the message with newline. Without
+// address, the message is sent to the local syslog, ie /dev/log
+
+const (
+	syslogDialTimeout = 5 * time.Second
+	syslogSeverity    = 6
+)
+
+var syslogFacility = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+var syslogLocal = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+type syslogSink struct {
+	network  string
+	address  string
+	tag      string
+	hostname string
+	priority int
+	stream   bool
+
+	conn net.Conn
+}
+
+func newSyslogSink(option *SinkOption) (Sink, error) {
+	facility := "local0"
+	if option.Facility != "" {
+		facility = option.Facility
+	}
+	f, ok := syslogFacility[facility]
+	if !ok {
+		return nil, fmt.Errorf("facility %s is unknown", facility)
+	}
+
+	s := &syslogSink{
+		network:  option.Network,
+		address:  option.Address,
+		tag:      option.Tag,
+		priority: f*8 + syslogSeverity,
+	}
+	if s.tag == "" {
+		s.tag = "moons"
+	}
+	if s.hostname, _ = os.Hostname(); s.hostname == "" {
+		s.hostname = "-"
+	}
+
+	switch {
+	case s.address == "":
+		s.network = "unixgram"
+	case s.network == "":
+		s.network = "udp"
+	}
+	switch s.network {
+	case "udp", "udp4", "udp6", "unixgram":
+		break
+	case "tcp", "tcp4", "tcp6", "unix":
+		s.stream = true
+	default:
+		return nil, fmt.Errorf("network %s is unknown", s.network)
+	}
+	return s, nil
+}
+
+func (s *syslogSink) dial() (net.Conn, error) {
+	if s.address != "" {
+		return net.DialTimeout(s.network, s.address, syslogDialTimeout)
+	}
+	var err error
+	for _, x := range syslogLocal {
+		var c net.Conn
+		if c, err = net.DialTimeout(s.network, x, syslogDialTimeout); err == nil {
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+func (s *syslogSink) Write(lines []string) error {
+	if s.conn == nil {
+		c, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = c
+	}
+
+	pid := strconv.Itoa(os.Getpid())
+	for _, x := range lines {
+		// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+		msg := fmt.Sprintf("<%d>1 %s %s %s %s - - %s",
+			s.priority,
+			time.Now().Format(time.RFC3339Nano),
+			s.hostname,
+			s.tag,
+			pid,
+			x,
+		)
+		if s.stream {
+			msg += "\n"
+		}
+		if _, err := s.conn.Write([]byte(msg)); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *syslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func init() {
+	RegisterSink("syslog", newSyslogSink)
+}
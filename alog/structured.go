@@ -0,0 +1,290 @@
+package alog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Structured access log. The line of the format is encoded as JSON or logfmt
+// instead of text, the key of each field is the name before "=" right ahead
+// of it, ie "method=%REQ(:METHOD)% status=%RESPONSE_CODE%", otherwise it is
+// derived from the field, ie req_method for %REQ(:METHOD)%. The other text of
+// the format is dropped. The custom fields of the log and its appendix follow
+// the fields of the format, JSON has the attempts of the upstream calls and
+// the diagnostic lines too, logfmt has the line as the pair of its level.
+// The field missing is null in JSON and empty in logfmt.
+
+const (
+	EncodingText   = "text"
+	EncodingJSON   = "json"
+	EncodingLogfmt = "logfmt"
+)
+
+// Field is a custom field of the log, the value is one of nil, bool, int64,
+// float64 and string
+type Field struct {
+	Name  string
+	Value interface{}
+}
+
+// SetField sets the custom field, the field of the name set before is replaced
+// in place
+func (l *Log) SetField(name string, value interface{}) {
+	for i := range l.Fields {
+		if l.Fields[i].Name == name {
+			l.Fields[i].Value = value
+			return
+		}
+	}
+	l.Fields = append(l.Fields, Field{
+		Name:  name,
+		Value: value,
+	})
+}
+
+// key of the field, prefix is the text of the format ahead of the field
+func fieldKey(prefix string, name string, param string) string {
+	if strings.HasSuffix(prefix, "=") {
+		prefix = prefix[:len(prefix)-1]
+		idx := strings.LastIndexAny(prefix, " \t,;|")
+		if key := prefix[idx+1:]; key != "" {
+			return key
+		}
+	}
+
+	key := strings.ToLower(name)
+	if param != "" {
+		if q := strings.Index(param, "?"); q != -1 {
+			param = param[:q]
+		}
+		param = strings.ToLower(strings.TrimPrefix(param, ":"))
+		if name != "START_TIME" {
+			key += "_" + param
+		}
+	}
+	return key
+}
+
+func intValue(vv func() (int64, bool)) (interface{}, bool) {
+	if v, ok := vv(); ok {
+		return v, true
+	}
+	return nil, false
+}
+
+func strValue(vv func() (string, bool)) (interface{}, bool) {
+	if v, ok := vv(); ok {
+		return v, true
+	}
+	return nil, false
+}
+
+func fieldValue(vv func(FormatParam) (string, bool), par FormatParam) (interface{}, bool) {
+	if v, ok := vv(par); ok {
+		return v, true
+	}
+	return nil, false
+}
+
+// value of the field, either int64 or string
+func (bc *bytecode) value(p Provider) (interface{}, bool) {
+	switch bc.op {
+	case fStartTime:
+		f := bc.param.(string)
+		if f == "" {
+			f = time.RFC3339
+		}
+		return p.FormatStartTime(f), true
+	case fReqHeaderBytes:
+		return intValue(p.ReqHeaderBytes)
+	case fBytesReceived:
+		return intValue(p.BytesReceived)
+	case fResponseHeadersBytes:
+		return intValue(p.ResponseHeadersBytes)
+	case fResponseTrailersBytes:
+		return intValue(p.ResponseTrailersBytes)
+	case fDuration:
+		return intValue(p.Duration)
+	case fBytesSent:
+		return intValue(p.BytesSent)
+	case fRequestDuration:
+		return intValue(p.RequestDuration)
+	case fResponseDuration:
+		return intValue(p.ResponseDuration)
+	case fConnectionTerminationDetails:
+		return strValue(p.ConnectionTerminationDetails)
+	case fConnectionId:
+		return strValue(p.ConnectionId)
+	case fVirtualHost:
+		return strValue(p.VirtualHost)
+	case fRouterInfo:
+		return fieldValue(p.RouterInfo, bc.param.(FormatParam))
+	case fReq:
+		return fieldValue(p.Req, bc.param.(FormatParam))
+	case fResp:
+		return fieldValue(p.Resp, bc.param.(FormatParam))
+	case fURI:
+		return fieldValue(p.URI, bc.param.(FormatParam))
+	case fTrailer:
+		return fieldValue(p.Trailer, bc.param.(FormatParam))
+	case fResponseCode:
+		return intValue(p.ResponseCode)
+	case fResponseCodeDetail:
+		return strValue(p.ResponseCodeDetail)
+	case fHost:
+		return strValue(p.Host)
+	case fRequestMiddleware:
+		return fieldValue(p.RequestMiddleware, bc.param.(FormatParam))
+	case fResponseMiddleware:
+		return fieldValue(p.ResponseMiddleware, bc.param.(FormatParam))
+	case fApplicationMiddleware:
+		return fieldValue(p.ApplicationMiddleware, bc.param.(FormatParam))
+	case fServiceName:
+		return strValue(p.ServiceName)
+	case fClientIp:
+		return strValue(p.ClientIp)
+	case fProtocol:
+		return strValue(p.Protocol)
+	case fScheme:
+		return strValue(p.Scheme)
+	case fRequestId:
+		return strValue(p.RequestId)
+	default:
+		return nil, false
+	}
+}
+
+// the fields of the log in order, the duplicated key is kept once with the
+// last value
+func (l *Log) fields(p Provider) ([]string, map[string]interface{}) {
+	keys := []string{}
+	values := map[string]interface{}{}
+	add := func(k string, v interface{}) {
+		if _, ok := values[k]; !ok {
+			keys = append(keys, k)
+		}
+		values[k] = v
+	}
+	for i := range l.Format.bc {
+		bc := &l.Format.bc[i]
+		if bc.key == "" {
+			continue
+		}
+		v, _ := bc.value(p)
+		add(bc.key, v)
+	}
+	for _, f := range l.Fields {
+		add(f.Name, f.Value)
+	}
+	return keys, values
+}
+
+func (l *Log) ToJSON(p Provider) string {
+	keys, values := l.fields(p)
+	if len(l.Appendix) != 0 {
+		keys = append(keys, "appendix")
+		values["appendix"] = l.Appendix
+	}
+	if len(l.Attempts) != 0 {
+		attempts := []interface{}{}
+		for _, a := range l.Attempts {
+			attempts = append(attempts, map[string]interface{}{
+				"upstream": a.Upstream,
+				"status":   a.Status,
+				"error":    a.Error,
+				"duration": a.Duration.Milliseconds(),
+			})
+		}
+		keys = append(keys, "attempts")
+		values["attempts"] = attempts
+	}
+	if len(l.Messages) != 0 {
+		messages := []interface{}{}
+		for _, m := range l.Messages {
+			messages = append(messages, map[string]interface{}{
+				"level":   LevelName(m.Level),
+				"message": m.Text,
+			})
+		}
+		keys = append(keys, "messages")
+		values["messages"] = messages
+	}
+
+	// encoding/json sorts the keys of map, so the object is written by hand
+	// to keep the order of the format
+	buf := new(bytes.Buffer)
+	buf.WriteByte('{')
+	for i, k := range keys {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		buf.Write(key)
+		buf.WriteByte(':')
+		val, err := json.Marshal(values[k])
+		if err != nil {
+			val, _ = json.Marshal(fmt.Sprintf("%v", values[k]))
+		}
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+func logfmtValue(v interface{}) string {
+	var str string
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		str = x
+	case float64:
+		str = strconv.FormatFloat(x, 'g', -1, 64)
+	default:
+		str = fmt.Sprintf("%v", x)
+	}
+	if str == "" || strings.ContainsAny(str, " =\"\t\r\n") {
+		return strconv.Quote(str)
+	}
+	return str
+}
+
+func (l *Log) ToLogfmt(p Provider) string {
+	keys, values := l.fields(p)
+	buf := new(bytes.Buffer)
+	for i, k := range keys {
+		if i != 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(k)
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(values[k]))
+	}
+	for _, a := range l.Appendix {
+		buf.WriteByte(' ')
+		buf.WriteString(a)
+	}
+	for _, m := range l.Messages {
+		buf.WriteByte(' ')
+		buf.WriteString(LevelName(m.Level))
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(m.Text))
+	}
+	return buf.String()
+}
+
+// Encode writes the line of the log in the encoding of its format
+func (l *Log) Encode(p Provider) string {
+	switch l.Format.Encoding {
+	case EncodingJSON:
+		return l.ToJSON(p)
+	case EncodingLogfmt:
+		return l.ToLogfmt(p)
+	default:
+		return l.ToText(p, "-", " ")
+	}
+}
@@ -313,6 +313,15 @@ func (t *totext) toText(
 			)
 			break
 
+		case fRequestId:
+			buf.WriteString(
+				t.fmtStr(
+					provider.RequestId,
+					ep,
+				),
+			)
+			break
+
 		default:
 			panic("should not reach here")
 			break
@@ -0,0 +1,25 @@
+fn testConfig() {
+  breaker::configure("test.breaker:8080", {"failure_rate": 0.5, "min_request": 4, "window": 1000, "consecutive_failure": 3, "open_timeout": 1000});
+  assert::eq(breaker::state("test.breaker:8080"), "closed");
+
+  // no breaker is created until the host is called
+  assert::eq(breaker::stats("test.breaker:8080"), null);
+  breaker::reset("test.breaker:8080");
+}
+
+fn testInvalidOption() {
+  assert::eq((try breaker::configure("test.breaker", {"failure_rate": 2}) else "bad"), "bad");
+  assert::eq((try breaker::configure("test.breaker", {"unknown": 1}) else "bad"), "bad");
+  assert::eq((try breaker::configure("test.breaker", {"window": -1}) else "bad"), "bad");
+}
+
+fn testUnknownHost() {
+  assert::eq(breaker::state("test.unknown"), "closed");
+  assert::eq(breaker::stats("test.unknown"), null);
+}
+
+test {
+  testConfig();
+  testInvalidOption();
+  testUnknownHost();
+}
@@ -0,0 +1,57 @@
+fn testStore() {
+  let c = cache::new({"max_entries": 2}, "test_cache");
+  c:set("a", {"x": [1, 2], "y": "z"});
+  c["b"] = 10;
+  assert::eq(c:get("a").x[1], 2);
+  assert::eq(c:get("a").y, "z");
+  assert::eq(c["b"], 10);
+  assert::yes(c:has("b"));
+  assert::eq(c.length, 2);
+
+  // least recently used entry is evicted
+  c:get("a");
+  c:set("c", true);
+  assert::eq(c:get("b"), null);
+  assert::eq(c.length, 2);
+
+  c:del("a");
+  assert::no(c:has("a"));
+
+  let o = cache::open("test_cache");
+  assert::eq(o:get("c"), true);
+  o:purge();
+  assert::eq(c.length, 0);
+}
+
+fn testControl() {
+  let cc = cache::control('max-age=60, no-transform, Private="set-cookie"');
+  assert::eq(cc["max-age"], "60");
+  assert::eq(cc["no-transform"], true);
+  assert::eq(cc["private"], "set-cookie");
+
+  assert::eq(cache::freshness({"cache-control": "max-age=10, s-maxage=30"}), 30);
+  assert::eq(cache::freshness({"cache-control": "max-age=10"}), 10);
+  assert::eq(cache::freshness({"content-type": "text/plain"}), -1);
+}
+
+fn testHttp() {
+  let req = http::new_request("GET", "http://example.com/a?b=1");
+  req.header:set("if-none-match", 'W/"v1"');
+  req.header:set("accept-language", "en");
+  assert::eq(cache::key(req), "http://example.com/a?b=1");
+  assert::eq(cache::key(req, {"vary": "accept-language"}), "http://example.com/a?b=1\nAccept-Language:en");
+
+  assert::yes(cache::not_modified(req, {"etag": '"v1"'}));
+  assert::no(cache::not_modified(req, {"etag": '"v2"'}));
+
+  assert::yes(cache::storable(req, 200, {"cache-control": "max-age=10"}));
+  assert::no(cache::storable(req, 200, {"cache-control": "private"}));
+  assert::no(cache::storable(req, 500, {"cache-control": "max-age=10"}));
+  assert::no(cache::storable(req, 200, {"set-cookie": "a=b"}));
+}
+
+test {
+  testStore();
+  testControl();
+  testHttp();
+}
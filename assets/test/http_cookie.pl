@@ -0,0 +1,46 @@
+fn testCookie() {
+  let c = http::new_cookie("sid", "abc");
+  c.path = "/";
+  c.httpOnly = true;
+  c.sameSite = "lax";
+  assert::eq(c:string(), "sid=abc; Path=/; HttpOnly; SameSite=Lax");
+  assert::throw(fn() { c.sameSite = "bad"; });
+
+  let s = http::parse_set_cookie("a=b; Domain=example.com; Secure");
+  assert::eq(s.name, "a");
+  assert::eq(s.value, "b");
+  assert::eq(s.domain, "example.com");
+  assert::yes(s.secure);
+
+  let l = http::parse_cookie("x=1; y=2");
+  assert::eq(l:length(), 2);
+  assert::eq(l[1].name, "y");
+  assert::eq(l[1].value, "2");
+}
+
+fn testRequestCookie() {
+  let req = http::new_request("GET", "http://example.com/");
+  req:addCookie(http::new_cookie("a", "1"));
+  req:addCookie(http::new_cookie("b", "2"));
+  assert::eq(req.header["Cookie"], "a=1; b=2");
+  assert::eq(req:cookies()[0].value, "1");
+
+  let resp = http::new_response(200);
+  resp:setCookie(http::new_cookie("c", "3"));
+  assert::eq(resp.header["Set-Cookie"], "c=3");
+  assert::eq(resp:cookies()[0].name, "c");
+}
+
+fn testCookieJar() {
+  let jar = http::new_cookie_jar();
+  jar:setCookies("http://example.com/", http::new_cookie("a", "1"));
+  jar:setCookies("http://example.com/", [http::new_cookie("b", "2")]);
+  assert::eq(jar:cookies("http://example.com/"):length(), 2);
+  assert::eq(jar:cookies("http://other.com/"):length(), 0);
+}
+
+test {
+  testCookie();
+  testRequestCookie();
+  testCookieJar();
+}
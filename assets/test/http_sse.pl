@@ -0,0 +1,35 @@
+fn testReader() {
+  let r = http::new_sse_reader(": comment\nretry: 2000\nid: 1\nevent: tick\ndata: a\ndata:b\n\ndata: c\r\n\r\nevent: empty\n\ndata: d");
+
+  let e = r:next();
+  assert::eq(e.id, "1");
+  assert::eq(e.event, "tick");
+  assert::eq(e.data, "a\nb");
+  assert::eq(e.retry, 2000);
+
+  e = r:next();
+  assert::eq(e.id, "1");
+  assert::eq(e.event, "message");
+  assert::eq(e.data, "c");
+
+  // event without data is skipped and the incomplete event is discarded
+  assert::eq(r:next(), null);
+  assert::yes(r.eof);
+  assert::eq(r.lastEventId, "1");
+}
+
+fn testReaderIter() {
+  let body = http::new_response(200, {}, "data: 1\n\ndata: 2\n\ndata: 3\n\n").body;
+  let r = http::new_sse_reader(body);
+  let l = [];
+  for let i, e = r {
+    l:push_back(e.data);
+  }
+  assert::eq(l:length(), 3);
+  assert::eq(l[2], "3");
+}
+
+test {
+  testReader();
+  testReaderIter();
+}
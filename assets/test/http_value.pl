@@ -0,0 +1,64 @@
+fn testRequest() {
+  let req = http::new_request("POST", "http://example.com/a?x=1", "hello");
+  req.header:add("X-Multi", "a");
+  req.header:add("X-Multi", "b");
+  assert::eq(req.header:values("X-Multi"), ["a", "b"]);
+  assert::eq(req.header:values("X-None"), []);
+
+  req.method = "PUT";
+  req.host = "example.org";
+  assert::eq(req.method, "PUT");
+  assert::eq(req.host, "example.org");
+  assert::throw(fn() { req.method = "BAD METHOD"; });
+
+  req.trailer:set("X-Checksum", "1234");
+  assert::eq(req.trailer["X-Checksum"], "1234");
+}
+
+fn testRequestClone() {
+  let req = http::new_request("POST", "http://example.com/a", "hello");
+  let c = req:clone();
+  c.header:set("X-Clone", "yes");
+  c.body = "world";
+
+  assert::eq(req.header["X-Clone"], "");
+  assert::eq(c.header["X-Clone"], "yes");
+  assert::eq(req.body:string(), "hello");
+  assert::eq(c.body:string(), "world");
+
+  let c2 = req:clone();
+  assert::eq(c2.method, "POST");
+}
+
+fn testResponse() {
+  let resp = http::new_response(201, {"X-A": "b"}, "body");
+  assert::eq(resp.status, 201);
+  assert::eq(resp.statusText, "201 Created");
+  assert::eq(resp.header["X-A"], "b");
+  assert::eq(resp.contentLength, 4);
+
+  let c = resp:clone();
+  c.status = 404;
+  assert::eq(c.statusText, "404 Not Found");
+  assert::eq(resp.status, 201);
+  assert::eq(c.body:string(), "body");
+  assert::eq(resp.body:string(), "body");
+
+  resp.body = "new";
+  assert::eq(resp.body:string(), "new");
+  assert::throw(fn() { resp.status = 20; });
+}
+
+fn testClientOption() {
+  let req = http::new_request("GET", "http://127.0.0.1:1/");
+  assert::throw(fn() { http::do(req, {"bogus": 1}); });
+  assert::throw(fn() { http::do(req, {"timeout": "1s"}); });
+  assert::throw(fn() { http::do(req, {"cert_file": "a.pem"}); });
+}
+
+test {
+  testRequest();
+  testClientOption();
+  testRequestClone();
+  testResponse();
+}
@@ -0,0 +1,45 @@
+fn testBasic() {
+  kv::set("a", {"x": [1, 2], "y": "z"});
+  assert::eq(kv::get("a").x[1], 2);
+  assert::eq(kv::get("missing"), null);
+  assert::eq(kv::get("missing", 10), 10);
+  assert::yes(kv::has("a"));
+
+  // value is copied into the store
+  let v = kv::get("a");
+  v.y = "w";
+  assert::eq(kv::get("a").y, "z");
+
+  assert::yes(kv::del("a"));
+  assert::no(kv::del("a"));
+  assert::no(kv::has("a"));
+}
+
+fn testCounter() {
+  assert::eq(kv::incr("hits"), 1);
+  assert::eq(kv::incr("hits", 10), 11);
+  assert::eq(kv::incr("hits", -1), 10);
+  assert::eq(kv::ttl("hits"), -1);
+  assert::eq(kv::ttl("nope"), -2);
+
+  kv::set("name", "str");
+  assert::eq(try kv::incr("name") else "error", "error");
+}
+
+fn testDedup() {
+  assert::yes(kv::add("seen:1", true, 60000));
+  assert::no(kv::add("seen:1", true, 60000));
+  assert::yes(kv::add("seen:2", true));
+  let ttl = kv::ttl("seen:1");
+  assert::yes(ttl > 0 && ttl <= 60000);
+  assert::yes(kv::expire("seen:2", 1000));
+  assert::yes(kv::ttl("seen:2") > 0);
+  assert::no(kv::expire("seen:3", 1000));
+  assert::eq(kv::keys("seen:"), ["seen:1", "seen:2"]);
+}
+
+test {
+  testBasic();
+  testCounter();
+  testDedup();
+}
@@ -0,0 +1,31 @@
+fn testRoundTrip() {
+  let data = str::repeat("hello world ", 100);
+  assert::eq(decompress::gzip(compress::gzip(data)), data);
+  assert::eq(decompress::gzip(compress::gzip(data, 9)), data);
+  assert::eq(decompress::deflate(compress::deflate(data)), data);
+  assert::yes(len(compress::gzip(data)) < len(data));
+  assert::eq(decompress::codec("gzip", compress::codec("gzip", data, 1)), data);
+  assert::eq(decompress::gzip(compress::gzip("")), "");
+  assert::eq(decompress::gzip(compress::gzip(data), 1200), data);
+  assert::eq(decompress::codec("deflate", compress::deflate(data), 1200), data);
+}
+
+fn testList() {
+  let l = compress::list();
+  assert::yes(l:contains("gzip"));
+  assert::yes(l:contains("deflate"));
+}
+
+fn testError() {
+  assert::throw(fn() { decompress::gzip("not gzip"); });
+  assert::throw(fn() { compress::codec("nope", "data"); });
+  assert::throw(fn() { compress::gzip("data", 100); });
+  assert::throw(fn() { decompress::gzip(compress::gzip(str::repeat("a", 100)), 99); });
+  assert::throw(fn() { decompress::codec("gzip", compress::gzip(str::repeat("a", 100)), 10); });
+}
+
+test {
+  testRoundTrip();
+  testList();
+  testError();
+}
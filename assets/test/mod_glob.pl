@@ -0,0 +1,36 @@
+fn testStar() {
+  let g = glob::compile("/api/*/users");
+  assert::yes(g:match("/api/v1/users"));
+  assert::no(g:match("/api/v1/x/users"));
+  assert::no(g:match("/api/v1/user"));
+  assert::eq(g:pattern(), "/api/*/users");
+}
+
+fn testDoubleStar() {
+  let g = glob::compile("/static/**.css");
+  assert::yes(g:match("/static/a.css"));
+  assert::yes(g:match("/static/a/b/c.css"));
+  assert::no(g:match("/static/a/b/c.js"));
+}
+
+fn testClass() {
+  let g = glob::compile("file[0-9][!a].t?t");
+  assert::yes(g:match("file1b.txt"));
+  assert::no(g:match("file1a.txt"));
+  assert::no(g:match("filex.txt"));
+  assert::yes(glob::match("*.example.com", "www.example.com"));
+  assert::no(glob::match("*.example.com", "example.com"));
+  assert::yes(glob::match("\\*", "*"));
+}
+
+fn testError() {
+  assert::throw(fn() { glob::compile("[a-"); });
+  assert::throw(fn() { glob::compile("abc\\"); });
+}
+
+test {
+  testStar();
+  testDoubleStar();
+  testClass();
+  testError();
+}
@@ -0,0 +1,48 @@
+fn testRound() {
+  assert::eq(math::floor(1.7), 1.0);
+  assert::eq(math::ceil(1.2), 2.0);
+  assert::eq(math::round(2.5), 3.0);
+  assert::eq(math::round(-2.5), -3.0);
+  assert::eq(math::trunc(-2.7), -2.0);
+  assert::eq(math::floor(3), 3.0);
+}
+
+fn testAbs() {
+  assert::eq(math::abs(-3), 3);
+  assert::eq(math::abs(-3.5), 3.5);
+}
+
+fn testFunc() {
+  assert::eq(math::sqrt(16), 4.0);
+  assert::eq(math::exp(0), 1.0);
+  assert::eq(math::log(1), 0.0);
+  assert::eq(math::log2(8), 3.0);
+  assert::eq(math::log10(1000), 3.0);
+  assert::eq(math::sin(0), 0.0);
+  assert::eq(math::cos(0), 1.0);
+  assert::yes(math::pi() > 3.14);
+}
+
+fn testClamp() {
+  assert::eq(math::clamp(10, 0, 5), 5);
+  assert::eq(math::clamp(-1, 0, 5), 0);
+  assert::eq(math::clamp(3, 0, 5), 3);
+  assert::eq(math::clamp(0.5, 1, 2), 1.0);
+  assert::throw(fn() { math::clamp(1, 5, 0); });
+}
+
+fn testStat() {
+  assert::eq(math::sum([1, 2, 3.5]), 6.5);
+  assert::eq(math::mean([1, 2, 3, 4]), 2.5);
+  assert::eq(math::stddev([2, 4, 4, 4, 5, 5, 7, 9]), 2.0);
+  assert::throw(fn() { math::mean([]); });
+  assert::throw(fn() { math::mean([1, "a"]); });
+}
+
+test {
+  testRound();
+  testAbs();
+  testFunc();
+  testClamp();
+  testStat();
+}
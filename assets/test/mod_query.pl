@@ -0,0 +1,286 @@
+fn testSort() {
+  assert::eq([3, 1, 2] | q::sort, [1, 2, 3]);
+  assert::eq([] | q::sort, []);
+  assert::eq(["b", "a", "c"] | q::sort, ["a", "b", "c"]);
+  assert::eq([2.5, 1, 2] | q::sort, [1, 2, 2.5]);
+  assert::eq([1.0, 1] | q::sort, [1, 1.0]);
+  assert::eq(["a", 1, null, true, false] | q::sort, [null, false, true, 1, "a"]);
+
+  // input is not modified
+  let l = [2, 1];
+  let _ = l | q::sort;
+  assert::eq(l, [2, 1]);
+}
+
+fn testSortBy() {
+  assert::eq([1, 3, 2] | q::sort_by(fn(a, b): a > b), [3, 2, 1]);
+  assert::eq([1, 3, 2] | q::sort_by(fn(a, b): b - a), [3, 2, 1]);
+
+  // stable
+  assert::eq(
+    [["b", 1], ["a", 2], ["c", 1]] | q::sort_by(fn(a, b): a[1] < b[1]),
+    [["b", 1], ["c", 1], ["a", 2]]
+  );
+
+  assert::throw(fn() {
+    let _ = [1, 2] | q::sort_by(fn(a, b): "x");
+  });
+}
+
+fn testGroupBy() {
+  let l = [1, 2, 3, 4, 5];
+  assert::eq(
+    l | q::group_by(fn(k, v): "even" if v % 2 == 0 else "odd"),
+    {"odd": [1, 3, 5], "even": [2, 4]}
+  );
+  assert::eq(
+    l | q::count_by(fn(k, v): "even" if v % 2 == 0 else "odd"),
+    {"odd": 3, "even": 2}
+  );
+  assert::eq(
+    {"a": 1, "b": 2, "c": 1} | q::count_by(fn(k, v): v),
+    {"1": 2, "2": 1}
+  );
+  assert::eq([] | q::group_by(fn(k, v): v), {});
+  assert::eq([] | q::count_by(fn(k, v): v), {});
+  assert::throw(fn() {
+    let _ = [1] | q::group_by(fn(k, v): [v]);
+  });
+}
+
+fn testStructural() {
+  assert::eq(q::zip([1, 2, 3], ["a", "b"]), [[1, "a"], [2, "b"]]);
+  assert::eq(q::zip([1], null), []);
+  assert::eq(q::zip(), []);
+  assert::throw(fn() {
+    let _ = q::zip([1], 1);
+  });
+
+  assert::eq([1, [2, [3]], []] | q::flatten, [1, 2, [3]]);
+  assert::eq([1, [2, [3, [4]]]] | q::flatten(-1), [1, 2, 3, 4]);
+  assert::eq(null | q::flatten, []);
+
+  // the list reached twice is fine, the one containing itself is not unless
+  // the depth is limited
+  let shared = [1];
+  assert::eq([shared, [shared]] | q::flatten(-1), [1, 1]);
+  assert::throw(fn() {
+    let self = [1];
+    self:push_back(self);
+    let _ = self | q::flatten(-1);
+  });
+  assert::throw(fn() {
+    let self = [1];
+    self:push_back(self);
+    let _ = [[self]] | q::flatten(-1);
+  });
+  let cyclic = [1];
+  cyclic:push_back(cyclic);
+  assert::eq((cyclic | q::flatten(2)):length(), 4);
+
+  assert::eq([1, 2, 1, "1", 2.0, 2] | q::unique, [1, 2, "1", 2.0]);
+  assert::eq([[1], [1], [2]] | q::unique, [[1], [2]]);
+  assert::eq(null | q::unique, []);
+
+  assert::eq([1, 2, 3] | q::reverse, [3, 2, 1]);
+  assert::eq([] | q::reverse, []);
+
+  assert::eq([1, 2, 3, 4, 5] | q::chunk(2), [[1, 2], [3, 4], [5]]);
+  assert::eq([] | q::chunk(2), []);
+  assert::throw(fn() {
+    let _ = [1] | q::chunk(0);
+  });
+}
+
+fn testJoin() {
+  let users = [{"id": 1, "name": "a"}, {"id": 2, "name": "b"}, {"id": 3, "name": "c"}];
+  let orders = [{"uid": 1, "sku": "x"}, {"uid": 1, "sku": "y"}, {"uid": 4, "sku": "z"}];
+  let lk = fn(k, v): v.id;
+  let rk = fn(k, v): v.uid;
+
+  let inner = q::join(users, orders, lk, rk);
+  assert::eq(inner:length(), 2);
+  assert::eq(inner[0].first.name, "a");
+  assert::eq(inner[0].second.sku, "x");
+  assert::eq(inner[1].second.sku, "y");
+
+  let left = q::join(users, orders, lk, rk, "left");
+  assert::eq(left:length(), 4);
+  assert::eq(left[2].first.name, "b");
+  assert::eq(left[2].second, null);
+
+  let outer = q::join(users, orders, lk, rk, "outer");
+  assert::eq(outer:length(), 5);
+  assert::eq(outer[4].first, null);
+  assert::eq(outer[4].second.sku, "z");
+
+  assert::eq(q::join([], orders, lk, rk), []);
+  assert::throw(fn() {
+    let _ = q::join(users, orders, lk, rk, "cross");
+  });
+}
+
+fn testOrderStat() {
+  let l = [5, 1, 4, 2, 3, "x"];
+  assert::eq(l | q::top_k(2), [5, 4]);
+  assert::eq(l | q::top_k(10), [5, 4, 3, 2, 1]);
+  assert::eq(l | q::top_k(0), []);
+  assert::eq([1.5, 3, 2] | q::top_k(2), [3, 2]);
+
+  assert::eq(l | q::median, 3.0);
+  assert::eq([1, 2, 3, 4] | q::median, 2.5);
+  assert::eq([] | q::median, null);
+
+  assert::eq([1, 2, 3, 4, 5] | q::percentile(0), 1.0);
+  assert::eq([1, 2, 3, 4, 5] | q::percentile(100), 5.0);
+  assert::eq([10, 20, 30, 40] | q::percentile(50), 25.0);
+  assert::eq([5, 1, 3, 2, 4, 10, 9, 8, 7, 6] | q::percentile(90), 9.1);
+  assert::throw(fn() {
+    let _ = [1] | q::percentile(101);
+  });
+
+  assert::eq([2, 4, 4, 4, 5, 5, 7, 9] | q::stddev, 2.0);
+  assert::eq([] | q::stddev, null);
+
+  // unlike math::stddev, the non number elements are skipped
+  assert::eq([2, "x", 4, 4, 4, 5, null, 5, 7, 9] | q::stddev, math::stddev([2, 4, 4, 4, 5, 5, 7, 9]));
+  assert::eq(["x"] | q::stddev, null);
+  assert::throw(fn() {
+    let _ = math::stddev([2, "x"]);
+  });
+}
+
+fn pipelineThrow(out) {
+  for let k, v = q::from([1, 2]):map(fn(k, v): v + {}) {
+    out:push_back(v);
+  }
+}
+
+fn testPipeline() {
+  // infinite source, only pulled on demand
+  let p = q::from(iter() {
+    for let i = 0; ; i++ {
+      yield (i, i);
+    }
+  });
+  assert::eq(
+    p:filter(fn(k, v): v % 2 == 0):map(fn(k, v): v * 10):skip(1):take(3):to_list(),
+    [20, 40, 60]
+  );
+
+  // map callback is not invoked more than needed
+  let calls = [];
+  let l = q::from([1, 2, 3, 4]):map(fn(k, v) {
+    calls:push_back(v);
+    return v;
+  }):take(2):to_list();
+  assert::eq(l, [1, 2]);
+  assert::eq(calls, [1, 2]);
+
+  // pipeline is iterable and each stage returns a new pipeline
+  let base = q::from([1, 2, 3, 4]);
+  let out = [];
+  for let k, v = base:map(fn(k, v): v + 1):filter(fn(k, v): v > 2) {
+    out:push_back(v);
+  }
+  assert::eq(out, [3, 4, 5]);
+  assert::eq(base:to_list(), [1, 2, 3, 4]);
+
+  let sum = 0;
+  for let k, v = q::from({"a": 1, "b": 2}):map(fn(k, v): v * 2) {
+    sum += v;
+  }
+  assert::eq(sum, 6);
+
+  assert::eq(q::from([]):take(1):to_list(), []);
+  assert::eq(q::from([1]):take(0):to_list(), []);
+  assert::throw(fn() { pipelineThrow(out); });
+  assert::throw(fn() { let _ = q::from(1); });
+  assert::throw(fn() { let _ = q::from([1]):filter(fn(k, v): 1):to_list(); });
+}
+
+fn pmapMutate() {
+  let cnt = 0;
+  return [1, 2] | q::pmap(fn(k, v) {
+    cnt = cnt + 1;
+    return v;
+  });
+}
+
+fn testPMap() {
+  let l = [1, 2, 3, 4, 5, 6, 7, 8, 9, 10];
+  assert::eq(l | q::pmap(fn(k, v): v * v), [1, 4, 9, 16, 25, 36, 49, 64, 81, 100]);
+  assert::eq(l | q::pmap(fn(k, v): k, {"workers": 3}), [0, 1, 2, 3, 4, 5, 6, 7, 8, 9]);
+  assert::eq([] | q::pmap(fn(k, v): v), []);
+
+  // thread safe capture is allowed
+  let base = 10;
+  assert::eq([1, 2] | q::pmap(fn(k, v): v + base), [11, 12]);
+
+  // mutable capture is rejected up front
+  let acc = [];
+  assert::throw(fn() {
+    let _ = [1, 2] | q::pmap(fn(k, v): acc:push_back(v));
+  });
+  assert::eq(acc, []);
+  assert::throw(fn() { pmapMutate(); });
+  assert::throw(fn() {
+    let _ = [1] | q::pmap(fn(k, v): v, {"workers": 0});
+  });
+  assert::throw(fn() {
+    let _ = [1, 2] | q::pmap(fn(k, v): v + {});
+  });
+}
+
+fn testPath() {
+  let v = {
+    "a": {"b": [1, 2, {"c": "x"}]},
+    "users": [{"id": 1, "name": "a"}, {"id": 2}, {"name": "c"}],
+    "m": {"k1": {"id": 10}, "k2": {"id": 20}},
+    "dot.key": 1
+  };
+
+  assert::eq(q::path(v, "a.b[2].c"), "x");
+  assert::eq(q::path(v, "a.b[-1].c"), "x");
+  assert::eq(q::path(v, "a.b[0]"), 1);
+  assert::eq(q::path(v, "a.b[10].c"), null);
+  assert::eq(q::path(v, "a.x.y.z"), null);
+  assert::eq(q::path(v, "a.x.y.z", "def"), "def");
+  assert::eq(q::path(v, "a.b.c"), null);
+  assert::eq(q::path(v, "['dot.key']"), 1);
+  assert::eq(q::path(v, ""), v);
+
+  // wildcard always returns list
+  assert::eq(q::path(v, "users.*.id"), [1, 2]);
+  assert::eq(q::path(v, "users[*].name"), ["a", "c"]);
+  assert::eq(q::path(v, "m.*.id"), [10, 20]);
+  assert::eq(q::path(v, "missing.*"), []);
+
+  assert::throw(fn() { return q::path(v, "a..b"); });
+  assert::throw(fn() { return q::path(v, "a[x]"); });
+  assert::throw(fn() { return q::path(v, "a[0"); });
+
+  // set
+  assert::eq(q::path_set(v, "a.b[0]", 100), 1);
+  assert::eq(q::path(v, "a.b[0]"), 100);
+  assert::eq(q::path_set(v, "x.y.z", true), 1);
+  assert::eq(q::path(v, "x.y.z"), true);
+  assert::eq(q::path_set(v, "users.*.active", false), 3);
+  assert::eq(q::path(v, "users[*].active"), [false, false, false]);
+  assert::eq(q::path_set(v, "a.b[2].c", "y"), 1);
+  assert::eq(q::path(v, "a.b[2].c"), "y");
+  assert::throw(fn() { return q::path_set(v, "a.b[9]", 1); });
+  assert::throw(fn() { return q::path_set(v, "", 1); });
+}
+
+test {
+  testSort();
+  testSortBy();
+  testGroupBy();
+  testStructural();
+  testJoin();
+  testOrderStat();
+  testPipeline();
+  testPMap();
+  testPath();
+}
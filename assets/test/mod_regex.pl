@@ -0,0 +1,41 @@
+fn testSubmatch() {
+  assert::eq(regexp::find_submatch(r"([a-z]+)@([a-z]+)", "mail: foo@bar"), ["foo@bar", "foo", "bar"]);
+  assert::eq(regexp::find_submatch(r"([0-9]+)", "abc"), null);
+  assert::eq(
+    regexp::find_all_submatch(r"([a-z])=([0-9])", "a=1,b=2", -1),
+    [["a=1", "a", "1"], ["b=2", "b", "2"]]
+  );
+}
+
+fn testNamed() {
+  let m = regexp::find_named(r"(?P<user>[a-z]+)@(?P<host>[a-z]+)", "foo@bar");
+  assert::eq(m["user"], "foo");
+  assert::eq(m["host"], "bar");
+  assert::eq(regexp::find_named(r"(?P<user>[0-9]+)", "abc"), null);
+}
+
+fn testSplit() {
+  assert::eq(regexp::split(r" *, *", "a , b,c", -1), ["a", "b", "c"]);
+  assert::eq(regexp::split(r",", "a,b,c", 2), ["a", "b,c"]);
+}
+
+fn testReplaceFunc() {
+  assert::eq(
+    regexp::replace_func(r"[0-9]+", "a1b22c", fn(m, g) { return str::repeat("x", str::rune_length(m)); }),
+    "axbxxc"
+  );
+  assert::eq(
+    regexp::replace_func(r"([a-z]+)=([a-z]+)", "a=b&c=d", fn(m, g) { return g[2] + "=" + g[1]; }),
+    "b=a&d=c"
+  );
+  assert::throw(fn() {
+    regexp::replace_func(r"a", "a", fn(m, g) { assert::pass(); });
+  });
+}
+
+test {
+  testSubmatch();
+  testNamed();
+  testSplit();
+  testReplaceFunc();
+}
@@ -0,0 +1,108 @@
+fn testBasic() {
+  let s = schema::compile({
+    "type": "object",
+    "required": ["id", "name"],
+    "properties": {
+      "id": {"type": "integer", "minimum": 1},
+      "name": {"type": "string", "minLength": 1, "maxLength": 8, "pattern": "^[a-z]+$"},
+      "tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true, "maxItems": 3},
+      "score": {"type": ["number", "null"], "exclusiveMaximum": 100},
+      "kind": {"enum": ["a", "b"]}
+    },
+    "additionalProperties": false
+  });
+
+  assert::eq(type(s), "user");
+  assert::eq(s:validate({"id": 1, "name": "abc"}), []);
+  assert::yes(s:is_valid({"id": 1, "name": "abc", "tags": ["x"], "score": null, "kind": "a"}));
+  assert::eq(schema::validate(s, {"id": 2, "name": "x", "score": 99.5}), []);
+
+  {
+    let errs = s:validate({"name": "ABC"});
+    assert::eq(errs:length(), 2);
+    assert::eq(errs[0], {"path": "", "keyword": "required", "message": "missing required property id"});
+    assert::eq(errs[1].path, "name");
+    assert::eq(errs[1].keyword, "pattern");
+  }
+
+  {
+    let errs = s:validate({"id": 0, "name": "a", "tags": ["x", "x", 1, "y"], "extra": 1, "kind": "c"});
+    let kw = q::from(errs):map(fn(k, v): v.path + ":" + v.keyword):to_list();
+    assert::eq(kw, [
+      "id:minimum",
+      "kind:enum",
+      "tags:maxItems",
+      "tags:uniqueItems",
+      "tags[2]:type",
+      "extra:additionalProperties"
+    ]);
+  }
+
+  assert::eq(s:validate("x")[0].keyword, "type");
+  assert::eq(s:validate({"id": 1, "name": "a", "score": 100})[0].path, "score");
+}
+
+fn testCombinator() {
+  let s = schema::compile(```
+  {
+    "definitions": {
+      "node": {
+        "type": "object",
+        "properties": {
+          "value": {"type": "integer", "multipleOf": 2},
+          "children": {"type": "array", "items": {"$ref": "#/definitions/node"}}
+        }
+      }
+    },
+    "oneOf": [
+      {"$ref": "#/definitions/node"},
+      {"type": "string", "not": {"const": "bad"}}
+    ]
+  }
+```);
+
+  assert::yes(s:is_valid("good"));
+  assert::no(s:is_valid("bad"));
+  assert::yes(s:is_valid({"value": 2, "children": [{"value": 4, "children": []}]}));
+
+  let errs = s:validate({"value": 2, "children": [{"value": 3}]});
+  assert::eq(errs:length(), 1);
+  assert::eq(errs[0].keyword, "oneOf");
+
+  // violations behind $ref are reported with the full path
+  let tree = schema::compile({
+    "$defs": {"node": {"type": "object", "properties": {
+      "value": {"type": "integer"},
+      "children": {"items": {"$ref": "#/$defs/node"}}
+    }}},
+    "$ref": "#/$defs/node"
+  });
+  errs = tree:validate({"value": 2, "children": [{"value": 1}, {"value": "x"}]});
+  assert::eq(errs:length(), 1);
+  assert::eq(errs[0].path, "children[1].value");
+  assert::eq(errs[0].keyword, "type");
+
+  let any = schema::compile({"anyOf": [{"type": "integer"}, {"type": "boolean"}]});
+  assert::yes(any:is_valid(1));
+  assert::yes(any:is_valid(1.0));
+  assert::yes(any:is_valid(true));
+  assert::no(any:is_valid("1"));
+
+  assert::yes(schema::compile(true):is_valid([1, 2]));
+  assert::no(schema::compile(false):is_valid(null));
+}
+
+fn testCompileError() {
+  assert::throw(fn() { return schema::compile({"type": "int"}); });
+  assert::throw(fn() { return schema::compile({"minLength": -1}); });
+  assert::throw(fn() { return schema::compile({"pattern": "("}); });
+  assert::throw(fn() { return schema::compile({"$ref": "#/nope"}); });
+  assert::throw(fn() { return schema::compile("{"); });
+  assert::throw(fn() { return schema::validate(1, 1); });
+}
+
+test {
+  testBasic();
+  testCombinator();
+  testCompileError();
+}
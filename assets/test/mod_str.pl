@@ -0,0 +1,66 @@
+fn testSplit() {
+  assert::eq(str::split("a,b,c", ","), ["a", "b", "c"]);
+  assert::eq(str::split_n("a,b,c", ",", 2), ["a", "b,c"]);
+  assert::eq(str::split_n("a,b,c", ",", 1), ["a,b,c"]);
+}
+
+fn testTrim() {
+  assert::eq(str::trim_space("  a b  "), "a b");
+  assert::eq(str::trim("xxaxx", "x"), "a");
+  assert::eq(str::trim_prefix("prefix-a", "prefix-"), "a");
+  assert::eq(str::trim_suffix("a.pl", ".pl"), "a");
+}
+
+fn testPad() {
+  assert::eq(str::pad_left("7", 3, "0"), "007");
+  assert::eq(str::pad_right("ab", 4), "ab  ");
+  assert::eq(str::pad_left("abcd", 2), "abcd");
+  assert::eq(str::pad_left("中", 3, "*"), "**中");
+  assert::eq(str::pad_left("x", 6, "ab"), "ababax");
+  assert::eq(str::pad_right("x", 4, "中文"), "x中文中");
+  assert::eq(str::pad_left("x", -1), "x");
+  assert::eq(str::rune_length(str::pad_right("", 1048576, "-")), 1048576);
+  assert::throw(fn() {
+    let _ = str::pad_left("x", 1048577);
+  });
+  assert::throw(fn() {
+    let _ = str::pad_right("x", 9223372036854775807, "ab");
+  });
+}
+
+fn testRune() {
+  assert::eq(str::rune_length("中文abc"), 5);
+  assert::eq(str::substr("中文abc", 1, 3), "文a");
+  assert::eq(str::substr("中文abc", 2), "abc");
+  assert::eq(str::substr("中文abc", -2), "bc");
+  assert::eq(str::substr("中文abc", 4, 2), "");
+  assert::eq(str::index_of("中文abc", "b"), 3);
+  assert::eq(str::index_of("中文abc", "x"), -1);
+  assert::eq(str::last_index_of("abab", "ab"), 2);
+  assert::eq(str::reverse("中文ab"), "ba文中");
+  assert::eq(str::chars("中a"), ["中", "a"]);
+}
+
+fn testCase() {
+  assert::eq(str::fold("HeLLo"), "hello");
+  assert::yes(str::caseless_eq("HeLLo", "hello"));
+  assert::yes(str::starts_with("hello", "he"));
+  assert::yes(str::ends_with("hello", "lo"));
+  assert::no(str::ends_with("hello", "he"));
+}
+
+fn testMisc() {
+  assert::eq(str::repeat("ab", 3), "ababab");
+  assert::eq(str::levenshtein("kitten", "sitting"), 3);
+  assert::eq(str::levenshtein("", "abc"), 3);
+  assert::eq(str::levenshtein("中文", "中午"), 1);
+}
+
+test {
+  testSplit();
+  testTrim();
+  testPad();
+  testRune();
+  testCase();
+  testMisc();
+}
@@ -0,0 +1,84 @@
+// notes, {{{ is the escape of {{ in string literal, ie no interpolation
+
+// template function is only registered in the config or global scope
+global {
+  func_shout = template::add_func("go", "shout", fn(s) {
+    return str::to_upper(s) + "!";
+  });
+  func_wrap = template::add_func("pongo", "wrap", fn(v, p) {
+    return p + v + p;
+  });
+  func_inc = template::add_func("pongo", "inc", fn(v): v + 1);
+  func_boom = template::add_func("go", "boom", fn(v) {
+    assert::yes(false);
+  });
+  func_bad = try template::add_func("go", "bad", fn(v) {
+    func_shout = v;
+  }) else "rejected";
+  func_name = try template::add_func("go", "bad name", fn(v): v) else "rejected";
+}
+
+fn testRender() {
+  assert::eq(template::render("go", "hello {{{.name}}", {"name": "world"}), "hello world");
+  assert::eq(template::render("mustache", "{{{#l}}{{{.}},{{{/l}}", {"l": [1, 2]}), "1,2,");
+  assert::eq(template::render("raw", "<%a%>", {"a": 1}, {"left": "<%", "right": "%>"}), "1");
+  assert::eq(template::render("pongo", "{{{ a|upper }}", {"a": "x"}), "X");
+
+  assert::throw(fn() { return template::render("nope", "", null); });
+  assert::throw(fn() { return template::render("go", "{{{.a", {}); });
+  assert::throw(fn() { return template::render("raw", "${a}", {}, {"escape": 1}); });
+}
+
+fn testCompile() {
+  let t = template::compile("mustache", "hi {{{who}}");
+  assert::eq(t.engine, "mustache");
+  assert::eq(t:render({"who": "a"}), "hi a");
+  assert::eq(t:render({"who": "b"}), "hi b");
+  assert::eq(t:render(), "hi ");
+
+  let e = template::compile("go", "{{{.x}}", {"escape": "html"});
+  assert::eq(e:render({"x": "<>"}), "&lt;&gt;");
+
+  assert::throw(fn() { return template::compile("mustache", "{{{#a}}"); });
+}
+
+fn testAddFunc() {
+  assert::eq(template::render("go", "{{{shout .name}}", {"name": "hi"}), "HI!");
+  assert::eq(template::render("pongo", "{{{ wrap(a, '*') }}-{{{ inc(b) }}", {"a": "x", "b": 1}), "*x*-2");
+
+  // context value shadows the function
+  assert::eq(template::render("pongo", "{{{ inc }}", {"inc": 1}), "1");
+
+  // function which raises error
+  assert::throw(fn() { return template::render("go", "{{{boom 1}}", {}); });
+
+  // modifying shared state or invalid name is rejected
+  assert::eq(func_bad, "rejected");
+  assert::eq(func_name, "rejected");
+
+  // registration outside of the config or global scope is rejected
+  assert::throw(fn() { template::add_func("go", "late", fn(v): v); });
+  assert::throw(fn() { template::add_func("mustache", "x", fn(v): v); });
+}
+
+// template assigned to body field of a plain value is still rendered into
+// string, only http body consumes it lazily
+fn testBodyAssign() {
+  let m = {};
+  m.body = template "raw", {"a": 1}, ```EOF
+a=${a}
+EOF```;
+  assert::eq(m.body, "a=1");
+
+  m.body = "x" + template "raw", {"a": 2}, ```EOF
+${a}
+EOF```;
+  assert::eq(m.body, "x2");
+}
+
+test {
+  testBodyAssign();
+  testRender();
+  testCompile();
+  testAddFunc();
+}
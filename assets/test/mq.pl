@@ -0,0 +1,6 @@
+test {
+  assert::eq(mq::drivers(), ["kafka", "nats"]);
+  assert::eq((try mq::connect("amqp://127.0.0.1:5672") else "nodriver"), "nodriver");
+  assert::eq((try mq::connect("nats://127.0.0.1:1", {"connect_timeout": 100}) else "refused"), "refused");
+  assert::eq((try mq::connect("nats://127.0.0.1:1", {"bogus": 1}) else "option"), "option");
+}
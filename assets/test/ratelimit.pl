@@ -0,0 +1,48 @@
+fn testTokenBucket() {
+  let l = ratelimit::new("test.tb", 1, 3);
+  assert::eq(l.limit, 3);
+  assert::eq(l.algorithm, "token_bucket");
+  assert::yes(l:allow("a"));
+  assert::yes(l:allow("a"));
+  let r = l:take("a");
+  assert::yes(r.allowed);
+  assert::eq(r.remaining, 0);
+  r = l:take("a");
+  assert::no(r.allowed);
+  assert::yes(r.retry_after > 0);
+
+  // keys are independent
+  assert::yes(l:allow("b"));
+  assert::eq(l.length, 2);
+
+  l:reset("a");
+  assert::yes(l:allow("a", 3));
+  assert::no(l:allow("a", 4));
+}
+
+fn testSlidingWindow() {
+  let l = ratelimit::new("test.sw", 2, 0, {"algorithm": "sliding_window", "window": 1000});
+  assert::eq(l.limit, 2);
+  assert::yes(l:allow("k"));
+  assert::yes(l:allow("k"));
+  assert::no(l:allow("k"));
+}
+
+fn testShared() {
+  let l = ratelimit::new("test.shared", 1, 1);
+  assert::yes(l:allow("x"));
+
+  // the key space is shared, the rate of the later one is ignored
+  let l2 = ratelimit::new("test.shared", 100, 100);
+  assert::eq(l2.limit, 1);
+  assert::no(l2:allow("x"));
+  assert::no(ratelimit::open("test.shared"):allow("x"));
+  assert::eq((try ratelimit::open("test.missing") else "missing"), "missing");
+  assert::eq((try ratelimit::new("test.bad", 0) else "bad"), "bad");
+}
+
+test {
+  testTokenBucket();
+  testSlidingWindow();
+  testShared();
+}
@@ -97,6 +97,76 @@ fn testNest() {
     ][0][0][0][0][0][0][0][0][0][0], 10);
 }
 
+fn testMethod() {
+  {
+    let l = [3, "a", 1, 2.5, null];
+    l:sort();
+    assert::eq(l, [null, 1, 2.5, 3, "a"]);
+    assert::eq([1, 3, 2]:sort(true), [3, 2, 1]);
+    assert::eq([]:sort(), []);
+  }
+
+  {
+    let l = [1, [2], "3"];
+    assert::yes(l:contains([2]));
+    assert::yes(l:contains("3"));
+    assert::no(l:contains(3));
+    assert::no(l:contains(1.0));
+    assert::eq(l:index_of("3"), 2);
+    assert::eq(l:index_of(4), -1);
+  }
+
+  {
+    let l = [1, 3];
+    assert::eq(l:insert(1, 2), [1, 2, 3]);
+    assert::eq(l:insert(3, 4), [1, 2, 3, 4]);
+    assert::eq(l:insert(0, 0), [0, 1, 2, 3, 4]);
+    assert::throw(fn() { l:insert(10, 1); });
+    assert::throw(fn() { l:insert(-1, 1); });
+
+    assert::eq(l:remove(0), 0);
+    assert::eq(l:remove(1), 2);
+    assert::eq(l, [1, 3, 4]);
+    assert::throw(fn() { l:remove(3); });
+
+    assert::eq(l:pop(), 4);
+    assert::eq(l:pop(), 3);
+    assert::eq(l:pop(), 1);
+    assert::eq(l, []);
+    assert::throw(fn() { l:pop(); });
+  }
+}
+
+fn testSlice() {
+  let l = [1, 2, 3, 4, 5];
+  assert::eq(l[-1], 5);
+  assert::eq(l[-5], 1);
+  assert::throw(fn() { return l[-6]; });
+
+  assert::eq(l[1:4], [2, 3, 4]);
+  assert::eq(l[:2], [1, 2]);
+  assert::eq(l[3:], [4, 5]);
+  assert::eq(l[:], l);
+  assert::eq(l[1:-1], [2, 3, 4]);
+  assert::eq(l[-2:], [4, 5]);
+  assert::eq(l[3:1], []);
+  assert::eq(l[-100:100], l);
+  assert::throw(fn() { return l["a":]; });
+
+  // bound can be arbitrary expression, including method call
+  let i = 1;
+  assert::eq(l[i + 1:l:length() - 1], [3, 4]);
+  assert::eq(l[(l:length() - 2):], [4, 5]);
+  assert::eq(l[i if i > 0 else 0:[1, 2, 3]:length()], [2, 3]);
+
+  // slice is a copy
+  let c = l[:];
+  c[0] = 100;
+  assert::eq(l[0], 1);
+
+  l[-1] = 50;
+  assert::eq(l, [1, 2, 3, 4, 50]);
+}
 
 test {
   test1();
@@ -104,4 +174,6 @@ test {
   test3();
   testBasic();
   testNest();
+  testMethod();
+  testSlice();
 }
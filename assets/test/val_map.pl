@@ -87,6 +87,74 @@ fn testNested() {
     }.a.a.a.a.a.a.a.a.a.a, 1);
 }
 
+fn testMethod() {
+  {
+    let m = {"b": 1, "a": 2, "c": 3};
+    assert::eq(m:keys():length(), 3);
+    assert::eq(m:values():length(), 3);
+    assert::eq(m:entries():length(), 3);
+    assert::eq(m:get_or("a", 0), 2);
+    assert::eq(m:get_or("x", 0), 0);
+    assert::yes(m:delete("a"));
+    assert::no(m:delete("a"));
+    assert::eq(m, {"b": 1, "c": 3});
+  }
+
+  {
+    let m = {};
+    m["z"] = 1;
+    m["y"] = 2;
+    m["x"] = 3;
+    m:delete("y");
+    m["w"] = 4;
+    assert::eq(m:keys(), ["z", "x", "w"]);
+    assert::eq(m:values(), [1, 3, 4]);
+    assert::eq(m:entries()[0].first, "z");
+    assert::eq(m:entries()[0].second, 1);
+  }
+
+  {
+    let a = {"x": 1, "n": {"a": 1, "b": 2}};
+    a:merge({"y": 2, "n": {"b": 3}});
+    assert::eq(a, {"x": 1, "y": 2, "n": {"b": 3}});
+
+    let b = {"x": 1, "n": {"a": 1, "b": 2}};
+    b:merge({"y": 2, "n": {"b": 3, "c": 4}}, true);
+    assert::eq(b, {"x": 1, "y": 2, "n": {"a": 1, "b": 3, "c": 4}});
+
+    // cyclic maps terminate
+    let c = {"v": 1};
+    c.self = c;
+    let d = {"w": 2};
+    d.self = d;
+    c:merge(d, true);
+    assert::eq(c.w, 2);
+    assert::eq(c.self.self.v, 1);
+    c:merge(c, true);
+    assert::eq(c:length(), 3);
+  }
+}
+
+fn testOrdered() {
+  let m = {}:set_ordered(true);
+  assert::yes(m:ordered());
+  m["z"] = 1;
+  m["a"] = 2;
+  m["m"] = 3;
+  assert::eq(json::encode(m), '{"z":1,"a":2,"m":3}');
+
+  // unordered map is serialized with sorted keys
+  m:set_ordered(false);
+  assert::eq(json::encode(m), '{"a":2,"m":3,"z":1}');
+
+  let doc = '{"z":1,"a":[1,2.5,"x",null,true],"m":{"y":1,"b":2}}';
+  assert::eq(json::encode(json::decode(doc, true)), doc);
+  assert::no(json::decode(doc):ordered());
+  assert::eq(json::decode(doc).a, [1, 2.5, "x", null, true]);
+  assert::throw(fn() { json::decode("{"); });
+  assert::throw(fn() { json::decode("1 2"); });
+}
+
 test {
   test1();
   testMapKey();
@@ -94,4 +162,6 @@ test {
   testMapIndex();
   testBasic();
   testNested();
+  testMethod();
+  testOrdered();
 }
@@ -38,6 +38,21 @@ fn testStrInter1() {
 fn testStrIndex2() {
   assert::eq("a"[0], "a");
   assert::eq("ab"[1], "b");
+  assert::eq("ab"[-1], "b");
+  assert::eq("ab"[-2], "a");
+  assert::throw(fn() { return "ab"[-3]; });
+}
+
+fn testStrSlice() {
+  let s = "hello";
+  assert::eq(s[1:3], "el");
+  assert::eq(s[2:-1], "ll");
+  assert::eq(s[:2], "he");
+  assert::eq(s[-3:], "llo");
+  assert::eq(s[:], "hello");
+  assert::eq(s[4:2], "");
+  assert::eq(s[1:s:length()], "ello");
+  assert::throw(fn() { return 1[1:2]; });
 }
 
 test {
@@ -46,5 +61,6 @@ test {
   testStrIndex();
   testStrInter1();
   testStrIndex2();
+  testStrSlice();
   assert::eq(type(""), "string");
 }
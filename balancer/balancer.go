@@ -0,0 +1,320 @@
+package balancer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Load balancer of an upstream group. The group is a list of upstream address
+// with weight, the balancer picks one of them for each call according to the
+// policy:
+//
+//   round_robin, the upstreams are picked in turn, the weight is ignored
+//
+//   weighted, smooth weighted round robin, the upstreams are picked in turn
+//   proportional to their weight without bursting on the heavy one
+//
+//   least_conn, the upstream with the least active calls per weight
+//
+//   ring_hash, consistent hashing of the key of the call, the calls of same key
+//   go to the same upstream and only the keys of the removed upstream move
+//
+// The unhealthy upstreams, marked by the health checking, are skipped unless
+// all of them are unhealthy, in which case the calls are balanced as if all of
+// them are healthy
+
+const (
+	RoundRobin = "round_robin"
+	Weighted   = "weighted"
+	LeastConn  = "least_conn"
+	RingHash   = "ring_hash"
+)
+
+// virtual nodes per weight of the ring
+const ringReplica = 100
+
+type Upstream struct {
+	Addr   string
+	Weight int
+}
+
+type Balancer interface {
+	Policy() string
+	Upstream() []Upstream
+
+	// Pick selects the upstream of the call, the key is only used by ring hash.
+	// The returned done must be called once the call finishes
+	Pick(key string) (string, func())
+
+	// SetHealthy marks the upstream of the address
+	SetHealthy(addr string, healthy bool)
+}
+
+func New(policy string, upstream []Upstream) (Balancer, error) {
+	if len(upstream) == 0 {
+		return nil, fmt.Errorf("balancer: upstream is empty")
+	}
+	u := make([]Upstream, len(upstream))
+	for i, x := range upstream {
+		if x.Addr == "" {
+			return nil, fmt.Errorf("balancer: upstream address is empty")
+		}
+		if x.Weight < 0 {
+			return nil, fmt.Errorf("balancer: upstream %s weight must be non negative", x.Addr)
+		}
+		if x.Weight == 0 {
+			x.Weight = 1
+		}
+		u[i] = x
+	}
+
+	h := newHealth(u)
+	switch policy {
+	case "", RoundRobin:
+		return &roundRobin{health: h, upstream: u}, nil
+	case Weighted:
+		return &weighted{health: h, upstream: u, current: make([]int, len(u))}, nil
+	case LeastConn:
+		return &leastConn{health: h, upstream: u, active: make([]int, len(u))}, nil
+	case RingHash:
+		return newRingHash(h, u), nil
+	default:
+		return nil, fmt.Errorf("balancer: unknown policy %s", policy)
+	}
+}
+
+func nop() {}
+
+// health marks of the upstreams shared by all the policies
+type health struct {
+	index map[string]int
+	down  []int32
+}
+
+func newHealth(upstream []Upstream) health {
+	h := health{
+		index: make(map[string]int),
+		down:  make([]int32, len(upstream)),
+	}
+	for i, x := range upstream {
+		h.index[x.Addr] = i
+	}
+	return h
+}
+
+func (h *health) SetHealthy(addr string, healthy bool) {
+	i, ok := h.index[addr]
+	if !ok {
+		return
+	}
+	if healthy {
+		atomic.StoreInt32(&h.down[i], 0)
+	} else {
+		atomic.StoreInt32(&h.down[i], 1)
+	}
+}
+
+func (h *health) alive(i int) bool {
+	return atomic.LoadInt32(&h.down[i]) == 0
+}
+
+func (h *health) anyAlive() bool {
+	for i := range h.down {
+		if h.alive(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// picks the next healthy upstream in turn
+func (h *health) inTurn(next *uint64, upstream []Upstream) string {
+	n := uint64(len(upstream))
+	start := atomic.AddUint64(next, 1) - 1
+	for j := uint64(0); j < n; j++ {
+		if i := (start + j) % n; h.alive(int(i)) {
+			return upstream[i].Addr
+		}
+	}
+	return upstream[start%n].Addr
+}
+
+type roundRobin struct {
+	health
+	upstream []Upstream
+	next     uint64
+}
+
+func (r *roundRobin) Policy() string {
+	return RoundRobin
+}
+
+func (r *roundRobin) Upstream() []Upstream {
+	return r.upstream
+}
+
+func (r *roundRobin) Pick(_ string) (string, func()) {
+	return r.inTurn(&r.next, r.upstream), nop
+}
+
+type weighted struct {
+	sync.Mutex
+	health
+	upstream []Upstream
+	current  []int
+}
+
+func (w *weighted) Policy() string {
+	return Weighted
+}
+
+func (w *weighted) Upstream() []Upstream {
+	return w.upstream
+}
+
+func (w *weighted) Pick(_ string) (string, func()) {
+	w.Lock()
+	defer w.Unlock()
+
+	all := !w.anyAlive()
+	total, best := 0, -1
+	for i, x := range w.upstream {
+		if !all && !w.alive(i) {
+			continue
+		}
+		w.current[i] += x.Weight
+		total += x.Weight
+		if best < 0 || w.current[i] > w.current[best] {
+			best = i
+		}
+	}
+	w.current[best] -= total
+	return w.upstream[best].Addr, nop
+}
+
+type leastConn struct {
+	sync.Mutex
+	health
+	upstream []Upstream
+	active   []int
+
+	// the ties are broken in turn so the idle upstreams share the load
+	next int
+}
+
+func (l *leastConn) Policy() string {
+	return LeastConn
+}
+
+func (l *leastConn) Upstream() []Upstream {
+	return l.upstream
+}
+
+func (l *leastConn) Pick(_ string) (string, func()) {
+	l.Lock()
+	defer l.Unlock()
+
+	all := !l.anyAlive()
+	n := len(l.upstream)
+	best := -1
+	for j := 0; j < n; j++ {
+		i := (l.next + j) % n
+		if !all && !l.alive(i) {
+			continue
+		}
+		// active[i]/weight[i] < active[best]/weight[best]
+		if best < 0 || l.active[i]*l.upstream[best].Weight < l.active[best]*l.upstream[i].Weight {
+			best = i
+		}
+	}
+	l.next++
+	l.active[best]++
+
+	var once sync.Once
+	return l.upstream[best].Addr, func() {
+		once.Do(func() {
+			l.Lock()
+			l.active[best]--
+			l.Unlock()
+		})
+	}
+}
+
+type ringNode struct {
+	hash  uint64
+	index int
+}
+
+type ringHash struct {
+	health
+	upstream []Upstream
+	ring     []ringNode
+
+	// calls without key are spread in turn
+	next uint64
+}
+
+// fnv of the short keys which differ in the last byte only are close to each
+// other, the result is mixed(murmur3 finalizer) to spread them on the ring
+func hashOf(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+func newRingHash(h health, upstream []Upstream) *ringHash {
+	r := &ringHash{health: h, upstream: upstream}
+	for i, x := range upstream {
+		for j := 0; j < x.Weight*ringReplica; j++ {
+			r.ring = append(r.ring, ringNode{
+				hash:  hashOf(x.Addr + "#" + strconv.Itoa(j)),
+				index: i,
+			})
+		}
+	}
+	sort.Slice(r.ring, func(i, j int) bool {
+		return r.ring[i].hash < r.ring[j].hash
+	})
+	return r
+}
+
+func (r *ringHash) Policy() string {
+	return RingHash
+}
+
+func (r *ringHash) Upstream() []Upstream {
+	return r.upstream
+}
+
+func (r *ringHash) Pick(key string) (string, func()) {
+	if key == "" {
+		return r.inTurn(&r.next, r.upstream), nop
+	}
+
+	h := hashOf(key)
+	i := sort.Search(len(r.ring), func(i int) bool {
+		return r.ring[i].hash >= h
+	})
+	if i == len(r.ring) {
+		i = 0
+	}
+
+	// the keys of the unhealthy upstream go to the next healthy one on the ring
+	n := len(r.ring)
+	for j := 0; j < n; j++ {
+		if x := r.ring[(i+j)%n]; r.alive(x.index) {
+			return r.upstream[x.index].Addr, nop
+		}
+	}
+	return r.upstream[r.ring[i].index].Addr, nop
+}
@@ -0,0 +1,345 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Circuit breaker of the upstream hosts shared by all sessions. The breaker of
+// a host is closed normally and every call is recorded, it opens once the
+// failure rate of the rolling window reaches the threshold or the host fails
+// too many times in a row (outlier). An opened breaker rejects the calls until
+// the open timeout passes, then it becomes half open and lets a few probes
+// through, the breaker closes if all of them succeed otherwise it opens again
+
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half_open"
+)
+
+const (
+	defaultFailureRate   = 0.5
+	defaultMinRequest    = 20
+	defaultWindow        = 10 * time.Second
+	defaultOpenTimeout   = 30 * time.Second
+	defaultHalfOpenProbe = 1
+
+	bucketSize = 10
+)
+
+// ErrOpen is returned, wrapped with the host, when the call is rejected
+var ErrOpen = errors.New("breaker: circuit is open")
+
+type Option struct {
+	// failure rate, between 0 and 1, of the window which opens the breaker,
+	// it is only checked when the window has at least MinRequest calls
+	FailureRate float64
+	MinRequest  int
+	Window      time.Duration
+
+	// number of consecutive failures which opens the breaker regardless of
+	// the failure rate, 0 disables it
+	ConsecutiveFailure int
+
+	// how long the breaker stays open, and the number of probes of half open
+	OpenTimeout   time.Duration
+	HalfOpenProbe int
+}
+
+func (o *Option) normalize() error {
+	if o.FailureRate < 0 || o.FailureRate > 1 || math.IsNaN(o.FailureRate) {
+		return fmt.Errorf("breaker: failure rate must be between 0 and 1")
+	}
+	if o.MinRequest < 0 || o.ConsecutiveFailure < 0 || o.HalfOpenProbe < 0 {
+		return fmt.Errorf("breaker: counts must be non negative")
+	}
+	if o.FailureRate == 0 {
+		o.FailureRate = defaultFailureRate
+	}
+	if o.MinRequest == 0 {
+		o.MinRequest = defaultMinRequest
+	}
+	if o.Window <= 0 {
+		o.Window = defaultWindow
+	}
+	if o.OpenTimeout <= 0 {
+		o.OpenTimeout = defaultOpenTimeout
+	}
+	if o.HalfOpenProbe == 0 {
+		o.HalfOpenProbe = defaultHalfOpenProbe
+	}
+	return nil
+}
+
+type bucket struct {
+	start   time.Time
+	success int
+	failure int
+}
+
+type Stats struct {
+	State       string
+	Success     int
+	Failure     int
+	Consecutive int
+	Rejected    int64
+	Trips       int64
+}
+
+type Breaker struct {
+	sync.Mutex
+	host   string
+	option Option
+	state  string
+
+	// rolling window of the closed state
+	bucket      [bucketSize]bucket
+	consecutive int
+
+	// when the breaker opened, and the probes of the half open state
+	openAt  time.Time
+	probe   int
+	success int
+
+	// bumped on each state change, the calls admitted by a previous state are
+	// not recorded
+	generation int64
+
+	rejected int64
+	trips    int64
+}
+
+func newBreaker(host string, option Option) *Breaker {
+	return &Breaker{
+		host:   host,
+		option: option,
+		state:  StateClosed,
+	}
+}
+
+func (b *Breaker) Host() string {
+	return b.host
+}
+
+func (b *Breaker) Option() Option {
+	return b.option
+}
+
+func (b *Breaker) setState(state string, now time.Time) {
+	b.state = state
+	b.generation++
+	b.probe = 0
+	b.success = 0
+	b.consecutive = 0
+	b.bucket = [bucketSize]bucket{}
+	if state == StateOpen {
+		b.openAt = now
+		b.trips++
+	}
+}
+
+// the open breaker turns into half open once the timeout passes
+func (b *Breaker) refresh(now time.Time) {
+	if b.state == StateOpen && now.Sub(b.openAt) >= b.option.OpenTimeout {
+		b.setState(StateHalfOpen, now)
+	}
+}
+
+func (b *Breaker) bucketOf(now time.Time) *bucket {
+	width := b.option.Window / bucketSize
+	start := now.Truncate(width)
+	x := &b.bucket[(start.UnixNano()/int64(width))%bucketSize]
+	if !x.start.Equal(start) {
+		*x = bucket{start: start}
+	}
+	return x
+}
+
+func (b *Breaker) window(now time.Time) (int, int) {
+	success, failure := 0, 0
+	for _, x := range b.bucket {
+		if now.Sub(x.start) < b.option.Window {
+			success += x.success
+			failure += x.failure
+		}
+	}
+	return success, failure
+}
+
+func (b *Breaker) State() string {
+	b.Lock()
+	defer b.Unlock()
+	b.refresh(time.Now())
+	return b.state
+}
+
+func (b *Breaker) Stats() Stats {
+	b.Lock()
+	defer b.Unlock()
+	now := time.Now()
+	b.refresh(now)
+	success, failure := b.window(now)
+	return Stats{
+		State:       b.state,
+		Success:     success,
+		Failure:     failure,
+		Consecutive: b.consecutive,
+		Rejected:    b.rejected,
+		Trips:       b.trips,
+	}
+}
+
+// Allow admits the call, the returned done must be called with the outcome
+// once the call finishes. ErrOpen is returned if the call is rejected
+func (b *Breaker) Allow() (func(bool), error) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.refresh(time.Now())
+	switch b.state {
+	case StateOpen:
+		b.rejected++
+		return nil, fmt.Errorf("%w: %s", ErrOpen, b.host)
+	case StateHalfOpen:
+		if b.probe >= b.option.HalfOpenProbe {
+			b.rejected++
+			return nil, fmt.Errorf("%w: %s", ErrOpen, b.host)
+		}
+		b.probe++
+	}
+
+	generation := b.generation
+	return func(ok bool) {
+		b.record(generation, ok)
+	}, nil
+}
+
+func (b *Breaker) record(generation int64, ok bool) {
+	b.Lock()
+	defer b.Unlock()
+	if generation != b.generation {
+		return
+	}
+
+	now := time.Now()
+	if b.state == StateHalfOpen {
+		if !ok {
+			b.setState(StateOpen, now)
+		} else if b.success++; b.success >= b.option.HalfOpenProbe {
+			b.setState(StateClosed, now)
+		}
+		return
+	}
+
+	x := b.bucketOf(now)
+	if ok {
+		x.success++
+		b.consecutive = 0
+		return
+	}
+	x.failure++
+	b.consecutive++
+
+	if b.option.ConsecutiveFailure > 0 && b.consecutive >= b.option.ConsecutiveFailure {
+		b.setState(StateOpen, now)
+		return
+	}
+	success, failure := b.window(now)
+	if total := success + failure; total >= b.option.MinRequest &&
+		float64(failure)/float64(total) >= b.option.FailureRate {
+		b.setState(StateOpen, now)
+	}
+}
+
+// Reset closes the breaker and forgets its history
+func (b *Breaker) Reset() {
+	b.Lock()
+	defer b.Unlock()
+	b.setState(StateClosed, time.Now())
+}
+
+// breakers are created lazily for the hosts which have option configured,
+// either by the host(with port), the hostname or * as default
+var (
+	registryLock sync.Mutex
+	option       = make(map[string]Option)
+	registry     = make(map[string]*Breaker)
+)
+
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+func optionOf(host string) (Option, bool) {
+	if o, ok := option[host]; ok {
+		return o, true
+	}
+	if o, ok := option[hostname(host)]; ok {
+		return o, true
+	}
+	o, ok := option["*"]
+	return o, ok
+}
+
+// Configure sets the option of the host, the breakers affected are recreated
+// with the new option
+func Configure(host string, o *Option) error {
+	x := *o
+	if err := x.normalize(); err != nil {
+		return err
+	}
+
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	option[host] = x
+	for h, b := range registry {
+		if y, _ := optionOf(h); y != b.option {
+			delete(registry, h)
+		}
+	}
+	return nil
+}
+
+// Get returns the breaker of the host, nil if the host has no option
+func Get(host string) *Breaker {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if b, ok := registry[host]; ok {
+		return b
+	}
+	o, ok := optionOf(host)
+	if !ok {
+		return nil
+	}
+	b := newBreaker(host, o)
+	registry[host] = b
+	return b
+}
+
+// Find returns the breaker of the host if it has been created
+func Find(host string) *Breaker {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	return registry[host]
+}
+
+// Hosts lists the hosts whose breaker has been created
+func Hosts() []string {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	o := make([]string, 0, len(registry))
+	for h := range registry {
+		o = append(o, h)
+	}
+	sort.Strings(o)
+	return o
+}
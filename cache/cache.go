@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Shared cache used by the http cache middleware and by script through the
+// cache:: module. A cache is either anonymous, or registered by name so it
+// can be shared, ie each vhost registers its cache under the vhost name
+
+type Option struct {
+	// total size of entries in bytes and number of entries, zero is unlimited
+	MaxSize    int64
+	MaxEntries int
+
+	// entry larger than MaxEntrySize is not cached, zero is unlimited
+	MaxEntrySize int64
+
+	// upper bound of the lifetime of an entry, including its stale period.
+	// Zero means the lifetime is decided by the entry itself
+	TTL time.Duration
+
+	// directory of the disk backend, empty means memory backend
+	Dir string
+}
+
+type Cache struct {
+	option Option
+	store  Store
+
+	// keys being revalidated by an in flight request, see Revalidate
+	revalidateLock sync.Mutex
+	revalidate     map[string]time.Time
+}
+
+// how long a revalidation is considered in flight, after that another request
+// is allowed to revalidate the entry again
+const revalidateTimeout = 30 * time.Second
+
+func New(option *Option) (*Cache, error) {
+	if option == nil {
+		option = &Option{}
+	}
+	c := &Cache{
+		option:     *option,
+		revalidate: make(map[string]time.Time),
+	}
+	if option.Dir != "" {
+		s, err := newDiskStore(option.Dir, option.MaxSize, option.MaxEntries)
+		if err != nil {
+			return nil, fmt.Errorf("cache: cannot open disk store %s: %s", option.Dir, err.Error())
+		}
+		c.store = s
+	} else {
+		c.store = newMemoryStore(option.MaxSize, option.MaxEntries)
+	}
+	return c, nil
+}
+
+func (c *Cache) Option() Option {
+	return c.option
+}
+
+func (c *Cache) Get(key string) *Entry {
+	return c.store.Get(key)
+}
+
+// stores the entry, the entry's Key and Stored are set and its Expire is
+// capped by the TTL option. Returns false when the entry is too large
+func (c *Cache) Set(key string, e *Entry) (bool, error) {
+	e.Key = key
+	if e.Stored.IsZero() {
+		e.Stored = time.Now()
+	}
+	if c.option.MaxEntrySize > 0 && e.Size() > c.option.MaxEntrySize {
+		return false, nil
+	}
+	if c.option.TTL > 0 {
+		deadline := e.Stored.Add(c.option.TTL)
+		if e.Expire.IsZero() || e.Expire.After(deadline) {
+			e.Expire = deadline
+		}
+		if e.Fresh.IsZero() || e.Fresh.After(deadline) {
+			e.Fresh = deadline
+		}
+	}
+	if err := c.store.Set(e); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *Cache) Delete(key string) {
+	c.store.Delete(key)
+}
+
+func (c *Cache) Purge() {
+	c.store.Purge()
+}
+
+func (c *Cache) Len() int {
+	return c.store.Len()
+}
+
+func (c *Cache) Size() int64 {
+	return c.store.Size()
+}
+
+// Revalidate claims the revalidation of the stale key, only the first caller
+// gets true until Revalidated is called or the claim times out, the others
+// keep serving the stale entry
+func (c *Cache) Revalidate(key string) bool {
+	c.revalidateLock.Lock()
+	defer c.revalidateLock.Unlock()
+	now := time.Now()
+	if t, ok := c.revalidate[key]; ok && now.Before(t) {
+		return false
+	}
+	c.revalidate[key] = now.Add(revalidateTimeout)
+	return true
+}
+
+func (c *Cache) Revalidated(key string) {
+	c.revalidateLock.Lock()
+	defer c.revalidateLock.Unlock()
+	delete(c.revalidate, key)
+}
+
+// named cache registry
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]*Cache)
+)
+
+// Register makes the cache visible by name, the cache registered before with
+// the same name is replaced
+func Register(name string, c *Cache) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry[name] = c
+}
+
+func Find(name string) *Cache {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	return registry[name]
+}
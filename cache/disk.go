@@ -0,0 +1,242 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// disk store keeps each entry as a gob encoded file under the directory, the
+// index is kept in memory and is rebuilt from the directory when opened, so
+// the cached entries survive restart
+type diskStore struct {
+	sync.Mutex
+	dir        string
+	lru        *list.List
+	entry      map[string]*list.Element
+	size       int64
+	maxSize    int64
+	maxEntries int
+}
+
+type diskItem struct {
+	key    string
+	file   string
+	size   int64
+	expire time.Time
+}
+
+const diskEntrySuffix = ".entry"
+
+func diskFileName(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:]) + diskEntrySuffix
+}
+
+func newDiskStore(dir string, maxSize int64, maxEntries int) (*diskStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	d := &diskStore{
+		dir:        dir,
+		lru:        list.New(),
+		entry:      make(map[string]*list.Element),
+		maxSize:    maxSize,
+		maxEntries: maxEntries,
+	}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// rebuilds the index, the least recently modified file is the least recently
+// used entry. The broken and expired files are removed
+func (d *diskStore) load() error {
+	files, err := os.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+
+	type loaded struct {
+		item  *diskItem
+		mtime time.Time
+	}
+	l := []loaded{}
+	now := time.Now()
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), diskEntrySuffix) {
+			continue
+		}
+		path := filepath.Join(d.dir, f.Name())
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		e, err := readDiskEntry(path)
+		if err != nil || e.IsExpired(now) || diskFileName(e.Key) != f.Name() {
+			os.Remove(path)
+			continue
+		}
+		l = append(l, loaded{
+			item: &diskItem{
+				key:    e.Key,
+				file:   path,
+				size:   e.Size(),
+				expire: e.Expire,
+			},
+			mtime: info.ModTime(),
+		})
+	}
+
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].mtime.Before(l[j].mtime)
+	})
+	for _, x := range l {
+		d.entry[x.item.key] = d.lru.PushFront(x.item)
+		d.size += x.item.size
+	}
+	d.evict()
+	return nil
+}
+
+func readDiskEntry(path string) (*Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (d *diskStore) remove(e *list.Element) {
+	x := e.Value.(*diskItem)
+	d.lru.Remove(e)
+	delete(d.entry, x.key)
+	d.size -= x.size
+	os.Remove(x.file)
+}
+
+func (d *diskStore) evict() {
+	for d.lru.Len() > 1 &&
+		((d.maxSize > 0 && d.size > d.maxSize) ||
+			(d.maxEntries > 0 && d.lru.Len() > d.maxEntries)) {
+		d.remove(d.lru.Back())
+	}
+}
+
+func (d *diskStore) Get(key string) *Entry {
+	d.Lock()
+	defer d.Unlock()
+	e, ok := d.entry[key]
+	if !ok {
+		return nil
+	}
+	x := e.Value.(*diskItem)
+	now := time.Now()
+	if !x.expire.IsZero() && !now.Before(x.expire) {
+		d.remove(e)
+		return nil
+	}
+	entry, err := readDiskEntry(x.file)
+	if err != nil || entry.Key != key {
+		d.remove(e)
+		return nil
+	}
+	d.lru.MoveToFront(e)
+	os.Chtimes(x.file, now, now)
+	return entry
+}
+
+func (d *diskStore) Set(x *Entry) error {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(x); err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	path := filepath.Join(d.dir, diskFileName(x.Key))
+
+	// write to a temporary file and rename it, so a reader never sees a
+	// partially written entry
+	tmp, err := os.CreateTemp(d.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if e, ok := d.entry[x.Key]; ok {
+		old := e.Value.(*diskItem)
+		d.lru.Remove(e)
+		delete(d.entry, old.key)
+		d.size -= old.size
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	item := &diskItem{
+		key:    x.Key,
+		file:   path,
+		size:   x.Size(),
+		expire: x.Expire,
+	}
+	d.entry[x.Key] = d.lru.PushFront(item)
+	d.size += item.size
+	d.evict()
+	return nil
+}
+
+func (d *diskStore) Delete(key string) {
+	d.Lock()
+	defer d.Unlock()
+	if e, ok := d.entry[key]; ok {
+		d.remove(e)
+	}
+}
+
+func (d *diskStore) Purge() {
+	d.Lock()
+	defer d.Unlock()
+	for e := d.lru.Front(); e != nil; e = e.Next() {
+		os.Remove(e.Value.(*diskItem).file)
+	}
+	d.lru.Init()
+	d.entry = make(map[string]*list.Element)
+	d.size = 0
+}
+
+func (d *diskStore) Len() int {
+	d.Lock()
+	defer d.Unlock()
+	return d.lru.Len()
+}
+
+func (d *diskStore) Size() int64 {
+	d.Lock()
+	defer d.Unlock()
+	return d.size
+}
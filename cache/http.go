@@ -0,0 +1,382 @@
+package cache
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RFC 7234 helpers for a shared cache. The origin is opaque to the cache, it
+// cannot be asked conditionally, so a stale entry is refreshed by letting one
+// request through to the origin while the others are served the stale entry
+// within the stale-while-revalidate window
+
+// Control is the parsed Cache-Control header, keyed by the lower case
+// directive name, the directive without argument has empty value
+type Control map[string]string
+
+func splitList(v string) []string {
+	o := []string{}
+	quoted := false
+	start := 0
+	for i := 0; i < len(v); i++ {
+		switch v[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				o = append(o, v[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(o, v[start:])
+}
+
+func ParseControl(values []string) Control {
+	o := make(Control)
+	for _, v := range values {
+		for _, x := range splitList(v) {
+			x = strings.TrimSpace(x)
+			if x == "" {
+				continue
+			}
+			name, arg := x, ""
+			if idx := strings.Index(x, "="); idx != -1 {
+				name = strings.TrimSpace(x[:idx])
+				arg = strings.Trim(strings.TrimSpace(x[idx+1:]), "\"")
+			}
+			o[strings.ToLower(name)] = arg
+		}
+	}
+	return o
+}
+
+func (c Control) Has(name string) bool {
+	_, ok := c[name]
+	return ok
+}
+
+// delta seconds argument of the directive, the overflowed value is clamped
+func (c Control) Seconds(name string) (time.Duration, bool) {
+	v, ok := c[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		if e, ok := err.(*strconv.NumError); ok && e.Err == strconv.ErrRange && n > 0 {
+			n = 1<<31 - 1
+		} else {
+			return 0, false
+		}
+	}
+	if n < 0 {
+		return 0, false
+	}
+	if n > 1<<31-1 {
+		n = 1<<31 - 1
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// Key is the primary key of the request, ie the effective request URI
+func Key(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	return scheme + "://" + host + r.URL.RequestURI()
+}
+
+// names of the request headers the response varies on, canonicalized and
+// sorted
+func VaryNames(h http.Header) []string {
+	o := []string{}
+	for _, v := range h.Values("Vary") {
+		for _, x := range strings.Split(v, ",") {
+			x = strings.TrimSpace(x)
+			if x != "" {
+				o = append(o, http.CanonicalHeaderKey(x))
+			}
+		}
+	}
+	sort.Strings(o)
+	return o
+}
+
+// VaryKey is the key of the variant selected by the request header
+func VaryKey(key string, vary []string, h http.Header) string {
+	b := strings.Builder{}
+	b.WriteString(key)
+	for _, name := range vary {
+		b.WriteString("\n")
+		b.WriteString(name)
+		b.WriteString(":")
+		b.WriteString(strings.Join(h.Values(name), ","))
+	}
+	return b.String()
+}
+
+// Freshness returns the freshness lifetime of the response for a shared cache,
+// the false is returned when the response has no explicit lifetime
+func Freshness(h http.Header, now time.Time) (time.Duration, bool) {
+	cc := ParseControl(h.Values("Cache-Control"))
+	if v, ok := cc.Seconds("s-maxage"); ok {
+		return v, true
+	}
+	if v, ok := cc.Seconds("max-age"); ok {
+		return v, true
+	}
+	if e := h.Get("Expires"); e != "" {
+		expires, err := http.ParseTime(e)
+		if err != nil {
+			// invalid Expires means already expired
+			return 0, true
+		}
+		date := now
+		if d, err := http.ParseTime(h.Get("Date")); err == nil {
+			date = d
+		}
+		if expires.Before(date) {
+			return 0, true
+		}
+		return expires.Sub(date), true
+	}
+	return 0, false
+}
+
+// StaleWhileRevalidate returns the window the stale response can be served
+// while it is being refreshed, see RFC 5861
+func StaleWhileRevalidate(h http.Header) time.Duration {
+	cc := ParseControl(h.Values("Cache-Control"))
+	if cc.Has("must-revalidate") || cc.Has("proxy-revalidate") {
+		return 0
+	}
+	v, _ := cc.Seconds("stale-while-revalidate")
+	return v
+}
+
+var storableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// Storable tells whether the response of the request can be stored by a
+// shared cache. The response with Set-Cookie is never stored since it is
+// likely personalized, and no-cache response is not stored since the cache
+// cannot revalidate it
+func Storable(r *http.Request, status int, h http.Header) bool {
+	if r.Method != http.MethodGet || !storableStatus[status] {
+		return false
+	}
+	if ParseControl(r.Header.Values("Cache-Control")).Has("no-store") {
+		return false
+	}
+	cc := ParseControl(h.Values("Cache-Control"))
+	if cc.Has("no-store") || cc.Has("private") || cc.Has("no-cache") {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" &&
+		!cc.Has("public") && !cc.Has("s-maxage") && !cc.Has("must-revalidate") {
+		return false
+	}
+	if h.Get("Set-Cookie") != "" {
+		return false
+	}
+	for _, x := range VaryNames(h) {
+		if x == "*" {
+			return false
+		}
+	}
+	return true
+}
+
+func etagMatch(a, b string) bool {
+	return strings.TrimPrefix(strings.TrimSpace(a), "W/") == strings.TrimPrefix(strings.TrimSpace(b), "W/")
+}
+
+// NotModified evaluates the request's validators against the response header,
+// ie If-None-Match against ETag with weak comparison and If-Modified-Since
+// against Last-Modified. The If-Modified-Since is ignored when If-None-Match
+// presents
+func NotModified(r *http.Request, h http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := h.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, x := range splitList(inm) {
+			x = strings.TrimSpace(x)
+			if x == "*" || etagMatch(x, etag) {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		lm, err := http.ParseTime(h.Get("Last-Modified"))
+		if err != nil {
+			return false
+		}
+		return !lm.After(since)
+	}
+	return false
+}
+
+// state of a request looked up in the cache
+const (
+	// not cached, or the caller should refresh the stale entry
+	StateMiss = iota
+
+	// fresh entry found
+	StateHit
+
+	// stale entry found and it is being refreshed by another request
+	StateStale
+)
+
+// Lookup finds the response of the request. On StateMiss of a stale entry,
+// the caller owns the revalidation of the key and the stored response ends
+// it, see StoreResponse
+func (c *Cache) Lookup(r *http.Request) (*Entry, int) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return nil, StateMiss
+	}
+	cc := ParseControl(r.Header.Values("Cache-Control"))
+	if cc.Has("no-store") || cc.Has("no-cache") ||
+		(len(cc) == 0 && r.Header.Get("Pragma") == "no-cache") {
+		return nil, StateMiss
+	}
+
+	key := Key(r)
+	e := c.Get(key)
+	if e == nil {
+		return nil, StateMiss
+	}
+	if len(e.Vary) != 0 {
+		if e = c.Get(VaryKey(key, e.Vary, r.Header)); e == nil {
+			return nil, StateMiss
+		}
+	}
+
+	now := time.Now()
+	fresh := e.IsFresh(now)
+	if maxAge, ok := cc.Seconds("max-age"); ok && now.Sub(e.Stored) > maxAge {
+		fresh = false
+	}
+	if fresh {
+		return e, StateHit
+	}
+	if c.Revalidate(key) {
+		return nil, StateMiss
+	}
+	return e, StateStale
+}
+
+var hopByHopHeader = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Age",
+}
+
+// StoreResponse stores the response of the request if it is storable, the
+// defaultTTL is used as freshness lifetime when the response has none, zero
+// means such response is not stored. It also ends the revalidation of the key
+// claimed by Lookup
+func (c *Cache) StoreResponse(
+	r *http.Request,
+	status int,
+	h http.Header,
+	body []byte,
+	defaultTTL time.Duration,
+) (bool, error) {
+	key := Key(r)
+	defer c.Revalidated(key)
+
+	if !Storable(r, status, h) {
+		return false, nil
+	}
+
+	now := time.Now()
+	lifetime, ok := Freshness(h, now)
+	if !ok {
+		lifetime = defaultTTL
+	}
+
+	// the age the response already has, ie from an upstream cache
+	stored := now
+	if age, err := strconv.ParseInt(h.Get("Age"), 10, 64); err == nil && age > 0 {
+		stored = now.Add(-time.Duration(age) * time.Second)
+	}
+
+	fresh := stored.Add(lifetime)
+	if !fresh.After(now) {
+		return false, nil
+	}
+	expire := fresh.Add(StaleWhileRevalidate(h))
+
+	header := h.Clone()
+	for _, x := range hopByHopHeader {
+		header.Del(x)
+	}
+
+	e := &Entry{
+		Status: status,
+		Header: header,
+		Body:   body,
+		Stored: stored,
+		Fresh:  fresh,
+		Expire: expire,
+	}
+
+	vary := VaryNames(h)
+	if len(vary) == 0 {
+		return c.Set(key, e)
+	}
+
+	if ok, err := c.Set(VaryKey(key, vary, r.Header), e); !ok || err != nil {
+		return ok, err
+	}
+	return c.Set(key, &Entry{
+		Vary:   vary,
+		Stored: stored,
+		Fresh:  fresh,
+		Expire: expire,
+	})
+}
+
+// Age of the entry in seconds
+func (e *Entry) Age(now time.Time) int64 {
+	age := int64(now.Sub(e.Stored) / time.Second)
+	if age < 0 {
+		return 0
+	}
+	return age
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// in memory LRU store bounded by total size and number of entries, zero means
+// unlimited
+type memoryStore struct {
+	sync.Mutex
+	lru        *list.List
+	entry      map[string]*list.Element
+	size       int64
+	maxSize    int64
+	maxEntries int
+}
+
+func newMemoryStore(maxSize int64, maxEntries int) *memoryStore {
+	return &memoryStore{
+		lru:        list.New(),
+		entry:      make(map[string]*list.Element),
+		maxSize:    maxSize,
+		maxEntries: maxEntries,
+	}
+}
+
+func (m *memoryStore) remove(e *list.Element) {
+	x := e.Value.(*Entry)
+	m.lru.Remove(e)
+	delete(m.entry, x.Key)
+	m.size -= x.Size()
+}
+
+func (m *memoryStore) Get(key string) *Entry {
+	m.Lock()
+	defer m.Unlock()
+	e, ok := m.entry[key]
+	if !ok {
+		return nil
+	}
+	x := e.Value.(*Entry)
+	if x.IsExpired(time.Now()) {
+		m.remove(e)
+		return nil
+	}
+	m.lru.MoveToFront(e)
+	return x
+}
+
+func (m *memoryStore) Set(x *Entry) error {
+	m.Lock()
+	defer m.Unlock()
+	if e, ok := m.entry[x.Key]; ok {
+		m.remove(e)
+	}
+	m.entry[x.Key] = m.lru.PushFront(x)
+	m.size += x.Size()
+
+	for m.lru.Len() > 1 &&
+		((m.maxSize > 0 && m.size > m.maxSize) ||
+			(m.maxEntries > 0 && m.lru.Len() > m.maxEntries)) {
+		m.remove(m.lru.Back())
+	}
+	return nil
+}
+
+func (m *memoryStore) Delete(key string) {
+	m.Lock()
+	defer m.Unlock()
+	if e, ok := m.entry[key]; ok {
+		m.remove(e)
+	}
+}
+
+func (m *memoryStore) Purge() {
+	m.Lock()
+	defer m.Unlock()
+	m.lru.Init()
+	m.entry = make(map[string]*list.Element)
+	m.size = 0
+}
+
+func (m *memoryStore) Len() int {
+	m.Lock()
+	defer m.Unlock()
+	return m.lru.Len()
+}
+
+func (m *memoryStore) Size() int64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.size
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"net/http"
+	"time"
+)
+
+// Entry is the unit stored in the cache. A http response uses Status, Header
+// and Body, a script value uses Body only. An entry with Vary is the marker of
+// the primary key of a response varied by request headers, the variants are
+// stored under their own keys
+type Entry struct {
+	Key    string
+	Status int
+	Header http.Header
+	Body   []byte
+	Vary   []string
+
+	// time the entry is stored, used to compute Age
+	Stored time.Time
+
+	// the entry is fresh until Fresh, and is kept as stale until Expire, ie
+	// for stale-while-revalidate. Zero Expire means never expires
+	Fresh  time.Time
+	Expire time.Time
+}
+
+func (e *Entry) IsFresh(now time.Time) bool {
+	return e.Fresh.IsZero() || now.Before(e.Fresh)
+}
+
+func (e *Entry) IsExpired(now time.Time) bool {
+	return !e.Expire.IsZero() && !now.Before(e.Expire)
+}
+
+// approximated memory footprint of the entry
+func (e *Entry) Size() int64 {
+	sz := int64(len(e.Key) + len(e.Body))
+	for k, v := range e.Header {
+		sz += int64(len(k))
+		for _, x := range v {
+			sz += int64(len(x))
+		}
+	}
+	for _, x := range e.Vary {
+		sz += int64(len(x))
+	}
+	return sz
+}
+
+// Store is the backend of the cache, it must be safe for concurrent use. The
+// expired entry is never returned by Get
+type Store interface {
+	Get(key string) *Entry
+	Set(e *Entry) error
+	Delete(key string)
+	Purge()
+
+	// number of entries and total size of entries
+	Len() int
+	Size() int64
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dianpeng/moons/pl"
+)
+
+// moons bench [-rule name] [-event json] [-n N] [-warmup N] [-time 1s] file
+//
+// benchmarks the rules of the PL file, every rule unless -rule is given. The
+// variables are loaded from -var name=json, the actions are discarded.
+
+type benchVars map[string]pl.Val
+
+func (v benchVars) String() string {
+	return "name=json"
+}
+
+func (v benchVars) Set(x string) error {
+	for i := 0; i < len(x); i++ {
+		if x[i] == '=' {
+			val, err := pl.NewValFromJSON(x[i+1:])
+			if err != nil {
+				return fmt.Errorf("invalid -var %s: %s", x, err.Error())
+			}
+			v[x[:i]] = val
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid -var %s, must be name=json", x)
+}
+
+func newBenchContext(vars benchVars) pl.EvalContext {
+	return pl.NewCbEvalContext(
+		func(_ *pl.Evaluator, name string) (pl.Val, error) {
+			if v, ok := vars[name]; ok {
+				return v, nil
+			}
+			return pl.NewValNull(), fmt.Errorf("bench: unknown variable %s", name)
+		},
+		func(_ *pl.Evaluator, name string, v pl.Val) error {
+			vars[name] = v
+			return nil
+		},
+		func(_ *pl.Evaluator, _ string, _ pl.Val) error {
+			return nil
+		},
+	)
+}
+
+func runBench(args []string) int {
+	set := flag.NewFlagSet("bench", flag.ExitOnError)
+	rule := set.String("rule", "", "rule to benchmark, all the rules if empty")
+	event := set.String("event", "", "event context of the rule in JSON, ie $")
+	n := set.Int("n", 0, "number of the runs measured, 0 runs until -time passes")
+	warmup := set.Int("warmup", 100, "number of the runs before measuring")
+	duration := set.Duration("time", time.Second, "how long to run each rule when -n is 0")
+	session := set.Bool("session", false, "evaluate the session scope before every run")
+	vars := make(benchVars)
+	set.Var(vars, "var", "variable of the context, name=json")
+	set.Parse(args)
+
+	if set.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: moons bench [flags] file\n")
+		set.PrintDefaults()
+		return 2
+	}
+
+	path := set.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+	module, err := pl.CompileModule(string(data), os.DirFS(filepath.Dir(path)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+
+	opt := &pl.BenchmarkOption{
+		Warmup:    *warmup,
+		Iteration: *n,
+		Duration:  *duration,
+		Session:   *session,
+		Context:   pl.NewValNull(),
+	}
+	if *event != "" {
+		v, err := pl.NewValFromJSON(*event)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -event: %s\n", err.Error())
+			return 2
+		}
+		opt.Context = v
+	}
+
+	rules := module.Rules()
+	if *rule != "" {
+		rules = []string{*rule}
+	}
+
+	code := 0
+	for _, x := range rules {
+		r, err := pl.Benchmark(module, x, newBenchContext(vars), opt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", x, err.Error())
+			code = 1
+			continue
+		}
+		fmt.Println(r.String())
+	}
+	return code
+}
@@ -3,12 +3,21 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"os"
-
-	"github.com/dianpeng/moons/manifest"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dianpeng/moons/g"
+	"github.com/dianpeng/moons/kv"
+	"github.com/dianpeng/moons/pl"
 	"github.com/dianpeng/moons/server"
+	"github.com/dianpeng/moons/util"
 
 	// for side effect
+	_ "github.com/dianpeng/moons/admin"
 	_ "github.com/dianpeng/moons/http"
 	_ "github.com/dianpeng/moons/redis"
 )
@@ -40,59 +49,204 @@ func parseListenerConfig(x strList) ([]server.ListenerConfig, error) {
 	return o, nil
 }
 
+func printFunctions() {
+	for _, x := range pl.ListIntrinsics() {
+		sig := x.Signature
+		if sig == "" {
+			sig = x.Name
+			if x.Module != "" {
+				sig = x.Module + "::" + x.Name
+			}
+		}
+		fmt.Println(sig)
+		if x.Comment != "" {
+			fmt.Printf("    %s\n", strings.ReplaceAll(x.Comment, "\n", "\n    "))
+		}
+	}
+}
+
+// builds the config of the config file and the flags, the listeners and vhosts
+// of the flags are added to the ones of the file, the limits of the flags set
+// explicitly override the ones of the file
+func loadConfig(
+	path string,
+	listenerConf strList,
+	httpdir strList,
+	redisdir strList,
+	limit server.LimitConfig,
+	watchInterval int64,
+) (*server.Config, error) {
+	c := &server.Config{
+		Limit: limit,
+		Log: server.LogConfig{
+			Level: "info",
+		},
+		WatchInterval: watchInterval,
+	}
+	if path != "" {
+		x, err := server.LoadConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		c = x
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "kv_max_size":
+				c.Limit.KVMaxSize = limit.KVMaxSize
+			case "kv_max_entries":
+				c.Limit.KVMaxEntries = limit.KVMaxEntries
+			case "shutdown_timeout":
+				c.Limit.ShutdownTimeout = limit.ShutdownTimeout
+			case "watch_interval":
+				c.WatchInterval = watchInterval
+			}
+		})
+	}
+
+	lconf, err := parseListenerConfig(listenerConf)
+	if err != nil {
+		return nil, err
+	}
+	c.Listener = append(c.Listener, lconf...)
+
+	for _, m := range httpdir {
+		c.VHost = append(c.VHost, server.VHostConfig{
+			Type: "http",
+			Main: m,
+			Pos:  "http_dir " + m,
+		})
+	}
+	for _, m := range redisdir {
+		c.VHost = append(c.VHost, server.VHostConfig{
+			Type: "redis",
+			Main: m,
+			Pos:  "redis_dir " + m,
+		})
+	}
+	return c, nil
+}
+
+func setupLog(c *server.LogConfig) error {
+	if err := util.SetLogLevel(c.Level); err != nil {
+		return err
+	}
+	if c.Output != "" {
+		f, err := os.OpenFile(c.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("log: %s", err.Error())
+		}
+		log.SetOutput(f)
+	}
+	return nil
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "test":
+			os.Exit(runTests(os.Args[2:]))
+		case "repl":
+			os.Exit(runRepl(os.Args[2:]))
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		}
+	}
+
 	var listenerConf strList
 	var httpdir strList
 	var redisdir strList
 
 	flag.Var(&listenerConf, "listener", "list of listener config, in JSON")
-	flag.Var(&httpdir, "http_dir", "list of path to local fs http virtual host")
-	flag.Var(&redisdir, "redis_dir", "list of path to local fs redis virtual host")
+	flag.Var(&httpdir, "http_dir", "list of main file, bundle or remote source of http virtual host")
+	flag.Var(&redisdir, "redis_dir", "list of main file, bundle or remote source of redis virtual host")
+
+	configFile := flag.String("config", "", "path of the server config file, in YAML or JSON")
+	check := flag.Bool("check", false, "validate the config and the vhosts, and exit")
+	listFunctions := flag.Bool("list_functions", false, "print all the intrinsic functions and exit")
+	kvMaxSize := flag.Int64("kv_max_size", g.KVMaxSize, "max size in bytes of the kv store, 0 is unlimited")
+	kvMaxEntries := flag.Int("kv_max_entries", g.KVMaxEntries, "max number of entries of the kv store, 0 is unlimited")
+	shutdownTimeout := flag.Int64("shutdown_timeout", g.ShutdownTimeout, "seconds to drain the in flight sessions once shutdown")
+	watchInterval := flag.Int64("watch_interval", 0, "seconds between the checks of the local manifests, the changed one is reloaded, 0 disables")
 
 	flag.Parse()
 
-	lconf, err := parseListenerConfig(listenerConf)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
+	if *listFunctions {
+		printFunctions()
 		return
 	}
 
-	srv, err := server.NewServer(lconf)
+	config, err := loadConfig(*configFile, listenerConf, httpdir, redisdir, server.LimitConfig{
+		KVMaxSize:       *kvMaxSize,
+		KVMaxEntries:    *kvMaxEntries,
+		ShutdownTimeout: *shutdownTimeout,
+	}, *watchInterval)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	kv.Default.SetLimit(config.Limit.KVMaxSize, config.Limit.KVMaxEntries)
+
+	srv, err := server.NewServerFromConfig(config)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	// the vhosts are compiled once added, nothing is listened yet
+	if *check {
+		fmt.Printf("Config is valid, %d listener(s) and %d vhost(s)\n",
+			len(config.Listener), len(config.VHost))
 		return
 	}
 
-	for _, m := range httpdir {
-		manifest, err := manifest.NewManifestFromLocalDir(
-			m,
-			"http",
-		)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, err.Error())
-			return
-		}
+	if err := setupLog(&config.Log); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
 
-		if err := srv.AddVirtualHost(manifest); err != nil {
-			fmt.Fprintf(os.Stderr, err.Error())
-			return
-		}
+	if config.WatchInterval > 0 {
+		srv.WatchManifest(time.Duration(config.WatchInterval) * time.Second)
 	}
 
-	for _, m := range redisdir {
-		manifest, err := manifest.NewManifestFromLocalDir(
-			m,
-			"redis",
-		)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, err.Error())
-			return
+	// graceful shutdown on SIGINT/SIGTERM, the second signal exits at once
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		go func() {
+			<-sig
+			os.Exit(1)
+		}()
+		srv.Shutdown(time.Duration(config.Limit.ShutdownTimeout) * time.Second)
+	}()
+
+	// reload the manifests on SIGHUP, the running vhosts are kept on failure
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := srv.Reload(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			} else {
+				fmt.Printf("Server has been reloaded\n")
+			}
 		}
-		if err := srv.AddVirtualHost(manifest); err != nil {
-			fmt.Fprintf(os.Stderr, err.Error())
-			return
+	}()
+
+	// hand the listening sockets over to a new process on SIGUSR2, this one is
+	// shut down once the new one serves
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+	go func() {
+		for range usr2 {
+			if pid, err := srv.Restart(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			} else {
+				fmt.Printf("Server is restarting as process %d\n", pid)
+			}
 		}
-	}
+	}()
 
 	srv.Run()
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dianpeng/moons/repl"
+)
+
+// moons repl [-file name] [source]
+//
+// starts the interactive shell of PL, the main file of the manifest of the
+// source, or the file of the name, is loaded once given.
+
+func runRepl(args []string) int {
+	set := flag.NewFlagSet("repl", flag.ExitOnError)
+	file := set.String("file", "", "file of the manifest to load instead of the main file")
+	set.Parse(args)
+
+	r := repl.New(os.Stdout)
+	if set.NArg() > 0 {
+		if err := r.Load(set.Arg(0), *file); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return 1
+		}
+	}
+
+	fmt.Printf("PL shell, :help for the commands\n")
+	if err := r.Run(os.Stdin); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+	return 0
+}
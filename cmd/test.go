@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/dianpeng/moons/pltest"
+)
+
+// moons test [-run regexp] [-junit report.xml] path ...
+//
+// runs the test blocks of the PL files, the directory is expanded to the .pl
+// files directly inside of it, the file without any test is skipped. The exit
+// code is 1 once any test fails.
+
+func testFiles(paths []string) ([]string, error) {
+	o := []string{}
+	for _, x := range paths {
+		info, err := os.Stat(x)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			o = append(o, x)
+			continue
+		}
+		list, err := filepath.Glob(filepath.Join(x, "*.pl"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(list)
+		o = append(o, list...)
+	}
+	return o, nil
+}
+
+func runTests(args []string) int {
+	set := flag.NewFlagSet("test", flag.ExitOnError)
+	run := set.String("run", "", "run only the tests whose name matches the regexp")
+	junit := set.String("junit", "", "path of the JUnit XML report")
+	set.Parse(args)
+
+	if set.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "usage: moons test [flags] path ...\n")
+		set.PrintDefaults()
+		return 2
+	}
+
+	runner := pltest.NewRunner()
+	if *run != "" {
+		r, err := regexp.Compile(*run)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -run: %s\n", err.Error())
+			return 2
+		}
+		runner.Filter = r
+	}
+
+	files, err := testFiles(set.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 2
+	}
+
+	suites := []*pltest.Suite{}
+	passed := true
+	for _, f := range files {
+		s := runner.RunFile(f)
+		if s.Error == "" && len(s.Results) == 0 {
+			continue
+		}
+		passed = passed && s.Passed()
+		suites = append(suites, s)
+	}
+	pltest.WriteText(os.Stdout, suites)
+
+	if *junit != "" {
+		f, err := os.Create(*junit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return 2
+		}
+		defer f.Close()
+		if err := pltest.WriteJUnit(f, suites); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return 2
+		}
+	}
+
+	if !passed {
+		return 1
+	}
+	return 0
+}
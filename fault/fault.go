@@ -0,0 +1,276 @@
+package fault
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fault injection for the resilience testing of the services behind moons.
+// Each kind of fault is sampled on its own by its rate, in [0, 1]
+//
+//   delay     the request or the response is delayed by the latency plus a
+//             random jitter
+//   abort     the request is replied with the status at once
+//   reset     the connection is reset without any response
+//   throttle  the body is transferred at most by the bytes per second
+//
+// The injectors are registered by name, so the option can be changed at
+// runtime, ie by the admin API, and the faults are turned off by Disabled.
+
+const defaultAbortStatus = http.StatusServiceUnavailable
+
+type DelayOption struct {
+	Rate    float64 `json:"rate"`
+	Latency int64   `json:"latency"`
+	Jitter  int64   `json:"jitter"`
+}
+
+type AbortOption struct {
+	Rate   float64 `json:"rate"`
+	Status int     `json:"status"`
+	Body   string  `json:"body"`
+}
+
+type ResetOption struct {
+	Rate float64 `json:"rate"`
+}
+
+type ThrottleOption struct {
+	Rate           float64 `json:"rate"`
+	BytesPerSecond int64   `json:"bytes_per_second"`
+}
+
+// Option is the faults of the injector, the latency and jitter are in
+// millisecond. Once the header is set, only the request carrying it is faulted
+type Option struct {
+	Disabled bool            `json:"disabled"`
+	Header   string          `json:"header,omitempty"`
+	Delay    *DelayOption    `json:"delay,omitempty"`
+	Abort    *AbortOption    `json:"abort,omitempty"`
+	Reset    *ResetOption    `json:"reset,omitempty"`
+	Throttle *ThrottleOption `json:"throttle,omitempty"`
+}
+
+func checkRate(rate float64, name string) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("fault %s rate %v is not in [0, 1]", name, rate)
+	}
+	return nil
+}
+
+// Validate checks the option and fills the defaults
+func (o *Option) Validate() error {
+	if x := o.Delay; x != nil {
+		if err := checkRate(x.Rate, "delay"); err != nil {
+			return err
+		}
+		if x.Latency < 0 || x.Jitter < 0 {
+			return fmt.Errorf("fault delay latency and jitter must not be negative")
+		}
+	}
+	if x := o.Abort; x != nil {
+		if err := checkRate(x.Rate, "abort"); err != nil {
+			return err
+		}
+		if x.Status == 0 {
+			x.Status = defaultAbortStatus
+		}
+		if x.Status < 100 || x.Status > 599 {
+			return fmt.Errorf("fault abort status %d is invalid", x.Status)
+		}
+	}
+	if x := o.Reset; x != nil {
+		if err := checkRate(x.Rate, "reset"); err != nil {
+			return err
+		}
+	}
+	if x := o.Throttle; x != nil {
+		if err := checkRate(x.Rate, "throttle"); err != nil {
+			return err
+		}
+		if x.BytesPerSecond <= 0 {
+			return fmt.Errorf("fault throttle bytes_per_second must be positive")
+		}
+	}
+	return nil
+}
+
+// Plan is the faults sampled for one request
+type Plan struct {
+	Delay          time.Duration
+	Abort          bool
+	Status         int
+	Body           string
+	Reset          bool
+	BytesPerSecond int64
+}
+
+// Stats is the counters of the faults injected
+type Stats struct {
+	Delayed   int64 `json:"delayed"`
+	Aborted   int64 `json:"aborted"`
+	Reset     int64 `json:"reset"`
+	Throttled int64 `json:"throttled"`
+}
+
+type Injector struct {
+	// accessed atomically, kept first for the 64 bit alignment
+	delayed   int64
+	aborted   int64
+	reset     int64
+	throttled int64
+
+	name string
+	lock sync.RWMutex
+
+	// the option of the config and the one in effect, which differs once it is
+	// changed at runtime
+	configured Option
+	option     Option
+}
+
+func (i *Injector) Name() string {
+	return i.name
+}
+
+func (i *Injector) Option() Option {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	return i.option
+}
+
+// SetOption changes the faults in effect until the config of the injector
+// changes, ie by reload
+func (i *Injector) SetOption(option *Option) error {
+	o := *option
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.option = o
+	return nil
+}
+
+func (i *Injector) Stats() Stats {
+	return Stats{
+		Delayed:   atomic.LoadInt64(&i.delayed),
+		Aborted:   atomic.LoadInt64(&i.aborted),
+		Reset:     atomic.LoadInt64(&i.reset),
+		Throttled: atomic.LoadInt64(&i.throttled),
+	}
+}
+
+func hit(rate float64) bool {
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	default:
+		return rand.Float64() < rate
+	}
+}
+
+// Decide samples the faults of the request, nil if none is hit. The reset wins
+// over the abort since the request cannot be replied once reset
+func (i *Injector) Decide(r *http.Request) *Plan {
+	o := i.Option()
+	if o.Disabled {
+		return nil
+	}
+	if o.Header != "" && (r == nil || r.Header.Get(o.Header) == "") {
+		return nil
+	}
+
+	p := &Plan{}
+	faulted := false
+	if x := o.Delay; x != nil && hit(x.Rate) {
+		p.Delay = time.Duration(x.Latency) * time.Millisecond
+		if x.Jitter > 0 {
+			p.Delay += time.Duration(rand.Int63n(x.Jitter+1)) * time.Millisecond
+		}
+		atomic.AddInt64(&i.delayed, 1)
+		faulted = true
+	}
+	if x := o.Reset; x != nil && hit(x.Rate) {
+		p.Reset = true
+		atomic.AddInt64(&i.reset, 1)
+		faulted = true
+	} else if x := o.Abort; x != nil && hit(x.Rate) {
+		p.Abort = true
+		p.Status = x.Status
+		p.Body = x.Body
+		atomic.AddInt64(&i.aborted, 1)
+		faulted = true
+	}
+	if x := o.Throttle; x != nil && hit(x.Rate) {
+		p.BytesPerSecond = x.BytesPerSecond
+		atomic.AddInt64(&i.throttled, 1)
+		faulted = true
+	}
+	if !faulted {
+		return nil
+	}
+	return p
+}
+
+// injectors are registered by name so they can be controlled at runtime
+var (
+	registryLock sync.Mutex
+	registry     = make(map[string]*Injector)
+)
+
+// Register returns the injector of the name, created if not registered yet.
+// The option in effect of the registered one is replaced only when the option
+// of the config changes, so the one changed at runtime survives the services
+// created again with the same config
+func Register(name string, option *Option) (*Injector, error) {
+	o := *option
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if x, ok := registry[name]; ok {
+		x.lock.Lock()
+		if !reflect.DeepEqual(x.configured, o) {
+			x.configured = o
+			x.option = o
+		}
+		x.lock.Unlock()
+		return x, nil
+	}
+
+	x := &Injector{
+		name:       name,
+		configured: o,
+		option:     o,
+	}
+	registry[name] = x
+	return x, nil
+}
+
+func Find(name string) *Injector {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	return registry[name]
+}
+
+func Names() []string {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	o := make([]string, 0, len(registry))
+	for k := range registry {
+		o = append(o, k)
+	}
+	sort.Strings(o)
+	return o
+}
@@ -0,0 +1,44 @@
+package fault
+
+import (
+	"io"
+	"time"
+)
+
+// the body is read in slices of a tenth of the bandwidth, each slice waits
+// until the bytes read so far are due by the bandwidth
+type throttledBody struct {
+	io.ReadCloser
+	bps   int64
+	start time.Time
+	total int64
+}
+
+// Throttle limits the reading of the body by the bytes per second
+func Throttle(body io.ReadCloser, bps int64) io.ReadCloser {
+	return &throttledBody{
+		ReadCloser: body,
+		bps:        bps,
+	}
+}
+
+func (t *throttledBody) Read(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	slice := t.bps / 10
+	if slice <= 0 {
+		slice = 1
+	}
+	if int64(len(p)) > slice {
+		p = p[:slice]
+	}
+
+	n, err := t.ReadCloser.Read(p)
+	t.total += int64(n)
+	due := t.start.Add(time.Duration(t.total * int64(time.Second) / t.bps))
+	if wait := time.Until(due); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
@@ -12,6 +12,26 @@ const (
 	VHostHttpClientPoolTimeout      = 30
 	VHostHttpClientPoolMaxDrainSize = 4096
 
+	// pool of the redis backends of the redis vhost, the timeout is in seconds
+	VHostRedisClientPoolSize = 16
+	VHostRedisClientTimeout  = 5
+
+	// slowlog of the redis vhost, the threshold is in microseconds
+	VHostRedisSlowlogLogSlowerThan = 10000
+	VHostRedisSlowlogMaxLen        = 128
+
+	// seconds the in flight sessions are drained for once shutdown
+	ShutdownTimeout = 30
+
+	// bound of the process wide kv store shared by all vhosts
+	KVMaxSize    = 64 << 20
+	KVMaxEntries = 1 << 20
+
+	// bound of the manifest fetched from remote or unpacked from archive, the
+	// timeout is in seconds
+	ManifestMaxSize      = 64 << 20
+	ManifestFetchTimeout = 30
+
 	VHostLogFormat = "" +
 		"%START_TIME%" +
 		"%SERVICE_NAME%" +
@@ -19,5 +39,6 @@ const (
 		"%REQ(:STATUS_CODE)%" +
 		"%REQ(:PATH)%" +
 		"%RESP(:STATUS_CODE)%" +
-		"%CLIENT_IP%"
+		"%CLIENT_IP%" +
+		"%REQUEST_ID%"
 )
@@ -10,4 +10,5 @@ require (
 	github.com/stretchr/testify v1.7.1
 	github.com/tidwall/redcon v1.4.5
 	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
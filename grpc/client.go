@@ -0,0 +1,343 @@
+package grpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Unary gRPC client over http/2. The upstream is https, which negotiates h2
+// via ALPN, or http which speaks h2 with prior knowledge (h2c)
+
+// status code of gRPC
+const (
+	CodeOK                 = 0
+	CodeCanceled           = 1
+	CodeUnknown            = 2
+	CodeInvalidArgument    = 3
+	CodeDeadlineExceeded   = 4
+	CodeNotFound           = 5
+	CodeAlreadyExists      = 6
+	CodePermissionDenied   = 7
+	CodeResourceExhausted  = 8
+	CodeFailedPrecondition = 9
+	CodeAborted            = 10
+	CodeOutOfRange         = 11
+	CodeUnimplemented      = 12
+	CodeInternal           = 13
+	CodeUnavailable        = 14
+	CodeDataLoss           = 15
+	CodeUnauthenticated    = 16
+)
+
+const (
+	maxResponseSize = 64 << 20
+)
+
+type Client struct {
+	target   *url.URL
+	registry *Registry
+	client   *http.Client
+}
+
+// result of a call, the Response is only valid when the Code is CodeOK
+type Result struct {
+	Code     int
+	Message  string
+	Response map[string]interface{}
+	Header   http.Header
+	Trailer  http.Header
+}
+
+// creates the client of the target, ie https://host:port or http://host:port.
+// The transport is used as is for https, and is switched to h2c for http
+func NewClient(target string, registry *Registry, transport *http.Transport) (*Client, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("grpc: target %s has no host", target)
+	}
+
+	if transport == nil {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+
+	switch u.Scheme {
+	case "https":
+		transport.ForceAttemptHTTP2 = true
+	case "http":
+		if err := enableH2C(transport); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("grpc: unsupported target scheme %s", u.Scheme)
+	}
+
+	return &Client{
+		target:   u,
+		registry: registry,
+		client: &http.Client{
+			Transport: transport,
+		},
+	}, nil
+}
+
+func (c *Client) Registry() *Registry {
+	return c.registry
+}
+
+// grpc-timeout header value, the unit is chosen to keep the value within 8
+// digits
+func encodeTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "1n"
+	}
+	units := []struct {
+		unit string
+		dur  time.Duration
+	}{
+		{"n", time.Nanosecond},
+		{"u", time.Microsecond},
+		{"m", time.Millisecond},
+		{"S", time.Second},
+		{"M", time.Minute},
+		{"H", time.Hour},
+	}
+	for _, x := range units {
+		v := d / x.dur
+		if v < 100000000 {
+			if d%x.dur != 0 {
+				v++
+			}
+			return strconv.FormatInt(int64(v), 10) + x.unit
+		}
+	}
+	return "99999999H"
+}
+
+func frame(payload []byte) []byte {
+	b := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(payload)))
+	copy(b[5:], payload)
+	return b
+}
+
+// reads one length prefixed message, io.EOF when the body is drained
+func readFrame(r io.Reader, encoding string) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("grpc: truncated response frame")
+		}
+		return nil, err
+	}
+	sz := binary.BigEndian.Uint32(hdr[1:])
+	if sz > maxResponseSize {
+		return nil, fmt.Errorf("grpc: response message too large")
+	}
+	payload := make([]byte, sz)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("grpc: truncated response frame")
+	}
+
+	if hdr[0] == 0 {
+		return payload, nil
+	}
+	if encoding != "gzip" {
+		return nil, fmt.Errorf("grpc: unsupported message encoding %s", encoding)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(io.LimitReader(zr, maxResponseSize))
+}
+
+// status is in the trailer, or in the header for trailers only response
+func status(resp *http.Response) (int, string, bool) {
+	for _, h := range []http.Header{resp.Trailer, resp.Header} {
+		if s := h.Get("Grpc-Status"); s != "" {
+			code, err := strconv.Atoi(s)
+			if err != nil {
+				return CodeUnknown, "invalid grpc-status " + s, true
+			}
+			msg, err := url.PathUnescape(h.Get("Grpc-Message"))
+			if err != nil {
+				msg = h.Get("Grpc-Message")
+			}
+			return code, msg, true
+		}
+	}
+	return 0, "", false
+}
+
+// httpStatusCode maps the http status of a non gRPC response, see the gRPC
+// http to gRPC status code mapping
+func httpStatusCode(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInternal
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodePermissionDenied
+	case http.StatusNotFound:
+		return CodeUnimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return CodeUnavailable
+	default:
+		return CodeUnknown
+	}
+}
+
+// Call issues the unary method with the request, the metadata is sent as
+// request header. The error is returned only when the call cannot be made,
+// ie bad request or network failure, the gRPC error status is in the result
+func (c *Client) Call(ctx context.Context, method string, request map[string]interface{}, metadata http.Header, timeout time.Duration) (*Result, error) {
+	md := c.registry.Method(method)
+	if md == nil {
+		return nil, fmt.Errorf("grpc: method %s is unknown", method)
+	}
+	if md.ClientStreaming || md.ServerStreaming {
+		return nil, fmt.Errorf("grpc: method %s is streaming, only unary method is supported", method)
+	}
+
+	payload, err := Marshal(md.Input, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	u := *c.target
+	u.Path = strings.TrimSuffix(u.Path, "/") + md.Path
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(frame(payload)))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range metadata {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Set("Te", "trailers")
+	req.Header.Set("Grpc-Accept-Encoding", "gzip")
+	if timeout > 0 {
+		req.Header.Set("Grpc-Timeout", encodeTimeout(timeout))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return &Result{
+				Code:    CodeDeadlineExceeded,
+				Message: err.Error(),
+				Header:  make(http.Header),
+				Trailer: make(http.Header),
+			}, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	r := &Result{
+		Header: resp.Header,
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		r.Code = httpStatusCode(resp.StatusCode)
+		r.Message = fmt.Sprintf("unexpected http status %d", resp.StatusCode)
+		r.Trailer = make(http.Header)
+		return r, nil
+	}
+
+	msg, readErr := readFrame(resp.Body, resp.Header.Get("Grpc-Encoding"))
+	if readErr == nil {
+		// unary response has exactly one message, drains the body to get the
+		// trailer
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			readErr = err
+		}
+	}
+	r.Trailer = resp.Trailer
+	if r.Trailer == nil {
+		r.Trailer = make(http.Header)
+	}
+
+	code, message, ok := status(resp)
+	switch {
+	case ok:
+		r.Code, r.Message = code, message
+	case ctx.Err() == context.DeadlineExceeded:
+		r.Code, r.Message = CodeDeadlineExceeded, ctx.Err().Error()
+		return r, nil
+	case readErr != nil && readErr != io.EOF:
+		return nil, readErr
+	default:
+		r.Code, r.Message = CodeInternal, "missing grpc-status"
+	}
+
+	if r.Code != CodeOK {
+		return r, nil
+	}
+	if readErr == io.EOF {
+		r.Code, r.Message = CodeInternal, "missing response message"
+		return r, nil
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	if r.Response, err = Unmarshal(md.Output, msg); err != nil {
+		r.Code, r.Message = CodeInternal, err.Error()
+		r.Response = nil
+	}
+	return r, nil
+}
+
+// HTTPStatus maps the gRPC status code to http status, used by gateway to
+// reply the downstream
+func HTTPStatus(code int) int {
+	switch code {
+	case CodeOK:
+		return http.StatusOK
+	case CodeCanceled:
+		return 499
+	case CodeInvalidArgument, CodeFailedPrecondition, CodeOutOfRange:
+		return http.StatusBadRequest
+	case CodeDeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeAlreadyExists, CodeAborted:
+		return http.StatusConflict
+	case CodePermissionDenied:
+		return http.StatusForbidden
+	case CodeResourceExhausted:
+		return http.StatusTooManyRequests
+	case CodeUnimplemented:
+		return http.StatusNotImplemented
+	case CodeUnavailable:
+		return http.StatusServiceUnavailable
+	case CodeUnauthenticated:
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
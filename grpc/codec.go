@@ -0,0 +1,668 @@
+package grpc
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Dynamic codec between protobuf message and generic go value following the
+// protobuf JSON mapping, ie the keys are the json names, enums are names and
+// bytes are base64 strings. The generic values are the ones produced by
+// encoding/json, except that integers are int64. When decoding, the fields
+// without presence are filled with their default value so the output has a
+// stable shape
+
+// Marshal encodes the map as the message, both json name and proto name are
+// accepted as key, null value means the field is not set
+func Marshal(m *MessageDesc, v map[string]interface{}) ([]byte, error) {
+	return marshalMessage(nil, m, v, m.FullName)
+}
+
+func marshalMessage(b []byte, m *MessageDesc, v map[string]interface{}, path string) ([]byte, error) {
+	// encodes in field number order to make the output deterministic
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		fi, fj := m.FieldByName(keys[i]), m.FieldByName(keys[j])
+		if fi == nil || fj == nil {
+			return keys[i] < keys[j]
+		}
+		return fi.Number < fj.Number
+	})
+
+	var err error
+	for _, k := range keys {
+		f := m.FieldByName(k)
+		if f == nil {
+			return nil, fmt.Errorf("protobuf: %s has no field %s", path, k)
+		}
+		x := v[k]
+		if x == nil {
+			continue
+		}
+		fp := path + "." + k
+		switch {
+		case f.IsMap():
+			b, err = marshalMap(b, f, x, fp)
+		case f.Repeated:
+			b, err = marshalRepeated(b, f, x, fp)
+		default:
+			b, err = marshalSingular(b, f, x, fp)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func marshalRepeated(b []byte, f *FieldDesc, x interface{}, path string) ([]byte, error) {
+	l, ok := x.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("protobuf: %s must be list", path)
+	}
+
+	if f.Packed {
+		var payload []byte
+		for idx, e := range l {
+			var err error
+			if payload, _, err = appendScalar(payload, f, e, fmt.Sprintf("%s[%d]", path, idx)); err != nil {
+				return nil, err
+			}
+		}
+		if len(payload) == 0 {
+			return b, nil
+		}
+		b = appendTag(b, f.Number, wireBytes)
+		return appendBytes(b, payload), nil
+	}
+
+	for idx, e := range l {
+		if e == nil {
+			return nil, fmt.Errorf("protobuf: %s[%d] cannot be null", path, idx)
+		}
+		var err error
+		if b, err = appendField(b, f, e, fmt.Sprintf("%s[%d]", path, idx)); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func marshalMap(b []byte, f *FieldDesc, x interface{}, path string) ([]byte, error) {
+	mv, ok := x.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("protobuf: %s must be map", path)
+	}
+	kf, vf := f.Message.FieldByNumber(1), f.Message.FieldByNumber(2)
+	if kf == nil || vf == nil {
+		return nil, fmt.Errorf("protobuf: %s invalid map entry", path)
+	}
+
+	keys := make([]string, 0, len(mv))
+	for k := range mv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		key, err := parseMapKey(kf, k)
+		if err != nil {
+			return nil, fmt.Errorf("protobuf: %s invalid key %s: %s", path, k, err.Error())
+		}
+		entry, err := appendField(nil, kf, key, path)
+		if err != nil {
+			return nil, err
+		}
+		if mv[k] != nil {
+			if entry, err = appendField(entry, vf, mv[k], path+"."+k); err != nil {
+				return nil, err
+			}
+		}
+		b = appendTag(b, f.Number, wireBytes)
+		b = appendBytes(b, entry)
+	}
+	return b, nil
+}
+
+// map key is always string in the generic value, converts it back
+func parseMapKey(f *FieldDesc, k string) (interface{}, error) {
+	switch f.Type {
+	case TypeString:
+		return k, nil
+	case TypeBool:
+		return strconv.ParseBool(k)
+	default:
+		return k, nil
+	}
+}
+
+func marshalSingular(b []byte, f *FieldDesc, x interface{}, path string) ([]byte, error) {
+	if f.HasPresence {
+		return appendField(b, f, x, path)
+	}
+
+	// the default value is not encoded for field without presence
+	o, err := appendField(b, f, x, path)
+	if err != nil {
+		return nil, err
+	}
+	if isDefault(f, x) {
+		return b, nil
+	}
+	return o, nil
+}
+
+func isDefault(f *FieldDesc, x interface{}) bool {
+	switch v := x.(type) {
+	case bool:
+		return !v
+	case string:
+		if f.Type == TypeEnum {
+			n, ok := f.Enum.Value(v)
+			return ok && n == 0
+		}
+		return v == "" && (f.Type == TypeString || f.Type == TypeBytes)
+	case int64:
+		return v == 0
+	case int:
+		return v == 0
+	case float64:
+		return v == 0 && !math.Signbit(v)
+	}
+	return false
+}
+
+// appends the tag and the value of a non packed field
+func appendField(b []byte, f *FieldDesc, x interface{}, path string) ([]byte, error) {
+	switch f.Type {
+	case TypeMessage:
+		mv, ok := x.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("protobuf: %s must be map", path)
+		}
+		payload, err := marshalMessage(nil, f.Message, mv, path)
+		if err != nil {
+			return nil, err
+		}
+		b = appendTag(b, f.Number, wireBytes)
+		return appendBytes(b, payload), nil
+
+	case TypeGroup:
+		mv, ok := x.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("protobuf: %s must be map", path)
+		}
+		b = appendTag(b, f.Number, wireStartGr)
+		b, err := marshalMessage(b, f.Message, mv, path)
+		if err != nil {
+			return nil, err
+		}
+		return appendTag(b, f.Number, wireEndGr), nil
+
+	case TypeString:
+		s, ok := x.(string)
+		if !ok {
+			return nil, fmt.Errorf("protobuf: %s must be string", path)
+		}
+		b = appendTag(b, f.Number, wireBytes)
+		return appendBytes(b, []byte(s)), nil
+
+	case TypeBytes:
+		s, ok := x.(string)
+		if !ok {
+			return nil, fmt.Errorf("protobuf: %s must be base64 string", path)
+		}
+		data, err := decodeBase64(s)
+		if err != nil {
+			return nil, fmt.Errorf("protobuf: %s must be base64 string", path)
+		}
+		b = appendTag(b, f.Number, wireBytes)
+		return appendBytes(b, data), nil
+	}
+
+	// scalars
+	tagPos := len(b)
+	b = appendTag(b, f.Number, 0)
+	b, wt, err := appendScalar(b, f, x, path)
+	if err != nil {
+		return nil, err
+	}
+	// patches the wire type, the tag is a varint whose lowest 3 bits is the
+	// wire type and sits in the first byte
+	b[tagPos] |= byte(wt)
+	return b, nil
+}
+
+// appends the scalar value without tag, returns the wire type
+func appendScalar(b []byte, f *FieldDesc, x interface{}, path string) ([]byte, int, error) {
+	switch f.Type {
+	case TypeBool:
+		v, ok := x.(bool)
+		if !ok {
+			return nil, 0, fmt.Errorf("protobuf: %s must be bool", path)
+		}
+		if v {
+			return appendVarint(b, 1), wireVarint, nil
+		}
+		return appendVarint(b, 0), wireVarint, nil
+
+	case TypeEnum:
+		v, err := enumValue(f.Enum, x)
+		if err != nil {
+			return nil, 0, fmt.Errorf("protobuf: %s %s", path, err.Error())
+		}
+		return appendVarint(b, uint64(int64(v))), wireVarint, nil
+
+	case TypeFloat, TypeDouble:
+		v, err := toFloat(x)
+		if err != nil {
+			return nil, 0, fmt.Errorf("protobuf: %s %s", path, err.Error())
+		}
+		if f.Type == TypeFloat {
+			return appendFixed32(b, math.Float32bits(float32(v))), wireFixed32, nil
+		}
+		return appendFixed64(b, math.Float64bits(v)), wireFixed64, nil
+	}
+
+	v, err := toInt(x, f.Type)
+	if err != nil {
+		return nil, 0, fmt.Errorf("protobuf: %s %s", path, err.Error())
+	}
+	switch f.Type {
+	case TypeInt32, TypeInt64, TypeUint32, TypeUint64:
+		return appendVarint(b, uint64(v)), wireVarint, nil
+	case TypeSint32:
+		return appendVarint(b, zigzag32(v)), wireVarint, nil
+	case TypeSint64:
+		return appendVarint(b, zigzag64(v)), wireVarint, nil
+	case TypeFixed32, TypeSfixed32:
+		return appendFixed32(b, uint32(v)), wireFixed32, nil
+	case TypeFixed64, TypeSfixed64:
+		return appendFixed64(b, uint64(v)), wireFixed64, nil
+	}
+	return nil, 0, fmt.Errorf("protobuf: %s has unsupported type %d", path, f.Type)
+}
+
+func enumValue(e *EnumDesc, x interface{}) (int32, error) {
+	switch v := x.(type) {
+	case string:
+		n, ok := e.Value(v)
+		if !ok {
+			return 0, fmt.Errorf("unknown enum value %s of %s", v, e.FullName)
+		}
+		return n, nil
+	case int64:
+		return int32(v), nil
+	case int:
+		return int32(v), nil
+	case float64:
+		if v == math.Trunc(v) {
+			return int32(v), nil
+		}
+	}
+	return 0, fmt.Errorf("must be enum name or number")
+}
+
+func toFloat(x interface{}) (float64, error) {
+	switch v := x.(type) {
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case string:
+		switch v {
+		case "NaN":
+			return math.NaN(), nil
+		case "Infinity":
+			return math.Inf(1), nil
+		case "-Infinity":
+			return math.Inf(-1), nil
+		}
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("must be number")
+}
+
+// integer in range of the type, 64 bits integer can be string as the JSON
+// mapping does
+func toInt(x interface{}, t int) (int64, error) {
+	var v int64
+	unsigned := t == TypeUint32 || t == TypeUint64 || t == TypeFixed32 || t == TypeFixed64
+
+	switch xv := x.(type) {
+	case int64:
+		v = xv
+	case int:
+		v = int64(xv)
+	case float64:
+		if xv != math.Trunc(xv) {
+			return 0, fmt.Errorf("must be integer")
+		}
+		v = int64(xv)
+	case string:
+		var err error
+		if unsigned {
+			var u uint64
+			u, err = strconv.ParseUint(xv, 10, 64)
+			v = int64(u)
+		} else {
+			v, err = strconv.ParseInt(xv, 10, 64)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("must be integer")
+		}
+	default:
+		return 0, fmt.Errorf("must be integer")
+	}
+
+	switch t {
+	case TypeInt32, TypeSint32, TypeSfixed32:
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return 0, fmt.Errorf("out of int32 range")
+		}
+	case TypeUint32, TypeFixed32:
+		if v < 0 || v > math.MaxUint32 {
+			return 0, fmt.Errorf("out of uint32 range")
+		}
+	case TypeUint64, TypeFixed64:
+		if _, ok := x.(string); !ok && v < 0 {
+			return 0, fmt.Errorf("out of uint64 range")
+		}
+	}
+	return v, nil
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding,
+		base64.URLEncoding,
+		base64.RawStdEncoding,
+		base64.RawURLEncoding,
+	} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid base64")
+}
+
+// decoding ----------------------------------------------------------------------
+
+// Unmarshal decodes the message into map keyed by json name, unknown fields
+// are dropped
+func Unmarshal(m *MessageDesc, data []byte) (map[string]interface{}, error) {
+	d := &decoder{buf: data}
+	return unmarshalMessage(d, m, -1)
+}
+
+// decodes until the buffer is drained, or the end group tag when group is not
+// negative
+func unmarshalMessage(d *decoder, m *MessageDesc, group int) (map[string]interface{}, error) {
+	o := make(map[string]interface{})
+
+	for !d.eof() {
+		num, wt, err := d.tag()
+		if err != nil {
+			return nil, err
+		}
+		if wt == wireEndGr {
+			if num != group {
+				return nil, fmt.Errorf("protobuf: mismatched group end")
+			}
+			fillDefault(m, o)
+			return o, nil
+		}
+
+		f := m.FieldByNumber(num)
+		if f == nil {
+			if err := d.skip(num, wt); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		switch {
+		case f.IsMap():
+			err = unmarshalMapEntry(d, f, wt, o)
+		case f.Repeated:
+			err = unmarshalRepeated(d, f, wt, o)
+		default:
+			var v interface{}
+			if v, err = unmarshalValue(d, f, wt); err == nil {
+				// the message field which appears several times is merged
+				if old, ok := o[f.JSONName].(map[string]interface{}); ok && f.Message != nil {
+					mergeMessage(old, v.(map[string]interface{}))
+				} else {
+					o[f.JSONName] = v
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if group >= 0 {
+		return nil, errTruncated
+	}
+	fillDefault(m, o)
+	return o, nil
+}
+
+func mergeMessage(dst map[string]interface{}, src map[string]interface{}) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+func fillDefault(m *MessageDesc, o map[string]interface{}) {
+	for _, f := range m.Fields {
+		if _, ok := o[f.JSONName]; ok {
+			continue
+		}
+		switch {
+		case f.IsMap():
+			o[f.JSONName] = map[string]interface{}{}
+		case f.Repeated:
+			o[f.JSONName] = []interface{}{}
+		case f.HasPresence:
+			break
+		default:
+			o[f.JSONName] = defaultValue(f)
+		}
+	}
+}
+
+func defaultValue(f *FieldDesc) interface{} {
+	switch f.Type {
+	case TypeBool:
+		return false
+	case TypeString, TypeBytes:
+		return ""
+	case TypeFloat, TypeDouble:
+		return float64(0)
+	case TypeEnum:
+		if n, ok := f.Enum.Name(0); ok {
+			return n
+		}
+		return int64(0)
+	default:
+		return int64(0)
+	}
+}
+
+func unmarshalRepeated(d *decoder, f *FieldDesc, wt int, o map[string]interface{}) error {
+	l, _ := o[f.JSONName].([]interface{})
+
+	// packed scalars, accepted regardless of the packed option
+	isScalar := f.Type != TypeString && f.Type != TypeBytes &&
+		f.Type != TypeMessage && f.Type != TypeGroup
+	if isScalar && wt == wireBytes {
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		pd := &decoder{buf: b}
+		swt := scalarWireType(f.Type)
+		for !pd.eof() {
+			v, err := unmarshalValue(pd, f, swt)
+			if err != nil {
+				return err
+			}
+			l = append(l, v)
+		}
+		o[f.JSONName] = l
+		return nil
+	}
+
+	v, err := unmarshalValue(d, f, wt)
+	if err != nil {
+		return err
+	}
+	o[f.JSONName] = append(l, v)
+	return nil
+}
+
+func unmarshalMapEntry(d *decoder, f *FieldDesc, wt int, o map[string]interface{}) error {
+	if wt != wireBytes {
+		return fmt.Errorf("protobuf: field %s wire type mismatch", f.Name)
+	}
+	b, err := d.bytes()
+	if err != nil {
+		return err
+	}
+	entry, err := unmarshalMessage(&decoder{buf: b}, f.Message, -1)
+	if err != nil {
+		return err
+	}
+
+	mv, ok := o[f.JSONName].(map[string]interface{})
+	if !ok {
+		mv = make(map[string]interface{})
+		o[f.JSONName] = mv
+	}
+
+	kf, vf := f.Message.FieldByNumber(1), f.Message.FieldByNumber(2)
+	if kf == nil || vf == nil {
+		return fmt.Errorf("protobuf: field %s invalid map entry", f.Name)
+	}
+	key := fmt.Sprint(entry[kf.JSONName])
+	mv[key] = entry[vf.JSONName]
+	return nil
+}
+
+func scalarWireType(t int) int {
+	switch t {
+	case TypeFixed32, TypeSfixed32, TypeFloat:
+		return wireFixed32
+	case TypeFixed64, TypeSfixed64, TypeDouble:
+		return wireFixed64
+	default:
+		return wireVarint
+	}
+}
+
+func unmarshalValue(d *decoder, f *FieldDesc, wt int) (interface{}, error) {
+	expect := wireBytes
+	switch f.Type {
+	case TypeMessage, TypeString, TypeBytes:
+		break
+	case TypeGroup:
+		expect = wireStartGr
+	default:
+		expect = scalarWireType(f.Type)
+	}
+	if wt != expect {
+		return nil, fmt.Errorf("protobuf: field %s wire type mismatch", f.Name)
+	}
+
+	switch f.Type {
+	case TypeMessage:
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return unmarshalMessage(&decoder{buf: b}, f.Message, -1)
+	case TypeGroup:
+		return unmarshalMessage(d, f.Message, f.Number)
+	case TypeString:
+		b, err := d.bytes()
+		return string(b), err
+	case TypeBytes:
+		b, err := d.bytes()
+		return base64.StdEncoding.EncodeToString(b), err
+	}
+
+	switch wt {
+	case wireFixed32:
+		v, err := d.fixed32()
+		if err != nil {
+			return nil, err
+		}
+		switch f.Type {
+		case TypeFloat:
+			return float64(math.Float32frombits(v)), nil
+		case TypeSfixed32:
+			return int64(int32(v)), nil
+		default:
+			return int64(v), nil
+		}
+
+	case wireFixed64:
+		v, err := d.fixed64()
+		if err != nil {
+			return nil, err
+		}
+		switch f.Type {
+		case TypeDouble:
+			return math.Float64frombits(v), nil
+		case TypeFixed64:
+			return unsigned64(v), nil
+		default:
+			return int64(v), nil
+		}
+	}
+
+	v, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	switch f.Type {
+	case TypeBool:
+		return v != 0, nil
+	case TypeInt32:
+		return int64(int32(v)), nil
+	case TypeUint32:
+		return int64(uint32(v)), nil
+	case TypeSint32:
+		return int64(int32(unzigzag(v))), nil
+	case TypeSint64:
+		return unzigzag(v), nil
+	case TypeEnum:
+		if n, ok := f.Enum.Name(int32(v)); ok {
+			return n, nil
+		}
+		return int64(int32(v)), nil
+	case TypeUint64:
+		return unsigned64(v), nil
+	default:
+		return int64(v), nil
+	}
+}
+
+// unsigned 64 bits value beyond int64 is kept as decimal string, which is
+// what the JSON mapping uses for 64 bits integer
+func unsigned64(v uint64) interface{} {
+	if v > math.MaxInt64 {
+		return strconv.FormatUint(v, 10)
+	}
+	return int64(v)
+}
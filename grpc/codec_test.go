@@ -0,0 +1,462 @@
+package grpc
+
+import (
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testdata/test.pb is the descriptor set of testdata/test.proto and its
+// import testdata/common.proto, in the form of
+//   protoc --include_imports --descriptor_set_out=test.pb test.proto
+// ie the dependency comes first, json_name is set and the proto3 optional
+// field has its synthetic oneof
+
+func testRegistry(t *testing.T) *Registry {
+	r, err := LoadRegistryFromFile("testdata/test.pb")
+	if err != nil {
+		t.Fatalf("load registry: %s", err.Error())
+	}
+	return r
+}
+
+func TestRegistry(t *testing.T) {
+	assert := assert.New(t)
+	r := testRegistry(t)
+
+	methods := r.Methods()
+	sort.Strings(methods)
+	assert.Equal([]string{"test.Store/Get", "test.Store/Upload", "test.Store/Watch"}, methods)
+
+	for _, n := range []string{"test.Store/Get", "/test.Store/Get", "test.Store.Get"} {
+		m := r.Method(n)
+		if assert.NotNil(m, n) {
+			assert.Equal("/test.Store/Get", m.Path)
+			assert.Equal("test.Item", m.Input.FullName)
+			assert.Equal("test.Item", m.Output.FullName)
+			assert.False(m.ClientStreaming)
+			assert.False(m.ServerStreaming)
+		}
+	}
+	assert.True(r.Method("test.Store/Watch").ServerStreaming)
+	assert.True(r.Method("test.Store/Upload").ClientStreaming)
+	assert.Nil(r.Method("test.Store/Nope"))
+
+	item := r.Message(".test.Item")
+	if !assert.NotNil(item) {
+		return
+	}
+	assert.True(item == r.Message("test.Item"))
+	assert.NotNil(r.Message("test.Item.Tag"))
+	assert.NotNil(r.Message("common.Meta.Extra"))
+	assert.NotNil(r.Enum("test.Color"))
+
+	cases := []struct {
+		name     string
+		json     string
+		repeated bool
+		packed   bool
+		presence bool
+		isMap    bool
+	}{
+		{"id", "id", false, false, false, false},
+		{"counts", "counts", true, true, false, false},
+		{"names", "names", true, false, false, false},
+		{"tags", "tags", true, false, false, false},
+		{"attrs", "attrs", true, false, false, true},
+		{"limit", "limit", false, false, true, false},
+		{"text", "text", false, false, true, false},
+		{"meta", "meta", false, false, true, false},
+		{"unpacked", "unpacked", true, false, false, false},
+		{"color", "color", false, false, false, false},
+	}
+	for _, c := range cases {
+		f := item.FieldByName(c.name)
+		if !assert.NotNil(f, c.name) {
+			continue
+		}
+		assert.Equal(c.json, f.JSONName, c.name)
+		assert.Equal(c.repeated, f.Repeated, c.name)
+		assert.Equal(c.packed, f.Packed, c.name)
+		assert.Equal(c.presence, f.HasPresence, c.name)
+		assert.Equal(c.isMap, f.IsMap(), c.name)
+	}
+
+	scalars := r.Message("test.Scalars")
+	assert.True(scalars.FieldByName("f_sint64") == scalars.FieldByName("fSint64"))
+	assert.Equal(18, scalars.FieldByName("fSint64").Number)
+
+	meta := r.Message("common.Meta")
+	assert.True(meta.FieldByName("version").HasPresence)
+	assert.False(meta.FieldByName("ids").Packed)
+	assert.Equal(TypeGroup, meta.FieldByName("extra").Type)
+}
+
+func TestRegistryError(t *testing.T) {
+	_, err := NewRegistry([]byte{0x0a, 0x05, 0x01})
+	assert.NotNil(t, err)
+
+	// field of unknown type
+	data := []byte{}
+	field := appendBytes(appendTag(nil, 1, wireBytes), []byte("x"))
+	field = appendVarint(appendTag(field, 3, wireVarint), 1)
+	field = appendVarint(appendTag(field, 5, wireVarint), TypeMessage)
+	field = appendBytes(appendTag(field, 6, wireBytes), []byte(".nope.Nope"))
+	msg := appendBytes(appendTag(nil, 1, wireBytes), []byte("M"))
+	msg = appendBytes(appendTag(msg, 2, wireBytes), field)
+	file := appendBytes(appendTag(nil, 4, wireBytes), msg)
+	data = appendBytes(appendTag(data, 1, wireBytes), file)
+	_, err = NewRegistry(data)
+	assert.NotNil(t, err)
+}
+
+// the encoding is checked against the bytes of the reference implementation
+func TestMarshalWire(t *testing.T) {
+	r := testRegistry(t)
+	scalars := r.Message("test.Scalars")
+	item := r.Message("test.Item")
+	meta := r.Message("common.Meta")
+
+	cases := []struct {
+		name string
+		m    *MessageDesc
+		v    map[string]interface{}
+		wire []byte
+	}{
+		{"int32", scalars, map[string]interface{}{"fInt32": int64(150)}, []byte{0x28, 0x96, 0x01}},
+		{"negative int32", scalars, map[string]interface{}{"fInt32": int64(-1)},
+			[]byte{0x28, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"string", scalars, map[string]interface{}{"f_string": "testing"},
+			[]byte{0x4a, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g'}},
+		{"sint32", scalars, map[string]interface{}{"fSint32": int64(-1)}, []byte{0x88, 0x01, 0x01}},
+		{"sint64", scalars, map[string]interface{}{"fSint64": int64(-2)}, []byte{0x90, 0x01, 0x03}},
+		{"fixed32", scalars, map[string]interface{}{"fFixed32": int64(1)}, []byte{0x3d, 0x01, 0x00, 0x00, 0x00}},
+		{"double", scalars, map[string]interface{}{"fDouble": 1.0},
+			[]byte{0x09, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f}},
+		{"bool", scalars, map[string]interface{}{"fBool": true}, []byte{0x40, 0x01}},
+		{"enum", scalars, map[string]interface{}{"fEnum": "BLUE"}, []byte{0x70, 0x02}},
+		{"bytes", scalars, map[string]interface{}{"fBytes": "AQI="}, []byte{0x62, 0x02, 0x01, 0x02}},
+		{"default omitted", scalars, map[string]interface{}{
+			"fInt32": int64(0), "fString": "", "fBool": false, "fEnum": "RED", "fDouble": 0.0,
+		}, nil},
+		{"null omitted", scalars, map[string]interface{}{"fInt32": nil}, nil},
+		{"packed", item, map[string]interface{}{"counts": []interface{}{int64(3), int64(270), int64(86942)}},
+			[]byte{0x12, 0x06, 0x03, 0x8e, 0x02, 0x9e, 0xa7, 0x05}},
+		{"packed empty", item, map[string]interface{}{"counts": []interface{}{}}, nil},
+		{"unpacked", item, map[string]interface{}{"unpacked": []interface{}{int64(1), int64(2)}},
+			[]byte{0x58, 0x01, 0x58, 0x02}},
+		{"proto3 optional zero", item, map[string]interface{}{"limit": int64(0)}, []byte{0x38, 0x00}},
+		{"oneof zero", item, map[string]interface{}{"number": int64(0)}, []byte{0x48, 0x00}},
+		{"nested", item, map[string]interface{}{"tags": []interface{}{
+			map[string]interface{}{"name": "a"},
+		}}, []byte{0x22, 0x03, 0x0a, 0x01, 'a'}},
+		{"map", item, map[string]interface{}{"attrs": map[string]interface{}{"k": int64(1)}},
+			[]byte{0x2a, 0x05, 0x0a, 0x01, 'k', 0x10, 0x01}},
+		{"proto2 required and unpacked", meta, map[string]interface{}{
+			"owner": "", "ids": []interface{}{int64(1), int64(2)},
+		}, []byte{0x0a, 0x00, 0x18, 0x01, 0x18, 0x02}},
+		{"group", meta, map[string]interface{}{"extra": map[string]interface{}{"note": "n"}},
+			[]byte{0x23, 0x2a, 0x01, 'n', 0x24}},
+	}
+
+	for _, c := range cases {
+		b, err := Marshal(c.m, c.v)
+		if assert.Nil(t, err, c.name) {
+			assert.Equal(t, c.wire, b, c.name)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	r := testRegistry(t)
+
+	cases := []struct {
+		name string
+		m    string
+		in   map[string]interface{}
+		out  map[string]interface{}
+	}{
+		{
+			name: "scalars",
+			m:    "test.Scalars",
+			in: map[string]interface{}{
+				"fDouble":   1.5,
+				"fFloat":    0.25,
+				"fInt64":    "-9007199254740993",
+				"fUint64":   "18446744073709551615",
+				"fInt32":    int64(math.MinInt32),
+				"fFixed64":  int64(7),
+				"fFixed32":  int64(math.MaxUint32),
+				"fBool":     true,
+				"fString":   "héllo",
+				"fBytes":    "aGVsbG8",
+				"fUint32":   int64(math.MaxUint32),
+				"fEnum":     int64(1),
+				"fSfixed32": int64(-3),
+				"fSfixed64": int64(-4),
+				"fSint32":   int64(math.MinInt32),
+				"fSint64":   int64(math.MinInt64),
+			},
+			out: map[string]interface{}{
+				"fDouble":   1.5,
+				"fFloat":    0.25,
+				"fInt64":    int64(-9007199254740993),
+				"fUint64":   "18446744073709551615",
+				"fInt32":    int64(math.MinInt32),
+				"fFixed64":  int64(7),
+				"fFixed32":  int64(math.MaxUint32),
+				"fBool":     true,
+				"fString":   "héllo",
+				"fBytes":    "aGVsbG8=",
+				"fUint32":   int64(math.MaxUint32),
+				"fEnum":     "GREEN",
+				"fSfixed32": int64(-3),
+				"fSfixed64": int64(-4),
+				"fSint32":   int64(math.MinInt32),
+				"fSint64":   int64(math.MinInt64),
+			},
+		},
+		{
+			name: "defaults",
+			m:    "test.Scalars",
+			in:   map[string]interface{}{},
+			out: map[string]interface{}{
+				"fDouble":   0.0,
+				"fFloat":    0.0,
+				"fInt64":    int64(0),
+				"fUint64":   int64(0),
+				"fInt32":    int64(0),
+				"fFixed64":  int64(0),
+				"fFixed32":  int64(0),
+				"fBool":     false,
+				"fString":   "",
+				"fBytes":    "",
+				"fUint32":   int64(0),
+				"fEnum":     "RED",
+				"fSfixed32": int64(0),
+				"fSfixed64": int64(0),
+				"fSint32":   int64(0),
+				"fSint64":   int64(0),
+			},
+		},
+		{
+			name: "item",
+			m:    "test.Item",
+			in: map[string]interface{}{
+				"id":     "x",
+				"counts": []interface{}{int64(1), int64(-1), 300.0},
+				"names":  []interface{}{"a", ""},
+				"tags": []interface{}{
+					map[string]interface{}{"name": "t1"},
+					map[string]interface{}{},
+				},
+				"attrs": map[string]interface{}{"a": int64(1), "b": "2"},
+				"flags": map[string]interface{}{
+					"true":  map[string]interface{}{"name": "yes"},
+					"false": map[string]interface{}{},
+				},
+				"limit":    int64(0),
+				"text":     "hi",
+				"unpacked": []interface{}{int64(5)},
+				"color":    "BLUE",
+				"meta": map[string]interface{}{
+					"owner":   "bob",
+					"version": int64(0),
+					"ids":     []interface{}{int64(9)},
+					"extra":   map[string]interface{}{"note": "n"},
+				},
+			},
+			out: map[string]interface{}{
+				"id":     "x",
+				"counts": []interface{}{int64(1), int64(-1), int64(300)},
+				"names":  []interface{}{"a", ""},
+				"tags": []interface{}{
+					map[string]interface{}{"name": "t1"},
+					map[string]interface{}{"name": ""},
+				},
+				"attrs": map[string]interface{}{"a": int64(1), "b": int64(2)},
+				"flags": map[string]interface{}{
+					"true":  map[string]interface{}{"name": "yes"},
+					"false": map[string]interface{}{"name": ""},
+				},
+				"limit":    int64(0),
+				"text":     "hi",
+				"unpacked": []interface{}{int64(5)},
+				"color":    "BLUE",
+				"meta": map[string]interface{}{
+					"owner":   "bob",
+					"version": int64(0),
+					"ids":     []interface{}{int64(9)},
+					"extra":   map[string]interface{}{"note": "n"},
+				},
+			},
+		},
+		{
+			name: "empty item",
+			m:    "test.Item",
+			in:   map[string]interface{}{"number": int64(0)},
+			out: map[string]interface{}{
+				"id":       "",
+				"counts":   []interface{}{},
+				"names":    []interface{}{},
+				"tags":     []interface{}{},
+				"attrs":    map[string]interface{}{},
+				"flags":    map[string]interface{}{},
+				"number":   int64(0),
+				"unpacked": []interface{}{},
+				"color":    "RED",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		m := r.Message(c.m)
+		b, err := Marshal(m, c.in)
+		if !assert.Nil(t, err, c.name) {
+			continue
+		}
+		o, err := Unmarshal(m, b)
+		if !assert.Nil(t, err, c.name) {
+			continue
+		}
+		assert.Equal(t, c.out, o, c.name)
+
+		// the decoded value encodes to the same bytes
+		b2, err := Marshal(m, o)
+		if assert.Nil(t, err, c.name) {
+			assert.Equal(t, b, b2, c.name)
+		}
+	}
+}
+
+func TestMarshalError(t *testing.T) {
+	r := testRegistry(t)
+	scalars := r.Message("test.Scalars")
+	item := r.Message("test.Item")
+
+	cases := []struct {
+		name string
+		m    *MessageDesc
+		v    map[string]interface{}
+	}{
+		{"unknown field", scalars, map[string]interface{}{"nope": int64(1)}},
+		{"string as int", scalars, map[string]interface{}{"fInt32": "x"}},
+		{"real as int", scalars, map[string]interface{}{"fInt32": 1.5}},
+		{"int32 range", scalars, map[string]interface{}{"fInt32": int64(math.MaxInt32) + 1}},
+		{"uint32 range", scalars, map[string]interface{}{"fUint32": int64(-1)}},
+		{"uint64 negative", scalars, map[string]interface{}{"fUint64": int64(-1)}},
+		{"int as string", scalars, map[string]interface{}{"fString": int64(1)}},
+		{"invalid base64", scalars, map[string]interface{}{"fBytes": "!!"}},
+		{"unknown enum", scalars, map[string]interface{}{"fEnum": "PINK"}},
+		{"int as bool", scalars, map[string]interface{}{"fBool": int64(1)}},
+		{"map as list", item, map[string]interface{}{"counts": map[string]interface{}{}}},
+		{"null element", item, map[string]interface{}{"names": []interface{}{nil}}},
+		{"list as message", item, map[string]interface{}{"meta": []interface{}{}}},
+		{"nested unknown field", item, map[string]interface{}{"tags": []interface{}{
+			map[string]interface{}{"nope": "x"},
+		}}},
+		{"map bool key", item, map[string]interface{}{"flags": map[string]interface{}{
+			"yes": map[string]interface{}{},
+		}}},
+	}
+
+	for _, c := range cases {
+		_, err := Marshal(c.m, c.v)
+		assert.NotNil(t, err, c.name)
+	}
+}
+
+func TestUnmarshalWire(t *testing.T) {
+	r := testRegistry(t)
+	scalars := r.Message("test.Scalars")
+	item := r.Message("test.Item")
+
+	// unpacked encoding of the packed field and packed encoding of the
+	// unpacked field are both accepted, unknown fields are skipped and the
+	// message field appears twice is merged
+	o, err := Unmarshal(item, []byte{
+		0x10, 0x01, 0x10, 0x02,
+		0x5a, 0x02, 0x03, 0x04,
+		0xf8, 0x01, 0x01,
+		0x9a, 0x01, 0x01, 'x',
+		0x52, 0x05, 0x0a, 0x03, 'b', 'o', 'b',
+		0x52, 0x02, 0x10, 0x02,
+	})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []interface{}{int64(1), int64(2)}, o["counts"])
+		assert.Equal(t, []interface{}{int64(3), int64(4)}, o["unpacked"])
+		assert.Equal(t, "bob", o["meta"].(map[string]interface{})["owner"])
+		assert.Equal(t, int64(2), o["meta"].(map[string]interface{})["version"])
+	}
+
+	// unknown enum number is kept as number
+	o, err = Unmarshal(scalars, []byte{0x70, 0x09})
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(9), o["fEnum"])
+	}
+
+	for _, c := range []struct {
+		name string
+		m    *MessageDesc
+		wire []byte
+	}{
+		{"truncated varint", scalars, []byte{0x28, 0x96}},
+		{"truncated bytes", scalars, []byte{0x4a, 0x07, 't'}},
+		{"truncated fixed32", scalars, []byte{0x3d, 0x01}},
+		{"wire type mismatch", scalars, []byte{0x29, 0x01, 0, 0, 0, 0, 0, 0, 0}},
+		{"varint overflow", scalars, []byte{0x28, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"unterminated group", r.Message("common.Meta"), []byte{0x23, 0x2a, 0x01, 'n'}},
+		{"mismatched group end", r.Message("common.Meta"), []byte{0x23, 0x2c}},
+		{"map entry wire type", item, []byte{0x28, 0x01}},
+	} {
+		_, err := Unmarshal(c.m, c.wire)
+		assert.NotNil(t, err, c.name)
+	}
+}
+
+func TestWire(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, v := range []uint64{0, 1, 127, 128, 300, math.MaxUint32, math.MaxUint64} {
+		d := &decoder{buf: appendVarint(nil, v)}
+		x, err := d.varint()
+		assert.Nil(err)
+		assert.Equal(v, x)
+		assert.True(d.eof())
+	}
+
+	for _, v := range []int64{0, -1, 1, -2, math.MaxInt32, math.MinInt32} {
+		assert.Equal(v, unzigzag(zigzag32(v)))
+	}
+	for _, v := range []int64{0, -1, 1, math.MaxInt64, math.MinInt64} {
+		assert.Equal(v, unzigzag(zigzag64(v)))
+	}
+	assert.Equal(uint64(1), zigzag32(-1))
+	assert.Equal(uint64(4294967295), zigzag32(math.MinInt32))
+
+	b := appendTag(nil, 4, wireStartGr)
+	b = appendFixed32(appendTag(b, 1, wireFixed32), 1)
+	b = appendFixed64(appendTag(b, 2, wireFixed64), 2)
+	b = appendBytes(appendTag(b, 3, wireBytes), []byte("abc"))
+	b = appendTag(b, 4, wireEndGr)
+	b = appendVarint(appendTag(b, 5, wireVarint), 5)
+
+	d := &decoder{buf: b}
+	num, wt, err := d.tag()
+	assert.Nil(err)
+	assert.Equal(4, num)
+	assert.Equal(wireStartGr, wt)
+	assert.Nil(d.skip(num, wt))
+	num, wt, err = d.tag()
+	assert.Nil(err)
+	assert.Equal(5, num)
+	assert.Equal(wireVarint, wt)
+
+	d = &decoder{buf: appendTag(nil, 1, 6)}
+	num, wt, err = d.tag()
+	if err == nil {
+		assert.NotNil(d.skip(num, wt))
+	}
+}
@@ -0,0 +1,596 @@
+package grpc
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Descriptors are loaded from a FileDescriptorSet, ie the output of
+//   protoc --include_imports --descriptor_set_out=api.pb api.proto
+// Only the parts needed by the dynamic codec are kept, the options other than
+// map_entry and packed are ignored.
+
+// field type, same as FieldDescriptorProto.Type
+const (
+	TypeDouble   = 1
+	TypeFloat    = 2
+	TypeInt64    = 3
+	TypeUint64   = 4
+	TypeInt32    = 5
+	TypeFixed64  = 6
+	TypeFixed32  = 7
+	TypeBool     = 8
+	TypeString   = 9
+	TypeGroup    = 10
+	TypeMessage  = 11
+	TypeBytes    = 12
+	TypeUint32   = 13
+	TypeEnum     = 14
+	TypeSfixed32 = 15
+	TypeSfixed64 = 16
+	TypeSint32   = 17
+	TypeSint64   = 18
+)
+
+const (
+	labelOptional = 1
+	labelRequired = 2
+	labelRepeated = 3
+)
+
+type FieldDesc struct {
+	Name     string
+	JSONName string
+	Number   int
+	Type     int
+	TypeName string
+	Repeated bool
+	Packed   bool
+
+	// whether the field tracks presence, ie proto2 optional or proto3
+	// optional field, the default value is not emitted when it is not set
+	HasPresence bool
+
+	Message *MessageDesc
+	Enum    *EnumDesc
+}
+
+// whether the field is a map, ie repeated map entry message
+func (f *FieldDesc) IsMap() bool {
+	return f.Repeated && f.Message != nil && f.Message.MapEntry
+}
+
+type MessageDesc struct {
+	FullName string
+	Fields   []*FieldDesc
+	MapEntry bool
+
+	byNumber map[int]*FieldDesc
+	byName   map[string]*FieldDesc
+}
+
+func (m *MessageDesc) FieldByNumber(n int) *FieldDesc {
+	return m.byNumber[n]
+}
+
+// finds the field by its json name or proto name
+func (m *MessageDesc) FieldByName(n string) *FieldDesc {
+	return m.byName[n]
+}
+
+type EnumDesc struct {
+	FullName string
+
+	byName   map[string]int32
+	byNumber map[int32]string
+}
+
+func (e *EnumDesc) Name(v int32) (string, bool) {
+	n, ok := e.byNumber[v]
+	return n, ok
+}
+
+func (e *EnumDesc) Value(n string) (int32, bool) {
+	v, ok := e.byName[n]
+	return v, ok
+}
+
+type MethodDesc struct {
+	// path of the http/2 request, ie /pkg.Service/Method
+	Path            string
+	Input           *MessageDesc
+	Output          *MessageDesc
+	ClientStreaming bool
+	ServerStreaming bool
+}
+
+// descriptors of all the files of the set, types are indexed by full name
+// without the leading dot
+type Registry struct {
+	messages map[string]*MessageDesc
+	enums    map[string]*EnumDesc
+	methods  map[string]*MethodDesc
+}
+
+func (r *Registry) Message(name string) *MessageDesc {
+	return r.messages[strings.TrimPrefix(name, ".")]
+}
+
+func (r *Registry) Enum(name string) *EnumDesc {
+	return r.enums[strings.TrimPrefix(name, ".")]
+}
+
+// finds the method by name, either pkg.Service/Method, /pkg.Service/Method or
+// pkg.Service.Method
+func (r *Registry) Method(name string) *MethodDesc {
+	name = strings.TrimPrefix(name, "/")
+	if !strings.Contains(name, "/") {
+		if idx := strings.LastIndexByte(name, '.'); idx > 0 {
+			name = name[:idx] + "/" + name[idx+1:]
+		}
+	}
+	return r.methods[name]
+}
+
+// list of the method name in form of pkg.Service/Method
+func (r *Registry) Methods() []string {
+	o := make([]string, 0, len(r.methods))
+	for k := range r.methods {
+		o = append(o, k)
+	}
+	return o
+}
+
+func LoadRegistryFromFile(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewRegistry(data)
+}
+
+// parses the serialized FileDescriptorSet
+func NewRegistry(data []byte) (*Registry, error) {
+	r := &Registry{
+		messages: make(map[string]*MessageDesc),
+		enums:    make(map[string]*EnumDesc),
+		methods:  make(map[string]*MethodDesc),
+	}
+	p := &descParser{
+		r: r,
+	}
+
+	d := &decoder{buf: data}
+	for !d.eof() {
+		num, wt, err := d.tag()
+		if err != nil {
+			return nil, err
+		}
+		if num == 1 && wt == wireBytes {
+			b, err := d.bytes()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.file(b); err != nil {
+				return nil, err
+			}
+		} else if err := d.skip(num, wt); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.link(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// raw method and field waiting for the types to be resolved
+type rawMethod struct {
+	service string
+	name    string
+	input   string
+	output  string
+	cstream bool
+	sstream bool
+}
+
+type rawField struct {
+	msg   *MessageDesc
+	field *FieldDesc
+}
+
+type descParser struct {
+	r       *Registry
+	fields  []rawField
+	methods []rawMethod
+}
+
+// iterates the fields of the message, the callback gets the decoder positioned
+// at the value
+func foreachField(data []byte, fn func(num int, wt int, d *decoder) error) error {
+	d := &decoder{buf: data}
+	for !d.eof() {
+		num, wt, err := d.tag()
+		if err != nil {
+			return err
+		}
+		pos := d.pos
+		if err := fn(num, wt, d); err != nil {
+			return err
+		}
+		// the callback does not consume the field
+		if d.pos == pos {
+			if err := d.skip(num, wt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func qualify(scope string, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func (p *descParser) file(data []byte) error {
+	pkg := ""
+	proto3 := false
+	var messages, enums, services [][]byte
+
+	err := foreachField(data, func(num int, wt int, d *decoder) error {
+		if wt != wireBytes {
+			return nil
+		}
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		switch num {
+		case 2:
+			pkg = string(b)
+		case 4:
+			messages = append(messages, b)
+		case 5:
+			enums = append(enums, b)
+		case 6:
+			services = append(services, b)
+		case 12:
+			proto3 = string(b) == "proto3"
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, m := range messages {
+		if err := p.message(pkg, m, proto3); err != nil {
+			return err
+		}
+	}
+	for _, e := range enums {
+		if err := p.enum(pkg, e); err != nil {
+			return err
+		}
+	}
+	for _, s := range services {
+		if err := p.service(pkg, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *descParser) message(scope string, data []byte, proto3 bool) error {
+	m := &MessageDesc{
+		byNumber: make(map[int]*FieldDesc),
+		byName:   make(map[string]*FieldDesc),
+	}
+	var fields, nested, enums [][]byte
+
+	err := foreachField(data, func(num int, wt int, d *decoder) error {
+		if wt != wireBytes {
+			return nil
+		}
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		switch num {
+		case 1:
+			m.FullName = qualify(scope, string(b))
+		case 2:
+			fields = append(fields, b)
+		case 3:
+			nested = append(nested, b)
+		case 4:
+			enums = append(enums, b)
+		case 7:
+			// MessageOptions.map_entry
+			return foreachField(b, func(num int, wt int, d *decoder) error {
+				if num == 7 && wt == wireVarint {
+					v, err := d.varint()
+					m.MapEntry = v != 0
+					return err
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		fd, err := p.field(f, proto3)
+		if err != nil {
+			return err
+		}
+		m.Fields = append(m.Fields, fd)
+		m.byNumber[fd.Number] = fd
+		m.byName[fd.Name] = fd
+		m.byName[fd.JSONName] = fd
+		p.fields = append(p.fields, rawField{msg: m, field: fd})
+	}
+	p.r.messages[m.FullName] = m
+
+	for _, n := range nested {
+		if err := p.message(m.FullName, n, proto3); err != nil {
+			return err
+		}
+	}
+	for _, e := range enums {
+		if err := p.enum(m.FullName, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *descParser) field(data []byte, proto3 bool) (*FieldDesc, error) {
+	f := &FieldDesc{}
+	label := labelOptional
+	packed := -1
+	proto3Optional := false
+	oneof := false
+
+	err := foreachField(data, func(num int, wt int, d *decoder) error {
+		switch {
+		case wt == wireBytes:
+			b, err := d.bytes()
+			if err != nil {
+				return err
+			}
+			switch num {
+			case 1:
+				f.Name = string(b)
+			case 6:
+				f.TypeName = strings.TrimPrefix(string(b), ".")
+			case 10:
+				f.JSONName = string(b)
+			case 8:
+				// FieldOptions.packed
+				return foreachField(b, func(num int, wt int, d *decoder) error {
+					if num == 2 && wt == wireVarint {
+						v, err := d.varint()
+						if v != 0 {
+							packed = 1
+						} else {
+							packed = 0
+						}
+						return err
+					}
+					return nil
+				})
+			}
+		case wt == wireVarint:
+			v, err := d.varint()
+			if err != nil {
+				return err
+			}
+			switch num {
+			case 3:
+				f.Number = int(v)
+			case 4:
+				label = int(v)
+			case 5:
+				f.Type = int(v)
+			case 9:
+				oneof = true
+			case 17:
+				proto3Optional = v != 0
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if f.JSONName == "" {
+		f.JSONName = jsonName(f.Name)
+	}
+	f.Repeated = label == labelRepeated
+
+	isScalar := f.Type != TypeString && f.Type != TypeBytes &&
+		f.Type != TypeMessage && f.Type != TypeGroup
+	if f.Repeated && isScalar {
+		if packed == -1 {
+			f.Packed = proto3
+		} else {
+			f.Packed = packed == 1
+		}
+	}
+	if !f.Repeated {
+		f.HasPresence = !proto3 || proto3Optional || oneof ||
+			f.Type == TypeMessage || f.Type == TypeGroup
+	}
+	return f, nil
+}
+
+// the default json name of protoc, ie foo_bar to fooBar
+func jsonName(n string) string {
+	b := strings.Builder{}
+	upper := false
+	for _, c := range n {
+		if c == '_' {
+			upper = true
+			continue
+		}
+		if upper && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper = false
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (p *descParser) enum(scope string, data []byte) error {
+	e := &EnumDesc{
+		byName:   make(map[string]int32),
+		byNumber: make(map[int32]string),
+	}
+	err := foreachField(data, func(num int, wt int, d *decoder) error {
+		if wt != wireBytes {
+			return nil
+		}
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		switch num {
+		case 1:
+			e.FullName = qualify(scope, string(b))
+		case 2:
+			name := ""
+			var number int32
+			err := foreachField(b, func(num int, wt int, d *decoder) error {
+				if num == 1 && wt == wireBytes {
+					v, err := d.bytes()
+					name = string(v)
+					return err
+				}
+				if num == 2 && wt == wireVarint {
+					v, err := d.varint()
+					number = int32(v)
+					return err
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			e.byName[name] = number
+			// the first name wins when the value is aliased
+			if _, ok := e.byNumber[number]; !ok {
+				e.byNumber[number] = name
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	p.r.enums[e.FullName] = e
+	return nil
+}
+
+func (p *descParser) service(scope string, data []byte) error {
+	name := ""
+	var methods [][]byte
+	err := foreachField(data, func(num int, wt int, d *decoder) error {
+		if wt != wireBytes {
+			return nil
+		}
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		switch num {
+		case 1:
+			name = qualify(scope, string(b))
+		case 2:
+			methods = append(methods, b)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, m := range methods {
+		x := rawMethod{service: name}
+		err := foreachField(m, func(num int, wt int, d *decoder) error {
+			if wt == wireBytes {
+				b, err := d.bytes()
+				if err != nil {
+					return err
+				}
+				switch num {
+				case 1:
+					x.name = string(b)
+				case 2:
+					x.input = string(b)
+				case 3:
+					x.output = string(b)
+				}
+			} else if wt == wireVarint {
+				v, err := d.varint()
+				if err != nil {
+					return err
+				}
+				switch num {
+				case 5:
+					x.cstream = v != 0
+				case 6:
+					x.sstream = v != 0
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		p.methods = append(p.methods, x)
+	}
+	return nil
+}
+
+// resolves the type references once all the files are parsed
+func (p *descParser) link() error {
+	for _, x := range p.fields {
+		f := x.field
+		switch f.Type {
+		case TypeMessage, TypeGroup:
+			if f.Message = p.r.Message(f.TypeName); f.Message == nil {
+				return fmt.Errorf("protobuf: field %s.%s has unknown type %s", x.msg.FullName, f.Name, f.TypeName)
+			}
+		case TypeEnum:
+			if f.Enum = p.r.Enum(f.TypeName); f.Enum == nil {
+				return fmt.Errorf("protobuf: field %s.%s has unknown enum %s", x.msg.FullName, f.Name, f.TypeName)
+			}
+		}
+	}
+
+	for _, x := range p.methods {
+		m := &MethodDesc{
+			Path:            "/" + x.service + "/" + x.name,
+			Input:           p.r.Message(x.input),
+			Output:          p.r.Message(x.output),
+			ClientStreaming: x.cstream,
+			ServerStreaming: x.sstream,
+		}
+		if m.Input == nil || m.Output == nil {
+			return fmt.Errorf("protobuf: method %s has unknown input or output type", m.Path)
+		}
+		p.r.methods[x.service+"/"+x.name] = m
+	}
+	return nil
+}
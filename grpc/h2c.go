@@ -0,0 +1,16 @@
+//go:build go1.24
+// +build go1.24
+
+package grpc
+
+import (
+	"net/http"
+)
+
+// speaks http/2 over cleartext with prior knowledge
+func enableH2C(t *http.Transport) error {
+	p := new(http.Protocols)
+	p.SetUnencryptedHTTP2(true)
+	t.Protocols = p
+	return nil
+}
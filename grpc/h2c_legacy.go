@@ -0,0 +1,15 @@
+//go:build !go1.24
+// +build !go1.24
+
+package grpc
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// net/http supports h2c client since go1.24, older toolchain can only reach
+// the upstream over https
+func enableH2C(_ *http.Transport) error {
+	return fmt.Errorf("grpc: cleartext http/2 upstream requires go1.24, use https target")
+}
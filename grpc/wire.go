@@ -0,0 +1,169 @@
+package grpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// protobuf wire format primitives
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireStartGr = 3
+	wireEndGr   = 4
+	wireFixed32 = 5
+)
+
+var (
+	errTruncated = fmt.Errorf("protobuf: truncated message")
+	errOverflow  = fmt.Errorf("protobuf: varint overflow")
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendTag(b []byte, num int, wt int) []byte {
+	return appendVarint(b, uint64(num)<<3|uint64(wt))
+}
+
+func appendBytes(b []byte, data []byte) []byte {
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendFixed32(b []byte, v uint32) []byte {
+	var x [4]byte
+	binary.LittleEndian.PutUint32(x[:], v)
+	return append(b, x[:]...)
+}
+
+func appendFixed64(b []byte, v uint64) []byte {
+	var x [8]byte
+	binary.LittleEndian.PutUint64(x[:], v)
+	return append(b, x[:]...)
+}
+
+func zigzag32(v int64) uint64 {
+	return uint64(uint32((int32(v) << 1) ^ (int32(v) >> 31)))
+}
+
+func zigzag64(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// decoder over a protobuf encoded buffer
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+func (d *decoder) eof() bool {
+	return d.pos >= len(d.buf)
+}
+
+func (d *decoder) varint() (uint64, error) {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		if d.pos >= len(d.buf) {
+			return 0, errTruncated
+		}
+		b := d.buf[d.pos]
+		d.pos++
+		v |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return v, nil
+		}
+	}
+	return 0, errOverflow
+}
+
+func (d *decoder) tag() (int, int, error) {
+	v, err := d.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	num := int(v >> 3)
+	if num <= 0 || v>>3 > math.MaxInt32 {
+		return 0, 0, fmt.Errorf("protobuf: invalid field number")
+	}
+	return num, int(v & 7), nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	n, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.buf)-d.pos) {
+		return nil, errTruncated
+	}
+	b := d.buf[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+func (d *decoder) fixed32() (uint32, error) {
+	if len(d.buf)-d.pos < 4 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint32(d.buf[d.pos:])
+	d.pos += 4
+	return v, nil
+}
+
+func (d *decoder) fixed64() (uint64, error) {
+	if len(d.buf)-d.pos < 8 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint64(d.buf[d.pos:])
+	d.pos += 8
+	return v, nil
+}
+
+// skips the value of the field with the wire type
+func (d *decoder) skip(num int, wt int) error {
+	switch wt {
+	case wireVarint:
+		_, err := d.varint()
+		return err
+	case wireFixed64:
+		_, err := d.fixed64()
+		return err
+	case wireBytes:
+		_, err := d.bytes()
+		return err
+	case wireFixed32:
+		_, err := d.fixed32()
+		return err
+	case wireStartGr:
+		for {
+			n, w, err := d.tag()
+			if err != nil {
+				return err
+			}
+			if w == wireEndGr {
+				if n != num {
+					return fmt.Errorf("protobuf: mismatched group end")
+				}
+				return nil
+			}
+			if err := d.skip(n, w); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("protobuf: invalid wire type %d", wt)
+	}
+}
@@ -0,0 +1,282 @@
+package health
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Active health checking of an upstream group. Each upstream is probed by a
+// GET of the path every interval, the probe succeeds if the status is the
+// expected one and the body contains the expected text. An upstream becomes
+// unhealthy after fall failed probes in a row and healthy again after rise
+// succeeded probes in a row, the upstreams are healthy initially
+
+const (
+	defaultInterval = 5 * time.Second
+	defaultTimeout  = 2 * time.Second
+	defaultRise     = 2
+	defaultFall     = 3
+
+	// max bytes of the body read for matching
+	maxBody = 64 * 1024
+)
+
+type Option struct {
+	Interval time.Duration
+	Timeout  time.Duration
+
+	// request of the probe, the scheme is http unless specified and the host
+	// header is the upstream address unless specified
+	Scheme string
+	Path   string
+	Host   string
+
+	// expected status, any 2xx and 3xx if empty, and the text the body must
+	// contain if not empty
+	Status []int
+	Body   string
+
+	Rise int
+	Fall int
+}
+
+func (o *Option) normalize() error {
+	if o.Interval < 0 || o.Timeout < 0 || o.Rise < 0 || o.Fall < 0 {
+		return fmt.Errorf("health: option must be non negative")
+	}
+	if o.Interval == 0 {
+		o.Interval = defaultInterval
+	}
+	if o.Timeout == 0 {
+		o.Timeout = defaultTimeout
+	}
+	switch o.Scheme {
+	case "":
+		o.Scheme = "http"
+	case "http", "https":
+		break
+	default:
+		return fmt.Errorf("health: unsupported scheme %s", o.Scheme)
+	}
+	if o.Path == "" {
+		o.Path = "/"
+	} else if o.Path[0] != '/' {
+		return fmt.Errorf("health: path must start with /")
+	}
+	if o.Rise == 0 {
+		o.Rise = defaultRise
+	}
+	if o.Fall == 0 {
+		o.Fall = defaultFall
+	}
+	return nil
+}
+
+// Event is published when an upstream changes between healthy and unhealthy
+type Event struct {
+	Group   string
+	Addr    string
+	Healthy bool
+
+	// why the last probe failed, empty when it succeeded
+	Reason string
+}
+
+type Status struct {
+	Addr    string
+	Healthy bool
+	Reason  string
+	Last    time.Time
+}
+
+type target struct {
+	addr    string
+	healthy bool
+	success int
+	failure int
+	reason  string
+	last    time.Time
+}
+
+type Checker struct {
+	sync.Mutex
+	group  string
+	option Option
+	client *http.Client
+	target []*target
+	notify func(Event)
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New creates the checker of the group, notify is called from the checker's
+// goroutine on every change of health
+func New(group string, addr []string, option *Option, notify func(Event)) (*Checker, error) {
+	o := *option
+	if err := o.normalize(); err != nil {
+		return nil, err
+	}
+
+	c := &Checker{
+		group:  group,
+		option: o,
+		client: &http.Client{
+			Timeout: o.Timeout,
+
+			// the redirection is the answer of the upstream itself
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		notify: notify,
+		stop:   make(chan struct{}),
+	}
+	for _, a := range addr {
+		c.target = append(c.target, &target{
+			addr:    a,
+			healthy: true,
+		})
+	}
+	return c, nil
+}
+
+func (c *Checker) Group() string {
+	return c.group
+}
+
+func (c *Checker) Start() {
+	for _, t := range c.target {
+		c.wg.Add(1)
+		go c.run(t)
+	}
+}
+
+// Stop stops probing and waits for the probes in flight
+func (c *Checker) Stop() {
+	close(c.stop)
+	c.wg.Wait()
+}
+
+func (c *Checker) Healthy(addr string) bool {
+	c.Lock()
+	defer c.Unlock()
+	for _, t := range c.target {
+		if t.addr == addr {
+			return t.healthy
+		}
+	}
+	return false
+}
+
+func (c *Checker) Status() []Status {
+	c.Lock()
+	defer c.Unlock()
+	o := make([]Status, 0, len(c.target))
+	for _, t := range c.target {
+		o = append(o, Status{
+			Addr:    t.addr,
+			Healthy: t.healthy,
+			Reason:  t.reason,
+			Last:    t.last,
+		})
+	}
+	return o
+}
+
+func (c *Checker) run(t *target) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.option.Interval)
+	defer ticker.Stop()
+	for {
+		c.update(t, c.probe(t.addr))
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			break
+		}
+	}
+}
+
+// returns the reason of failure, empty if the probe succeeds
+func (c *Checker) probe(addr string) string {
+	req, err := http.NewRequest("GET", c.option.Scheme+"://"+addr+c.option.Path, nil)
+	if err != nil {
+		return err.Error()
+	}
+	if c.option.Host != "" {
+		req.Host = c.option.Host
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err.Error()
+	}
+	defer resp.Body.Close()
+
+	if !c.expectStatus(resp.StatusCode) {
+		return fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	if c.option.Body != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+		if err != nil {
+			return err.Error()
+		}
+		if !strings.Contains(string(body), c.option.Body) {
+			return "unexpected body"
+		}
+	}
+	return ""
+}
+
+func (c *Checker) expectStatus(status int) bool {
+	if len(c.option.Status) == 0 {
+		return status >= 200 && status < 400
+	}
+	for _, x := range c.option.Status {
+		if x == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Checker) update(t *target, reason string) {
+	c.Lock()
+	t.reason = reason
+	t.last = time.Now()
+
+	changed := false
+	if reason == "" {
+		t.failure = 0
+		t.success++
+		if !t.healthy && t.success >= c.option.Rise {
+			t.healthy = true
+			changed = true
+		}
+	} else {
+		t.success = 0
+		t.failure++
+		if t.healthy && t.failure >= c.option.Fall {
+			t.healthy = false
+			changed = true
+		}
+	}
+	event := Event{
+		Group:   c.group,
+		Addr:    t.addr,
+		Healthy: t.healthy,
+		Reason:  reason,
+	}
+	c.Unlock()
+
+	if changed && c.notify != nil {
+		c.notify(event)
+	}
+}
@@ -2,6 +2,8 @@ package hpl
 
 import (
 	"fmt"
+	"sort"
+
 	"github.com/dianpeng/moons/alog"
 	"github.com/dianpeng/moons/pl"
 )
@@ -25,7 +27,7 @@ func newAccessLog(
 func ValIsAccessLog(
 	v pl.Val,
 ) bool {
-	return v.Id() == ".access_log"
+	return v.Id() == AccessLogTypeId
 }
 
 func (l *accesslog) Index(key pl.Val) (pl.Val, error) {
@@ -36,14 +38,33 @@ func (l *accesslog) Index(key pl.Val) (pl.Val, error) {
 	switch key.String() {
 	case "format":
 		return pl.NewValStr(l.l.Format.Raw), nil
+	case "encoding":
+		return pl.NewValStr(l.l.Format.Encoding), nil
 	case "appendix":
 		return l.appendix, nil
+	case "attempts":
+		return l.attempts()
 	default:
 		return pl.NewValNull(),
 			fmt.Errorf("%s index: key %s is unknown", l.Id(), key.String())
 	}
 }
 
+// attempts of the upstream calls as list of {upstream, status, error,
+// duration}, the duration is in millisecond
+func (l *accesslog) attempts() (pl.Val, error) {
+	o := []interface{}{}
+	for _, a := range l.l.Attempts {
+		o = append(o, map[string]interface{}{
+			"upstream": a.Upstream,
+			"status":   a.Status,
+			"error":    a.Error,
+			"duration": a.Duration.Milliseconds(),
+		})
+	}
+	return pl.MarshalVal(o)
+}
+
 func (l *accesslog) Dot(key string) (pl.Val, error) {
 	return l.Index(pl.NewValStr(key))
 }
@@ -96,3 +117,239 @@ func NewAccessLogVal(
 		newAccessLog(l),
 	)
 }
+
+// The custom fields of the access log are computed by the closures of the
+// config at the end of the transaction, ie
+//
+//   .log_fields = {
+//     "user": fn() { return request.header:get("x-user", ""); },
+//     "cache": fn() { return response.header:get("x-cache", ""); }
+//   };
+
+// LogField is one custom field of the access log
+type LogField struct {
+	Name string
+	Fn   pl.Val
+}
+
+// ParseLogFields parses the map of the field name and its closure, the fields
+// are ordered by name
+func ParseLogFields(v pl.Val, name string) ([]LogField, error) {
+	if !v.IsMap() {
+		return nil, fmt.Errorf("%s: must be map of field name and closure", name)
+	}
+	o := []LogField{}
+	var err error
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			if !val.IsClosure() {
+				err = fmt.Errorf("%s: field %s must be closure", name, key)
+				return false
+			}
+			o = append(o, LogField{
+				Name: key,
+				Fn:   val,
+			})
+			return true
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(o, func(i, j int) bool {
+		return o[i].Name < o[j].Name
+	})
+	return o, nil
+}
+
+func logFieldValue(v pl.Val) interface{} {
+	switch {
+	case v.IsNull():
+		return nil
+	case v.IsBool():
+		return v.Bool()
+	case v.IsInt():
+		return v.Int()
+	case v.IsReal():
+		return v.Real()
+	case v.IsString():
+		return v.String()
+	default:
+		if str, err := v.ToJSONString(); err == nil {
+			return str
+		}
+		return v.Info()
+	}
+}
+
+// EvalLogFields computes the custom fields into the log, the field whose
+// closure fails is null and the first error is returned
+func EvalLogFields(
+	eval *pl.Evaluator,
+	fields []LogField,
+	l *alog.Log,
+) error {
+	var first error
+	for _, f := range fields {
+		v, err := f.Fn.Closure().Call(eval, nil)
+		if err != nil {
+			if first == nil {
+				first = fmt.Errorf("access log field %s: %s", f.Name, err.Error())
+			}
+			v = pl.NewValNull()
+		}
+		l.SetField(f.Name, logFieldValue(v))
+	}
+	return first
+}
+
+// The access log is shipped by the sinks of the config, one map or list of
+// them, the durations are in millisecond, ie
+//
+//   .log_sink = [
+//     { "type": "file", "path": "/var/log/moons/access.log", "max_size": 104857600 },
+//     { "type": "http", "url": "http://collector/logs", "overflow": "drop_oldest" }
+//   ];
+
+// NewSinkOptionFromVal parses one sink of the access log, see alog.SinkOption
+func NewSinkOptionFromVal(v pl.Val) (*alog.SinkOption, error) {
+	if !v.IsMap() {
+		return nil, fmt.Errorf("access log sink must be map")
+	}
+
+	o := &alog.SinkOption{}
+	var err error
+
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "type":
+				err = optionStr(val, key, &o.Type)
+			case "path":
+				err = optionStr(val, key, &o.Path)
+			case "max_size":
+				var size int
+				err = optionInt(val, key, &size)
+				o.MaxSize = int64(size)
+			case "max_backups":
+				err = optionInt(val, key, &o.MaxBackups)
+			case "network":
+				err = optionStr(val, key, &o.Network)
+			case "address":
+				err = optionStr(val, key, &o.Address)
+			case "tag":
+				err = optionStr(val, key, &o.Tag)
+			case "facility":
+				err = optionStr(val, key, &o.Facility)
+			case "url":
+				err = optionStr(val, key, &o.URL)
+			case "header":
+				o.Header, err = sinkOptionHeader(val)
+			case "timeout":
+				err = optionDuration(val, key, &o.Timeout)
+			case "topic":
+				err = optionStr(val, key, &o.Topic)
+			case "option":
+				o.MQOption, err = NewMQOptionFromVal(val)
+			case "buffer_size":
+				err = optionInt(val, key, &o.BufferSize)
+			case "batch_size":
+				err = optionInt(val, key, &o.BatchSize)
+			case "flush_interval":
+				err = optionDuration(val, key, &o.FlushInterval)
+			case "max_retry":
+				err = optionInt(val, key, &o.MaxRetry)
+			case "retry_wait":
+				err = optionDuration(val, key, &o.RetryWait)
+			case "overflow":
+				err = optionStr(val, key, &o.Overflow)
+			case "block_timeout":
+				err = optionDuration(val, key, &o.BlockTimeout)
+			default:
+				err = fmt.Errorf("access log sink %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	if o.Type == "" {
+		return nil, fmt.Errorf("access log sink requires type")
+	}
+	return o, nil
+}
+
+func sinkOptionHeader(v pl.Val) (map[string]string, error) {
+	if !v.IsMap() {
+		return nil, fmt.Errorf("access log sink header must be map")
+	}
+	o := make(map[string]string)
+	var err error
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			if !val.IsString() {
+				err = fmt.Errorf("access log sink header %s must be string", key)
+				return false
+			}
+			o[key] = val.String()
+			return true
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func sampleRate(v pl.Val, name string, ptr *float64) error {
+	if !v.IsNumber() {
+		return fmt.Errorf("access log sample %s must be number", name)
+	}
+	if v.IsInt() {
+		*ptr = float64(v.Int())
+	} else {
+		*ptr = v.Real()
+	}
+	return nil
+}
+
+// The access log is sampled by the config, ie
+//
+//   .log_sample = { "rate": 0.01, "error_rate": 1, "header": "x-debug-log" };
+
+// NewSampleOptionFromVal parses the sampling of the access log, the rates are
+// 1 by default, see alog.Sampler
+func NewSampleOptionFromVal(v pl.Val) (*alog.SampleOption, error) {
+	if !v.IsMap() {
+		return nil, fmt.Errorf("access log sample must be map")
+	}
+
+	o := &alog.SampleOption{
+		Rate:      1,
+		ErrorRate: 1,
+	}
+	var err error
+
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "rate":
+				err = sampleRate(val, key, &o.Rate)
+			case "error_rate":
+				err = sampleRate(val, key, &o.ErrorRate)
+			case "header":
+				err = optionStr(val, key, &o.Header)
+			default:
+				err = fmt.Errorf("access log sample %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
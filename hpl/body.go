@@ -39,6 +39,13 @@ func (h *Body) SetBuffer(data []byte) {
 	h.stream.SetBuffer(data)
 }
 
+// clones the body, both bodies see the full content of the stream, see
+// ReadableStream.Fork
+func (h *Body) Clone() pl.Val {
+	rs := h.stream.Fork()
+	return newBodyValFromReadableStream(pl.NewValUsr(rs), rs)
+}
+
 func (h *Body) Index(name pl.Val) (pl.Val, error) {
 	if name.Type != pl.ValStr {
 		return pl.NewValNull(), fmt.Errorf("http.body invalid field index")
@@ -104,7 +111,8 @@ func (h *Body) ToString() (string, error) {
 }
 
 var (
-	methodProtoString = pl.MustNewFuncProto("http.body.string", "%0")
+	methodProtoString    = pl.MustNewFuncProto("http.body.string", "%0")
+	methodProtoBodyClone = pl.MustNewFuncProto("http.body.clone", "%0")
 )
 
 func (h *Body) Method(name string, arg []pl.Val) (pl.Val, error) {
@@ -120,6 +128,12 @@ func (h *Body) Method(name string, arg []pl.Val) (pl.Val, error) {
 		}
 		return pl.NewValStr(str), nil
 
+	case "clone":
+		if _, err := methodProtoBodyClone.Check(arg); err != nil {
+			return pl.NewValNull(), err
+		}
+		return h.Clone(), nil
+
 	default:
 		break
 	}
@@ -175,6 +189,22 @@ func NewBodyValFromBuffer(data []byte) pl.Val {
 	return newBodyValFromReadableStream(streamVal, stream)
 }
 
+// the template is rendered into the body stream when the body is consumed
+func NewBodyValFromTemplateStream(v pl.Val) pl.Val {
+	x, ok := v.Usr().(*pl.TemplateStream)
+	must(ok, "must be template stream")
+
+	return NewBodyValFromStream(x.NewReader())
+}
+
+// the cached content is kept so the length of the body is still known
+func newBodyValFromStreamKeepCache(x *ReadableStream) pl.Val {
+	if buf, ok := x.TryCacheBuffer(); ok {
+		return NewBodyValFromBuffer(buf)
+	}
+	return NewBodyValFromStream(x.Stream)
+}
+
 func NewBodyValFromVal(v pl.Val) (pl.Val, error) {
 	switch v.Type {
 	case pl.ValStr:
@@ -182,11 +212,14 @@ func NewBodyValFromVal(v pl.Val) (pl.Val, error) {
 	default:
 		if ValIsReadableStream(v) {
 			x, _ := v.Usr().(*ReadableStream)
-			return NewBodyValFromStream(x.Stream), nil
+			return newBodyValFromStreamKeepCache(x), nil
 		}
 		if ValIsHttpBody(v) {
 			x, _ := v.Usr().(*Body)
-			return NewBodyValFromStream(x.Stream().Stream), nil
+			return newBodyValFromStreamKeepCache(x.Stream()), nil
+		}
+		if pl.IsValTemplateStream(v) {
+			return NewBodyValFromTemplateStream(v), nil
 		}
 		break
 	}
@@ -0,0 +1,126 @@
+package hpl
+
+import (
+	"fmt"
+
+	"github.com/dianpeng/moons/breaker"
+	"github.com/dianpeng/moons/pl"
+)
+
+// breaker option passed from script as a map, the durations are in
+// millisecond. The keys are failure_rate, min_request, window,
+// consecutive_failure, open_timeout and half_open_probe
+func NewBreakerOptionFromVal(v pl.Val) (*breaker.Option, error) {
+	if !v.IsMap() {
+		return nil, fmt.Errorf("breaker option must be map")
+	}
+
+	o := &breaker.Option{}
+	var err error
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "failure_rate":
+				switch {
+				case val.IsReal():
+					o.FailureRate = val.Real()
+				case val.IsInt():
+					o.FailureRate = float64(val.Int())
+				default:
+					err = fmt.Errorf("breaker option %s must be number", key)
+				}
+			case "min_request":
+				err = optionInt(val, key, &o.MinRequest)
+			case "window":
+				err = optionDuration(val, key, &o.Window)
+			case "consecutive_failure":
+				err = optionInt(val, key, &o.ConsecutiveFailure)
+			case "open_timeout":
+				err = optionDuration(val, key, &o.OpenTimeout)
+			case "half_open_probe":
+				err = optionInt(val, key, &o.HalfOpenProbe)
+			default:
+				err = fmt.Errorf("breaker option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func NewBreakerStatsVal(s breaker.Stats) pl.Val {
+	o := pl.NewValMap()
+	o.AddMap("state", pl.NewValStr(s.State))
+	o.AddMap("success", pl.NewValInt(s.Success))
+	o.AddMap("failure", pl.NewValInt(s.Failure))
+	o.AddMap("consecutive_failure", pl.NewValInt(s.Consecutive))
+	o.AddMap("rejected", pl.NewValInt64(s.Rejected))
+	o.AddMap("trips", pl.NewValInt64(s.Trips))
+	return o
+}
+
+// breaker::configure(host, option), host is the host(with port) of the upstream
+// url, the hostname or * for all the upstreams. The http client consults the
+// breaker of the host for every request once it is configured
+func fnBreakerConfigure(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	o, err := NewBreakerOptionFromVal(argument[1])
+	if err != nil {
+		return pl.NewValNull(), fmt.Errorf("breaker::configure invalid option: %s", err.Error())
+	}
+	if err := breaker.Configure(argument[0].String(), o); err != nil {
+		return pl.NewValNull(), err
+	}
+	return pl.NewValNull(), nil
+}
+
+// breaker::state(host), one of closed, open and half_open. The host without
+// breaker configured is always closed
+func fnBreakerState(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	if b := breaker.Find(argument[0].String()); b != nil {
+		return pl.NewValStr(b.State()), nil
+	}
+	return pl.NewValStr(breaker.StateClosed), nil
+}
+
+// breaker::stats(host), null if the host has no breaker yet
+func fnBreakerStats(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	if b := breaker.Find(argument[0].String()); b != nil {
+		return NewBreakerStatsVal(b.Stats()), nil
+	}
+	return pl.NewValNull(), nil
+}
+
+// breaker::reset(host), closes the breaker of the host
+func fnBreakerReset(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	if b := breaker.Find(argument[0].String()); b != nil {
+		b.Reset()
+	}
+	return pl.NewValNull(), nil
+}
+
+// breaker::hosts(), the hosts whose breaker has been created
+func fnBreakerHosts(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	o := pl.NewValList()
+	for _, h := range breaker.Hosts() {
+		o.AddList(pl.NewValStr(h))
+	}
+	return o, nil
+}
@@ -19,6 +19,8 @@ import (
 //   [2]: [header](list[string]),
 //   [3]: [body](string)
 // )
+// or http::do(request, [cookie jar | client option]), the client option is a
+// map, see HttpClientOption, and may carry the cookie jar as "cookie_jar"
 
 var (
 	fnProtoHttpGet  = pl.MustNewModFuncProto("http", "get", "{%s}{%s%a}")
@@ -26,6 +28,8 @@ var (
 
 	fnProtoHttpDo = pl.MustNewModFuncProto("http", "do",
 		"{%U['http.request']}"+ /* just request */
+			"{%U['http.request']%U['http.cookiejar']}"+ /* request, cookie jar */
+			"{%U['http.request']%m}"+ /* request, client option */
 			"{%s%s}"+ /* url, method */
 			"{%s%s%a}"+ /* url, method, header */
 			"{%s%s%a%a}", /* url, method, header, body(string) */
@@ -104,12 +108,13 @@ func FnHttpPost(factory HttpClientFactory, argument []pl.Val) (pl.Val, error) {
 	hreq, err := http.NewRequest(
 		"POST",
 		argument[0].String(),
-		body.Stream().Stream,
+		http.NoBody,
 	)
 
 	if err != nil {
 		return pl.NewValNull(), fmt.Errorf("http::post cannot create request: %s", err.Error())
 	}
+	setRequestBody(hreq, body)
 
 	if headerval.Type != pl.ValNull {
 		hdr, _ := headerval.Usr().(*Header)
@@ -129,6 +134,52 @@ func FnHttpPost(factory HttpClientFactory, argument []pl.Val) (pl.Val, error) {
 	return NewResponseVal(resp), nil
 }
 
+// sets the body of outgoing request, the stream is sent as is without being
+// cached, ie a large upload is streamed to the upstream with chunked encoding.
+// The content length is only known when the stream is already cached, and the
+// request can only be replayed, ie by retry or redirection, in that case
+func setRequestBody(req *http.Request, b *Body) {
+	s := b.Stream()
+	if buf, ok := s.TryCacheBuffer(); ok {
+		if len(buf) == 0 {
+			req.Body = http.NoBody
+			req.ContentLength = 0
+			req.GetBody = nil
+			return
+		}
+		req.Body = neweofByteReadCloser(buf)
+		req.ContentLength = int64(len(buf))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return neweofByteReadCloser(buf), nil
+		}
+		return
+	}
+	req.Body = s.Stream
+	req.ContentLength = -1
+	req.GetBody = nil
+}
+
+// splits the cookie jar out of the option map of http::do
+func httpDoOption(v pl.Val) (*HttpClientOption, *CookieJar, error) {
+	var jar *CookieJar
+	m := pl.NewValMap()
+	v.Map().Foreach(
+		func(k string, x pl.Val) bool {
+			if k == "cookie_jar" && ValIsHttpCookieJar(x) {
+				jar, _ = x.Usr().(*CookieJar)
+			} else {
+				m.AddMap(k, x)
+			}
+			return true
+		},
+	)
+	option, err := NewHttpClientOptionFromVal(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return option, jar, nil
+}
+
 func FnHttpDo(factory HttpClientFactory, argument []pl.Val) (pl.Val, error) {
 	asize, err := fnProtoHttpDo.Check(argument)
 	if err != nil {
@@ -136,13 +187,18 @@ func FnHttpDo(factory HttpClientFactory, argument []pl.Val) (pl.Val, error) {
 	}
 
 	var req *http.Request
+	var jar *CookieJar
+	var option *HttpClientOption
 
-	if asize > 1 {
+	if asize > 1 && argument[0].IsString() {
 		url := argument[0].String()
 		method := argument[1].String()
 
-		var body io.Reader
-		body = http.NoBody
+		if hreq, err := http.NewRequest(method, url, http.NoBody); err != nil {
+			return pl.NewValNull(), fmt.Errorf("http::do cannot create request: %s", err.Error())
+		} else {
+			req = hreq
+		}
 
 		if asize == 4 && argument[3].Type != pl.ValNull {
 			bodyval, err := NewBodyValFromVal(argument[3])
@@ -150,16 +206,10 @@ func FnHttpDo(factory HttpClientFactory, argument []pl.Val) (pl.Val, error) {
 				return pl.NewValNull(), fmt.Errorf("http::do cannot create body: %s", err.Error())
 			}
 			b, _ := bodyval.Usr().(*Body)
-			body = b.Stream().Stream
-		}
-
-		if hreq, err := http.NewRequest(method, url, body); err != nil {
-			return pl.NewValNull(), fmt.Errorf("http::do cannot create request: %s", err.Error())
-		} else {
-			req = hreq
+			setRequestBody(req, b)
 		}
 
-		if asize >= 3 && argument[3].Type != pl.ValNull {
+		if asize >= 3 && argument[2].Type != pl.ValNull {
 			hdrval, err := NewHeaderValFromVal(argument[2])
 			if err != nil {
 				return pl.NewValNull(), fmt.Errorf("http::do cannot create header: %s", err.Error())
@@ -170,14 +220,29 @@ func FnHttpDo(factory HttpClientFactory, argument []pl.Val) (pl.Val, error) {
 	} else {
 		hreq, _ := argument[0].Usr().(*Request)
 		req = hreq.HttpRequest()
+		if asize == 2 {
+			if ValIsHttpCookieJar(argument[1]) {
+				jar, _ = argument[1].Usr().(*CookieJar)
+			} else {
+				option, jar, err = httpDoOption(argument[1])
+				if err != nil {
+					return pl.NewValNull(), fmt.Errorf("http::do invalid option: %s", err.Error())
+				}
+			}
+		}
 	}
 
-	client, err := factory.GetHttpClient(req.URL.String())
+	client, err := getHttpClient(factory, req.URL.String(), option)
 	if err != nil {
 		return pl.NewValNull(), fmt.Errorf("http::do cannot create client: %s", err.Error())
 	}
 
-	resp, err := client.Do(req)
+	var resp *http.Response
+	if jar != nil {
+		resp, err = doWithJar(client, req, jar.Jar())
+	} else {
+		resp, err = client.Do(req)
+	}
 	if err != nil {
 		return pl.NewValNull(), fmt.Errorf("http::do cannot issue request: %s", err.Error())
 	}
@@ -0,0 +1,175 @@
+package hpl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dianpeng/moons/pl"
+)
+
+// http::fetch_all([request], [option]) issues the requests concurrently and
+// returns the result list in the same order as input. Each element of the
+// request list is either a http.request or a url string which means GET. Each
+// result is a map {ok, response, error}, a failed request does not fail the
+// others. The option map accepts
+//   concurrency: max number of inflight requests, default 8
+//   timeout    : deadline in millisecond for all the requests including
+//                reading the response bodies, default none
+//   client     : http client option applied to every request, see
+//                HttpClientOption
+
+const (
+	fetchAllDefaultConcurrency = 8
+	fetchAllMaxConcurrency     = 256
+)
+
+var (
+	fnProtoHttpFetchAll = pl.MustNewModFuncProto("http", "fetch_all", "{%l}{%l%m}")
+)
+
+type fetchAllOption struct {
+	concurrency int
+	timeout     time.Duration
+	client      *HttpClientOption
+}
+
+func newFetchAllOption(v pl.Val) (*fetchAllOption, error) {
+	o := &fetchAllOption{
+		concurrency: fetchAllDefaultConcurrency,
+	}
+	var err error
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "concurrency":
+				if !val.IsInt() || val.Int() <= 0 || val.Int() > fetchAllMaxConcurrency {
+					err = fmt.Errorf("concurrency must be int in range [1, %d]", fetchAllMaxConcurrency)
+				} else {
+					o.concurrency = int(val.Int())
+				}
+			case "timeout":
+				if !val.IsInt() || val.Int() <= 0 {
+					err = fmt.Errorf("timeout must be positive int")
+				} else {
+					o.timeout = time.Duration(val.Int()) * time.Millisecond
+				}
+			case "client":
+				o.client, err = NewHttpClientOptionFromVal(val)
+			default:
+				err = fmt.Errorf("option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func fetchAllRequest(v pl.Val) (*http.Request, error) {
+	if v.IsString() {
+		return http.NewRequest("GET", v.String(), http.NoBody)
+	}
+	if ValIsHttpRequest(v) {
+		r, _ := v.Usr().(*Request)
+		return r.HttpRequest(), nil
+	}
+	return nil, fmt.Errorf("element must be http.request or url string")
+}
+
+func fetchAllResult(resp *http.Response, err error) pl.Val {
+	o := pl.NewValMap()
+	if err != nil {
+		o.AddMap("ok", pl.NewValBool(false))
+		o.AddMap("response", pl.NewValNull())
+		o.AddMap("error", pl.NewValStr(err.Error()))
+	} else {
+		o.AddMap("ok", pl.NewValBool(true))
+		o.AddMap("response", NewResponseVal(resp))
+		o.AddMap("error", pl.NewValNull())
+	}
+	return o
+}
+
+func FnHttpFetchAll(factory HttpClientFactory, argument []pl.Val) (pl.Val, error) {
+	asize, err := fnProtoHttpFetchAll.Check(argument)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+
+	option := &fetchAllOption{
+		concurrency: fetchAllDefaultConcurrency,
+	}
+	if asize == 2 {
+		if option, err = newFetchAllOption(argument[1]); err != nil {
+			return pl.NewValNull(), fmt.Errorf("http::fetch_all invalid option: %s", err.Error())
+		}
+	}
+
+	// the deadline covers reading the response bodies as well, so the context
+	// cannot be canceled when this function returns
+	ctx := context.Background()
+	if option.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, option.timeout)
+		time.AfterFunc(option.timeout, cancel)
+	}
+
+	// the requests and clients are prepared serially since neither the script
+	// values nor the client factory is thread safe, only the round trips are
+	// issued concurrently
+	input := argument[0].List().Data
+	req := make([]*http.Request, len(input))
+	client := make([]HttpClient, len(input))
+	resp := make([]*http.Response, len(input))
+	errs := make([]error, len(input))
+
+	for idx, v := range input {
+		r, err := fetchAllRequest(v)
+		if err != nil {
+			return pl.NewValNull(), fmt.Errorf("http::fetch_all element(%d): %s", idx, err.Error())
+		}
+		c, err := getHttpClient(factory, r.URL.String(), option.client)
+		if err != nil {
+			errs[idx] = err
+			continue
+		}
+		req[idx] = r.WithContext(ctx)
+		client[idx] = c
+	}
+
+	workers := option.concurrency
+	if len(input) < workers {
+		workers = len(input)
+	}
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				resp[idx], errs[idx] = client[idx].Do(req[idx])
+			}
+		}()
+	}
+
+	for idx := range input {
+		if errs[idx] == nil {
+			jobs <- idx
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	output := make([]pl.Val, len(input))
+	for idx := range input {
+		output[idx] = fetchAllResult(resp[idx], errs[idx])
+	}
+	return pl.NewValListRaw(output), nil
+}
@@ -3,6 +3,7 @@ package hpl
 import (
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 
 	// router
@@ -55,6 +56,76 @@ func fnNewRequest(info *pl.IntrinsicInfo,
 	}
 }
 
+func fnNewResponse(info *pl.IntrinsicInfo,
+	_ *pl.Evaluator,
+	_ string,
+	argument []pl.Val,
+) (pl.Val, error) {
+	alog, err := info.Check(argument)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+
+	header := pl.NewValNull()
+	body := pl.NewValNull()
+	if alog >= 2 {
+		header = argument[1]
+	}
+	if alog == 3 {
+		body = argument[2]
+	}
+
+	resp, err := NewResponseValFromVal(int(argument[0].Int()), header, body)
+	if err != nil {
+		return pl.NewValNull(), fmt.Errorf("http::new_response cannot create response: %s", err.Error())
+	}
+	return resp, nil
+}
+
+func fnNewCookie(info *pl.IntrinsicInfo,
+	_ *pl.Evaluator,
+	_ string,
+	argument []pl.Val,
+) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	return NewCookieVal(&http.Cookie{
+		Name:  argument[0].String(),
+		Value: argument[1].String(),
+	}), nil
+}
+
+func fnParseCookie(info *pl.IntrinsicInfo,
+	_ *pl.Evaluator,
+	_ string,
+	argument []pl.Val,
+) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	o := pl.NewValList()
+	for _, c := range ParseCookie(argument[0].String()) {
+		o.AddList(NewCookieVal(c))
+	}
+	return o, nil
+}
+
+func fnParseSetCookie(info *pl.IntrinsicInfo,
+	_ *pl.Evaluator,
+	_ string,
+	argument []pl.Val,
+) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	c, err := ParseSetCookie(argument[0].String())
+	if err != nil {
+		return pl.NewValNull(), fmt.Errorf("http::parse_set_cookie: %s", err.Error())
+	}
+	return NewCookieVal(c), nil
+}
+
 func fnNewHeader(info *pl.IntrinsicInfo,
 	_ *pl.Evaluator,
 	_ string,
@@ -110,6 +181,7 @@ func init() {
 		"",
 		"{%0}{%s}",
 		fnNewUrl,
+		"http::new_url([url]), parses the url, empty url if absent",
 	)
 
 	pl.AddModFunction(
@@ -118,6 +190,16 @@ func init() {
 		"",
 		"{%s%s}{%s%s%s}{%s%s%U['http.body']}{%s%s%U['.readablestream']}",
 		fnNewRequest,
+		"http::new_request(method, url, [body]), the HTTP request",
+	)
+
+	pl.AddModFunction(
+		"http",
+		"new_response",
+		"",
+		"{%d}{%d%a}{%d%a%a}",
+		fnNewResponse,
+		"http::new_response(status, [header], [body]), the HTTP response",
 	)
 
 	pl.AddModFunction(
@@ -126,6 +208,246 @@ func init() {
 		"",
 		"{%p}{%l}{%m}{%U['http.header']}",
 		fnNewHeader,
+		"http::new_header(v), the HTTP header from pair, list, map or header",
+	)
+
+	pl.AddModFunction(
+		"http",
+		"new_cookie",
+		"",
+		"%s:name%s:value",
+		fnNewCookie,
+		"http::new_cookie(name, value), the cookie",
+	)
+
+	pl.AddModFunction(
+		"http",
+		"parse_cookie",
+		"",
+		"%s",
+		fnParseCookie,
+		"http::parse_cookie(s), parses the Cookie header",
+	)
+
+	pl.AddModFunction(
+		"http",
+		"parse_set_cookie",
+		"",
+		"%s",
+		fnParseSetCookie,
+		"http::parse_set_cookie(s), parses the Set-Cookie header",
+	)
+
+	pl.AddModFunction(
+		"http",
+		"new_cookie_jar",
+		"",
+		"%0",
+		func(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+			if _, err := info.Check(argument); err != nil {
+				return pl.NewValNull(), err
+			}
+			return NewCookieJarVal(), nil
+		},
+		"http::new_cookie_jar(), the cookie jar for HTTP client",
+	)
+
+	pl.AddModFunction(
+		"http",
+		"websocket",
+		"",
+		"{%s}{%s%m}",
+		fnHttpWebSocket,
+		"http::websocket(url, [options]), dials the websocket server",
+	)
+
+	pl.AddModFunction(
+		"http",
+		"new_sse_reader",
+		"",
+		"%a",
+		fnNewSSEReader,
+		"http::new_sse_reader(v), reads server-sent events from the stream, body, response or string",
+	)
+
+	pl.AddModFunction(
+		"cache",
+		"new",
+		"",
+		"{%0}{%m}{%m%s}",
+		fnCacheNew,
+		"cache::new([option], [name]), the cache, the named one can be opened by cache::open",
+	)
+
+	pl.AddModFunction(
+		"cache",
+		"open",
+		"",
+		"%s",
+		fnCacheOpen,
+		"cache::open(name), the cache registered under the name",
+	)
+
+	pl.AddModFunction(
+		"cache",
+		"control",
+		"",
+		"%a",
+		fnCacheControl,
+		"cache::control(header), parses the Cache-Control into map",
+	)
+
+	pl.AddModFunction(
+		"cache",
+		"freshness",
+		"",
+		"%a",
+		fnCacheFreshness,
+		"cache::freshness(header), the freshness lifetime in second, or -1",
+	)
+
+	pl.AddModFunction(
+		"cache",
+		"key",
+		"",
+		"{%U['http.request']}{%U['http.request']%a}",
+		fnCacheKey,
+		"cache::key(request, [header]), the cache key of the request, the header's Vary selects the variant",
+	)
+
+	pl.AddModFunction(
+		"cache",
+		"not_modified",
+		"",
+		"%U['http.request']%a",
+		fnCacheNotModified,
+		"cache::not_modified(request, header), evaluates the request's validators against the header",
+	)
+
+	pl.AddModFunction(
+		"cache",
+		"storable",
+		"",
+		"%U['http.request']%d%a",
+		fnCacheStorable,
+		"cache::storable(request, status, header), whether a shared cache can store the response",
+	)
+
+	pl.AddModFunction(
+		"grpc",
+		"new_client",
+		"",
+		"{%s%s}{%s%s%m}",
+		fnNewGrpcClient,
+		"grpc::new_client(target, descriptor_set_file, [option]), the gRPC client",
+	)
+
+	pl.AddModFunction(
+		"grpc",
+		"http_status",
+		"",
+		"%d",
+		fnGrpcHttpStatus,
+		"grpc::http_status(code), the HTTP status of the gRPC code",
+	)
+
+	pl.AddModFunction(
+		"redisclient",
+		"connect",
+		"",
+		"{%s}{%s%m}",
+		fnRedisClientConnect,
+		"redisclient::connect(addr, [option]), connects to the redis server",
+	)
+
+	pl.AddModFunction(
+		"mq",
+		"connect",
+		"",
+		"{%s}{%s%m}",
+		fnMQConnect,
+		"mq::connect(url, [option]), connects to the message broker",
+	)
+
+	pl.AddModFunction(
+		"mq",
+		"drivers",
+		"",
+		"%0",
+		fnMQDrivers,
+		"mq::drivers(), the url schemes of the linked brokers",
+	)
+
+	pl.AddModFunction(
+		"ratelimit",
+		"new",
+		"",
+		"{%s%a}{%s%a%d}{%s%a%d%m}",
+		fnRateLimitNew,
+		"ratelimit::new(key_space, rate, [burst], [option]), the rate limiter shared by the key space",
+	)
+
+	pl.AddModFunction(
+		"ratelimit",
+		"open",
+		"",
+		"%s",
+		fnRateLimitOpen,
+		"ratelimit::open(key_space), the rate limiter of the key space",
+	)
+
+	pl.AddModFunction(
+		"ratelimit",
+		"concurrency",
+		"",
+		"{%0}{%s}",
+		fnRateLimitConcurrency,
+		"ratelimit::concurrency([name]), the occupancy of the concurrency limiter, or of all the limiters",
+	)
+
+	pl.AddModFunction(
+		"breaker",
+		"configure",
+		"",
+		"%s%m",
+		fnBreakerConfigure,
+		"breaker::configure(host, option), configures the circuit breaker of the upstream host, * for all",
+	)
+
+	pl.AddModFunction(
+		"breaker",
+		"state",
+		"",
+		"%s",
+		fnBreakerState,
+		"breaker::state(host), one of closed, open and half_open",
+	)
+
+	pl.AddModFunction(
+		"breaker",
+		"stats",
+		"",
+		"%s",
+		fnBreakerStats,
+		"breaker::stats(host), the statistics of the breaker, null if the host has no breaker yet",
+	)
+
+	pl.AddModFunction(
+		"breaker",
+		"reset",
+		"",
+		"%s",
+		fnBreakerReset,
+		"breaker::reset(host), closes the breaker of the host",
+	)
+
+	pl.AddModFunction(
+		"breaker",
+		"hosts",
+		"",
+		"%0",
+		fnBreakerHosts,
+		"breaker::hosts(), the hosts whose breaker has been created",
 	)
 
 	pl.AddModFunction(
@@ -134,6 +456,7 @@ func init() {
 		"",
 		"%a*",
 		fnConcateHttpBody,
+		"http::concate_body(...), concatenates the bodies",
 	)
 
 	pl.AddModFunction(
@@ -152,5 +475,6 @@ func init() {
 			}
 			return v, nil
 		},
+		"http::new_url_search(s), parses the URL query string",
 	)
 }
@@ -0,0 +1,361 @@
+package hpl
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dianpeng/moons/cache"
+	"github.com/dianpeng/moons/pl"
+)
+
+// cache option passed from script as a map, the keys are max_size,
+// max_entries, max_entry_size, ttl in millisecond and dir of the disk backend
+func NewCacheOptionFromVal(v pl.Val) (*cache.Option, error) {
+	if !v.IsMap() {
+		return nil, fmt.Errorf("cache option must be map")
+	}
+
+	o := &cache.Option{}
+	var err error
+
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "max_size":
+				err = cacheOptionInt64(val, key, &o.MaxSize)
+			case "max_entries":
+				var n int64
+				err = cacheOptionInt64(val, key, &n)
+				o.MaxEntries = int(n)
+			case "max_entry_size":
+				err = cacheOptionInt64(val, key, &o.MaxEntrySize)
+			case "ttl":
+				var n int64
+				err = cacheOptionInt64(val, key, &n)
+				o.TTL = time.Duration(n) * time.Millisecond
+			case "dir":
+				if val.IsString() {
+					o.Dir = val.String()
+				} else {
+					err = fmt.Errorf("cache option %s must be string", key)
+				}
+			default:
+				err = fmt.Errorf("cache option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func cacheOptionInt64(v pl.Val, name string, ptr *int64) error {
+	if !v.IsInt() || v.Int() < 0 {
+		return fmt.Errorf("cache option %s must be non negative int", name)
+	}
+	*ptr = v.Int()
+	return nil
+}
+
+// Cache is the script handle of a shared cache, the value is stored as JSON
+// so it is shared among sessions safely and can be kept by the disk backend
+type Cache struct {
+	cache *cache.Cache
+}
+
+func ValIsCache(v pl.Val) bool {
+	return v.Id() == CacheTypeId
+}
+
+func NewCacheVal(c *cache.Cache) pl.Val {
+	return pl.NewValUsr(&Cache{cache: c})
+}
+
+func (c *Cache) Cache() *cache.Cache {
+	return c.cache
+}
+
+func (c *Cache) Index(key pl.Val) (pl.Val, error) {
+	if !key.IsString() {
+		return pl.NewValNull(), fmt.Errorf("%s index key must be string", c.Id())
+	}
+	return c.get(key.String())
+}
+
+func (c *Cache) IndexSet(key pl.Val, val pl.Val) error {
+	if !key.IsString() {
+		return fmt.Errorf("%s index key must be string", c.Id())
+	}
+	return c.set(key.String(), val, 0)
+}
+
+func (c *Cache) Dot(name string) (pl.Val, error) {
+	switch name {
+	case "length":
+		return pl.NewValInt(c.cache.Len()), nil
+	case "size":
+		return pl.NewValInt64(c.cache.Size()), nil
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s unknown field %s", c.Id(), name)
+}
+
+func (c *Cache) DotSet(_ string, _ pl.Val) error {
+	return fmt.Errorf("%s does not support dot set", c.Id())
+}
+
+func (c *Cache) ToString() (string, error) {
+	return c.Info(), nil
+}
+
+func (c *Cache) ToJSON() (pl.Val, error) {
+	return pl.MarshalVal(
+		map[string]interface{}{
+			"type":   CacheTypeId,
+			"length": c.cache.Len(),
+			"size":   c.cache.Size(),
+		},
+	)
+}
+
+func (c *Cache) get(key string) (pl.Val, error) {
+	e := c.cache.Get(key)
+	if e == nil || !e.IsFresh(time.Now()) {
+		return pl.NewValNull(), nil
+	}
+	return pl.NewValFromJSON(string(e.Body))
+}
+
+func (c *Cache) set(key string, val pl.Val, ttl time.Duration) error {
+	data, err := val.ToJSONString()
+	if err != nil {
+		return fmt.Errorf("%s:set, value cannot be serialized: %s", c.Id(), err.Error())
+	}
+	e := &cache.Entry{
+		Body: []byte(data),
+	}
+	if ttl > 0 {
+		e.Stored = time.Now()
+		e.Fresh = e.Stored.Add(ttl)
+		e.Expire = e.Fresh
+	}
+	_, err = c.cache.Set(key, e)
+	return err
+}
+
+var (
+	methodProtoCacheGet   = pl.MustNewFuncProto("cache.store.get", "%s")
+	methodProtoCacheSet   = pl.MustNewFuncProto("cache.store.set", "{%s%a}{%s%a%d}")
+	methodProtoCacheHas   = pl.MustNewFuncProto("cache.store.has", "%s")
+	methodProtoCacheDel   = pl.MustNewFuncProto("cache.store.del", "%s")
+	methodProtoCachePurge = pl.MustNewFuncProto("cache.store.purge", "%0")
+)
+
+func (c *Cache) Method(name string, args []pl.Val) (pl.Val, error) {
+	switch name {
+	case "get":
+		if _, err := methodProtoCacheGet.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		return c.get(args[0].String())
+
+	// set(key, value, [ttl]), the ttl is in millisecond and is capped by the
+	// cache's ttl option
+	case "set":
+		alen, err := methodProtoCacheSet.Check(args)
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		ttl := time.Duration(0)
+		if alen == 3 {
+			ttl = time.Duration(args[2].Int()) * time.Millisecond
+		}
+		if err := c.set(args[0].String(), args[1], ttl); err != nil {
+			return pl.NewValNull(), err
+		}
+		return pl.NewValNull(), nil
+
+	case "has":
+		if _, err := methodProtoCacheHas.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		e := c.cache.Get(args[0].String())
+		return pl.NewValBool(e != nil && e.IsFresh(time.Now())), nil
+
+	case "del":
+		if _, err := methodProtoCacheDel.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		c.cache.Delete(args[0].String())
+		return pl.NewValNull(), nil
+
+	case "purge":
+		if _, err := methodProtoCachePurge.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		c.cache.Purge()
+		return pl.NewValNull(), nil
+
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s's method %s is unknown", c.Id(), name)
+}
+
+func (c *Cache) Info() string {
+	return c.Id()
+}
+
+func (c *Cache) Id() string {
+	return CacheTypeId
+}
+
+func (c *Cache) IsThreadSafe() bool {
+	return true
+}
+
+func (c *Cache) NewIterator() (pl.Iter, error) {
+	return nil, fmt.Errorf("%s does not support iterator", c.Id())
+}
+
+// cache::new([option], [name]), the cache with name is registered and can be
+// opened by cache::open, ie from other vhosts
+func fnCacheNew(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	alen, err := info.Check(argument)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	option := &cache.Option{}
+	if alen >= 1 {
+		if option, err = NewCacheOptionFromVal(argument[0]); err != nil {
+			return pl.NewValNull(), fmt.Errorf("cache::new invalid option: %s", err.Error())
+		}
+	}
+	c, err := cache.New(option)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	if alen == 2 {
+		cache.Register(argument[1].String(), c)
+	}
+	return NewCacheVal(c), nil
+}
+
+// cache::open(name), the vhost's cache is registered under the vhost name
+func fnCacheOpen(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	c := cache.Find(argument[0].String())
+	if c == nil {
+		return pl.NewValNull(), fmt.Errorf("cache::open, cache %s is not found", argument[0].String())
+	}
+	return NewCacheVal(c), nil
+}
+
+func cacheHeaderArg(v pl.Val) (http.Header, error) {
+	h := make(http.Header)
+	if v.IsString() {
+		h.Set("Cache-Control", v.String())
+		return h, nil
+	}
+	if ValIsHttpResponse(v) {
+		return v.Usr().(*Response).HttpResponse().Header, nil
+	}
+	if !foreachHeaderKV(v, func(k, v string) { h.Add(k, v) }) {
+		return nil, fmt.Errorf("expect header, response, map or list of pairs")
+	}
+	return h, nil
+}
+
+// cache::control(header), parses the Cache-Control into map, the directive
+// without argument is true. A string argument is the Cache-Control itself
+func fnCacheControl(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	h, err := cacheHeaderArg(argument[0])
+	if err != nil {
+		return pl.NewValNull(), fmt.Errorf("cache::control %s", err.Error())
+	}
+	o := pl.NewValMap()
+	for k, v := range cache.ParseControl(h.Values("Cache-Control")) {
+		if v == "" {
+			o.AddMap(k, pl.NewValBool(true))
+		} else {
+			o.AddMap(k, pl.NewValStr(v))
+		}
+	}
+	return o, nil
+}
+
+// cache::freshness(header), the freshness lifetime in second of the response
+// for a shared cache, or -1 if the response has no explicit lifetime
+func fnCacheFreshness(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	h, err := cacheHeaderArg(argument[0])
+	if err != nil {
+		return pl.NewValNull(), fmt.Errorf("cache::freshness %s", err.Error())
+	}
+	d, ok := cache.Freshness(h, time.Now())
+	if !ok {
+		return pl.NewValInt(-1), nil
+	}
+	return pl.NewValInt64(int64(d / time.Second)), nil
+}
+
+// cache::key(request, [header]), the cache key of the request, the response
+// header's Vary selects the variant
+func fnCacheKey(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	alen, err := info.Check(argument)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	r := argument[0].Usr().(*Request).HttpRequest()
+	key := cache.Key(r)
+	if alen == 2 {
+		h, err := cacheHeaderArg(argument[1])
+		if err != nil {
+			return pl.NewValNull(), fmt.Errorf("cache::key %s", err.Error())
+		}
+		if vary := cache.VaryNames(h); len(vary) != 0 {
+			key = cache.VaryKey(key, vary, r.Header)
+		}
+	}
+	return pl.NewValStr(key), nil
+}
+
+// cache::not_modified(request, header), evaluates the request's validators
+// against the response header's ETag and Last-Modified
+func fnCacheNotModified(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	h, err := cacheHeaderArg(argument[1])
+	if err != nil {
+		return pl.NewValNull(), fmt.Errorf("cache::not_modified %s", err.Error())
+	}
+	r := argument[0].Usr().(*Request).HttpRequest()
+	return pl.NewValBool(cache.NotModified(r, h)), nil
+}
+
+// cache::storable(request, status, header), whether a shared cache can store
+// the response
+func fnCacheStorable(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	h, err := cacheHeaderArg(argument[2])
+	if err != nil {
+		return pl.NewValNull(), fmt.Errorf("cache::storable %s", err.Error())
+	}
+	r := argument[0].Usr().(*Request).HttpRequest()
+	return pl.NewValBool(cache.Storable(r, int(argument[1].Int()), h)), nil
+}
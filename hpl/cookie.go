@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/dianpeng/moons/pl"
 	"net/http"
+	"time"
 )
 
 type cookie struct {
@@ -14,6 +15,54 @@ func (c *cookie) Cookie() *http.Cookie {
 	return c.c
 }
 
+func ValIsHttpCookie(v pl.Val) bool {
+	return v.Id() == HttpCookieTypeId
+}
+
+// the underlying http.Cookie of a http.cookie value
+func HttpCookieFromVal(v pl.Val) (*http.Cookie, bool) {
+	if !ValIsHttpCookie(v) {
+		return nil, false
+	}
+	c, _ := v.Usr().(*cookie)
+	return c.c, true
+}
+
+// parses the value of Cookie header, ie "a=b; c=d", into cookies
+func ParseCookie(x string) []*http.Cookie {
+	r := &http.Request{
+		Header: http.Header{"Cookie": {x}},
+	}
+	return r.Cookies()
+}
+
+// parses the value of Set-Cookie header into a cookie
+func ParseSetCookie(x string) (*http.Cookie, error) {
+	r := &http.Response{
+		Header: http.Header{"Set-Cookie": {x}},
+	}
+	l := r.Cookies()
+	if len(l) == 0 {
+		return nil, fmt.Errorf("invalid Set-Cookie value: %s", x)
+	}
+	return l[0], nil
+}
+
+func parseSameSite(x string) (http.SameSite, bool) {
+	switch x {
+	case "default":
+		return http.SameSiteDefaultMode, true
+	case "lax":
+		return http.SameSiteLaxMode, true
+	case "strict":
+		return http.SameSiteStrictMode, true
+	case "none":
+		return http.SameSiteNoneMode, true
+	default:
+		return http.SameSiteDefaultMode, false
+	}
+}
+
 func (c *cookie) Index(key pl.Val) (pl.Val, error) {
 	if !key.IsString() {
 		return pl.NewValNull(), fmt.Errorf("invalid index, cookie's index type must be string")
@@ -49,6 +98,11 @@ func (c *cookie) Dot(key string) (pl.Val, error) {
 		return pl.NewValStr(c.c.Domain), nil
 	case "expireString":
 		return pl.NewValStr(c.c.RawExpires), nil
+	case "expires":
+		if c.c.Expires.IsZero() {
+			return pl.NewValNull(), nil
+		}
+		return pl.NewValInt64(c.c.Expires.Unix()), nil
 
 	case "maxAge":
 		if c.c.MaxAge == 0 {
@@ -146,6 +200,25 @@ func (c *cookie) DotSet(key string, val pl.Val) error {
 		}
 		break
 
+	case "sameSite":
+		if !val.IsString() {
+			return fmt.Errorf("%s's field 'sameSite' must set with value of type string", c.Id())
+		}
+		if ss, ok := parseSameSite(val.String()); ok {
+			c.c.SameSite = ss
+		} else {
+			return fmt.Errorf("%s's field 'sameSite' value %s is unknown", c.Id(), val.String())
+		}
+		break
+
+	case "expires":
+		if val.IsInt() {
+			c.c.Expires = time.Unix(val.Int(), 0).UTC()
+		} else {
+			return fmt.Errorf("%s's field 'expires' must set with value of type int", c.Id())
+		}
+		break
+
 	default:
 		return fmt.Errorf("%s's field %s is unknown", c.Id(), key)
 	}
@@ -177,7 +250,8 @@ func (c *cookie) ToJSON() (pl.Val, error) {
 }
 
 var (
-	cookieMpProto = pl.MustNewFuncProto("http.cookie.isValid", "%0")
+	cookieMpProto       = pl.MustNewFuncProto("http.cookie.isValid", "%0")
+	cookieMpProtoString = pl.MustNewFuncProto("http.cookie.string", "%0")
 )
 
 func (c *cookie) Method(name string, args []pl.Val) (pl.Val, error) {
@@ -187,6 +261,13 @@ func (c *cookie) Method(name string, args []pl.Val) (pl.Val, error) {
 			return pl.NewValNull(), err
 		}
 		return pl.NewValBool(c.c.Valid() == nil), nil
+
+	// serialized as the value of Set-Cookie header
+	case "string":
+		if _, err := cookieMpProtoString.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		return pl.NewValStr(c.c.String()), nil
 	default:
 		break
 	}
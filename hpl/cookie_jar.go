@@ -0,0 +1,165 @@
+package hpl
+
+import (
+	"fmt"
+	"github.com/dianpeng/moons/pl"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+)
+
+// cookie jar which can be passed to http::do, the cookies received by the
+// request, including the ones received during redirection, are stored in the
+// jar and sent with the following requests
+type CookieJar struct {
+	jar http.CookieJar
+}
+
+func ValIsHttpCookieJar(v pl.Val) bool {
+	return v.Id() == HttpCookieJarTypeId
+}
+
+func (c *CookieJar) Jar() http.CookieJar {
+	return c.jar
+}
+
+func (c *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return c.jar.Cookies(u)
+}
+
+func (c *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	c.jar.SetCookies(u, cookies)
+}
+
+func (c *CookieJar) Index(_ pl.Val) (pl.Val, error) {
+	return pl.NewValNull(), fmt.Errorf("%s does not support index", c.Id())
+}
+
+func (c *CookieJar) IndexSet(_ pl.Val, _ pl.Val) error {
+	return fmt.Errorf("%s does not support index set", c.Id())
+}
+
+func (c *CookieJar) Dot(_ string) (pl.Val, error) {
+	return pl.NewValNull(), fmt.Errorf("%s does not support dot", c.Id())
+}
+
+func (c *CookieJar) DotSet(_ string, _ pl.Val) error {
+	return fmt.Errorf("%s does not support dot set", c.Id())
+}
+
+func (c *CookieJar) ToString() (string, error) {
+	return c.Info(), nil
+}
+
+func (c *CookieJar) ToJSON() (pl.Val, error) {
+	return pl.MarshalVal(
+		map[string]interface{}{
+			"type": HttpCookieJarTypeId,
+		},
+	)
+}
+
+var (
+	methodProtoCookieJarCookies    = pl.MustNewFuncProto("http.cookiejar.cookies", "%s")
+	methodProtoCookieJarSetCookies = pl.MustNewFuncProto("http.cookiejar.setCookies", "{%s%U['http.cookie']}{%s%l}")
+)
+
+func (c *CookieJar) Method(name string, args []pl.Val) (pl.Val, error) {
+	switch name {
+	case "cookies":
+		if _, err := methodProtoCookieJarCookies.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		u, err := url.Parse(args[0].String())
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		o := pl.NewValList()
+		for _, x := range c.Cookies(u) {
+			o.AddList(NewCookieVal(x))
+		}
+		return o, nil
+
+	case "setCookies":
+		if _, err := methodProtoCookieJarSetCookies.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		u, err := url.Parse(args[0].String())
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		var l []pl.Val
+		if args[1].IsList() {
+			l = args[1].List().Data
+		} else {
+			l = []pl.Val{args[1]}
+		}
+		cookies := []*http.Cookie{}
+		for _, x := range l {
+			hc, ok := HttpCookieFromVal(x)
+			if !ok {
+				return pl.NewValNull(), fmt.Errorf("%s:setCookies expects list of http.cookie", c.Id())
+			}
+			cookies = append(cookies, hc)
+		}
+		c.SetCookies(u, cookies)
+		return pl.NewValNull(), nil
+
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s's method %s is unknown", c.Id(), name)
+}
+
+func (c *CookieJar) Info() string {
+	return c.Id()
+}
+
+func (c *CookieJar) Id() string {
+	return HttpCookieJarTypeId
+}
+
+// the cookiejar.Jar is safe for concurrent usage, so the jar can be shared
+// among sessions
+func (c *CookieJar) IsThreadSafe() bool {
+	return true
+}
+
+func (c *CookieJar) NewIterator() (pl.Iter, error) {
+	return nil, fmt.Errorf("%s does not support iterator", c.Id())
+}
+
+func NewCookieJarVal() pl.Val {
+	jar, _ := cookiejar.New(nil)
+	return pl.NewValUsr(
+		&CookieJar{jar: jar},
+	)
+}
+
+// issues the request with the cookie jar. When the client does not support
+// the jar natively, the cookies are only applied to the request and collected
+// from the final response
+func doWithJar(client HttpClient, req *http.Request, jar http.CookieJar) (*http.Response, error) {
+	switch c := client.(type) {
+	case HttpJarClient:
+		return c.DoWithJar(req, jar)
+	case *http.Client:
+		x := *c
+		x.Jar = jar
+		return x.Do(req)
+	default:
+		break
+	}
+
+	for _, x := range jar.Cookies(req.URL) {
+		req.AddCookie(x)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if cl := resp.Cookies(); len(cl) > 0 {
+		jar.SetCookies(req.URL, cl)
+	}
+	return resp, nil
+}
@@ -0,0 +1,161 @@
+package hpl
+
+import (
+	"fmt"
+
+	"github.com/dianpeng/moons/fault"
+	"github.com/dianpeng/moons/pl"
+)
+
+// The faults of the fault middleware, ie
+//
+//   {
+//     "header": "x-chaos",
+//     "delay": { "rate": 0.1, "latency": 200, "jitter": 100 },
+//     "abort": { "rate": 0.05, "status": 503, "body": "injected" },
+//     "reset": { "rate": 0.01 },
+//     "throttle": { "rate": 0.1, "bytes_per_second": 4096 }
+//   }
+
+func faultSection(v pl.Val, name string, f func(string, pl.Val) error) error {
+	if !v.IsMap() {
+		return fmt.Errorf("fault %s must be map", name)
+	}
+	var err error
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			err = f(key, val)
+			return err == nil
+		},
+	)
+	return err
+}
+
+func faultRate(v pl.Val, name string, ptr *float64) error {
+	if !v.IsNumber() {
+		return fmt.Errorf("fault %s must be number", name)
+	}
+	if v.IsInt() {
+		*ptr = float64(v.Int())
+	} else {
+		*ptr = v.Real()
+	}
+	return nil
+}
+
+func faultInt64(v pl.Val, name string, ptr *int64) error {
+	if !v.IsInt() || v.Int() < 0 {
+		return fmt.Errorf("fault %s must be non negative int", name)
+	}
+	*ptr = v.Int()
+	return nil
+}
+
+func faultStr(v pl.Val, name string, ptr *string) error {
+	if !v.IsString() {
+		return fmt.Errorf("fault %s must be string", name)
+	}
+	*ptr = v.String()
+	return nil
+}
+
+func newFaultDelayFromVal(v pl.Val) (*fault.DelayOption, error) {
+	o := &fault.DelayOption{}
+	err := faultSection(v, "delay", func(key string, val pl.Val) error {
+		switch key {
+		case "rate":
+			return faultRate(val, "delay.rate", &o.Rate)
+		case "latency":
+			return faultInt64(val, "delay.latency", &o.Latency)
+		case "jitter":
+			return faultInt64(val, "delay.jitter", &o.Jitter)
+		default:
+			return fmt.Errorf("fault delay.%s is unknown", key)
+		}
+	})
+	return o, err
+}
+
+func newFaultAbortFromVal(v pl.Val) (*fault.AbortOption, error) {
+	o := &fault.AbortOption{}
+	err := faultSection(v, "abort", func(key string, val pl.Val) error {
+		switch key {
+		case "rate":
+			return faultRate(val, "abort.rate", &o.Rate)
+		case "status":
+			var status int64
+			if err := faultInt64(val, "abort.status", &status); err != nil {
+				return err
+			}
+			o.Status = int(status)
+			return nil
+		case "body":
+			return faultStr(val, "abort.body", &o.Body)
+		default:
+			return fmt.Errorf("fault abort.%s is unknown", key)
+		}
+	})
+	return o, err
+}
+
+func newFaultResetFromVal(v pl.Val) (*fault.ResetOption, error) {
+	o := &fault.ResetOption{}
+	err := faultSection(v, "reset", func(key string, val pl.Val) error {
+		switch key {
+		case "rate":
+			return faultRate(val, "reset.rate", &o.Rate)
+		default:
+			return fmt.Errorf("fault reset.%s is unknown", key)
+		}
+	})
+	return o, err
+}
+
+func newFaultThrottleFromVal(v pl.Val) (*fault.ThrottleOption, error) {
+	o := &fault.ThrottleOption{}
+	err := faultSection(v, "throttle", func(key string, val pl.Val) error {
+		switch key {
+		case "rate":
+			return faultRate(val, "throttle.rate", &o.Rate)
+		case "bytes_per_second":
+			return faultInt64(val, "throttle.bytes_per_second", &o.BytesPerSecond)
+		default:
+			return fmt.Errorf("fault throttle.%s is unknown", key)
+		}
+	})
+	return o, err
+}
+
+func NewFaultOptionFromVal(v pl.Val) (*fault.Option, error) {
+	o := &fault.Option{}
+	err := faultSection(v, "option", func(key string, val pl.Val) error {
+		var err error
+		switch key {
+		case "disabled":
+			if !val.IsBool() {
+				return fmt.Errorf("fault disabled must be bool")
+			}
+			o.Disabled = val.Bool()
+		case "header":
+			err = faultStr(val, key, &o.Header)
+		case "delay":
+			o.Delay, err = newFaultDelayFromVal(val)
+		case "abort":
+			o.Abort, err = newFaultAbortFromVal(val)
+		case "reset":
+			o.Reset, err = newFaultResetFromVal(val)
+		case "throttle":
+			o.Throttle, err = newFaultThrottleFromVal(val)
+		default:
+			err = fmt.Errorf("fault %s is unknown", key)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
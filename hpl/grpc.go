@@ -0,0 +1,203 @@
+package hpl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/dianpeng/moons/grpc"
+	"github.com/dianpeng/moons/pl"
+)
+
+// gRPC client of an upstream, the methods and messages are described by the
+// descriptor set file. Requests and responses are maps following the protobuf
+// JSON mapping, so a PL gateway can transcode http/JSON to gRPC
+type GrpcClient struct {
+	client  *grpc.Client
+	timeout time.Duration
+}
+
+func ValIsGrpcClient(v pl.Val) bool {
+	return v.Id() == GrpcClientTypeId
+}
+
+func (c *GrpcClient) Client() *grpc.Client {
+	return c.client
+}
+
+func (c *GrpcClient) Index(_ pl.Val) (pl.Val, error) {
+	return pl.NewValNull(), fmt.Errorf("%s does not support index", c.Id())
+}
+
+func (c *GrpcClient) IndexSet(_ pl.Val, _ pl.Val) error {
+	return fmt.Errorf("%s does not support index set", c.Id())
+}
+
+func (c *GrpcClient) Dot(_ string) (pl.Val, error) {
+	return pl.NewValNull(), fmt.Errorf("%s does not support dot", c.Id())
+}
+
+func (c *GrpcClient) DotSet(_ string, _ pl.Val) error {
+	return fmt.Errorf("%s does not support dot set", c.Id())
+}
+
+func (c *GrpcClient) ToString() (string, error) {
+	return c.Info(), nil
+}
+
+func (c *GrpcClient) ToJSON() (pl.Val, error) {
+	return pl.MarshalVal(
+		map[string]interface{}{
+			"type": GrpcClientTypeId,
+		},
+	)
+}
+
+var (
+	methodProtoGrpcClientCall    = pl.MustNewFuncProto("grpc.client.call", "{%s%m}{%s%m%a}{%s%m%a%d}")
+	methodProtoGrpcClientMethods = pl.MustNewFuncProto("grpc.client.methods", "%0")
+)
+
+func grpcResultVal(r *grpc.Result) (pl.Val, error) {
+	o := pl.NewValMap()
+	o.AddMap("ok", pl.NewValBool(r.Code == grpc.CodeOK))
+	o.AddMap("code", pl.NewValInt(r.Code))
+	o.AddMap("message", pl.NewValStr(r.Message))
+	if r.Response != nil {
+		resp, err := pl.MarshalVal(r.Response)
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		o.AddMap("response", resp)
+	} else {
+		o.AddMap("response", pl.NewValNull())
+	}
+	o.AddMap("header", NewHeaderVal(r.Header))
+	o.AddMap("trailer", NewHeaderVal(r.Trailer))
+	return o, nil
+}
+
+func (c *GrpcClient) call(args []pl.Val, alen int) (pl.Val, error) {
+	var request map[string]interface{}
+	if err := pl.Unmarshal(args[1], &request); err != nil {
+		return pl.NewValNull(), fmt.Errorf("%s:call, invalid request: %s", c.Id(), err.Error())
+	}
+
+	metadata := make(http.Header)
+	if alen >= 3 && !args[2].IsNull() {
+		if !foreachHeaderKV(args[2], func(k, v string) { metadata.Add(k, v) }) {
+			return pl.NewValNull(), fmt.Errorf("%s:call, invalid metadata", c.Id())
+		}
+	}
+
+	timeout := c.timeout
+	if alen == 4 {
+		timeout = time.Duration(args[3].Int()) * time.Millisecond
+	}
+
+	r, err := c.client.Call(
+		context.Background(),
+		args[0].String(),
+		request,
+		metadata,
+		timeout,
+	)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	return grpcResultVal(r)
+}
+
+func (c *GrpcClient) Method(name string, args []pl.Val) (pl.Val, error) {
+	switch name {
+	// call(method, request, [metadata], [timeout]) returns the result map
+	// {ok, code, message, response, header, trailer}
+	case "call":
+		alen, err := methodProtoGrpcClientCall.Check(args)
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		return c.call(args, alen)
+
+	case "methods":
+		if _, err := methodProtoGrpcClientMethods.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		l := c.client.Registry().Methods()
+		sort.Strings(l)
+		o := pl.NewValList()
+		for _, x := range l {
+			o.AddList(pl.NewValStr(x))
+		}
+		return o, nil
+
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s's method %s is unknown", c.Id(), name)
+}
+
+func (c *GrpcClient) Info() string {
+	return c.Id()
+}
+
+func (c *GrpcClient) Id() string {
+	return GrpcClientTypeId
+}
+
+// the client holds only immutable descriptors and the http client, so it can
+// be created in global and shared among sessions
+func (c *GrpcClient) IsThreadSafe() bool {
+	return true
+}
+
+func (c *GrpcClient) NewIterator() (pl.Iter, error) {
+	return nil, fmt.Errorf("%s does not support iterator", c.Id())
+}
+
+// grpc::new_client(target, descriptor_set_file, [option]), the option is the
+// http client option map, its timeout is the default deadline of each call
+func fnNewGrpcClient(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	alen, err := info.Check(argument)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+
+	option := &HttpClientOption{}
+	if alen == 3 {
+		if option, err = NewHttpClientOptionFromVal(argument[2]); err != nil {
+			return pl.NewValNull(), fmt.Errorf("grpc::new_client invalid option: %s", err.Error())
+		}
+	}
+
+	registry, err := grpc.LoadRegistryFromFile(argument[1].String())
+	if err != nil {
+		return pl.NewValNull(), fmt.Errorf("grpc::new_client cannot load descriptor set: %s", err.Error())
+	}
+
+	transport, err := option.NewTransport()
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+
+	client, err := grpc.NewClient(argument[0].String(), registry, transport)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+
+	return pl.NewValUsr(
+		&GrpcClient{
+			client:  client,
+			timeout: option.Timeout,
+		},
+	), nil
+}
+
+func fnGrpcHttpStatus(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	return pl.NewValInt(grpc.HTTPStatus(int(argument[0].Int()))), nil
+}
@@ -64,6 +64,10 @@ func (h *Header) Get(key string, d string) string {
 	return strings.Join(h.header.Values(key), d)
 }
 
+func (h *Header) Values(key string) []string {
+	return h.header.Values(key)
+}
+
 func (h *Header) Set(key string, d string) {
 	h.header.Set(key, d)
 }
@@ -131,6 +135,7 @@ var (
 	methodProtoHeaderSet            = pl.MustNewFuncProto("http.header.set", "%s%s")
 	methodProtoHeaderAdd            = pl.MustNewFuncProto("http.header.add", "%s%s")
 	methodProtoHeaderLength         = pl.MustNewFuncProto("http.header.length", "%0")
+	methodProtoHeaderValues         = pl.MustNewFuncProto("http.header.values", "%s")
 )
 
 func (h *Header) Method(name string, arg []pl.Val) (pl.Val, error) {
@@ -174,6 +179,16 @@ func (h *Header) Method(name string, arg []pl.Val) (pl.Val, error) {
 		}
 		return pl.NewValInt(h.DeleteByFilter(arg[0])), nil
 
+	case "values":
+		if _, err := methodProtoHeaderValues.Check(arg); err != nil {
+			return pl.NewValNull(), err
+		}
+		o := pl.NewValList()
+		for _, v := range h.Values(arg[0].String()) {
+			o.AddList(pl.NewValStr(v))
+		}
+		return o, nil
+
 	case "set":
 		if _, err := methodProtoHeaderSet.Check(arg); err != nil {
 			return pl.NewValNull(), err
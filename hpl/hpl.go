@@ -19,6 +19,12 @@ type HttpClient interface {
 	Do(*http.Request) (*http.Response, error)
 }
 
+// optional interface of HttpClient, issues the request with a cookie jar which
+// sees the cookies of all the redirections
+type HttpJarClient interface {
+	DoWithJar(*http.Request, http.CookieJar) (*http.Response, error)
+}
+
 type HttpClientFactory interface {
 	GetHttpClient(url string) (HttpClient, error)
 }
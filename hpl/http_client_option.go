@@ -0,0 +1,166 @@
+package hpl
+
+import (
+	"fmt"
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// client option passed from script as a map, all the durations are in
+// millisecond. The keys are timeout, connect_timeout, read_timeout,
+// expect_continue_timeout, idle_conn_timeout, max_idle_conns, max_idle_conns_per_host,
+// max_conns_per_host, retry, retry_backoff, retry_max_backoff, retry_on,
+// retry_non_idempotent, per_try_timeout, insecure_skip_verify, server_name,
+// ca_file, cert_file, key_file and balance_key
+type HttpClientOption = util.HClientOption
+
+// optional interface of HttpClientFactory, creates client with the per call
+// option applied on top of the destination's option
+type HttpClientOptionFactory interface {
+	GetHttpClientWithOption(url string, option *HttpClientOption) (HttpClient, error)
+}
+
+func getHttpClient(factory HttpClientFactory, url string, option *HttpClientOption) (HttpClient, error) {
+	if option == nil {
+		return factory.GetHttpClient(url)
+	}
+	if f, ok := factory.(HttpClientOptionFactory); ok {
+		return f.GetHttpClientWithOption(url, option)
+	}
+	c, err := util.NewHClient(nil, option)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func optionDuration(v pl.Val, name string, ptr *time.Duration) error {
+	if !v.IsInt() || v.Int() < 0 {
+		return fmt.Errorf("http client option %s must be non negative int", name)
+	}
+	*ptr = time.Duration(v.Int()) * time.Millisecond
+	return nil
+}
+
+func optionInt(v pl.Val, name string, ptr *int) error {
+	if !v.IsInt() || v.Int() < 0 {
+		return fmt.Errorf("http client option %s must be non negative int", name)
+	}
+	*ptr = int(v.Int())
+	return nil
+}
+
+func optionStr(v pl.Val, name string, ptr *string) error {
+	if !v.IsString() {
+		return fmt.Errorf("http client option %s must be string", name)
+	}
+	*ptr = v.String()
+	return nil
+}
+
+// status code or class, ie 503 or "5xx"
+func optionRetryOn(v pl.Val, ptr *[]string) error {
+	list := []pl.Val{v}
+	if v.IsList() {
+		list = v.List().Data
+	}
+	o := []string{}
+	for _, x := range list {
+		switch {
+		case x.IsInt() && x.Int() >= 100 && x.Int() <= 599:
+			o = append(o, strconv.FormatInt(x.Int(), 10))
+		case x.IsString() && len(x.String()) == 3 && strings.HasSuffix(x.String(), "xx"):
+			o = append(o, x.String())
+		case x.IsString():
+			if c, err := strconv.Atoi(x.String()); err == nil && c >= 100 && c <= 599 {
+				o = append(o, x.String())
+				break
+			}
+			fallthrough
+		default:
+			return fmt.Errorf("http client option retry_on must be list of status or class, ie 503 or \"5xx\"")
+		}
+	}
+	*ptr = o
+	return nil
+}
+
+// parses the client option map, the unknown key is rejected. The key which is
+// not client option, ie cookie_jar of http::do, should be removed by caller
+func NewHttpClientOptionFromVal(v pl.Val) (*HttpClientOption, error) {
+	if !v.IsMap() {
+		return nil, fmt.Errorf("http client option must be map")
+	}
+
+	o := &HttpClientOption{}
+	var err error
+
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "timeout":
+				err = optionDuration(val, key, &o.Timeout)
+			case "connect_timeout":
+				err = optionDuration(val, key, &o.ConnectTimeout)
+			case "read_timeout":
+				err = optionDuration(val, key, &o.ReadTimeout)
+			case "expect_continue_timeout":
+				err = optionDuration(val, key, &o.ExpectContinueTimeout)
+			case "idle_conn_timeout":
+				err = optionDuration(val, key, &o.IdleConnTimeout)
+			case "max_idle_conns":
+				err = optionInt(val, key, &o.MaxIdleConns)
+			case "max_idle_conns_per_host":
+				err = optionInt(val, key, &o.MaxIdleConnsPerHost)
+			case "max_conns_per_host":
+				err = optionInt(val, key, &o.MaxConnsPerHost)
+			case "retry":
+				err = optionInt(val, key, &o.Retry)
+			case "retry_backoff":
+				err = optionDuration(val, key, &o.RetryBackoff)
+			case "retry_max_backoff":
+				err = optionDuration(val, key, &o.RetryMaxBackoff)
+			case "retry_on":
+				err = optionRetryOn(val, &o.RetryOn)
+			case "retry_non_idempotent":
+				if val.IsBool() {
+					o.RetryNonIdempotent = val.Bool()
+				} else {
+					err = fmt.Errorf("http client option %s must be bool", key)
+				}
+			case "per_try_timeout":
+				err = optionDuration(val, key, &o.PerTryTimeout)
+			case "insecure_skip_verify":
+				if val.IsBool() {
+					o.InsecureSkipVerify = val.Bool()
+				} else {
+					err = fmt.Errorf("http client option %s must be bool", key)
+				}
+			case "server_name":
+				err = optionStr(val, key, &o.ServerName)
+			case "ca_file":
+				err = optionStr(val, key, &o.CAFile)
+			case "cert_file":
+				err = optionStr(val, key, &o.CertFile)
+			case "key_file":
+				err = optionStr(val, key, &o.KeyFile)
+			case "balance_key":
+				err = optionStr(val, key, &o.BalanceKey)
+			default:
+				err = fmt.Errorf("http client option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return nil, fmt.Errorf("http client option cert_file and key_file must be set together")
+	}
+	return o, nil
+}
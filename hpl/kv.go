@@ -0,0 +1,182 @@
+package hpl
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dianpeng/moons/kv"
+	"github.com/dianpeng/moons/pl"
+)
+
+// kv:: functions over the namespace of the session, the runtime binds them as
+// function variable since the namespace, ie the vhost, is only known by the
+// runtime. The ttl is in millisecond, and the value is copied in and out of
+// the store so it can be any value convertible to JSON
+
+var (
+	kvProtoGet    = pl.MustNewFuncProto("kv::get", "{%s}{%s%a}")
+	kvProtoSet    = pl.MustNewFuncProto("kv::set", "{%s%a}{%s%a%d}")
+	kvProtoAdd    = pl.MustNewFuncProto("kv::add", "{%s%a}{%s%a%d}")
+	kvProtoDel    = pl.MustNewFuncProto("kv::del", "%s")
+	kvProtoHas    = pl.MustNewFuncProto("kv::has", "%s")
+	kvProtoIncr   = pl.MustNewFuncProto("kv::incr", "{%s}{%s%d}{%s%d%d}")
+	kvProtoExpire = pl.MustNewFuncProto("kv::expire", "%s%d")
+	kvProtoTTL    = pl.MustNewFuncProto("kv::ttl", "%s")
+	kvProtoKeys   = pl.MustNewFuncProto("kv::keys", "{%0}{%s}")
+)
+
+func kvValue(name string, v pl.Val) (interface{}, error) {
+	var x interface{}
+	if err := pl.Unmarshal(v, &x); err != nil {
+		return nil, fmt.Errorf("%s, value cannot be stored: %s", name, err.Error())
+	}
+	return x, nil
+}
+
+func kvTTL(args []pl.Val, alen int, idx int) time.Duration {
+	if alen <= idx {
+		return 0
+	}
+	return time.Duration(args[idx].Int()) * time.Millisecond
+}
+
+func kvGet(ns *kv.Namespace, args []pl.Val) (pl.Val, error) {
+	alen, err := kvProtoGet.Check(args)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	v, ok := ns.Get(args[0].String())
+	if !ok {
+		if alen == 2 {
+			return args[1], nil
+		}
+		return pl.NewValNull(), nil
+	}
+	return pl.MarshalVal(v)
+}
+
+func kvSet(ns *kv.Namespace, args []pl.Val) (pl.Val, error) {
+	alen, err := kvProtoSet.Check(args)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	v, err := kvValue("kv::set", args[1])
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	ns.Set(args[0].String(), v, kvTTL(args, alen, 2))
+	return pl.NewValNull(), nil
+}
+
+func kvAdd(ns *kv.Namespace, args []pl.Val) (pl.Val, error) {
+	alen, err := kvProtoAdd.Check(args)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	v, err := kvValue("kv::add", args[1])
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	return pl.NewValBool(ns.Add(args[0].String(), v, kvTTL(args, alen, 2))), nil
+}
+
+func kvDel(ns *kv.Namespace, args []pl.Val) (pl.Val, error) {
+	if _, err := kvProtoDel.Check(args); err != nil {
+		return pl.NewValNull(), err
+	}
+	return pl.NewValBool(ns.Del(args[0].String())), nil
+}
+
+func kvHas(ns *kv.Namespace, args []pl.Val) (pl.Val, error) {
+	if _, err := kvProtoHas.Check(args); err != nil {
+		return pl.NewValNull(), err
+	}
+	_, ok := ns.Get(args[0].String())
+	return pl.NewValBool(ok), nil
+}
+
+func kvIncr(ns *kv.Namespace, args []pl.Val) (pl.Val, error) {
+	alen, err := kvProtoIncr.Check(args)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	delta := int64(1)
+	if alen >= 2 {
+		delta = args[1].Int()
+	}
+	v, err := ns.Incr(args[0].String(), delta, kvTTL(args, alen, 2))
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	return pl.NewValInt64(v), nil
+}
+
+func kvExpire(ns *kv.Namespace, args []pl.Val) (pl.Val, error) {
+	if _, err := kvProtoExpire.Check(args); err != nil {
+		return pl.NewValNull(), err
+	}
+	return pl.NewValBool(ns.Expire(args[0].String(), time.Duration(args[1].Int())*time.Millisecond)), nil
+}
+
+// remaining ttl in millisecond, -1 if the key never expires and -2 if the key
+// is absent
+func kvTTLOf(ns *kv.Namespace, args []pl.Val) (pl.Val, error) {
+	if _, err := kvProtoTTL.Check(args); err != nil {
+		return pl.NewValNull(), err
+	}
+	d, ok := ns.TTL(args[0].String())
+	switch {
+	case !ok:
+		return pl.NewValInt(-2), nil
+	case d < 0:
+		return pl.NewValInt(-1), nil
+	default:
+		return pl.NewValInt64(int64(d / time.Millisecond)), nil
+	}
+}
+
+func kvKeys(ns *kv.Namespace, args []pl.Val) (pl.Val, error) {
+	alen, err := kvProtoKeys.Check(args)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	prefix := ""
+	if alen == 1 {
+		prefix = args[0].String()
+	}
+	keys := ns.Keys(prefix)
+	sort.Strings(keys)
+	o := pl.NewValList()
+	for _, k := range keys {
+		o.AddList(pl.NewValStr(k))
+	}
+	return o, nil
+}
+
+var kvFunction = map[string]func(*kv.Namespace, []pl.Val) (pl.Val, error){
+	"kv::get":    kvGet,
+	"kv::set":    kvSet,
+	"kv::add":    kvAdd,
+	"kv::del":    kvDel,
+	"kv::has":    kvHas,
+	"kv::incr":   kvIncr,
+	"kv::expire": kvExpire,
+	"kv::ttl":    kvTTLOf,
+	"kv::keys":   kvKeys,
+}
+
+// NewKVFunction returns the kv:: function of the name bound to the namespace,
+// the false is returned if the name is not a kv:: function
+func NewKVFunction(name string, ns *kv.Namespace) (pl.Val, bool) {
+	fn, ok := kvFunction[name]
+	if !ok {
+		return pl.NewValNull(), false
+	}
+	return pl.NewValNativeFunction(
+		name,
+		func(args []pl.Val) (pl.Val, error) {
+			return fn(ns, args)
+		},
+	), true
+}
@@ -0,0 +1,204 @@
+package hpl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dianpeng/moons/alog"
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/util"
+)
+
+// log:: functions write the access log record of the transaction from script
+// and emit the diagnostic lines. The runtime binds them as function variable
+// like kv::, since the record and the level of the vhost are only known by the
+// runtime.
+//
+//   log::set(key, value)        sets the field of the record, the value other
+//                               than null, bool, number and string is JSON
+//   log::fields(map)            sets the fields of the map, ordered by key
+//   log::debug/info/warn/error  appends the diagnostic line to the record, the
+//                               arguments are joined by space and the one
+//                               other than string is written as JSON
+//   log::line(level, ...)       writes the standalone diagnostic line to the
+//                               process log right away
+//   log::level()                the level of the vhost
+//
+// The line below the level of the vhost is dropped. Outside of any
+// transaction, ie the event of a subscribed topic, there is no record, so the
+// line goes to the process log and log::set fails.
+
+var (
+	logProtoDebug  = pl.MustNewFuncProto("log::debug", "%a*")
+	logProtoInfo   = pl.MustNewFuncProto("log::info", "%a*")
+	logProtoWarn   = pl.MustNewFuncProto("log::warn", "%a*")
+	logProtoError  = pl.MustNewFuncProto("log::error", "%a*")
+	logProtoSet    = pl.MustNewFuncProto("log::set", "%s%a")
+	logProtoFields = pl.MustNewFuncProto("log::fields", "%m")
+	logProtoLine   = pl.MustNewFuncProto("log::line", "%s%a*")
+	logProtoLevel  = pl.MustNewFuncProto("log::level", "%0")
+)
+
+// what the log:: functions are bound to, the record is null outside of any
+// transaction
+type logContext struct {
+	level  *alog.Level
+	record pl.Val
+}
+
+func logText(args []pl.Val) string {
+	o := make([]string, 0, len(args))
+	for _, x := range args {
+		if x.IsString() {
+			o = append(o, x.String())
+		} else if str, err := x.ToJSONString(); err == nil {
+			o = append(o, str)
+		} else {
+			o = append(o, x.Info())
+		}
+	}
+	return strings.Join(o, " ")
+}
+
+// AccessLogOf returns the record of the access log value, nil if the value is
+// not the access log, ie null outside of any transaction
+func AccessLogOf(v pl.Val) *alog.Log {
+	if !ValIsAccessLog(v) {
+		return nil
+	}
+	return v.Usr().(*accesslog).l
+}
+
+func (c *logContext) accessLog(name string) (*alog.Log, error) {
+	l := AccessLogOf(c.record)
+	if l == nil {
+		return nil, fmt.Errorf("%s, no access log outside of transaction", name)
+	}
+	return l, nil
+}
+
+func logProcess(level int32, text string) {
+	switch level {
+	case alog.LevelDebug:
+		util.Debugf("%s", text)
+	case alog.LevelInfo:
+		util.Infof("%s", text)
+	case alog.LevelWarn:
+		util.Warnf("%s", text)
+	default:
+		util.Errorf("%s", text)
+	}
+}
+
+func logLine(level int32, proto *pl.FuncProto) func(*logContext, []pl.Val) (pl.Val, error) {
+	return func(c *logContext, args []pl.Val) (pl.Val, error) {
+		if _, err := proto.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		if !c.level.Enabled(level) {
+			return pl.NewValNull(), nil
+		}
+		text := logText(args)
+		if l := AccessLogOf(c.record); l != nil {
+			l.AddMessage(level, text)
+		} else {
+			logProcess(level, text)
+		}
+		return pl.NewValNull(), nil
+	}
+}
+
+func logSet(c *logContext, args []pl.Val) (pl.Val, error) {
+	if _, err := logProtoSet.Check(args); err != nil {
+		return pl.NewValNull(), err
+	}
+	l, err := c.accessLog("log::set")
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	l.SetField(args[0].String(), logFieldValue(args[1]))
+	return pl.NewValNull(), nil
+}
+
+func logFields(c *logContext, args []pl.Val) (pl.Val, error) {
+	if _, err := logProtoFields.Check(args); err != nil {
+		return pl.NewValNull(), err
+	}
+	l, err := c.accessLog("log::fields")
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	fields := []alog.Field{}
+	args[0].Map().Foreach(
+		func(k string, v pl.Val) bool {
+			fields = append(fields, alog.Field{
+				Name:  k,
+				Value: logFieldValue(v),
+			})
+			return true
+		},
+	)
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+	for _, f := range fields {
+		l.SetField(f.Name, f.Value)
+	}
+	return pl.NewValNull(), nil
+}
+
+func logStandalone(c *logContext, args []pl.Val) (pl.Val, error) {
+	if _, err := logProtoLine.Check(args); err != nil {
+		return pl.NewValNull(), err
+	}
+	level, err := alog.ParseLevel(args[0].String())
+	if err != nil {
+		return pl.NewValNull(), fmt.Errorf("log::line, %s", err.Error())
+	}
+	if c.level.Enabled(level) {
+		logProcess(level, logText(args[1:]))
+	}
+	return pl.NewValNull(), nil
+}
+
+func logLevel(c *logContext, args []pl.Val) (pl.Val, error) {
+	if _, err := logProtoLevel.Check(args); err != nil {
+		return pl.NewValNull(), err
+	}
+	if c.level == nil {
+		return pl.NewValStr(alog.LevelName(alog.LevelInfo)), nil
+	}
+	return pl.NewValStr(c.level.String()), nil
+}
+
+var logFunction = map[string]func(*logContext, []pl.Val) (pl.Val, error){
+	"log::debug":  logLine(alog.LevelDebug, logProtoDebug),
+	"log::info":   logLine(alog.LevelInfo, logProtoInfo),
+	"log::warn":   logLine(alog.LevelWarn, logProtoWarn),
+	"log::error":  logLine(alog.LevelError, logProtoError),
+	"log::set":    logSet,
+	"log::fields": logFields,
+	"log::line":   logStandalone,
+	"log::level":  logLevel,
+}
+
+// NewLogFunction returns the log:: function of the name bound to the record
+// and the level of the vhost, the false is returned if the name is not a log::
+// function
+func NewLogFunction(name string, level *alog.Level, record pl.Val) (pl.Val, bool) {
+	fn, ok := logFunction[name]
+	if !ok {
+		return pl.NewValNull(), false
+	}
+	c := &logContext{
+		level:  level,
+		record: record,
+	}
+	return pl.NewValNativeFunction(
+		name,
+		func(args []pl.Val) (pl.Val, error) {
+			return fn(c, args)
+		},
+	), true
+}
@@ -0,0 +1,317 @@
+package hpl
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/dianpeng/moons/mq"
+	"github.com/dianpeng/moons/pl"
+)
+
+// mq:: module publishes to message brokers, the broker is picked by the url
+// scheme, ie nats://. Consuming is done by the vhost, which subscribes the
+// topics listed in its config and delivers each message as event
+
+func mqOptionBool(v pl.Val, name string, ptr *bool) error {
+	if !v.IsBool() {
+		return fmt.Errorf("mq option %s must be bool", name)
+	}
+	*ptr = v.Bool()
+	return nil
+}
+
+// mq option passed from script as a map, the durations are in millisecond
+func NewMQOptionFromVal(v pl.Val) (*mq.Option, error) {
+	if !v.IsMap() {
+		return nil, fmt.Errorf("mq option must be map")
+	}
+
+	o := &mq.Option{}
+	var err error
+
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "name":
+				err = optionStr(val, key, &o.Name)
+			case "user":
+				err = optionStr(val, key, &o.User)
+			case "password":
+				err = optionStr(val, key, &o.Password)
+			case "token":
+				err = optionStr(val, key, &o.Token)
+			case "connect_timeout":
+				err = optionDuration(val, key, &o.ConnectTimeout)
+			case "reconnect_wait":
+				err = optionDuration(val, key, &o.ReconnectWait)
+			case "max_reconnect":
+				err = optionInt(val, key, &o.MaxReconnect)
+			case "tls":
+				err = mqOptionBool(val, key, &o.TLS)
+			case "insecure_skip_verify":
+				err = mqOptionBool(val, key, &o.InsecureSkipVerify)
+			default:
+				err = fmt.Errorf("mq option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// MQSubscribe is one subscription of the vhost, the message of the topic is
+// delivered as the event, which is the topic itself if not specified
+type MQSubscribe struct {
+	URL    string
+	Topic  string
+	Queue  string
+	Event  string
+	Option *mq.Option
+}
+
+func NewMQSubscribeFromVal(v pl.Val) (*MQSubscribe, error) {
+	if !v.IsMap() {
+		return nil, fmt.Errorf("mq subscribe must be map")
+	}
+
+	o := &MQSubscribe{}
+	var err error
+
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "url":
+				err = optionStr(val, key, &o.URL)
+			case "topic":
+				err = optionStr(val, key, &o.Topic)
+			case "queue":
+				err = optionStr(val, key, &o.Queue)
+			case "event":
+				err = optionStr(val, key, &o.Event)
+			case "option":
+				o.Option, err = NewMQOptionFromVal(val)
+			default:
+				err = fmt.Errorf("mq subscribe %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	if o.URL == "" || o.Topic == "" {
+		return nil, fmt.Errorf("mq subscribe requires url and topic")
+	}
+	if o.Event == "" {
+		o.Event = o.Topic
+	}
+	return o, nil
+}
+
+// NewMQMessageVal converts the message into the event context, the payload
+// is the decoded JSON of the data, or the data itself if it is not JSON
+func NewMQMessageVal(m *mq.Message) pl.Val {
+	o := pl.NewValMap()
+	o.AddMap("topic", pl.NewValStr(m.Topic))
+	o.AddMap("reply", pl.NewValStr(m.Reply))
+
+	header := pl.NewValMap()
+	for k, v := range m.Header {
+		header.AddMap(k, pl.NewValStr(v))
+	}
+	o.AddMap("header", header)
+
+	data := string(m.Data)
+	o.AddMap("data", pl.NewValStr(data))
+	if payload, err := pl.NewValFromJSON(data); err == nil {
+		o.AddMap("payload", payload)
+	} else {
+		o.AddMap("payload", pl.NewValStr(data))
+	}
+	return o
+}
+
+// the string is published as is, otherwise the value is serialized as JSON
+func mqPayload(v pl.Val) ([]byte, error) {
+	if v.IsString() {
+		return []byte(v.String()), nil
+	}
+	data, err := v.ToJSONString()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
+// the credential of the url is not exposed to the script, the user without
+// password is a token
+func mqRedacted(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); u.User != nil && !ok {
+		u.User = url.User("xxxxx")
+	}
+	return u.Redacted()
+}
+
+type MQClient struct {
+	broker mq.Broker
+	url    string
+}
+
+func ValIsMQClient(v pl.Val) bool {
+	return v.Id() == MQClientTypeId
+}
+
+func (c *MQClient) Broker() mq.Broker {
+	return c.broker
+}
+
+func (c *MQClient) Index(_ pl.Val) (pl.Val, error) {
+	return pl.NewValNull(), fmt.Errorf("%s does not support index", c.Id())
+}
+
+func (c *MQClient) IndexSet(_ pl.Val, _ pl.Val) error {
+	return fmt.Errorf("%s does not support index set", c.Id())
+}
+
+func (c *MQClient) Dot(name string) (pl.Val, error) {
+	switch name {
+	case "url":
+		return pl.NewValStr(c.url), nil
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s unknown field %s", c.Id(), name)
+}
+
+func (c *MQClient) DotSet(_ string, _ pl.Val) error {
+	return fmt.Errorf("%s does not support dot set", c.Id())
+}
+
+func (c *MQClient) ToString() (string, error) {
+	return c.Info(), nil
+}
+
+func (c *MQClient) ToJSON() (pl.Val, error) {
+	return pl.MarshalVal(
+		map[string]interface{}{
+			"type": MQClientTypeId,
+			"url":  c.url,
+		},
+	)
+}
+
+var (
+	methodProtoMQClientPublish = pl.MustNewFuncProto("mq.client.publish", "{%s%a}{%s%a%m}")
+	methodProtoMQClientClose   = pl.MustNewFuncProto("mq.client.close", "%0")
+)
+
+func (c *MQClient) Method(name string, args []pl.Val) (pl.Val, error) {
+	switch name {
+	// publish(topic, payload, [header])
+	case "publish":
+		alen, err := methodProtoMQClientPublish.Check(args)
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		data, err := mqPayload(args[1])
+		if err != nil {
+			return pl.NewValNull(), fmt.Errorf("mq.client.publish, payload cannot be serialized: %s", err.Error())
+		}
+		var header map[string]string
+		if alen == 3 {
+			header = make(map[string]string)
+			args[2].Map().Foreach(
+				func(k string, v pl.Val) bool {
+					if s, e := v.ToString(); e == nil {
+						header[k] = s
+					} else {
+						err = fmt.Errorf("mq.client.publish, header %s must be string", k)
+					}
+					return err == nil
+				},
+			)
+			if err != nil {
+				return pl.NewValNull(), err
+			}
+		}
+		if err := c.broker.Publish(args[0].String(), data, header); err != nil {
+			return pl.NewValNull(), fmt.Errorf("mq.client.publish, %s", err.Error())
+		}
+		return pl.NewValNull(), nil
+
+	case "close":
+		if _, err := methodProtoMQClientClose.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		c.broker.Close()
+		return pl.NewValNull(), nil
+
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s's method %s is unknown", c.Id(), name)
+}
+
+func (c *MQClient) Info() string {
+	return fmt.Sprintf("%s[%s]", c.Id(), c.url)
+}
+
+func (c *MQClient) Id() string {
+	return MQClientTypeId
+}
+
+func (c *MQClient) IsThreadSafe() bool {
+	return true
+}
+
+func (c *MQClient) NewIterator() (pl.Iter, error) {
+	return nil, fmt.Errorf("%s does not support iterator", c.Id())
+}
+
+// mq::connect(url, [option])
+func fnMQConnect(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	alen, err := info.Check(argument)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	option := &mq.Option{}
+	if alen == 2 {
+		if option, err = NewMQOptionFromVal(argument[1]); err != nil {
+			return pl.NewValNull(), fmt.Errorf("mq::connect invalid option: %s", err.Error())
+		}
+	}
+	b, err := mq.Dial(argument[0].String(), option)
+	if err != nil {
+		return pl.NewValNull(), fmt.Errorf("mq::connect %s", err.Error())
+	}
+	return pl.NewValUsr(
+		&MQClient{
+			broker: b,
+			url:    mqRedacted(argument[0].String()),
+		},
+	), nil
+}
+
+// mq::drivers(), the url schemes of the linked brokers
+func fnMQDrivers(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	d := mq.Drivers()
+	sort.Strings(d)
+	o := pl.NewValList()
+	for _, x := range d {
+		o.AddList(pl.NewValStr(x))
+	}
+	return o, nil
+}
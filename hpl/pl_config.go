@@ -20,8 +20,7 @@ func NewPLConfig(eval *pl.Evaluator, a []pl.Val) PLConfig {
 
 func (p *PLConfig) tryeval(v pl.Val) (pl.Val, error) {
 	if v.IsClosure() {
-		cls, _ := v.Usr().(pl.Closure)
-		return cls.Call(p.eval, []pl.Val{})
+		return v.Closure().Call(p.eval, []pl.Val{})
 	}
 	return v, nil
 }
@@ -35,7 +34,7 @@ func (p *PLConfig) Get(
 	}
 	arg, err := p.tryeval(p.args[index])
 	if err != nil {
-		return fmt.Errorf("%d'th elements evaluation error: %s", err.Error())
+		return fmt.Errorf("%d'th elements evaluation error: %s", index, err.Error())
 	}
 	*ptr = arg
 	return nil
@@ -61,7 +60,7 @@ func (p *PLConfig) GetStr(
 
 	arg, err := p.tryeval(p.args[index])
 	if err != nil {
-		return fmt.Errorf("%d'th elements evaluation error: %s", err.Error())
+		return fmt.Errorf("%d'th elements evaluation error: %s", index, err.Error())
 	}
 
 	str, err := arg.ToString()
@@ -93,10 +92,10 @@ func (p *PLConfig) GetInt(
 
 	arg, err := p.tryeval(p.args[index])
 	if err != nil {
-		return fmt.Errorf("%d'th elements evaluation error: %s", err.Error())
+		return fmt.Errorf("%d'th elements evaluation error: %s", index, err.Error())
 	}
 
-	if arg.IsInt() {
+	if !arg.IsInt() {
 		return fmt.Errorf("%d'th elements is not int", index)
 	}
 
@@ -124,10 +123,10 @@ func (p *PLConfig) GetInt64(
 
 	arg, err := p.tryeval(p.args[index])
 	if err != nil {
-		return fmt.Errorf("%d'th elements evaluation error: %s", err.Error())
+		return fmt.Errorf("%d'th elements evaluation error: %s", index, err.Error())
 	}
 
-	if arg.IsInt() {
+	if !arg.IsInt() {
 		return fmt.Errorf("%d'th elements is not int", index)
 	}
 
@@ -155,7 +154,7 @@ func (p *PLConfig) GetReal(
 
 	arg, err := p.tryeval(p.args[index])
 	if err != nil {
-		return fmt.Errorf("%d'th elements evaluation error: %s", err.Error())
+		return fmt.Errorf("%d'th elements evaluation error: %s", index, err.Error())
 	}
 
 	if !arg.IsReal() {
@@ -186,7 +185,7 @@ func (p *PLConfig) GetBool(
 
 	arg, err := p.tryeval(p.args[index])
 	if err != nil {
-		return fmt.Errorf("%d'th elements evaluation error: %s", err.Error())
+		return fmt.Errorf("%d'th elements evaluation error: %s", index, err.Error())
 	}
 
 	if !arg.IsBool() {
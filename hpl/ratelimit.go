@@ -0,0 +1,300 @@
+package hpl
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/ratelimit"
+)
+
+// RateLimiter is the script handle of a keyed limiter, the limiter is shared
+// among sessions and services through its key space name
+type RateLimiter struct {
+	name    string
+	limiter *ratelimit.Limiter
+}
+
+func ValIsRateLimiter(v pl.Val) bool {
+	return v.Id() == RateLimiterTypeId
+}
+
+func NewRateLimiterVal(name string, l *ratelimit.Limiter) pl.Val {
+	return pl.NewValUsr(&RateLimiter{name: name, limiter: l})
+}
+
+func (r *RateLimiter) Limiter() *ratelimit.Limiter {
+	return r.limiter
+}
+
+// rate limit option passed from script as a map, the keys are algorithm,
+// window in millisecond and max_keys
+func NewRateLimitOptionFromVal(v pl.Val, o *ratelimit.Option) error {
+	if !v.IsMap() {
+		return fmt.Errorf("ratelimit option must be map")
+	}
+
+	var err error
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "algorithm":
+				err = optionStr(val, key, &o.Algorithm)
+			case "window":
+				err = optionDuration(val, key, &o.Window)
+			case "max_keys":
+				err = optionInt(val, key, &o.MaxKeys)
+			default:
+				err = fmt.Errorf("ratelimit option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+	return err
+}
+
+// NewRateLimitResultVal converts the result into map, the durations are in
+// millisecond
+func NewRateLimitResultVal(r ratelimit.Result) pl.Val {
+	o := pl.NewValMap()
+	o.AddMap("allowed", pl.NewValBool(r.Allowed))
+	o.AddMap("limit", pl.NewValInt(r.Limit))
+	o.AddMap("remaining", pl.NewValInt(r.Remaining))
+	o.AddMap("retry_after", pl.NewValInt64(r.RetryAfter.Milliseconds()))
+	o.AddMap("reset", pl.NewValInt64(r.Reset.Milliseconds()))
+	return o
+}
+
+// concurrency limit option passed from script as a map, the keys are
+// max_inflight, queue, queue_timeout and retry_after in millisecond
+func NewConcurrencyOptionFromVal(v pl.Val) (*ratelimit.ConcurrencyOption, error) {
+	if !v.IsMap() {
+		return nil, fmt.Errorf("concurrency option must be map")
+	}
+
+	o := &ratelimit.ConcurrencyOption{}
+	var err error
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "max_inflight":
+				err = optionInt(val, key, &o.MaxInFlight)
+			case "queue":
+				err = optionInt(val, key, &o.MaxQueue)
+			case "queue_timeout":
+				err = optionDuration(val, key, &o.QueueTimeout)
+			case "retry_after":
+				err = optionDuration(val, key, &o.RetryAfter)
+			default:
+				err = fmt.Errorf("concurrency option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// NewConcurrencyStatsVal converts the occupancy of the concurrency limiter
+// into map
+func NewConcurrencyStatsVal(s ratelimit.ConcurrencyStats) pl.Val {
+	o := pl.NewValMap()
+	o.AddMap("limit", pl.NewValInt(s.Limit))
+	o.AddMap("in_flight", pl.NewValInt(s.InFlight))
+	o.AddMap("queue", pl.NewValInt(s.MaxQueue))
+	o.AddMap("queued", pl.NewValInt(s.Queued))
+	o.AddMap("admitted", pl.NewValInt64(s.Admitted))
+	o.AddMap("rejected", pl.NewValInt64(s.Rejected))
+	o.AddMap("timed_out", pl.NewValInt64(s.TimedOut))
+	return o
+}
+
+func (r *RateLimiter) Index(_ pl.Val) (pl.Val, error) {
+	return pl.NewValNull(), fmt.Errorf("%s does not support index", r.Id())
+}
+
+func (r *RateLimiter) IndexSet(_ pl.Val, _ pl.Val) error {
+	return fmt.Errorf("%s does not support index set", r.Id())
+}
+
+func (r *RateLimiter) Dot(name string) (pl.Val, error) {
+	o := r.limiter.Option()
+	switch name {
+	case "name":
+		return pl.NewValStr(r.name), nil
+	case "algorithm":
+		return pl.NewValStr(o.Algorithm), nil
+	case "rate":
+		return pl.NewValReal(o.Rate), nil
+	case "burst":
+		return pl.NewValInt(o.Burst), nil
+	case "limit":
+		return pl.NewValInt(r.limiter.Limit()), nil
+	case "length":
+		return pl.NewValInt(r.limiter.Len()), nil
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s unknown field %s", r.Id(), name)
+}
+
+func (r *RateLimiter) DotSet(_ string, _ pl.Val) error {
+	return fmt.Errorf("%s does not support dot set", r.Id())
+}
+
+func (r *RateLimiter) ToString() (string, error) {
+	return r.Info(), nil
+}
+
+func (r *RateLimiter) ToJSON() (pl.Val, error) {
+	o := r.limiter.Option()
+	return pl.MarshalVal(
+		map[string]interface{}{
+			"type":      RateLimiterTypeId,
+			"name":      r.name,
+			"algorithm": o.Algorithm,
+			"rate":      o.Rate,
+			"limit":     r.limiter.Limit(),
+		},
+	)
+}
+
+var (
+	methodProtoRateLimiterAllow = pl.MustNewFuncProto("ratelimit.limiter.allow", "{%s}{%s%d}")
+	methodProtoRateLimiterTake  = pl.MustNewFuncProto("ratelimit.limiter.take", "{%s}{%s%d}")
+	methodProtoRateLimiterReset = pl.MustNewFuncProto("ratelimit.limiter.reset", "%s")
+)
+
+func (r *RateLimiter) Method(name string, args []pl.Val) (pl.Val, error) {
+	switch name {
+	// allow(key, [n]), whether the n requests of the key are admitted
+	case "allow", "take":
+		proto := methodProtoRateLimiterAllow
+		if name == "take" {
+			proto = methodProtoRateLimiterTake
+		}
+		alen, err := proto.Check(args)
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		n := 1
+		if alen == 2 {
+			if n = int(args[1].Int()); n <= 0 {
+				return pl.NewValNull(), fmt.Errorf("%s.%s, n must be positive", r.Id(), name)
+			}
+		}
+		res := r.limiter.AllowN(args[0].String(), n, time.Now())
+		if name == "allow" {
+			return pl.NewValBool(res.Allowed), nil
+		}
+
+		// take(key, [n]), same as allow but returns the detail of the result
+		return NewRateLimitResultVal(res), nil
+
+	case "reset":
+		if _, err := methodProtoRateLimiterReset.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		r.limiter.Reset(args[0].String())
+		return pl.NewValNull(), nil
+
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s's method %s is unknown", r.Id(), name)
+}
+
+func (r *RateLimiter) Info() string {
+	return fmt.Sprintf("%s[%s]", r.Id(), r.name)
+}
+
+func (r *RateLimiter) Id() string {
+	return RateLimiterTypeId
+}
+
+func (r *RateLimiter) IsThreadSafe() bool {
+	return true
+}
+
+func (r *RateLimiter) NewIterator() (pl.Iter, error) {
+	return nil, fmt.Errorf("%s does not support iterator", r.Id())
+}
+
+// ratelimit::new(key_space, rate, [burst], [option]), the rate is requests
+// per second. The limiter is registered under the key space, the one already
+// registered is returned instead so every service naming the key space shares
+// the same limiter
+func fnRateLimitNew(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	alen, err := info.Check(argument)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+
+	name := argument[0].String()
+	if l := ratelimit.Find(name); l != nil {
+		return NewRateLimiterVal(name, l), nil
+	}
+
+	option := &ratelimit.Option{}
+	switch {
+	case argument[1].IsInt():
+		option.Rate = float64(argument[1].Int())
+	case argument[1].IsReal():
+		option.Rate = argument[1].Real()
+	default:
+		return pl.NewValNull(), fmt.Errorf("ratelimit::new rate must be number")
+	}
+	if alen >= 3 {
+		option.Burst = int(argument[2].Int())
+	}
+	if alen == 4 {
+		if err := NewRateLimitOptionFromVal(argument[3], option); err != nil {
+			return pl.NewValNull(), fmt.Errorf("ratelimit::new invalid option: %s", err.Error())
+		}
+	}
+
+	l, err := ratelimit.New(option)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	return NewRateLimiterVal(name, ratelimit.Register(name, l)), nil
+}
+
+// ratelimit::open(key_space)
+func fnRateLimitOpen(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	name := argument[0].String()
+	l := ratelimit.Find(name)
+	if l == nil {
+		return pl.NewValNull(), fmt.Errorf("ratelimit::open, limiter %s is not found", name)
+	}
+	return NewRateLimiterVal(name, l), nil
+}
+
+// ratelimit::concurrency([name]), the occupancy of the concurrency limiter
+// of the vhost or the service, ie "vhost" or "vhost/service", null if it is
+// not found. Without name, returns the map of all the limiters
+func fnRateLimitConcurrency(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	alen, err := info.Check(argument)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	if alen == 1 {
+		if c := ratelimit.FindConcurrency(argument[0].String()); c != nil {
+			return NewConcurrencyStatsVal(c.Stats()), nil
+		}
+		return pl.NewValNull(), nil
+	}
+
+	o := pl.NewValMap()
+	for _, name := range ratelimit.ConcurrencyNames() {
+		if c := ratelimit.FindConcurrency(name); c != nil {
+			o.AddMap(name, NewConcurrencyStatsVal(c.Stats()))
+		}
+	}
+	return o, nil
+}
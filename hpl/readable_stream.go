@@ -1,11 +1,16 @@
 package hpl
 
 import (
+	"bufio"
 	"fmt"
 	"github.com/dianpeng/moons/pl"
 	"io"
+	"time"
 )
 
+// chunk size of each iteration when the stream is iterated by for loop
+const readableStreamChunkSize = 4096
+
 type ReadableStream struct {
 	Stream   io.ReadCloser
 	cacheBuf []byte // maybe populated to locally cache the content of stream
@@ -118,6 +123,59 @@ func (h *ReadableStream) ToStream() io.ReadCloser {
 	return h.Stream
 }
 
+// incremental read APIs, they consume the stream partially. Since the content
+// is been consumed, the cache, if any, is dropped and later consumption only
+// sees the rest of the stream
+func (h *ReadableStream) bufReader() *bufio.Reader {
+	if h.hasCache {
+		h.cacheBuf = nil
+		h.hasCache = false
+	}
+	if x, ok := h.Stream.(*bufReadCloser); ok {
+		return x.Reader
+	}
+	x := newbufReadCloser(h.Stream)
+	h.Stream = x
+	return x.Reader
+}
+
+// reads at most n bytes from the stream, returns io.EOF when the stream is
+// drained
+func (h *ReadableStream) Read(n int) ([]byte, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf(".readablestream:read size must be positive")
+	}
+	buf := make([]byte, n)
+	sz, err := io.ReadFull(h.bufReader(), buf)
+	if sz > 0 {
+		return buf[:sz], nil
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return nil, err
+}
+
+// reads a line without the trailing \n or \r\n, the last line of the stream
+// may not be terminated by newline. Returns io.EOF when the stream is drained
+func (h *ReadableStream) ReadLine() (string, error) {
+	line, err := h.bufReader().ReadString('\n')
+	if err == io.EOF {
+		if line == "" {
+			return "", io.EOF
+		}
+		return line, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	line = line[:len(line)-1]
+	if l := len(line); l > 0 && line[l-1] == '\r' {
+		line = line[:l-1]
+	}
+	return line, nil
+}
+
 func (h *ReadableStream) SetStream(stream io.ReadCloser) {
 	h.cacheBuf = []byte{}
 	h.hasCache = false
@@ -193,8 +251,46 @@ var (
 	methodProtoReadableStreamTryCacheString = pl.MustNewFuncProto(".readablestream.tryCacheString", "%0")
 	methodProtoReadableStreamAsString       = pl.MustNewFuncProto(".readablestream.string", "%0")
 	methodProtoReadableStreamClose          = pl.MustNewFuncProto(".readablestream.close", "%0")
+	methodProtoReadableStreamRead           = pl.MustNewFuncProto(".readablestream.read", "%d:size")
+	methodProtoReadableStreamReadLine       = pl.MustNewFuncProto(".readablestream.readLine", "%0")
+	methodProtoReadableStreamPipe           = pl.MustNewFuncProto(".readablestream.pipe", "%U['.writablestream']")
+	methodProtoReadableStreamTransform      = pl.MustNewFuncProto(".readablestream.transform", "{%s}{%s%a*}{%c}")
+	methodProtoReadableStreamTee            = pl.MustNewFuncProto(".readablestream.tee", "%0")
+	methodProtoReadableStreamSetLimit       = pl.MustNewFuncProto(".readablestream.setLimit", "%d:limit")
+	methodProtoReadableStreamSetReadTimeout = pl.MustNewFuncProto(".readablestream.setReadTimeout", "%d:millisecond")
 )
 
+func (h *ReadableStream) EvalMethod(e *pl.Evaluator, name string, arg []pl.Val) (pl.Val, error) {
+	switch name {
+	case "transform":
+		if _, err := methodProtoReadableStreamTransform.Check(arg); err != nil {
+			return pl.NewValNull(), err
+		}
+		x, err := h.Transform(e, arg)
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		return pl.NewValUsr(x), nil
+	case "tee":
+		if _, err := methodProtoReadableStreamTee.Check(arg); err != nil {
+			return pl.NewValNull(), err
+		}
+		a, b := h.Tee()
+		// the branches may hold a temporary file which must not outlive the
+		// session, even if the script never drains them
+		if e != nil {
+			e.AddCloser(a.Stream)
+			e.AddCloser(b.Stream)
+		}
+		l := pl.NewValList()
+		l.AddList(pl.NewValUsr(a))
+		l.AddList(pl.NewValUsr(b))
+		return l, nil
+	default:
+		return h.Method(name, arg)
+	}
+}
+
 func (h *ReadableStream) Method(name string, arg []pl.Val) (pl.Val, error) {
 	switch name {
 	case "cacheString":
@@ -227,6 +323,53 @@ func (h *ReadableStream) Method(name string, arg []pl.Val) (pl.Val, error) {
 		} else {
 			return pl.NewValStr(s), nil
 		}
+	// incremental consume APIs, returns null when the stream is drained
+	case "read":
+		if _, err := methodProtoReadableStreamRead.Check(arg); err != nil {
+			return pl.NewValNull(), err
+		}
+		b, err := h.Read(int(arg[0].Int()))
+		if err == io.EOF {
+			return pl.NewValNull(), nil
+		}
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		return pl.NewValStr(string(b)), nil
+	case "readLine":
+		if _, err := methodProtoReadableStreamReadLine.Check(arg); err != nil {
+			return pl.NewValNull(), err
+		}
+		l, err := h.ReadLine()
+		if err == io.EOF {
+			return pl.NewValNull(), nil
+		}
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		return pl.NewValStr(l), nil
+	case "transform", "tee":
+		return h.EvalMethod(nil, name, arg)
+	case "pipe":
+		if _, err := methodProtoReadableStreamPipe.Check(arg); err != nil {
+			return pl.NewValNull(), err
+		}
+		dst, _ := arg[0].Usr().(*WritableStream)
+		n, err := h.Pipe(dst)
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		return pl.NewValInt64(n), nil
+	case "setLimit":
+		if _, err := methodProtoReadableStreamSetLimit.Check(arg); err != nil {
+			return pl.NewValNull(), err
+		}
+		return pl.NewValNull(), h.SetLimit(arg[0].Int())
+	case "setReadTimeout":
+		if _, err := methodProtoReadableStreamSetReadTimeout.Check(arg); err != nil {
+			return pl.NewValNull(), err
+		}
+		return pl.NewValNull(), h.SetReadTimeout(time.Duration(arg[0].Int()) * time.Millisecond)
 	case "close":
 		if _, err := methodProtoReadableStreamClose.Check(arg); err != nil {
 			return pl.NewValNull(), err
@@ -252,8 +395,56 @@ func (h *ReadableStream) Id() string {
 	return ReadableStreamTypeId
 }
 
+// iterates the stream chunk by chunk, the index is the offset of the chunk
 func (h *ReadableStream) NewIterator() (pl.Iter, error) {
-	return nil, fmt.Errorf(".readablestream does not support iterator")
+	x := &readableStreamIter{
+		stream: h,
+	}
+	if _, err := x.Next(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type readableStreamIter struct {
+	stream *ReadableStream
+	offset int
+	chunk  []byte
+	done   bool
+}
+
+func (x *readableStreamIter) SetUp(_ *pl.Evaluator, _ []pl.Val) error {
+	return nil
+}
+
+func (x *readableStreamIter) Has() bool {
+	return !x.done
+}
+
+func (x *readableStreamIter) Next() (bool, error) {
+	if x.done {
+		return false, nil
+	}
+	x.offset += len(x.chunk)
+	b, err := x.stream.Read(readableStreamChunkSize)
+	if err == io.EOF {
+		x.chunk = nil
+		x.done = true
+		return false, nil
+	}
+	if err != nil {
+		x.done = true
+		return false, err
+	}
+	x.chunk = b
+	return true, nil
+}
+
+func (x *readableStreamIter) Deref() (pl.Val, pl.Val, error) {
+	if x.done {
+		return pl.NewValNull(), pl.NewValNull(), fmt.Errorf("iterator out of bound")
+	}
+	return pl.NewValInt(x.offset), pl.NewValStr(string(x.chunk)), nil
 }
 
 func NewReadableStreamValFromStream(stream io.ReadCloser) pl.Val {
@@ -0,0 +1,145 @@
+package hpl
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// guards of the stream consumption. A stream coming from the network can be
+// arbitrary large or arbitrary slow, the limit and deadline are applied to the
+// underlying stream so every consumer, ie ConsumeAsString, CacheBuffer, read
+// and pipe, fails with an error instead of exhausting memory or hanging
+
+type limitReadCloser struct {
+	src   io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (l *limitReadCloser) exceeded() error {
+	return fmt.Errorf(".readablestream: size limit %d exceeded", l.limit)
+}
+
+func (l *limitReadCloser) Read(p []byte) (int, error) {
+	if l.read > l.limit {
+		return 0, l.exceeded()
+	}
+	// allows reading one more byte than the limit to tell whether the stream
+	// is exactly limit bytes or larger than that
+	if max := l.limit - l.read + 1; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := l.src.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return 0, l.exceeded()
+	}
+	return n, err
+}
+
+func (l *limitReadCloser) Close() error {
+	return l.src.Close()
+}
+
+type deadlineReadResult struct {
+	n   int
+	err error
+}
+
+type deadlineReadCloser struct {
+	src      io.ReadCloser
+	deadline time.Time
+	buf      []byte
+	result   chan deadlineReadResult
+	err      error
+}
+
+func (d *deadlineReadCloser) timeout() error {
+	return fmt.Errorf(".readablestream: read deadline exceeded")
+}
+
+// the read is performed in another goroutine since an io.Reader cannot be
+// interrupted, once the deadline is exceeded the source is closed to unblock
+// the pending read
+func (d *deadlineReadCloser) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	left := time.Until(d.deadline)
+	if left <= 0 {
+		d.err = d.timeout()
+		d.src.Close()
+		return 0, d.err
+	}
+
+	if cap(d.buf) < len(p) {
+		d.buf = make([]byte, len(p))
+	}
+	buf := d.buf[:len(p)]
+
+	go func() {
+		n, err := d.src.Read(buf)
+		d.result <- deadlineReadResult{n: n, err: err}
+	}()
+
+	timer := time.NewTimer(left)
+	defer timer.Stop()
+
+	select {
+	case r := <-d.result:
+		copy(p, buf[:r.n])
+		return r.n, r.err
+	case <-timer.C:
+		d.err = d.timeout()
+
+		// the pending read owns the buffer now
+		d.buf = nil
+		d.src.Close()
+		return 0, d.err
+	}
+}
+
+func (d *deadlineReadCloser) Close() error {
+	if d.err != nil {
+		return nil
+	}
+	return d.src.Close()
+}
+
+// limits the size of the stream, reading more than n bytes from the stream
+// fails. The content already cached is checked against the limit directly
+func (h *ReadableStream) SetLimit(n int64) error {
+	if n < 0 {
+		return fmt.Errorf(".readablestream:setLimit limit must not be negative")
+	}
+	if h.hasCache {
+		if int64(len(h.cacheBuf)) > n {
+			return fmt.Errorf(".readablestream: size limit %d exceeded", n)
+		}
+		return nil
+	}
+	h.Stream = &limitReadCloser{
+		src:   h.Stream,
+		limit: n,
+	}
+	return nil
+}
+
+// sets a deadline, d from now, for consuming the rest of the stream. Reads
+// after the deadline fail and the stream is closed. The cached content is not
+// affected since it does not read from the underlying stream
+func (h *ReadableStream) SetReadTimeout(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf(".readablestream:setReadTimeout timeout must be positive")
+	}
+	if h.hasCache {
+		return nil
+	}
+	h.Stream = &deadlineReadCloser{
+		src:      h.Stream,
+		deadline: time.Now().Add(d),
+		result:   make(chan deadlineReadResult, 1),
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package hpl
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// Tee splits a stream into 2 independent streams. The content read from the
+// source by the faster branch is kept in a shared buffer until the slower
+// branch has consumed it, so the whole content is only buffered when one
+// branch is far behind the other. Once the buffered window exceeds
+// teeSpillSize, the buffer is spilled into a temporary file which is removed
+// once both branches are closed. The spill file is bounded by teeMaxSpillSize,
+// reading past it fails both branches.
+const (
+	teeSpillSize    = 1 << 20
+	teeMaxSpillSize = 64 << 20
+)
+
+type teeSource struct {
+	sync.Mutex
+	src    io.ReadCloser
+	srcEOF bool
+	srcErr error
+
+	// in memory buffer, holding [base, base+len(mem))
+	base int64
+	mem  []byte
+
+	// spill file, holding [0, fileSize) once spilled
+	file     *os.File
+	fileSize int64
+
+	branch [2]*teeBranch
+	chunk  []byte
+}
+
+type teeBranch struct {
+	t      *teeSource
+	off    int64
+	closed bool
+}
+
+func (t *teeSource) end() int64 {
+	if t.file != nil {
+		return t.fileSize
+	}
+	return t.base + int64(len(t.mem))
+}
+
+// drops the data consumed by all the branches, only for in memory buffer
+func (t *teeSource) trim() {
+	if t.file != nil {
+		return
+	}
+	min := t.end()
+	for _, b := range t.branch {
+		if !b.closed && b.off < min {
+			min = b.off
+		}
+	}
+	if drop := min - t.base; drop > 0 {
+		t.mem = t.mem[drop:]
+		t.base = min
+	}
+}
+
+func (t *teeSource) spill() error {
+	f, err := ioutil.TempFile("", "moons-tee-")
+	if err != nil {
+		return err
+	}
+	// the file starts at offset 0, so pad the trimmed prefix to keep the
+	// offset of each branch unchanged
+	if err := f.Truncate(t.base); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if _, err := f.WriteAt(t.mem, t.base); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	t.file = f
+	t.fileSize = t.base + int64(len(t.mem))
+	t.mem = nil
+	return nil
+}
+
+func (t *teeSource) append(b []byte) error {
+	if t.file != nil {
+		if t.fileSize+int64(len(b)) > teeMaxSpillSize {
+			return fmt.Errorf(".readablestream: tee buffers more than %d bytes", teeMaxSpillSize)
+		}
+		if _, err := t.file.WriteAt(b, t.fileSize); err != nil {
+			return err
+		}
+		t.fileSize += int64(len(b))
+		return nil
+	}
+	t.mem = append(t.mem, b...)
+	if len(t.mem) > teeSpillSize {
+		return t.spill()
+	}
+	return nil
+}
+
+// fill reads next chunk from the source into the buffer
+func (t *teeSource) fill() error {
+	if t.srcEOF {
+		return io.EOF
+	}
+	if t.srcErr != nil {
+		return t.srcErr
+	}
+	n, err := t.src.Read(t.chunk)
+	if n > 0 {
+		if aerr := t.append(t.chunk[:n]); aerr != nil {
+			t.srcErr = aerr
+			return aerr
+		}
+	}
+	if err == io.EOF {
+		t.srcEOF = true
+		if n == 0 {
+			return io.EOF
+		}
+	} else if err != nil {
+		t.srcErr = err
+		if n == 0 {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *teeSource) readAt(p []byte, off int64) (int, error) {
+	end := t.end()
+	if int64(len(p)) > end-off {
+		p = p[:end-off]
+	}
+	if t.file != nil {
+		return t.file.ReadAt(p, off)
+	}
+	return copy(p, t.mem[off-t.base:]), nil
+}
+
+func (b *teeBranch) Read(p []byte) (int, error) {
+	t := b.t
+	t.Lock()
+	defer t.Unlock()
+
+	if b.closed {
+		return 0, fmt.Errorf(".readablestream: tee stream is closed")
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for b.off >= t.end() {
+		if err := t.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := t.readAt(p, b.off)
+	b.off += int64(n)
+	t.trim()
+	return n, err
+}
+
+func (b *teeBranch) Close() error {
+	t := b.t
+	t.Lock()
+	defer t.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	t.trim()
+
+	for _, x := range t.branch {
+		if !x.closed {
+			return nil
+		}
+	}
+
+	t.mem = nil
+	if t.file != nil {
+		t.file.Close()
+		os.Remove(t.file.Name())
+		t.file = nil
+	}
+	return t.src.Close()
+}
+
+// splits the stream into 2 independent streams, the stream itself should not
+// be used after
+func (h *ReadableStream) Tee() (*ReadableStream, *ReadableStream) {
+	if h.hasCache {
+		return NewReadableStreamFromBuffer(h.cacheBuf), NewReadableStreamFromBuffer(h.cacheBuf)
+	}
+
+	t := &teeSource{
+		src:   h.Stream,
+		chunk: make([]byte, readableStreamChunkSize),
+	}
+	t.branch[0] = &teeBranch{t: t}
+	t.branch[1] = &teeBranch{t: t}
+	return NewReadableStreamFromStream(t.branch[0]), NewReadableStreamFromStream(t.branch[1])
+}
+
+// forks the stream in place, the stream itself keeps one branch of the tee and
+// the returned stream is the other one. Useful when the stream is referenced
+// elsewhere, ie by the body of a request, and cannot be replaced
+func (h *ReadableStream) Fork() *ReadableStream {
+	if h.hasCache {
+		return NewReadableStreamFromBuffer(h.cacheBuf)
+	}
+	a, b := h.Tee()
+	h.Stream = a.Stream
+	return b
+}
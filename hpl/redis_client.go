@@ -0,0 +1,352 @@
+package hpl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/redis/client"
+)
+
+// Client of an external redis server, or of the keyspace of the redis vhost.
+// The connections are pooled by the client and it is shared among sessions
+// safely. Besides do and pipeline, any
+// other method is sent as the redis command of the same name, ie
+// client:get("k") is GET k
+
+type RedisClient struct {
+	client client.Backend
+
+	// the client of a pool is owned by the pool and cannot be closed
+	pooled bool
+}
+
+// RedisClientFactory provides the pooled client of the address, ie the client
+// pool of the redis vhost used by redis::client
+type RedisClientFactory interface {
+	GetRedisClient(addr string) (*client.Client, error)
+}
+
+// RedisCommand is the command value received by the redis vhost, which is
+// passed as the whole command to the client, ie client:do(cmd)
+type RedisCommand interface {
+	Name() string
+	StringList() []string
+}
+
+func ValIsRedisClient(v pl.Val) bool {
+	return v.Id() == RedisClientTypeId
+}
+
+func NewRedisClientVal(c client.Backend) pl.Val {
+	return pl.NewValUsr(&RedisClient{client: c})
+}
+
+// NewPooledRedisClientVal wraps the client of a pool, see RedisClientFactory
+func NewPooledRedisClientVal(c client.Backend) pl.Val {
+	return pl.NewValUsr(&RedisClient{client: c, pooled: true})
+}
+
+func (c *RedisClient) Client() client.Backend {
+	return c.client
+}
+
+func redisOptionBool(v pl.Val, name string, ptr *bool) error {
+	if !v.IsBool() {
+		return fmt.Errorf("redis client option %s must be bool", name)
+	}
+	*ptr = v.Bool()
+	return nil
+}
+
+// redis client option passed from script as a map, the timeout is in
+// millisecond
+func NewRedisClientOptionFromVal(v pl.Val) (*client.Option, error) {
+	if !v.IsMap() {
+		return nil, fmt.Errorf("redis client option must be map")
+	}
+
+	o := &client.Option{}
+	var err error
+
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "username":
+				err = optionStr(val, key, &o.Username)
+			case "password":
+				err = optionStr(val, key, &o.Password)
+			case "db":
+				err = optionInt(val, key, &o.DB)
+			case "pool_size":
+				err = optionInt(val, key, &o.PoolSize)
+			case "pool_timeout":
+				err = optionDuration(val, key, &o.PoolTimeout)
+			case "dial_timeout":
+				err = optionDuration(val, key, &o.DialTimeout)
+			case "read_timeout":
+				err = optionDuration(val, key, &o.ReadTimeout)
+			case "write_timeout":
+				err = optionDuration(val, key, &o.WriteTimeout)
+			case "idle_timeout":
+				err = optionDuration(val, key, &o.IdleTimeout)
+			case "tls":
+				err = redisOptionBool(val, key, &o.TLS)
+			case "insecure_skip_verify":
+				err = redisOptionBool(val, key, &o.InsecureSkipVerify)
+			default:
+				err = fmt.Errorf("redis client option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// converts the script values into command arguments, the list is flattened
+// one level so client:del(["a", "b"]) works, and the received command is
+// expanded into its name and arguments
+func redisCommandArgs(name string, args []pl.Val, o []string) ([]string, error) {
+	for _, a := range args {
+		if a.IsUsr() {
+			if cmd, ok := a.Usr().(RedisCommand); ok {
+				o = append(o, cmd.Name())
+				o = append(o, cmd.StringList()...)
+				continue
+			}
+		}
+		switch {
+		case a.IsString():
+			o = append(o, a.String())
+		case a.IsInt():
+			o = append(o, strconv.FormatInt(a.Int(), 10))
+		case a.IsReal():
+			o = append(o, strconv.FormatFloat(a.Real(), 'g', -1, 64))
+		case a.IsBool():
+			if a.Bool() {
+				o = append(o, "1")
+			} else {
+				o = append(o, "0")
+			}
+		case a.IsList():
+			for _, x := range a.List().Data {
+				if x.IsList() {
+					return nil, fmt.Errorf("%s, nested list argument is not allowed", name)
+				}
+				var err error
+				if o, err = redisCommandArgs(name, []pl.Val{x}, o); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, fmt.Errorf("%s, argument of type %s is not allowed", name, a.Info())
+		}
+	}
+	return o, nil
+}
+
+// reply to value, the error reply is converted to {"error": message} only
+// when it is nested, ie inside of a pipeline result
+func redisReplyVal(r interface{}) pl.Val {
+	switch x := r.(type) {
+	case string:
+		return pl.NewValStr(x)
+	case client.Status:
+		return pl.NewValStr(string(x))
+	case int64:
+		return pl.NewValInt64(x)
+	case client.Error:
+		o := pl.NewValMap()
+		o.AddMap("error", pl.NewValStr(string(x)))
+		return o
+	case []interface{}:
+		o := pl.NewValList()
+		for _, e := range x {
+			o.AddList(redisReplyVal(e))
+		}
+		return o
+	default:
+		return pl.NewValNull()
+	}
+}
+
+// RedisCommandArgs converts the arguments into one command, see
+// redisCommandArgs
+func RedisCommandArgs(name string, args []pl.Val) ([]string, error) {
+	o, err := redisCommandArgs(name, args, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(o) == 0 {
+		return nil, fmt.Errorf("%s, command must be non empty list", name)
+	}
+	return o, nil
+}
+
+// RedisCommandList converts the list of commands, each of which is either a
+// list of arguments or the received command
+func RedisCommandList(name string, v pl.Val) ([][]string, error) {
+	cmds := [][]string{}
+	for _, x := range v.List().Data {
+		var data []pl.Val
+		switch {
+		case x.IsList():
+			data = x.List().Data
+		case x.IsUsr():
+			data = []pl.Val{x}
+		}
+		cmd, err := RedisCommandArgs(name, data)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds, nil
+}
+
+func (c *RedisClient) Index(_ pl.Val) (pl.Val, error) {
+	return pl.NewValNull(), fmt.Errorf("%s does not support index", c.Id())
+}
+
+func (c *RedisClient) IndexSet(_ pl.Val, _ pl.Val) error {
+	return fmt.Errorf("%s does not support index set", c.Id())
+}
+
+func (c *RedisClient) Dot(name string) (pl.Val, error) {
+	switch name {
+	case "addr":
+		return pl.NewValStr(c.client.Addr()), nil
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s unknown field %s", c.Id(), name)
+}
+
+func (c *RedisClient) DotSet(_ string, _ pl.Val) error {
+	return fmt.Errorf("%s does not support dot set", c.Id())
+}
+
+func (c *RedisClient) ToString() (string, error) {
+	return c.Info(), nil
+}
+
+func (c *RedisClient) ToJSON() (pl.Val, error) {
+	return pl.MarshalVal(
+		map[string]interface{}{
+			"type": RedisClientTypeId,
+			"addr": c.client.Addr(),
+		},
+	)
+}
+
+var (
+	methodProtoRedisClientDo       = pl.MustNewFuncProto("redisclient.client.do", "{%s}{%U}{%s%a*}")
+	methodProtoRedisClientPipeline = pl.MustNewFuncProto("redisclient.client.pipeline", "%l")
+	methodProtoRedisClientClose    = pl.MustNewFuncProto("redisclient.client.close", "%0")
+)
+
+func (c *RedisClient) do(name string, args []string) (pl.Val, error) {
+	r, err := c.client.Do(args...)
+	if err != nil {
+		return pl.NewValNull(), fmt.Errorf("%s, %s", name, err.Error())
+	}
+	return redisReplyVal(r), nil
+}
+
+func (c *RedisClient) Method(name string, args []pl.Val) (pl.Val, error) {
+	switch name {
+	// do(command, ...) or do(cmd), the error reply is raised as error
+	case "do":
+		if _, err := methodProtoRedisClientDo.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		cmd, err := redisCommandArgs("redisclient.client.do", args, nil)
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		return c.do("redisclient.client.do", cmd)
+
+	// pipeline([[command, ...], ...]), sends all the commands at once and
+	// returns the list of replies, the error reply is {"error": message}. The
+	// received command is accepted as the element as well
+	case "pipeline":
+		if _, err := methodProtoRedisClientPipeline.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		cmds, err := RedisCommandList("redisclient.client.pipeline", args[0])
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		r, err := c.client.Pipeline(cmds)
+		if err != nil {
+			return pl.NewValNull(), fmt.Errorf("redisclient.client.pipeline, %s", err.Error())
+		}
+		return redisReplyVal(r), nil
+
+	case "close":
+		if _, err := methodProtoRedisClientClose.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		if c.pooled {
+			return pl.NewValNull(), fmt.Errorf("redisclient.client.close, pooled client cannot be closed")
+		}
+		c.client.Close()
+		return pl.NewValNull(), nil
+
+	default:
+		break
+	}
+
+	mname := fmt.Sprintf("redisclient.client.%s", name)
+	cmd, err := redisCommandArgs(mname, args, []string{strings.ToUpper(name)})
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	return c.do(mname, cmd)
+}
+
+func (c *RedisClient) Info() string {
+	return fmt.Sprintf("%s[%s]", c.Id(), c.client.Addr())
+}
+
+func (c *RedisClient) Id() string {
+	return RedisClientTypeId
+}
+
+func (c *RedisClient) IsThreadSafe() bool {
+	return true
+}
+
+func (c *RedisClient) NewIterator() (pl.Iter, error) {
+	return nil, fmt.Errorf("%s does not support iterator", c.Id())
+}
+
+// redisclient::connect(addr, [option]), the addr is host:port or a redis url,
+// ie redis://:password@host:port/db. The server is pinged so a wrong address
+// or credential fails here instead of the first command
+func fnRedisClientConnect(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	alen, err := info.Check(argument)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	option := &client.Option{}
+	if alen == 2 {
+		if option, err = NewRedisClientOptionFromVal(argument[1]); err != nil {
+			return pl.NewValNull(), fmt.Errorf("redisclient::connect invalid option: %s", err.Error())
+		}
+	}
+	c, err := client.NewClient(argument[0].String(), option)
+	if err != nil {
+		return pl.NewValNull(), fmt.Errorf("redisclient::connect %s", err.Error())
+	}
+	if _, err := c.Do("PING"); err != nil {
+		c.Close()
+		return pl.NewValNull(), fmt.Errorf("redisclient::connect %s", err.Error())
+	}
+	return NewRedisClientVal(c), nil
+}
@@ -3,6 +3,7 @@ package hpl
 import (
 	"fmt"
 	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/util"
 	"io"
 	"net/http"
 	"net/url"
@@ -14,17 +15,135 @@ type Request struct {
 	header  pl.Val
 	url     pl.Val
 	body    pl.Val
+	trailer pl.Val
 	tls     pl.Val
+
+	// id assigned to the request, ie by the request_id middleware
+	id string
+
+	// whether the body is replaced by the script, the content length of the
+	// request is not valid anymore
+	bodyDirty bool
 }
 
 func ValIsHttpRequest(v pl.Val) bool {
 	return v.Id() == HttpRequestTypeId
 }
 
+// the underlying http.Request with all the modification done by the script
+// applied
 func (r *Request) HttpRequest() *http.Request {
+	r.sync()
 	return r.request
 }
 
+// the body value seen by the script
+func (r *Request) Body() pl.Val {
+	return r.body
+}
+
+// the id assigned to the request, empty if none
+func (r *Request) RequestId() string {
+	return r.id
+}
+
+func (r *Request) SetRequestId(id string) {
+	r.id = id
+}
+
+func (r *Request) sync() {
+	if u, ok := r.url.Usr().(*Url); ok {
+		r.request.URL = u.URL()
+	}
+	if h, ok := r.header.Usr().(*Header); ok {
+		r.request.Header = h.HttpHeader()
+	}
+	if t, ok := r.trailer.Usr().(*Header); ok {
+		if hdr := t.HttpHeader(); len(hdr) > 0 || r.request.Trailer != nil {
+			r.request.Trailer = hdr
+		}
+	}
+	if b, ok := r.body.Usr().(*Body); ok {
+		stream := b.Stream()
+		if r.request.Body != stream.Stream {
+			// the body is replaced or partially consumed, the request can only be
+			// replayed, ie by retry and redirection, when the content is cached
+			if buf, ok := stream.TryCacheBuffer(); ok {
+				r.request.GetBody = func() (io.ReadCloser, error) {
+					return neweofByteReadCloser(buf), nil
+				}
+			} else {
+				r.request.GetBody = nil
+			}
+		}
+		r.request.Body = stream.Stream
+		if r.bodyDirty {
+			r.request.ContentLength = int64(stream.ByteLength())
+			r.bodyDirty = false
+		}
+	}
+}
+
+// clones the request, the body is forked so both requests see the full body
+func (r *Request) Clone() pl.Val {
+	r.sync()
+	req := r.request.Clone(r.request.Context())
+
+	var body pl.Val
+	if b, ok := r.body.Usr().(*Body); ok {
+		body = b.Clone()
+		bb, _ := body.Usr().(*Body)
+		req.Body = bb.Stream().Stream
+	} else {
+		body = NewBodyValFromStream(http.NoBody)
+	}
+	x := newRequestVal(req, body, r.tls)
+	if c, ok := x.Usr().(*Request); ok {
+		c.id = r.id
+	}
+	return x
+}
+
+func (r *Request) setMethod(v pl.Val) error {
+	if v.Type != pl.ValStr {
+		return fmt.Errorf("http.request.method set, invalid type")
+	}
+	m := v.String()
+	if m == "" || strings.ContainsAny(m, " \t\r\n") {
+		return fmt.Errorf("http.request.method set, invalid method %s", m)
+	}
+	r.request.Method = m
+	return nil
+}
+
+func (r *Request) setHost(v pl.Val) error {
+	if v.Type != pl.ValStr {
+		return fmt.Errorf("http.request.host set, invalid type")
+	}
+	r.request.Host = v.String()
+	return nil
+}
+
+// sets the length of a streamed body explicitly, ie when the script knows the
+// size of the upload and the upstream does not accept chunked encoding. -1
+// means unknown
+func (r *Request) setContentLength(v pl.Val) error {
+	if !v.IsInt() || v.Int() < -1 {
+		return fmt.Errorf("http.request.contentLength set, invalid value")
+	}
+	r.sync()
+	r.request.ContentLength = v.Int()
+	return nil
+}
+
+func (r *Request) setTrailer(v pl.Val) error {
+	if ValIsHttpHeader(v) {
+		r.trailer = v
+		return nil
+	}
+	return fmt.Errorf("http.request.trailer set, invalid type")
+}
+
 func (r *Request) setUrl(v pl.Val) error {
 	switch v.Type {
 	case pl.ValStr:
@@ -57,17 +176,37 @@ func (r *Request) setHeader(v pl.Val) error {
 func (r *Request) setBody(v pl.Val) error {
 	if ValIsHttpBody(v) {
 		r.body = v
+		r.bodyDirty = true
 		return nil
 	}
 
 	if v.Type == pl.ValStr {
 		r.body = NewBodyValFromString(v.String())
+		r.bodyDirty = true
+		return nil
+	}
+
+	if ValIsReadableStream(v) {
+		x, _ := v.Usr().(*ReadableStream)
+		r.body = newBodyValFromReadableStream(v, x)
+		r.bodyDirty = true
+		return nil
+	}
+
+	if pl.IsValTemplateStream(v) {
+		r.body = NewBodyValFromTemplateStream(v)
+		r.bodyDirty = true
 		return nil
 	}
 
 	return fmt.Errorf("http.request.body set, invalid type")
 }
 
+// body accepts template rendered lazily, see pl.TemplateStream
+func (r *Request) AcceptTemplateStream(field string) bool {
+	return field == "body"
+}
+
 func (h *Request) isTLS() bool {
 	return h.request.TLS != nil
 }
@@ -91,15 +230,24 @@ func (h *Request) Index(key pl.Val) (pl.Val, error) {
 		return h.header, nil
 	case "method":
 		return pl.NewValStr(h.request.Method), nil
+	case "id":
+		if h.id == "" {
+			return pl.NewValNull(), nil
+		}
+		return pl.NewValStr(h.id), nil
 	case "proto":
 		return pl.NewValStr(h.request.Proto), nil
 	case "protoMajor":
 		return pl.NewValInt(h.request.ProtoMajor), nil
 	case "protoMinor":
 		return pl.NewValInt(h.request.ProtoMinor), nil
+	case "protocol":
+		return pl.NewValStr(util.HttpProtocol(h.request)), nil
 
 	case "body":
 		return h.body, nil
+	case "trailer":
+		return h.trailer, nil
 
 	// URI related
 	case "requestURI":
@@ -166,11 +314,21 @@ func (h *Request) DotSet(key string, val pl.Val) error {
 	case "body":
 		return h.setBody(val)
 
+	case "trailer":
+		return h.setTrailer(val)
+
+	case "method":
+		return h.setMethod(val)
+
+	case "host":
+		return h.setHost(val)
+
+	case "contentLength":
+		return h.setContentLength(val)
+
 	default:
 		return fmt.Errorf("http.request set, unknown field: %s", key)
 	}
-
-	return nil
 }
 
 func (h *Request) ToString() (string, error) {
@@ -186,6 +344,7 @@ func (h *Request) ToJSON() (pl.Val, error) {
 			"requestURI": h.request.RequestURI,
 			"url":        h.request.URL.String(),
 			"proto":      h.request.Proto,
+			"protocol":   util.HttpProtocol(h.request),
 			"tls":        h.request.TLS,
 			"header":     h.request.Header,
 			"remoteAddr": h.request.RemoteAddr,
@@ -196,6 +355,9 @@ func (h *Request) ToJSON() (pl.Val, error) {
 var (
 	methodProtoRequestCookie    = pl.MustNewFuncProto("http.request.cookie", "%s")
 	methodProtoRequestAllCookie = pl.MustNewFuncProto("http.request.allCookie", "%0")
+	methodProtoRequestClone     = pl.MustNewFuncProto("http.request.clone", "%0")
+	methodProtoRequestCookies   = pl.MustNewFuncProto("http.request.cookies", "%0")
+	methodProtoRequestAddCookie = pl.MustNewFuncProto("http.request.addCookie", "%U['http.cookie']")
 )
 
 func (h *Request) Method(name string, args []pl.Val) (pl.Val, error) {
@@ -221,6 +383,30 @@ func (h *Request) Method(name string, args []pl.Val) (pl.Val, error) {
 		}
 		return o, nil
 
+	case "clone":
+		if _, err := methodProtoRequestClone.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		return h.Clone(), nil
+
+	case "cookies":
+		if _, err := methodProtoRequestCookies.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		o := pl.NewValList()
+		for _, c := range h.HttpRequest().Cookies() {
+			o.AddList(NewCookieVal(c))
+		}
+		return o, nil
+
+	case "addCookie":
+		if _, err := methodProtoRequestAddCookie.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		c, _ := HttpCookieFromVal(args[0])
+		h.HttpRequest().AddCookie(c)
+		return pl.NewValNull(), nil
+
 	default:
 		break
 	}
@@ -243,18 +429,32 @@ func (h *Request) NewIterator() (pl.Iter, error) {
 	return nil, fmt.Errorf("http.request does not support iterator")
 }
 
-func NewRequestVal(req *http.Request) pl.Val {
+func newRequestVal(req *http.Request, body pl.Val, tls pl.Val) pl.Val {
+	trailer := req.Trailer
+	if trailer == nil {
+		trailer = make(http.Header)
+	}
+
 	x := &Request{
 		request: req,
 		header:  NewHeaderVal(req.Header),
 		url:     NewUrlVal(req.URL),
-		body:    NewBodyValFromStream(req.Body),
-		tls:     NewTLSConnStateVal(req.TLS),
+		body:    body,
+		trailer: NewHeaderVal(trailer),
+		tls:     tls,
 	}
 
 	return pl.NewValUsr(x)
 }
 
+func NewRequestVal(req *http.Request) pl.Val {
+	body := req.Body
+	if body == nil {
+		body = http.NoBody
+	}
+	return newRequestVal(req, NewBodyValFromStream(body), NewTLSConnStateVal(req.TLS))
+}
+
 func NewRequestValFromVal(
 	method string,
 	url string,
@@ -11,6 +11,11 @@ type Response struct {
 	header   pl.Val
 	request  pl.Val
 	body     pl.Val
+	trailer  pl.Val
+
+	// whether the body is replaced by the script, the content length of the
+	// response is not valid anymore
+	bodyDirty bool
 }
 
 func ValIsHttpResponse(a pl.Val) bool {
@@ -26,10 +31,76 @@ func (h *Response) isChunked() bool {
 	return false
 }
 
+// the underlying http.Response with all the modification done by the script
+// applied
 func (r *Response) HttpResponse() *http.Response {
+	r.sync()
 	return r.response
 }
 
+func (r *Response) sync() {
+	if h, ok := r.header.Usr().(*Header); ok {
+		r.response.Header = h.HttpHeader()
+	}
+	if t, ok := r.trailer.Usr().(*Header); ok {
+		if hdr := t.HttpHeader(); len(hdr) > 0 || r.response.Trailer != nil {
+			r.response.Trailer = hdr
+		}
+	}
+	if b, ok := r.body.Usr().(*Body); ok {
+		stream := b.Stream()
+		r.response.Body = stream.Stream
+		if r.bodyDirty {
+			r.response.ContentLength = int64(stream.ByteLength())
+			if r.response.ContentLength >= 0 {
+				r.response.Header.Set("Content-Length", fmt.Sprintf("%d", r.response.ContentLength))
+			} else {
+				r.response.Header.Del("Content-Length")
+			}
+			r.bodyDirty = false
+		}
+	}
+}
+
+// clones the response, the body is forked so both responses see the full body
+func (r *Response) Clone() pl.Val {
+	r.sync()
+	resp := *r.response
+	resp.Header = r.response.Header.Clone()
+	resp.Trailer = r.response.Trailer.Clone()
+
+	var body pl.Val
+	if b, ok := r.body.Usr().(*Body); ok {
+		body = b.Clone()
+		bb, _ := body.Usr().(*Body)
+		resp.Body = bb.Stream().Stream
+	} else {
+		body = NewBodyValFromStream(http.NoBody)
+	}
+	return newResponseVal(&resp, r.request, body)
+}
+
+func (r *Response) setStatus(v pl.Val) error {
+	if v.Type != pl.ValInt {
+		return fmt.Errorf("http.response.status set, invalid type")
+	}
+	code := int(v.Int())
+	if code < 100 || code > 999 {
+		return fmt.Errorf("http.response.status set, invalid status %d", code)
+	}
+	r.response.StatusCode = code
+	r.response.Status = fmt.Sprintf("%d %s", code, http.StatusText(code))
+	return nil
+}
+
+func (r *Response) setTrailer(v pl.Val) error {
+	if ValIsHttpHeader(v) {
+		r.trailer = v
+		return nil
+	}
+	return fmt.Errorf("http.response.trailer set, invalid type")
+}
+
 func (r *Response) setHeader(v pl.Val) error {
 	if ValIsHttpHeader(v) {
 		r.header = v
@@ -41,17 +112,37 @@ func (r *Response) setHeader(v pl.Val) error {
 func (r *Response) setBody(v pl.Val) error {
 	if ValIsHttpBody(v) {
 		r.body = v
+		r.bodyDirty = true
 		return nil
 	}
 
 	if v.Type == pl.ValStr {
 		r.body = NewBodyValFromString(v.String())
+		r.bodyDirty = true
+		return nil
+	}
+
+	if ValIsReadableStream(v) {
+		x, _ := v.Usr().(*ReadableStream)
+		r.body = newBodyValFromReadableStream(v, x)
+		r.bodyDirty = true
+		return nil
+	}
+
+	if pl.IsValTemplateStream(v) {
+		r.body = NewBodyValFromTemplateStream(v)
+		r.bodyDirty = true
 		return nil
 	}
 
 	return fmt.Errorf("http.response.body set, invalid type")
 }
 
+// body accepts template rendered lazily, see pl.TemplateStream
+func (r *Response) AcceptTemplateStream(field string) bool {
+	return field == "body"
+}
+
 func (h *Response) Index(name pl.Val) (pl.Val, error) {
 	if name.Type != pl.ValStr {
 		return pl.NewValNull(), fmt.Errorf("invalid index, name must be string")
@@ -71,6 +162,9 @@ func (h *Response) Index(name pl.Val) (pl.Val, error) {
 	case "body":
 		return h.body, nil
 
+	case "trailer":
+		return h.trailer, nil
+
 	case "contentLength":
 		if h.response.ContentLength >= 0 {
 			return pl.NewValInt(int(h.response.ContentLength)), nil
@@ -110,9 +204,15 @@ func (h *Response) DotSet(key string, value pl.Val) error {
 	case "header":
 		return h.setHeader(value)
 
-	case "url":
+	case "body":
 		return h.setBody(value)
 
+	case "trailer":
+		return h.setTrailer(value)
+
+	case "status":
+		return h.setStatus(value)
+
 	default:
 		return fmt.Errorf("http.response set, unknown field: %s", key)
 	}
@@ -136,7 +236,43 @@ func (h *Response) IsThreadSafe() bool {
 	return false
 }
 
-func (h *Response) Method(name string, _ []pl.Val) (pl.Val, error) {
+var (
+	methodProtoResponseClone     = pl.MustNewFuncProto("http.response.clone", "%0")
+	methodProtoResponseCookies   = pl.MustNewFuncProto("http.response.cookies", "%0")
+	methodProtoResponseSetCookie = pl.MustNewFuncProto("http.response.setCookie", "%U['http.cookie']")
+)
+
+func (h *Response) Method(name string, args []pl.Val) (pl.Val, error) {
+	switch name {
+	case "clone":
+		if _, err := methodProtoResponseClone.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		return h.Clone(), nil
+
+	case "cookies":
+		if _, err := methodProtoResponseCookies.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		o := pl.NewValList()
+		for _, c := range h.HttpResponse().Cookies() {
+			o.AddList(NewCookieVal(c))
+		}
+		return o, nil
+
+	case "setCookie":
+		if _, err := methodProtoResponseSetCookie.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		c, _ := HttpCookieFromVal(args[0])
+		if v := c.String(); v != "" {
+			h.HttpResponse().Header.Add("Set-Cookie", v)
+		}
+		return pl.NewValNull(), nil
+
+	default:
+		break
+	}
 	return pl.NewValNull(), fmt.Errorf("http.response method %s is unknown", name)
 }
 
@@ -152,6 +288,26 @@ func (h *Response) NewIterator() (pl.Iter, error) {
 	return nil, fmt.Errorf("http.response does not support iterator")
 }
 
+func newResponseVal(response *http.Response, request pl.Val, body pl.Val) pl.Val {
+	if response.Header == nil {
+		response.Header = make(http.Header)
+	}
+	trailer := response.Trailer
+	if trailer == nil {
+		trailer = make(http.Header)
+	}
+
+	x := &Response{
+		response: response,
+		header:   NewHeaderVal(response.Header),
+		request:  request,
+		body:     body,
+		trailer:  NewHeaderVal(trailer),
+	}
+
+	return pl.NewValUsr(x)
+}
+
 func NewResponseVal(response *http.Response) pl.Val {
 	var request pl.Val
 	if response.Request != nil {
@@ -160,12 +316,43 @@ func NewResponseVal(response *http.Response) pl.Val {
 		request = pl.NewValNull()
 	}
 
-	x := &Response{
-		response: response,
-		header:   NewHeaderVal(response.Header),
-		request:  request,
-		body:     NewBodyValFromStream(response.Body),
+	body := response.Body
+	if body == nil {
+		body = http.NoBody
 	}
+	return newResponseVal(response, request, NewBodyValFromStream(body))
+}
 
-	return pl.NewValUsr(x)
+// creates a response from scratch, ie to be returned by a script in place of
+// the upstream's response
+func NewResponseValFromVal(status int, header pl.Val, body pl.Val) (pl.Val, error) {
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          http.NoBody,
+		ContentLength: 0,
+	}
+
+	if !header.IsNull() {
+		hdr, err := NewHeaderValFromVal(header)
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		h, _ := hdr.Usr().(*Header)
+		resp.Header = h.HttpHeader()
+	}
+
+	x := NewResponseVal(resp)
+	if !body.IsNull() {
+		r, _ := x.Usr().(*Response)
+		if err := r.setBody(body); err != nil {
+			return pl.NewValNull(), err
+		}
+		r.sync()
+	}
+	return x, nil
 }
@@ -0,0 +1,616 @@
+//go:build sql
+// +build sql
+
+package hpl
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/dianpeng/moons/pl"
+)
+
+// sql:: module wraps database/sql. It is only built with the sql tag, ie
+// go build -tags sql, since no driver is linked by default. The driver must be
+// linked into the binary by a blank import in cmd, guarded by the same tag,
+// and is picked by name in sql::open. Rows are returned as list of maps keyed
+// by column name, the []byte column is string and the time column is RFC3339
+// string
+
+type SqlOption struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// timeout of each query/exec, 0 means no timeout
+	Timeout time.Duration
+}
+
+func NewSqlOptionFromVal(v pl.Val) (*SqlOption, error) {
+	if !v.IsMap() {
+		return nil, fmt.Errorf("sql option must be map")
+	}
+
+	o := &SqlOption{}
+	var err error
+
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "max_open_conns":
+				err = optionInt(val, key, &o.MaxOpenConns)
+			case "max_idle_conns":
+				err = optionInt(val, key, &o.MaxIdleConns)
+			case "conn_max_lifetime":
+				err = optionDuration(val, key, &o.ConnMaxLifetime)
+			case "conn_max_idle_time":
+				err = optionDuration(val, key, &o.ConnMaxIdleTime)
+			case "timeout":
+				err = optionDuration(val, key, &o.Timeout)
+			default:
+				err = fmt.Errorf("sql option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// converts the script values into query arguments
+func sqlArgs(name string, args []pl.Val) ([]interface{}, error) {
+	o := make([]interface{}, 0, len(args))
+	for _, a := range args {
+		switch {
+		case a.IsNull():
+			o = append(o, nil)
+		case a.IsInt():
+			o = append(o, a.Int())
+		case a.IsReal():
+			o = append(o, a.Real())
+		case a.IsBool():
+			o = append(o, a.Bool())
+		case a.IsString():
+			o = append(o, a.String())
+		default:
+			return nil, fmt.Errorf("%s, argument of type %s is not allowed", name, a.Info())
+		}
+	}
+	return o, nil
+}
+
+func sqlColumnVal(v interface{}) pl.Val {
+	switch x := v.(type) {
+	case nil:
+		return pl.NewValNull()
+	case int64:
+		return pl.NewValInt64(x)
+	case float64:
+		return pl.NewValReal(x)
+	case bool:
+		return pl.NewValBool(x)
+	case string:
+		return pl.NewValStr(x)
+	case []byte:
+		return pl.NewValStr(string(x))
+	case time.Time:
+		return pl.NewValStr(x.Format(time.RFC3339Nano))
+	default:
+		return pl.NewValStr(fmt.Sprint(x))
+	}
+}
+
+// reads the rows into list of maps, or the first row only when first is true,
+// which is null if there is no row
+func sqlRowsVal(rows *sql.Rows, first bool) (pl.Val, error) {
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+
+	o := pl.NewValList()
+	for rows.Next() {
+		cell := make([]interface{}, len(columns))
+		ptr := make([]interface{}, len(columns))
+		for i := range cell {
+			ptr[i] = &cell[i]
+		}
+		if err := rows.Scan(ptr...); err != nil {
+			return pl.NewValNull(), err
+		}
+		row := pl.NewValMap()
+		for i, c := range columns {
+			row.AddMap(c, sqlColumnVal(cell[i]))
+		}
+		if first {
+			return row, rows.Close()
+		}
+		o.AddList(row)
+	}
+	if err := rows.Err(); err != nil {
+		return pl.NewValNull(), err
+	}
+	if first {
+		return pl.NewValNull(), nil
+	}
+	return o, nil
+}
+
+func sqlResultVal(r sql.Result) pl.Val {
+	o := pl.NewValMap()
+	if n, err := r.RowsAffected(); err == nil {
+		o.AddMap("rows_affected", pl.NewValInt64(n))
+	} else {
+		o.AddMap("rows_affected", pl.NewValNull())
+	}
+	if id, err := r.LastInsertId(); err == nil {
+		o.AddMap("last_insert_id", pl.NewValInt64(id))
+	} else {
+		o.AddMap("last_insert_id", pl.NewValNull())
+	}
+	return o
+}
+
+func sqlContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// the common part of sql.db and sql.tx
+type sqlQueryer interface {
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+	PrepareContext(context.Context, string) (*sql.Stmt, error)
+}
+
+var (
+	methodProtoSqlQuery    = pl.MustNewFuncProto("sql.query", "{%s}{%s%a*}")
+	methodProtoSqlQueryRow = pl.MustNewFuncProto("sql.query_row", "{%s}{%s%a*}")
+	methodProtoSqlExec     = pl.MustNewFuncProto("sql.exec", "{%s}{%s%a*}")
+	methodProtoSqlPrepare  = pl.MustNewFuncProto("sql.prepare", "%s")
+	methodProtoSqlNoArg    = pl.MustNewFuncProto("sql", "%0")
+)
+
+// handles query, query_row, exec and prepare, returns false if the method is
+// not one of them
+func sqlQueryerMethod(q sqlQueryer, timeout time.Duration, id string, name string, args []pl.Val) (pl.Val, bool, error) {
+	var proto *pl.FuncProto
+	switch name {
+	case "query":
+		proto = methodProtoSqlQuery
+	case "query_row":
+		proto = methodProtoSqlQueryRow
+	case "exec":
+		proto = methodProtoSqlExec
+	case "prepare":
+		proto = methodProtoSqlPrepare
+	default:
+		return pl.NewValNull(), false, nil
+	}
+
+	mname := fmt.Sprintf("%s.%s", id, name)
+	if _, err := proto.Check(args); err != nil {
+		return pl.NewValNull(), true, err
+	}
+	qargs, err := sqlArgs(mname, args[1:])
+	if err != nil {
+		return pl.NewValNull(), true, err
+	}
+
+	ctx, cancel := sqlContext(timeout)
+	defer cancel()
+
+	var v pl.Val
+	switch name {
+	case "query", "query_row":
+		var rows *sql.Rows
+		rows, err = q.QueryContext(ctx, args[0].String(), qargs...)
+		if err == nil {
+			v, err = sqlRowsVal(rows, name == "query_row")
+		}
+	case "exec":
+		var r sql.Result
+		r, err = q.ExecContext(ctx, args[0].String(), qargs...)
+		if err == nil {
+			v = sqlResultVal(r)
+		}
+	default:
+		var stmt *sql.Stmt
+		stmt, err = q.PrepareContext(context.Background(), args[0].String())
+		if err == nil {
+			v = pl.NewValUsr(&SqlStmt{stmt: stmt, timeout: timeout})
+		}
+	}
+	if err != nil {
+		return pl.NewValNull(), true, fmt.Errorf("%s, %s", mname, err.Error())
+	}
+	return v, true, nil
+}
+
+// SqlDB is the pooled database handle, it is shared among sessions safely
+type SqlDB struct {
+	db      *sql.DB
+	driver  string
+	timeout time.Duration
+}
+
+func ValIsSqlDB(v pl.Val) bool {
+	return v.Id() == SqlDBTypeId
+}
+
+func (d *SqlDB) DB() *sql.DB {
+	return d.db
+}
+
+func (d *SqlDB) Index(_ pl.Val) (pl.Val, error) {
+	return pl.NewValNull(), fmt.Errorf("%s does not support index", d.Id())
+}
+
+func (d *SqlDB) IndexSet(_ pl.Val, _ pl.Val) error {
+	return fmt.Errorf("%s does not support index set", d.Id())
+}
+
+func (d *SqlDB) Dot(name string) (pl.Val, error) {
+	switch name {
+	case "driver":
+		return pl.NewValStr(d.driver), nil
+	case "stats":
+		s := d.db.Stats()
+		return pl.MarshalVal(
+			map[string]interface{}{
+				"max_open_conns":      s.MaxOpenConnections,
+				"open_conns":          s.OpenConnections,
+				"in_use":              s.InUse,
+				"idle":                s.Idle,
+				"wait_count":          s.WaitCount,
+				"wait_duration":       s.WaitDuration.Milliseconds(),
+				"max_idle_closed":     s.MaxIdleClosed,
+				"max_lifetime_closed": s.MaxLifetimeClosed,
+			},
+		)
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s unknown field %s", d.Id(), name)
+}
+
+func (d *SqlDB) DotSet(_ string, _ pl.Val) error {
+	return fmt.Errorf("%s does not support dot set", d.Id())
+}
+
+func (d *SqlDB) ToString() (string, error) {
+	return d.Info(), nil
+}
+
+func (d *SqlDB) ToJSON() (pl.Val, error) {
+	return pl.MarshalVal(
+		map[string]interface{}{
+			"type":   SqlDBTypeId,
+			"driver": d.driver,
+		},
+	)
+}
+
+func (d *SqlDB) Method(name string, args []pl.Val) (pl.Val, error) {
+	if v, ok, err := sqlQueryerMethod(d.db, d.timeout, d.Id(), name, args); ok {
+		return v, err
+	}
+
+	switch name {
+	case "begin":
+		if _, err := methodProtoSqlNoArg.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		tx, err := d.db.Begin()
+		if err != nil {
+			return pl.NewValNull(), fmt.Errorf("%s.begin, %s", d.Id(), err.Error())
+		}
+		return pl.NewValUsr(&SqlTx{tx: tx, timeout: d.timeout}), nil
+
+	case "ping":
+		if _, err := methodProtoSqlNoArg.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		ctx, cancel := sqlContext(d.timeout)
+		defer cancel()
+		return pl.NewValBool(d.db.PingContext(ctx) == nil), nil
+
+	case "close":
+		if _, err := methodProtoSqlNoArg.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		d.db.Close()
+		return pl.NewValNull(), nil
+
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s's method %s is unknown", d.Id(), name)
+}
+
+func (d *SqlDB) Info() string {
+	return fmt.Sprintf("%s[%s]", d.Id(), d.driver)
+}
+
+func (d *SqlDB) Id() string {
+	return SqlDBTypeId
+}
+
+func (d *SqlDB) IsThreadSafe() bool {
+	return true
+}
+
+func (d *SqlDB) NewIterator() (pl.Iter, error) {
+	return nil, fmt.Errorf("%s does not support iterator", d.Id())
+}
+
+// SqlTx is a transaction, it must be ended by commit or rollback otherwise the
+// connection is held until the database handle is closed
+type SqlTx struct {
+	tx      *sql.Tx
+	timeout time.Duration
+}
+
+func (t *SqlTx) Index(_ pl.Val) (pl.Val, error) {
+	return pl.NewValNull(), fmt.Errorf("%s does not support index", t.Id())
+}
+
+func (t *SqlTx) IndexSet(_ pl.Val, _ pl.Val) error {
+	return fmt.Errorf("%s does not support index set", t.Id())
+}
+
+func (t *SqlTx) Dot(_ string) (pl.Val, error) {
+	return pl.NewValNull(), fmt.Errorf("%s does not support dot", t.Id())
+}
+
+func (t *SqlTx) DotSet(_ string, _ pl.Val) error {
+	return fmt.Errorf("%s does not support dot set", t.Id())
+}
+
+func (t *SqlTx) ToString() (string, error) {
+	return t.Info(), nil
+}
+
+func (t *SqlTx) ToJSON() (pl.Val, error) {
+	return pl.MarshalVal(
+		map[string]interface{}{
+			"type": SqlTxTypeId,
+		},
+	)
+}
+
+func (t *SqlTx) Method(name string, args []pl.Val) (pl.Val, error) {
+	if v, ok, err := sqlQueryerMethod(t.tx, t.timeout, t.Id(), name, args); ok {
+		return v, err
+	}
+
+	switch name {
+	case "commit", "rollback":
+		if _, err := methodProtoSqlNoArg.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		var err error
+		if name == "commit" {
+			err = t.tx.Commit()
+		} else {
+			err = t.tx.Rollback()
+		}
+		if err != nil {
+			return pl.NewValNull(), fmt.Errorf("%s.%s, %s", t.Id(), name, err.Error())
+		}
+		return pl.NewValNull(), nil
+
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s's method %s is unknown", t.Id(), name)
+}
+
+func (t *SqlTx) Info() string {
+	return t.Id()
+}
+
+func (t *SqlTx) Id() string {
+	return SqlTxTypeId
+}
+
+func (t *SqlTx) IsThreadSafe() bool {
+	return false
+}
+
+func (t *SqlTx) NewIterator() (pl.Iter, error) {
+	return nil, fmt.Errorf("%s does not support iterator", t.Id())
+}
+
+// SqlStmt is a prepared statement, the one prepared from the database handle
+// is shared among sessions safely
+type SqlStmt struct {
+	stmt    *sql.Stmt
+	timeout time.Duration
+}
+
+var (
+	methodProtoSqlStmtQuery = pl.MustNewFuncProto("sql.stmt.query", "{%0}{%a*}")
+	methodProtoSqlStmtExec  = pl.MustNewFuncProto("sql.stmt.exec", "{%0}{%a*}")
+)
+
+func (s *SqlStmt) Index(_ pl.Val) (pl.Val, error) {
+	return pl.NewValNull(), fmt.Errorf("%s does not support index", s.Id())
+}
+
+func (s *SqlStmt) IndexSet(_ pl.Val, _ pl.Val) error {
+	return fmt.Errorf("%s does not support index set", s.Id())
+}
+
+func (s *SqlStmt) Dot(_ string) (pl.Val, error) {
+	return pl.NewValNull(), fmt.Errorf("%s does not support dot", s.Id())
+}
+
+func (s *SqlStmt) DotSet(_ string, _ pl.Val) error {
+	return fmt.Errorf("%s does not support dot set", s.Id())
+}
+
+func (s *SqlStmt) ToString() (string, error) {
+	return s.Info(), nil
+}
+
+func (s *SqlStmt) ToJSON() (pl.Val, error) {
+	return pl.MarshalVal(
+		map[string]interface{}{
+			"type": SqlStmtTypeId,
+		},
+	)
+}
+
+func (s *SqlStmt) Method(name string, args []pl.Val) (pl.Val, error) {
+	mname := fmt.Sprintf("%s.%s", s.Id(), name)
+
+	switch name {
+	case "query", "query_row":
+		if _, err := methodProtoSqlStmtQuery.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		qargs, err := sqlArgs(mname, args)
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		ctx, cancel := sqlContext(s.timeout)
+		defer cancel()
+		rows, err := s.stmt.QueryContext(ctx, qargs...)
+		if err != nil {
+			return pl.NewValNull(), fmt.Errorf("%s, %s", mname, err.Error())
+		}
+		v, err := sqlRowsVal(rows, name == "query_row")
+		if err != nil {
+			return pl.NewValNull(), fmt.Errorf("%s, %s", mname, err.Error())
+		}
+		return v, nil
+
+	case "exec":
+		if _, err := methodProtoSqlStmtExec.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		qargs, err := sqlArgs(mname, args)
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		ctx, cancel := sqlContext(s.timeout)
+		defer cancel()
+		r, err := s.stmt.ExecContext(ctx, qargs...)
+		if err != nil {
+			return pl.NewValNull(), fmt.Errorf("%s, %s", mname, err.Error())
+		}
+		return sqlResultVal(r), nil
+
+	case "close":
+		if _, err := methodProtoSqlNoArg.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		s.stmt.Close()
+		return pl.NewValNull(), nil
+
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s's method %s is unknown", s.Id(), name)
+}
+
+func (s *SqlStmt) Info() string {
+	return s.Id()
+}
+
+func (s *SqlStmt) Id() string {
+	return SqlStmtTypeId
+}
+
+func (s *SqlStmt) IsThreadSafe() bool {
+	return true
+}
+
+func (s *SqlStmt) NewIterator() (pl.Iter, error) {
+	return nil, fmt.Errorf("%s does not support iterator", s.Id())
+}
+
+// sql::open(driver, dsn, [option]), the connection is verified by ping so a
+// wrong dsn fails at config time instead of the first query
+func fnSqlOpen(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	alen, err := info.Check(argument)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	option := &SqlOption{}
+	if alen == 3 {
+		if option, err = NewSqlOptionFromVal(argument[2]); err != nil {
+			return pl.NewValNull(), fmt.Errorf("sql::open invalid option: %s", err.Error())
+		}
+	}
+
+	driver := argument[0].String()
+	db, err := sql.Open(driver, argument[1].String())
+	if err != nil {
+		return pl.NewValNull(), fmt.Errorf("sql::open %s", err.Error())
+	}
+	db.SetMaxOpenConns(option.MaxOpenConns)
+	if option.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(option.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(option.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(option.ConnMaxIdleTime)
+
+	ctx, cancel := sqlContext(option.Timeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return pl.NewValNull(), fmt.Errorf("sql::open %s", err.Error())
+	}
+
+	return pl.NewValUsr(
+		&SqlDB{
+			db:      db,
+			driver:  driver,
+			timeout: option.Timeout,
+		},
+	), nil
+}
+
+// sql::drivers(), names of the linked drivers
+func fnSqlDrivers(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+	o := pl.NewValList()
+	for _, d := range sql.Drivers() {
+		o.AddList(pl.NewValStr(d))
+	}
+	return o, nil
+}
+
+func init() {
+	pl.AddModFunction(
+		"sql",
+		"open",
+		"",
+		"{%s%s}{%s%s%m}",
+		fnSqlOpen,
+		"sql::open(driver, dsn, [option]), opens the database",
+	)
+
+	pl.AddModFunction(
+		"sql",
+		"drivers",
+		"",
+		"%0",
+		fnSqlDrivers,
+		"sql::drivers(), the names of the linked drivers",
+	)
+}
@@ -0,0 +1,253 @@
+//go:build sql
+// +build sql
+
+package hpl
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dianpeng/moons/pl"
+	"github.com/stretchr/testify/assert"
+)
+
+// fake driver backed by an in-memory table per dsn. "insert" appends its
+// arguments as a row of (id, name), "select" returns all the rows and "types"
+// returns one row of each column type. The dsn "down" fails to connect
+
+type fakeDriver struct {
+	sync.Mutex
+	table map[string][][]driver.Value
+}
+
+var fakeSql = &fakeDriver{table: map[string][][]driver.Value{}}
+
+func init() {
+	sql.Register("fake", fakeSql)
+}
+
+func (d *fakeDriver) Open(dsn string) (driver.Conn, error) {
+	if dsn == "down" {
+		return nil, fmt.Errorf("connection refused")
+	}
+	return &fakeConn{d: d, dsn: dsn}, nil
+}
+
+func (d *fakeDriver) rows(dsn string) [][]driver.Value {
+	d.Lock()
+	defer d.Unlock()
+	return append([][]driver.Value(nil), d.table[dsn]...)
+}
+
+type fakeConn struct {
+	d   *fakeDriver
+	dsn string
+
+	// rows inserted by the ongoing transaction
+	tx [][]driver.Value
+	in bool
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	switch query {
+	case "insert", "select", "types":
+		return &fakeStmt{c: c, query: query}, nil
+	default:
+		return nil, fmt.Errorf("syntax error: %s", query)
+	}
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.in = true
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.d.Lock()
+	c.d.table[c.dsn] = append(c.d.table[c.dsn], c.tx...)
+	c.d.Unlock()
+	c.tx, c.in = nil, false
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.tx, c.in = nil, false
+	return nil
+}
+
+type fakeStmt struct {
+	c     *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	if s.query == "insert" {
+		return 2
+	}
+	return 0
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if s.query != "insert" {
+		return nil, fmt.Errorf("%s is not an exec", s.query)
+	}
+	row := append([]driver.Value(nil), args...)
+	if s.c.in {
+		s.c.tx = append(s.c.tx, row)
+		return fakeResult(len(s.c.tx)), nil
+	}
+	d := s.c.d
+	d.Lock()
+	defer d.Unlock()
+	d.table[s.c.dsn] = append(d.table[s.c.dsn], row)
+	return fakeResult(len(d.table[s.c.dsn])), nil
+}
+
+func (s *fakeStmt) Query(_ []driver.Value) (driver.Rows, error) {
+	switch s.query {
+	case "select":
+		return &fakeRows{
+			columns: []string{"id", "name"},
+			rows:    s.c.d.rows(s.c.dsn),
+		}, nil
+	case "types":
+		return &fakeRows{
+			columns: []string{"int", "real", "bool", "bytes", "time", "none"},
+			rows: [][]driver.Value{
+				{int64(1), 1.5, true, []byte("b"), time.Unix(0, 0).UTC(), nil},
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("%s is not a query", s.query)
+	}
+}
+
+// the last insert id is the number of rows
+type fakeResult int64
+
+func (r fakeResult) LastInsertId() (int64, error) {
+	return int64(r), nil
+}
+
+func (r fakeResult) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func runSqlTest(code string) error {
+	module, err := pl.CompileModule(code, nil)
+	if err != nil {
+		return err
+	}
+	eval := pl.NewEvaluatorSimple()
+	if err := eval.EvalSession(module); err != nil {
+		return err
+	}
+	_, err = eval.Eval("test", module)
+	return err
+}
+
+func TestSql(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(runSqlTest(`
+test {
+  assert::yes(str::contains(str::join(sql::drivers(), ","), "fake"));
+
+  let db = sql::open("fake", "t1", {"max_open_conns": 2, "timeout": 1000});
+  assert::eq(db.driver, "fake");
+
+  assert::eq(db:query("select"), []);
+  assert::eq(db:query_row("select"), null);
+
+  let r = db:exec("insert", 1, "a");
+  assert::eq(r.rows_affected, 1);
+  assert::eq(r.last_insert_id, 1);
+
+  let stmt = db:prepare("insert");
+  stmt:exec(2, "b");
+  stmt:close();
+
+  assert::eq(db:query("select"), [{"id": 1, "name": "a"}, {"id": 2, "name": "b"}]);
+  assert::eq(db:query_row("select"), {"id": 1, "name": "a"});
+
+  let row = db:query_row("types");
+  assert::eq(row.int, 1);
+  assert::eq(row.real, 1.5);
+  assert::eq(row.bool, true);
+  assert::eq(row.bytes, "b");
+  assert::eq(row.time, "1970-01-01T00:00:00Z");
+  assert::eq(row.none, null);
+
+  db:close();
+}
+`))
+
+	// the transaction is only visible once committed
+	assert.Nil(runSqlTest(`
+test {
+  let db = sql::open("fake", "t2", {"max_open_conns": 1});
+
+  let tx = db:begin();
+  tx:exec("insert", 1, "a");
+  tx:rollback();
+
+  tx = db:begin();
+  tx:exec("insert", 2, "b");
+  tx:commit();
+
+  db:close();
+}
+`))
+	rows := fakeSql.rows("t2")
+	assert.Equal(1, len(rows))
+	assert.Equal(int64(2), rows[0][0])
+}
+
+func TestSqlError(t *testing.T) {
+	for _, x := range []string{
+		`test { sql::open("nodriver", "t"); }`,
+		`test { sql::open("fake", "down"); }`,
+		`test { sql::open("fake", "t", {"pool": 1}); }`,
+		`test { sql::open("fake", "t"):query("drop"); }`,
+		`test { sql::open("fake", "t"):exec("select"); }`,
+		`test { sql::open("fake", "t"):exec("insert", [1], "a"); }`,
+		`test { sql::open("fake", "t"):unknown(); }`,
+	} {
+		assert.NotNil(t, runSqlTest(x), x)
+	}
+}
@@ -0,0 +1,709 @@
+package hpl
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dianpeng/moons/pl"
+)
+
+// Server-Sent Events, the writer frames the events on top of a streamed
+// response body and the reader parses the events out of a readable stream,
+// ie the body of an upstream response
+
+// one event of the text/event-stream, Retry is in millisecond and zero means
+// not set
+type SSEEvent struct {
+	Id    string
+	Event string
+	Data  string
+	Retry int64
+}
+
+func sseField(b *strings.Builder, name string, value string) {
+	b.WriteString(name)
+	b.WriteString(": ")
+	b.WriteString(value)
+	b.WriteString("\n")
+}
+
+// encodes the event, multiline data is split into several data fields. The
+// id and event are written in one line, so newline is not allowed in them
+func (e *SSEEvent) Encode() ([]byte, error) {
+	if strings.ContainsAny(e.Id, "\r\n") || strings.ContainsAny(e.Event, "\r\n") {
+		return nil, fmt.Errorf("sse event id and event name cannot contain newline")
+	}
+
+	b := strings.Builder{}
+	if e.Id != "" {
+		sseField(&b, "id", e.Id)
+	}
+	if e.Event != "" {
+		sseField(&b, "event", e.Event)
+	}
+	if e.Retry > 0 {
+		sseField(&b, "retry", strconv.FormatInt(e.Retry, 10))
+	}
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		sseField(&b, "data", line)
+	}
+	b.WriteString("\n")
+	return []byte(b.String()), nil
+}
+
+func (e *SSEEvent) toVal() pl.Val {
+	o := pl.NewValMap()
+	o.AddMap("id", pl.NewValStr(e.Id))
+	o.AddMap("event", pl.NewValStr(e.Event))
+	o.AddMap("data", pl.NewValStr(e.Data))
+	if e.Retry > 0 {
+		o.AddMap("retry", pl.NewValInt64(e.Retry))
+	} else {
+		o.AddMap("retry", pl.NewValNull())
+	}
+	return o
+}
+
+// writer side -----------------------------------------------------------------
+
+// SSEWriter writes events to the underlying stream. By default each event is
+// flushed once written, and the keep alive comment is sent periodically from
+// background when enabled, so the writer is guarded by a lock
+type SSEWriter struct {
+	sync.Mutex
+	w         io.WriteCloser
+	autoFlush bool
+	closed    bool
+	sent      int64
+	err       error
+
+	keepAliveStop chan struct{}
+}
+
+func ValIsSSEWriter(v pl.Val) bool {
+	return v.Id() == SSEWriterTypeId
+}
+
+func NewSSEWriter(w io.WriteCloser) *SSEWriter {
+	return &SSEWriter{
+		w:         w,
+		autoFlush: true,
+	}
+}
+
+func NewSSEWriterVal(w io.WriteCloser) pl.Val {
+	return pl.NewValUsr(NewSSEWriter(w))
+}
+
+// sets the headers required by the event stream on the response header
+func SetSSEHeader(h http.Header) {
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	h.Del("Content-Length")
+}
+
+func (s *SSEWriter) flush() error {
+	switch x := s.w.(type) {
+	case interface{ Flush() error }:
+		return x.Flush()
+	case http.Flusher:
+		x.Flush()
+	}
+	return nil
+}
+
+func (s *SSEWriter) write(b []byte, flush bool) error {
+	if s.closed {
+		return fmt.Errorf("%s is closed", SSEWriterTypeId)
+	}
+	if s.err != nil {
+		return s.err
+	}
+	if _, err := s.w.Write(b); err != nil {
+		s.err = err
+		return err
+	}
+	if flush {
+		if err := s.flush(); err != nil {
+			s.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SSEWriter) Send(e *SSEEvent) error {
+	b, err := e.Encode()
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err := s.write(b, s.autoFlush); err != nil {
+		return err
+	}
+	s.sent++
+	return nil
+}
+
+// writes a comment line, which is ignored by the client
+func (s *SSEWriter) Comment(text string) error {
+	b := strings.Builder{}
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		b.WriteString(": ")
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	s.Lock()
+	defer s.Unlock()
+	return s.write([]byte(b.String()), s.autoFlush)
+}
+
+func (s *SSEWriter) Flush() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return fmt.Errorf("%s is closed", SSEWriterTypeId)
+	}
+	return s.flush()
+}
+
+func (s *SSEWriter) SetAutoFlush(v bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.autoFlush = v
+}
+
+// sends the keep alive comment every interval until the writer is closed or
+// the write fails, zero interval stops the keep alive
+func (s *SSEWriter) KeepAlive(interval time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.keepAliveStop != nil {
+		close(s.keepAliveStop)
+		s.keepAliveStop = nil
+	}
+	if interval <= 0 || s.closed {
+		return
+	}
+
+	stop := make(chan struct{})
+	s.keepAliveStop = stop
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				s.Lock()
+				select {
+				case <-stop:
+					s.Unlock()
+					return
+				default:
+					break
+				}
+				err := s.write([]byte(": keep-alive\n\n"), true)
+				s.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+func (s *SSEWriter) IsClose() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.closed
+}
+
+// stops the keep alive and closes the underlying stream, the pending data is
+// flushed
+func (s *SSEWriter) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return nil
+	}
+	if s.keepAliveStop != nil {
+		close(s.keepAliveStop)
+		s.keepAliveStop = nil
+	}
+	if s.err == nil {
+		s.flush()
+	}
+	s.closed = true
+	return s.w.Close()
+}
+
+func (s *SSEWriter) Index(name pl.Val) (pl.Val, error) {
+	if name.IsString() {
+		return s.Dot(name.String())
+	}
+	return pl.NewValNull(), fmt.Errorf("%s: invalid index", s.Id())
+}
+
+func (s *SSEWriter) IndexSet(_ pl.Val, _ pl.Val) error {
+	return fmt.Errorf("%s does not support index set", s.Id())
+}
+
+func (s *SSEWriter) Dot(name string) (pl.Val, error) {
+	switch name {
+	case "close":
+		return pl.NewValBool(s.IsClose()), nil
+	case "eventSent":
+		s.Lock()
+		defer s.Unlock()
+		return pl.NewValInt64(s.sent), nil
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s: unknown field name %s", s.Id(), name)
+}
+
+func (s *SSEWriter) DotSet(_ string, _ pl.Val) error {
+	return fmt.Errorf("%s does not support dot set", s.Id())
+}
+
+func (s *SSEWriter) ToString() (string, error) {
+	return s.Info(), nil
+}
+
+func (s *SSEWriter) ToJSON() (pl.Val, error) {
+	s.Lock()
+	defer s.Unlock()
+	return pl.MarshalVal(
+		map[string]interface{}{
+			"type":      SSEWriterTypeId,
+			"eventSent": s.sent,
+			"close":     s.closed,
+		},
+	)
+}
+
+var (
+	methodProtoSSEWriterSend         = pl.MustNewFuncProto(".ssewriter.send", "{%a:data}{%a:data%s:event}{%a:data%s:event%s:id}")
+	methodProtoSSEWriterComment      = pl.MustNewFuncProto(".ssewriter.comment", "%s")
+	methodProtoSSEWriterRetry        = pl.MustNewFuncProto(".ssewriter.retry", "%d:millisecond")
+	methodProtoSSEWriterKeepAlive    = pl.MustNewFuncProto(".ssewriter.keepAlive", "%d:millisecond")
+	methodProtoSSEWriterSetAutoFlush = pl.MustNewFuncProto(".ssewriter.setAutoFlush", "%b")
+	methodProtoSSEWriterFlush        = pl.MustNewFuncProto(".ssewriter.flush", "%0")
+	methodProtoSSEWriterClose        = pl.MustNewFuncProto(".ssewriter.close", "%0")
+)
+
+// event from the map {id, event, data, retry}, data which is not string is
+// converted to string
+func newSSEEventFromVal(v pl.Val) (*SSEEvent, error) {
+	e := &SSEEvent{}
+	var err error
+
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "id":
+				e.Id, err = val.ToString()
+			case "event":
+				e.Event, err = val.ToString()
+			case "data":
+				e.Data, err = val.ToString()
+			case "retry":
+				if !val.IsInt() || val.Int() < 0 {
+					err = fmt.Errorf("retry must be non negative int")
+				} else {
+					e.Retry = val.Int()
+				}
+			default:
+				err = fmt.Errorf("field %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (s *SSEWriter) Method(name string, args []pl.Val) (pl.Val, error) {
+	switch name {
+	case "send":
+		alen, err := methodProtoSSEWriterSend.Check(args)
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		var e *SSEEvent
+		if alen == 1 && args[0].IsMap() {
+			if e, err = newSSEEventFromVal(args[0]); err != nil {
+				return pl.NewValNull(), fmt.Errorf("%s:send, %s", s.Id(), err.Error())
+			}
+		} else {
+			e = &SSEEvent{}
+			if e.Data, err = args[0].ToString(); err != nil {
+				return pl.NewValNull(), err
+			}
+			if alen >= 2 {
+				e.Event = args[1].String()
+			}
+			if alen == 3 {
+				e.Id = args[2].String()
+			}
+		}
+		return pl.NewValNull(), s.Send(e)
+
+	case "comment":
+		if _, err := methodProtoSSEWriterComment.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		return pl.NewValNull(), s.Comment(args[0].String())
+
+	// sends only the retry field, which changes the reconnection delay of the
+	// client
+	case "retry":
+		if _, err := methodProtoSSEWriterRetry.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		if args[0].Int() <= 0 {
+			return pl.NewValNull(), fmt.Errorf("%s:retry, retry must be positive", s.Id())
+		}
+		s.Lock()
+		defer s.Unlock()
+		return pl.NewValNull(), s.write([]byte(fmt.Sprintf("retry: %d\n\n", args[0].Int())), s.autoFlush)
+
+	case "keepAlive":
+		if _, err := methodProtoSSEWriterKeepAlive.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		s.KeepAlive(time.Duration(args[0].Int()) * time.Millisecond)
+		return pl.NewValNull(), nil
+
+	case "setAutoFlush":
+		if _, err := methodProtoSSEWriterSetAutoFlush.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		s.SetAutoFlush(args[0].Bool())
+		return pl.NewValNull(), nil
+
+	case "flush":
+		if _, err := methodProtoSSEWriterFlush.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		return pl.NewValNull(), s.Flush()
+
+	case "close":
+		if _, err := methodProtoSSEWriterClose.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		return pl.NewValNull(), s.Close()
+
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s's method %s is unknown", s.Id(), name)
+}
+
+func (s *SSEWriter) Info() string {
+	s.Lock()
+	defer s.Unlock()
+	return fmt.Sprintf("%s[sent=%d;close=%t]", SSEWriterTypeId, s.sent, s.closed)
+}
+
+func (s *SSEWriter) Id() string {
+	return SSEWriterTypeId
+}
+
+func (s *SSEWriter) IsThreadSafe() bool {
+	return false
+}
+
+func (s *SSEWriter) NewIterator() (pl.Iter, error) {
+	return nil, fmt.Errorf("%s does not support iterator", s.Id())
+}
+
+// reader side -----------------------------------------------------------------
+
+// SSEReader parses the events out of the stream following the event stream
+// interpretation of the HTML spec. The event without data is not dispatched,
+// and the event name defaults to "message"
+type SSEReader struct {
+	stream      *ReadableStream
+	lastEventId string
+	retry       int64
+	eof         bool
+}
+
+func ValIsSSEReader(v pl.Val) bool {
+	return v.Id() == SSEReaderTypeId
+}
+
+func NewSSEReader(stream *ReadableStream) *SSEReader {
+	return &SSEReader{
+		stream: stream,
+	}
+}
+
+func NewSSEReaderVal(stream *ReadableStream) pl.Val {
+	return pl.NewValUsr(NewSSEReader(stream))
+}
+
+// returns the next event, io.EOF when the stream is drained. The incomplete
+// event at the end of the stream is discarded
+func (r *SSEReader) Next() (*SSEEvent, error) {
+	if r.eof {
+		return nil, io.EOF
+	}
+
+	data := strings.Builder{}
+	hasData := false
+	event := ""
+
+	for {
+		line, err := r.stream.ReadLine()
+		if err == io.EOF {
+			r.eof = true
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// dispatch
+		if line == "" {
+			if !hasData {
+				event = ""
+				continue
+			}
+			if event == "" {
+				event = "message"
+			}
+			return &SSEEvent{
+				Id:    r.lastEventId,
+				Event: event,
+				Data:  data.String(),
+				Retry: r.retry,
+			}, nil
+		}
+
+		// comment
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value := line, ""
+		if idx := strings.IndexByte(line, ':'); idx >= 0 {
+			field = line[:idx]
+			value = strings.TrimPrefix(line[idx+1:], " ")
+		}
+
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			if hasData {
+				data.WriteString("\n")
+			}
+			data.WriteString(value)
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				r.lastEventId = value
+			}
+		case "retry":
+			if v, err := strconv.ParseInt(value, 10, 64); err == nil && v >= 0 {
+				r.retry = v
+			}
+		default:
+			break
+		}
+	}
+}
+
+func (r *SSEReader) Index(name pl.Val) (pl.Val, error) {
+	if name.IsString() {
+		return r.Dot(name.String())
+	}
+	return pl.NewValNull(), fmt.Errorf("%s: invalid index", r.Id())
+}
+
+func (r *SSEReader) IndexSet(_ pl.Val, _ pl.Val) error {
+	return fmt.Errorf("%s does not support index set", r.Id())
+}
+
+func (r *SSEReader) Dot(name string) (pl.Val, error) {
+	switch name {
+	case "lastEventId":
+		return pl.NewValStr(r.lastEventId), nil
+	case "retry":
+		if r.retry > 0 {
+			return pl.NewValInt64(r.retry), nil
+		}
+		return pl.NewValNull(), nil
+	case "eof":
+		return pl.NewValBool(r.eof), nil
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s: unknown field name %s", r.Id(), name)
+}
+
+func (r *SSEReader) DotSet(_ string, _ pl.Val) error {
+	return fmt.Errorf("%s does not support dot set", r.Id())
+}
+
+func (r *SSEReader) ToString() (string, error) {
+	return r.Info(), nil
+}
+
+func (r *SSEReader) ToJSON() (pl.Val, error) {
+	return pl.MarshalVal(
+		map[string]interface{}{
+			"type":        SSEReaderTypeId,
+			"lastEventId": r.lastEventId,
+			"eof":         r.eof,
+		},
+	)
+}
+
+var (
+	methodProtoSSEReaderNext  = pl.MustNewFuncProto(".ssereader.next", "%0")
+	methodProtoSSEReaderClose = pl.MustNewFuncProto(".ssereader.close", "%0")
+)
+
+func (r *SSEReader) Method(name string, args []pl.Val) (pl.Val, error) {
+	switch name {
+	// returns the next event as map {id, event, data, retry}, null when the
+	// stream is drained
+	case "next":
+		if _, err := methodProtoSSEReaderNext.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		e, err := r.Next()
+		if err == io.EOF {
+			return pl.NewValNull(), nil
+		}
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		return e.toVal(), nil
+
+	case "close":
+		if _, err := methodProtoSSEReaderClose.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		r.eof = true
+		return pl.NewValNull(), r.stream.Close()
+
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s's method %s is unknown", r.Id(), name)
+}
+
+func (r *SSEReader) Info() string {
+	return fmt.Sprintf("%s[lastEventId=%s;eof=%t]", SSEReaderTypeId, r.lastEventId, r.eof)
+}
+
+func (r *SSEReader) Id() string {
+	return SSEReaderTypeId
+}
+
+func (r *SSEReader) IsThreadSafe() bool {
+	return false
+}
+
+// iterates the events until the stream is drained, the index is the sequence
+// number of the event
+func (r *SSEReader) NewIterator() (pl.Iter, error) {
+	x := &sseReaderIter{
+		reader: r,
+		idx:    -1,
+	}
+	if _, err := x.Next(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type sseReaderIter struct {
+	reader *SSEReader
+	idx    int
+	event  *SSEEvent
+	done   bool
+}
+
+func (x *sseReaderIter) SetUp(_ *pl.Evaluator, _ []pl.Val) error {
+	return nil
+}
+
+func (x *sseReaderIter) Has() bool {
+	return !x.done
+}
+
+func (x *sseReaderIter) Next() (bool, error) {
+	if x.done {
+		return false, nil
+	}
+	e, err := x.reader.Next()
+	if err != nil {
+		x.done = true
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	x.idx++
+	x.event = e
+	return true, nil
+}
+
+func (x *sseReaderIter) Deref() (pl.Val, pl.Val, error) {
+	if x.done {
+		return pl.NewValNull(), pl.NewValNull(), fmt.Errorf("iterator out of bound")
+	}
+	return pl.NewValInt(x.idx), x.event.toVal(), nil
+}
+
+// http::new_sse_reader accepts .readablestream, http.body, http.response or
+// string
+func fnNewSSEReader(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	if _, err := info.Check(argument); err != nil {
+		return pl.NewValNull(), err
+	}
+
+	v := argument[0]
+	if ValIsHttpResponse(v) {
+		b, err := v.Dot("body")
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		v = b
+	}
+
+	switch {
+	case v.IsString():
+		return NewSSEReaderVal(NewReadableStreamFromString(v.String())), nil
+	case ValIsReadableStream(v):
+		s, _ := v.Usr().(*ReadableStream)
+		return NewSSEReaderVal(s), nil
+	case ValIsHttpBody(v):
+		b, _ := v.Usr().(*Body)
+		return NewSSEReaderVal(b.Stream()), nil
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("http::new_sse_reader expects .readablestream, http.body, http.response or string")
+}
@@ -1,6 +1,7 @@
 package hpl
 
 import (
+	"bufio"
 	"io"
 	"strings"
 )
@@ -50,6 +51,23 @@ func (e *eofByteReadCloser) Close() error {
 	return nil
 }
 
+// buffered reader which still closes the underlying stream
+type bufReadCloser struct {
+	*bufio.Reader
+	c io.Closer
+}
+
+func newbufReadCloser(x io.ReadCloser) *bufReadCloser {
+	return &bufReadCloser{
+		Reader: bufio.NewReader(x),
+		c:      x,
+	}
+}
+
+func (b *bufReadCloser) Close() error {
+	return b.c.Close()
+}
+
 func NewEofReadCloser() io.ReadCloser {
 	return &eofReadCloser{}
 }
@@ -0,0 +1,303 @@
+package hpl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/dianpeng/moons/pl"
+	"io"
+	"time"
+)
+
+// Stream transformation. A transform wraps the source stream into a new
+// stream which applies the transformation lazily while been read, so the
+// content is never fully buffered. The builtin transforms are:
+//
+//	gzip [level], compress the stream
+//	gunzip, decompress the stream
+//	compress codec [level], compress the stream with registered codec, see
+//	  pl.RegisterCodec
+//	decompress codec, decompress the stream with registered codec
+//	replace pattern replacement, sed like line based replacement, the pattern
+//	  is either a literal string or a regexp
+//	throttle bytes_per_second, limits the read speed of the stream
+//
+// Otherwise the transform is a closure called with each chunk and returns the
+// transformed chunk, null means nothing to output for the chunk
+type streamTransformFactory func(*pl.Evaluator, io.Reader, []pl.Val) (io.Reader, error)
+
+var streamTransform = map[string]streamTransformFactory{
+	"gzip":       newGzipTransform,
+	"gunzip":     newGunzipTransform,
+	"compress":   newCompressTransform,
+	"decompress": newDecompressTransform,
+	"replace":    newReplaceTransform,
+	"throttle":   newThrottleTransform,
+}
+
+// chunked transformation, the output of each input chunk is buffered until it
+// has been drained by the reader
+type chunkTransform func(chunk []byte, eof bool) ([]byte, error)
+
+type chunkTransformReader struct {
+	src  io.Reader
+	fn   chunkTransform
+	buf  []byte
+	out  []byte
+	done bool
+}
+
+func newChunkTransformReader(src io.Reader, fn chunkTransform) *chunkTransformReader {
+	return &chunkTransformReader{
+		src: src,
+		fn:  fn,
+		buf: make([]byte, readableStreamChunkSize),
+	}
+}
+
+func (c *chunkTransformReader) Read(p []byte) (int, error) {
+	for len(c.out) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		n, err := c.src.Read(c.buf)
+		if n > 0 {
+			out, terr := c.fn(c.buf[:n], false)
+			if terr != nil {
+				return 0, terr
+			}
+			c.out = out
+		}
+		if err == io.EOF {
+			out, terr := c.fn(nil, true)
+			if terr != nil {
+				return 0, terr
+			}
+			c.out = append(c.out, out...)
+			c.done = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+func codecLevel(args []pl.Val, name string) (int, error) {
+	if len(args) == 0 {
+		return -1, nil
+	}
+	if len(args) != 1 || !args[0].IsInt() {
+		return 0, fmt.Errorf("%s transform level must be int", name)
+	}
+	return int(args[0].Int()), nil
+}
+
+func newGzipTransform(_ *pl.Evaluator, src io.Reader, args []pl.Val) (io.Reader, error) {
+	level, err := codecLevel(args, "gzip")
+	if err != nil {
+		return nil, err
+	}
+	return pl.NewCompressReader("gzip", src, level)
+}
+
+func newGunzipTransform(_ *pl.Evaluator, src io.Reader, args []pl.Val) (io.Reader, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("gunzip transform does not take argument")
+	}
+	return pl.NewDecompressReader("gzip", src)
+}
+
+func newCompressTransform(_ *pl.Evaluator, src io.Reader, args []pl.Val) (io.Reader, error) {
+	if len(args) == 0 || !args[0].IsString() {
+		return nil, fmt.Errorf("compress transform expects codec name")
+	}
+	level, err := codecLevel(args[1:], "compress")
+	if err != nil {
+		return nil, err
+	}
+	return pl.NewCompressReader(args[0].String(), src, level)
+}
+
+func newDecompressTransform(_ *pl.Evaluator, src io.Reader, args []pl.Val) (io.Reader, error) {
+	if len(args) != 1 || !args[0].IsString() {
+		return nil, fmt.Errorf("decompress transform expects codec name")
+	}
+	return pl.NewDecompressReader(args[0].String(), src)
+}
+
+func newReplaceTransform(_ *pl.Evaluator, src io.Reader, args []pl.Val) (io.Reader, error) {
+	if len(args) != 2 || !args[1].IsString() {
+		return nil, fmt.Errorf("replace transform expects pattern and replacement string")
+	}
+	repl := []byte(args[1].String())
+
+	var fn func([]byte) []byte
+	switch {
+	case args[0].IsRegexp():
+		re := args[0].Regexp()
+		fn = func(line []byte) []byte {
+			return re.ReplaceAll(line, repl)
+		}
+	case args[0].IsString():
+		pattern := []byte(args[0].String())
+		if len(pattern) == 0 {
+			return nil, fmt.Errorf("replace transform pattern cannot be empty")
+		}
+		fn = func(line []byte) []byte {
+			return bytes.ReplaceAll(line, pattern, repl)
+		}
+	default:
+		return nil, fmt.Errorf("replace transform pattern must be string or regexp")
+	}
+
+	return &lineTransformReader{
+		br: bufio.NewReader(src),
+		fn: fn,
+	}, nil
+}
+
+// line based reader used by replace transform, the transformation is applied
+// to each line including the trailing newline
+type lineTransformReader struct {
+	br   *bufio.Reader
+	fn   func([]byte) []byte
+	out  []byte
+	done bool
+}
+
+func (l *lineTransformReader) Read(p []byte) (int, error) {
+	for len(l.out) == 0 {
+		if l.done {
+			return 0, io.EOF
+		}
+		line, err := l.br.ReadBytes('\n')
+		if err == io.EOF {
+			l.done = true
+		} else if err != nil {
+			return 0, err
+		}
+		if len(line) > 0 {
+			l.out = l.fn(line)
+		}
+	}
+	n := copy(p, l.out)
+	l.out = l.out[n:]
+	return n, nil
+}
+
+type throttleReader struct {
+	src   io.Reader
+	rate  int64
+	start time.Time
+	total int64
+}
+
+func (t *throttleReader) Read(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	if int64(len(p)) > t.rate {
+		p = p[:t.rate]
+	}
+	n, err := t.src.Read(p)
+	t.total += int64(n)
+
+	// sleep until the average rate is below the limit
+	expect := time.Duration(t.total * int64(time.Second) / t.rate)
+	if d := expect - time.Since(t.start); d > 0 {
+		time.Sleep(d)
+	}
+	return n, err
+}
+
+func newThrottleTransform(_ *pl.Evaluator, src io.Reader, args []pl.Val) (io.Reader, error) {
+	if len(args) != 1 || !args[0].IsInt() || args[0].Int() <= 0 {
+		return nil, fmt.Errorf("throttle transform expects positive bytes per second")
+	}
+	return &throttleReader{
+		src:  src,
+		rate: args[0].Int(),
+	}, nil
+}
+
+func newClosureTransform(e *pl.Evaluator, src io.Reader, fn pl.Closure) io.Reader {
+	return newChunkTransformReader(src, func(chunk []byte, eof bool) ([]byte, error) {
+		if eof {
+			return nil, nil
+		}
+		v, err := fn.Call(e, []pl.Val{pl.NewValStr(string(chunk))})
+		if err != nil {
+			return nil, err
+		}
+		if v.IsNull() {
+			return nil, nil
+		}
+		str, err := v.ToString()
+		if err != nil {
+			return nil, fmt.Errorf("stream transform closure returns invalid chunk: %s", err.Error())
+		}
+		return []byte(str), nil
+	})
+}
+
+// transformed stream, closing it closes the source stream
+type transformReadCloser struct {
+	io.Reader
+	c io.Closer
+}
+
+func (t *transformReadCloser) Close() error {
+	return t.c.Close()
+}
+
+// creates a new stream with the transformation applied to the content of the
+// stream, the stream itself should not be used after
+func (h *ReadableStream) Transform(e *pl.Evaluator, args []pl.Val) (*ReadableStream, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf(".readablestream:transform expects transform")
+	}
+
+	var r io.Reader
+	switch {
+	case args[0].IsClosure():
+		if e == nil {
+			return nil, fmt.Errorf(".readablestream:transform closure requires evaluator")
+		}
+		if len(args) != 1 {
+			return nil, fmt.Errorf(".readablestream:transform closure does not take argument")
+		}
+		r = newClosureTransform(e, h.Stream, args[0].Closure())
+
+	case args[0].IsString():
+		fac, ok := streamTransform[args[0].String()]
+		if !ok {
+			return nil, fmt.Errorf(".readablestream:transform unknown transform %s", args[0].String())
+		}
+		x, err := fac(e, h.Stream, args[1:])
+		if err != nil {
+			return nil, fmt.Errorf(".readablestream:transform %s", err.Error())
+		}
+		r = x
+
+	default:
+		return nil, fmt.Errorf(".readablestream:transform expects transform name or closure")
+	}
+
+	return NewReadableStreamFromStream(&transformReadCloser{
+		Reader: r,
+		c:      h.Stream,
+	}), nil
+}
+
+// copies the whole content of the stream into the writable stream and closes
+// the stream, the writable stream is left open
+func (h *ReadableStream) Pipe(dst *WritableStream) (int64, error) {
+	defer h.Close()
+	if h.hasCache {
+		n, err := dst.Write(h.cacheBuf)
+		return int64(n), err
+	}
+	return io.Copy(dst, h.Stream)
+}
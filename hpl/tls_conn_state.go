@@ -1,7 +1,10 @@
 package hpl
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
 	"github.com/dianpeng/moons/pl"
 )
@@ -32,6 +35,62 @@ func (c *tlsConnState) CipherSuiteString() string {
 	return tls.CipherSuiteName(c.state.CipherSuite)
 }
 
+// whether the peer certificate is verified against the client CA
+func (c *tlsConnState) verified() bool {
+	return len(c.state.VerifiedChains) != 0
+}
+
+func strListVal(x []string) pl.Val {
+	o := pl.NewValList()
+	for _, v := range x {
+		o.AddList(pl.NewValStr(v))
+	}
+	return o
+}
+
+// certificate of the peer as map, the time is in unix seconds and the
+// fingerprint is the hex of sha256 of the DER encoding
+func newCertificateVal(cert *x509.Certificate) pl.Val {
+	sum := sha256.Sum256(cert.Raw)
+	ip := []string{}
+	for _, x := range cert.IPAddresses {
+		ip = append(ip, x.String())
+	}
+	uri := []string{}
+	for _, x := range cert.URIs {
+		uri = append(uri, x.String())
+	}
+
+	o := pl.NewValMap()
+	o.AddMap("subject", pl.NewValStr(cert.Subject.String()))
+	o.AddMap("commonName", pl.NewValStr(cert.Subject.CommonName))
+	o.AddMap("issuer", pl.NewValStr(cert.Issuer.String()))
+	o.AddMap("serialNumber", pl.NewValStr(fmt.Sprintf("%X", cert.SerialNumber)))
+	o.AddMap("notBefore", pl.NewValInt64(cert.NotBefore.Unix()))
+	o.AddMap("notAfter", pl.NewValInt64(cert.NotAfter.Unix()))
+	o.AddMap("dnsNames", strListVal(cert.DNSNames))
+	o.AddMap("emailAddresses", strListVal(cert.EmailAddresses))
+	o.AddMap("ipAddresses", strListVal(ip))
+	o.AddMap("uris", strListVal(uri))
+	o.AddMap("fingerprint", pl.NewValStr(hex.EncodeToString(sum[:])))
+	return o
+}
+
+func (c *tlsConnState) peerCertificate() pl.Val {
+	if len(c.state.PeerCertificates) == 0 {
+		return pl.NewValNull()
+	}
+	return newCertificateVal(c.state.PeerCertificates[0])
+}
+
+func (c *tlsConnState) peerCertificates() pl.Val {
+	o := pl.NewValList()
+	for _, x := range c.state.PeerCertificates {
+		o.AddList(newCertificateVal(x))
+	}
+	return o
+}
+
 func (c *tlsConnState) ConnectionState() *tls.ConnectionState {
 	return c.state
 }
@@ -55,6 +114,14 @@ func (c *tlsConnState) Dot(key string) (pl.Val, error) {
 		return pl.NewValStr(c.CipherSuiteString()), nil
 	case "negotiatedProtocol":
 		return pl.NewValStr(c.state.NegotiatedProtocol), nil
+	case "didResume":
+		return pl.NewValBool(c.state.DidResume), nil
+	case "verified":
+		return pl.NewValBool(c.verified()), nil
+	case "peerCertificate":
+		return c.peerCertificate(), nil
+	case "peerCertificates":
+		return c.peerCertificates(), nil
 
 	default:
 		return pl.NewValNull(), nil
@@ -74,14 +141,21 @@ func (c *tlsConnState) ToString() (string, error) {
 }
 
 func (c *tlsConnState) ToJSON() (pl.Val, error) {
-	return pl.MarshalVal(
+	o, err := pl.MarshalVal(
 		map[string]interface{}{
 			"version":            c.VersionString(),
 			"cipherSuit":         c.CipherSuiteString(),
 			"negotiatedProtocol": c.state.NegotiatedProtocol,
 			"serverName":         c.state.ServerName,
+			"didResume":          c.state.DidResume,
+			"verified":           c.verified(),
 		},
 	)
+	if err != nil {
+		return o, err
+	}
+	o.AddMap("peerCertificate", c.peerCertificate())
+	return o, nil
 }
 
 func (c *tlsConnState) Method(name string, _ []pl.Val) (pl.Val, error) {
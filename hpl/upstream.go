@@ -0,0 +1,162 @@
+package hpl
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dianpeng/moons/balancer"
+	"github.com/dianpeng/moons/health"
+	"github.com/dianpeng/moons/pl"
+)
+
+// Upstream is the upstream group of the http client, the calls are balanced
+// among the upstreams and optionally the upstreams are health checked
+type Upstream struct {
+	Balancer    balancer.Balancer
+	HealthCheck *health.Option
+}
+
+// upstream group passed from script as a map, the keys are policy, one of
+// round_robin, weighted, least_conn and ring_hash, servers which is either a
+// list of address or a map of address to weight, and health_check, see
+// NewHealthOptionFromVal
+func NewUpstreamFromVal(v pl.Val) (*Upstream, error) {
+	if !v.IsMap() {
+		return nil, fmt.Errorf("upstream must be map")
+	}
+
+	o := &Upstream{}
+	policy := ""
+	upstream := []balancer.Upstream{}
+	var err error
+
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "policy":
+				err = optionStr(val, key, &policy)
+			case "servers":
+				upstream, err = newUpstreamFromVal(val)
+			case "health_check":
+				o.HealthCheck, err = NewHealthOptionFromVal(val)
+			default:
+				err = fmt.Errorf("upstream option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	o.Balancer, err = balancer.New(policy, upstream)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// health check option passed from script as a map, the durations are in
+// millisecond. The keys are interval, timeout, scheme, path, host, status
+// which is an int or list of int, body, rise and fall
+func NewHealthOptionFromVal(v pl.Val) (*health.Option, error) {
+	if !v.IsMap() {
+		return nil, fmt.Errorf("health_check must be map")
+	}
+
+	o := &health.Option{}
+	var err error
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "interval":
+				err = optionDuration(val, key, &o.Interval)
+			case "timeout":
+				err = optionDuration(val, key, &o.Timeout)
+			case "scheme":
+				err = optionStr(val, key, &o.Scheme)
+			case "path":
+				err = optionStr(val, key, &o.Path)
+			case "host":
+				err = optionStr(val, key, &o.Host)
+			case "status":
+				o.Status, err = healthStatusFromVal(val)
+			case "body":
+				err = optionStr(val, key, &o.Body)
+			case "rise":
+				err = optionInt(val, key, &o.Rise)
+			case "fall":
+				err = optionInt(val, key, &o.Fall)
+			default:
+				err = fmt.Errorf("health_check option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func healthStatusFromVal(v pl.Val) ([]int, error) {
+	list := []pl.Val{v}
+	if v.IsList() {
+		list = v.List().Data
+	}
+	o := []int{}
+	for _, x := range list {
+		if !x.IsInt() {
+			return nil, fmt.Errorf("health_check option status must be int or list of int")
+		}
+		o = append(o, int(x.Int()))
+	}
+	return o, nil
+}
+
+func newUpstreamFromVal(v pl.Val) ([]balancer.Upstream, error) {
+	o := []balancer.Upstream{}
+	switch {
+	case v.IsList():
+		for _, x := range v.List().Data {
+			if !x.IsString() {
+				return nil, fmt.Errorf("upstream servers must be list of string")
+			}
+			o = append(o, balancer.Upstream{Addr: x.String()})
+		}
+
+	case v.IsMap():
+		var err error
+		v.Map().Foreach(
+			func(addr string, x pl.Val) bool {
+				u := balancer.Upstream{Addr: addr}
+				err = optionInt(x, "weight of "+addr, &u.Weight)
+				o = append(o, u)
+				return err == nil
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// the map is not ordered, keeps the order stable across reloading
+		sort.Slice(o, func(i, j int) bool {
+			return o[i].Addr < o[j].Addr
+		})
+
+	default:
+		return nil, fmt.Errorf("upstream servers must be list or map")
+	}
+	return o, nil
+}
+
+// NewHealthEventVal is the context of the upstream.<group>:up and
+// upstream.<group>:down events
+func NewHealthEventVal(e health.Event) pl.Val {
+	o := pl.NewValMap()
+	o.AddMap("group", pl.NewValStr(e.Group))
+	o.AddMap("addr", pl.NewValStr(e.Addr))
+	o.AddMap("healthy", pl.NewValBool(e.Healthy))
+	o.AddMap("reason", pl.NewValStr(e.Reason))
+	return o
+}
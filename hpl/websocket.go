@@ -0,0 +1,361 @@
+package hpl
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/websocket"
+)
+
+// websocket connection, either accepted by the websocket application or
+// connected by http::websocket. Reading returns null once the peer closes the
+// connection, and the close status can be found via closeCode/closeReason
+type WebSocket struct {
+	conn *websocket.Conn
+}
+
+func ValIsWebSocket(v pl.Val) bool {
+	return v.Id() == WebSocketTypeId
+}
+
+func (w *WebSocket) Conn() *websocket.Conn {
+	return w.conn
+}
+
+// reads the next message, returns null when the connection is closed by the
+// peer
+func (w *WebSocket) read() (int, pl.Val, error) {
+	op, data, err := w.conn.ReadMessage()
+	if err != nil {
+		if _, ok := err.(*websocket.CloseError); ok {
+			return 0, pl.NewValNull(), nil
+		}
+		return 0, pl.NewValNull(), err
+	}
+	return op, pl.NewValStr(string(data)), nil
+}
+
+func messageTypeName(op int) string {
+	if op == websocket.BinaryMessage {
+		return "binary"
+	}
+	return "text"
+}
+
+func (w *WebSocket) Index(key pl.Val) (pl.Val, error) {
+	if key.IsString() {
+		return w.Dot(key.String())
+	}
+	return pl.NewValNull(), fmt.Errorf("%s: invalid index", w.Id())
+}
+
+func (w *WebSocket) IndexSet(_ pl.Val, _ pl.Val) error {
+	return fmt.Errorf("%s does not support index set", w.Id())
+}
+
+func (w *WebSocket) Dot(key string) (pl.Val, error) {
+	switch key {
+	case "subprotocol":
+		return pl.NewValStr(w.conn.Subprotocol()), nil
+	case "remoteAddr":
+		return pl.NewValStr(w.conn.RemoteAddr().String()), nil
+	case "localAddr":
+		return pl.NewValStr(w.conn.LocalAddr().String()), nil
+	case "closed":
+		return pl.NewValBool(w.conn.IsClosed()), nil
+	case "closeCode":
+		if s := w.conn.CloseStatus(); s != nil {
+			return pl.NewValInt(s.Code), nil
+		}
+		return pl.NewValNull(), nil
+	case "closeReason":
+		if s := w.conn.CloseStatus(); s != nil {
+			return pl.NewValStr(s.Reason), nil
+		}
+		return pl.NewValNull(), nil
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s: unknown field name %s", w.Id(), key)
+}
+
+func (w *WebSocket) DotSet(_ string, _ pl.Val) error {
+	return fmt.Errorf("%s does not support dot set", w.Id())
+}
+
+func (w *WebSocket) ToString() (string, error) {
+	return w.Info(), nil
+}
+
+func (w *WebSocket) ToJSON() (pl.Val, error) {
+	return pl.MarshalVal(
+		map[string]interface{}{
+			"type":        WebSocketTypeId,
+			"subprotocol": w.conn.Subprotocol(),
+			"remoteAddr":  w.conn.RemoteAddr().String(),
+			"closed":      w.conn.IsClosed(),
+		},
+	)
+}
+
+var (
+	methodProtoWebSocketRead           = pl.MustNewFuncProto(".websocket.read", "%0")
+	methodProtoWebSocketReadMessage    = pl.MustNewFuncProto(".websocket.readMessage", "%0")
+	methodProtoWebSocketWrite          = pl.MustNewFuncProto(".websocket.write", "%s")
+	methodProtoWebSocketWriteBinary    = pl.MustNewFuncProto(".websocket.writeBinary", "%s")
+	methodProtoWebSocketPing           = pl.MustNewFuncProto(".websocket.ping", "{%0}{%s}")
+	methodProtoWebSocketClose          = pl.MustNewFuncProto(".websocket.close", "{%0}{%d:code}{%d:code%s:reason}")
+	methodProtoWebSocketSetReadTimeout = pl.MustNewFuncProto(".websocket.setReadTimeout", "%d:millisecond")
+	methodProtoWebSocketSetMaxSize     = pl.MustNewFuncProto(".websocket.setMaxMessageSize", "%d")
+	methodProtoWebSocketProxy          = pl.MustNewFuncProto(".websocket.proxy", "%U['.websocket']")
+)
+
+func (w *WebSocket) Method(name string, args []pl.Val) (pl.Val, error) {
+	switch name {
+	case "read":
+		if _, err := methodProtoWebSocketRead.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		_, v, err := w.read()
+		return v, err
+
+	case "readMessage":
+		if _, err := methodProtoWebSocketReadMessage.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		op, v, err := w.read()
+		if err != nil || v.IsNull() {
+			return v, err
+		}
+		o := pl.NewValMap()
+		o.AddMap("type", pl.NewValStr(messageTypeName(op)))
+		o.AddMap("data", v)
+		return o, nil
+
+	case "write":
+		if _, err := methodProtoWebSocketWrite.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		return pl.NewValNull(), w.conn.WriteMessage(websocket.TextMessage, []byte(args[0].String()))
+
+	case "writeBinary":
+		if _, err := methodProtoWebSocketWriteBinary.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		return pl.NewValNull(), w.conn.WriteMessage(websocket.BinaryMessage, []byte(args[0].String()))
+
+	case "ping":
+		alen, err := methodProtoWebSocketPing.Check(args)
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		data := ""
+		if alen == 1 {
+			data = args[0].String()
+		}
+		return pl.NewValNull(), w.conn.WriteMessage(websocket.PingMessage, []byte(data))
+
+	case "close":
+		alen, err := methodProtoWebSocketClose.Check(args)
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+		code := websocket.CloseNormalClosure
+		reason := ""
+		if alen >= 1 {
+			code = int(args[0].Int())
+			if code < 1000 || code > 4999 {
+				return pl.NewValNull(), fmt.Errorf("%s:close, invalid close code %d", w.Id(), code)
+			}
+		}
+		if alen == 2 {
+			reason = args[1].String()
+		}
+		return pl.NewValNull(), w.conn.CloseWithCode(code, reason)
+
+	case "setReadTimeout":
+		if _, err := methodProtoWebSocketSetReadTimeout.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		var deadline time.Time
+		if ms := args[0].Int(); ms > 0 {
+			deadline = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return pl.NewValNull(), w.conn.SetReadDeadline(deadline)
+
+	case "setMaxMessageSize":
+		if _, err := methodProtoWebSocketSetMaxSize.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		w.conn.SetMaxMessageSize(args[0].Int())
+		return pl.NewValNull(), nil
+
+	// relays messages between the 2 connections until either side closes, used
+	// for proxying the websocket to upstream
+	case "proxy":
+		if _, err := methodProtoWebSocketProxy.Check(args); err != nil {
+			return pl.NewValNull(), err
+		}
+		other, _ := args[0].Usr().(*WebSocket)
+		if other == w {
+			return pl.NewValNull(), fmt.Errorf("%s:proxy, cannot proxy to itself", w.Id())
+		}
+		return pl.NewValNull(), websocket.Proxy(w.conn, other.conn)
+
+	default:
+		break
+	}
+	return pl.NewValNull(), fmt.Errorf("%s's method %s is unknown", w.Id(), name)
+}
+
+func (w *WebSocket) Info() string {
+	return fmt.Sprintf("%s[remote=%s;closed=%t]", w.Id(), w.conn.RemoteAddr().String(), w.conn.IsClosed())
+}
+
+func (w *WebSocket) Id() string {
+	return WebSocketTypeId
+}
+
+func (w *WebSocket) IsThreadSafe() bool {
+	return false
+}
+
+// iterates the messages until the connection is closed, the index is the
+// sequence number of the message
+func (w *WebSocket) NewIterator() (pl.Iter, error) {
+	x := &webSocketIter{
+		ws:  w,
+		idx: -1,
+	}
+	if _, err := x.Next(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type webSocketIter struct {
+	ws   *WebSocket
+	idx  int
+	msg  pl.Val
+	done bool
+}
+
+func (x *webSocketIter) SetUp(_ *pl.Evaluator, _ []pl.Val) error {
+	return nil
+}
+
+func (x *webSocketIter) Has() bool {
+	return !x.done
+}
+
+func (x *webSocketIter) Next() (bool, error) {
+	if x.done {
+		return false, nil
+	}
+	_, v, err := x.ws.read()
+	if err != nil || v.IsNull() {
+		x.done = true
+		return false, err
+	}
+	x.idx++
+	x.msg = v
+	return true, nil
+}
+
+func (x *webSocketIter) Deref() (pl.Val, pl.Val, error) {
+	if x.done {
+		return pl.NewValNull(), pl.NewValNull(), fmt.Errorf("iterator out of bound")
+	}
+	return pl.NewValInt(x.idx), x.msg, nil
+}
+
+func NewWebSocketVal(conn *websocket.Conn) pl.Val {
+	return pl.NewValUsr(
+		&WebSocket{
+			conn: conn,
+		},
+	)
+}
+
+// option map of http::websocket, the keys are header, subprotocols, timeout
+// in millisecond, max_message_size and insecure_skip_verify
+func newWebSocketDialOption(v pl.Val) (*websocket.DialOption, int64, error) {
+	o := &websocket.DialOption{}
+	maxSize := int64(websocket.DefaultMaxMessageSize)
+	var err error
+
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "header":
+				hdr := make(http.Header)
+				if !foreachHeaderKV(val, func(k, v string) { hdr.Add(k, v) }) {
+					err = fmt.Errorf("header is invalid")
+				}
+				o.Header = hdr
+			case "subprotocols":
+				if !val.IsList() {
+					err = fmt.Errorf("subprotocols must be list of string")
+					break
+				}
+				for _, x := range val.List().Data {
+					if !x.IsString() {
+						err = fmt.Errorf("subprotocols must be list of string")
+						break
+					}
+					o.Subprotocols = append(o.Subprotocols, x.String())
+				}
+			case "timeout":
+				if !val.IsInt() || val.Int() < 0 {
+					err = fmt.Errorf("timeout must be non negative int")
+				} else {
+					o.Timeout = time.Duration(val.Int()) * time.Millisecond
+				}
+			case "max_message_size":
+				if !val.IsInt() {
+					err = fmt.Errorf("max_message_size must be int")
+				} else {
+					maxSize = val.Int()
+				}
+			case "insecure_skip_verify":
+				if !val.IsBool() {
+					err = fmt.Errorf("insecure_skip_verify must be bool")
+				} else if val.Bool() {
+					o.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+				}
+			default:
+				err = fmt.Errorf("option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	return o, maxSize, nil
+}
+
+func fnHttpWebSocket(info *pl.IntrinsicInfo, _ *pl.Evaluator, _ string, argument []pl.Val) (pl.Val, error) {
+	alen, err := info.Check(argument)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+
+	option := &websocket.DialOption{}
+	maxSize := int64(websocket.DefaultMaxMessageSize)
+	if alen == 2 {
+		if option, maxSize, err = newWebSocketDialOption(argument[1]); err != nil {
+			return pl.NewValNull(), fmt.Errorf("http::websocket invalid option: %s", err.Error())
+		}
+	}
+
+	conn, _, err := websocket.Dial(argument[0].String(), option)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	conn.SetMaxMessageSize(maxSize)
+	return NewWebSocketVal(conn), nil
+}
@@ -3,10 +3,14 @@ package hpl
 const (
 	// global type
 	ReadableStreamTypeId = ".readablestream"
+	WritableStreamTypeId = ".writablestream"
 	UrlTypeId            = ".url"
 	UrlSearchTypeId      = ".urlsearch"
 	TLSConnStateTypeId   = ".tlsconnstate"
 	AccessLogTypeId      = ".accesslog"
+	WebSocketTypeId      = ".websocket"
+	SSEWriterTypeId      = ".ssewriter"
+	SSEReaderTypeId      = ".ssereader"
 
 	// http type
 	HttpHeaderTypeId       = "http.header"
@@ -15,4 +19,25 @@ const (
 	HttpResponseTypeId     = "http.response"
 	HttpRouterParamsTypeId = "http.router.params"
 	HttpCookieTypeId       = "http.cookie"
+	HttpCookieJarTypeId    = "http.cookiejar"
+
+	// grpc type
+	GrpcClientTypeId = "grpc.client"
+
+	// cache type
+	CacheTypeId = "cache.store"
+
+	// redisclient type
+	RedisClientTypeId = "redisclient.client"
+
+	// sql type
+	SqlDBTypeId   = "sql.db"
+	SqlTxTypeId   = "sql.tx"
+	SqlStmtTypeId = "sql.stmt"
+
+	// mq type
+	MQClientTypeId = "mq.client"
+
+	// ratelimit type
+	RateLimiterTypeId = "ratelimit.limiter"
 )
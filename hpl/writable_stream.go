@@ -0,0 +1,190 @@
+package hpl
+
+import (
+	"fmt"
+	"github.com/dianpeng/moons/pl"
+	"io"
+	"net/http"
+)
+
+// WritableStream is the counterpart of ReadableStream, it allows script to
+// generate output incrementally, ie streaming the http response body
+type WritableStream struct {
+	Stream  io.WriteCloser
+	written int64
+	closed  bool
+}
+
+func ValIsWritableStream(v pl.Val) bool {
+	return v.Id() == WritableStreamTypeId
+}
+
+func NewWritableStreamFromStream(stream io.WriteCloser) *WritableStream {
+	return &WritableStream{
+		Stream: stream,
+	}
+}
+
+func NewWritableStreamValFromStream(stream io.WriteCloser) pl.Val {
+	return pl.NewValUsr(NewWritableStreamFromStream(stream))
+}
+
+func (h *WritableStream) IsClose() bool {
+	return h.closed
+}
+
+func (h *WritableStream) ByteWritten() int64 {
+	return h.written
+}
+
+func (h *WritableStream) Write(b []byte) (int, error) {
+	if h.closed {
+		return 0, fmt.Errorf(".writablestream is closed")
+	}
+	n, err := h.Stream.Write(b)
+	h.written += int64(n)
+	return n, err
+}
+
+// flush the buffered data of the underlying stream if it supports, ie the
+// http.ResponseWriter or bufio.Writer
+func (h *WritableStream) Flush() error {
+	if h.closed {
+		return fmt.Errorf(".writablestream is closed")
+	}
+	switch x := h.Stream.(type) {
+	case interface{ Flush() error }:
+		return x.Flush()
+	case http.Flusher:
+		x.Flush()
+	}
+	return nil
+}
+
+func (h *WritableStream) Close() error {
+	if h.closed {
+		return nil
+	}
+	h.closed = true
+	return h.Stream.Close()
+}
+
+func (h *WritableStream) Index(name pl.Val) (pl.Val, error) {
+	if name.Type != pl.ValStr {
+		return pl.NewValNull(), fmt.Errorf("invalid index, .writablestream field name must be string")
+	}
+	switch name.String() {
+	case "close":
+		return pl.NewValBool(h.IsClose()), nil
+	case "byteWritten":
+		return pl.NewValInt64(h.ByteWritten()), nil
+	default:
+		return pl.NewValNull(), fmt.Errorf("invalid index, unknown name %s", name.String())
+	}
+}
+
+func (h *WritableStream) IndexSet(_ pl.Val, _ pl.Val) error {
+	return fmt.Errorf(".writablestream does not support index set")
+}
+
+func (h *WritableStream) Dot(name string) (pl.Val, error) {
+	return h.Index(pl.NewValStr(name))
+}
+
+func (h *WritableStream) DotSet(name string, value pl.Val) error {
+	return fmt.Errorf(".writablestream does not support dot set")
+}
+
+func (h *WritableStream) ToString() (string, error) {
+	return h.Info(), nil
+}
+
+func (h *WritableStream) ToJSON() (pl.Val, error) {
+	return pl.MarshalVal(
+		map[string]interface{}{
+			"byteWritten": h.ByteWritten(),
+			"close":       h.IsClose(),
+		},
+	)
+}
+
+var (
+	methodProtoWritableStreamWrite     = pl.MustNewFuncProto(".writablestream.write", "%a*")
+	methodProtoWritableStreamWriteLine = pl.MustNewFuncProto(".writablestream.writeLine", "%a*")
+	methodProtoWritableStreamFlush     = pl.MustNewFuncProto(".writablestream.flush", "%0")
+	methodProtoWritableStreamClose     = pl.MustNewFuncProto(".writablestream.close", "%0")
+)
+
+// writes all the arguments converted to string, returns the number of bytes
+// been written
+func (h *WritableStream) writeVal(arg []pl.Val, suffix string) (pl.Val, error) {
+	n := 0
+	for idx, a := range arg {
+		str, err := a.ToString()
+		if err != nil {
+			return pl.NewValNull(), fmt.Errorf(".writablestream: the %d argument cannot be converted to string: %s",
+				idx+1, err.Error())
+		}
+		sz, err := h.Write([]byte(str))
+		n += sz
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+	}
+	if suffix != "" {
+		sz, err := h.Write([]byte(suffix))
+		n += sz
+		if err != nil {
+			return pl.NewValNull(), err
+		}
+	}
+	return pl.NewValInt(n), nil
+}
+
+func (h *WritableStream) Method(name string, arg []pl.Val) (pl.Val, error) {
+	switch name {
+	case "write":
+		if _, err := methodProtoWritableStreamWrite.Check(arg); err != nil {
+			return pl.NewValNull(), err
+		}
+		return h.writeVal(arg, "")
+	case "writeLine":
+		if _, err := methodProtoWritableStreamWriteLine.Check(arg); err != nil {
+			return pl.NewValNull(), err
+		}
+		return h.writeVal(arg, "\n")
+	case "flush":
+		if _, err := methodProtoWritableStreamFlush.Check(arg); err != nil {
+			return pl.NewValNull(), err
+		}
+		if err := h.Flush(); err != nil {
+			return pl.NewValNull(), err
+		}
+		return pl.NewValNull(), nil
+	case "close":
+		if _, err := methodProtoWritableStreamClose.Check(arg); err != nil {
+			return pl.NewValNull(), err
+		}
+		if err := h.Close(); err != nil {
+			return pl.NewValNull(), err
+		}
+		return pl.NewValNull(), nil
+	}
+	return pl.NewValNull(), fmt.Errorf("method: .writablestream:%s is unknown", name)
+}
+
+func (h *WritableStream) Info() string {
+	return fmt.Sprintf(".writablestream[written=%d;close=%t]", h.ByteWritten(), h.IsClose())
+}
+
+func (h *WritableStream) IsThreadSafe() bool {
+	return false
+}
+
+func (h *WritableStream) Id() string {
+	return WritableStreamTypeId
+}
+
+func (h *WritableStream) NewIterator() (pl.Iter, error) {
+	return nil, fmt.Errorf(".writablestream does not support iterator")
+}
@@ -45,6 +45,11 @@ func (p *Params) ByName(id string) string {
 	return p.p[id]
 }
 
+func (p *Params) Lookup(id string) (string, bool) {
+	v, ok := p.p[id]
+	return v, ok
+}
+
 func (p *Params) Set(k, v string) {
 	p.p[k] = v
 }
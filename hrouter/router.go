@@ -0,0 +1,305 @@
+package hrouter
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// The route of a service is written in the compact form
+//
+//   [METHODS]host/path
+//
+// The METHODS is a comma separated method list or * for all methods, the host
+// is optional and the route without host matches any host. The path segment
+// can be a parameter {name}, a regex constrained parameter {name:regex}, or an
+// optional parameter {name?} or {name?:regex}, the optional parameters must be
+// the trailing segments of the path. A trailing * matches the rest of the
+// path, which is stored as parameter _Rest.
+
+var methodList = []string{
+	"GET",
+	"POST",
+	"PUT",
+	"DELETE",
+	"PURGE",
+	"OPTIONS",
+	"PATCH",
+	"HEAD",
+}
+
+const RestParam = "_Rest"
+
+type Route struct {
+	// name of the service owning the route and the group it is mounted in
+	Service string
+	Group   string
+
+	Host    string
+	Methods []string
+	Path    string
+
+	// path templates registered, more than one if the route has optional
+	// trailing segments
+	Pattern []string
+}
+
+// Group is a set of routes sharing the path prefix and the host
+type Group struct {
+	Name   string
+	Prefix string
+	Host   string
+}
+
+func (r *Route) String() string {
+	return fmt.Sprintf("[%s]%s%s", strings.Join(r.Methods, ","), r.Host, r.Path)
+}
+
+// splits the path by / outside of the braces, so the regex of the parameter
+// can contain / and braces
+func splitPath(path string) ([]string, error) {
+	var o []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced } in path %s", path)
+			}
+		case '/':
+			if depth == 0 {
+				o = append(o, path[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced { in path %s", path)
+	}
+	return append(o, path[start:]), nil
+}
+
+// returns the mux form of the optional parameter and whether the segment is
+// an optional parameter
+func optionalSegment(seg string) (string, bool) {
+	if !strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") {
+		return seg, false
+	}
+	body := seg[1 : len(seg)-1]
+	name := body
+	if i := strings.IndexByte(body, ':'); i >= 0 {
+		name = body[:i]
+	}
+	if !strings.HasSuffix(name, "?") {
+		return seg, false
+	}
+	return "{" + strings.TrimSuffix(name, "?") + body[len(name):] + "}", true
+}
+
+func compilePath(path string) ([]string, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path %s must start with /", path)
+	}
+	seg, err := splitPath(path[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	// the wildcard is always a segment of its own, ie /assets* is same as
+	// /assets/*
+	last := len(seg) - 1
+	if strings.HasSuffix(seg[last], "*") {
+		if x := strings.TrimSuffix(seg[last], "*"); x != "" {
+			seg[last] = x
+			seg = append(seg, "")
+			last++
+		}
+		seg[last] = "{" + RestParam + ":.*}"
+	}
+
+	base := []string{}
+	optional := []string{}
+	for _, s := range seg {
+		x, ok := optionalSegment(s)
+		switch {
+		case ok:
+			optional = append(optional, x)
+		case len(optional) != 0:
+			return nil, fmt.Errorf("optional parameter must be trailing in path %s", path)
+		default:
+			base = append(base, x)
+		}
+	}
+
+	o := []string{}
+	for i := 0; i <= len(optional); i++ {
+		x := append(append([]string{}, base...), optional[:i]...)
+		o = append(o, "/"+strings.Join(x, "/"))
+	}
+	return o, nil
+}
+
+// ParseRoute parses the compact form of the route
+func ParseRoute(spec string) (*Route, error) {
+	spec = strings.TrimSpace(spec)
+	if !strings.HasPrefix(spec, "[") {
+		return nil, fmt.Errorf("invalid router: %s, cannot find [", spec)
+	}
+	end := strings.Index(spec, "]")
+	if end == -1 {
+		return nil, fmt.Errorf("invalid router: %s, cannot find ]", spec)
+	}
+
+	r := &Route{}
+	if mlist := strings.TrimSpace(spec[1:end]); mlist == "*" {
+		r.Methods = methodList
+	} else {
+		for _, m := range strings.Split(mlist, ",") {
+			if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+				r.Methods = append(r.Methods, m)
+			}
+		}
+	}
+
+	rest := spec[end+1:]
+	if !strings.HasPrefix(rest, "/") {
+		i := strings.IndexByte(rest, '/')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid router: %s, path must start with /", spec)
+		}
+		r.Host = strings.ToLower(rest[:i])
+		rest = rest[i:]
+	}
+
+	pattern, err := compilePath(rest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid router: %s, %s", spec, err.Error())
+	}
+	r.Path = rest
+	r.Pattern = pattern
+	return r, nil
+}
+
+// NewRoute creates the route of the path in mux form, ie the path of the
+// OpenAPI spec
+func NewRoute(method string, host string, path string) *Route {
+	return &Route{
+		Host:    strings.ToLower(host),
+		Methods: []string{method},
+		Path:    path,
+		Pattern: []string{path},
+	}
+}
+
+// Mount places the route into the group, the path is prefixed by the group's
+// prefix and the route without host takes the host of the group
+func (r *Route) Mount(g *Group) (*Route, error) {
+	prefix := strings.TrimSuffix(g.Prefix, "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		return nil, fmt.Errorf("group %s prefix must start with /", g.Name)
+	}
+	host := strings.ToLower(g.Host)
+	if r.Host != "" && host != "" && r.Host != host {
+		return nil, fmt.Errorf("route %s does not match the host of group %s", r.String(), g.Name)
+	}
+
+	x := *r
+	x.Group = g.Name
+	if x.Host == "" {
+		x.Host = host
+	}
+	x.Path = prefix + r.Path
+	x.Pattern = nil
+	for _, p := range r.Pattern {
+		if p == "/" && prefix != "" {
+			x.Pattern = append(x.Pattern, prefix)
+		} else {
+			x.Pattern = append(x.Pattern, prefix+p)
+		}
+	}
+	return &x, nil
+}
+
+// Router dispatches the request to the route table of its host and falls back
+// to the routes without host. The host containing a template, ie
+// {tenant}.example.com, is matched by the fallback table in the order of
+// registration
+type Router struct {
+	host   map[string]*mux.Router
+	any    *mux.Router
+	routes []*Route
+}
+
+func NewRouter() *Router {
+	return &Router{
+		host: make(map[string]*mux.Router),
+		any:  mux.NewRouter(),
+	}
+}
+
+func (r *Router) table(host string) *mux.Router {
+	if host == "" || strings.Contains(host, "{") {
+		return r.any
+	}
+	t, ok := r.host[host]
+	if !ok {
+		t = mux.NewRouter()
+		r.host[host] = t
+	}
+	return t
+}
+
+// Handle registers the route, the routes are not thread safe to be added
+// once the router starts serving
+func (r *Router) Handle(route *Route, handler http.Handler) error {
+	t := r.table(route.Host)
+	for _, p := range route.Pattern {
+		x := t.Handle(p, handler)
+		if len(route.Methods) != 0 {
+			x.Methods(route.Methods...)
+		}
+		if strings.Contains(route.Host, "{") {
+			x.Host(route.Host)
+		}
+		if err := x.GetError(); err != nil {
+			return fmt.Errorf("route %s: %s", route.String(), err.Error())
+		}
+	}
+	r.routes = append(r.routes, route)
+	return nil
+}
+
+// Routes returns the compiled routes in the order of registration
+func (r *Router) Routes() []Route {
+	o := make([]Route, 0, len(r.routes))
+	for _, x := range r.routes {
+		o = append(o, *x)
+	}
+	return o
+}
+
+func hostOf(req *http.Request) string {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if t, ok := r.host[hostOf(req)]; ok {
+		var m mux.RouteMatch
+		if t.Match(req, &m) && m.MatchErr == nil {
+			t.ServeHTTP(w, req)
+			return
+		}
+	}
+	r.any.ServeHTTP(w, req)
+}
@@ -0,0 +1,242 @@
+package hrouter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitPath(t *testing.T) {
+	cases := []struct {
+		path string
+		seg  []string
+		ok   bool
+	}{
+		{"", []string{""}, true},
+		{"a", []string{"a"}, true},
+		{"a/b", []string{"a", "b"}, true},
+		{"a/", []string{"a", ""}, true},
+		{"a//b", []string{"a", "", "b"}, true},
+		{"{id}/x", []string{"{id}", "x"}, true},
+		{"{p:a/b}/x", []string{"{p:a/b}", "x"}, true},
+		{"{p:[0-9]{2}}/x", []string{"{p:[0-9]{2}}", "x"}, true},
+		{"{id", nil, false},
+		{"id}", nil, false},
+		{"{a}}/{b", nil, false},
+	}
+	for _, c := range cases {
+		seg, err := splitPath(c.path)
+		if c.ok {
+			assert.Nil(t, err, c.path)
+			assert.Equal(t, c.seg, seg, c.path)
+		} else {
+			assert.NotNil(t, err, c.path)
+		}
+	}
+}
+
+func TestCompilePath(t *testing.T) {
+	cases := []struct {
+		path    string
+		pattern []string
+		ok      bool
+	}{
+		{"/", []string{"/"}, true},
+		{"/a/b", []string{"/a/b"}, true},
+		{"/a/{id}", []string{"/a/{id}"}, true},
+		{"/a/{id:[0-9]+}", []string{"/a/{id:[0-9]+}"}, true},
+		{"/a/{id?}", []string{"/a", "/a/{id}"}, true},
+		{"/a/{id?:[0-9]+}", []string{"/a", "/a/{id:[0-9]+}"}, true},
+		{"/a/{x?}/{y?}", []string{"/a", "/a/{x}", "/a/{x}/{y}"}, true},
+		{"/{x?}", []string{"/", "/{x}"}, true},
+		{"/a/{re:x?}", []string{"/a/{re:x?}"}, true},
+		{"/a/*", []string{"/a/{_Rest:.*}"}, true},
+		{"/a*", []string{"/a/{_Rest:.*}"}, true},
+		{"/*", []string{"/{_Rest:.*}"}, true},
+		{"/a/{p:x/y}/b", []string{"/a/{p:x/y}/b"}, true},
+		{"a/b", nil, false},
+		{"/a/{x?}/b", nil, false},
+		{"/a/{x?}/{y}", nil, false},
+		{"/a/{x", nil, false},
+	}
+	for _, c := range cases {
+		p, err := compilePath(c.path)
+		if c.ok {
+			assert.Nil(t, err, c.path)
+			assert.Equal(t, c.pattern, p, c.path)
+		} else {
+			assert.NotNil(t, err, c.path)
+		}
+	}
+}
+
+func TestParseRoute(t *testing.T) {
+	assert := assert.New(t)
+
+	r, err := ParseRoute(" [get, Post]Example.COM/a/{id?} ")
+	assert.Nil(err)
+	assert.Equal([]string{"GET", "POST"}, r.Methods)
+	assert.Equal("example.com", r.Host)
+	assert.Equal("/a/{id?}", r.Path)
+	assert.Equal([]string{"/a", "/a/{id}"}, r.Pattern)
+	assert.Equal("[GET,POST]example.com/a/{id?}", r.String())
+
+	r, err = ParseRoute("[*]/")
+	assert.Nil(err)
+	assert.Equal(methodList, r.Methods)
+	assert.Equal("", r.Host)
+
+	r, err = ParseRoute("[]/a")
+	assert.Nil(err)
+	assert.Nil(r.Methods)
+
+	for _, x := range []string{
+		"GET/a",
+		"[GET/a",
+		"[GET]",
+		"[GET]example.com",
+		"[GET]a/{x?}/b",
+		"[GET]/a/{x?}/b",
+	} {
+		_, err := ParseRoute(x)
+		assert.NotNil(err, x)
+	}
+}
+
+func TestMount(t *testing.T) {
+	assert := assert.New(t)
+
+	r, _ := ParseRoute("[GET]/{id?}")
+	m, err := r.Mount(&Group{Name: "g", Prefix: "/api/", Host: "Example.com"})
+	assert.Nil(err)
+	assert.Equal("g", m.Group)
+	assert.Equal("example.com", m.Host)
+	assert.Equal("/api/{id?}", m.Path)
+	assert.Equal([]string{"/api", "/api/{id}"}, m.Pattern)
+
+	// the original route is untouched
+	assert.Equal("", r.Host)
+	assert.Equal([]string{"/", "/{id}"}, r.Pattern)
+
+	m, err = r.Mount(&Group{Name: "g"})
+	assert.Nil(err)
+	assert.Equal([]string{"/", "/{id}"}, m.Pattern)
+
+	r, _ = ParseRoute("[GET]a.com/x")
+	_, err = r.Mount(&Group{Name: "g", Host: "b.com"})
+	assert.NotNil(err)
+	m, err = r.Mount(&Group{Name: "g", Host: "A.com"})
+	assert.Nil(err)
+	assert.Equal("a.com", m.Host)
+
+	_, err = r.Mount(&Group{Name: "g", Prefix: "api"})
+	assert.NotNil(err)
+}
+
+// handler replies its name and the parameters of the request
+func named(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := NewParams(r)
+		w.Write([]byte(name))
+		for _, k := range []string{"id", "x", "y", "tenant", RestParam} {
+			if v, ok := p.Lookup(k); ok {
+				w.Write([]byte(" " + k + "=" + v))
+			}
+		}
+	})
+}
+
+func serve(r *Router, method string, host string, path string) (int, string) {
+	req := httptest.NewRequest(method, "http://"+host+path, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code, w.Body.String()
+}
+
+func TestRouter(t *testing.T) {
+	router := NewRouter()
+	for _, x := range []struct {
+		spec string
+		name string
+	}{
+		{"[GET]/items/{id?}", "items"},
+		{"[GET]/opt/{x?}/{y?:[0-9]+}", "opt"},
+		{"[GET]/static/*", "static"},
+		{"[GET]/host", "any-host"},
+		{"[GET]a.com/host", "a-host"},
+		{"[POST]b.com/host", "b-host"},
+		{"[GET]{tenant}.t.com/host", "tenant-host"},
+		{"[GET]a.com/only-a", "only-a"},
+		{"[GET]{tenant}.t.com/tenant", "tenant"},
+	} {
+		r, err := ParseRoute(x.spec)
+		if !assert.Nil(t, err, x.spec) {
+			continue
+		}
+		assert.Nil(t, router.Handle(r, named(x.name)), x.spec)
+	}
+
+	cases := []struct {
+		method string
+		host   string
+		path   string
+		code   int
+		body   string
+	}{
+		// optional trailing segments
+		{"GET", "x.com", "/items", 200, "items"},
+		{"GET", "x.com", "/items/7", 200, "items id=7"},
+		{"GET", "x.com", "/items/7/8", 404, ""},
+		{"GET", "x.com", "/opt", 200, "opt"},
+		{"GET", "x.com", "/opt/a", 200, "opt x=a"},
+		{"GET", "x.com", "/opt/a/1", 200, "opt x=a y=1"},
+		{"GET", "x.com", "/opt/a/b", 404, ""},
+		{"POST", "x.com", "/items", 405, ""},
+
+		// wildcard
+		{"GET", "x.com", "/static/", 200, "static _Rest="},
+		{"GET", "x.com", "/static/css/a.css", 200, "static _Rest=css/a.css"},
+
+		// host precedence, the exact host wins over the route without host
+		{"GET", "a.com", "/host", 200, "a-host"},
+		{"GET", "A.COM:8080", "/host", 200, "a-host"},
+		{"GET", "c.com", "/host", 200, "any-host"},
+		{"GET", "", "/host", 200, "any-host"},
+
+		// the templated host shares the table of the route without host, so
+		// the one registered first wins
+		{"GET", "acme.t.com", "/host", 200, "any-host"},
+		{"GET", "acme.t.com", "/tenant", 200, "tenant tenant=acme"},
+		{"GET", "t.com", "/tenant", 404, ""},
+
+		// the exact host table falls back when none of its route matches,
+		// including method mismatch
+		{"GET", "b.com", "/host", 200, "any-host"},
+		{"POST", "b.com", "/host", 200, "b-host"},
+		{"GET", "a.com", "/items/1", 200, "items id=1"},
+
+		// the route of a host is not visible to other hosts
+		{"GET", "a.com", "/only-a", 200, "only-a"},
+		{"GET", "b.com", "/only-a", 404, ""},
+	}
+	for _, c := range cases {
+		code, body := serve(router, c.method, c.host, c.path)
+		name := c.method + " " + c.host + c.path
+		assert.Equal(t, c.code, code, name)
+		if c.code == 200 {
+			assert.Equal(t, c.body, body, name)
+		}
+	}
+
+	routes := router.Routes()
+	assert.Equal(t, 9, len(routes))
+	assert.Equal(t, "/items/{id?}", routes[0].Path)
+}
+
+func TestRouterError(t *testing.T) {
+	r := NewRouter()
+	err := r.Handle(NewRoute("GET", "", "/a/{id:[}"), named("x"))
+	assert.NotNil(t, err)
+}
@@ -1,6 +1,11 @@
 package framework
 
 import (
+	"bufio"
+	"io/fs"
+	"net"
+
+	"github.com/dianpeng/moons/cache"
 	"github.com/dianpeng/moons/http/runtime"
 )
 
@@ -8,3 +13,28 @@ type ServiceContext interface {
 	Runtime() *runtime.Runtime
 	HplSessionWrapper() runtime.SessionWrapper
 }
+
+// optional interface of ServiceContext, takes over the connection of the http
+// transaction, ie for protocol upgrade. Once hijacked, the response phase does
+// not write anything to the connection and the application owns it
+type ConnHijacker interface {
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
+}
+
+// optional interface of ServiceContext, returns the cache of the vhost or nil
+// if the vhost has no cache configured
+type CacheProvider interface {
+	Cache() *cache.Cache
+}
+
+// optional interface of ServiceContext, returns the file system of the
+// manifest the vhost is loaded from
+type FSProvider interface {
+	FS() fs.FS
+}
+
+// optional interface of ServiceContext, returns the response writer of the
+// http transaction so the application can generate the response itself
+type ResponseWriterProvider interface {
+	ResponseWriter() HttpResponseWriter
+}
@@ -1 +1,34 @@
 package framework
+
+import (
+	"sort"
+)
+
+// FactoryInfo describes a registered module, kind is one of request,
+// response and application
+type FactoryInfo struct {
+	Kind    string
+	Name    string
+	Comment string
+}
+
+// ListFactories returns the registered modules sorted by kind and name
+func ListFactories() []FactoryInfo {
+	o := []FactoryInfo{}
+	for name, x := range requestmap.m {
+		o = append(o, FactoryInfo{"request", name, x.Comment()})
+	}
+	for name, x := range responsemap.m {
+		o = append(o, FactoryInfo{"response", name, x.Comment()})
+	}
+	for name, x := range applicationmap {
+		o = append(o, FactoryInfo{"application", name, x.Comment()})
+	}
+	sort.Slice(o, func(i, j int) bool {
+		if o[i].Kind != o[j].Kind {
+			return o[i].Kind < o[j].Kind
+		}
+		return o[i].Name < o[j].Name
+	})
+	return o
+}
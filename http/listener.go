@@ -1,13 +1,18 @@
 package http
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"github.com/dianpeng/moons/http/vhost"
 	"github.com/dianpeng/moons/server"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,14 +25,45 @@ type listenerConfig struct {
 	IdleTimeout       int64  `json:"idle_timeout"`
 	ReadHeaderTimeout int64  `json:"read_header_timeout"`
 	MaxHeaderSize     int64  `json:"max_header_size"`
+
+	// TLS termination, only in JSON form, nil for plain http
+	TLS *server.TLSConfig `json:"tls"`
+
+	// protocols served and the http/2 tuning, only in JSON form, see
+	// protocol.go
+	Protocol []string     `json:"protocol"`
+	HTTP2    *http2Config `json:"http2"`
+
+	// PROXY protocol of the L4 balancer, only in JSON form
+	ProxyProtocol *server.ProxyProtocolConfig `json:"proxy_protocol"`
+
+	// liveness and readiness probes, see probe.go
+	Probe *probeConfig `json:"probe"`
 }
 
 type listener struct {
 	name   string
 	server *http.Server // the server
 	vlist  vhostlist
+	tls    *server.TLS
+	proxy  *server.ProxyProtocol
+	probe  *probe
+
+	// set once the shutdown starts, the readiness probe fails from then on
+	shutting int32
+
+	// listening socket, set once the listener runs
+	socketLock sync.Mutex
+	socket     *server.DrainListener
+
+	// connections accepted whose first request is not read yet, net/http
+	// drops them without reply once its shutdown starts
+	freshLock sync.Mutex
+	fresh     map[net.Conn]struct{}
 }
 
+const drainPollInterval = 10 * time.Millisecond
+
 func (lc *listenerConfig) TypeName() string {
 	return lc.Type
 }
@@ -36,6 +72,19 @@ func (l *listener) ServeHTTP(
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
+	// the HTTP-01 challenge of ACME is answered before any vhost
+	if strings.HasPrefix(r.URL.Path, server.ACMEChallengePath) {
+		if resp, ok := server.ACMEChallengeResponse(r.Host, r.URL.Path); ok {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(resp))
+			return
+		}
+	}
+
+	if l.serveProbe(w, r) {
+		return
+	}
+
 	x := l.resolveVHost(r.Host)
 	if x != nil {
 		x.Router.ServeHTTP(w, r)
@@ -44,6 +93,21 @@ func (l *listener) ServeHTTP(
 	}
 }
 
+// HTTP/2 is served over TLS, which requires one of the AES 128 GCM cipher
+// suites if the suites are configured
+func http2CipherSuite(list []uint16) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, x := range list {
+		if x == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 ||
+			x == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			return true
+		}
+	}
+	return false
+}
+
 type fac struct{}
 
 func (f *fac) New(
@@ -51,9 +115,16 @@ func (f *fac) New(
 ) (server.Listener, error) {
 	opt := sopt.(*listenerConfig)
 
+	proto, err := opt.protocols()
+	if err != nil {
+		return nil, err
+	}
+
 	l := &listener{
 		name:  opt.Name,
 		vlist: newvhostlist(),
+		probe: newProbe(opt.Probe),
+		fresh: make(map[net.Conn]struct{}),
 	}
 
 	l.server = &http.Server{
@@ -64,6 +135,32 @@ func (f *fac) New(
 		WriteTimeout:      time.Second * time.Duration(opt.WriteTimeout),
 		IdleTimeout:       time.Second * time.Duration(opt.IdleTimeout),
 		MaxHeaderBytes:    int(opt.MaxHeaderSize),
+		ConnState:         l.onConnState,
+	}
+	if err := configureProtocol(l.server, proto, opt.HTTP2); err != nil {
+		return nil, fmt.Errorf("listener %s: %s", opt.Name, err.Error())
+	}
+
+	if opt.TLS != nil {
+		t, err := server.NewTLS(opt.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: %s", opt.Name, err.Error())
+		}
+		if proto[protoH2] && !http2CipherSuite(t.Config.CipherSuites) {
+			return nil, fmt.Errorf("listener %s: tls: cipher_suite must include "+
+				"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or "+
+				"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 required by HTTP/2", opt.Name)
+		}
+		l.tls = t
+		l.server.TLSConfig = t.Config
+	}
+
+	if opt.ProxyProtocol != nil {
+		p, err := server.NewProxyProtocol(opt.ProxyProtocol)
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: %s", opt.Name, err.Error())
+		}
+		l.proxy = p
 	}
 
 	return l, nil
@@ -150,7 +247,86 @@ func (l *listener) Type() string {
 }
 
 func (l *listener) Run() error {
-	return l.server.ListenAndServe()
+	ln, err := server.Listen(l.name, l.server.Addr)
+	if err != nil {
+		return err
+	}
+	if l.proxy != nil {
+		ln = l.proxy.NewListener(ln)
+	}
+	socket := server.NewDrainListener(ln)
+	l.socketLock.Lock()
+	l.socket = socket
+	l.socketLock.Unlock()
+
+	if l.tls != nil {
+		err = l.server.ServeTLS(socket, "", "")
+	} else {
+		err = l.server.Serve(socket)
+	}
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (l *listener) onConnState(c net.Conn, st http.ConnState) {
+	l.freshLock.Lock()
+	defer l.freshLock.Unlock()
+	if st == http.StateNew {
+		l.fresh[c] = struct{}{}
+	} else {
+		delete(l.fresh, c)
+	}
+}
+
+// waits the connections just accepted to send their first request, or to be
+// closed by the read header timeout
+func (l *listener) waitFresh(ctx context.Context) error {
+	tick := time.NewTicker(drainPollInterval)
+	defer tick.Stop()
+	for {
+		l.freshLock.Lock()
+		n := len(l.fresh)
+		l.freshLock.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+			break
+		}
+	}
+}
+
+func (l *listener) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&l.shutting, 1)
+
+	// stops accepting first, the socket may be handed over to the new process
+	// which accepts the connections from now on
+	l.socketLock.Lock()
+	if l.socket != nil {
+		l.socket.Stop()
+	}
+	l.socketLock.Unlock()
+
+	err := l.waitFresh(ctx)
+	if err == nil {
+		err = l.server.Shutdown(ctx)
+	}
+	if err != nil {
+		// the sessions not drained in time are closed
+		l.server.Close()
+	}
+	if l.tls != nil {
+		l.tls.Close()
+	}
+	for _, v := range l.vlist.list() {
+		v.Shutdown()
+	}
+	return err
 }
 
 // the follwing function are thread safe, so can be used to add, update, remove
@@ -158,14 +334,24 @@ func (l *listener) Run() error {
 func (l *listener) AddVHost(
 	v server.VHost,
 ) error {
-	return l.vlist.add(v.(*vhost.VHost))
+	x := v.(*vhost.VHost)
+	if err := l.vlist.add(x); err != nil {
+		return err
+	}
+	x.Activate()
+	return nil
 }
 
 // try to update a VHost in the current listener
 func (l *listener) UpdateVHost(
 	v server.VHost,
-) {
-	l.vlist.update(v.(*vhost.VHost))
+) error {
+	x := v.(*vhost.VHost)
+	if err := l.vlist.update(x); err != nil {
+		return err
+	}
+	x.Activate()
+	return nil
 }
 
 func (l *listener) RemoveVHost(
@@ -177,7 +363,10 @@ func (l *listener) RemoveVHost(
 func (l *listener) GetVHost(
 	serverName string,
 ) server.VHost {
-	return l.vlist.get(serverName)
+	if x := l.vlist.get(serverName); x != nil {
+		return x
+	}
+	return nil
 }
 
 func init() {
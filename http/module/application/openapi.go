@@ -0,0 +1,202 @@
+package application
+
+// Dispatches the request routed by the OpenAPI spec of the service, ie the
+// .openapi property, to the event named by the operationId of the matched
+// operation, with context {operation, params, body}. The params is a map of
+// location, ie path, query, header and cookie, to the map of the parameters
+// whose value is converted by the type of its schema, and the body is the
+// decoded JSON request body or null. The request failing the validation is
+// replied with 400 and the list of violations in JSON, the event is not
+// emitted. Arguments:
+//   0) option map, optional
+//      validate, whether to validate the request, default is true
+//      max_body, max size of the JSON body in bytes, default is 1MB
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/openapi"
+	"github.com/dianpeng/moons/pl"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defOpenAPIMaxBody = 1 << 20
+)
+
+type openAPIApplication struct {
+	validate bool
+	maxBody  int64
+}
+
+type openAPIFactory struct{}
+
+func (o *openAPIApplication) Prepare(r *http.Request, _ hrouter.Params) (interface{}, error) {
+	return r, nil
+}
+
+// reads the body through the request value of the script, so the body is
+// still readable by the event
+func (o *openAPIApplication) readBody(r *http.Request, context framework.ServiceContext) ([]byte, error) {
+	if r.ContentLength > o.maxBody {
+		return nil, fmt.Errorf("request body is too large")
+	}
+
+	reqVal := context.Runtime().Request()
+	if hpl.ValIsHttpRequest(reqVal) {
+		bodyVal := reqVal.Usr().(*hpl.Request).Body()
+		if hpl.ValIsHttpBody(bodyVal) {
+			data, err := bodyVal.Usr().(*hpl.Body).Stream().CacheBuffer()
+			if err == nil && int64(len(data)) > o.maxBody {
+				return nil, fmt.Errorf("request body is too large")
+			}
+			return data, err
+		}
+	}
+
+	if r.Body == nil {
+		return nil, nil
+	}
+	return io.ReadAll(io.LimitReader(r.Body, o.maxBody))
+}
+
+func (o *openAPIApplication) reject(
+	context framework.ServiceContext,
+	status int,
+	violation []openapi.Violation,
+) error {
+	p, ok := context.(framework.ResponseWriterProvider)
+	if !ok {
+		return fmt.Errorf("module(openapi): response writer is not available")
+	}
+
+	list := pl.NewValList()
+	for _, v := range violation {
+		list.AddList(v.ToVal())
+	}
+	x := pl.NewValMap()
+	x.AddMap("error", pl.NewValStr(http.StatusText(status)))
+	x.AddMap("violations", list)
+	body, err := x.ToJSONString()
+	if err != nil {
+		return err
+	}
+
+	w := p.ResponseWriter()
+	w.WriteStatus(status)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteBody(hpl.NewReadCloserFromString(body))
+	return nil
+}
+
+func (o *openAPIApplication) Accept(
+	ctx interface{},
+	context framework.ServiceContext,
+) (framework.ApplicationResult, error) {
+	r, ok := ctx.(*http.Request)
+	if !ok {
+		return framework.ApplicationResult{},
+			fmt.Errorf("module(openapi): input context parameter invalid")
+	}
+	op := openapi.OperationOf(r)
+	if op == nil {
+		return framework.ApplicationResult{},
+			fmt.Errorf("module(openapi): request is not routed by openapi spec")
+	}
+
+	params, violation := op.Params(r, mux.Vars(r), o.validate)
+
+	body := pl.NewValNull()
+	if op.Body != nil {
+		data, err := o.readBody(r, context)
+		if err != nil {
+			return framework.ApplicationResult{},
+				o.reject(context, http.StatusRequestEntityTooLarge, []openapi.Violation{
+					{In: "body", Message: err.Error()},
+				})
+		}
+		v, bv := op.RequestBody(data, o.validate)
+		body = v
+		violation = append(violation, bv...)
+	}
+
+	if len(violation) > 0 {
+		return framework.ApplicationResult{},
+			o.reject(context, http.StatusBadRequest, violation)
+	}
+
+	output := framework.NewApplicationResult(op.Id)
+	output.AddContext(
+		"operation",
+		pl.NewValStr(op.Id),
+	)
+	output.AddContext(
+		"params",
+		params,
+	)
+	output.AddContext(
+		"body",
+		body,
+	)
+	return output, nil
+}
+
+func (o *openAPIApplication) Done(_ interface{}) {
+}
+
+func (o *openAPIFactory) Create(x []pl.Val) (framework.Application, error) {
+	app := &openAPIApplication{
+		validate: true,
+		maxBody:  defOpenAPIMaxBody,
+	}
+	if len(x) == 0 || x[0].IsNull() {
+		return app, nil
+	}
+	if !x[0].IsMap() {
+		return nil, fmt.Errorf("module(openapi): option must be map")
+	}
+
+	var err error
+	x[0].Map().Foreach(
+		func(key string, v pl.Val) bool {
+			switch key {
+			case "validate":
+				if !v.IsBool() {
+					err = fmt.Errorf("module(openapi): validate must be bool")
+				} else {
+					app.validate = v.Bool()
+				}
+			case "max_body":
+				if !v.IsInt() || v.Int() <= 0 {
+					err = fmt.Errorf("module(openapi): max_body must be positive int")
+				} else {
+					app.maxBody = v.Int()
+				}
+			default:
+				err = fmt.Errorf("module(openapi): option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+func (o *openAPIFactory) Name() string {
+	return "openapi"
+}
+
+func (o *openAPIFactory) Comment() string {
+	return "dispatch the request to the event of its OpenAPI operation"
+}
+
+func init() {
+	framework.AddApplicationFactory("openapi", &openAPIFactory{})
+}
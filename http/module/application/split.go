@@ -0,0 +1,417 @@
+package application
+
+// Traffic splitting, routes the request to one of the targets and emits the
+// event of the target with context {target, upstream}, so one rule can proxy
+// all the targets by the upstream. The first target whose matching conditions
+// all hold is chosen, otherwise the request is split among the targets by
+// weight, ie for canary release. The weighted choice is sticky by cookie or by
+// the hash of a key of the request, so the client stays on the same target as
+// long as the weights do not change. Arguments:
+//   0) list of targets, each is a map of
+//      name, unique name of the target
+//      weight, share of the weighted split, default is 1, 0 means the target
+//        is only chosen by matching
+//      event, event emitted, default is split
+//      upstream, url or upstream group of the target, passed to the event
+//      header, map of header name to the value or regex it must match, or true
+//        if it only needs to be present
+//      when, closure returning whether the request matches
+//   1) sticky option, optional, a map of
+//      cookie, name of the cookie remembering the target
+//      max_age, max age of the cookie in seconds, default is 86400
+//      key, "ip", "header:<name>", "query:<name>" or a closure returning the
+//        key whose hash picks the target
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+)
+
+const (
+	defSplitEvent        = "split"
+	defSplitCookieMaxAge = 86400
+)
+
+type splitHeaderMatch struct {
+	name    string
+	value   string
+	re      *regexp.Regexp
+	present bool
+}
+
+func (m *splitHeaderMatch) match(r *http.Request) bool {
+	v, ok := r.Header[http.CanonicalHeaderKey(m.name)]
+	switch {
+	case !ok || len(v) == 0:
+		return false
+	case m.present:
+		return true
+	case m.re != nil:
+		return m.re.MatchString(v[0])
+	default:
+		return v[0] == m.value
+	}
+}
+
+type splitTarget struct {
+	name     string
+	weight   int64
+	event    string
+	upstream string
+	header   []splitHeaderMatch
+	when     pl.Val
+}
+
+func (t *splitTarget) hasMatch() bool {
+	return len(t.header) > 0 || t.when.IsClosure()
+}
+
+func (t *splitTarget) match(r *http.Request, context framework.ServiceContext) (bool, error) {
+	for i := range t.header {
+		if !t.header[i].match(r) {
+			return false, nil
+		}
+	}
+	if t.when.IsClosure() {
+		v, err := t.when.Closure().Call(context.Runtime().Eval, []pl.Val{})
+		if err != nil {
+			return false, err
+		}
+		return v.ToBoolean(), nil
+	}
+	return true, nil
+}
+
+type splitApplication struct {
+	target []*splitTarget
+	total  int64
+
+	cookie string
+	maxAge int
+	key    pl.Val
+}
+
+type splitFactory struct{}
+
+func (s *splitApplication) Prepare(r *http.Request, _ hrouter.Params) (interface{}, error) {
+	return r, nil
+}
+
+func (s *splitApplication) find(name string) *splitTarget {
+	for _, t := range s.target {
+		if t.name == name && t.weight > 0 {
+			return t
+		}
+	}
+	return nil
+}
+
+// picks the target by weight, the point is in [0, total)
+func (s *splitApplication) pick(point int64) *splitTarget {
+	for _, t := range s.target {
+		if point < t.weight {
+			return t
+		}
+		point -= t.weight
+	}
+	return nil
+}
+
+func (s *splitApplication) stickyKey(r *http.Request, context framework.ServiceContext) (string, error) {
+	if s.key.IsClosure() {
+		v, err := s.key.Closure().Call(context.Runtime().Eval, []pl.Val{})
+		if err != nil {
+			return "", err
+		}
+		return v.ToString()
+	}
+
+	spec := s.key.String()
+	switch {
+	case spec == "ip":
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			return host, nil
+		}
+		return r.RemoteAddr, nil
+	case strings.HasPrefix(spec, "header:"):
+		return r.Header.Get(spec[7:]), nil
+	default:
+		return r.URL.Query().Get(spec[6:]), nil
+	}
+}
+
+func (s *splitApplication) weighted(r *http.Request, context framework.ServiceContext) (*splitTarget, error) {
+	if s.total == 0 {
+		return nil, fmt.Errorf("module(split): no target matches the request")
+	}
+
+	if s.cookie != "" {
+		if c, err := r.Cookie(s.cookie); err == nil {
+			if t := s.find(c.Value); t != nil {
+				return t, nil
+			}
+		}
+		t := s.pick(rand.Int63n(s.total))
+		if p, ok := context.(framework.ResponseWriterProvider); ok {
+			c := &http.Cookie{
+				Name:     s.cookie,
+				Value:    t.name,
+				Path:     "/",
+				MaxAge:   s.maxAge,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			}
+			p.ResponseWriter().Header().Add("Set-Cookie", c.String())
+		}
+		return t, nil
+	}
+
+	if !s.key.IsNull() {
+		key, err := s.stickyKey(r, context)
+		if err != nil {
+			return nil, err
+		}
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		return s.pick(int64(h.Sum64() % uint64(s.total))), nil
+	}
+
+	return s.pick(rand.Int63n(s.total)), nil
+}
+
+func (s *splitApplication) Accept(
+	ctx interface{},
+	context framework.ServiceContext,
+) (framework.ApplicationResult, error) {
+	r, ok := ctx.(*http.Request)
+	if !ok {
+		return framework.ApplicationResult{},
+			fmt.Errorf("module(split): input context parameter invalid")
+	}
+
+	var target *splitTarget
+	for _, t := range s.target {
+		if !t.hasMatch() {
+			continue
+		}
+		ok, err := t.match(r, context)
+		if err != nil {
+			return framework.ApplicationResult{}, err
+		}
+		if ok {
+			target = t
+			break
+		}
+	}
+	if target == nil {
+		t, err := s.weighted(r, context)
+		if err != nil {
+			return framework.ApplicationResult{}, err
+		}
+		target = t
+	}
+
+	output := framework.NewApplicationResult(target.event)
+	output.AddContext(
+		"target",
+		pl.NewValStr(target.name),
+	)
+	output.AddContext(
+		"upstream",
+		pl.NewValStr(target.upstream),
+	)
+	return output, nil
+}
+
+func (s *splitApplication) Done(_ interface{}) {
+}
+
+func newSplitHeaderMatch(v pl.Val) ([]splitHeaderMatch, error) {
+	if !v.IsMap() {
+		return nil, fmt.Errorf("header must be map")
+	}
+	o := []splitHeaderMatch{}
+	var err error
+	v.Map().Foreach(
+		func(name string, x pl.Val) bool {
+			m := splitHeaderMatch{
+				name: name,
+			}
+			switch {
+			case x.IsString():
+				m.value = x.String()
+			case x.IsRegexp():
+				m.re = x.Regexp()
+			case x.IsBool() && x.Bool():
+				m.present = true
+			default:
+				err = fmt.Errorf("header %s must be string, regex or true", name)
+				return false
+			}
+			o = append(o, m)
+			return true
+		},
+	)
+	return o, err
+}
+
+func newSplitTarget(v pl.Val) (*splitTarget, error) {
+	if !v.IsMap() {
+		return nil, fmt.Errorf("target must be map")
+	}
+	t := &splitTarget{
+		weight: 1,
+		event:  defSplitEvent,
+		when:   pl.NewValNull(),
+	}
+
+	var err error
+	v.Map().Foreach(
+		func(key string, x pl.Val) bool {
+			switch key {
+			case "name":
+				if !x.IsString() || x.String() == "" {
+					err = fmt.Errorf("target name must be non empty string")
+				} else {
+					t.name = x.String()
+				}
+			case "weight":
+				if !x.IsInt() || x.Int() < 0 {
+					err = fmt.Errorf("target weight must be non negative int")
+				} else {
+					t.weight = x.Int()
+				}
+			case "event":
+				if !x.IsString() {
+					err = fmt.Errorf("target event must be string")
+				} else {
+					t.event = x.String()
+				}
+			case "upstream":
+				if !x.IsString() {
+					err = fmt.Errorf("target upstream must be string")
+				} else {
+					t.upstream = x.String()
+				}
+			case "header":
+				t.header, err = newSplitHeaderMatch(x)
+			case "when":
+				if !x.IsClosure() {
+					err = fmt.Errorf("target when must be closure")
+				} else {
+					t.when = x
+				}
+			default:
+				err = fmt.Errorf("target option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if t.name == "" {
+		return nil, fmt.Errorf("target name is not specified")
+	}
+	return t, nil
+}
+
+func (s *splitApplication) setSticky(v pl.Val) error {
+	if v.IsNull() {
+		return nil
+	}
+	if !v.IsMap() {
+		return fmt.Errorf("sticky option must be map")
+	}
+
+	var err error
+	v.Map().Foreach(
+		func(key string, x pl.Val) bool {
+			switch key {
+			case "cookie":
+				if !x.IsString() || x.String() == "" {
+					err = fmt.Errorf("sticky cookie must be non empty string")
+				} else {
+					s.cookie = x.String()
+				}
+			case "max_age":
+				if !x.IsInt() {
+					err = fmt.Errorf("sticky max_age must be int")
+				} else {
+					s.maxAge = int(x.Int())
+				}
+			case "key":
+				switch {
+				case x.IsClosure():
+					s.key = x
+				case x.IsString() && (x.String() == "ip" ||
+					strings.HasPrefix(x.String(), "header:") ||
+					strings.HasPrefix(x.String(), "query:")):
+					s.key = x
+				default:
+					err = fmt.Errorf("sticky key must be ip, header:<name>, query:<name> or closure")
+				}
+			default:
+				err = fmt.Errorf("sticky option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+	if err == nil && s.cookie != "" && !s.key.IsNull() {
+		err = fmt.Errorf("sticky cookie and key cannot be used together")
+	}
+	return err
+}
+
+func (s *splitFactory) Create(x []pl.Val) (framework.Application, error) {
+	if len(x) == 0 || !x[0].IsList() {
+		return nil, fmt.Errorf("module(split): targets must be list")
+	}
+	app := &splitApplication{
+		maxAge: defSplitCookieMaxAge,
+		key:    pl.NewValNull(),
+	}
+
+	seen := make(map[string]bool)
+	l := x[0].List()
+	for i := 0; i < l.Length(); i++ {
+		t, err := newSplitTarget(l.At(i))
+		if err != nil {
+			return nil, fmt.Errorf("module(split): %s", err.Error())
+		}
+		if seen[t.name] {
+			return nil, fmt.Errorf("module(split): target %s is duplicated", t.name)
+		}
+		seen[t.name] = true
+		app.target = append(app.target, t)
+		app.total += t.weight
+	}
+
+	if len(x) > 1 {
+		if err := app.setSticky(x[1]); err != nil {
+			return nil, fmt.Errorf("module(split): %s", err.Error())
+		}
+	}
+	return app, nil
+}
+
+func (s *splitFactory) Name() string {
+	return "split"
+}
+
+func (s *splitFactory) Comment() string {
+	return "route the request among the targets by weight, header or predicate"
+}
+
+func init() {
+	framework.AddApplicationFactory("split", &splitFactory{})
+}
@@ -0,0 +1,483 @@
+package application
+
+// Static file application, serves the files of the manifest file system
+// without any script. The file is the wildcard part of the router if the
+// router ends with *, otherwise the request path. Range request, conditional
+// GET with ETag and Last-Modified, and content type detection are supported.
+// Arguments:
+//   0) root directory in the manifest file system, default is the manifest root
+//   1) option map
+//        index, the index file name or list of them of a directory, default is
+//        index.html
+//        list, whether the directory without index file is listed, default is
+//        false
+//        max_age, max-age of Cache-Control in second, not set by default
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+)
+
+// name of the router parameter which holds the wildcard part
+const staticRouterRest = "_Rest"
+
+const sniffLen = 512
+
+type staticFactory struct{}
+
+type staticApplication struct {
+	args []pl.Val
+}
+
+type staticConfig struct {
+	root   string
+	index  []string
+	list   bool
+	maxAge int
+}
+
+type staticRequest struct {
+	req  *http.Request
+	name string
+	file fs.File
+}
+
+func (s *staticFactory) Name() string {
+	return "static"
+}
+
+func (s *staticFactory) Comment() string {
+	return "serve the static files of the manifest file system"
+}
+
+func (s *staticFactory) Create(args []pl.Val) (framework.Application, error) {
+	return &staticApplication{
+		args: args,
+	}, nil
+}
+
+func (s *staticApplication) config(ctx framework.ServiceContext) (*staticConfig, error) {
+	cfg := hpl.NewPLConfig(ctx.Runtime().Eval, s.args)
+	o := &staticConfig{
+		index: []string{"index.html"},
+	}
+	cfg.TryGetStr(0, &o.root, ".")
+
+	var option pl.Val
+	cfg.TryGet(1, &option, pl.NewValNull())
+	if option.IsNull() {
+		return o, nil
+	}
+	if !option.IsMap() {
+		return nil, fmt.Errorf("static: option must be map")
+	}
+
+	var err error
+	option.Map().Foreach(
+		func(key string, v pl.Val) bool {
+			switch key {
+			case "index":
+				o.index, err = staticIndex(v)
+			case "list":
+				if v.IsBool() {
+					o.list = v.Bool()
+				} else {
+					err = fmt.Errorf("static: option list must be bool")
+				}
+			case "max_age":
+				if v.IsInt() && v.Int() >= 0 {
+					o.maxAge = int(v.Int())
+				} else {
+					err = fmt.Errorf("static: option max_age must be non negative int")
+				}
+			default:
+				err = fmt.Errorf("static: option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func staticIndex(v pl.Val) ([]string, error) {
+	list := []pl.Val{v}
+	if v.IsList() {
+		list = v.List().Data
+	}
+	o := []string{}
+	for _, x := range list {
+		if !x.IsString() {
+			return nil, fmt.Errorf("static: option index must be string or list of string")
+		}
+		o = append(o, x.String())
+	}
+	return o, nil
+}
+
+func (s *staticApplication) Prepare(req *http.Request, p hrouter.Params) (interface{}, error) {
+	name, ok := p.Lookup(staticRouterRest)
+	if !ok {
+		name = req.URL.Path
+	}
+	return &staticRequest{
+		req:  req,
+		name: name,
+	}, nil
+}
+
+func (s *staticApplication) Done(ctx interface{}) {
+	if r, ok := ctx.(*staticRequest); ok && r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+func (s *staticApplication) Accept(ctx interface{}, sctx framework.ServiceContext) (framework.ApplicationResult, error) {
+	r, ok := ctx.(*staticRequest)
+	if !ok {
+		return framework.ApplicationResult{}, fmt.Errorf("static: input context parameter invalid")
+	}
+	fp, ok := sctx.(framework.FSProvider)
+	if !ok || fp.FS() == nil {
+		return framework.ApplicationResult{}, fmt.Errorf("static: file system is not available")
+	}
+	wp, ok := sctx.(framework.ResponseWriterProvider)
+	if !ok {
+		return framework.ApplicationResult{}, fmt.Errorf("static: response writer is not available")
+	}
+	config, err := s.config(sctx)
+	if err != nil {
+		return framework.ApplicationResult{}, err
+	}
+
+	s.serve(r, fp.FS(), config, wp.ResponseWriter())
+	return framework.ApplicationResult{}, nil
+}
+
+func staticReply(w framework.HttpResponseWriter, status int, body string) {
+	w.WriteStatus(status)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteBody(hpl.NewReadCloserFromString(body))
+}
+
+// path of the file in the file system, fs.FS does not accept the leading slash
+func staticPath(root, name string) (string, bool) {
+	p := strings.TrimPrefix(path.Join(root, path.Clean("/"+name)), "/")
+	if p == "" {
+		p = "."
+	}
+	return p, fs.ValidPath(p)
+}
+
+func (s *staticApplication) serve(
+	r *staticRequest,
+	fsys fs.FS,
+	config *staticConfig,
+	w framework.HttpResponseWriter,
+) {
+	req := r.req
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		staticReply(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	name, ok := staticPath(config.root, r.name)
+	if !ok {
+		staticReply(w, http.StatusNotFound, "not found")
+		return
+	}
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		staticReply(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if info.IsDir() {
+		// the relative links of the index are resolved against the directory
+		if !strings.HasSuffix(req.URL.Path, "/") {
+			u := url.URL{Path: req.URL.Path + "/", RawQuery: req.URL.RawQuery}
+			w.Header().Set("Location", u.String())
+			staticReply(w, http.StatusMovedPermanently, "moved permanently")
+			return
+		}
+
+		found := false
+		for _, index := range config.index {
+			x := path.Join(name, index)
+			if xi, err := fs.Stat(fsys, x); err == nil && !xi.IsDir() {
+				name, info, found = x, xi, true
+				break
+			}
+		}
+		if !found {
+			if config.list {
+				s.list(fsys, name, w)
+			} else {
+				staticReply(w, http.StatusNotFound, "not found")
+			}
+			return
+		}
+	}
+
+	file, err := fsys.Open(name)
+	if err != nil {
+		staticReply(w, http.StatusNotFound, "not found")
+		return
+	}
+	r.file = file
+	s.serveFile(req, file, info, config, w)
+}
+
+func (s *staticApplication) list(fsys fs.FS, name string, w framework.HttpResponseWriter) {
+	entry, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		staticReply(w, http.StatusInternalServerError, "cannot read directory")
+		return
+	}
+
+	b := new(bytes.Buffer)
+	b.WriteString("<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n")
+	for _, e := range entry {
+		n := e.Name()
+		if e.IsDir() {
+			n += "/"
+		}
+		u := url.URL{Path: n}
+		fmt.Fprintf(b, "<a href=\"%s\">%s</a>\n", u.String(), html.EscapeString(n))
+	}
+	b.WriteString("</pre>\n")
+
+	w.WriteStatus(http.StatusOK)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
+	w.WriteBody(hpl.NewReadCloserFromString(b.String()))
+}
+
+// the strong validator of the file, derived from its size and modification
+// time
+func staticETag(info fs.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size())
+}
+
+// whether the etag is in the list of If-Match or If-None-Match, weak compares
+// the tags without the W/ prefix
+func staticETagMatch(list, etag string, weak bool) bool {
+	for _, x := range strings.Split(list, ",") {
+		x = strings.TrimSpace(x)
+		if x == "*" {
+			return true
+		}
+		if weak {
+			x = strings.TrimPrefix(x, "W/")
+		}
+		if x == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluates the preconditions, returns the status to reply with or 0 if the
+// request should be served
+func staticPrecondition(req *http.Request, etag string, modtime time.Time) int {
+	h := req.Header
+	if x := h.Get("If-Match"); x != "" {
+		if !staticETagMatch(x, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if x := h.Get("If-Unmodified-Since"); x != "" {
+		if t, err := http.ParseTime(x); err == nil && modtime.After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if x := h.Get("If-None-Match"); x != "" {
+		if staticETagMatch(x, etag, true) {
+			return http.StatusNotModified
+		}
+	} else if x := h.Get("If-Modified-Since"); x != "" {
+		if t, err := http.ParseTime(x); err == nil && !modtime.After(t) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// parses the single range of the Range header, ok is false if the header
+// should be ignored, ie multiple ranges which are served as a whole. The
+// unsatisfiable range returns length -1
+func staticRange(x string, size int64) (int64, int64, bool) {
+	if !strings.HasPrefix(x, "bytes=") || strings.Contains(x, ",") {
+		return 0, 0, false
+	}
+	spec := strings.TrimSpace(x[6:])
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, 0, false
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+
+	if first == "" {
+		// suffix range, the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		if n == 0 {
+			return 0, -1, true
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	if start >= size {
+		return 0, -1, true
+	}
+	end := size - 1
+	if last != "" {
+		e, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || e < start {
+			return 0, 0, false
+		}
+		if e < end {
+			end = e
+		}
+	}
+	return start, end - start + 1, true
+}
+
+// If-Range, the range is only served if the validator is still current
+func staticIfRange(req *http.Request, etag string, modtime time.Time) bool {
+	x := req.Header.Get("If-Range")
+	if x == "" {
+		return true
+	}
+	if strings.HasPrefix(x, "\"") {
+		return x == etag
+	}
+	t, err := http.ParseTime(x)
+	return err == nil && modtime.Equal(t)
+}
+
+// body of the file, the file itself is closed when the application is done
+type staticBody struct {
+	io.Reader
+}
+
+func (s *staticBody) Close() error {
+	return nil
+}
+
+func (s *staticApplication) serveFile(
+	req *http.Request,
+	file fs.File,
+	info fs.FileInfo,
+	config *staticConfig,
+	w framework.HttpResponseWriter,
+) {
+	hdr := w.Header()
+	size := info.Size()
+	modtime := info.ModTime().UTC().Truncate(time.Second)
+	etag := staticETag(info)
+	seeker, seekable := file.(io.ReadSeeker)
+
+	hdr.Set("ETag", etag)
+	if !info.ModTime().IsZero() {
+		hdr.Set("Last-Modified", modtime.Format(http.TimeFormat))
+	}
+	if config.maxAge > 0 {
+		hdr.Set("Cache-Control", "max-age="+strconv.Itoa(config.maxAge))
+	}
+
+	if status := staticPrecondition(req, etag, modtime); status != 0 {
+		w.WriteStatus(status)
+		if status == http.StatusPreconditionFailed {
+			w.WriteBody(hpl.NewReadCloserFromString("precondition failed"))
+		} else {
+			w.WriteBody(hpl.NewEofReadCloser())
+		}
+		return
+	}
+
+	// the content type is sniffed from the head of the file if the extension
+	// does not tell
+	var body io.Reader = file
+	ctype := mime.TypeByExtension(path.Ext(info.Name()))
+	if ctype == "" {
+		head := make([]byte, sniffLen)
+		n, _ := io.ReadFull(file, head)
+		ctype = http.DetectContentType(head[:n])
+		if seekable {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				staticReply(w, http.StatusInternalServerError, "cannot seek file")
+				return
+			}
+		} else {
+			body = io.MultiReader(bytes.NewReader(head[:n]), file)
+		}
+	}
+	hdr.Set("Content-Type", ctype)
+
+	// range is only supported by the seekable file
+	status := http.StatusOK
+	length := size
+	if seekable {
+		hdr.Set("Accept-Ranges", "bytes")
+		if x := req.Header.Get("Range"); x != "" && staticIfRange(req, etag, modtime) {
+			if start, n, ok := staticRange(x, size); ok {
+				if n < 0 {
+					hdr.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+					staticReply(w, http.StatusRequestedRangeNotSatisfiable, "range not satisfiable")
+					return
+				}
+				if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+					staticReply(w, http.StatusInternalServerError, "cannot seek file")
+					return
+				}
+				status = http.StatusPartialContent
+				length = n
+				hdr.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+n-1, size))
+			}
+		}
+	}
+
+	hdr.Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteStatus(status)
+	if req.Method == http.MethodHead {
+		w.WriteBody(hpl.NewEofReadCloser())
+		return
+	}
+	w.WriteBody(&staticBody{Reader: io.LimitReader(body, length)})
+}
+
+func init() {
+	framework.AddApplicationFactory(
+		"static",
+		&staticFactory{},
+	)
+}
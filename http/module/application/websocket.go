@@ -0,0 +1,171 @@
+package application
+
+// Websocket service, upgrades the http request to websocket and hands the
+// connection to the script via an event. The event handler owns the session,
+// ie it reads and writes messages until it is done, once it returns the
+// connection is closed. Proxying to an upstream websocket is done by
+// connecting with http::websocket and relaying with the proxy method
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/websocket"
+)
+
+const (
+	defWebSocketEvent = "websocket"
+)
+
+type webSocketConfig struct {
+	event          string
+	subprotocols   []string
+	maxMessageSize int64
+}
+
+type webSocketApplication struct {
+	args   []pl.Val
+	config webSocketConfig
+}
+
+type webSocketFactory struct{}
+
+// prepare's returned result
+type webSocketInput struct {
+	request *http.Request
+	conn    *websocket.Conn
+}
+
+func (w *webSocketApplication) prepareConfig(
+	context framework.ServiceContext,
+) error {
+	cfg := hpl.NewPLConfig(
+		context.Runtime().Eval,
+		w.args,
+	)
+
+	protocols := ""
+	cfg.TryGetStr(0, &w.config.event, defWebSocketEvent)
+	cfg.TryGetStr(1, &protocols, "")
+	cfg.TryGetInt64(2, &w.config.maxMessageSize, websocket.DefaultMaxMessageSize)
+
+	w.config.subprotocols = nil
+	for _, x := range strings.Split(protocols, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			w.config.subprotocols = append(w.config.subprotocols, x)
+		}
+	}
+	return nil
+}
+
+func (w *webSocketApplication) Prepare(req *http.Request, _ hrouter.Params) (interface{}, error) {
+	if err := websocket.CheckUpgradeRequest(req); err != nil {
+		return nil, err
+	}
+	return &webSocketInput{
+		request: req,
+	}, nil
+}
+
+func (w *webSocketApplication) Accept(
+	ctx interface{},
+	context framework.ServiceContext,
+) (framework.ApplicationResult, error) {
+	if err := w.prepareConfig(context); err != nil {
+		return framework.ApplicationResult{}, err
+	}
+
+	param, ok := ctx.(*webSocketInput)
+	if !ok {
+		return framework.ApplicationResult{},
+			fmt.Errorf("module(websocket): input context parameter invalid")
+	}
+
+	hijacker, ok := context.(framework.ConnHijacker)
+	if !ok {
+		return framework.ApplicationResult{},
+			fmt.Errorf("module(websocket): service context does not support hijack")
+	}
+
+	conn, err := websocket.Upgrade(
+		param.request,
+		hijacker.Hijack,
+		w.config.subprotocols,
+	)
+	if err != nil {
+		return framework.ApplicationResult{}, err
+	}
+	conn.SetMaxMessageSize(w.config.maxMessageSize)
+	param.conn = conn
+
+	output := framework.NewApplicationResult(w.config.event)
+	output.AddContext(
+		"websocket",
+		hpl.NewWebSocketVal(conn),
+	)
+	output.AddContext(
+		"subprotocol",
+		pl.NewValStr(conn.Subprotocol()),
+	)
+	return output, nil
+}
+
+// the session is finished, closes the connection if the script does not
+func (w *webSocketApplication) Done(ctx interface{}) {
+	if param, ok := ctx.(*webSocketInput); ok && param.conn != nil {
+		param.conn.Close()
+	}
+}
+
+func (w *webSocketFactory) Name() string {
+	return "websocket"
+}
+
+func (w *webSocketFactory) Comment() string {
+	return `
+A service upgrades the http request to websocket. The request which is not a
+valid websocket handshake is rejected. It accepts following arguments
+
+1. event name, the event emitted once the connection is upgraded, by default
+   it is "websocket"
+2. subprotocols supported by the server separated by comma, the first one
+   requested by the client is selected
+3. max message size in bytes, by default it is 1MB
+
+The event handler owns the connection, the connection is closed once it
+returns. It exposes following module variable for user to use
+
+1. websocket, the connection which can be iterated to read messages and
+   supports read, readMessage, write, writeBinary, ping, close and proxy
+2. subprotocol, the negotiated subprotocol or empty string
+
+For example, an echo server is written as
+
+rule "websocket" {
+  for let _, msg = $.websocket {
+    $.websocket:write(msg);
+  }
+}
+
+And a proxy to upstream websocket is written as
+
+rule "websocket" {
+  $.websocket:proxy(http::websocket("ws://upstream/chat"));
+}
+`
+}
+
+func (w *webSocketFactory) Create(args []pl.Val) (framework.Application, error) {
+	return &webSocketApplication{
+		args: args,
+	}, nil
+}
+
+func init() {
+	framework.AddApplicationFactory("websocket", &webSocketFactory{})
+}
@@ -0,0 +1,34 @@
+package module
+
+import (
+	"fmt"
+	"github.com/dianpeng/moons/cache"
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/http/framework"
+)
+
+// GetCache resolves the cache used by the cache middleware. The argument at
+// index names a cache registered by cache::new or by a vhost, when it is
+// absent the cache of the current vhost is used
+func GetCache(
+	context string,
+	cfg *hpl.PLConfig,
+	index int,
+	ctx framework.ServiceContext,
+) (*cache.Cache, error) {
+	name := ""
+	cfg.TryGetStr(index, &name, "")
+	if name != "" {
+		if c := cache.Find(name); c != nil {
+			return c, nil
+		}
+		return nil, fmt.Errorf("%s: cache %s is not found", context, name)
+	}
+
+	if p, ok := ctx.(framework.CacheProvider); ok {
+		if c := p.Cache(); c != nil {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: vhost has no cache configured", context)
+}
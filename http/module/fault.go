@@ -0,0 +1,76 @@
+package module
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/dianpeng/moons/fault"
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+)
+
+// NewFaultInjector registers the injector of the fault middleware, the
+// arguments are the name of the injector and the faults, see
+// hpl.NewFaultOptionFromVal
+func NewFaultInjector(context string, args []pl.Val) (*fault.Injector, error) {
+	if len(args) != 2 || !args[0].IsString() {
+		return nil, fmt.Errorf("%s: expect name and faults", context)
+	}
+	option, err := hpl.NewFaultOptionFromVal(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", context, err.Error())
+	}
+	x, err := fault.Register(args[0].String(), option)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", context, err.Error())
+	}
+	return x, nil
+}
+
+// the connection is closed at once without any response, by RST if it is a
+// plain tcp one. The connection which cannot be taken over, ie HTTP/2, is
+// replied 502 instead
+func resetConn(w framework.HttpResponseWriter, ctx framework.ServiceContext) {
+	if h, ok := ctx.(framework.ConnHijacker); ok {
+		if conn, _, err := h.Hijack(); err == nil {
+			if tcp, ok := conn.(*net.TCPConn); ok {
+				tcp.SetLinger(0)
+			}
+			conn.Close()
+			return
+		}
+	}
+	w.ReplyNow(http.StatusBadGateway, "connection reset")
+}
+
+// InjectFault applies the delay, the reset and the abort of the plan, false is
+// returned if the transaction is ended by the fault. The throttle is left to
+// the middleware since the body differs by phase
+func InjectFault(
+	plan *fault.Plan,
+	r *http.Request,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	if plan.Delay > 0 {
+		t := time.NewTimer(plan.Delay)
+		select {
+		case <-t.C:
+			break
+		case <-r.Context().Done():
+			t.Stop()
+		}
+	}
+	if plan.Reset {
+		resetConn(w, ctx)
+		return false
+	}
+	if plan.Abort {
+		w.ReplyNow(plan.Status, plan.Body)
+		return false
+	}
+	return true
+}
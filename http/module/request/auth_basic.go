@@ -0,0 +1,145 @@
+package request
+
+// authenticates the request by the basic scheme(RFC 7617), the request without
+// valid credential is rejected with 401 and the challenge. Once authenticated,
+// identity of the session is {scheme: "basic", user: <user name>}. Arguments:
+//   0) users, a map of user name to password, or a closure called with the
+//      user name and password which returns whether the credential is valid
+//   1) realm of the challenge, default is "moons"
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+)
+
+const defaultRealm = "moons"
+
+// quoted-string of the auth-param
+func authParam(name string, value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return fmt.Sprintf(`%s="%s"`, name, value)
+}
+
+// rejects the request with the challenge, the challenge is omitted if empty
+func replyAuth(w framework.HttpResponseWriter, status int, challenge string) {
+	if challenge != "" {
+		w.Header().Set("WWW-Authenticate", challenge)
+	}
+	if status == http.StatusForbidden {
+		w.ReplyNow(status, "forbidden")
+	} else {
+		w.ReplyNow(status, "unauthorized")
+	}
+}
+
+type authBasic struct {
+	args []pl.Val
+}
+
+func (c *authBasic) Name() string {
+	return "request.auth_basic"
+}
+
+func (c *authBasic) check(
+	user string,
+	password string,
+	cfg *hpl.PLConfig,
+	ctx framework.ServiceContext,
+) (bool, error) {
+	if len(c.args) == 0 {
+		return false, fmt.Errorf("users is not specified")
+	}
+
+	if c.args[0].IsClosure() {
+		v, err := c.args[0].Closure().Call(
+			ctx.Runtime().Eval,
+			[]pl.Val{pl.NewValStr(user), pl.NewValStr(password)},
+		)
+		if err != nil {
+			return false, err
+		}
+		return v.ToBoolean(), nil
+	}
+
+	var users pl.Val
+	if err := cfg.Get(0, &users); err != nil {
+		return false, err
+	}
+	if !users.IsMap() {
+		return false, fmt.Errorf("users must be map or closure")
+	}
+	expect, ok := users.Map().Get(user)
+	if !ok || !expect.IsString() {
+		return false, nil
+	}
+	return subtle.ConstantTimeCompare([]byte(expect.String()), []byte(password)) == 1, nil
+}
+
+func (c *authBasic) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	cfg := hpl.NewPLConfig(
+		ctx.Runtime().Eval,
+		c.args,
+	)
+
+	realm := defaultRealm
+	cfg.TryGetStr(1, &realm, realm)
+	challenge := "Basic " + authParam("realm", realm) + `, charset="UTF-8"`
+
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		replyAuth(w, http.StatusUnauthorized, challenge)
+		return false
+	}
+
+	valid, err := c.check(user, password, &cfg, ctx)
+	if err != nil {
+		w.ReplyError("request.auth_basic", 500, err)
+		return false
+	}
+	if !valid {
+		replyAuth(w, http.StatusUnauthorized, challenge)
+		return false
+	}
+
+	identity := pl.NewValMap()
+	identity.AddMap("scheme", pl.NewValStr("basic"))
+	identity.AddMap("user", pl.NewValStr(user))
+	ctx.Runtime().SetIdentity(identity)
+	return true
+}
+
+type authBasicFactory struct{}
+
+func (c *authBasicFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	return &authBasic{
+		args: x,
+	}, nil
+}
+
+func (c *authBasicFactory) Name() string {
+	return "request.auth_basic"
+}
+
+func (c *authBasicFactory) Comment() string {
+	return "authenticate the request by basic scheme"
+}
+
+func init() {
+	framework.AddRequestFactory(
+		"auth_basic",
+		&authBasicFactory{},
+	)
+}
@@ -0,0 +1,170 @@
+package request
+
+// authenticates the webhook request by the HMAC of its body signed with the
+// shared secret, the signature is in the header either as is or prefixed by
+// the algorithm like "sha256=<signature>", and encoded in hex or base64. The
+// body is read and cached so the application still sees it, put body_limit
+// before to bound it. The request without valid signature is rejected with
+// 401. Once authenticated, identity of the session is {scheme: "hmac",
+// header: <header>}. Arguments:
+//   0) header of the signature
+//   1) secret
+//   2) algorithm, one of sha1, sha256 and sha512, default is sha256
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+)
+
+func hmacHashOf(algo string) (func() hash.Hash, error) {
+	switch algo {
+	case "sha1":
+		return sha1.New, nil
+	case "sha256":
+		return sha256.New, nil
+	case "sha512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", algo)
+	}
+}
+
+// decodes the signature of the header value, nil if it is malformed
+func decodeSignature(value string, algo string, size int) []byte {
+	value = strings.TrimSpace(value)
+	if i := strings.IndexByte(value, '='); i > 0 && strings.EqualFold(value[:i], algo) {
+		value = value[i+1:]
+	}
+	if len(value) == 2*size {
+		if b, err := hex.DecodeString(value); err == nil {
+			return b
+		}
+	}
+	if b, err := base64.StdEncoding.DecodeString(value); err == nil && len(b) == size {
+		return b
+	}
+	return nil
+}
+
+// reads the whole body, the body is cached and put back to the request and
+// the request value of the script
+func readBody(r *http.Request, ctx framework.ServiceContext) ([]byte, error) {
+	if body := scriptBody(ctx); body != nil {
+		buf, err := body.Stream().CacheBuffer()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body.Stream().Stream
+		return buf, nil
+	}
+
+	if r.Body == nil {
+		return nil, nil
+	}
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(buf))
+	return buf, nil
+}
+
+type authHMAC struct {
+	args []pl.Val
+}
+
+func (c *authHMAC) Name() string {
+	return "request.auth_hmac"
+}
+
+func (c *authHMAC) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	cfg := hpl.NewPLConfig(
+		ctx.Runtime().Eval,
+		c.args,
+	)
+
+	var header, secret string
+	if err := cfg.GetStr(0, &header); err != nil {
+		w.ReplyError("request.auth_hmac", 500, err)
+		return false
+	}
+	if err := cfg.GetStr(1, &secret); err != nil {
+		w.ReplyError("request.auth_hmac", 500, err)
+		return false
+	}
+	algo := "sha256"
+	cfg.TryGetStr(2, &algo, algo)
+	h, err := hmacHashOf(algo)
+	if err != nil {
+		w.ReplyError("request.auth_hmac", 500, err)
+		return false
+	}
+
+	mac := hmac.New(h, []byte(secret))
+	signature := decodeSignature(r.Header.Get(header), algo, mac.Size())
+	if signature == nil {
+		replyAuth(w, http.StatusUnauthorized, "")
+		return false
+	}
+
+	body, err := readBody(r, ctx)
+	if err != nil {
+		// the body_limit or read_timeout may have replied already
+		w.ReplyError("request.auth_hmac", 400, err)
+		return false
+	}
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		replyAuth(w, http.StatusUnauthorized, "")
+		return false
+	}
+
+	identity := pl.NewValMap()
+	identity.AddMap("scheme", pl.NewValStr("hmac"))
+	identity.AddMap("header", pl.NewValStr(header))
+	ctx.Runtime().SetIdentity(identity)
+	return true
+}
+
+type authHMACFactory struct{}
+
+func (c *authHMACFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	return &authHMAC{
+		args: x,
+	}, nil
+}
+
+func (c *authHMACFactory) Name() string {
+	return "request.auth_hmac"
+}
+
+func (c *authHMACFactory) Comment() string {
+	return "authenticate the webhook request by HMAC of the body"
+}
+
+func init() {
+	framework.AddRequestFactory(
+		"auth_hmac",
+		&authHMACFactory{},
+	)
+}
@@ -0,0 +1,225 @@
+package request
+
+// authenticates the request by the bearer JWT(RFC 6750) of the Authorization
+// header, verified by the JWKS of the issuer. The request without token or
+// with invalid, expired token is rejected with 401, the token whose claims
+// fail the checks is rejected with 403, both carry the bearer challenge. Once
+// authenticated, identity of the session is {scheme: "jwt", subject: <sub>,
+// claims: <claims>}. Arguments:
+//   0) url of the JWKS, the key set is cached and shared by the url
+//   1) claims checks, either a map of claim name to the expected value, a list
+//      means any of them, or a closure called with the claims which returns
+//      whether the claims are accepted
+//   2) realm of the challenge, default is "moons"
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/jwt"
+	"github.com/dianpeng/moons/pl"
+)
+
+// allowed clock skew between the issuer and us when checking exp and nbf
+const jwtLeeway = 30 * time.Second
+
+func claimEqual(actual interface{}, expect pl.Val) bool {
+	switch a := actual.(type) {
+	case string:
+		return expect.IsString() && expect.String() == a
+	case float64:
+		return (expect.IsInt() && float64(expect.Int()) == a) ||
+			(expect.IsReal() && expect.Real() == a)
+	case bool:
+		return expect.IsBool() && expect.Bool() == a
+	default:
+		return false
+	}
+}
+
+// the claim of the token matches the expected value, the list claim, ie aud,
+// matches if any of its element matches. The scope claims are space separated
+// list in string
+func claimMatch(name string, actual interface{}, expect pl.Val) bool {
+	if expect.IsList() {
+		l := expect.List()
+		for i := 0; i < l.Length(); i++ {
+			if claimMatch(name, actual, l.At(i)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if s, ok := actual.(string); ok && (name == "scope" || name == "scp") {
+		for _, x := range strings.Fields(s) {
+			if claimEqual(x, expect) {
+				return true
+			}
+		}
+		return false
+	}
+	if l, ok := actual.([]interface{}); ok {
+		for _, x := range l {
+			if claimEqual(x, expect) {
+				return true
+			}
+		}
+		return false
+	}
+	return claimEqual(actual, expect)
+}
+
+type authJWT struct {
+	args []pl.Val
+}
+
+func (c *authJWT) Name() string {
+	return "request.auth_jwt"
+}
+
+func (c *authJWT) checkClaims(
+	token *jwt.Token,
+	claims pl.Val,
+	cfg *hpl.PLConfig,
+	ctx framework.ServiceContext,
+) (bool, error) {
+	if len(c.args) < 2 {
+		return true, nil
+	}
+
+	if c.args[1].IsClosure() {
+		v, err := c.args[1].Closure().Call(
+			ctx.Runtime().Eval,
+			[]pl.Val{claims},
+		)
+		if err != nil {
+			return false, err
+		}
+		return v.ToBoolean(), nil
+	}
+
+	var checks pl.Val
+	if err := cfg.Get(1, &checks); err != nil {
+		return false, err
+	}
+	if checks.IsNull() {
+		return true, nil
+	}
+	if !checks.IsMap() {
+		return false, fmt.Errorf("claims checks must be map or closure")
+	}
+
+	ok := true
+	checks.Map().Foreach(
+		func(name string, expect pl.Val) bool {
+			actual, has := token.Claims[name]
+			ok = has && claimMatch(name, actual, expect)
+			return ok
+		},
+	)
+	return ok, nil
+}
+
+func (c *authJWT) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	cfg := hpl.NewPLConfig(
+		ctx.Runtime().Eval,
+		c.args,
+	)
+
+	var url string
+	if err := cfg.GetStr(0, &url); err != nil {
+		w.ReplyError("request.auth_jwt", 500, err)
+		return false
+	}
+	realm := defaultRealm
+	cfg.TryGetStr(2, &realm, realm)
+	challenge := "Bearer " + authParam("realm", realm)
+
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		replyAuth(w, http.StatusUnauthorized, challenge)
+		return false
+	}
+
+	invalid := func(err error) {
+		replyAuth(w, http.StatusUnauthorized, challenge+", "+
+			authParam("error", "invalid_token")+", "+
+			authParam("error_description", err.Error()))
+	}
+
+	token, err := jwt.Parse(strings.TrimSpace(auth[7:]))
+	if err != nil {
+		invalid(err)
+		return false
+	}
+	if err := jwt.Open(url).Verify(token); err != nil {
+		if errors.Is(err, jwt.ErrKeySet) {
+			w.ReplyError("request.auth_jwt", 503, err)
+		} else {
+			invalid(err)
+		}
+		return false
+	}
+	if err := token.ValidateTime(time.Now(), jwtLeeway); err != nil {
+		invalid(err)
+		return false
+	}
+
+	claims, err := pl.NewValFromJSON(string(token.RawClaims))
+	if err != nil {
+		invalid(err)
+		return false
+	}
+	accepted, err := c.checkClaims(token, claims, &cfg, ctx)
+	if err != nil {
+		w.ReplyError("request.auth_jwt", 500, err)
+		return false
+	}
+	if !accepted {
+		replyAuth(w, http.StatusForbidden, challenge+", "+
+			authParam("error", "insufficient_scope"))
+		return false
+	}
+
+	identity := pl.NewValMap()
+	identity.AddMap("scheme", pl.NewValStr("jwt"))
+	identity.AddMap("subject", pl.NewValStr(token.Subject()))
+	identity.AddMap("claims", claims)
+	ctx.Runtime().SetIdentity(identity)
+	return true
+}
+
+type authJWTFactory struct{}
+
+func (c *authJWTFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	return &authJWT{
+		args: x,
+	}, nil
+}
+
+func (c *authJWTFactory) Name() string {
+	return "request.auth_jwt"
+}
+
+func (c *authJWTFactory) Comment() string {
+	return "authenticate the request by bearer JWT verified with JWKS"
+}
+
+func init() {
+	framework.AddRequestFactory(
+		"auth_jwt",
+		&authJWTFactory{},
+	)
+}
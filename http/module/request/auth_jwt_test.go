@@ -0,0 +1,52 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/dianpeng/moons/pl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimMatch(t *testing.T) {
+	list := func(x ...pl.Val) pl.Val {
+		return pl.NewValListRaw(x)
+	}
+
+	cases := []struct {
+		name   string
+		claim  string
+		actual interface{}
+		expect pl.Val
+		ok     bool
+	}{
+		{"string", "iss", "https://issuer", pl.NewValStr("https://issuer"), true},
+		{"string mismatch", "iss", "https://issuer", pl.NewValStr("https://other"), false},
+		{"string against int", "iss", "1", pl.NewValInt(1), false},
+		{"int", "level", float64(3), pl.NewValInt(3), true},
+		{"int mismatch", "level", float64(3), pl.NewValInt(4), false},
+		{"real", "level", float64(1.5), pl.NewValReal(1.5), true},
+		{"int against string", "level", float64(3), pl.NewValStr("3"), false},
+		{"bool", "admin", true, pl.NewValBool(true), true},
+		{"bool mismatch", "admin", false, pl.NewValBool(true), false},
+		{"null claim", "admin", nil, pl.NewValNull(), false},
+		{"object claim", "ext", map[string]interface{}{}, pl.NewValStr("x"), false},
+
+		{"any of expect", "iss", "b", list(pl.NewValStr("a"), pl.NewValStr("b")), true},
+		{"none of expect", "iss", "c", list(pl.NewValStr("a"), pl.NewValStr("b")), false},
+		{"empty expect", "iss", "a", list(), false},
+
+		{"aud list", "aud", []interface{}{"api", "web"}, pl.NewValStr("web"), true},
+		{"aud list mismatch", "aud", []interface{}{"api", "web"}, pl.NewValStr("cli"), false},
+		{"aud list any of", "aud", []interface{}{"api"}, list(pl.NewValStr("cli"), pl.NewValStr("api")), true},
+
+		{"scope", "scope", "read write", pl.NewValStr("write"), true},
+		{"scope mismatch", "scope", "read write", pl.NewValStr("admin"), false},
+		{"scope partial", "scope", "read:all", pl.NewValStr("read"), false},
+		{"scp", "scp", "read  write", pl.NewValStr("write"), true},
+		{"space in other claim", "name", "read write", pl.NewValStr("write"), false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.ok, claimMatch(c.claim, c.actual, c.expect), c.name)
+	}
+}
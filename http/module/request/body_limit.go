@@ -0,0 +1,178 @@
+package request
+
+// rejects the request whose body is larger than the limit. The request with
+// a larger Content-Length is rejected at once, otherwise the body is counted
+// while it is read, ie by body:string() of the script, and the reading fails
+// once the limit is exceeded and the rejected response is sent. Arguments:
+//   0) limit of the body in bytes
+//   1) status of the rejected response, default is 413
+//   2) body of the rejected response
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+)
+
+// the body value of the request seen by the script, nil if the session has
+// no request value
+func scriptBody(ctx framework.ServiceContext) *hpl.Body {
+	reqVal := ctx.Runtime().Request()
+	if !hpl.ValIsHttpRequest(reqVal) {
+		return nil
+	}
+	bodyVal := reqVal.Usr().(*hpl.Request).Body()
+	if !hpl.ValIsHttpBody(bodyVal) {
+		return nil
+	}
+	return bodyVal.Usr().(*hpl.Body)
+}
+
+// replaces the body of the request, both the http.Request and the request
+// value of the script, with the wrapped one. The body already replaced or
+// cached by the session is left as is since it is not read from the client
+func wrapBody(
+	r *http.Request,
+	ctx framework.ServiceContext,
+	wrap func(io.ReadCloser) io.ReadCloser,
+) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+
+	body := scriptBody(ctx)
+	if body != nil && body.Stream().Stream != r.Body {
+		return
+	}
+
+	r.Body = wrap(r.Body)
+	if body != nil {
+		body.SetStream(r.Body)
+	}
+}
+
+// replies the rejected response once, the reading of the body may fail more
+// than once. The rest of the body is not read so the connection is closed
+// after the response, otherwise the server drains the body before replying
+type bodyReject struct {
+	once   sync.Once
+	w      framework.HttpResponseWriter
+	status int
+	body   string
+}
+
+func (b *bodyReject) reply() {
+	b.once.Do(func() {
+		b.w.Header().Set("Connection", "close")
+		b.w.ReplyNow(b.status, b.body)
+	})
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	remain int64
+	reject *bodyReject
+	limit  int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remain < 0 {
+		return 0, fmt.Errorf("request.body_limit: body exceeds %d bytes", l.limit)
+	}
+
+	// read one byte more than the remain to tell whether the body exceeds
+	if int64(len(p)) > l.remain+1 {
+		p = p[:l.remain+1]
+	}
+	n, err := l.ReadCloser.Read(p)
+	if int64(n) > l.remain {
+		n = int(l.remain)
+		l.remain = -1
+		l.reject.reply()
+		return n, fmt.Errorf("request.body_limit: body exceeds %d bytes", l.limit)
+	}
+	l.remain -= int64(n)
+	return n, err
+}
+
+type bodyLimit struct {
+	args []pl.Val
+}
+
+func (c *bodyLimit) Name() string {
+	return "request.body_limit"
+}
+
+func (c *bodyLimit) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	cfg := hpl.NewPLConfig(
+		ctx.Runtime().Eval,
+		c.args,
+	)
+
+	var limit int64
+	if err := cfg.GetInt64(0, &limit); err != nil {
+		w.ReplyError("request.body_limit", 500, err)
+		return false
+	}
+	if limit < 0 {
+		w.ReplyError("request.body_limit", 500, fmt.Errorf("limit must be non negative"))
+		return false
+	}
+
+	reject := &bodyReject{
+		w:      w,
+		status: http.StatusRequestEntityTooLarge,
+		body:   "request entity too large",
+	}
+	cfg.TryGetInt(1, &reject.status, reject.status)
+	cfg.TryGetStr(2, &reject.body, reject.body)
+
+	if r.ContentLength > limit {
+		reject.reply()
+		return false
+	}
+
+	wrapBody(r, ctx, func(body io.ReadCloser) io.ReadCloser {
+		return &limitedBody{
+			ReadCloser: body,
+			remain:     limit,
+			reject:     reject,
+			limit:      limit,
+		}
+	})
+	return true
+}
+
+type bodyLimitFactory struct{}
+
+func (c *bodyLimitFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	return &bodyLimit{
+		args: x,
+	}, nil
+}
+
+func (c *bodyLimitFactory) Name() string {
+	return "request.body_limit"
+}
+
+func (c *bodyLimitFactory) Comment() string {
+	return "reject the request whose body exceeds the limit"
+}
+
+func init() {
+	framework.AddRequestFactory(
+		"body_limit",
+		&bodyLimitFactory{},
+	)
+}
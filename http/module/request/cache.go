@@ -0,0 +1,114 @@
+package request
+
+// serves the request from the cache when a fresh response is stored, or a
+// stale one within its stale-while-revalidate window while another request
+// is refreshing it. The validators of the request are honored, ie 304 is
+// replied when If-None-Match matches. Pairs with the response cache
+// middleware which stores the response. Arguments:
+//   0) name of the cache, default is the vhost's cache
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dianpeng/moons/cache"
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/http/module"
+	"github.com/dianpeng/moons/pl"
+)
+
+type cacheLookup struct {
+	args []pl.Val
+}
+
+func (c *cacheLookup) Name() string {
+	return "request.cache"
+}
+
+func (c *cacheLookup) serve(
+	r *http.Request,
+	e *cache.Entry,
+	state int,
+	w framework.HttpResponseWriter,
+) {
+	hdr := e.Header.Clone()
+	if hdr == nil {
+		hdr = make(http.Header)
+	}
+	hdr.Set("Age", strconv.FormatInt(e.Age(time.Now()), 10))
+	if state == cache.StateHit {
+		hdr.Set("X-Cache", "HIT")
+	} else {
+		hdr.Set("X-Cache", "STALE")
+	}
+
+	if e.Status == http.StatusOK && cache.NotModified(r, hdr) {
+		hdr.Del("Content-Length")
+		w.SetHeader(hdr)
+		w.WriteStatus(http.StatusNotModified)
+		w.WriteBody(nil)
+	} else {
+		w.SetHeader(hdr)
+		w.WriteStatus(e.Status)
+		w.WriteBody(io.NopCloser(bytes.NewReader(e.Body)))
+	}
+	w.Flush()
+}
+
+func (c *cacheLookup) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	cfg := hpl.NewPLConfig(
+		ctx.Runtime().Eval,
+		c.args,
+	)
+
+	x, err := module.GetCache("request.cache", &cfg, 0, ctx)
+	if err != nil {
+		w.ReplyError("request.cache", 500, err)
+		return false
+	}
+
+	e, state := x.Lookup(r)
+	if state == cache.StateMiss {
+		if cache.ParseControl(r.Header.Values("Cache-Control")).Has("only-if-cached") {
+			w.ReplyNow(http.StatusGatewayTimeout, "")
+			return false
+		}
+		return true
+	}
+
+	c.serve(r, e, state, w)
+	return false
+}
+
+type cacheLookupFactory struct{}
+
+func (c *cacheLookupFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	return &cacheLookup{
+		args: x,
+	}, nil
+}
+
+func (c *cacheLookupFactory) Name() string {
+	return "request.cache"
+}
+
+func (c *cacheLookupFactory) Comment() string {
+	return "serve the request from the http cache"
+}
+
+func init() {
+	framework.AddRequestFactory(
+		"cache",
+		&cacheLookupFactory{},
+	)
+}
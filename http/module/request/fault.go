@@ -0,0 +1,75 @@
+package request
+
+// injects the faults into the request for resilience testing, the request is
+// delayed, aborted with the status, reset without response, or its body is
+// read slowly, each at the sampled rate. The faults of the name are shared by
+// the services and can be changed at runtime by the admin API. Arguments:
+//   0) name of the faults
+//   1) faults, ie {"delay": {"rate": 0.1, "latency": 200}}, see hpl/fault.go
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/dianpeng/moons/fault"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/http/module"
+	"github.com/dianpeng/moons/pl"
+)
+
+type faultInjector struct {
+	injector *fault.Injector
+}
+
+func (c *faultInjector) Name() string {
+	return "request.fault"
+}
+
+func (c *faultInjector) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	plan := c.injector.Decide(r)
+	if plan == nil {
+		return true
+	}
+	if !module.InjectFault(plan, r, w, ctx) {
+		return false
+	}
+	if plan.BytesPerSecond > 0 {
+		wrapBody(r, ctx, func(body io.ReadCloser) io.ReadCloser {
+			return fault.Throttle(body, plan.BytesPerSecond)
+		})
+	}
+	return true
+}
+
+type faultFactory struct{}
+
+func (c *faultFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	injector, err := module.NewFaultInjector("request.fault", x)
+	if err != nil {
+		return nil, err
+	}
+	return &faultInjector{
+		injector: injector,
+	}, nil
+}
+
+func (c *faultFactory) Name() string {
+	return "request.fault"
+}
+
+func (c *faultFactory) Comment() string {
+	return "inject delay, abort, reset and throttle into the request"
+}
+
+func init() {
+	framework.AddRequestFactory(
+		"fault",
+		&faultFactory{},
+	)
+}
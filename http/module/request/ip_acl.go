@@ -0,0 +1,153 @@
+package request
+
+// allows or denies the request by the client address. The request from the
+// deny list is rejected, otherwise the request is rejected unless the allow
+// list is empty or has the address. The lists are compiled once the service is
+// created. Behind trusted proxies, the client address is taken from the
+// X-Forwarded-For header skipping the addresses appended by them. Arguments:
+//   0) allow list of CIDR or address, null or empty list allows everyone
+//   1) deny list of CIDR or address
+//   2) number of trusted proxies in front of us, default is 0 which uses the
+//      address of the connection and ignores X-Forwarded-For
+//   3) status of the rejected response, default is 403
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/util"
+)
+
+func newCIDRSetFromVal(v pl.Val, name string) (*util.CIDRSet, error) {
+	cidr := []string{}
+	switch {
+	case v.IsNull():
+		break
+	case v.IsString():
+		cidr = append(cidr, v.String())
+	case v.IsList():
+		l := v.List()
+		for i := 0; i < l.Length(); i++ {
+			x := l.At(i)
+			if !x.IsString() {
+				return nil, fmt.Errorf("%s must be list of string", name)
+			}
+			cidr = append(cidr, x.String())
+		}
+	default:
+		return nil, fmt.Errorf("%s must be list of string", name)
+	}
+
+	s, err := util.NewCIDRSet(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("%s is invalid: %s", name, err.Error())
+	}
+	return s, nil
+}
+
+// the address of the client, the trusted proxies each appends the address of
+// its peer to the X-Forwarded-For header, so the client is the one before the
+// last hops addresses of the header and the connection. If the header is
+// shorter, the request does not come through the proxies and the address of
+// the connection is used
+func forwardedIP(r *http.Request, hops int) net.IP {
+	addr := []string{}
+	if hops > 0 {
+		for _, h := range r.Header.Values("X-Forwarded-For") {
+			for _, x := range strings.Split(h, ",") {
+				if x = strings.TrimSpace(x); x != "" {
+					addr = append(addr, x)
+				}
+			}
+		}
+	}
+	addr = append(addr, clientIP(r))
+
+	i := len(addr) - 1 - hops
+	if i < 0 {
+		i = len(addr) - 1
+	}
+	return net.ParseIP(addr[i])
+}
+
+type ipACL struct {
+	args  []pl.Val
+	allow *util.CIDRSet
+	deny  *util.CIDRSet
+}
+
+func (c *ipACL) Name() string {
+	return "request.ip_acl"
+}
+
+func (c *ipACL) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	cfg := hpl.NewPLConfig(
+		ctx.Runtime().Eval,
+		c.args,
+	)
+
+	hops := 0
+	status := http.StatusForbidden
+	cfg.TryGetInt(2, &hops, hops)
+	cfg.TryGetInt(3, &status, status)
+
+	ip := forwardedIP(r, hops)
+	if ip == nil ||
+		c.deny.Contains(ip) ||
+		(!c.allow.Empty() && !c.allow.Contains(ip)) {
+		w.ReplyNow(status, "forbidden")
+		return false
+	}
+	return true
+}
+
+type ipACLFactory struct{}
+
+func (c *ipACLFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	m := &ipACL{
+		args: x,
+	}
+
+	allow, deny := pl.NewValNull(), pl.NewValNull()
+	if len(x) > 0 {
+		allow = x[0]
+	}
+	if len(x) > 1 {
+		deny = x[1]
+	}
+
+	var err error
+	if m.allow, err = newCIDRSetFromVal(allow, "request.ip_acl allow"); err != nil {
+		return nil, err
+	}
+	if m.deny, err = newCIDRSetFromVal(deny, "request.ip_acl deny"); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *ipACLFactory) Name() string {
+	return "request.ip_acl"
+}
+
+func (c *ipACLFactory) Comment() string {
+	return "allow or deny the request by the client address"
+}
+
+func init() {
+	framework.AddRequestFactory(
+		"ip_acl",
+		&ipACLFactory{},
+	)
+}
@@ -0,0 +1,262 @@
+package request
+
+// mirrors the sampled requests to the shadow upstream. The mirrored request is
+// queued and sent in background, its response is discarded and never affects
+// the primary one; when the queue is full the request is not mirrored. The
+// requests of the same upstream share one queue, created by the first service
+// using it. The request with body larger than the limit or of unknown length
+// is not mirrored. Arguments:
+//   0) upstream, the url whose scheme and host, and path as prefix, replace
+//      the ones of the request
+//   1) sample rate in 0 to 1, default is 1 which mirrors all the requests
+//   2) options map, optional
+//      queue, size of the queue, default is 1024
+//      workers, number of the background senders, default is 4
+//      timeout, timeout of the mirrored request in ms, default is 5000
+//      max_body, max body size in bytes to mirror, default is 1MB
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+)
+
+const (
+	mirrorDefaultQueue   = 1024
+	mirrorDefaultWorkers = 4
+	mirrorDefaultTimeout = 5000
+	mirrorDefaultMaxBody = 1 << 20
+)
+
+type mirrorOption struct {
+	queue   int
+	workers int
+	timeout int64
+	maxBody int64
+}
+
+// bounded queue of the mirrored requests of one upstream
+type mirrorQueue struct {
+	q      chan *http.Request
+	client *http.Client
+}
+
+var (
+	mirrorQueueLock sync.Mutex
+	mirrorQueueMap  = make(map[string]*mirrorQueue)
+)
+
+func mirrorQueueOf(upstream string, opt mirrorOption) *mirrorQueue {
+	mirrorQueueLock.Lock()
+	defer mirrorQueueLock.Unlock()
+	if m, ok := mirrorQueueMap[upstream]; ok {
+		return m
+	}
+
+	m := &mirrorQueue{
+		q: make(chan *http.Request, opt.queue),
+		client: &http.Client{
+			Timeout: time.Duration(opt.timeout) * time.Millisecond,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	for i := 0; i < opt.workers; i++ {
+		go m.run()
+	}
+	mirrorQueueMap[upstream] = m
+	return m
+}
+
+func (m *mirrorQueue) run() {
+	for req := range m.q {
+		resp, err := m.client.Do(req)
+		if err != nil {
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+// queues the request without blocking, false if the queue is full
+func (m *mirrorQueue) push(req *http.Request) bool {
+	select {
+	case m.q <- req:
+		return true
+	default:
+		return false
+	}
+}
+
+type mirror struct {
+	args     []pl.Val
+	upstream *url.URL
+	maxBody  int64
+	queue    *mirrorQueue
+}
+
+func (c *mirror) Name() string {
+	return "request.mirror"
+}
+
+func (c *mirror) newRequest(r *http.Request, body []byte) (*http.Request, error) {
+	u := *c.upstream
+	u.Path = strings.TrimSuffix(u.Path, "/") + r.URL.Path
+	u.RawPath = ""
+	u.RawQuery = r.URL.RawQuery
+
+	req, err := http.NewRequest(r.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = r.Header.Clone()
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	if len(body) == 0 {
+		req.Body = http.NoBody
+		req.GetBody = nil
+	}
+	return req, nil
+}
+
+func (c *mirror) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	cfg := hpl.NewPLConfig(
+		ctx.Runtime().Eval,
+		c.args,
+	)
+
+	rate := 1.0
+	var v pl.Val
+	cfg.TryGet(1, &v, pl.NewValNull())
+	switch {
+	case v.IsReal():
+		rate = v.Real()
+	case v.IsInt():
+		rate = float64(v.Int())
+	case v.IsNull():
+		break
+	default:
+		w.ReplyError("request.mirror", 500, fmt.Errorf("sample rate must be number"))
+		return false
+	}
+	if rate <= 0 || (rate < 1 && rand.Float64() >= rate) {
+		return true
+	}
+	if r.ContentLength < 0 || r.ContentLength > c.maxBody {
+		return true
+	}
+
+	var body []byte
+	if r.ContentLength > 0 {
+		buf, err := readBody(r, ctx)
+		if err != nil {
+			w.ReplyError("request.mirror", 500, err)
+			return false
+		}
+		body = buf
+	}
+
+	// the mirror must not affect the primary request, so the failure is
+	// ignored
+	if req, err := c.newRequest(r, body); err == nil {
+		c.queue.push(req)
+	}
+	return true
+}
+
+func newMirrorOption(x []pl.Val) (mirrorOption, error) {
+	opt := mirrorOption{
+		queue:   mirrorDefaultQueue,
+		workers: mirrorDefaultWorkers,
+		timeout: mirrorDefaultTimeout,
+		maxBody: mirrorDefaultMaxBody,
+	}
+	if len(x) <= 2 || x[2].IsNull() {
+		return opt, nil
+	}
+	if !x[2].IsMap() {
+		return opt, fmt.Errorf("request.mirror option must be map")
+	}
+
+	var err error
+	x[2].Map().Foreach(
+		func(key string, val pl.Val) bool {
+			if !val.IsInt() || val.Int() <= 0 {
+				err = fmt.Errorf("request.mirror option %s must be positive int", key)
+				return false
+			}
+			switch key {
+			case "queue":
+				opt.queue = int(val.Int())
+			case "workers":
+				opt.workers = int(val.Int())
+			case "timeout":
+				opt.timeout = val.Int()
+			case "max_body":
+				opt.maxBody = val.Int()
+			default:
+				err = fmt.Errorf("request.mirror option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+	return opt, err
+}
+
+type mirrorFactory struct{}
+
+func (c *mirrorFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	if len(x) == 0 || !x[0].IsString() {
+		return nil, fmt.Errorf("request.mirror upstream must be string")
+	}
+	u, err := url.Parse(x[0].String())
+	if err != nil {
+		return nil, fmt.Errorf("request.mirror upstream is invalid: %s", err.Error())
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("request.mirror upstream must be http(s) url")
+	}
+
+	opt, err := newMirrorOption(x)
+	if err != nil {
+		return nil, err
+	}
+	return &mirror{
+		args:     x,
+		upstream: u,
+		maxBody:  opt.maxBody,
+		queue:    mirrorQueueOf(x[0].String(), opt),
+	}, nil
+}
+
+func (c *mirrorFactory) Name() string {
+	return "request.mirror"
+}
+
+func (c *mirrorFactory) Comment() string {
+	return "mirror the sampled requests to the shadow upstream"
+}
+
+func init() {
+	framework.AddRequestFactory(
+		"mirror",
+		&mirrorFactory{},
+	)
+}
@@ -0,0 +1,157 @@
+package request
+
+// rejects the request once its key exceeds the limiter, the limit state is
+// reported by X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset
+// and the rejected response carries Retry-After. Arguments:
+//   0) limiter, a ratelimit.limiter value or the key space of ratelimit::new
+//   1) key, "ip" which is the default, "header:<name>", "query:<name>", or a
+//      closure returning the key
+//   2) status of the rejected response, default is 429
+//   3) body of the rejected response
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/ratelimit"
+)
+
+type rateLimit struct {
+	args []pl.Val
+}
+
+func (c *rateLimit) Name() string {
+	return "request.ratelimit"
+}
+
+func (c *rateLimit) limiter(cfg *hpl.PLConfig) (*ratelimit.Limiter, error) {
+	var v pl.Val
+	if err := cfg.Get(0, &v); err != nil {
+		return nil, fmt.Errorf("limiter is not specified")
+	}
+	if hpl.ValIsRateLimiter(v) {
+		return v.Usr().(*hpl.RateLimiter).Limiter(), nil
+	}
+	if v.IsString() {
+		if l := ratelimit.Find(v.String()); l != nil {
+			return l, nil
+		}
+		return nil, fmt.Errorf("limiter %s is not found", v.String())
+	}
+	return nil, fmt.Errorf("limiter must be ratelimit.limiter or key space name")
+}
+
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// the key of the request, the requests without the header or query share the
+// empty key so they cannot bypass the limiter
+func (c *rateLimit) key(r *http.Request, cfg *hpl.PLConfig) (string, error) {
+	if len(c.args) < 2 {
+		return clientIP(r), nil
+	}
+
+	var v pl.Val
+	if err := cfg.Get(1, &v); err != nil {
+		return "", err
+	}
+	if c.args[1].IsClosure() {
+		return v.ToString()
+	}
+	if !v.IsString() {
+		return "", fmt.Errorf("key must be string or closure")
+	}
+
+	spec := v.String()
+	switch {
+	case spec == "ip":
+		return clientIP(r), nil
+	case strings.HasPrefix(spec, "header:"):
+		return r.Header.Get(spec[7:]), nil
+	case strings.HasPrefix(spec, "query:"):
+		return r.URL.Query().Get(spec[6:]), nil
+	default:
+		return "", fmt.Errorf("unknown key %s", spec)
+	}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+func (c *rateLimit) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	cfg := hpl.NewPLConfig(
+		ctx.Runtime().Eval,
+		c.args,
+	)
+
+	l, err := c.limiter(&cfg)
+	if err != nil {
+		w.ReplyError("request.ratelimit", 500, err)
+		return false
+	}
+	key, err := c.key(r, &cfg)
+	if err != nil {
+		w.ReplyError("request.ratelimit", 500, err)
+		return false
+	}
+
+	res := l.AllowN(key, 1, time.Now())
+
+	hdr := w.Header()
+	hdr.Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+	hdr.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	hdr.Set("X-RateLimit-Reset", ceilSeconds(res.Reset))
+	if res.Allowed {
+		return true
+	}
+
+	status := http.StatusTooManyRequests
+	body := "too many requests"
+	cfg.TryGetInt(2, &status, status)
+	cfg.TryGetStr(3, &body, body)
+
+	hdr.Set("Retry-After", ceilSeconds(res.RetryAfter))
+	w.ReplyNow(status, body)
+	return false
+}
+
+type rateLimitFactory struct{}
+
+func (c *rateLimitFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	return &rateLimit{
+		args: x,
+	}, nil
+}
+
+func (c *rateLimitFactory) Name() string {
+	return "request.ratelimit"
+}
+
+func (c *rateLimitFactory) Comment() string {
+	return "reject the request exceeding the rate limit"
+}
+
+func init() {
+	framework.AddRequestFactory(
+		"ratelimit",
+		&rateLimitFactory{},
+	)
+}
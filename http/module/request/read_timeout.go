@@ -0,0 +1,153 @@
+package request
+
+// rejects the request whose body is not received in time, protects the
+// session from the client which uploads slowly or stalls. The timeout counts
+// from the middleware for the whole body, once it passes the reading of the
+// body fails and the rejected response is sent. Arguments:
+//   0) timeout in milliseconds
+//   1) status of the rejected response, default is 408
+//   2) body of the rejected response
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+)
+
+type readResult struct {
+	n   int
+	err error
+}
+
+// the read of the underlying body cannot be interrupted, so it is done by a
+// goroutine into the buffer owned by the body. Once the read times out, the
+// body fails forever and the goroutine finishes when the connection closes or
+// the read timeout of the listener passes
+type timedBody struct {
+	io.ReadCloser
+	deadline time.Time
+	timeout  time.Duration
+	reject   *bodyReject
+	buf      []byte
+	failed   bool
+}
+
+func (t *timedBody) Read(p []byte) (int, error) {
+	if t.failed {
+		return 0, fmt.Errorf("request.read_timeout: body is not received in %s", t.timeout)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if cap(t.buf) < len(p) {
+		t.buf = make([]byte, len(p))
+	}
+	buf := t.buf[:len(p)]
+	done := make(chan readResult, 1)
+	go func() {
+		n, err := t.ReadCloser.Read(buf)
+		done <- readResult{n: n, err: err}
+	}()
+
+	timer := time.NewTimer(time.Until(t.deadline))
+	defer timer.Stop()
+
+	select {
+	case x := <-done:
+		copy(p, buf[:x.n])
+		return x.n, x.err
+	case <-timer.C:
+		t.failed = true
+		t.buf = nil
+		t.reject.reply()
+		return 0, fmt.Errorf("request.read_timeout: body is not received in %s", t.timeout)
+	}
+}
+
+// the underlying body cannot be closed while the read is pending, the server
+// closes the connection after the rejected response instead
+func (t *timedBody) Close() error {
+	if t.failed {
+		return nil
+	}
+	return t.ReadCloser.Close()
+}
+
+type readTimeout struct {
+	args []pl.Val
+}
+
+func (c *readTimeout) Name() string {
+	return "request.read_timeout"
+}
+
+func (c *readTimeout) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	cfg := hpl.NewPLConfig(
+		ctx.Runtime().Eval,
+		c.args,
+	)
+
+	var ms int64
+	if err := cfg.GetInt64(0, &ms); err != nil {
+		w.ReplyError("request.read_timeout", 500, err)
+		return false
+	}
+	if ms <= 0 {
+		w.ReplyError("request.read_timeout", 500, fmt.Errorf("timeout must be positive"))
+		return false
+	}
+
+	reject := &bodyReject{
+		w:      w,
+		status: http.StatusRequestTimeout,
+		body:   "request timeout",
+	}
+	cfg.TryGetInt(1, &reject.status, reject.status)
+	cfg.TryGetStr(2, &reject.body, reject.body)
+
+	timeout := time.Duration(ms) * time.Millisecond
+	wrapBody(r, ctx, func(body io.ReadCloser) io.ReadCloser {
+		return &timedBody{
+			ReadCloser: body,
+			deadline:   time.Now().Add(timeout),
+			timeout:    timeout,
+			reject:     reject,
+		}
+	})
+	return true
+}
+
+type readTimeoutFactory struct{}
+
+func (c *readTimeoutFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	return &readTimeout{
+		args: x,
+	}, nil
+}
+
+func (c *readTimeoutFactory) Name() string {
+	return "request.read_timeout"
+}
+
+func (c *readTimeoutFactory) Comment() string {
+	return "reject the request whose body is not received in time"
+}
+
+func init() {
+	framework.AddRequestFactory(
+		"read_timeout",
+		&readTimeoutFactory{},
+	)
+}
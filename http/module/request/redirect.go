@@ -0,0 +1,107 @@
+package request
+
+// redirects the request to the target. With the regex, only the request whose
+// path matches is redirected and the target can refer the capture groups by
+// $1 or ${name}. If the target has no query, the query of the request is
+// appended to it. Arguments:
+//   0) status, one of 301, 302, 303, 307 and 308
+//   1) target, a string or a closure returning the string
+//   2) regex matched against the path, optional
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+)
+
+type redirect struct {
+	args   []pl.Val
+	status int
+	re     *regexp.Regexp
+}
+
+func (c *redirect) Name() string {
+	return "request.redirect"
+}
+
+func (c *redirect) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	if c.re != nil && !c.re.MatchString(r.URL.Path) {
+		return true
+	}
+
+	cfg := hpl.NewPLConfig(
+		ctx.Runtime().Eval,
+		c.args,
+	)
+	var target string
+	if err := cfg.GetStr(1, &target); err != nil {
+		w.ReplyError("request.redirect", 500, err)
+		return false
+	}
+	if c.re != nil {
+		target, _ = expandPath(c.re, r.URL.Path, target)
+	}
+	if !strings.Contains(target, "?") && r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	w.Header().Set("Location", target)
+	w.ReplyNow(c.status, http.StatusText(c.status))
+	return false
+}
+
+type redirectFactory struct{}
+
+func (c *redirectFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	if len(x) < 2 {
+		return nil, fmt.Errorf("request.redirect requires status and target")
+	}
+	if !x[0].IsInt() {
+		return nil, fmt.Errorf("request.redirect status must be int")
+	}
+	m := &redirect{
+		args:   x,
+		status: int(x[0].Int()),
+	}
+	switch m.status {
+	case 301, 302, 303, 307, 308:
+		break
+	default:
+		return nil, fmt.Errorf("request.redirect status %d is not redirection", m.status)
+	}
+
+	if len(x) > 2 {
+		re, err := compileRegexArg(x, 2, "request.redirect")
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+func (c *redirectFactory) Name() string {
+	return "request.redirect"
+}
+
+func (c *redirectFactory) Comment() string {
+	return "redirect the request to the target"
+}
+
+func init() {
+	framework.AddRequestFactory(
+		"redirect",
+		&redirectFactory{},
+	)
+}
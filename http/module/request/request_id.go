@@ -0,0 +1,106 @@
+package request
+
+// assigns an id to the request. The id of the incoming header is kept if it
+// is trusted and well formed, otherwise a new uuid v7 is generated. The id is
+// visible as request.id to the script, and is carried by the same header to
+// the upstream calls of the session and written into the access log by the
+// REQUEST_ID field. Arguments:
+//   0) header of the id, default is X-Request-Id
+//   1) whether to keep the id of the incoming request, default is true
+//   2) whether to send the id back in the response header, default is true
+
+import (
+	"net/http"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/util"
+)
+
+const (
+	defaultRequestIdHeader = "X-Request-Id"
+	maxRequestIdLength     = 128
+)
+
+// the id is logged and forwarded as is, so only printable characters without
+// space are accepted
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+type requestId struct {
+	args []pl.Val
+}
+
+func (c *requestId) Name() string {
+	return "request.request_id"
+}
+
+func (c *requestId) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	cfg := hpl.NewPLConfig(
+		ctx.Runtime().Eval,
+		c.args,
+	)
+
+	header := defaultRequestIdHeader
+	trust := true
+	echo := true
+	cfg.TryGetStr(0, &header, header)
+	cfg.TryGetBool(1, &trust, trust)
+	cfg.TryGetBool(2, &echo, echo)
+	header = http.CanonicalHeaderKey(header)
+
+	id := ""
+	if trust {
+		id = r.Header.Get(header)
+	}
+	if !validRequestId(id) {
+		id = util.NewUUIDv7()
+	}
+
+	// the header is shared with the request value of the script
+	r.Header.Set(header, id)
+	ctx.Runtime().SetRequestId(header, id)
+	if echo {
+		w.Header().Set(header, id)
+	}
+	return true
+}
+
+type requestIdFactory struct{}
+
+func (c *requestIdFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	return &requestId{
+		args: x,
+	}, nil
+}
+
+func (c *requestIdFactory) Name() string {
+	return "request.request_id"
+}
+
+func (c *requestIdFactory) Comment() string {
+	return "assign an id to the request and propagate it"
+}
+
+func init() {
+	framework.AddRequestFactory(
+		"request_id",
+		&requestIdFactory{},
+	)
+}
@@ -0,0 +1,126 @@
+package request
+
+// rewrites the path of the request matching the regex, the replacement can
+// refer the capture groups by $1 or ${name}. If the replacement has a query,
+// the query of the request is appended to it. The regex is compiled once the
+// service is created. Arguments:
+//   0) regex matched against the path
+//   1) replacement, a string or a closure returning the string
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+)
+
+// the regex argument is either a regex literal or a string
+func compileRegexArg(x []pl.Val, index int, name string) (*regexp.Regexp, error) {
+	if len(x) > index && x[index].IsRegexp() {
+		return x[index].Regexp(), nil
+	}
+	if len(x) <= index || !x[index].IsString() {
+		return nil, fmt.Errorf("%s regex must be regex or string", name)
+	}
+	re, err := regexp.Compile(x[index].String())
+	if err != nil {
+		return nil, fmt.Errorf("%s regex is invalid: %s", name, err.Error())
+	}
+	return re, nil
+}
+
+// expands the template by the capture groups of the path, nil if the path
+// does not match
+func expandPath(re *regexp.Regexp, path string, template string) (string, bool) {
+	m := re.FindStringSubmatchIndex(path)
+	if m == nil {
+		return "", false
+	}
+	return string(re.ExpandString(nil, template, path, m)), true
+}
+
+type rewrite struct {
+	args []pl.Val
+	re   *regexp.Regexp
+}
+
+func (c *rewrite) Name() string {
+	return "request.rewrite"
+}
+
+func (c *rewrite) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	if !c.re.MatchString(r.URL.Path) {
+		return true
+	}
+
+	cfg := hpl.NewPLConfig(
+		ctx.Runtime().Eval,
+		c.args,
+	)
+	var replacement string
+	if err := cfg.GetStr(1, &replacement); err != nil {
+		w.ReplyError("request.rewrite", 500, err)
+		return false
+	}
+
+	target, _ := expandPath(c.re, r.URL.Path, replacement)
+	query := ""
+	if i := strings.IndexByte(target, '?'); i >= 0 {
+		target, query = target[:i], target[i+1:]
+		if r.URL.RawQuery != "" {
+			if query != "" {
+				query += "&"
+			}
+			query += r.URL.RawQuery
+		}
+	} else {
+		query = r.URL.RawQuery
+	}
+	if !strings.HasPrefix(target, "/") {
+		target = "/" + target
+	}
+
+	// the url is shared with the request value of the script
+	r.URL.Path = target
+	r.URL.RawPath = ""
+	r.URL.RawQuery = query
+	return true
+}
+
+type rewriteFactory struct{}
+
+func (c *rewriteFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	re, err := compileRegexArg(x, 0, "request.rewrite")
+	if err != nil {
+		return nil, err
+	}
+	return &rewrite{
+		args: x,
+		re:   re,
+	}, nil
+}
+
+func (c *rewriteFactory) Name() string {
+	return "request.rewrite"
+}
+
+func (c *rewriteFactory) Comment() string {
+	return "rewrite the path of the request"
+}
+
+func init() {
+	framework.AddRequestFactory(
+		"rewrite",
+		&rewriteFactory{},
+	)
+}
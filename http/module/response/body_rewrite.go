@@ -0,0 +1,187 @@
+package response
+
+// rewrites the response body while it is sent, the body is never fully
+// buffered. The pattern is replaced line by line, so it cannot match across
+// lines. The compressed body is decompressed before rewriting and sent
+// without Content-Encoding, Content-Length is dropped and ETag is weakened.
+// Arguments:
+//   0) pattern, a regex literal, a literal string, or a closure called with
+//      each chunk of the body and returns the rewritten chunk, null means
+//      nothing to output for the chunk
+//   1) replacement of the pattern, a string or a closure returning the string
+//      ie rendering a template, the regex replacement can refer the capture
+//      groups by $1 or ${name}. Not used with the closure pattern
+//   2) list of content type prefixes to rewrite, default is text/,
+//      application/json, application/javascript and application/xml. The
+//      response without Content-Type is always rewritten
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+)
+
+var bodyRewriteDefaultType = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+}
+
+type bodyRewrite struct {
+	args        []pl.Val
+	contentType []string
+}
+
+func (c *bodyRewrite) Name() string {
+	return "response.body_rewrite"
+}
+
+func (c *bodyRewrite) rewritable(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	contentType = strings.ToLower(contentType)
+	for _, x := range c.contentType {
+		if strings.HasPrefix(contentType, x) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *bodyRewrite) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	body := w.GetBody()
+	if body == nil || r.Method == http.MethodHead {
+		return true
+	}
+	if s := w.Status(); s == http.StatusNoContent || s == http.StatusNotModified ||
+		s == http.StatusPartialContent {
+		return true
+	}
+	hdr := w.Header()
+	if !c.rewritable(hdr.Get("Content-Type")) {
+		return true
+	}
+
+	transform := []pl.Val{c.args[0]}
+	if !c.args[0].IsClosure() {
+		cfg := hpl.NewPLConfig(
+			ctx.Runtime().Eval,
+			c.args,
+		)
+		var replacement string
+		if err := cfg.GetStr(1, &replacement); err != nil {
+			w.ReplyError("response.body_rewrite", 500, err)
+			return false
+		}
+		transform = []pl.Val{
+			pl.NewValStr("replace"),
+			c.args[0],
+			pl.NewValStr(replacement),
+		}
+	}
+
+	src := body
+	if enc := hdr.Get("Content-Encoding"); enc != "" && enc != "identity" {
+		// the body cannot be rewritten without knowing its encoding
+		if pl.GetCodec(enc) == nil {
+			return true
+		}
+		x, err := pl.NewDecompressReader(enc, body)
+		if err != nil {
+			w.ReplyError("response.body_rewrite", 500, err)
+			return false
+		}
+		src = &compressBody{
+			Reader: x,
+			c:      body,
+		}
+		hdr.Del("Content-Encoding")
+	}
+
+	out, err := hpl.NewReadableStreamFromStream(src).Transform(
+		ctx.Runtime().Eval,
+		transform,
+	)
+	if err != nil {
+		w.ReplyError("response.body_rewrite", 500, err)
+		return false
+	}
+
+	hdr.Del("Content-Length")
+	if etag := hdr.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		hdr.Set("ETag", "W/"+etag)
+	}
+	w.WriteBody(out.Stream)
+	return true
+}
+
+type bodyRewriteFactory struct{}
+
+func (c *bodyRewriteFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	if len(x) == 0 {
+		return nil, fmt.Errorf("response.body_rewrite requires pattern")
+	}
+	switch {
+	case x[0].IsClosure(), x[0].IsRegexp():
+		break
+	case x[0].IsString():
+		if x[0].String() == "" {
+			return nil, fmt.Errorf("response.body_rewrite pattern cannot be empty")
+		}
+	default:
+		return nil, fmt.Errorf("response.body_rewrite pattern must be regex, string or closure")
+	}
+	if !x[0].IsClosure() && len(x) < 2 {
+		return nil, fmt.Errorf("response.body_rewrite requires replacement")
+	}
+
+	m := &bodyRewrite{
+		args:        x,
+		contentType: bodyRewriteDefaultType,
+	}
+	if len(x) > 2 && !x[2].IsNull() {
+		m.contentType = nil
+		if x[2].IsString() {
+			m.contentType = append(m.contentType, strings.ToLower(x[2].String()))
+		} else if x[2].IsList() {
+			l := x[2].List()
+			for i := 0; i < l.Length(); i++ {
+				item := l.At(i)
+				if !item.IsString() {
+					return nil, fmt.Errorf("response.body_rewrite content type must be list of string")
+				}
+				m.contentType = append(m.contentType, strings.ToLower(item.String()))
+			}
+		} else {
+			return nil, fmt.Errorf("response.body_rewrite content type must be list of string")
+		}
+	}
+	return m, nil
+}
+
+func (c *bodyRewriteFactory) Name() string {
+	return "response.body_rewrite"
+}
+
+func (c *bodyRewriteFactory) Comment() string {
+	return "rewrite the response body while it is sent"
+}
+
+func init() {
+	framework.AddResponseFactory(
+		"body_rewrite",
+		&bodyRewriteFactory{},
+	)
+}
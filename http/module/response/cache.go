@@ -0,0 +1,156 @@
+package response
+
+// stores the response into the cache when it is storable by a shared cache,
+// the body is captured while it is flushed to the client. The header is taken
+// when the middleware runs, so it should be placed after the middlewares
+// modifying the response, ie compress, to cache what the client receives.
+// Arguments:
+//   0) name of the cache, default is the vhost's cache
+//   1) freshness lifetime in millisecond of the response without explicit
+//      one, ie Cache-Control max-age or Expires, default 0 which means such
+//      response is not stored
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/dianpeng/moons/cache"
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/http/module"
+	"github.com/dianpeng/moons/pl"
+)
+
+type cacheStore struct {
+	args []pl.Val
+}
+
+func (c *cacheStore) Name() string {
+	return "response.cache"
+}
+
+// body reader which captures the body, the response is stored once the body
+// is drained. The body larger than the limit is not captured
+type cacheBody struct {
+	body   io.ReadCloser
+	buf    bytes.Buffer
+	limit  int64
+	done   bool
+	finish func([]byte)
+}
+
+func (b *cacheBody) end(store bool) {
+	if b.done {
+		return
+	}
+	b.done = true
+	if store {
+		b.finish(b.buf.Bytes())
+	} else {
+		b.finish(nil)
+	}
+}
+
+func (b *cacheBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if !b.done && n > 0 {
+		if b.limit > 0 && int64(b.buf.Len()+n) > b.limit {
+			b.end(false)
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		b.end(true)
+	} else if err != nil {
+		b.end(false)
+	}
+	return n, err
+}
+
+func (b *cacheBody) Close() error {
+	b.end(false)
+	return b.body.Close()
+}
+
+func (c *cacheStore) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	cfg := hpl.NewPLConfig(
+		ctx.Runtime().Eval,
+		c.args,
+	)
+
+	x, err := module.GetCache("response.cache", &cfg, 0, ctx)
+	if err != nil {
+		w.ReplyError("response.cache", 500, err)
+		return false
+	}
+
+	ttl := int64(0)
+	cfg.TryGetInt64(1, &ttl, 0)
+
+	hdr := w.Header()
+	status := w.Status()
+
+	if w.IsHeaderFlushed() || !cache.Storable(r, status, hdr) {
+		x.Revalidated(cache.Key(r))
+		return true
+	}
+
+	if hdr.Get("X-Cache") == "" {
+		hdr.Set("X-Cache", "MISS")
+	}
+
+	header := hdr.Clone()
+	header.Del("X-Cache")
+	store := func(body []byte) {
+		if body == nil {
+			x.Revalidated(cache.Key(r))
+			return
+		}
+		x.StoreResponse(r, status, header, body, time.Duration(ttl)*time.Millisecond)
+	}
+
+	body := w.GetBody()
+	if body == nil {
+		store([]byte{})
+		return true
+	}
+
+	w.WriteBody(&cacheBody{
+		body:   body,
+		limit:  x.Option().MaxEntrySize,
+		finish: store,
+	})
+	return true
+}
+
+type cacheStoreFactory struct{}
+
+func (c *cacheStoreFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	return &cacheStore{
+		args: x,
+	}, nil
+}
+
+func (c *cacheStoreFactory) Name() string {
+	return "response.cache"
+}
+
+func (c *cacheStoreFactory) Comment() string {
+	return "store the response into the http cache"
+}
+
+func init() {
+	framework.AddResponseFactory(
+		"cache",
+		&cacheStoreFactory{},
+	)
+}
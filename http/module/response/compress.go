@@ -0,0 +1,194 @@
+package response
+
+// compress the response body with the codec negotiated from the request's
+// Accept-Encoding header. Arguments:
+//   0) list of codec names in server preference order, default is zstd, br,
+//      gzip and deflate, the codecs not registered are ignored
+//   1) compression level, default -1 which is the codec's default level
+//   2) minimum Content-Length to compress, response without Content-Length
+//      is always compressed, default 0
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+)
+
+var compressDefaultCodec = []string{"zstd", "br", "gzip", "deflate"}
+
+type compress struct {
+	args []pl.Val
+}
+
+func (c *compress) Name() string {
+	return "response.compress"
+}
+
+// parses Accept-Encoding into codec name and its q value
+func parseAcceptEncoding(h string) map[string]float64 {
+	o := make(map[string]float64)
+	for _, x := range strings.Split(h, ",") {
+		x = strings.TrimSpace(x)
+		if x == "" {
+			continue
+		}
+		q := 1.0
+		if idx := strings.Index(x, ";"); idx != -1 {
+			param := strings.TrimSpace(x[idx+1:])
+			x = strings.TrimSpace(x[:idx])
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		o[strings.ToLower(x)] = q
+	}
+	return o
+}
+
+// picks the first codec in server preference order accepted by client
+func negotiateEncoding(h string, codec []string) string {
+	if h == "" {
+		return ""
+	}
+	accept := parseAcceptEncoding(h)
+	for _, c := range codec {
+		if pl.GetCodec(c) == nil {
+			continue
+		}
+		if q, ok := accept[c]; ok {
+			if q > 0 {
+				return c
+			}
+			continue
+		}
+		if q, ok := accept["*"]; ok && q > 0 {
+			return c
+		}
+	}
+	return ""
+}
+
+func (c *compress) codecList(cfg *hpl.PLConfig) ([]string, error) {
+	var v pl.Val
+	cfg.TryGet(0, &v, pl.NewValNull())
+	if v.IsNull() {
+		return compressDefaultCodec, nil
+	}
+	if v.IsString() {
+		return []string{v.String()}, nil
+	}
+	if !v.IsList() {
+		return nil, fmt.Errorf("codec list must be list of string")
+	}
+	o := []string{}
+	for _, x := range v.List().Data {
+		if !x.IsString() {
+			return nil, fmt.Errorf("codec list must be list of string")
+		}
+		o = append(o, x.String())
+	}
+	return o, nil
+}
+
+type compressBody struct {
+	io.Reader
+	c io.Closer
+}
+
+func (c *compressBody) Close() error {
+	return c.c.Close()
+}
+
+func (c *compress) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	body := w.GetBody()
+	if body == nil || r.Method == http.MethodHead {
+		return true
+	}
+	if s := w.Status(); s == http.StatusNoContent || s == http.StatusNotModified ||
+		s == http.StatusPartialContent {
+		return true
+	}
+	hdr := w.Header()
+	if hdr.Get("Content-Encoding") != "" {
+		return true
+	}
+
+	cfg := hpl.NewPLConfig(
+		ctx.Runtime().Eval,
+		c.args,
+	)
+
+	codec, err := c.codecList(&cfg)
+	if err != nil {
+		w.ReplyError("response.compress", 500, err)
+		return false
+	}
+	level := -1
+	minLength := int64(0)
+	cfg.TryGetInt(1, &level, -1)
+	cfg.TryGetInt64(2, &minLength, 0)
+
+	if l := hdr.Get("Content-Length"); l != "" && minLength > 0 {
+		if v, err := strconv.ParseInt(l, 10, 64); err == nil && v < minLength {
+			return true
+		}
+	}
+
+	hdr.Add("Vary", "Accept-Encoding")
+
+	name := negotiateEncoding(r.Header.Get("Accept-Encoding"), codec)
+	if name == "" {
+		return true
+	}
+
+	x, err := pl.NewCompressReader(name, body, level)
+	if err != nil {
+		w.ReplyError("response.compress", 500, err)
+		return false
+	}
+
+	hdr.Set("Content-Encoding", name)
+	hdr.Del("Content-Length")
+	w.WriteBody(&compressBody{
+		Reader: x,
+		c:      body,
+	})
+	return true
+}
+
+type compressfactory struct{}
+
+func (c *compressfactory) Create(x []pl.Val) (framework.Middleware, error) {
+	return &compress{
+		args: x,
+	}, nil
+}
+
+func (c *compressfactory) Name() string {
+	return "response.compress"
+}
+
+func (c *compressfactory) Comment() string {
+	return "compress response body with codec negotiated by Accept-Encoding"
+}
+
+func init() {
+	framework.AddResponseFactory(
+		"compress",
+		&compressfactory{},
+	)
+}
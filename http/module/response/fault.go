@@ -0,0 +1,73 @@
+package response
+
+// injects the faults into the response for resilience testing, the response
+// is delayed, replaced by the status, reset without being sent, or its body is
+// sent slowly, each at the sampled rate. The faults of the name are shared by
+// the services and can be changed at runtime by the admin API. Arguments:
+//   0) name of the faults
+//   1) faults, ie {"throttle": {"rate": 1, "bytes_per_second": 4096}}, see
+//      hpl/fault.go
+
+import (
+	"net/http"
+
+	"github.com/dianpeng/moons/fault"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/http/module"
+	"github.com/dianpeng/moons/pl"
+)
+
+type faultInjector struct {
+	injector *fault.Injector
+}
+
+func (c *faultInjector) Name() string {
+	return "response.fault"
+}
+
+func (c *faultInjector) Accept(
+	r *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	plan := c.injector.Decide(r)
+	if plan == nil || w.IsHeaderFlushed() {
+		return true
+	}
+	if !module.InjectFault(plan, r, w, ctx) {
+		return false
+	}
+	if body := w.GetBody(); plan.BytesPerSecond > 0 && body != nil {
+		w.WriteBody(fault.Throttle(body, plan.BytesPerSecond))
+	}
+	return true
+}
+
+type faultFactory struct{}
+
+func (c *faultFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	injector, err := module.NewFaultInjector("response.fault", x)
+	if err != nil {
+		return nil, err
+	}
+	return &faultInjector{
+		injector: injector,
+	}, nil
+}
+
+func (c *faultFactory) Name() string {
+	return "response.fault"
+}
+
+func (c *faultFactory) Comment() string {
+	return "inject delay, abort, reset and throttle into the response"
+}
+
+func init() {
+	framework.AddResponseFactory(
+		"fault",
+		&faultFactory{},
+	)
+}
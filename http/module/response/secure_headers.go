@@ -0,0 +1,322 @@
+package response
+
+// sets the security headers of the response, the headers already set by the
+// application are kept. Each argument is a preset name or a map of options,
+// or a closure returning either, they are merged in order so the later ones
+// override the former, ie a route overrides the shared preset. Without any
+// argument, the default preset is used. Presets:
+//   default, HSTS of one year, nosniff, strict-origin-when-cross-origin
+//            referrer policy and SAMEORIGIN frame options
+//   strict,  HSTS of two years including subdomains, nosniff, no-referrer,
+//            DENY frame options and CSP allowing only the same origin
+//   none,    nothing
+// Options:
+//   hsts, false, max-age in seconds, or map of max_age, include_subdomains
+//     and preload
+//   content_type_options, whether X-Content-Type-Options is nosniff
+//   referrer_policy, Referrer-Policy or false
+//   frame_options, X-Frame-Options or false
+//   csp, the policy string or map of directive to the source list(list or
+//     string), merged directive by directive and null removes the directive
+//   csp_report_only, whether to use Content-Security-Policy-Report-Only
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/http/framework"
+	"github.com/dianpeng/moons/pl"
+)
+
+type cspDirective struct {
+	name  string
+	value string
+}
+
+type securePolicy struct {
+	hsts          string
+	nosniff       bool
+	referrer      string
+	frame         string
+	csp           []cspDirective
+	cspReportOnly bool
+}
+
+func (p *securePolicy) setDirective(name string, value string, remove bool) {
+	for i, d := range p.csp {
+		if d.name == name {
+			if remove {
+				p.csp = append(p.csp[:i], p.csp[i+1:]...)
+			} else {
+				p.csp[i].value = value
+			}
+			return
+		}
+	}
+	if !remove {
+		p.csp = append(p.csp, cspDirective{name: name, value: value})
+	}
+}
+
+// the policy string replaces the whole policy
+func (p *securePolicy) setPolicy(policy string) {
+	p.csp = nil
+	for _, x := range strings.Split(policy, ";") {
+		f := strings.Fields(x)
+		if len(f) == 0 {
+			continue
+		}
+		p.setDirective(strings.ToLower(f[0]), strings.Join(f[1:], " "), false)
+	}
+}
+
+func (p *securePolicy) cspString() string {
+	o := make([]string, 0, len(p.csp))
+	for _, d := range p.csp {
+		if d.value == "" {
+			o = append(o, d.name)
+		} else {
+			o = append(o, d.name+" "+d.value)
+		}
+	}
+	return strings.Join(o, "; ")
+}
+
+func (p *securePolicy) preset(name string) error {
+	switch name {
+	case "default":
+		*p = securePolicy{
+			hsts:     "max-age=31536000",
+			nosniff:  true,
+			referrer: "strict-origin-when-cross-origin",
+			frame:    "SAMEORIGIN",
+		}
+	case "strict":
+		*p = securePolicy{
+			hsts:     "max-age=63072000; includeSubDomains",
+			nosniff:  true,
+			referrer: "no-referrer",
+			frame:    "DENY",
+		}
+		p.setPolicy("default-src 'self'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'")
+	case "none":
+		*p = securePolicy{}
+	default:
+		return fmt.Errorf("unknown preset %s", name)
+	}
+	return nil
+}
+
+func hstsOf(v pl.Val) (string, error) {
+	switch {
+	case v.IsBool():
+		if v.Bool() {
+			return "max-age=31536000", nil
+		}
+		return "", nil
+	case v.IsInt():
+		return "max-age=" + strconv.FormatInt(v.Int(), 10), nil
+	case v.IsMap():
+		age := int64(31536000)
+		sub, preload := false, false
+		if x, ok := v.Map().Get("max_age"); ok && x.IsInt() {
+			age = x.Int()
+		}
+		if x, ok := v.Map().Get("include_subdomains"); ok {
+			sub = x.ToBoolean()
+		}
+		if x, ok := v.Map().Get("preload"); ok {
+			preload = x.ToBoolean()
+		}
+		o := "max-age=" + strconv.FormatInt(age, 10)
+		if sub {
+			o += "; includeSubDomains"
+		}
+		if preload {
+			o += "; preload"
+		}
+		return o, nil
+	default:
+		return "", fmt.Errorf("hsts must be bool, int or map")
+	}
+}
+
+// string option, false disables it
+func optionalStr(v pl.Val, key string) (string, error) {
+	switch {
+	case v.IsString():
+		return v.String(), nil
+	case v.IsBool() && !v.Bool():
+		return "", nil
+	case v.IsNull():
+		return "", nil
+	default:
+		return "", fmt.Errorf("%s must be string or false", key)
+	}
+}
+
+func (p *securePolicy) mergeCSP(v pl.Val) error {
+	switch {
+	case v.IsString():
+		p.setPolicy(v.String())
+		return nil
+	case v.IsBool() && !v.Bool(), v.IsNull():
+		p.csp = nil
+		return nil
+	case v.IsMap():
+		break
+	default:
+		return fmt.Errorf("csp must be string, map or false")
+	}
+
+	m := v.Map()
+	for _, name := range m.Keys() {
+		x, _ := m.Get(name)
+		name = strings.ToLower(name)
+		switch {
+		case x.IsNull():
+			p.setDirective(name, "", true)
+		case x.IsString():
+			p.setDirective(name, x.String(), false)
+		case x.IsBool() && x.Bool():
+			// directive without value, ie upgrade-insecure-requests
+			p.setDirective(name, "", false)
+		case x.IsList():
+			src := []string{}
+			l := x.List()
+			for i := 0; i < l.Length(); i++ {
+				item := l.At(i)
+				s, err := item.ToString()
+				if err != nil {
+					return fmt.Errorf("csp %s source must be string", name)
+				}
+				src = append(src, s)
+			}
+			p.setDirective(name, strings.Join(src, " "), false)
+		default:
+			return fmt.Errorf("csp %s must be string, list, true or null", name)
+		}
+	}
+	return nil
+}
+
+func (p *securePolicy) merge(v pl.Val) error {
+	if v.IsString() {
+		return p.preset(v.String())
+	}
+	if !v.IsMap() {
+		return fmt.Errorf("option must be preset name or map")
+	}
+
+	var err error
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "hsts":
+				p.hsts, err = hstsOf(val)
+			case "content_type_options":
+				p.nosniff = val.ToBoolean()
+			case "referrer_policy":
+				p.referrer, err = optionalStr(val, key)
+			case "frame_options":
+				p.frame, err = optionalStr(val, key)
+			case "csp":
+				err = p.mergeCSP(val)
+			case "csp_report_only":
+				p.cspReportOnly = val.ToBoolean()
+			default:
+				err = fmt.Errorf("option %s is unknown", key)
+			}
+			return err == nil
+		},
+	)
+	return err
+}
+
+func setIfAbsent(h http.Header, key string, value string) {
+	if value != "" && h.Get(key) == "" {
+		h.Set(key, value)
+	}
+}
+
+func (p *securePolicy) apply(h http.Header) {
+	setIfAbsent(h, "Strict-Transport-Security", p.hsts)
+	if p.nosniff {
+		setIfAbsent(h, "X-Content-Type-Options", "nosniff")
+	}
+	setIfAbsent(h, "Referrer-Policy", p.referrer)
+	setIfAbsent(h, "X-Frame-Options", p.frame)
+	if len(p.csp) > 0 {
+		if p.cspReportOnly {
+			setIfAbsent(h, "Content-Security-Policy-Report-Only", p.cspString())
+		} else {
+			setIfAbsent(h, "Content-Security-Policy", p.cspString())
+		}
+	}
+}
+
+type secureHeaders struct {
+	args []pl.Val
+}
+
+func (s *secureHeaders) Name() string {
+	return "response.secure_headers"
+}
+
+func (s *secureHeaders) Accept(
+	_ *http.Request,
+	_ hrouter.Params,
+	w framework.HttpResponseWriter,
+	ctx framework.ServiceContext,
+) bool {
+	cfg := hpl.NewPLConfig(
+		ctx.Runtime().Eval,
+		s.args,
+	)
+
+	p := &securePolicy{}
+	if len(s.args) == 0 {
+		p.preset("default")
+	}
+	for i := range s.args {
+		var v pl.Val
+		if err := cfg.Get(i, &v); err != nil {
+			w.ReplyError("response.secure_headers", 500, err)
+			return false
+		}
+		if err := p.merge(v); err != nil {
+			w.ReplyError("response.secure_headers", 500, err)
+			return false
+		}
+	}
+
+	p.apply(w.Header())
+	return true
+}
+
+type secureHeadersFactory struct{}
+
+func (s *secureHeadersFactory) Create(x []pl.Val) (framework.Middleware, error) {
+	return &secureHeaders{
+		args: x,
+	}, nil
+}
+
+func (s *secureHeadersFactory) Name() string {
+	return "response.secure_headers"
+}
+
+func (s *secureHeadersFactory) Comment() string {
+	return "set the security headers of the response"
+}
+
+func init() {
+	framework.AddResponseFactory(
+		"secure_headers",
+		&secureHeadersFactory{},
+	)
+}
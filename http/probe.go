@@ -0,0 +1,162 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dianpeng/moons/http/vhost"
+)
+
+// Probes of the load balancer, answered by the listener before any vhost
+//
+//   GET /healthz   liveness, 200 as long as the listener serves
+//   GET /readyz    readiness, 503 once the listener starts to shut down, ie
+//                  handed over to the new process, or the vhost is not ready
+//
+// The readiness is the one of the vhost of the Host header, or of all the
+// vhosts of the listener if the Host header matches none of them, see
+// vhost.Ready. The probes are configured in JSON form, ie
+//
+//   "probe": {"live": "/healthz", "ready": "/readyz", "timeout": 1000}
+//
+// the timeout is the one of the @health rule in millisecond, and
+// "disable": true turns the probes off, the paths are then routed as usual.
+
+const (
+	defaultProbeLive    = "/healthz"
+	defaultProbeReady   = "/readyz"
+	defaultProbeTimeout = 1000
+)
+
+type probeConfig struct {
+	Disable bool   `json:"disable"`
+	Live    string `json:"live"`
+	Ready   string `json:"ready"`
+	Timeout int64  `json:"timeout"`
+}
+
+type probe struct {
+	live    string
+	ready   string
+	timeout time.Duration
+}
+
+func newProbe(c *probeConfig) *probe {
+	if c == nil {
+		c = &probeConfig{}
+	}
+	if c.Disable {
+		return nil
+	}
+	p := &probe{
+		live:    c.Live,
+		ready:   c.Ready,
+		timeout: time.Duration(c.Timeout) * time.Millisecond,
+	}
+	if p.live == "" {
+		p.live = defaultProbeLive
+	}
+	if p.ready == "" {
+		p.ready = defaultProbeReady
+	}
+	if p.timeout <= 0 {
+		p.timeout = defaultProbeTimeout * time.Millisecond
+	}
+	return p
+}
+
+type vhostReady struct {
+	Ready  bool   `json:"ready"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func replyProbe(w http.ResponseWriter, ready bool, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+// checks the vhosts at once, so the slow @health rule of one does not delay
+// the others
+func (p *probe) readyAll(list []*vhost.VHost) (bool, map[string]vhostReady) {
+	o := make(map[string]vhostReady)
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, x := range list {
+		wg.Add(1)
+		go func(x *vhost.VHost) {
+			defer wg.Done()
+			ok, reason := x.Ready(p.timeout)
+			lock.Lock()
+			o[x.Name()] = vhostReady{
+				Ready:  ok,
+				Reason: reason,
+			}
+			lock.Unlock()
+		}(x)
+	}
+	wg.Wait()
+
+	ready := true
+	for _, x := range o {
+		ready = ready && x.Ready
+	}
+	return ready, o
+}
+
+// serves the probe, false if the request is not the probe
+func (l *listener) serveProbe(w http.ResponseWriter, r *http.Request) bool {
+	p := l.probe
+	if p == nil {
+		return false
+	}
+
+	switch r.URL.Path {
+	case p.live:
+		replyProbe(w, true, map[string]interface{}{
+			"live": true,
+		})
+		return true
+
+	case p.ready:
+		if atomic.LoadInt32(&l.shutting) == 1 {
+			replyProbe(w, false, map[string]interface{}{
+				"ready":  false,
+				"reason": "listener is shutting down",
+			})
+			return true
+		}
+
+		list := []*vhost.VHost{}
+		if x := l.resolveVHost(r.Host); x != nil {
+			list = append(list, x)
+		} else {
+			list = l.vlist.list()
+		}
+		if len(list) == 0 {
+			replyProbe(w, false, map[string]interface{}{
+				"ready":  false,
+				"reason": "listener has no vhost",
+			})
+			return true
+		}
+
+		ready, vhosts := p.readyAll(list)
+		replyProbe(w, ready, map[string]interface{}{
+			"ready":  ready,
+			"vhosts": vhosts,
+		})
+		return true
+
+	default:
+		return false
+	}
+}
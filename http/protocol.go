@@ -0,0 +1,70 @@
+package http
+
+import (
+	"fmt"
+)
+
+// Protocols served by the http listener, by the "protocol" field of the
+// listener config in JSON, ie ["http1", "h2"]. By default http/1.x is served,
+// along with h2 once the TLS is terminated. The h2c is the http/2 over
+// cleartext by prior knowledge, which can only be served without TLS. The h3
+// requires QUIC, which is not available in this build.
+const (
+	protoHTTP1 = "http1"
+	protoH2    = "h2"
+	protoH2C   = "h2c"
+	protoH3    = "h3"
+)
+
+// tuning knobs of http/2, by the "http2" field of the listener config, the
+// zero value means the default of net/http
+type http2Config struct {
+	MaxConcurrentStreams          int `json:"max_concurrent_streams"`
+	MaxReadFrameSize              int `json:"max_read_frame_size"`
+	MaxReceiveBufferPerConnection int `json:"max_receive_buffer_per_connection"`
+	MaxReceiveBufferPerStream     int `json:"max_receive_buffer_per_stream"`
+	MaxDecoderHeaderTableSize     int `json:"max_decoder_header_table_size"`
+	MaxEncoderHeaderTableSize     int `json:"max_encoder_header_table_size"`
+
+	// in seconds
+	SendPingTimeout  int64 `json:"send_ping_timeout"`
+	PingTimeout      int64 `json:"ping_timeout"`
+	WriteByteTimeout int64 `json:"write_byte_timeout"`
+}
+
+// returns the protocols to serve, validated against the TLS setting
+func (lc *listenerConfig) protocols() (map[string]bool, error) {
+	list := lc.Protocol
+	if len(list) == 0 {
+		list = []string{protoHTTP1}
+		if lc.TLS != nil {
+			list = append(list, protoH2)
+		}
+	}
+
+	o := make(map[string]bool)
+	for _, x := range list {
+		switch x {
+		case protoHTTP1:
+			break
+		case protoH2:
+			if lc.TLS == nil {
+				return nil, fmt.Errorf("listener %s: protocol h2 requires tls, use h2c instead", lc.Name)
+			}
+		case protoH2C:
+			if lc.TLS != nil {
+				return nil, fmt.Errorf("listener %s: protocol h2c cannot be served with tls, use h2 instead", lc.Name)
+			}
+		case protoH3:
+			return nil, fmt.Errorf("listener %s: protocol h3 requires QUIC, which is not supported by this build", lc.Name)
+		default:
+			return nil, fmt.Errorf("listener %s: unknown protocol %s", lc.Name, x)
+		}
+		o[x] = true
+	}
+
+	if lc.HTTP2 != nil && !o[protoH2] && !o[protoH2C] {
+		return nil, fmt.Errorf("listener %s: http2 is configured, but neither h2 nor h2c is served", lc.Name)
+	}
+	return o, nil
+}
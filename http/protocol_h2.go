@@ -0,0 +1,32 @@
+//go:build go1.24
+// +build go1.24
+
+package http
+
+import (
+	"net/http"
+	"time"
+)
+
+func configureProtocol(s *http.Server, proto map[string]bool, c *http2Config) error {
+	p := new(http.Protocols)
+	p.SetHTTP1(proto[protoHTTP1])
+	p.SetHTTP2(proto[protoH2])
+	p.SetUnencryptedHTTP2(proto[protoH2C])
+	s.Protocols = p
+
+	if c != nil {
+		s.HTTP2 = &http.HTTP2Config{
+			MaxConcurrentStreams:          c.MaxConcurrentStreams,
+			MaxReadFrameSize:              c.MaxReadFrameSize,
+			MaxReceiveBufferPerConnection: c.MaxReceiveBufferPerConnection,
+			MaxReceiveBufferPerStream:     c.MaxReceiveBufferPerStream,
+			MaxDecoderHeaderTableSize:     c.MaxDecoderHeaderTableSize,
+			MaxEncoderHeaderTableSize:     c.MaxEncoderHeaderTableSize,
+			SendPingTimeout:               time.Second * time.Duration(c.SendPingTimeout),
+			PingTimeout:                   time.Second * time.Duration(c.PingTimeout),
+			WriteByteTimeout:              time.Second * time.Duration(c.WriteByteTimeout),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build !go1.24
+// +build !go1.24
+
+package http
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+)
+
+// net/http serves h2 over TLS on its own before go1.24, which can only be
+// turned off, the h2c and the tuning knobs are not available
+func configureProtocol(s *http.Server, proto map[string]bool, c *http2Config) error {
+	if !proto[protoHTTP1] {
+		return fmt.Errorf("protocol http1 can only be turned off since go1.24")
+	}
+	if proto[protoH2C] {
+		return fmt.Errorf("protocol h2c requires go1.24")
+	}
+	if c != nil {
+		return fmt.Errorf("http2 requires go1.24")
+	}
+	if !proto[protoH2] {
+		s.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	return nil
+}
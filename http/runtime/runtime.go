@@ -9,6 +9,7 @@ import (
 	// router
 	"github.com/dianpeng/moons/alog"
 	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/kv"
 	"github.com/dianpeng/moons/pl"
 )
 
@@ -52,9 +53,23 @@ type Runtime struct {
 	respWriter pl.Val
 	log        pl.Val
 
+	// who the request is authenticated as, set by the auth middlewares
+	identity pl.Val
+
+	// id of the request and the header carrying it to the upstream, set by the
+	// request_id middleware
+	requestId       string
+	requestIdHeader string
+
 	hplCtx    Context
 	hplRt     Resource
 	hplAction Action
+
+	// namespace of kv:: functions
+	kvNamespace string
+
+	// level of log:: functions, ie the one of the vhost
+	logLevel *alog.Level
 }
 
 func NewRuntime() *Runtime {
@@ -83,9 +98,43 @@ func (h *Runtime) SetModule(p *pl.Module) {
 	h.Module = p
 }
 
+// the request value of the current session, null outside of a session
+func (h *Runtime) Request() pl.Val {
+	return h.request
+}
+
+// the identity of the current session, null if the request is not
+// authenticated
+func (h *Runtime) Identity() pl.Val {
+	return h.identity
+}
+
+func (h *Runtime) SetIdentity(v pl.Val) {
+	h.identity = v
+}
+
+// the id of the current session and the header carrying it, empty if the
+// request is not assigned one
+func (h *Runtime) RequestId() (string, string) {
+	return h.requestId, h.requestIdHeader
+}
+
+// assigns the id to the current session, it is visible as request.id to the
+// script
+func (h *Runtime) SetRequestId(header string, id string) {
+	h.requestId = id
+	h.requestIdHeader = header
+	if hpl.ValIsHttpRequest(h.request) {
+		if r, ok := h.request.Usr().(*hpl.Request); ok {
+			r.SetRequestId(id)
+		}
+	}
+}
+
 // Derive a HPL state from another existed HPL, suitable for using in background
 func (h *Runtime) Derive(that *Runtime) {
 	h.Module = that.Module
+	h.kvNamespace = that.kvNamespace
 
 	// notes, we currently do not have a way to duplicate session state from that
 	// HPL to our HPL and due to the thread issue, we cannot safely just do shallow
@@ -107,6 +156,8 @@ func (p *Runtime) loadVarBasic(x *pl.Evaluator, n string) (pl.Val, error) {
 		return p.respWriter, nil
 	case "log":
 		return p.log, nil
+	case "identity":
+		return p.identity, nil
 	default:
 		return p.hplCtx.OnLoadVar(x, n)
 	}
@@ -152,11 +203,33 @@ func (p *Runtime) loadFnVar(_ *pl.Evaluator, n string) (pl.Val, bool) {
 			},
 		), true
 
+	case "http::fetch_all":
+		return pl.NewValNativeFunction(
+			"http::fetch_all",
+			func(args []pl.Val) (pl.Val, error) {
+				return p.fnHttp(args, hpl.FnHttpFetchAll)
+			},
+		), true
+
 	default:
 		break
 	}
 
-	return pl.NewValNull(), false
+	if v, ok := hpl.NewLogFunction(n, p.logLevel, p.log); ok {
+		return v, true
+	}
+	return hpl.NewKVFunction(n, kv.Default.Namespace(p.kvNamespace))
+}
+
+// SetKVNamespace sets the namespace of the kv:: functions, ie the vhost name
+func (p *Runtime) SetKVNamespace(ns string) {
+	p.kvNamespace = ns
+}
+
+// SetLogLevel sets the level of the log:: functions, ie the level of the vhost
+// which is adjustable at runtime
+func (p *Runtime) SetLogLevel(level *alog.Level) {
+	p.logLevel = level
 }
 
 // -----------------------------------------------------------------------------
@@ -232,6 +305,7 @@ func (h *Runtime) OnGlobal(session ConstSessionWrapper) error {
 	}
 
 	h.hplRt = session
+	h.log = pl.NewValNull()
 
 	h.Eval.Context = pl.NewCbEvalContext(
 		h.globalLoadVar,
@@ -317,6 +391,9 @@ func (h *Runtime) OnInit(
 	h.request = request
 	h.params = hrouter
 	h.respWriter = respWriter
+	h.identity = pl.NewValNull()
+	h.requestId = ""
+	h.requestIdHeader = ""
 
 	h.hplCtx = session
 	h.hplRt = session
@@ -333,6 +410,12 @@ func (h *Runtime) OnInit(
 	return h.Eval.EvalSession(h.Module)
 }
 
+// closes the resources registered by the session, ie the branches of a tee'd
+// stream, should be called once the session is done
+func (h *Runtime) Cleanup() error {
+	return h.Eval.Cleanup()
+}
+
 // -----------------------------------------------------------------------------
 func (h *Runtime) Emit(name string, context pl.Val) (pl.Val, error) {
 	if h.Module == nil {
@@ -342,6 +425,45 @@ func (h *Runtime) Emit(name string, context pl.Val) (pl.Val, error) {
 	return h.Eval.EvalWithContext(name, context, h.Module)
 }
 
+// -----------------------------------------------------------------------------
+// event phase, the event comes from outside of any http transaction, ie the
+// message of a subscribed topic, so no session variable is available
+func (h *Runtime) eventLoadVar(x *pl.Evaluator, n string) (pl.Val, error) {
+	if v, ok := h.loadFnVar(x, n); ok {
+		return v, nil
+	}
+	return pl.NewValNull(), fmt.Errorf("event: unknown variable %s", n)
+}
+
+func (p *Runtime) eventStoreVar(x *pl.Evaluator, n string, v pl.Val) error {
+	return fmt.Errorf("event: unknown variable set %s", n)
+}
+
+func (h *Runtime) eventAction(x *pl.Evaluator, actionName string, arg pl.Val) error {
+	return fmt.Errorf("event: unknown action %s", actionName)
+}
+
+func (h *Runtime) OnEvent(name string, context pl.Val, session ConstSessionWrapper) (pl.Val, error) {
+	if h.Module == nil {
+		return pl.NewValNull(), fmt.Errorf("Runtime engine does not have any module binded")
+	}
+	h.hplRt = session
+	h.log = pl.NewValNull()
+
+	h.Eval.Context = pl.NewCbEvalContext(
+		h.eventLoadVar,
+		h.eventStoreVar,
+		h.eventAction,
+	)
+
+	defer func() {
+		h.hplRt = nil
+		h.hplCtx = nil
+	}()
+
+	return h.Emit(name, context)
+}
+
 // =============================================================================
 // -----------------------------------------------------------------------------
 // This is used for testing purpose and not used in production
@@ -382,6 +504,9 @@ func (h *Runtime) OnTestSession(session SessionWrapper) error {
 	h.request = pl.NewValNull()
 	h.params = pl.NewValNull()
 	h.respWriter = pl.NewValNull()
+	h.identity = pl.NewValNull()
+	h.requestId = ""
+	h.requestIdHeader = ""
 
 	h.hplCtx = session
 	h.hplRt = session
@@ -403,6 +528,9 @@ func (h *Runtime) OnTest(selector string, context pl.Val) (pl.Val, error) {
 	h.request = pl.NewValNull()
 	h.params = pl.NewValNull()
 	h.respWriter = pl.NewValNull()
+	h.identity = pl.NewValNull()
+	h.requestId = ""
+	h.requestIdHeader = ""
 
 	h.Eval.Context = pl.NewCbEvalContext(
 		h.testLoadVar,
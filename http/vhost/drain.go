@@ -0,0 +1,57 @@
+package vhost
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// draining of the vhost by the admin API, the drained vhost replies 503 to the
+// new requests and the ones in flight still finish. The vhost recreated by
+// reload is not drained
+
+const drainPollInterval = 10 * time.Millisecond
+
+// counted before checking the draining flag, so the drain either sees the
+// request in flight or the request sees the flag
+func (v *VHost) enter() bool {
+	atomic.AddInt64(&v.inflight, 1)
+	if atomic.LoadInt32(&v.draining) == 1 {
+		atomic.AddInt64(&v.inflight, -1)
+		return false
+	}
+	return true
+}
+
+func (v *VHost) leave() {
+	atomic.AddInt64(&v.inflight, -1)
+}
+
+// Drain rejects the new requests and waits the ones in flight to finish
+func (v *VHost) Drain(ctx context.Context) error {
+	atomic.StoreInt32(&v.draining, 1)
+
+	tick := time.NewTicker(drainPollInterval)
+	defer tick.Stop()
+	for atomic.LoadInt64(&v.inflight) != 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+			break
+		}
+	}
+	return nil
+}
+
+func (v *VHost) Resume() {
+	atomic.StoreInt32(&v.draining, 0)
+}
+
+func (v *VHost) Draining() bool {
+	return atomic.LoadInt32(&v.draining) == 1
+}
+
+func (v *VHost) InFlight() int64 {
+	return atomic.LoadInt64(&v.inflight)
+}
@@ -0,0 +1,74 @@
+package vhost
+
+import (
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/http/runtime"
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/util"
+)
+
+// eventSession runs the events which come from outside of any http
+// transaction, ie the message of a subscribed topic, in the vhost module. The
+// session is not thread safe, the http clients used by the event are put back
+// once the event finishes
+
+type eventSession struct {
+	vhost   *VHost
+	runtime *runtime.Runtime
+
+	activeHttpClient []util.HClient
+}
+
+func (v *VHost) newEventSession() *eventSession {
+	return v.newModuleEventSession(v.Module)
+}
+
+// event session of the module of the vhost or of its service
+func (v *VHost) newModuleEventSession(m *pl.Module) *eventSession {
+	rt := runtime.NewRuntimeWithModule(m)
+	v.setupEval(rt.Eval)
+	rt.SetKVNamespace(v.Config.Name)
+	rt.SetLogLevel(v.logLevel)
+	return &eventSession{
+		vhost:   v,
+		runtime: rt,
+	}
+}
+
+func (s *eventSession) emit(name string, context pl.Val) error {
+	_, err := s.call(name, context)
+	return err
+}
+
+// runs the event and returns what the rule returns
+func (s *eventSession) call(name string, context pl.Val) (pl.Val, error) {
+	defer func() {
+		for _, c := range s.activeHttpClient {
+			s.vhost.clientPool.Put(c)
+		}
+		s.activeHttpClient = nil
+		s.runtime.Cleanup()
+	}()
+
+	return s.runtime.OnEvent(name, context, s)
+}
+
+// interface for hpl.HttpClientFactory
+func (s *eventSession) GetHttpClient(url string) (hpl.HttpClient, error) {
+	c, err := s.vhost.clientPool.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	s.activeHttpClient = append(s.activeHttpClient, c)
+	return &c, nil
+}
+
+// interface for hpl.HttpClientOptionFactory
+func (s *eventSession) GetHttpClientWithOption(url string, option *hpl.HttpClientOption) (hpl.HttpClient, error) {
+	c, err := s.vhost.clientPool.GetWithOption(url, option)
+	if err != nil {
+		return nil, err
+	}
+	s.activeHttpClient = append(s.activeHttpClient, c)
+	return &c, nil
+}
@@ -0,0 +1,92 @@
+package vhost
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/dianpeng/moons/health"
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/util"
+)
+
+// health checking of the upstream groups which have health_check configured.
+// The unhealthy upstream is excluded from balancing and the change of health
+// is emitted into the vhost module as event upstream.<group>:down or
+// upstream.<group>:up. The checkers run concurrently, so the events are
+// serialized on one event session
+
+type healthCheck struct {
+	sync.Mutex
+	session *eventSession
+	checker []*health.Checker
+}
+
+func healthEventName(e health.Event) string {
+	if e.Healthy {
+		return "upstream." + e.Group + ":up"
+	}
+	return "upstream." + e.Group + ":down"
+}
+
+func (v *VHost) onHealthEvent(u *hpl.Upstream, e health.Event) {
+	u.Balancer.SetHealthy(e.Addr, e.Healthy)
+
+	h := v.healthCheck
+	h.Lock()
+	defer h.Unlock()
+
+	name := healthEventName(e)
+	if err := h.session.emit(name, hpl.NewHealthEventVal(e)); err != nil {
+		util.Errorf("vhost %s: event %s of upstream %s failed: %s",
+			v.Config.Name, name, e.Addr, err.Error())
+	}
+}
+
+func (v *VHost) startHealthCheck() error {
+	// sorted so the checkers start in the same order
+	name := []string{}
+	for n, u := range v.Config.Upstream {
+		if u.HealthCheck != nil {
+			name = append(name, n)
+		}
+	}
+	if len(name) == 0 {
+		return nil
+	}
+	sort.Strings(name)
+
+	v.healthCheck = &healthCheck{
+		session: v.newEventSession(),
+	}
+	for _, n := range name {
+		u := v.Config.Upstream[n]
+		addr := []string{}
+		for _, x := range u.Balancer.Upstream() {
+			addr = append(addr, x.Addr)
+		}
+
+		c, err := health.New(n, addr, u.HealthCheck, func(e health.Event) {
+			v.onHealthEvent(u, e)
+		})
+		if err != nil {
+			v.stopHealthCheck()
+			return fmt.Errorf("http_vhost: health check of upstream %s failed: %s", n, err.Error())
+		}
+		v.healthCheck.checker = append(v.healthCheck.checker, c)
+	}
+	for _, c := range v.healthCheck.checker {
+		c.Start()
+	}
+	return nil
+}
+
+func (v *VHost) stopHealthCheck() {
+	if v.healthCheck == nil {
+		return
+	}
+	for _, c := range v.healthCheck.checker {
+		c.Stop()
+	}
+	v.healthCheck = nil
+}
@@ -0,0 +1,192 @@
+package vhost
+
+import (
+	"fmt"
+
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/ratelimit"
+	"github.com/dianpeng/moons/server"
+)
+
+// server.Inspector of the vhost, the results are in JSON form
+
+func middlewareNames(c *vHSMiddlewareConfig) []string {
+	o := []string{}
+	for _, x := range c.List {
+		o = append(o, x.Name)
+	}
+	return o
+}
+
+func moduleInfo(m *pl.Module) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"rules":     m.Rules(),
+		"functions": m.Functions(),
+	}
+}
+
+func (v *VHost) Inspect() interface{} {
+	services := []interface{}{}
+	for _, svc := range v.ServiceList {
+		c := svc.config
+		services = append(services, map[string]interface{}{
+			"name":        c.Name,
+			"tag":         c.Tag,
+			"comment":     c.Comment,
+			"router":      c.Router,
+			"group":       c.Group,
+			"request":     middlewareNames(&c.Request),
+			"response":    middlewareNames(&c.Response),
+			"application": c.AppName,
+			"module":      moduleInfo(svc.module),
+		})
+	}
+
+	routes := []interface{}{}
+	for _, r := range v.Routes() {
+		routes = append(routes, map[string]interface{}{
+			"service": r.Service,
+			"group":   r.Group,
+			"host":    r.Host,
+			"methods": r.Methods,
+			"path":    r.Path,
+			"pattern": r.Pattern,
+		})
+	}
+
+	return map[string]interface{}{
+		"name":        v.Config.Name,
+		"type":        v.ListenerType(),
+		"listener":    v.Config.Listener,
+		"comment":     v.Config.Comment,
+		"serverName":  v.Config.ServerName,
+		"serverAlias": v.Config.ServerAlias,
+		"draining":    v.Draining(),
+		"module":      moduleInfo(v.Module),
+		"services":    services,
+		"routes":      routes,
+	}
+}
+
+func (v *VHost) Bytecode(service string) (string, error) {
+	if service == "" {
+		return v.Module.Dump(), nil
+	}
+	for _, svc := range v.ServiceList {
+		if svc.config.Name != service {
+			continue
+		}
+		if svc.module == nil {
+			return "", fmt.Errorf("service %s has no module", service)
+		}
+		return svc.module.Dump(), nil
+	}
+	return "", fmt.Errorf("service %s is not existed", service)
+}
+
+func moduleRuleStats(service string, m *pl.Module) []server.RuleStats {
+	o := []server.RuleStats{}
+	if m == nil {
+		return o
+	}
+	for _, x := range m.Stats() {
+		o = append(o, server.RuleStats{
+			Service:   service,
+			RuleStats: x,
+		})
+	}
+	return o
+}
+
+func (v *VHost) RuleStats() []server.RuleStats {
+	o := moduleRuleStats("", v.Module)
+	for _, svc := range v.ServiceList {
+		o = append(o, moduleRuleStats(svc.config.Name, svc.module)...)
+	}
+	server.SortRuleStats(o)
+	return o
+}
+
+func (v *VHost) ResetRuleStats() {
+	if v.Module != nil {
+		v.Module.ResetStats()
+	}
+	for _, svc := range v.ServiceList {
+		if svc.module != nil {
+			svc.module.ResetStats()
+		}
+	}
+}
+
+func (v *VHost) ConfigAudit(service string) ([]pl.ConfigAuditEntry, error) {
+	if service == "" {
+		return v.configAudit, nil
+	}
+	for _, svc := range v.ServiceList {
+		if svc.config.Name == service {
+			return svc.configAudit, nil
+		}
+	}
+	return nil, fmt.Errorf("service %s is not existed", service)
+}
+
+func (v *VHost) Health() interface{} {
+	o := make(map[string]interface{})
+	h := v.healthCheck
+	if h == nil {
+		return o
+	}
+	for _, c := range h.checker {
+		list := []interface{}{}
+		for _, s := range c.Status() {
+			list = append(list, map[string]interface{}{
+				"addr":    s.Addr,
+				"healthy": s.Healthy,
+				"reason":  s.Reason,
+				"last":    s.Last,
+			})
+		}
+		o[c.Group()] = list
+	}
+	return o
+}
+
+func concurrencyStats(c *ratelimit.Concurrency) interface{} {
+	if c == nil {
+		return nil
+	}
+	s := c.Stats()
+	return map[string]interface{}{
+		"limit":    s.Limit,
+		"inflight": s.InFlight,
+		"maxQueue": s.MaxQueue,
+		"queued":   s.Queued,
+		"admitted": s.Admitted,
+		"rejected": s.Rejected,
+		"timedOut": s.TimedOut,
+	}
+}
+
+func (v *VHost) Metrics() interface{} {
+	services := make(map[string]interface{})
+	for _, svc := range v.ServiceList {
+		services[svc.config.Name] = map[string]interface{}{
+			"concurrency": concurrencyStats(svc.concurrency),
+			"idleSession": svc.servicePool.idleSize(),
+		}
+	}
+
+	return map[string]interface{}{
+		"inflight":       v.InFlight(),
+		"draining":       v.Draining(),
+		"concurrency":    concurrencyStats(v.concurrency),
+		"httpClientPool": v.clientPool.Stats(),
+		"services":       services,
+		"logSink":        v.sink.Stats(),
+		"logSample":      v.sampler.Stats(),
+		"logLevel":       v.logLevel.String(),
+	}
+}
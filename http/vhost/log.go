@@ -2,6 +2,8 @@ package vhost
 
 import (
 	"github.com/dianpeng/moons/alog"
+	"github.com/dianpeng/moons/util"
+	"net"
 	"net/http"
 	"time"
 )
@@ -117,14 +119,30 @@ func (l *logProvider) ServiceName() (string, bool) {
 	return "", false
 }
 
+// the remote address is the real client once the listener speaks the PROXY
+// protocol
 func (l *logProvider) ClientIp() (string, bool) {
-	return "", false
+	if l.hreq == nil || l.hreq.RemoteAddr == "" {
+		return "", false
+	}
+	if host, _, err := net.SplitHostPort(l.hreq.RemoteAddr); err == nil {
+		return host, true
+	}
+	return l.hreq.RemoteAddr, true
 }
 
 func (l *logProvider) Protocol() (string, bool) {
-	return "", false
+	if l.hreq == nil {
+		return "", false
+	}
+	return util.HttpProtocol(l.hreq), true
 }
 
 func (l *logProvider) Scheme() (string, bool) {
 	return "", false
 }
+
+func (l *logProvider) RequestId() (string, bool) {
+	id, _ := l.s.runtime.RequestId()
+	return id, id != ""
+}
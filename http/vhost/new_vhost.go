@@ -7,8 +7,10 @@ import (
 	"github.com/dianpeng/moons/http/runtime"
 	"github.com/dianpeng/moons/manifest"
 	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/ratelimit"
 	"io/fs"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -22,7 +24,14 @@ func (c *constHttpClientFactory) GetHttpClient(url string) (hpl.HttpClient, erro
 	}, nil
 }
 
-func initmodule(x string, config pl.EvalConfig, fs fs.FS) (*pl.Module, error) {
+func initmodule(
+	x string,
+	config pl.EvalConfig,
+	fs fs.FS,
+	policy *pl.IntrinsicPolicy,
+	capability int,
+	kvNamespace string,
+) (*pl.Module, error) {
 	p, err := pl.CompileModule(x, fs)
 	if err != nil {
 		return nil, err
@@ -30,6 +39,9 @@ func initmodule(x string, config pl.EvalConfig, fs fs.FS) (*pl.Module, error) {
 
 	session := &constHttpClientFactory{}
 	hpl := runtime.NewRuntimeWithModule(p)
+	hpl.Eval.SetPolicy(policy)
+	hpl.Eval.AddCapability(capability)
+	hpl.SetKVNamespace(kvNamespace)
 
 	if err := hpl.OnGlobal(session); err != nil {
 		return nil, err
@@ -73,7 +85,11 @@ func initVHost(
 		config: vhostConfig,
 	}
 
-	p, err := initmodule(string(vhostSource), vhostConfigBuilder, fsp)
+	audit := pl.NewConfigAudit(vhostConfigBuilder)
+
+	// the vhost name is only known after its config is evaluated, so the
+	// global scope of the vhost module uses the default kv namespace
+	p, err := initmodule(string(vhostSource), audit, fsp, nil, 0, "")
 	if err != nil {
 		return nil, wrapErr(
 			"http_vhost",
@@ -83,7 +99,12 @@ func initVHost(
 		)
 	}
 
-	return vhostConfig.Compose(p)
+	vhost, err := vhostConfig.Compose(p)
+	if err != nil {
+		return nil, err
+	}
+	vhost.configAudit = audit.Entries()
+	return vhost, nil
 }
 
 func initVHostSVC(
@@ -98,14 +119,17 @@ func initVHostSVC(
 	}
 
 	cfg := &vHSConfig{}
-	builder := &svcConfigBuilder{
+	audit := pl.NewConfigAudit(&svcConfigBuilder{
 		config: cfg,
-	}
+	})
 
 	p, err := initmodule(
 		string(src),
-		builder,
+		audit,
 		fsp,
+		vhost.Policy,
+		vhost.capability(),
+		vhost.Config.Name,
 	)
 	if err != nil {
 		return nil, wrapErr(
@@ -116,6 +140,19 @@ func initVHostSVC(
 		)
 	}
 
+	if cfg.Group != "" {
+		g, ok := vhost.Config.Group[cfg.Group]
+		if !ok {
+			return nil, wrapErr(
+				"service",
+				"route group",
+				path,
+				fmt.Errorf("route group %s is not found", cfg.Group),
+			)
+		}
+		cfg.joinGroup(g)
+	}
+
 	fac, err := cfg.Compose()
 	if err != nil {
 		return nil, wrapErr(
@@ -126,12 +163,24 @@ func initVHostSVC(
 		)
 	}
 
-	return newvHS(
+	svc, err := newvHS(
 		vhost,
 		fac,
 		cfg,
 		p,
 	)
+	if err != nil {
+		return nil, err
+	}
+	svc.configAudit = audit.Entries()
+	return svc, nil
+}
+
+// replies 503 to the request not admitted by the concurrency limit
+func rejectSaturated(w http.ResponseWriter, c *ratelimit.Concurrency) {
+	retry := (c.Option().RetryAfter + time.Second - 1) / time.Second
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(retry), 10))
+	http.Error(w, "service unavailable, too many requests in flight", http.StatusServiceUnavailable)
 }
 
 func doRoute(
@@ -140,6 +189,27 @@ func doRoute(
 	req *http.Request,
 ) {
 
+	if !vhs.vhost.enter() {
+		http.Error(writer, "service unavailable, vhost is draining", http.StatusServiceUnavailable)
+		return
+	}
+	defer vhs.vhost.leave()
+
+	// admission control, the service limit is checked before the vhost one so
+	// the request queued by a saturated service does not hold the slot of the
+	// vhost while waiting
+	for _, c := range []*ratelimit.Concurrency{vhs.concurrency, vhs.vhost.concurrency} {
+		if c == nil {
+			continue
+		}
+		release, err := c.Acquire(req.Context())
+		if err != nil {
+			rejectSaturated(writer, c)
+			return
+		}
+		defer release()
+	}
+
 	handler, err := vhs.getServiceHandler()
 
 	handler.main(
@@ -161,6 +231,7 @@ func CreateVHost(
 	if err != nil {
 		return nil, err
 	}
+	vhost.fs = manifest.FS
 
 	for _, cfg := range manifest.ServiceFile {
 		if svc, err := initVHostSVC(
@@ -170,19 +241,22 @@ func CreateVHost(
 		); err != nil {
 			return nil, err
 		} else {
-			_, err = newRouter(
-				svc.config.Router,
-				vhost.Router,
-				func(a http.ResponseWriter, b *http.Request) {
-					doRoute(svc, a, b)
-				},
-			)
-			if err != nil {
+			if err := vhost.addRoute(svc, cfg); err != nil {
 				return nil, err
 			}
 			vhost.ServiceList = append(vhost.ServiceList, svc)
 		}
 	}
 
+	// subscription and health checking start last since the events may arrive
+	// at once
+	if err := vhost.startSubscriber(); err != nil {
+		return nil, err
+	}
+	if err := vhost.startHealthCheck(); err != nil {
+		vhost.stopSubscriber()
+		return nil, err
+	}
+
 	return vhost, nil
 }
@@ -0,0 +1,84 @@
+package vhost
+
+import (
+	"time"
+
+	"github.com/dianpeng/moons/pl"
+)
+
+// Readiness of the vhost, checked by the /readyz probe of the listener. The
+// vhost is not ready while it is drained, or when the @health rule of the
+// vhost module, if defined, fails, returns false or does not finish in time.
+// The rule returning a string is not ready either, the string is the reason
+//
+//   rule "@health" {
+//     if kv::get("maintenance", false) {
+//       return "under maintenance";
+//     }
+//     return true;
+//   }
+//
+// The probes arriving while the rule is running share its result, so the load
+// balancers probing at once do not pile up the rule.
+
+type readyProbe struct {
+	done   chan struct{}
+	ready  bool
+	reason string
+}
+
+func (v *VHost) evalHealthRule() (bool, string) {
+	ret, err := v.newEventSession().call(pl.HealthRule, pl.NewValNull())
+	if err != nil {
+		return false, err.Error()
+	}
+	switch {
+	case ret.IsString():
+		return false, ret.String()
+	case ret.IsBool() && !ret.Bool():
+		return false, "@health rule returns false"
+	default:
+		return true, ""
+	}
+}
+
+func (v *VHost) runHealthRule() *readyProbe {
+	v.probeLock.Lock()
+	defer v.probeLock.Unlock()
+	if v.probe != nil {
+		return v.probe
+	}
+
+	p := &readyProbe{
+		done: make(chan struct{}),
+	}
+	v.probe = p
+	go func() {
+		p.ready, p.reason = v.evalHealthRule()
+		v.probeLock.Lock()
+		v.probe = nil
+		v.probeLock.Unlock()
+		close(p.done)
+	}()
+	return p
+}
+
+// Ready returns whether the vhost is ready to serve, and the reason if not
+func (v *VHost) Ready(timeout time.Duration) (bool, string) {
+	if v.Draining() {
+		return false, "vhost is draining"
+	}
+	if v.Module == nil || !v.Module.HaveEvent(pl.HealthRule) {
+		return true, ""
+	}
+
+	p := v.runHealthRule()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-p.done:
+		return p.ready, p.reason
+	case <-t.C:
+		return false, "@health rule is timed out"
+	}
+}
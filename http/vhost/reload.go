@@ -0,0 +1,30 @@
+package vhost
+
+import (
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/server"
+	"github.com/dianpeng/moons/util"
+)
+
+// OnManifestReloaded emits server.ManifestReloadedEvent into the vhost module
+// and each service module defining it, once the vhost is swapped in by the
+// manifest watcher
+func (v *VHost) OnManifestReloaded(files []string) {
+	context := pl.NewValMap()
+	context.AddMap("vhost", pl.NewValStr(v.Config.Name))
+	context.AddMap("files", pl.NewValStrList(files))
+
+	modules := []*pl.Module{v.Module}
+	for _, svc := range v.ServiceList {
+		modules = append(modules, svc.module)
+	}
+	for _, m := range modules {
+		if m == nil || !m.HaveEvent(server.ManifestReloadedEvent) {
+			continue
+		}
+		if err := v.newModuleEventSession(m).emit(server.ManifestReloadedEvent, context); err != nil {
+			util.Errorf("vhost %s: event %s failed: %s",
+				v.Config.Name, server.ManifestReloadedEvent, err.Error())
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package vhost
 
 import (
+	"bufio"
 	"fmt"
 	"github.com/dianpeng/moons/hpl"
 	"github.com/dianpeng/moons/pl"
 	"io"
+	"net"
 	"net/http"
 )
 
@@ -26,6 +28,10 @@ type responseWriterWrapper struct {
 	// pl.Val field for exposition
 	headerVal pl.Val
 	bodyVal   pl.Val
+
+	// event stream writer if the response is in sse mode, it must be closed
+	// before the http transaction is done since it may write from background
+	sse *hpl.SSEWriter
 }
 
 func ValIsHttpResponseWriter(
@@ -72,6 +78,17 @@ func (r *responseWriterWrapper) SetReply(
 	return false
 }
 
+// adds a Set-Cookie header, fails when the header is already flushed
+func (r *responseWriterWrapper) SetCookie(c *http.Cookie) bool {
+	if r.IsHeaderFlushed() {
+		return false
+	}
+	if v := c.String(); v != "" {
+		r.header.Add("Set-Cookie", v)
+	}
+	return true
+}
+
 // -----------------------------------------------------------------------------
 // Interface for pl.Usr
 func (r *responseWriterWrapper) Index(
@@ -170,6 +187,9 @@ var (
 	rwMethodFlush           = pl.MustNewFuncProto("http.response_writer.flush", "%0")
 	rwMethodIsHeaderFlushed = pl.MustNewFuncProto("http.response_writer.isHeaderFlushed", "%0")
 	rwMethodIsFlushed       = pl.MustNewFuncProto("http.response_writer.isFlushed", "%0")
+	rwMethodStream          = pl.MustNewFuncProto("http.response_writer.stream", "%0")
+	rwMethodSSE             = pl.MustNewFuncProto("http.response_writer.sse", "%0")
+	rwMethodSetCookie       = pl.MustNewFuncProto("http.response_writer.setCookie", "%U['http.cookie']")
 )
 
 func (r *responseWriterWrapper) Method(
@@ -202,6 +222,25 @@ func (r *responseWriterWrapper) Method(
 		}
 		return pl.NewValBool(r.IsHeaderFlushed()), nil
 
+	case "stream":
+		if _, err := rwMethodStream.Check(arg); err != nil {
+			return pl.NewValNull(), err
+		}
+		return r.Stream()
+
+	case "sse":
+		if _, err := rwMethodSSE.Check(arg); err != nil {
+			return pl.NewValNull(), err
+		}
+		return r.SSE()
+
+	case "setCookie":
+		if _, err := rwMethodSetCookie.Check(arg); err != nil {
+			return pl.NewValNull(), err
+		}
+		c, _ := hpl.HttpCookieFromVal(arg[0])
+		return pl.NewValBool(r.SetCookie(c)), nil
+
 	default:
 		break
 	}
@@ -312,6 +351,92 @@ func (r *responseWriterWrapper) Flush() bool {
 	return true
 }
 
+// Stream flushes the status and header out and hands the response body to the
+// caller as a writable stream, the body set before is discarded. After that
+// the response is considered flushed, and the body is finished once the
+// service is done
+func (r *responseWriterWrapper) Stream() (pl.Val, error) {
+	if r.bodyDone {
+		return pl.NewValNull(), fmt.Errorf("http.response_writer:stream, body already flushed")
+	}
+
+	r.FlushHeader()
+	if r.body != nil {
+		r.body.Close()
+	}
+	r.bodyDone = true
+	r.body = nil
+
+	return hpl.NewWritableStreamValFromStream(&rwBodyWriter{r: r}), nil
+}
+
+// SSE switches the response to Server-Sent Events, the event stream headers are
+// set and flushed, and the body is written by the returned event writer
+func (r *responseWriterWrapper) SSE() (pl.Val, error) {
+	if r.headerDone || r.bodyDone {
+		return pl.NewValNull(), fmt.Errorf("http.response_writer:sse, response already flushed")
+	}
+
+	hpl.SetSSEHeader(r.header)
+	if _, err := r.Stream(); err != nil {
+		return pl.NewValNull(), err
+	}
+
+	r.sse = hpl.NewSSEWriter(&rwBodyWriter{r: r})
+	return pl.NewValUsr(r.sse), nil
+}
+
+// body writer backed by the http.ResponseWriter, closing it does not close the
+// underlying connection
+type rwBodyWriter struct {
+	r *responseWriterWrapper
+}
+
+func (w *rwBodyWriter) Write(b []byte) (int, error) {
+	n, err := w.r.w.Write(b)
+	if err != nil {
+		w.r.bodyError = err
+	}
+	return n, err
+}
+
+func (w *rwBodyWriter) Flush() error {
+	if f, ok := w.r.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (w *rwBodyWriter) Close() error {
+	return nil
+}
+
+// Hijack takes over the connection, after that the response is considered
+// flushed and nothing will be written by the wrapper. The status is recorded
+// as 101 for the access log
+func (r *responseWriterWrapper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if r.headerDone {
+		return nil, nil, fmt.Errorf("http.response_writer: cannot hijack, header already flushed")
+	}
+	h, ok := r.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("http.response_writer: hijack is not supported")
+	}
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if r.body != nil {
+		r.body.Close()
+	}
+	r.status = http.StatusSwitchingProtocols
+	r.headerDone = true
+	r.bodyDone = true
+	r.body = nil
+	return conn, brw, nil
+}
+
 func (r *responseWriterWrapper) IsFlushed() bool {
 	return r.bodyDone
 }
@@ -323,6 +448,10 @@ func (r *responseWriterWrapper) IsHeaderFlushed() bool {
 // Finalize will finally try to flush the data out if needed and also it will
 // run the response hook if needed
 func (r *responseWriterWrapper) Finalize() {
+	if r.sse != nil {
+		r.sse.Close()
+		r.sse = nil
+	}
 	r.Flush()
 }
 
@@ -1,82 +1,105 @@
 package vhost
 
 import (
-	"fmt"
+	"io/fs"
 	"net/http"
-	"strings"
 
-	"github.com/gorilla/mux"
+	"github.com/dianpeng/moons/hrouter"
+	"github.com/dianpeng/moons/openapi"
 )
 
-var httpmethodlist = []string{
-	"GET",
-	"POST",
-	"PUT",
-	"DELETE",
-	"PURGE",
-	"OPTION",
-	"HEAD",
-}
+type serviceRoute struct {
+	route *hrouter.Route
 
-// parse the compact router representation to gorilla's mux
-func newRouter(
-	router string,
-	r *mux.Router,
-	callback func(http.ResponseWriter, *http.Request),
-) (*mux.Route, error) {
+	// operation of the OpenAPI spec routed, nil for the router of the service
+	op *openapi.Operation
+}
 
-	methodList := []string{}
-	path := ""
+// collects the routes of the service, ie the routes generated from the
+// OpenAPI spec followed by the router of the service. The routes of the spec
+// come first, so the router can serve the paths not described by the spec
+func (v *VHost) serviceRoute(svc *vHS) ([]serviceRoute, error) {
+	o := []serviceRoute{}
 
-	{
-		startSqr := strings.Index(router, "[")
-		if startSqr == -1 {
-			return nil, fmt.Errorf("invalid router: %s, cannot find [", router)
+	if svc.config.OpenAPI != "" {
+		data, err := fs.ReadFile(v.fs, svc.config.OpenAPI)
+		if err != nil {
+			return nil, err
 		}
-
-		endSqr := strings.Index(router, "]")
-		if endSqr == -1 {
-			return nil, fmt.Errorf("invalid router: %s, cannot find ]", router)
+		spec, err := openapi.Parse(data)
+		if err != nil {
+			return nil, err
 		}
-
-		mlist := strings.TrimSpace(router[startSqr+1 : endSqr])
-		if mlist == "*" {
-			methodList = httpmethodlist
-		} else {
-			x := strings.Split(mlist, ",")
-			for _, xx := range x {
-				methodList = append(methodList,
-					strings.ToUpper(
-						strings.TrimSpace(xx),
-					),
-				)
-			}
+		for _, op := range spec.Operation {
+			o = append(o, serviceRoute{
+				route: hrouter.NewRoute(op.Method, "", op.Path),
+				op:    op,
+			})
 		}
+	}
 
-		// now get the rest as path
-		path = router[endSqr+1:]
-		path = checkrouterpath(path)
+	if svc.config.OpenAPI == "" || svc.config.Router != "" {
+		r, err := hrouter.ParseRoute(svc.config.Router)
+		if err != nil {
+			return nil, err
+		}
+		o = append(o, serviceRoute{
+			route: r,
+		})
 	}
+	return o, nil
+}
 
-	rr := r.HandleFunc(path, callback)
+// registers the routes of the service, the route is mounted into the group of
+// the service if it has one
+func (v *VHost) addRoute(svc *vHS, path string) error {
+	route, err := v.serviceRoute(svc)
+	if err != nil {
+		return wrapErr(
+			"service",
+			"router",
+			path,
+			err,
+		)
+	}
 
-	if len(methodList) != 0 {
-		rr.Methods(methodList...)
+	var group *hrouter.Group
+	if svc.config.Group != "" {
+		group = &v.Config.Group[svc.config.Group].Group
 	}
 
-	return rr, nil
-}
+	for _, x := range route {
+		r := x.route
+		if group != nil {
+			if r, err = r.Mount(group); err != nil {
+				return wrapErr(
+					"service",
+					"router",
+					path,
+					err,
+				)
+			}
+		}
+		r.Service = svc.config.Name
 
-func checkrouterpath(p string) string {
-	idx := strings.LastIndex(p, "*")
-	if idx == -1 {
-		return p
-	} else {
-		suffix := p[:idx]
-		if len(suffix) != 0 && suffix[len(suffix)-1] == '/' {
-			return fmt.Sprintf("%s{_Rest:.*}", suffix)
+		var handler http.HandlerFunc
+		if op := x.op; op != nil {
+			handler = func(w http.ResponseWriter, req *http.Request) {
+				doRoute(svc, w, req.WithContext(openapi.WithOperation(req.Context(), op)))
+			}
 		} else {
-			return fmt.Sprintf("%s/{_Rest:.*}", suffix)
+			handler = func(w http.ResponseWriter, req *http.Request) {
+				doRoute(svc, w, req)
+			}
+		}
+		if err := v.Router.Handle(r, handler); err != nil {
+			return wrapErr(
+				"service",
+				"router",
+				path,
+				err,
+			)
 		}
 	}
+	return nil
 }
@@ -1,11 +1,16 @@
 package vhost
 
 import (
+	"bufio"
 	"fmt"
+	"io/fs"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/dianpeng/moons/alog"
+	"github.com/dianpeng/moons/cache"
 	"github.com/dianpeng/moons/g"
 	"github.com/dianpeng/moons/hpl"
 	"github.com/dianpeng/moons/hrouter"
@@ -33,6 +38,14 @@ type serviceHandler struct {
 	serviceResult framework.ApplicationResult
 	phase         string
 	phaseIndex    int
+
+	// response writer of the current http transaction
+	respWriter *responseWriterWrapper
+
+	// access log of the current http transaction, the upstream attempts are
+	// recorded into it and may come from concurrent calls
+	log     *alog.Log
+	logLock sync.Mutex
 }
 
 func newServicePool(cacheSize int) servicePool {
@@ -79,6 +92,9 @@ func newServiceHandler(s *framework.Service, vhs *vHS) *serviceHandler {
 		runtime: runtime.NewRuntimeWithModule(vhs.module),
 		vhs:     vhs,
 	}
+	vhs.vhost.setupEval(h.runtime.Eval)
+	h.runtime.SetKVNamespace(vhs.vhost.Config.Name)
+	h.runtime.SetLogLevel(vhs.vhost.logLevel)
 	return h
 }
 
@@ -105,11 +121,15 @@ func (s *serviceHandler) main(
 		s,
 		resp,
 	)
+	s.respWriter = respWrapper
 
 	log := alog.NewLog(s.vhs.vhost.LogFormat)
 	logP := &logProvider{
-		s: s,
+		s:       s,
+		startTs: time.Now(),
+		hreq:    req,
 	}
+	s.setLog(&log)
 
 	defer func() {
 
@@ -130,12 +150,17 @@ func (s *serviceHandler) main(
 		{
 			s.setPhase(phase.PhaseAccessLog, ".access_log")
 			s.Log(&log)
+			s.logFields(&log)
 		}
 
 		// cleanup work
+		if respWrapper.Status() >= 500 {
+			log.Failed = true
+		}
 		s.vhs.vhost.uploadLog(
 			&log,
 			logP,
+			req,
 		)
 		s.finish()
 	}()
@@ -239,6 +264,18 @@ func (s *serviceHandler) Log(log *alog.Log) error {
 	return err
 }
 
+// computes the custom fields of the access log, the failure is logged and
+// leaves the field null
+func (s *serviceHandler) logFields(log *alog.Log) {
+	if err := hpl.EvalLogFields(
+		s.runtime.Eval,
+		s.vhs.vhost.Config.LogFields,
+		log,
+	); err != nil {
+		util.Errorf("http_vhost %s: %s", s.vhs.vhost.Config.Name, err.Error())
+	}
+}
+
 // interface for frame.ServiceContext
 func (s *serviceHandler) Runtime() *runtime.Runtime {
 	return s.runtime
@@ -248,6 +285,29 @@ func (s *serviceHandler) HplSessionWrapper() runtime.SessionWrapper {
 	return s
 }
 
+// interface for framework.ConnHijacker
+func (s *serviceHandler) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if s.respWriter == nil {
+		return nil, nil, fmt.Errorf("no active http transaction")
+	}
+	return s.respWriter.Hijack()
+}
+
+// interface for framework.CacheProvider
+func (s *serviceHandler) Cache() *cache.Cache {
+	return s.vhs.vhost.cache
+}
+
+// interface for framework.FSProvider
+func (s *serviceHandler) FS() fs.FS {
+	return s.vhs.vhost.fs
+}
+
+// interface for framework.ResponseWriterProvider
+func (s *serviceHandler) ResponseWriter() framework.HttpResponseWriter {
+	return s.respWriter
+}
+
 // interface for alog.ServiceInfo
 func (s *serviceHandler) ServiceName() string {
 	return s.vhs.config.Name
@@ -274,6 +334,10 @@ func (s *serviceHandler) init(
 }
 
 func (s *serviceHandler) finish() {
+	s.respWriter = nil
+	s.setLog(nil)
+	s.runtime.Cleanup()
+
 	// http client pool draining operations
 	if s.activeHttpClient != nil {
 		for _, c := range s.activeHttpClient {
@@ -283,12 +347,52 @@ func (s *serviceHandler) finish() {
 	}
 }
 
+func (s *serviceHandler) setLog(log *alog.Log) {
+	s.logLock.Lock()
+	defer s.logLock.Unlock()
+	s.log = log
+}
+
+func (s *serviceHandler) recordAttempt(a util.HClientAttempt) {
+	s.logLock.Lock()
+	defer s.logLock.Unlock()
+	if s.log != nil {
+		s.log.Attempts = append(s.log.Attempts, alog.Attempt{
+			Upstream: a.Upstream,
+			Status:   a.Status,
+			Error:    a.Error,
+			Duration: a.Duration,
+		})
+	}
+}
+
+// the upstream calls of the session carry the request id, if any, and their
+// attempts are recorded into the access log
+func (s *serviceHandler) prepareHttpClient(c *util.HClient) {
+	if id, header := s.runtime.RequestId(); id != "" {
+		c.SetHeader(header, id)
+	}
+	c.SetAttemptHook(s.recordAttempt)
+}
+
 // interface for hpl.SessionWrapper
 func (s *serviceHandler) GetHttpClient(url string) (hpl.HttpClient, error) {
 	c, err := s.vhs.vhost.clientPool.Get(url)
 	if err != nil {
 		return nil, err
 	}
+	s.prepareHttpClient(&c)
+	s.activeHttpClient = append(s.activeHttpClient, &c)
+	return &c, nil
+}
+
+// interface for hpl.HttpClientOptionFactory
+func (s *serviceHandler) GetHttpClientWithOption(url string, option *hpl.HttpClientOption) (hpl.HttpClient, error) {
+	c, err := s.vhs.vhost.clientPool.GetWithOption(url, option)
+	if err != nil {
+		return nil, err
+	}
+	s.prepareHttpClient(&c)
 	s.activeHttpClient = append(s.activeHttpClient, &c)
 	return &c, nil
 }
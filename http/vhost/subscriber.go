@@ -0,0 +1,69 @@
+package vhost
+
+import (
+	"fmt"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/mq"
+	"github.com/dianpeng/moons/util"
+)
+
+// subscriber delivers the messages of a subscribed topic into the vhost
+// module as event. The messages of one subscription are delivered in order by
+// the broker, so each subscription owns its event session
+
+type subscriber struct {
+	*eventSession
+	config *hpl.MQSubscribe
+	broker mq.Broker
+	sub    mq.Subscription
+}
+
+func (s *subscriber) onMessage(m *mq.Message) {
+	if err := s.emit(s.config.Event, hpl.NewMQMessageVal(m)); err != nil {
+		util.Errorf("vhost %s: event %s of topic %s failed: %s",
+			s.vhost.Config.Name, s.config.Event, m.Topic, err.Error())
+	}
+}
+
+func (v *VHost) subscribe(config *hpl.MQSubscribe) (*subscriber, error) {
+	broker, err := mq.Dial(config.URL, config.Option)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &subscriber{
+		eventSession: v.newEventSession(),
+		config:       config,
+		broker:       broker,
+	}
+	sub, err := broker.Subscribe(config.Topic, config.Queue, s.onMessage)
+	if err != nil {
+		broker.Close()
+		return nil, err
+	}
+	s.sub = sub
+	return s, nil
+}
+
+// subscribes all the topics of the vhost config, nothing is subscribed if any
+// of them fails
+func (v *VHost) startSubscriber() error {
+	for _, config := range v.Config.Subscribe {
+		s, err := v.subscribe(config)
+		if err != nil {
+			v.stopSubscriber()
+			return fmt.Errorf("http_vhost: subscribe topic %s failed: %s", config.Topic, err.Error())
+		}
+		v.subscriber = append(v.subscriber, s)
+	}
+	return nil
+}
+
+func (v *VHost) stopSubscriber() {
+	for _, s := range v.subscriber {
+		s.sub.Unsubscribe()
+		s.broker.Close()
+	}
+	v.subscriber = nil
+}
@@ -2,7 +2,11 @@ package vhost
 
 import (
 	"fmt"
+	"github.com/dianpeng/moons/alog"
+	"github.com/dianpeng/moons/cache"
+	"github.com/dianpeng/moons/hpl"
 	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/ratelimit"
 )
 
 func propSetString(
@@ -43,3 +47,187 @@ func propSetInt64(
 	*ptr = v.Int()
 	return nil
 }
+
+// capabilities granted to the evaluators of the vhost, see pl.CapabilityEnv
+func (v *VHost) capability() int {
+	if v.Config.AllowEnv {
+		return pl.CapabilityEnv
+	}
+	return 0
+}
+
+// applies the intrinsic policy and the capabilities of the vhost to the
+// evaluator running the vhost or service module
+func (v *VHost) setupEval(e *pl.Evaluator) {
+	e.SetPolicy(v.Policy)
+	e.AddCapability(v.capability())
+}
+
+func propSetBool(
+	v pl.Val,
+	ptr *bool,
+	name string,
+) error {
+	if !v.IsBool() {
+		return fmt.Errorf("%s: set field error, value is not bool", name)
+	}
+
+	*ptr = v.Bool()
+	return nil
+}
+
+// accepts either a single string or a list of strings
+func propSetStrList(
+	v pl.Val,
+	ptr *[]string,
+	name string,
+) error {
+	if v.IsString() {
+		*ptr = []string{v.String()}
+		return nil
+	}
+	if !v.IsList() {
+		return fmt.Errorf("%s: set field error, value is not string or list", name)
+	}
+
+	o := []string{}
+	for _, x := range v.List().Data {
+		if !x.IsString() {
+			return fmt.Errorf("%s: set field error, list element is not string", name)
+		}
+		o = append(o, x.String())
+	}
+	*ptr = o
+	return nil
+}
+
+// accepts map of destination to http client option, see hpl.HttpClientOption
+func propSetHttpClientOption(
+	v pl.Val,
+	ptr *map[string]*hpl.HttpClientOption,
+	name string,
+) error {
+	if !v.IsMap() {
+		return fmt.Errorf("%s: set field error, value is not map", name)
+	}
+
+	o := make(map[string]*hpl.HttpClientOption)
+	var err error
+	v.Map().Foreach(
+		func(dest string, x pl.Val) bool {
+			option, e := hpl.NewHttpClientOptionFromVal(x)
+			if e != nil {
+				err = fmt.Errorf("%s: set field error, destination %s: %s", name, dest, e.Error())
+				return false
+			}
+			o[dest] = option
+			return true
+		},
+	)
+	if err != nil {
+		return err
+	}
+	*ptr = o
+	return nil
+}
+
+func propSetCacheOption(
+	v pl.Val,
+	ptr **cache.Option,
+	name string,
+) error {
+	option, err := hpl.NewCacheOptionFromVal(v)
+	if err != nil {
+		return fmt.Errorf("%s: set field error, %s", name, err.Error())
+	}
+	*ptr = option
+	return nil
+}
+
+func propSetConcurrencyOption(
+	v pl.Val,
+	ptr **ratelimit.ConcurrencyOption,
+	name string,
+) error {
+	option, err := hpl.NewConcurrencyOptionFromVal(v)
+	if err != nil {
+		return fmt.Errorf("%s: set field error, %s", name, err.Error())
+	}
+	*ptr = option
+	return nil
+}
+
+// accepts one subscription map or list of them
+func propSetMQSubscribe(
+	v pl.Val,
+	ptr *[]*hpl.MQSubscribe,
+	name string,
+) error {
+	list := []pl.Val{v}
+	if v.IsList() {
+		list = v.List().Data
+	}
+	o := []*hpl.MQSubscribe{}
+	for _, x := range list {
+		sub, err := hpl.NewMQSubscribeFromVal(x)
+		if err != nil {
+			return fmt.Errorf("%s: set field error, %s", name, err.Error())
+		}
+		o = append(o, sub)
+	}
+	*ptr = o
+	return nil
+}
+
+// accepts one access log sink map or list of them, see hpl.NewSinkOptionFromVal
+func propSetLogSink(
+	v pl.Val,
+	ptr *[]*alog.SinkOption,
+	name string,
+) error {
+	list := []pl.Val{v}
+	if v.IsList() {
+		list = v.List().Data
+	}
+	o := []*alog.SinkOption{}
+	for _, x := range list {
+		sink, err := hpl.NewSinkOptionFromVal(x)
+		if err != nil {
+			return fmt.Errorf("%s: set field error, %s", name, err.Error())
+		}
+		o = append(o, sink)
+	}
+	*ptr = o
+	return nil
+}
+
+// accepts map of upstream group name to upstream group, see
+// hpl.NewUpstreamFromVal
+func propSetUpstream(
+	v pl.Val,
+	ptr *map[string]*hpl.Upstream,
+	name string,
+) error {
+	if !v.IsMap() {
+		return fmt.Errorf("%s: set field error, value is not map", name)
+	}
+
+	o := make(map[string]*hpl.Upstream)
+	var err error
+	v.Map().Foreach(
+		func(group string, x pl.Val) bool {
+			b, e := hpl.NewUpstreamFromVal(x)
+			if e != nil {
+				err = fmt.Errorf("%s: set field error, upstream %s: %s", name, group, e.Error())
+				return false
+			}
+			o[group] = b
+			return true
+		},
+	)
+	if err != nil {
+		return err
+	}
+	*ptr = o
+	return nil
+}
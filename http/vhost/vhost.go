@@ -2,13 +2,19 @@ package vhost
 
 import (
 	"fmt"
-
-	"github.com/gorilla/mux"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/dianpeng/moons/alog"
+	"github.com/dianpeng/moons/cache"
 	"github.com/dianpeng/moons/g"
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/hrouter"
 	"github.com/dianpeng/moons/manifest"
 	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/ratelimit"
 	"github.com/dianpeng/moons/server"
 	"github.com/dianpeng/moons/util"
 )
@@ -21,23 +27,116 @@ type VHostConfig struct {
 	Listener   string
 	LogFormat  string
 
+	// encoding of the access log, text, json or logfmt, and the custom fields
+	// computed at the end of the request, see alog/structured.go
+	LogEncoding string
+	LogFields   []hpl.LogField
+
+	// sinks the access log is shipped to, see alog/sink.go
+	LogSink []*alog.SinkOption
+
+	// sampling of the access log, nil keeps all the records, and the initial
+	// level of the log:: functions, see alog/sample.go and alog/level.go
+	LogSample *alog.SampleOption
+	LogLevel  string
+
+	// other server names served by the vhost
+	ServerAlias []string
+
+	// whether script is allowed to access process environment via env::
+	AllowEnv bool
+
+	// intrinsic modules or functions the service scripts are allowed or denied
+	// to use, see pl.IntrinsicPolicy
+	AllowIntrinsic []string
+	DenyIntrinsic  []string
+
 	HttpClientPoolMaxSize      int64
 	HttpClientPoolTimeout      int64
 	HttpClientPoolMaxDrainSize int64
+
+	// per destination http client option, keyed by host or * for default
+	HttpClientOption map[string]*hpl.HttpClientOption
+
+	// upstream groups of the http client, the url whose host is the group name
+	// is balanced among the upstreams of the group
+	Upstream map[string]*hpl.Upstream
+
+	// shared response cache of the vhost, nil means no cache
+	Cache *cache.Option
+
+	// message queue topics subscribed by the vhost, each message is delivered
+	// into the vhost module as event
+	Subscribe []*hpl.MQSubscribe
+
+	// max in flight requests of the vhost, nil means no limit
+	Concurrency *ratelimit.ConcurrencyOption
+
+	// route groups, keyed by the group name, the services in the group share
+	// the path prefix, the host and the middlewares of the group
+	Group map[string]*vHostGroupConfig
+}
+
+type vHostGroupConfig struct {
+	hrouter.Group
+
+	// middlewares run before the ones of the service for request, and after
+	// the ones of the service for response
+	Request  vHSMiddlewareConfig
+	Response vHSMiddlewareConfig
 }
 
 type VHost struct {
+	// accessed atomically, kept first for the 64 bit alignment, see drain.go
+	inflight int64
+	draining int32
+
 	ServiceList []*vHS
-	Router      *mux.Router
+	Router      *hrouter.Router
 	LogFormat   *alog.Format
 	Config      *VHostConfig
 	Module      *pl.Module
+	Policy      *pl.IntrinsicPolicy
 	clientPool  *util.HClientPool
+	cache       *cache.Cache
+	subscriber  []*subscriber
+	healthCheck *healthCheck
+	concurrency *ratelimit.Concurrency
+	sink        alog.SinkGroup
+	sampler     *alog.Sampler
+	logLevel    *alog.Level
+
+	// calls made by the config phase of the vhost module
+	configAudit []pl.ConfigAuditEntry
+
+	// @health rule running for the readiness probe, see ready.go
+	probeLock sync.Mutex
+	probe     *readyProbe
+
+	// file system of the manifest
+	fs fs.FS
 }
 
+// config scope of the vhost module
+const (
+	vhostConfigInit = iota
+	vhostConfigVHost
+	vhostConfigGroup
+	vhostConfigGroupRequest
+	vhostConfigGroupResponse
+)
+
 type VHostConfigBuilder struct {
-	configPush bool
-	config     *VHostConfig
+	cur    []int
+	group  *vHostGroupConfig
+	config *VHostConfig
+}
+
+func (x *VHostConfigBuilder) curType() int {
+	if len(x.cur) == 0 {
+		return vhostConfigInit
+	}
+	return x.cur[len(x.cur)-1]
 }
 
 func (config *VHostConfig) Compose(p *pl.Module) (*VHost, error) {
@@ -49,14 +148,14 @@ func (config *VHostConfig) Compose(p *pl.Module) (*VHost, error) {
 			g.VHostLogFormat,
 		)
 
-		logf, err := alog.CompileFormat(logFormat)
+		logf, err := alog.CompileFormatEncoding(logFormat, config.LogEncoding)
 		if err != nil {
 			return nil, err
 		}
 		VHost.LogFormat = logf
 	}
 
-	router := mux.NewRouter()
+	router := hrouter.NewRouter()
 
 	// finish the creation of VHost object
 	VHost.Config = config
@@ -64,12 +163,61 @@ func (config *VHostConfig) Compose(p *pl.Module) (*VHost, error) {
 	VHost.ServiceList = nil
 	VHost.Module = p
 
+	if len(config.AllowIntrinsic) != 0 || len(config.DenyIntrinsic) != 0 {
+		VHost.Policy = pl.NewIntrinsicPolicy(
+			config.AllowIntrinsic,
+			config.DenyIntrinsic,
+		)
+	}
+
 	VHost.clientPool = util.NewHClientPool(
 		config.Name,
 		util.NotZeroInt64(config.HttpClientPoolMaxSize, g.VHostHttpClientPoolMaxSize),
 		util.NotZeroInt64(config.HttpClientPoolTimeout, g.VHostHttpClientPoolTimeout),
 		util.NotZeroInt64(config.HttpClientPoolMaxDrainSize, g.VHostHttpClientPoolMaxDrainSize),
 	)
+	for dest, option := range config.HttpClientOption {
+		VHost.clientPool.SetOption(dest, option)
+	}
+	for name, u := range config.Upstream {
+		VHost.clientPool.SetUpstream(name, u.Balancer)
+	}
+
+	if config.Concurrency != nil {
+		c, err := ratelimit.NewConcurrency(config.Concurrency)
+		if err != nil {
+			return nil, err
+		}
+		VHost.concurrency = c
+	}
+
+	if config.Cache != nil {
+		c, err := cache.New(config.Cache)
+		if err != nil {
+			return nil, err
+		}
+		VHost.cache = c
+	}
+
+	level, err := alog.NewLevel(config.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	VHost.logLevel = level
+
+	if config.LogSample != nil {
+		sampler, err := alog.NewSampler(config.LogSample)
+		if err != nil {
+			return nil, err
+		}
+		VHost.sampler = sampler
+	}
+
+	sink, err := alog.NewSinkGroup(config.LogSink)
+	if err != nil {
+		return nil, err
+	}
+	VHost.sink = sink
 
 	return VHost, nil
 }
@@ -79,24 +227,96 @@ func (x *VHostConfigBuilder) PushConfig(
 	name string,
 	_ pl.Val,
 ) error {
-	if x.configPush {
+	switch x.curType() {
+	case vhostConfigInit:
+		switch name {
+		case "http_vhost":
+			x.cur = append(x.cur, vhostConfigVHost)
+			return nil
+		case "route_group":
+			x.group = &vHostGroupConfig{}
+			x.cur = append(x.cur, vhostConfigGroup)
+			return nil
+		default:
+			return fmt.Errorf("http_vhost config: unknown config type, expect http_vhost or route_group")
+		}
+
+	case vhostConfigGroup:
+		switch name {
+		case "request":
+			x.cur = append(x.cur, vhostConfigGroupRequest)
+			return nil
+		case "response":
+			x.cur = append(x.cur, vhostConfigGroupResponse)
+			return nil
+		default:
+			return fmt.Errorf("route_group config: expect request/response type")
+		}
+
+	default:
 		return fmt.Errorf("http_vhost config: nested config scope is not allowed")
 	}
-	if name != "http_vhost" {
-		return fmt.Errorf("http_vhost config: unknown config type, expect http_vhost")
-	}
-
-	x.configPush = true
-	return nil
 }
 
 func (x *VHostConfigBuilder) PopConfig(
 	_ *pl.Evaluator,
 ) error {
-	x.configPush = false
+	if x.curType() == vhostConfigGroup {
+		if err := x.addGroup(); err != nil {
+			return err
+		}
+	}
+	x.cur = x.cur[:len(x.cur)-1]
 	return nil
 }
 
+func (x *VHostConfigBuilder) addGroup() error {
+	g := x.group
+	x.group = nil
+	if g.Name == "" {
+		return fmt.Errorf("route_group: name is not set")
+	}
+	if x.config.Group == nil {
+		x.config.Group = make(map[string]*vHostGroupConfig)
+	}
+	if _, ok := x.config.Group[g.Name]; ok {
+		return fmt.Errorf("route_group: %s is duplicated", g.Name)
+	}
+	x.config.Group[g.Name] = g
+	return nil
+}
+
+func (x *VHostConfigBuilder) groupProperty(
+	key string,
+	value pl.Val,
+) error {
+	switch key {
+	case "name":
+		return propSetString(
+			value,
+			&x.group.Name,
+			"route_group.name",
+		)
+
+	case "prefix":
+		return propSetString(
+			value,
+			&x.group.Prefix,
+			"route_group.prefix",
+		)
+
+	case "host":
+		return propSetString(
+			value,
+			&x.group.Host,
+			"route_group.host",
+		)
+
+	default:
+		return fmt.Errorf("route_group: unknown property: %s", key)
+	}
+}
+
 func (s *VHostConfigBuilder) ConfigProperty(
 	_ *pl.Evaluator,
 	key string,
@@ -104,7 +324,12 @@ func (s *VHostConfigBuilder) ConfigProperty(
 	_ pl.Val,
 ) error {
 
-	if !s.configPush {
+	switch s.curType() {
+	case vhostConfigVHost:
+		break
+	case vhostConfigGroup:
+		return s.groupProperty(key, value)
+	default:
 		return fmt.Errorf("config property must be set inside of http_vhost scope")
 	}
 
@@ -130,6 +355,13 @@ func (s *VHostConfigBuilder) ConfigProperty(
 			"http_vhost.server_name",
 		)
 
+	case "server_alias":
+		return propSetStrList(
+			value,
+			&s.config.ServerAlias,
+			"http_vhost.server_alias",
+		)
+
 	case "listener":
 		return propSetString(
 			value,
@@ -144,6 +376,64 @@ func (s *VHostConfigBuilder) ConfigProperty(
 			"http_vhost.log_format",
 		)
 
+	case "log_encoding":
+		return propSetString(
+			value,
+			&s.config.LogEncoding,
+			"http_vhost.log_encoding",
+		)
+
+	case "log_fields":
+		f, err := hpl.ParseLogFields(value, "http_vhost.log_fields")
+		if err != nil {
+			return err
+		}
+		s.config.LogFields = f
+		return nil
+
+	case "log_sink":
+		return propSetLogSink(
+			value,
+			&s.config.LogSink,
+			"http_vhost.log_sink",
+		)
+
+	case "log_sample":
+		option, err := hpl.NewSampleOptionFromVal(value)
+		if err != nil {
+			return fmt.Errorf("http_vhost.log_sample: set field error, %s", err.Error())
+		}
+		s.config.LogSample = option
+		return nil
+
+	case "log_level":
+		return propSetString(
+			value,
+			&s.config.LogLevel,
+			"http_vhost.log_level",
+		)
+
+	case "allow_env":
+		return propSetBool(
+			value,
+			&s.config.AllowEnv,
+			"http_vhost.allow_env",
+		)
+
+	case "allow_intrinsic":
+		return propSetStrList(
+			value,
+			&s.config.AllowIntrinsic,
+			"http_vhost.allow_intrinsic",
+		)
+
+	case "deny_intrinsic":
+		return propSetStrList(
+			value,
+			&s.config.DenyIntrinsic,
+			"http_vhost.deny_intrinsic",
+		)
+
 	case "http_client_pool_max_size":
 		return propSetInt64(
 			value,
@@ -165,6 +455,41 @@ func (s *VHostConfigBuilder) ConfigProperty(
 			"http_vhost.http_client_pool_max_drain_size",
 		)
 
+	case "http_client":
+		return propSetHttpClientOption(
+			value,
+			&s.config.HttpClientOption,
+			"http_vhost.http_client",
+		)
+
+	case "cache":
+		return propSetCacheOption(
+			value,
+			&s.config.Cache,
+			"http_vhost.cache",
+		)
+
+	case "concurrency":
+		return propSetConcurrencyOption(
+			value,
+			&s.config.Concurrency,
+			"http_vhost.concurrency",
+		)
+
+	case "subscribe":
+		return propSetMQSubscribe(
+			value,
+			&s.config.Subscribe,
+			"http_vhost.subscribe",
+		)
+
+	case "upstream":
+		return propSetUpstream(
+			value,
+			&s.config.Upstream,
+			"http_vhost.upstream",
+		)
+
 	default:
 		break
 	}
@@ -175,14 +500,51 @@ func (s *VHostConfigBuilder) ConfigProperty(
 func (x *VHostConfigBuilder) ConfigCommand(
 	_ *pl.Evaluator,
 	key string,
-	_ []pl.Val,
+	value []pl.Val,
 	_ pl.Val,
 ) error {
-	return fmt.Errorf("http_vhost: unknown command %s", key)
+	var list *vHSMiddlewareConfig
+	switch x.curType() {
+	case vhostConfigGroupRequest:
+		list = &x.group.Request
+	case vhostConfigGroupResponse:
+		list = &x.group.Response
+	default:
+		return fmt.Errorf("http_vhost: unknown command %s", key)
+	}
+	list.List = append(list.List, vHSMiddlewareConfigEntry{
+		Name:   key,
+		Config: value,
+	})
+	return nil
+}
+
+// Routes returns the compiled routes of the services
+func (v *VHost) Routes() []hrouter.Route {
+	return v.Router.Routes()
+}
+
+// uploads the record kept by the sampler, the record of the request carrying
+// the trigger header of the sampler is always kept
+func (v *VHost) uploadLog(l *alog.Log, p alog.Provider, req *http.Request) {
+	forced := false
+	if h := v.sampler.Header(); h != "" && req != nil {
+		forced = req.Header.Get(h) != ""
+	}
+	if v.sampler.Keep(l, forced) {
+		v.sink.Upload(l, p)
+	}
 }
 
-func (v *VHost) uploadLog(_ *alog.Log, _ alog.Provider) {
-	// TODO(dpeng): Add log sinking services
+// LogLevel is the level of the log:: functions of the vhost
+func (v *VHost) LogLevel() string {
+	return v.logLevel.String()
+}
+
+// SetLogLevel changes the level of the log:: functions, the level is back to
+// the one of the config once the vhost is reloaded
+func (v *VHost) SetLogLevel(name string) error {
+	return v.logLevel.Set(name)
 }
 
 // ----------------------------------------------------------------------------
@@ -191,6 +553,55 @@ func (v *VHost) Name() string {
 	return v.Config.Name
 }
 
+// Activate registers the shared objects of the vhost once it is served by the
+// listener, so the vhost created by a failed reload never replaces the ones of
+// the running vhost. The cache is registered under the vhost name so script
+// can open it, and the occupancy of the concurrency limiters is inspected by
+// the vhost name and "vhost/service"
+func (v *VHost) Activate() {
+	if v.cache != nil && v.Config.Name != "" {
+		cache.Register(v.Config.Name, v.cache)
+	}
+	if v.concurrency != nil {
+		ratelimit.RegisterConcurrency(v.Config.Name, v.concurrency)
+	}
+	for _, svc := range v.ServiceList {
+		if svc.concurrency != nil {
+			ratelimit.RegisterConcurrency(v.Config.Name+"/"+svc.config.Name, svc.concurrency)
+		}
+	}
+}
+
+// Retire stops the subscribers and the health checks of the vhost replaced by
+// reload, the requests in flight are still served by it, so the access log
+// sinks are closed once they have had the time to finish
+func (v *VHost) Retire() {
+	v.stopSubscriber()
+	v.stopHealthCheck()
+	time.AfterFunc(g.ShutdownTimeout*time.Second, v.sink.Close)
+}
+
+// Shutdown stops the background work of the vhost and runs the @shutdown rule
+// of the vhost module and of the service modules, the failure of the rule is
+// only logged since the vhost is going away anyway
+func (v *VHost) Shutdown() {
+	v.Retire()
+	defer v.sink.Close()
+
+	modules := []*pl.Module{v.Module}
+	for _, svc := range v.ServiceList {
+		modules = append(modules, svc.module)
+	}
+	for _, m := range modules {
+		if m == nil || !m.HaveEvent(pl.ShutdownRule) {
+			continue
+		}
+		if err := v.newModuleEventSession(m).emit(pl.ShutdownRule, pl.NewValNull()); err != nil {
+			util.Errorf("vhost %s: %s failed: %s", v.Config.Name, pl.ShutdownRule, err.Error())
+		}
+	}
+}
+
 func (v *VHost) ListenerName() string {
 	return v.Config.Listener
 }
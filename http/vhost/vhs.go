@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/dianpeng/moons/http/framework"
 	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/ratelimit"
 )
 
 type vHS struct {
@@ -12,6 +13,10 @@ type vHS struct {
 	config      *vHSConfig
 	vhost       *VHost
 	servicePool servicePool
+	concurrency *ratelimit.Concurrency
+
+	// calls made by the config phase of the service module
+	configAudit []pl.ConfigAuditEntry
 }
 
 func (s *vHS) getServiceHandler() (*serviceHandler, error) {
@@ -33,13 +38,22 @@ func newvHS(vhost *VHost,
 	config *vHSConfig,
 	p *pl.Module,
 ) (*vHS, error) {
-	return &vHS{
+	svc := &vHS{
 		factory:     factory,
 		module:      p,
 		config:      config,
 		vhost:       vhost,
 		servicePool: newServicePool(config.MaxSessionCacheSize),
-	}, nil
+	}
+
+	if config.Concurrency != nil {
+		c, err := ratelimit.NewConcurrency(config.Concurrency)
+		if err != nil {
+			return nil, err
+		}
+		svc.concurrency = c
+	}
+	return svc, nil
 }
 
 // We use a data oriented method, during the bytecode execution, firstly we do
@@ -61,6 +75,9 @@ type vHSConfig struct {
 	Tag                 string
 	Comment             string
 	Router              string
+	OpenAPI             string
+	Group               string
+	Concurrency         *ratelimit.ConcurrencyOption
 	MaxSessionCacheSize int
 
 	// middleware part, ie request, response, application etc ...
@@ -70,6 +87,18 @@ type vHSConfig struct {
 	AppConfig []pl.Val
 }
 
+// wraps the middlewares of the service with the ones of its group
+func (cfg *vHSConfig) joinGroup(g *vHostGroupConfig) {
+	cfg.Request.List = append(
+		append([]vHSMiddlewareConfigEntry{}, g.Request.List...),
+		cfg.Request.List...,
+	)
+	cfg.Response.List = append(
+		append([]vHSMiddlewareConfigEntry{}, cfg.Response.List...),
+		g.Response.List...,
+	)
+}
+
 func (cfg *vHSConfig) Compose() (*framework.ServiceFactory, error) {
 	o := &framework.ServiceFactory{}
 	{
@@ -233,6 +262,36 @@ func (s *svcConfigBuilder) propService(
 		}
 		break
 
+	case "group":
+		if err := propSetString(
+			value,
+			&s.config.Group,
+			"service.group",
+		); err != nil {
+			return err
+		}
+		break
+
+	case "openapi":
+		if err := propSetString(
+			value,
+			&s.config.OpenAPI,
+			"service.openapi",
+		); err != nil {
+			return err
+		}
+		break
+
+	case "concurrency":
+		if err := propSetConcurrencyOption(
+			value,
+			&s.config.Concurrency,
+			"service.concurrency",
+		); err != nil {
+			return err
+		}
+		break
+
 	case "max_session_cache_size":
 		if err := propSetInt(
 			value,
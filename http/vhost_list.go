@@ -23,17 +23,24 @@ func newvhostlist() vhostlist {
 	}
 }
 
+// the server name followed by the aliases of the vhost
+func serverNameList(vhost *vhost.VHost) []string {
+	return append(
+		[]string{vhost.Config.ServerName},
+		vhost.Config.ServerAlias...,
+	)
+}
+
 func (v *vhostlist) add(
 	vhost *vhost.VHost,
 ) error {
-	serverName := vhost.Config.ServerName
 	vhostName := vhost.Config.Name
 
 	// (0) try to get it from the serverNameIndex if needed.
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
-	{
+	for _, serverName := range serverNameList(vhost) {
 		_, ok := v.index[serverName]
 		if ok {
 			return fmt.Errorf("server name %s already existed", serverName)
@@ -46,7 +53,9 @@ func (v *vhostlist) add(
 		}
 	}
 
-	v.index[serverName] = vhost
+	for _, serverName := range serverNameList(vhost) {
+		v.index[serverName] = vhost
+	}
 	v.name[vhostName] = vhost
 	return nil
 }
@@ -60,25 +69,39 @@ func (v *vhostlist) remove(
 	if !ok {
 		return false
 	}
-	delete(v.index, val.Config.ServerName)
+	for _, serverName := range serverNameList(val) {
+		delete(v.index, serverName)
+	}
 	delete(v.name, vhostName)
 	return true
 }
 
+// replaces the vhost of the same name in one go, so the request never sees
+// the server name unbound in between
 func (v *vhostlist) update(
 	vhost *vhost.VHost,
-) {
-	serverName := vhost.Config.ServerName
+) error {
 	vhostName := vhost.Config.Name
 
-	// make the name and index table consistent
-	v.remove(vhostName)
-
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
-	v.index[serverName] = vhost
+	for _, serverName := range serverNameList(vhost) {
+		if x, ok := v.index[serverName]; ok && x.Config.Name != vhostName {
+			return fmt.Errorf("server name %s already existed", serverName)
+		}
+	}
+
+	if old, ok := v.name[vhostName]; ok {
+		for _, serverName := range serverNameList(old) {
+			delete(v.index, serverName)
+		}
+	}
+	for _, serverName := range serverNameList(vhost) {
+		v.index[serverName] = vhost
+	}
 	v.name[vhostName] = vhost
+	return nil
 }
 
 func (v *vhostlist) get(
@@ -94,6 +117,16 @@ func (v *vhostlist) get(
 	}
 }
 
+func (v *vhostlist) list() []*vhost.VHost {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	o := make([]*vhost.VHost, 0, len(v.name))
+	for _, val := range v.name {
+		o = append(o, val)
+	}
+	return o
+}
+
 func (v *vhostlist) resolve(
 	host string,
 ) *vhost.VHost {
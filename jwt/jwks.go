@@ -0,0 +1,288 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// JSON web key set(RFC 7517) fetched from the URL of the issuer. The key set
+// is cached and refetched periodically, a token signed by an unknown key id
+// also triggers a refetch so the rotated keys are picked up, but at most once
+// per minRefetch to avoid hammering the issuer with forged tokens
+
+const (
+	refreshInterval = 10 * time.Minute
+	minRefetch      = 30 * time.Second
+	fetchTimeout    = 5 * time.Second
+
+	// max bytes of the key set document
+	maxKeySet = 1024 * 1024
+)
+
+// ErrKeySet is returned, wrapped with the reason, when the key set cannot be
+// fetched and no cached key verifies the token
+var ErrKeySet = errors.New("jwt: key set is unavailable")
+
+type Key struct {
+	Kid string
+	Kty string
+	Alg string
+
+	// []byte, *rsa.PublicKey or *ecdsa.PublicKey
+	Key interface{}
+}
+
+// whether the key can verify the algorithm
+func (k *Key) accept(alg string) bool {
+	if len(alg) < 2 || (k.Alg != "" && k.Alg != alg) {
+		return false
+	}
+	switch k.Kty {
+	case "oct":
+		return alg[:2] == "HS"
+	case "RSA":
+		return alg[:2] == "RS" || alg[:2] == "PS"
+	case "EC":
+		return alg[:2] == "ES"
+	default:
+		return false
+	}
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+
+	// oct
+	K string `json:"k"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func bigIntOf(s string) (*big.Int, error) {
+	b, err := decodeSegment(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (j *jwk) toKey() (*Key, error) {
+	k := &Key{
+		Kid: j.Kid,
+		Kty: j.Kty,
+		Alg: j.Alg,
+	}
+	switch j.Kty {
+	case "oct":
+		b, err := decodeSegment(j.K)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("invalid oct key")
+		}
+		k.Key = b
+
+	case "RSA":
+		n, err := bigIntOf(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := bigIntOf(j.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		k.Key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", j.Crv)
+		}
+		x, err := bigIntOf(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := bigIntOf(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("EC point is not on curve")
+		}
+		k.Key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", j.Kty)
+	}
+	return k, nil
+}
+
+// ParseKeySet decodes the key set document, the keys not for signature or of
+// unsupported type are skipped
+func ParseKeySet(data []byte) ([]*Key, error) {
+	doc := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("jwt: invalid key set: %s", err.Error())
+	}
+
+	o := []*Key{}
+	for _, j := range doc.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		if k, err := j.toKey(); err == nil {
+			o = append(o, k)
+		}
+	}
+	return o, nil
+}
+
+type KeySet struct {
+	sync.Mutex
+	url    string
+	client *http.Client
+	keys   []*Key
+
+	// when the key set is fetched successfully, when it is tried last and the
+	// error of the last try
+	fetched time.Time
+	tried   time.Time
+	err     error
+}
+
+func newKeySet(url string) *KeySet {
+	return &KeySet{
+		url: url,
+		client: &http.Client{
+			Timeout: fetchTimeout,
+		},
+	}
+}
+
+func (k *KeySet) URL() string {
+	return k.url
+}
+
+func (k *KeySet) load() ([]*Key, error) {
+	resp, err := k.client.Get(k.url)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKeySet, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected status %d", ErrKeySet, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxKeySet))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKeySet, err.Error())
+	}
+	keys, err := ParseKeySet(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKeySet, err.Error())
+	}
+	return keys, nil
+}
+
+// the cached keys are kept if the fetch fails
+func (k *KeySet) fetch(now time.Time) {
+	k.tried = now
+	keys, err := k.load()
+	if err != nil {
+		k.err = err
+		return
+	}
+	k.keys = keys
+	k.fetched = now
+	k.err = nil
+}
+
+func (k *KeySet) find(kid string, alg string) []*Key {
+	o := []*Key{}
+	for _, x := range k.keys {
+		if (kid == "" || x.Kid == kid) && x.accept(alg) {
+			o = append(o, x)
+		}
+	}
+	return o
+}
+
+// Keys returns the keys which may verify the token, the token without kid is
+// checked against all the keys of its algorithm
+func (k *KeySet) Keys(kid string, alg string) ([]*Key, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	now := time.Now()
+	if now.Sub(k.fetched) >= refreshInterval && now.Sub(k.tried) >= minRefetch {
+		k.fetch(now)
+	}
+	o := k.find(kid, alg)
+	if len(o) == 0 && now.Sub(k.tried) >= minRefetch {
+		k.fetch(now)
+		o = k.find(kid, alg)
+	}
+	if len(o) == 0 {
+		if k.err != nil {
+			return nil, k.err
+		}
+		return nil, fmt.Errorf("jwt: no key of kid %q and algorithm %s", kid, alg)
+	}
+	return o, nil
+}
+
+// Verify checks the signature of the token by the key set
+func (k *KeySet) Verify(t *Token) error {
+	keys, err := k.Keys(t.Kid, t.Alg)
+	if err != nil {
+		return err
+	}
+	for _, x := range keys {
+		if err = t.Verify(x.Key); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// key sets are shared by the url so all the sessions use the same cache
+var (
+	registryLock sync.Mutex
+	registry     = make(map[string]*KeySet)
+)
+
+func Open(url string) *KeySet {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if k, ok := registry[url]; ok {
+		return k
+	}
+	k := newKeySet(url)
+	registry[url] = k
+	return k
+}
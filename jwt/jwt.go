@@ -0,0 +1,191 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// JSON web token(RFC 7519) in compact serialization signed by JWS(RFC 7515).
+// The algorithms supported are HS256/384/512 with oct key, RS256/384/512 and
+// PS256/384/512 with RSA key and ES256/384/512 with EC key, the unsecured
+// token(alg none) is always rejected
+
+var (
+	ErrMalformed = errors.New("jwt: token is malformed")
+	ErrSignature = errors.New("jwt: signature is invalid")
+	ErrExpired   = errors.New("jwt: token is expired")
+	ErrNotBefore = errors.New("jwt: token is not valid yet")
+)
+
+type Token struct {
+	Alg string
+	Kid string
+
+	// the decoded header and claims, the raw claims is the JSON payload
+	Header    map[string]interface{}
+	Claims    map[string]interface{}
+	RawClaims []byte
+
+	signed    string
+	signature []byte
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// Parse decodes the token without verifying it
+func Parse(token string) (*Token, error) {
+	seg := strings.Split(token, ".")
+	if len(seg) != 3 {
+		return nil, ErrMalformed
+	}
+
+	header, err := decodeSegment(seg[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	payload, err := decodeSegment(seg[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	signature, err := decodeSegment(seg[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	t := &Token{
+		RawClaims: payload,
+		signed:    seg[0] + "." + seg[1],
+		signature: signature,
+	}
+	if err := json.Unmarshal(header, &t.Header); err != nil {
+		return nil, ErrMalformed
+	}
+	if err := json.Unmarshal(payload, &t.Claims); err != nil {
+		return nil, ErrMalformed
+	}
+	t.Alg, _ = t.Header["alg"].(string)
+	t.Kid, _ = t.Header["kid"].(string)
+	if t.Alg == "" {
+		return nil, ErrMalformed
+	}
+	return t, nil
+}
+
+func hashOf(alg string) (crypto.Hash, bool) {
+	if len(alg) != 5 {
+		return 0, false
+	}
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, true
+	case "384":
+		return crypto.SHA384, true
+	case "512":
+		return crypto.SHA512, true
+	default:
+		return 0, false
+	}
+}
+
+// Verify checks the signature of the token by the key, which is []byte for
+// HS*, *rsa.PublicKey for RS* and PS* and *ecdsa.PublicKey for ES*
+func (t *Token) Verify(key interface{}) error {
+	h, ok := hashOf(t.Alg)
+	if !ok {
+		return fmt.Errorf("jwt: unsupported algorithm %s", t.Alg)
+	}
+
+	switch t.Alg[:2] {
+	case "HS":
+		k, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("jwt: key of %s must be oct", t.Alg)
+		}
+		mac := hmac.New(h.New, k)
+		mac.Write([]byte(t.signed))
+		if !hmac.Equal(mac.Sum(nil), t.signature) {
+			return ErrSignature
+		}
+		return nil
+
+	case "RS", "PS":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("jwt: key of %s must be RSA", t.Alg)
+		}
+		digest := h.New()
+		digest.Write([]byte(t.signed))
+		var err error
+		if t.Alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(k, h, digest.Sum(nil), t.signature)
+		} else {
+			err = rsa.VerifyPSS(k, h, digest.Sum(nil), t.signature, &rsa.PSSOptions{
+				SaltLength: rsa.PSSSaltLengthEqualsHash,
+			})
+		}
+		if err != nil {
+			return ErrSignature
+		}
+		return nil
+
+	case "ES":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("jwt: key of %s must be EC", t.Alg)
+		}
+		// the signature is r and s concatenated, each of the size of the curve
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(t.signature) != 2*size {
+			return ErrSignature
+		}
+		r := new(big.Int).SetBytes(t.signature[:size])
+		s := new(big.Int).SetBytes(t.signature[size:])
+		digest := h.New()
+		digest.Write([]byte(t.signed))
+		if !ecdsa.Verify(k, digest.Sum(nil), r, s) {
+			return ErrSignature
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("jwt: unsupported algorithm %s", t.Alg)
+	}
+}
+
+func (t *Token) numericClaim(name string) (time.Time, bool) {
+	v, ok := t.Claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+// ValidateTime checks exp and nbf of the token if they exist, leeway allows
+// the clock skew between the issuer and us
+func (t *Token) ValidateTime(now time.Time, leeway time.Duration) error {
+	if exp, ok := t.numericClaim("exp"); ok && !now.Before(exp.Add(leeway)) {
+		return ErrExpired
+	}
+	if nbf, ok := t.numericClaim("nbf"); ok && now.Add(leeway).Before(nbf) {
+		return ErrNotBefore
+	}
+	return nil
+}
+
+func (t *Token) Subject() string {
+	s, _ := t.Claims["sub"].(string)
+	return s
+}
@@ -0,0 +1,282 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func unsigned(header, claims map[string]interface{}) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	return b64(h) + "." + b64(c)
+}
+
+// signs the token by the private key, []byte for HS*, *rsa.PrivateKey for RS*
+// and PS* and *ecdsa.PrivateKey for ES*
+func sign(alg, kid string, claims map[string]interface{}, key interface{}) string {
+	header := map[string]interface{}{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	signed := unsigned(header, claims)
+	if alg == "none" {
+		return signed + "."
+	}
+
+	h, _ := hashOf(alg)
+	digest := h.New()
+	digest.Write([]byte(signed))
+
+	var sig []byte
+	switch alg[:2] {
+	case "HS":
+		mac := hmac.New(h.New, key.([]byte))
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case "RS":
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), h, digest.Sum(nil))
+	case "PS":
+		sig, _ = rsa.SignPSS(rand.Reader, key.(*rsa.PrivateKey), h, digest.Sum(nil),
+			&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES":
+		k := key.(*ecdsa.PrivateKey)
+		r, s, _ := ecdsa.Sign(rand.Reader, k, digest.Sum(nil))
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, x := range []string{
+		"",
+		"a.b",
+		"a.b.c.d",
+		"!!.e30.",
+		"e30.!!.",
+		"e30.e30.!!",
+		unsigned(map[string]interface{}{"typ": "JWT"}, nil) + ".",
+		b64([]byte("[1]")) + "." + b64([]byte("{}")) + ".",
+		b64([]byte(`{"alg":"HS256"}`)) + "." + b64([]byte("1")) + ".",
+	} {
+		_, err := Parse(x)
+		assert.Equal(ErrMalformed, err, x)
+	}
+
+	tk, err := Parse(sign("HS256", "k1", map[string]interface{}{"sub": "bob"}, []byte("secret")))
+	assert.Nil(err)
+	assert.Equal("HS256", tk.Alg)
+	assert.Equal("k1", tk.Kid)
+	assert.Equal("bob", tk.Subject())
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherRSA, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := []byte("secret")
+	claims := map[string]interface{}{"sub": "bob"}
+
+	// replaces the claims or the algorithm of the token, keeps the signature
+	tampered := func(token string) string {
+		tk, _ := Parse(token)
+		return unsigned(tk.Header, map[string]interface{}{"sub": "eve"}) +
+			token[len(tk.signed):]
+	}
+	withAlg := func(token string, alg string) string {
+		tk, _ := Parse(token)
+		tk.Header["alg"] = alg
+		return unsigned(tk.Header, tk.Claims) + token[len(tk.signed):]
+	}
+
+	cases := []struct {
+		name  string
+		token string
+		key   interface{}
+		ok    bool
+	}{
+		{"hs256", sign("HS256", "", claims, secret), secret, true},
+		{"hs384", sign("HS384", "", claims, secret), secret, true},
+		{"hs512", sign("HS512", "", claims, secret), secret, true},
+		{"rs256", sign("RS256", "", claims, rsaKey), &rsaKey.PublicKey, true},
+		{"ps384", sign("PS384", "", claims, rsaKey), &rsaKey.PublicKey, true},
+		{"es256", sign("ES256", "", claims, ecKey), &ecKey.PublicKey, true},
+
+		{"none", sign("none", "", claims, nil), secret, false},
+		{"none with rsa", sign("none", "", claims, nil), &rsaKey.PublicKey, false},
+		{"unknown alg", withAlg(sign("HS256", "", claims, secret), "HS1"), secret, false},
+		{"hs256 as hs512", withAlg(sign("HS256", "", claims, secret), "HS512"), secret, false},
+		{"hs wrong secret", sign("HS256", "", claims, []byte("other")), secret, false},
+		{"hs tampered", tampered(sign("HS256", "", claims, secret)), secret, false},
+		{"hs with rsa key", sign("HS256", "", claims, secret), &rsaKey.PublicKey, false},
+		{"rs wrong key", sign("RS256", "", claims, otherRSA), &rsaKey.PublicKey, false},
+		{"rs tampered", tampered(sign("RS256", "", claims, rsaKey)), &rsaKey.PublicKey, false},
+		{"rs with oct key", sign("RS256", "", claims, rsaKey), secret, false},
+		{"ps as rs", withAlg(sign("PS256", "", claims, rsaKey), "RS256"), &rsaKey.PublicKey, false},
+		{"es tampered", tampered(sign("ES256", "", claims, ecKey)), &ecKey.PublicKey, false},
+		{"es with rsa key", sign("ES256", "", claims, ecKey), &rsaKey.PublicKey, false},
+	}
+
+	for _, c := range cases {
+		tk, err := Parse(c.token)
+		if !assert.Nil(t, err, c.name) {
+			continue
+		}
+		err = tk.Verify(c.key)
+		if c.ok {
+			assert.Nil(t, err, c.name)
+		} else {
+			assert.NotNil(t, err, c.name)
+		}
+	}
+}
+
+func TestValidateTime(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	leeway := 30 * time.Second
+
+	cases := []struct {
+		name   string
+		claims map[string]interface{}
+		err    error
+	}{
+		{"no claim", map[string]interface{}{}, nil},
+		{"valid", map[string]interface{}{"exp": 1000100, "nbf": 999900}, nil},
+		{"expired", map[string]interface{}{"exp": 999900}, ErrExpired},
+		{"expired at now", map[string]interface{}{"exp": 999970}, ErrExpired},
+		{"expired within leeway", map[string]interface{}{"exp": 999980}, nil},
+		{"not before", map[string]interface{}{"nbf": 1000100}, ErrNotBefore},
+		{"not before within leeway", map[string]interface{}{"nbf": 1000020}, nil},
+		{"non numeric", map[string]interface{}{"exp": "999900"}, nil},
+	}
+
+	for _, c := range cases {
+		tk, err := Parse(sign("HS256", "", c.claims, []byte("secret")))
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, c.err, tk.ValidateTime(now, leeway), c.name)
+	}
+}
+
+func rsaJWK(kid string, k *rsa.PublicKey) map[string]interface{} {
+	return map[string]interface{}{
+		"kid": kid,
+		"kty": "RSA",
+		"alg": "RS256",
+		"use": "sig",
+		"n":   b64(k.N.Bytes()),
+		"e":   b64(big.NewInt(int64(k.E)).Bytes()),
+	}
+}
+
+func TestParseKeySet(t *testing.T) {
+	assert := assert.New(t)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	doc, _ := json.Marshal(map[string]interface{}{
+		"keys": []interface{}{
+			rsaJWK("r1", &rsaKey.PublicKey),
+			map[string]interface{}{
+				"kid": "e1", "kty": "EC", "crv": "P-256",
+				"x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes()),
+			},
+			map[string]interface{}{"kid": "o1", "kty": "oct", "k": b64([]byte("secret"))},
+			map[string]interface{}{"kid": "enc", "kty": "oct", "use": "enc", "k": b64([]byte("x"))},
+			map[string]interface{}{"kid": "bad", "kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"},
+			map[string]interface{}{"kid": "okp", "kty": "OKP"},
+		},
+	})
+	keys, err := ParseKeySet(doc)
+	assert.Nil(err)
+	assert.Equal(3, len(keys))
+	assert.Equal("r1", keys[0].Kid)
+	assert.True(keys[0].accept("RS256"))
+	assert.False(keys[0].accept("PS256"))
+	assert.False(keys[0].accept("HS256"))
+	assert.True(keys[1].accept("ES256"))
+	assert.False(keys[1].accept("RS256"))
+	assert.True(keys[2].accept("HS256"))
+	assert.False(keys[2].accept("none"))
+
+	_, err = ParseKeySet([]byte("{"))
+	assert.NotNil(err)
+}
+
+func TestKeySet(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	claims := map[string]interface{}{"sub": "bob"}
+
+	doc, _ := json.Marshal(map[string]interface{}{
+		"keys": []interface{}{rsaJWK("r1", &rsaKey.PublicKey)},
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(doc)
+	}))
+	defer srv.Close()
+
+	ks := Open(srv.URL)
+	assert.True(t, ks == Open(srv.URL))
+
+	// the modulus of the RSA key used as HMAC secret, ie the algorithm
+	// confusion attack, must not verify against the RSA key set
+	pub := rsaKey.PublicKey.N.Bytes()
+
+	cases := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"rs256", sign("RS256", "r1", claims, rsaKey), true},
+		{"rs256 without kid", sign("RS256", "", claims, rsaKey), true},
+		{"unknown kid", sign("RS256", "r2", claims, rsaKey), false},
+		{"alg mismatch", sign("PS256", "r1", claims, rsaKey), false},
+		{"hs against rsa", sign("HS256", "r1", claims, pub), false},
+		{"hs against rsa without kid", sign("HS256", "", claims, pub), false},
+		{"none", sign("none", "r1", claims, nil), false},
+	}
+	for _, c := range cases {
+		tk, err := Parse(c.token)
+		if !assert.Nil(t, err, c.name) {
+			continue
+		}
+		err = ks.Verify(tk)
+		if c.ok {
+			assert.Nil(t, err, c.name)
+		} else {
+			assert.NotNil(t, err, c.name)
+		}
+	}
+}
+
+func TestKeySetUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	tk, err := Parse(sign("RS256", "r1", map[string]interface{}{}, rsaKey))
+	assert.Nil(t, err)
+	err = Open(srv.URL).Verify(tk)
+	assert.ErrorIs(t, err, ErrKeySet)
+}
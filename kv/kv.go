@@ -0,0 +1,275 @@
+package kv
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dianpeng/moons/g"
+)
+
+// Process wide key value store shared by all sessions. The store is split
+// into namespaces, ie one per vhost, which share the memory bound of the
+// store, the least recently used entry is evicted when the bound is reached.
+// The value is the generic go value, ie the one converted by pl.Unmarshal,
+// and must not be mutated once stored
+
+type Store struct {
+	sync.Mutex
+	lru        *list.List
+	entry      map[string]*list.Element
+	size       int64
+	maxSize    int64
+	maxEntries int
+}
+
+type item struct {
+	key    string
+	value  interface{}
+	size   int64
+	expire time.Time
+}
+
+func (i *item) expired(now time.Time) bool {
+	return !i.expire.IsZero() && !now.Before(i.expire)
+}
+
+var Default = NewStore(g.KVMaxSize, g.KVMaxEntries)
+
+// NewStore creates a store bounded by total size and number of entries, zero
+// means unlimited
+func NewStore(maxSize int64, maxEntries int) *Store {
+	return &Store{
+		lru:        list.New(),
+		entry:      make(map[string]*list.Element),
+		maxSize:    maxSize,
+		maxEntries: maxEntries,
+	}
+}
+
+func (s *Store) SetLimit(maxSize int64, maxEntries int) {
+	s.Lock()
+	defer s.Unlock()
+	s.maxSize = maxSize
+	s.maxEntries = maxEntries
+	s.evict()
+}
+
+func (s *Store) Len() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.lru.Len()
+}
+
+func (s *Store) Size() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.size
+}
+
+func (s *Store) Namespace(name string) *Namespace {
+	return &Namespace{
+		store:  s,
+		prefix: name + "\x00",
+	}
+}
+
+func (s *Store) remove(e *list.Element) {
+	x := e.Value.(*item)
+	s.lru.Remove(e)
+	delete(s.entry, x.key)
+	s.size -= x.size
+}
+
+func (s *Store) evict() {
+	for s.lru.Len() > 0 &&
+		((s.maxSize > 0 && s.size > s.maxSize) ||
+			(s.maxEntries > 0 && s.lru.Len() > s.maxEntries)) {
+		s.remove(s.lru.Back())
+	}
+}
+
+// returns the live item of the key, the expired one is removed
+func (s *Store) lookup(key string, now time.Time) *list.Element {
+	e, ok := s.entry[key]
+	if !ok {
+		return nil
+	}
+	if e.Value.(*item).expired(now) {
+		s.remove(e)
+		return nil
+	}
+	s.lru.MoveToFront(e)
+	return e
+}
+
+func (s *Store) put(key string, value interface{}, expire time.Time) {
+	if e, ok := s.entry[key]; ok {
+		s.remove(e)
+	}
+	x := &item{
+		key:    key,
+		value:  value,
+		size:   int64(len(key)) + sizeOf(value),
+		expire: expire,
+	}
+	s.entry[key] = s.lru.PushFront(x)
+	s.size += x.size
+	s.evict()
+}
+
+// approximated memory footprint of the generic value
+func sizeOf(v interface{}) int64 {
+	switch x := v.(type) {
+	case string:
+		return int64(len(x)) + 16
+	case []interface{}:
+		sz := int64(24)
+		for _, e := range x {
+			sz += sizeOf(e)
+		}
+		return sz
+	case map[string]interface{}:
+		sz := int64(48)
+		for k, e := range x {
+			sz += int64(len(k)) + 16 + sizeOf(e)
+		}
+		return sz
+	default:
+		return 16
+	}
+}
+
+func deadline(ttl time.Duration, now time.Time) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// Namespace is a view of the store, the keys of different namespaces do not
+// collide
+type Namespace struct {
+	store  *Store
+	prefix string
+}
+
+func (n *Namespace) Get(key string) (interface{}, bool) {
+	s := n.store
+	s.Lock()
+	defer s.Unlock()
+	e := s.lookup(n.prefix+key, time.Now())
+	if e == nil {
+		return nil, false
+	}
+	return e.Value.(*item).value, true
+}
+
+// Set stores the value, the ttl less or equal to 0 means never expire
+func (n *Namespace) Set(key string, value interface{}, ttl time.Duration) {
+	s := n.store
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	s.put(n.prefix+key, value, deadline(ttl, now))
+}
+
+// Add stores the value only when the key is absent, returns whether it is
+// stored
+func (n *Namespace) Add(key string, value interface{}, ttl time.Duration) bool {
+	s := n.store
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	if s.lookup(n.prefix+key, now) != nil {
+		return false
+	}
+	s.put(n.prefix+key, value, deadline(ttl, now))
+	return true
+}
+
+func (n *Namespace) Del(key string) bool {
+	s := n.store
+	s.Lock()
+	defer s.Unlock()
+	e := s.lookup(n.prefix+key, time.Now())
+	if e == nil {
+		return false
+	}
+	s.remove(e)
+	return true
+}
+
+// Incr adds delta to the integer value of the key and returns the new value.
+// The absent key starts from 0 with the ttl, the ttl of an existed key is
+// kept
+func (n *Namespace) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	s := n.store
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	e := s.lookup(n.prefix+key, now)
+	if e == nil {
+		s.put(n.prefix+key, delta, deadline(ttl, now))
+		return delta, nil
+	}
+	x := e.Value.(*item)
+	v, ok := x.value.(int64)
+	if !ok {
+		return 0, fmt.Errorf("kv: value of key %s is not integer", key)
+	}
+	v += delta
+	x.value = v
+	return v, nil
+}
+
+// Expire resets the ttl of the key, the ttl less or equal to 0 removes the
+// expiration. Returns false when the key is absent
+func (n *Namespace) Expire(key string, ttl time.Duration) bool {
+	s := n.store
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	e := s.lookup(n.prefix+key, now)
+	if e == nil {
+		return false
+	}
+	e.Value.(*item).expire = deadline(ttl, now)
+	return true
+}
+
+// TTL returns the remaining time to live of the key, it is -1 when the key
+// never expires. The false is returned when the key is absent
+func (n *Namespace) TTL(key string) (time.Duration, bool) {
+	s := n.store
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	e := s.lookup(n.prefix+key, now)
+	if e == nil {
+		return 0, false
+	}
+	x := e.Value.(*item)
+	if x.expire.IsZero() {
+		return -1, true
+	}
+	return x.expire.Sub(now), true
+}
+
+// Keys returns the live keys of the namespace with the prefix
+func (n *Namespace) Keys(prefix string) []string {
+	s := n.store
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	o := []string{}
+	for k, e := range s.entry {
+		if !strings.HasPrefix(k, n.prefix+prefix) || e.Value.(*item).expired(now) {
+			continue
+		}
+		o = append(o, k[len(n.prefix):])
+	}
+	return o
+}
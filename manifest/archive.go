@@ -0,0 +1,134 @@
+package manifest
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/dianpeng/moons/g"
+)
+
+// unpacking of the zip, tar and tar.gz bundle into memory. The format is told
+// by the content rather than the name, since the remote artifact may have no
+// meaningful name. The unpacked size is bounded by g.ManifestMaxSize
+
+func isZip(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("PK\x03\x04")) ||
+		bytes.HasPrefix(data, []byte("PK\x05\x06"))
+}
+
+func isGzip(data []byte) bool {
+	return bytes.HasPrefix(data, []byte{0x1f, 0x8b})
+}
+
+func isTar(data []byte) bool {
+	return len(data) > 262 && string(data[257:262]) == "ustar"
+}
+
+func isArchive(data []byte) bool {
+	return isZip(data) || isGzip(data) || isTar(data)
+}
+
+// name of the archived file in the fs, the entry escaping the archive is
+// rejected
+func archivePath(name string) (string, error) {
+	p := strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+	if p == "" || !fs.ValidPath(p) {
+		return "", fmt.Errorf("manifest: invalid archive entry %s", name)
+	}
+	return p, nil
+}
+
+func unpack(data []byte) (*memFS, error) {
+	switch {
+	case isZip(data):
+		return unpackZip(data)
+	case isGzip(data):
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("manifest: gzip: %s", err.Error())
+		}
+		defer r.Close()
+		return unpackTar(r)
+	case isTar(data):
+		return unpackTar(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("manifest: unknown archive format")
+	}
+}
+
+func unpackZip(data []byte) (*memFS, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("manifest: zip: %s", err.Error())
+	}
+	m := newMemFS()
+	var total int64
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name, err := archivePath(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("manifest: zip: %s: %s", f.Name, err.Error())
+		}
+		b, err := readBounded(rc, g.ManifestMaxSize-total)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("manifest: zip: %s: %s", f.Name, err.Error())
+		}
+		total += int64(len(b))
+		m.add(name, b, f.Mode(), f.Modified)
+	}
+	return m, nil
+}
+
+func unpackTar(input io.Reader) (*memFS, error) {
+	r := tar.NewReader(input)
+	m := newMemFS()
+	var total int64
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("manifest: tar: %s", err.Error())
+		}
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name, err := archivePath(h.Name)
+		if err != nil {
+			return nil, err
+		}
+		b, err := readBounded(r, g.ManifestMaxSize-total)
+		if err != nil {
+			return nil, fmt.Errorf("manifest: tar: %s: %s", h.Name, err.Error())
+		}
+		total += int64(len(b))
+		m.add(name, b, h.FileInfo().Mode(), h.ModTime)
+	}
+	return m, nil
+}
+
+func readBounded(r io.Reader, limit int64) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, fmt.Errorf("exceeds the max manifest size %d", g.ManifestMaxSize)
+	}
+	return b, nil
+}
@@ -0,0 +1,307 @@
+package manifest
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dianpeng/moons/pl"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Composition of the manifest loaded from local dir. The dir of the main file
+// may have a descriptor, manifest.yaml or manifest.json, ie
+//
+//   include:
+//     - ../common/main.pl
+//   library:
+//     - ../shared/lib
+//   template:
+//     - ../shared/templates
+//
+// The services of the included manifest are served by this one, its main file
+// is not. The library and template dirs are shared files, the .pl files of the
+// library are imported by the modules and are not services. All the dirs are
+// merged into one fs at its root, so a service of the included manifest
+// imports by the same path as it does in its own manifest. The paths are
+// relative to the dir of the descriptor.
+//
+// The merged module graph is checked once loaded, a file provided by two of the
+// dirs, a module name declared by two files and an import not found in the
+// merged fs are rejected, rather than failing when the module is compiled.
+
+var descriptorFile = []string{
+	"manifest.yaml",
+	"manifest.json",
+}
+
+type descriptor struct {
+	Include  []string `yaml:"include"`
+	Library  []string `yaml:"library"`
+	Template []string `yaml:"template"`
+}
+
+// dir watched for the manifest, see watch.go
+type watchDir struct {
+	path string
+
+	// whether all the files are watched, or only the .pl files
+	all bool
+}
+
+func isDescriptor(name string) bool {
+	for _, x := range descriptorFile {
+		if name == x {
+			return true
+		}
+	}
+	return false
+}
+
+func readDescriptor(dir string) (*descriptor, string, error) {
+	for _, x := range descriptorFile {
+		p := filepath.Join(dir, x)
+		data, err := os.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, p, fmt.Errorf("manifest: %s", err.Error())
+		}
+		d := &descriptor{}
+		dec := yaml.NewDecoder(strings.NewReader(string(data)))
+		dec.KnownFields(true)
+		if err := dec.Decode(d); err != nil {
+			return nil, p, fmt.Errorf("manifest: %s: %s", p, err.Error())
+		}
+		return d, p, nil
+	}
+	return nil, "", nil
+}
+
+// layer of the merged fs, the hidden file is not visible, ie the main file of
+// the included manifest
+type layer struct {
+	fs     fs.FS
+	origin string
+	hide   string
+}
+
+type unionFS struct {
+	layer []layer
+}
+
+func (u *unionFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	var dirs []layer
+	var info fs.FileInfo
+	for _, l := range u.layer {
+		if name == l.hide {
+			continue
+		}
+		f, err := l.fs.Open(name)
+		if err != nil {
+			continue
+		}
+		st, err := f.Stat()
+		if err != nil {
+			f.Close()
+			continue
+		}
+		if !st.IsDir() {
+			if len(dirs) != 0 {
+				f.Close()
+				continue
+			}
+			return f, nil
+		}
+		f.Close()
+		if info == nil {
+			info = st
+		}
+		dirs = append(dirs, l)
+	}
+	if len(dirs) == 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	seen := make(map[string]bool)
+	list := []fs.DirEntry{}
+	for _, l := range dirs {
+		entry, err := fs.ReadDir(l.fs, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entry {
+			if seen[e.Name()] || path.Join(name, e.Name()) == l.hide {
+				continue
+			}
+			seen[e.Name()] = true
+			list = append(list, e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+	return &memDir{
+		entry: info,
+		list:  list,
+	}, nil
+}
+
+// checks the files of the layers, returns the .pl files of each layer
+func (u *unionFS) check() ([][]string, error) {
+	owner := make(map[string]string)
+	o := make([][]string, len(u.layer))
+	for i, l := range u.layer {
+		err := fs.WalkDir(l.fs, ".", func(p string, d fs.DirEntry, e error) error {
+			if e != nil || d.IsDir() || p == l.hide {
+				return nil
+			}
+			if isDescriptor(p) {
+				return nil
+			}
+			if x, ok := owner[p]; ok {
+				return fmt.Errorf("manifest: file %s is provided by both %s and %s", p, x, l.origin)
+			}
+			owner[p] = l.origin
+			if path.Ext(p) == ".pl" {
+				o[i] = append(o[i], p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// checks the module graph of the .pl files of the merged fs
+func checkModuleGraph(fsys fs.FS, files []string) error {
+	module := make(map[string]string)
+	for _, f := range files {
+		data, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return fmt.Errorf("manifest: %s", err.Error())
+		}
+		h, err := pl.ParseModuleHeader(string(data))
+		if err != nil {
+			return fmt.Errorf("manifest: %s: %s", f, err.Error())
+		}
+		if h.Name != "" {
+			if x, ok := module[h.Name]; ok {
+				return fmt.Errorf("manifest: module %s is declared by both %s and %s", h.Name, x, f)
+			}
+			module[h.Name] = f
+		}
+		for _, x := range h.Imports {
+			if st, err := fs.Stat(fsys, x); err != nil || st.IsDir() {
+				return fmt.Errorf("manifest: %s imports %s which is not existed", f, x)
+			}
+		}
+	}
+	return nil
+}
+
+// composes the manifest of the local dir with its descriptor, the including
+// manifests are in the stack for the cycle detection
+func (m *Manifest) compose(dir string, stack []string) error {
+	d, file, err := readDescriptor(dir)
+	if err != nil || d == nil {
+		return err
+	}
+
+	rel := func(x string) string {
+		if filepath.IsAbs(x) {
+			return x
+		}
+		return filepath.Join(dir, x)
+	}
+
+	u := &unionFS{}
+	u.layer = append(u.layer, layer{
+		fs:     m.FS,
+		origin: dir,
+	})
+	var services []bool
+	services = append(services, true)
+
+	for _, x := range d.Include {
+		p := rel(x)
+		for _, s := range stack {
+			if s == p {
+				return fmt.Errorf("manifest: %s includes %s, cycle detected", file, x)
+			}
+		}
+		inc, err := newManifestFromLocalDir(p, m.Type, append(stack, p))
+		if err != nil {
+			return fmt.Errorf("manifest: %s includes %s: %s", file, x, err.Error())
+		}
+		u.layer = append(u.layer, layer{
+			fs:     inc.FS,
+			origin: filepath.Dir(p),
+			hide:   inc.Main,
+		})
+		services = append(services, true)
+		m.watch = append(m.watch, watchDir{path: filepath.Dir(p)})
+		m.watch = append(m.watch, inc.watch...)
+	}
+
+	addDir := func(list []string, all bool) error {
+		for _, x := range list {
+			p := rel(x)
+			if st, err := os.Stat(p); err != nil || !st.IsDir() {
+				return fmt.Errorf("manifest: %s: dir %s is not existed", file, x)
+			}
+			u.layer = append(u.layer, layer{
+				fs:     os.DirFS(p),
+				origin: p,
+			})
+			services = append(services, false)
+			m.watch = append(m.watch, watchDir{path: p, all: all})
+		}
+		return nil
+	}
+	if err := addDir(d.Library, false); err != nil {
+		return err
+	}
+	if err := addDir(d.Template, true); err != nil {
+		return err
+	}
+
+	files, err := u.check()
+	if err != nil {
+		return err
+	}
+
+	// the main file is hidden by the including manifest, the services are the
+	// .pl files of this manifest and of the included ones
+	all := []string{}
+	m.ServiceFile = nil
+	for i, l := range files {
+		all = append(all, l...)
+		if !services[i] {
+			continue
+		}
+		for _, f := range l {
+			if i == 0 && f == m.Main {
+				continue
+			}
+			m.ServiceFile = append(m.ServiceFile, f)
+		}
+	}
+	if err := checkModuleGraph(u, all); err != nil {
+		return err
+	}
+	m.FS = u
+	return nil
+}
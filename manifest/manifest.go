@@ -1,6 +1,7 @@
 package manifest
 
 import (
+	"fmt"
 	"io/fs"
 )
 
@@ -12,4 +13,27 @@ type Manifest struct {
 	Main        string
 	ServiceFile []string
 	Type        string
+
+	// path of the main file on local fs, empty if the manifest is not loaded
+	// from local dir
+	Path string
+
+	// source the manifest is loaded from, see NewManifestFromSource, empty if
+	// the manifest is loaded by NewManifestFromLocalDir
+	Source string
+
+	// dirs of the included manifests, libraries and templates, see compose.go
+	watch []watchDir
+}
+
+// Reload loads the manifest again from where it was loaded, so the files
+// added or removed since are picked up
+func (m *Manifest) Reload() (*Manifest, error) {
+	if m.Source != "" {
+		return NewManifestFromSource(m.Source, m.Type)
+	}
+	if m.Path == "" {
+		return nil, fmt.Errorf("manifest: %s cannot be reloaded, not loaded from local dir or source", m.Main)
+	}
+	return NewManifestFromLocalDir(m.Path, m.Type)
 }
@@ -9,9 +9,18 @@ import (
 func NewManifestFromLocalDir(
 	mainPath string,
 	t string,
+) (*Manifest, error) {
+	return newManifestFromLocalDir(mainPath, t, []string{filepath.Clean(mainPath)})
+}
+
+func newManifestFromLocalDir(
+	mainPath string,
+	t string,
+	stack []string,
 ) (*Manifest, error) {
 	manifest := &Manifest{
 		Type: t,
+		Path: mainPath,
 	}
 	dir := filepath.Dir(mainPath)
 
@@ -45,5 +54,10 @@ func NewManifestFromLocalDir(
 		return nil, err
 	}
 
+	// the descriptor of the dir, see compose.go
+	if err := manifest.compose(dir, stack); err != nil {
+		return nil, err
+	}
+
 	return manifest, nil
 }
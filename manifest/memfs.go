@@ -0,0 +1,174 @@
+package manifest
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// in memory fs.FS of the files unpacked from an archive, the directories are
+// implied by the path of the files. The file is seekable so the static
+// application serves the range of it
+
+type memFS struct {
+	file map[string]*memEntry
+	dir  map[string][]*memEntry
+}
+
+type memEntry struct {
+	name    string
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+	isDir   bool
+}
+
+func newMemFS() *memFS {
+	return &memFS{
+		file: make(map[string]*memEntry),
+		dir: map[string][]*memEntry{
+			".": nil,
+		},
+	}
+}
+
+// adds the file, the parent directories are created on demand. The file added
+// again replaces the previous one
+func (m *memFS) add(name string, data []byte, mode fs.FileMode, modTime time.Time) {
+	e := &memEntry{
+		name:    path.Base(name),
+		data:    data,
+		mode:    mode.Perm(),
+		modTime: modTime,
+	}
+	if _, ok := m.file[name]; ok {
+		m.remove(name)
+	}
+	m.file[name] = e
+	m.link(name, e)
+}
+
+func (m *memFS) remove(name string) {
+	dir := path.Dir(name)
+	l := m.dir[dir]
+	for i, x := range l {
+		if x.name == path.Base(name) {
+			m.dir[dir] = append(l[:i], l[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *memFS) link(name string, e *memEntry) {
+	dir := path.Dir(name)
+	_, existed := m.dir[dir]
+	m.dir[dir] = append(m.dir[dir], e)
+	if existed {
+		return
+	}
+	m.link(dir, &memEntry{
+		name:    path.Base(dir),
+		mode:    fs.ModeDir | 0555,
+		modTime: e.modTime,
+		isDir:   true,
+	})
+}
+
+func (m *memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if e, ok := m.file[name]; ok {
+		return &memFile{
+			entry:  e,
+			Reader: bytes.NewReader(e.data),
+		}, nil
+	}
+	if l, ok := m.dir[name]; ok {
+		entry := make([]fs.DirEntry, 0, len(l))
+		for _, x := range l {
+			entry = append(entry, x)
+		}
+		sort.Slice(entry, func(i, j int) bool {
+			return entry[i].Name() < entry[j].Name()
+		})
+		return &memDir{
+			entry: &memEntry{
+				name:  path.Base(name),
+				mode:  fs.ModeDir | 0555,
+				isDir: true,
+			},
+			list: entry,
+		}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// fs.FileInfo and fs.DirEntry of the entry
+func (e *memEntry) Name() string               { return e.name }
+func (e *memEntry) Size() int64                { return int64(len(e.data)) }
+func (e *memEntry) Mode() fs.FileMode          { return e.mode }
+func (e *memEntry) ModTime() time.Time         { return e.modTime }
+func (e *memEntry) IsDir() bool                { return e.isDir }
+func (e *memEntry) Sys() interface{}           { return nil }
+func (e *memEntry) Type() fs.FileMode          { return e.mode.Type() }
+func (e *memEntry) Info() (fs.FileInfo, error) { return e, nil }
+
+type memFile struct {
+	entry *memEntry
+	*bytes.Reader
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	return f.entry, nil
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+// directory listing of memFS and of unionFS
+type memDir struct {
+	entry fs.FileInfo
+	list  []fs.DirEntry
+	off   int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) {
+	return d.entry, nil
+}
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *memDir) Close() error {
+	return nil
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	left := len(d.list) - d.off
+	if n > 0 && left == 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > left {
+		n = left
+	}
+	o := append([]fs.DirEntry{}, d.list[d.off:d.off+n]...)
+	d.off += n
+	return o, nil
+}
+
+// the archive wrapping all its files in one top directory, ie app/main.pl, is
+// rooted at that directory
+func (m *memFS) topDir() string {
+	l := m.dir["."]
+	if len(l) == 1 && l[0].isDir && !strings.HasPrefix(l[0].name, ".") {
+		return l[0].name
+	}
+	return ""
+}
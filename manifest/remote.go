@@ -0,0 +1,215 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dianpeng/moons/g"
+)
+
+// fetching of the artifact from remote. The s3 object is fetched from its
+// public https endpoint, there is no request signing, the private object is
+// fetched by its presigned https URL instead. The OCI artifact is pulled by
+// the registry http API, anonymously, and its first layer is the bundle
+
+var remoteClient = &http.Client{
+	Timeout: time.Second * g.ManifestFetchTimeout,
+}
+
+func httpGet(u string, header map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	return remoteClient.Do(req)
+}
+
+func readResponse(resp *http.Response, u string) ([]byte, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("manifest: fetch %s: status %d", u, resp.StatusCode)
+	}
+	b, err := readBounded(resp.Body, g.ManifestMaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("manifest: fetch %s: %s", u, err.Error())
+	}
+	return b, nil
+}
+
+func fetchHTTP(u string) ([]byte, error) {
+	resp, err := httpGet(u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("manifest: fetch %s: %s", u, err.Error())
+	}
+	return readResponse(resp, u)
+}
+
+// s3://bucket/key, the region is optional
+func fetchS3(u *url.URL, region string) ([]byte, error) {
+	if u.Host == "" || u.Path == "" || u.Path == "/" {
+		return nil, fmt.Errorf("manifest: invalid s3 location %s, must be s3://bucket/key", u.String())
+	}
+	host := u.Host + ".s3.amazonaws.com"
+	if region != "" {
+		host = u.Host + ".s3." + region + ".amazonaws.com"
+	}
+	return fetchHTTP("https://" + host + u.EscapedPath())
+}
+
+const (
+	ociManifestAccept = "application/vnd.oci.image.manifest.v1+json, " +
+		"application/vnd.docker.distribution.manifest.v2+json"
+)
+
+type ociManifest struct {
+	Layers []struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+	} `json:"layers"`
+}
+
+type ociRegistry struct {
+	host  string
+	repo  string
+	token string
+}
+
+// the anonymous bearer token of the challenge, ie
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="..."
+func (r *ociRegistry) authorize(challenge string) error {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return fmt.Errorf("unsupported auth challenge %s", challenge)
+	}
+	param := make(map[string]string)
+	for _, x := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(x), "=", 2)
+		if len(kv) == 2 {
+			param[kv[0]] = strings.Trim(kv[1], "\"")
+		}
+	}
+	realm, ok := param["realm"]
+	if !ok {
+		return fmt.Errorf("auth challenge has no realm")
+	}
+	q := url.Values{}
+	if x, ok := param["service"]; ok {
+		q.Set("service", x)
+	}
+	if x, ok := param["scope"]; ok {
+		q.Set("scope", x)
+	} else {
+		q.Set("scope", "repository:"+r.repo+":pull")
+	}
+	u := realm + "?" + q.Encode()
+	resp, err := httpGet(u, nil)
+	if err != nil {
+		return err
+	}
+	b, err := readResponse(resp, u)
+	if err != nil {
+		return err
+	}
+	t := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return err
+	}
+	r.token = t.Token
+	if r.token == "" {
+		r.token = t.AccessToken
+	}
+	return nil
+}
+
+func (r *ociRegistry) get(p string, accept string) ([]byte, error) {
+	u := "https://" + r.host + "/v2/" + r.repo + p
+	for i := 0; ; i++ {
+		header := map[string]string{}
+		if accept != "" {
+			header["Accept"] = accept
+		}
+		if r.token != "" {
+			header["Authorization"] = "Bearer " + r.token
+		}
+		resp, err := httpGet(u, header)
+		if err != nil {
+			return nil, fmt.Errorf("manifest: fetch %s: %s", u, err.Error())
+		}
+		if resp.StatusCode == http.StatusUnauthorized && i == 0 {
+			resp.Body.Close()
+			if err := r.authorize(resp.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, fmt.Errorf("manifest: oci %s: %s", r.host, err.Error())
+			}
+			continue
+		}
+		return readResponse(resp, u)
+	}
+}
+
+// oci://registry/repo:tag or oci://registry/repo@sha256:digest
+func fetchOCI(u *url.URL) ([]byte, error) {
+	ref := strings.TrimPrefix(u.Path, "/")
+	repo, tag := ref, "latest"
+	if i := strings.LastIndex(ref, "@"); i >= 0 {
+		repo, tag = ref[:i], ref[i+1:]
+	} else if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		repo, tag = ref[:i], ref[i+1:]
+	}
+	if u.Host == "" || repo == "" {
+		return nil, fmt.Errorf("manifest: invalid oci location %s, must be oci://registry/repo:tag",
+			u.String())
+	}
+
+	r := &ociRegistry{
+		host: u.Host,
+		repo: repo,
+	}
+	b, err := r.get("/manifests/"+tag, ociManifestAccept)
+	if err != nil {
+		return nil, err
+	}
+	m := ociManifest{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("manifest: oci %s: invalid manifest: %s", ref, err.Error())
+	}
+	if len(m.Layers) == 0 {
+		return nil, fmt.Errorf("manifest: oci %s has no layer", ref)
+	}
+
+	digest := m.Layers[0].Digest
+	blob, err := r.get("/blobs/"+digest, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDigest(blob, digest); err != nil {
+		return nil, fmt.Errorf("manifest: oci %s: %s", ref, err.Error())
+	}
+	return blob, nil
+}
+
+// the digest is in form of sha256:<hex>
+func checkDigest(data []byte, digest string) error {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return fmt.Errorf("unsupported digest %s", digest)
+	}
+	return checkSHA256(data, strings.TrimPrefix(digest, "sha256:"))
+}
+
+func checkSHA256(data []byte, expect string) error {
+	sum := sha256.Sum256(data)
+	if actual := hex.EncodeToString(sum[:]); !strings.EqualFold(actual, expect) {
+		return fmt.Errorf("checksum mismatched, expect sha256 %s but got %s", expect, actual)
+	}
+	return nil
+}
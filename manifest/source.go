@@ -0,0 +1,200 @@
+package manifest
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Manifest loaded from a source other than the local dir, the source is in
+// form of location[#option&option], ie
+//
+//   ./site/main.pl                        local dir, same as NewManifestFromLocalDir
+//   ./site.zip                            zip, tar or tar.gz bundle
+//   https://example.com/site.tar.gz       bundle or single main file over http(s)
+//   s3://bucket/site.zip#region=us-east-1 public object of s3
+//   oci://ghcr.io/org/site:v1             first layer of the OCI artifact
+//
+// The options are
+//
+//   sha256=<hex>     pins the checksum of the fetched artifact, the artifact
+//                    mismatched is rejected
+//   main=<path>      main file inside of the bundle, main.pl by default
+//   region=<region>  region of the s3 bucket
+//
+// The bundle wrapping all its files in one top directory is rooted at that
+// directory. All the other .pl files of the bundle are the service files
+
+type sourceOption struct {
+	sha256 string
+	main   string
+	region string
+}
+
+func parseSource(src string) (string, *sourceOption, error) {
+	opt := &sourceOption{
+		main: "main.pl",
+	}
+	i := strings.LastIndex(src, "#")
+	if i < 0 {
+		return src, opt, nil
+	}
+	location := src[:i]
+	for _, x := range strings.Split(src[i+1:], "&") {
+		kv := strings.SplitN(x, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return "", nil, fmt.Errorf("manifest: invalid source option %s", x)
+		}
+		switch kv[0] {
+		case "sha256":
+			opt.sha256 = kv[1]
+		case "main":
+			opt.main = kv[1]
+		case "region":
+			opt.region = kv[1]
+		default:
+			return "", nil, fmt.Errorf("manifest: unknown source option %s", kv[0])
+		}
+	}
+	return location, opt, nil
+}
+
+// IsRemoteSource returns whether the source is fetched from remote rather than
+// read from the local fs
+func IsRemoteSource(src string) bool {
+	return strings.Contains(src, "://")
+}
+
+func NewManifestFromSource(
+	src string,
+	t string,
+) (*Manifest, error) {
+	location, opt, err := parseSource(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if IsRemoteSource(location) {
+		u, err := url.Parse(location)
+		if err != nil {
+			return nil, fmt.Errorf("manifest: invalid source %s: %s", location, err.Error())
+		}
+		switch u.Scheme {
+		case "http", "https":
+			data, err = fetchHTTP(location)
+		case "s3":
+			data, err = fetchS3(u, opt.region)
+		case "oci":
+			data, err = fetchOCI(u)
+		default:
+			return nil, fmt.Errorf("manifest: unknown source scheme %s", u.Scheme)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		st, err := os.Stat(location)
+		if err != nil {
+			return nil, fmt.Errorf("manifest: %s", err.Error())
+		}
+		if st.IsDir() {
+			return nil, fmt.Errorf("manifest: %s is a directory, must be main file or bundle", location)
+		}
+		data, err = os.ReadFile(location)
+		if err != nil {
+			return nil, fmt.Errorf("manifest: %s", err.Error())
+		}
+
+		// the plain main file of the local dir
+		if !isArchive(data) && opt.sha256 == "" {
+			m, err := NewManifestFromLocalDir(location, t)
+			if err != nil {
+				return nil, err
+			}
+			m.Source = src
+			return m, nil
+		}
+	}
+
+	if opt.sha256 != "" {
+		if err := checkSHA256(data, opt.sha256); err != nil {
+			return nil, fmt.Errorf("manifest: %s: %s", location, err.Error())
+		}
+	}
+
+	m, err := newManifestFromData(location, data, opt, t)
+	if err != nil {
+		return nil, err
+	}
+	m.Source = src
+	return m, nil
+}
+
+func newManifestFromData(
+	location string,
+	data []byte,
+	opt *sourceOption,
+	t string,
+) (*Manifest, error) {
+	// the single main file fetched
+	if !isArchive(data) {
+		name := path.Base(location)
+		if path.Ext(name) != ".pl" {
+			name = opt.main
+		}
+		m := newMemFS()
+		m.add(name, data, 0444, time.Now())
+		return &Manifest{
+			FS:   m,
+			Main: name,
+			Type: t,
+		}, nil
+	}
+
+	mfs, err := unpack(data)
+	if err != nil {
+		return nil, err
+	}
+	var fsys fs.FS = mfs
+	main := strings.TrimPrefix(path.Clean("/"+opt.main), "/")
+	if _, err := fs.Stat(fsys, main); err != nil {
+		if top := mfs.topDir(); top != "" {
+			if sub, err := fs.Sub(mfs, top); err == nil {
+				fsys = sub
+			}
+		}
+	}
+	if st, err := fs.Stat(fsys, main); err != nil || st.IsDir() {
+		return nil, fmt.Errorf("manifest: main file %s is not existed in %s", main, location)
+	}
+
+	// the services are the .pl files under the dir of the main file
+	dir := path.Dir(main)
+	manifest := &Manifest{
+		Type: t,
+		Main: path.Base(main),
+	}
+	manifest.FS, err = fs.Sub(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("manifest: %s", err.Error())
+	}
+	err = fs.WalkDir(manifest.FS, ".", func(p string, d fs.DirEntry, e error) error {
+		if e != nil || d.IsDir() {
+			return nil
+		}
+		if p == manifest.Main || path.Ext(p) != ".pl" {
+			return nil
+		}
+		manifest.ServiceFile = append(manifest.ServiceFile, p)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("manifest: %s", err.Error())
+	}
+	return manifest, nil
+}
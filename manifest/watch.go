@@ -0,0 +1,76 @@
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Stamp of the .pl files of the manifest loaded from local dir, which is
+// compared with the previous one to tell the change of the files. The file is
+// stamped by its size and modification time, so the change keeping both is
+// not seen
+
+type Stamp map[string]string
+
+// Watchable returns whether the files of the manifest can be stamped, which
+// is only the manifest loaded from local dir
+func (m *Manifest) Watchable() bool {
+	return m.Path != ""
+}
+
+// Stamp stamps the main file, the descriptor and all the .pl files under its
+// dir, so the service file added is seen as well. The dirs composed by the
+// descriptor are stamped too, all the files of the template dirs are stamped
+func (m *Manifest) Stamp() (Stamp, error) {
+	if !m.Watchable() {
+		return nil, fmt.Errorf("manifest: %s cannot be watched, not loaded from local dir", m.Main)
+	}
+	o := make(Stamp)
+	dir := filepath.Dir(m.Path)
+	list := append([]watchDir{{path: dir}}, m.watch...)
+	for _, w := range list {
+		w := w
+		err := filepath.Walk(
+			w.path,
+			func(path string, info os.FileInfo, e error) error {
+				if e != nil || info.IsDir() {
+					return nil
+				}
+				if !w.all && path != m.Path && filepath.Ext(path) != ".pl" &&
+					!isDescriptor(filepath.Base(path)) {
+					return nil
+				}
+				name, err := filepath.Rel(dir, path)
+				if err != nil {
+					name = path
+				}
+				o[name] = fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())
+				return nil
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// Diff returns the files added, removed or modified since the previous stamp,
+// in order of name
+func (s Stamp) Diff(prev Stamp) []string {
+	o := []string{}
+	for k, v := range s {
+		if x, ok := prev[k]; !ok || x != v {
+			o = append(o, k)
+		}
+	}
+	for k := range prev {
+		if _, ok := s[k]; !ok {
+			o = append(o, k)
+		}
+	}
+	sort.Strings(o)
+	return o
+}
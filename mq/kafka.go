@@ -0,0 +1,600 @@
+package mq
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal Kafka producer, ie kafka://host1:9092,host2:9092?acks=1. It speaks
+// Metadata v4 to find the leader of the partitions and Produce v3 with the
+// record batch of magic 2, uncompressed. The messages are spread over the
+// partitions in round robin. SASL/PLAIN is used once the user is given, by
+// the url or the option. Subscribe is not supported, the consumer group
+// protocol is out of the scope
+
+const (
+	kafkaDefaultPort    = "9092"
+	kafkaDefaultTimeout = 10 * time.Second
+	kafkaClientID       = "moons"
+	kafkaMaxResponse    = 64 << 20
+
+	kafkaApiProduce          = 0
+	kafkaApiMetadata         = 3
+	kafkaApiSaslHandshake    = 17
+	kafkaApiSaslAuthenticate = 36
+
+	kafkaErrUnknownTopic    = 3
+	kafkaErrLeaderNotAvail  = 5
+	kafkaErrNotLeader       = 6
+	kafkaErrRequestTimedOut = 7
+)
+
+var kafkaCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// kafka protocol encoder, the integer is big endian
+type kafkaEncoder struct {
+	b []byte
+}
+
+func (e *kafkaEncoder) int8(v int8) {
+	e.b = append(e.b, byte(v))
+}
+
+func (e *kafkaEncoder) int16(v int16) {
+	e.b = append(e.b, byte(v>>8), byte(v))
+}
+
+func (e *kafkaEncoder) int32(v int32) {
+	e.b = append(e.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *kafkaEncoder) int64(v int64) {
+	e.int32(int32(v >> 32))
+	e.int32(int32(v))
+}
+
+func (e *kafkaEncoder) str(v string) {
+	e.int16(int16(len(v)))
+	e.b = append(e.b, v...)
+}
+
+func (e *kafkaEncoder) bytes(v []byte) {
+	e.int32(int32(len(v)))
+	e.b = append(e.b, v...)
+}
+
+func (e *kafkaEncoder) varint(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	e.b = append(e.b, buf[:n]...)
+}
+
+func (e *kafkaEncoder) varbytes(v []byte) {
+	e.varint(int64(len(v)))
+	e.b = append(e.b, v...)
+}
+
+// kafka protocol decoder, the first error sticks
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.b) {
+		d.err = fmt.Errorf("mq: kafka response is truncated")
+		return nil
+	}
+	o := d.b[:n]
+	d.b = d.b[n:]
+	return o
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if b := d.take(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string or nullable string, null is empty
+func (d *kafkaDecoder) str() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+func (d *kafkaDecoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.take(int(n))
+}
+
+func (d *kafkaDecoder) array() int {
+	n := int(d.int32())
+	if n < 0 || n > len(d.b) {
+		return 0
+	}
+	return n
+}
+
+func (d *kafkaDecoder) skipInt32Array() {
+	d.take(d.array() * 4)
+}
+
+type kafkaConn struct {
+	sync.Mutex
+	conn    net.Conn
+	r       *bufio.Reader
+	corr    int32
+	timeout time.Duration
+}
+
+// sends the request and reads its response, the response is not read for the
+// produce of acks 0, which the broker does not reply
+func (c *kafkaConn) roundTrip(api int16, version int16, body []byte, reply bool) ([]byte, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.corr++
+	e := &kafkaEncoder{}
+	e.int32(0)
+	e.int16(api)
+	e.int16(version)
+	e.int32(c.corr)
+	e.str(kafkaClientID)
+	e.b = append(e.b, body...)
+	binary.BigEndian.PutUint32(e.b, uint32(len(e.b)-4))
+
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if _, err := c.conn.Write(e.b); err != nil {
+		return nil, err
+	}
+	if !reply {
+		return nil, nil
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 4 || n > kafkaMaxResponse {
+		return nil, fmt.Errorf("mq: kafka response size %d is invalid", n)
+	}
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(c.r, resp); err != nil {
+		return nil, err
+	}
+	if corr := int32(binary.BigEndian.Uint32(resp)); corr != c.corr {
+		return nil, fmt.Errorf("mq: kafka response correlation %d, expect %d", corr, c.corr)
+	}
+	return resp[4:], nil
+}
+
+type kafkaTopic struct {
+	partitions []int32
+	leader     map[int32]int32
+}
+
+type kafkaBroker struct {
+	sync.Mutex
+	seeds  []string
+	option Option
+	acks   int16
+
+	conns   map[string]*kafkaConn
+	brokers map[int32]string
+	topics  map[string]*kafkaTopic
+	next    int
+	closed  bool
+}
+
+func newKafkaBroker(u *url.URL, option *Option) (Broker, error) {
+	o := *option
+	if u.User != nil {
+		o.User = u.User.Username()
+		o.Password, _ = u.User.Password()
+	}
+	if o.ConnectTimeout <= 0 {
+		o.ConnectTimeout = kafkaDefaultTimeout
+	}
+
+	b := &kafkaBroker{
+		option:  o,
+		acks:    1,
+		conns:   make(map[string]*kafkaConn),
+		brokers: make(map[int32]string),
+		topics:  make(map[string]*kafkaTopic),
+	}
+	if x := u.Query().Get("acks"); x != "" {
+		acks, err := strconv.Atoi(x)
+		if err != nil || acks < -1 || acks > 1 {
+			return nil, fmt.Errorf("mq: kafka acks %s is invalid, must be -1, 0 or 1", x)
+		}
+		b.acks = int16(acks)
+	}
+	for _, x := range strings.Split(u.Host, ",") {
+		if x == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(x); err != nil {
+			x = net.JoinHostPort(x, kafkaDefaultPort)
+		}
+		b.seeds = append(b.seeds, x)
+	}
+	if len(b.seeds) == 0 {
+		return nil, fmt.Errorf("mq: kafka url has no broker")
+	}
+
+	// the broker must be reachable at least once
+	b.Lock()
+	defer b.Unlock()
+	if _, err := b.anyConn(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *kafkaBroker) dial(addr string) (*kafkaConn, error) {
+	c, err := net.DialTimeout("tcp", addr, b.option.ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if b.option.TLS {
+		host, _, _ := net.SplitHostPort(addr)
+		tc := tls.Client(c, &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: b.option.InsecureSkipVerify,
+		})
+		tc.SetDeadline(time.Now().Add(b.option.ConnectTimeout))
+		if err := tc.Handshake(); err != nil {
+			c.Close()
+			return nil, err
+		}
+		tc.SetDeadline(time.Time{})
+		c = tc
+	}
+	kc := &kafkaConn{
+		conn:    c,
+		r:       bufio.NewReader(c),
+		timeout: b.option.ConnectTimeout,
+	}
+	if b.option.User != "" {
+		if err := b.saslPlain(kc); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return kc, nil
+}
+
+func (b *kafkaBroker) saslPlain(c *kafkaConn) error {
+	e := &kafkaEncoder{}
+	e.str("PLAIN")
+	resp, err := c.roundTrip(kafkaApiSaslHandshake, 1, e.b, true)
+	if err != nil {
+		return err
+	}
+	d := &kafkaDecoder{b: resp}
+	if code := d.int16(); code != 0 {
+		return fmt.Errorf("mq: kafka SASL/PLAIN is not enabled, error %d", code)
+	}
+
+	e = &kafkaEncoder{}
+	e.bytes([]byte("\x00" + b.option.User + "\x00" + b.option.Password))
+	if resp, err = c.roundTrip(kafkaApiSaslAuthenticate, 0, e.b, true); err != nil {
+		return err
+	}
+	d = &kafkaDecoder{b: resp}
+	if code := d.int16(); code != 0 {
+		return fmt.Errorf("mq: kafka authentication failed, error %d %s", code, d.str())
+	}
+	return d.err
+}
+
+// connection to the address, created on demand, the caller holds the lock
+func (b *kafkaBroker) conn(addr string) (*kafkaConn, error) {
+	if b.closed {
+		return nil, fmt.Errorf("mq: kafka broker is closed")
+	}
+	if c, ok := b.conns[addr]; ok {
+		return c, nil
+	}
+	c, err := b.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	b.conns[addr] = c
+	return c, nil
+}
+
+// connection to any of the brokers known or the seeds
+func (b *kafkaBroker) anyConn() (*kafkaConn, error) {
+	for _, c := range b.conns {
+		return c, nil
+	}
+	var err error
+	for _, x := range b.seeds {
+		var c *kafkaConn
+		if c, err = b.conn(x); err == nil {
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+func (b *kafkaBroker) drop(c *kafkaConn) {
+	for k, v := range b.conns {
+		if v == c {
+			delete(b.conns, k)
+		}
+	}
+	c.conn.Close()
+}
+
+// Metadata v4 of the topic, the topic is created if the broker allows
+func (b *kafkaBroker) metadata(topic string) (*kafkaTopic, error) {
+	c, err := b.anyConn()
+	if err != nil {
+		return nil, err
+	}
+	e := &kafkaEncoder{}
+	e.int32(1)
+	e.str(topic)
+	e.int8(1)
+	resp, err := c.roundTrip(kafkaApiMetadata, 4, e.b, true)
+	if err != nil {
+		b.drop(c)
+		return nil, err
+	}
+
+	d := &kafkaDecoder{b: resp}
+	d.int32()
+	for i, n := 0, d.array(); i < n; i++ {
+		id := d.int32()
+		host := d.str()
+		port := d.int32()
+		d.str()
+		b.brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.str()
+	d.int32()
+
+	var t *kafkaTopic
+	for i, n := 0, d.array(); i < n; i++ {
+		code := d.int16()
+		name := d.str()
+		d.int8()
+		x := &kafkaTopic{
+			leader: make(map[int32]int32),
+		}
+		for j, m := 0, d.array(); j < m; j++ {
+			d.int16()
+			p := d.int32()
+			leader := d.int32()
+			d.skipInt32Array()
+			d.skipInt32Array()
+			if leader >= 0 {
+				x.partitions = append(x.partitions, p)
+				x.leader[p] = leader
+			}
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+		if name != topic {
+			continue
+		}
+		if code != 0 {
+			return nil, fmt.Errorf("mq: kafka topic %s metadata error %d", topic, code)
+		}
+		if len(x.partitions) == 0 {
+			return nil, fmt.Errorf("mq: kafka topic %s has no partition leader", topic)
+		}
+		t = x
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if t == nil {
+		return nil, fmt.Errorf("mq: kafka topic %s is not found", topic)
+	}
+	b.topics[topic] = t
+	return t, nil
+}
+
+type kafkaRecord struct {
+	value  []byte
+	header map[string]string
+}
+
+// record batch of magic 2, the crc is CRC-32C of the bytes after it
+func kafkaRecordBatch(records []kafkaRecord) []byte {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	body := &kafkaEncoder{}
+	body.int16(0)
+	body.int32(int32(len(records) - 1))
+	body.int64(now)
+	body.int64(now)
+	body.int64(-1)
+	body.int16(-1)
+	body.int32(-1)
+	body.int32(int32(len(records)))
+	for i, r := range records {
+		x := &kafkaEncoder{}
+		x.int8(0)
+		x.varint(0)
+		x.varint(int64(i))
+		x.varint(-1)
+		x.varbytes(r.value)
+		x.varint(int64(len(r.header)))
+		for k, v := range r.header {
+			x.varbytes([]byte(k))
+			x.varbytes([]byte(v))
+		}
+		body.varbytes(x.b)
+	}
+
+	e := &kafkaEncoder{}
+	e.int64(0)
+	e.int32(int32(4 + 1 + 4 + len(body.b)))
+	e.int32(-1)
+	e.int8(2)
+	e.int32(int32(crc32.Checksum(body.b, kafkaCRC)))
+	e.b = append(e.b, body.b...)
+	return e.b
+}
+
+func (b *kafkaBroker) produce(topic string, records []kafkaRecord) error {
+	if err := natsCheckTopic(topic); err != nil {
+		return err
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	t, ok := b.topics[topic]
+	if !ok {
+		var err error
+		if t, err = b.metadata(topic); err != nil {
+			return err
+		}
+	}
+	partition := t.partitions[b.next%len(t.partitions)]
+	b.next++
+
+	addr, ok := b.brokers[t.leader[partition]]
+	if !ok {
+		delete(b.topics, topic)
+		return fmt.Errorf("mq: kafka leader of %s-%d is unknown", topic, partition)
+	}
+	c, err := b.conn(addr)
+	if err != nil {
+		delete(b.topics, topic)
+		return err
+	}
+
+	e := &kafkaEncoder{}
+	e.int16(-1)
+	e.int16(b.acks)
+	e.int32(int32(b.option.ConnectTimeout / time.Millisecond))
+	e.int32(1)
+	e.str(topic)
+	e.int32(1)
+	e.int32(partition)
+	e.bytes(kafkaRecordBatch(records))
+
+	resp, err := c.roundTrip(kafkaApiProduce, 3, e.b, b.acks != 0)
+	if err != nil {
+		b.drop(c)
+		delete(b.topics, topic)
+		return err
+	}
+	if b.acks == 0 {
+		return nil
+	}
+
+	d := &kafkaDecoder{b: resp}
+	for i, n := 0, d.array(); i < n; i++ {
+		d.str()
+		for j, m := 0, d.array(); j < m; j++ {
+			d.int32()
+			code := d.int16()
+			d.int64()
+			d.int64()
+			if d.err != nil {
+				break
+			}
+			switch code {
+			case 0:
+				break
+			case kafkaErrUnknownTopic, kafkaErrLeaderNotAvail, kafkaErrNotLeader, kafkaErrRequestTimedOut:
+				delete(b.topics, topic)
+				return fmt.Errorf("mq: kafka produce %s-%d error %d", topic, partition, code)
+			default:
+				return fmt.Errorf("mq: kafka produce %s-%d error %d", topic, partition, code)
+			}
+		}
+	}
+	return d.err
+}
+
+func (b *kafkaBroker) Publish(topic string, data []byte, header map[string]string) error {
+	return b.produce(topic, []kafkaRecord{{value: data, header: header}})
+}
+
+// PublishBatch produces the messages in one record batch
+func (b *kafkaBroker) PublishBatch(topic string, data [][]byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	records := make([]kafkaRecord, 0, len(data))
+	for _, x := range data {
+		records = append(records, kafkaRecord{value: x})
+	}
+	return b.produce(topic, records)
+}
+
+func (b *kafkaBroker) Subscribe(topic string, queue string, handler Handler) (Subscription, error) {
+	return nil, fmt.Errorf("mq: kafka subscribe is not supported")
+}
+
+func (b *kafkaBroker) Close() error {
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for k, c := range b.conns {
+		c.conn.Close()
+		delete(b.conns, k)
+	}
+	return nil
+}
+
+func init() {
+	Register("kafka", newKafkaBroker)
+}
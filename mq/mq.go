@@ -0,0 +1,106 @@
+package mq
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Message queue abstraction used by the mq:: module and the vhost subscriber.
+// Each broker is implemented by a driver registered under the url scheme, ie
+// nats://, so other brokers can be plugged in without touching the callers
+
+type Message struct {
+	Topic  string
+	Reply  string
+	Header map[string]string
+	Data   []byte
+}
+
+type Handler func(*Message)
+
+type Subscription interface {
+	Topic() string
+	Unsubscribe() error
+}
+
+type Broker interface {
+	Publish(topic string, data []byte, header map[string]string) error
+
+	// Subscribe delivers the messages of the topic to the handler in order, the
+	// queue group, if not empty, load balances the messages among subscribers
+	// of the same group
+	Subscribe(topic string, queue string, handler Handler) (Subscription, error)
+
+	Close() error
+}
+
+// BatchPublisher is the broker which publishes the messages of a topic in one
+// round trip, ie kafka, the caller checks it by the type assertion
+type BatchPublisher interface {
+	PublishBatch(topic string, data [][]byte) error
+}
+
+type Option struct {
+	// client name reported to the broker
+	Name string
+
+	User     string
+	Password string
+	Token    string
+
+	ConnectTimeout time.Duration
+
+	// wait between reconnect attempts, and the max number of attempts before
+	// the broker gives up, negative means forever
+	ReconnectWait time.Duration
+	MaxReconnect  int
+
+	TLS                bool
+	InsecureSkipVerify bool
+}
+
+type Driver func(*url.URL, *Option) (Broker, error)
+
+var (
+	driverLock sync.Mutex
+	driver     = make(map[string]Driver)
+)
+
+func Register(scheme string, d Driver) {
+	driverLock.Lock()
+	defer driverLock.Unlock()
+	driver[scheme] = d
+}
+
+// Drivers returns the schemes of the registered drivers in sorted order
+func Drivers() []string {
+	driverLock.Lock()
+	defer driverLock.Unlock()
+	o := []string{}
+	for k := range driver {
+		o = append(o, k)
+	}
+	sort.Strings(o)
+	return o
+}
+
+// Dial connects to the broker of the url, the driver is picked by the scheme
+func Dial(rawurl string, option *Option) (Broker, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	driverLock.Lock()
+	d, ok := driver[u.Scheme]
+	driverLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("mq: driver of scheme %s is not found", u.Scheme)
+	}
+	if option == nil {
+		option = &Option{}
+	}
+	return d(u, option)
+}
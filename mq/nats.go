@@ -0,0 +1,514 @@
+package mq
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal NATS client of the core protocol, ie PUB/HPUB, SUB/UNSUB and
+// MSG/HMSG. JetStream is not supported. The connection is re-established in
+// background after it is lost and the subscriptions are restored
+
+const (
+	natsDefaultPort          = "4222"
+	natsDefaultTimeout       = 5 * time.Second
+	natsDefaultReconnectWait = 2 * time.Second
+	natsPendingSize          = 1024
+	natsMaxControlLine       = 4096
+)
+
+type natsInfo struct {
+	Headers     bool `json:"headers"`
+	TLSRequired bool `json:"tls_required"`
+	MaxPayload  int  `json:"max_payload"`
+}
+
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name,omitempty"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+	Lang     string `json:"lang"`
+	Version  string `json:"version"`
+	Protocol int    `json:"protocol"`
+	Headers  bool   `json:"headers"`
+}
+
+type natsSub struct {
+	broker  *natsBroker
+	sid     int64
+	topic   string
+	queue   string
+	handler Handler
+	pending chan *Message
+	done    chan struct{}
+	dropped int64
+}
+
+func (s *natsSub) Topic() string {
+	return s.topic
+}
+
+func (s *natsSub) Unsubscribe() error {
+	return s.broker.unsubscribe(s)
+}
+
+func (s *natsSub) run() {
+	for {
+		select {
+		case m := <-s.pending:
+			s.handler(m)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+type natsBroker struct {
+	sync.Mutex
+	addr   string
+	option Option
+
+	conn   net.Conn
+	w      *bufio.Writer
+	info   natsInfo
+	sid    int64
+	sub    map[int64]*natsSub
+	closed bool
+}
+
+func newNatsBroker(u *url.URL, option *Option) (Broker, error) {
+	o := *option
+	if u.User != nil {
+		if p, ok := u.User.Password(); ok {
+			o.User = u.User.Username()
+			o.Password = p
+		} else {
+			o.Token = u.User.Username()
+		}
+	}
+	if o.ConnectTimeout <= 0 {
+		o.ConnectTimeout = natsDefaultTimeout
+	}
+	if o.ReconnectWait <= 0 {
+		o.ReconnectWait = natsDefaultReconnectWait
+	}
+	if o.MaxReconnect == 0 {
+		o.MaxReconnect = -1
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), natsDefaultPort)
+	}
+
+	b := &natsBroker{
+		addr:   addr,
+		option: o,
+		sub:    make(map[int64]*natsSub),
+	}
+	r, err := b.connect()
+	if err != nil {
+		return nil, err
+	}
+	go b.readLoop(r)
+	return b, nil
+}
+
+func (b *natsBroker) dial() (net.Conn, *bufio.Reader, error) {
+	c, err := net.DialTimeout("tcp", b.addr, b.option.ConnectTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.SetDeadline(time.Now().Add(b.option.ConnectTimeout))
+	r := bufio.NewReader(c)
+
+	line, err := natsReadLine(r)
+	if err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		c.Close()
+		return nil, nil, fmt.Errorf("mq: nats server sends unexpected %s", line)
+	}
+	info := natsInfo{}
+	if err := json.Unmarshal([]byte(line[5:]), &info); err != nil {
+		c.Close()
+		return nil, nil, fmt.Errorf("mq: nats server sends invalid INFO: %s", err.Error())
+	}
+
+	if info.TLSRequired || b.option.TLS {
+		host, _, _ := net.SplitHostPort(b.addr)
+		tc := tls.Client(c, &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: b.option.InsecureSkipVerify,
+		})
+		if err := tc.Handshake(); err != nil {
+			c.Close()
+			return nil, nil, err
+		}
+		c = tc
+		r = bufio.NewReader(c)
+	}
+
+	cmd, _ := json.Marshal(&natsConnect{
+		Name:     b.option.Name,
+		User:     b.option.User,
+		Pass:     b.option.Password,
+		Token:    b.option.Token,
+		Lang:     "go",
+		Version:  "moons",
+		Protocol: 1,
+		Headers:  info.Headers,
+	})
+	if _, err := fmt.Fprintf(c, "CONNECT %s\r\nPING\r\n", cmd); err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+
+	// the PONG confirms the CONNECT, the auth failure is reported as -ERR
+	for {
+		line, err := natsReadLine(r)
+		if err != nil {
+			c.Close()
+			return nil, nil, err
+		}
+		switch {
+		case line == "PONG":
+			c.SetDeadline(time.Time{})
+			b.info = info
+			return c, r, nil
+		case strings.HasPrefix(line, "-ERR"):
+			c.Close()
+			return nil, nil, fmt.Errorf("mq: nats server error %s", strings.TrimSpace(line[4:]))
+		default:
+			// +OK or INFO update
+		}
+	}
+}
+
+// establishes the connection and restores the subscriptions
+func (b *natsBroker) connect() (*bufio.Reader, error) {
+	c, r, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		c.Close()
+		return nil, fmt.Errorf("mq: broker is closed")
+	}
+	b.conn = c
+	b.w = bufio.NewWriter(c)
+	for _, s := range b.sub {
+		b.writeSub(s)
+	}
+	if err := b.w.Flush(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (b *natsBroker) reconnect() (*bufio.Reader, bool) {
+	for i := 0; b.option.MaxReconnect < 0 || i < b.option.MaxReconnect; i++ {
+		time.Sleep(b.option.ReconnectWait)
+
+		b.Lock()
+		closed := b.closed
+		b.Unlock()
+		if closed {
+			return nil, false
+		}
+
+		if r, err := b.connect(); err == nil {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+func (b *natsBroker) readLoop(r *bufio.Reader) {
+	for {
+		err := b.read(r)
+
+		b.Lock()
+		b.conn.Close()
+		b.conn = nil
+		closed := b.closed
+		b.Unlock()
+
+		if closed || err == nil {
+			return
+		}
+		var ok bool
+		if r, ok = b.reconnect(); !ok {
+			b.Close()
+			return
+		}
+	}
+}
+
+func (b *natsBroker) read(r *bufio.Reader) error {
+	for {
+		line, err := natsReadLine(r)
+		if err != nil {
+			return err
+		}
+		op := line
+		if idx := strings.IndexByte(line, ' '); idx >= 0 {
+			op = line[:idx]
+		}
+
+		switch strings.ToUpper(op) {
+		case "MSG":
+			if err := b.readMsg(r, line, false); err != nil {
+				return err
+			}
+		case "HMSG":
+			if err := b.readMsg(r, line, true); err != nil {
+				return err
+			}
+		case "PING":
+			b.Lock()
+			if b.w != nil {
+				b.w.WriteString("PONG\r\n")
+				b.w.Flush()
+			}
+			b.Unlock()
+		case "-ERR":
+			// the server closes the connection after the error, the read fails
+			// next and the connection is re-established
+		default:
+			// PONG, +OK and INFO
+		}
+	}
+}
+
+// MSG <subject> <sid> [reply-to] <#bytes>
+// HMSG <subject> <sid> [reply-to] <#header bytes> <#total bytes>
+func (b *natsBroker) readMsg(r *bufio.Reader, line string, header bool) error {
+	f := strings.Fields(line)
+	n := 4
+	if header {
+		n = 5
+	}
+	if len(f) != n && len(f) != n+1 {
+		return fmt.Errorf("mq: nats malformed %s", f[0])
+	}
+
+	m := &Message{Topic: f[1]}
+	sid, err := strconv.ParseInt(f[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("mq: nats malformed sid")
+	}
+	if len(f) == n+1 {
+		m.Reply = f[3]
+	}
+	total, err := strconv.Atoi(f[len(f)-1])
+	if err != nil || total < 0 {
+		return fmt.Errorf("mq: nats malformed size")
+	}
+	hsize := 0
+	if header {
+		if hsize, err = strconv.Atoi(f[len(f)-2]); err != nil || hsize < 0 || hsize > total {
+			return fmt.Errorf("mq: nats malformed header size")
+		}
+	}
+
+	data := make([]byte, total+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	if header {
+		m.Header = natsParseHeader(data[:hsize])
+	}
+	m.Data = data[hsize:total]
+
+	b.Lock()
+	s, ok := b.sub[sid]
+	b.Unlock()
+	if ok {
+		select {
+		case s.pending <- m:
+		default:
+			// slow consumer, the message is dropped instead of blocking the
+			// connection
+			s.dropped++
+		}
+	}
+	return nil
+}
+
+func natsParseHeader(data []byte) map[string]string {
+	o := make(map[string]string)
+	lines := strings.Split(string(data), "\r\n")
+	// first line is the version line, ie NATS/1.0
+	for _, l := range lines[1:] {
+		idx := strings.IndexByte(l, ':')
+		if idx <= 0 {
+			continue
+		}
+		o[strings.TrimSpace(l[:idx])] = strings.TrimSpace(l[idx+1:])
+	}
+	return o
+}
+
+func natsReadLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return "", fmt.Errorf("mq: nats control line is too long")
+		}
+		return "", err
+	}
+	if len(line) > natsMaxControlLine {
+		return "", fmt.Errorf("mq: nats control line is too long")
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func natsCheckTopic(topic string) error {
+	if topic == "" || strings.ContainsAny(topic, " \t\r\n") {
+		return fmt.Errorf("mq: invalid topic %q", topic)
+	}
+	return nil
+}
+
+func (b *natsBroker) writeSub(s *natsSub) {
+	if s.queue != "" {
+		fmt.Fprintf(b.w, "SUB %s %s %d\r\n", s.topic, s.queue, s.sid)
+	} else {
+		fmt.Fprintf(b.w, "SUB %s %d\r\n", s.topic, s.sid)
+	}
+}
+
+func (b *natsBroker) Publish(topic string, data []byte, header map[string]string) error {
+	if err := natsCheckTopic(topic); err != nil {
+		return err
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		return fmt.Errorf("mq: broker is closed")
+	}
+	if b.conn == nil {
+		return fmt.Errorf("mq: nats is reconnecting")
+	}
+	if b.info.MaxPayload > 0 && len(data) > b.info.MaxPayload {
+		return fmt.Errorf("mq: payload exceeds the max payload %d", b.info.MaxPayload)
+	}
+
+	if len(header) == 0 {
+		fmt.Fprintf(b.w, "PUB %s %d\r\n", topic, len(data))
+	} else {
+		if !b.info.Headers {
+			return fmt.Errorf("mq: nats server does not support header")
+		}
+		h := bytes.Buffer{}
+		h.WriteString("NATS/1.0\r\n")
+		for k, v := range header {
+			if strings.ContainsAny(k, ":\r\n") || strings.ContainsAny(v, "\r\n") {
+				return fmt.Errorf("mq: invalid header %s", k)
+			}
+			h.WriteString(k)
+			h.WriteString(": ")
+			h.WriteString(v)
+			h.WriteString("\r\n")
+		}
+		h.WriteString("\r\n")
+		fmt.Fprintf(b.w, "HPUB %s %d %d\r\n", topic, h.Len(), h.Len()+len(data))
+		b.w.Write(h.Bytes())
+	}
+	b.w.Write(data)
+	b.w.WriteString("\r\n")
+	return b.w.Flush()
+}
+
+func (b *natsBroker) Subscribe(topic string, queue string, handler Handler) (Subscription, error) {
+	if err := natsCheckTopic(topic); err != nil {
+		return nil, err
+	}
+	if queue != "" && strings.ContainsAny(queue, " \t\r\n") {
+		return nil, fmt.Errorf("mq: invalid queue %q", queue)
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		return nil, fmt.Errorf("mq: broker is closed")
+	}
+
+	b.sid++
+	s := &natsSub{
+		broker:  b,
+		sid:     b.sid,
+		topic:   topic,
+		queue:   queue,
+		handler: handler,
+		pending: make(chan *Message, natsPendingSize),
+		done:    make(chan struct{}),
+	}
+	b.sub[s.sid] = s
+	go s.run()
+
+	// the subscription is restored once reconnected if the connection is lost
+	if b.conn != nil {
+		b.writeSub(s)
+		b.w.Flush()
+	}
+	return s, nil
+}
+
+func (b *natsBroker) unsubscribe(s *natsSub) error {
+	b.Lock()
+	defer b.Unlock()
+	if _, ok := b.sub[s.sid]; !ok {
+		return nil
+	}
+	delete(b.sub, s.sid)
+	close(s.done)
+	if b.conn != nil {
+		fmt.Fprintf(b.w, "UNSUB %d\r\n", s.sid)
+		return b.w.Flush()
+	}
+	return nil
+}
+
+func (b *natsBroker) Close() error {
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for sid, s := range b.sub {
+		close(s.done)
+		delete(b.sub, sid)
+	}
+	if b.conn != nil {
+		b.w.Flush()
+		b.conn.Close()
+	}
+	return nil
+}
+
+func init() {
+	Register("nats", newNatsBroker)
+}
@@ -0,0 +1,323 @@
+package openapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/dianpeng/moons/pl"
+)
+
+// OpenAPI 3 specification driving the routing of a service. Each operation
+// of the spec becomes a route, and the operationId names the event emitted for
+// the matched request. Only the parts needed for routing and validation are
+// loaded, ie the paths, the parameters and the JSON request body, the schemas
+// are compiled by the JSON schema validator of pl and can refer the schemas
+// of the components by local $ref. The spec must be JSON.
+
+var httpMethodList = []string{
+	"get",
+	"put",
+	"post",
+	"delete",
+	"options",
+	"head",
+	"patch",
+	"trace",
+}
+
+type Param struct {
+	Name     string
+	In       string
+	Required bool
+
+	// type of the schema, used to convert the string value of the parameter
+	// before validation
+	Type     string
+	ItemType string
+	Schema   *pl.Schema
+}
+
+type Operation struct {
+	Id     string
+	Method string
+	Path   string
+	Param  []*Param
+
+	// JSON request body, nil if the operation does not declare one
+	Body         *pl.Schema
+	BodyRequired bool
+}
+
+type Spec struct {
+	Title     string
+	Version   string
+	Operation []*Operation
+}
+
+type operationKey struct{}
+
+// WithOperation attaches the operation matched by the request to the context
+func WithOperation(ctx context.Context, op *Operation) context.Context {
+	return context.WithValue(ctx, operationKey{}, op)
+}
+
+// OperationOf returns the operation matched by the request, nil if the
+// request is not routed by a spec
+func OperationOf(r *http.Request) *Operation {
+	op, _ := r.Context().Value(operationKey{}).(*Operation)
+	return op
+}
+
+type parser struct {
+	root       pl.Val
+	components pl.Val
+}
+
+func (p *parser) get(v pl.Val, key string) (pl.Val, bool) {
+	if !v.IsMap() {
+		return pl.NewValNull(), false
+	}
+	return v.Map().Get(key)
+}
+
+func (p *parser) str(v pl.Val, key string) string {
+	if x, ok := p.get(v, key); ok && x.IsString() {
+		return x.String()
+	}
+	return ""
+}
+
+func (p *parser) boolean(v pl.Val, key string) bool {
+	if x, ok := p.get(v, key); ok && x.IsBool() {
+		return x.Bool()
+	}
+	return false
+}
+
+// follows the local $ref of the spec, ie parameters and request bodies can be
+// shared via the components
+func (p *parser) deref(v pl.Val) (pl.Val, error) {
+	for i := 0; i < 32; i++ {
+		ref, ok := p.get(v, "$ref")
+		if !ok {
+			return v, nil
+		}
+		if !ref.IsString() || !strings.HasPrefix(ref.String(), "#/") {
+			return v, fmt.Errorf("$ref must be local reference")
+		}
+		target := p.root
+		for _, seg := range strings.Split(ref.String()[2:], "/") {
+			seg = strings.ReplaceAll(strings.ReplaceAll(seg, "~1", "/"), "~0", "~")
+			x, ok := p.get(target, seg)
+			if !ok {
+				return v, fmt.Errorf("$ref %s cannot be resolved", ref.String())
+			}
+			target = x
+		}
+		v = target
+	}
+	return v, fmt.Errorf("$ref is too deep")
+}
+
+// compiles the schema, the components are attached to the schema so the
+// local $ref of the schema resolves against the spec
+func (p *parser) schema(v pl.Val) (*pl.Schema, error) {
+	if v.IsMap() && !p.components.IsNull() {
+		x := pl.NewValMap()
+		x.Map().Merge(v.Map(), false)
+		x.AddMap("components", p.components)
+		v = x
+	}
+	return pl.CompileSchema(v)
+}
+
+func (p *parser) schemaType(v pl.Val) string {
+	v, err := p.deref(v)
+	if err != nil {
+		return ""
+	}
+	return p.str(v, "type")
+}
+
+func (p *parser) param(v pl.Val) (*Param, error) {
+	v, err := p.deref(v)
+	if err != nil {
+		return nil, err
+	}
+	x := &Param{
+		Name:     p.str(v, "name"),
+		In:       p.str(v, "in"),
+		Required: p.boolean(v, "required"),
+	}
+	if x.Name == "" {
+		return nil, fmt.Errorf("parameter name is not specified")
+	}
+	switch x.In {
+	case "path":
+		x.Required = true
+	case "query", "header", "cookie":
+		break
+	default:
+		return nil, fmt.Errorf("parameter %s location %s is unknown", x.Name, x.In)
+	}
+
+	if s, ok := p.get(v, "schema"); ok {
+		x.Type = p.schemaType(s)
+		if items, ok := p.get(s, "items"); ok {
+			x.ItemType = p.schemaType(items)
+		}
+		if x.Schema, err = p.schema(s); err != nil {
+			return nil, fmt.Errorf("parameter %s: %s", x.Name, err.Error())
+		}
+	}
+	return x, nil
+}
+
+func (p *parser) body(op *Operation, v pl.Val) error {
+	v, err := p.deref(v)
+	if err != nil {
+		return err
+	}
+	op.BodyRequired = p.boolean(v, "required")
+	content, _ := p.get(v, "content")
+	media, ok := p.get(content, "application/json")
+	if !ok {
+		return nil
+	}
+	s, ok := p.get(media, "schema")
+	if !ok {
+		s = pl.NewValBool(true)
+	}
+	if op.Body, err = p.schema(s); err != nil {
+		return fmt.Errorf("request body: %s", err.Error())
+	}
+	return nil
+}
+
+// parameters of the operation override the ones of the path item with the
+// same name and location
+func (p *parser) params(op *Operation, list ...pl.Val) error {
+	for _, l := range list {
+		if !l.IsList() {
+			continue
+		}
+		for i := 0; i < l.List().Length(); i++ {
+			x, err := p.param(l.List().At(i))
+			if err != nil {
+				return err
+			}
+			replaced := false
+			for j, old := range op.Param {
+				if old.Name == x.Name && old.In == x.In {
+					op.Param[j] = x
+					replaced = true
+				}
+			}
+			if !replaced {
+				op.Param = append(op.Param, x)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *parser) operation(path, method string, item, v pl.Val) (*Operation, error) {
+	op := &Operation{
+		Id:     p.str(v, "operationId"),
+		Method: strings.ToUpper(method),
+		Path:   path,
+	}
+	if op.Id == "" {
+		return nil, fmt.Errorf("operationId is not specified")
+	}
+
+	shared, _ := p.get(item, "parameters")
+	own, _ := p.get(v, "parameters")
+	if err := p.params(op, shared, own); err != nil {
+		return nil, err
+	}
+	if b, ok := p.get(v, "requestBody"); ok {
+		if err := p.body(op, b); err != nil {
+			return nil, err
+		}
+	}
+	return op, nil
+}
+
+// the concrete path must be routed before the templated one, ie /pets/mine
+// before /pets/{id}
+func lessPath(a, b string) bool {
+	sa := strings.Split(a, "/")
+	sb := strings.Split(b, "/")
+	for i := 0; i < len(sa) && i < len(sb); i++ {
+		ta := strings.HasPrefix(sa[i], "{")
+		tb := strings.HasPrefix(sb[i], "{")
+		if ta != tb {
+			return tb
+		}
+	}
+	return false
+}
+
+// Parse loads the spec from its JSON document
+func Parse(data []byte) (*Spec, error) {
+	root, err := pl.NewValFromJSONOrdered(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("openapi: %s", err.Error())
+	}
+	p := &parser{
+		root:       root,
+		components: pl.NewValNull(),
+	}
+	if x, ok := p.get(root, "components"); ok {
+		p.components = x
+	}
+
+	if v := p.str(root, "openapi"); !strings.HasPrefix(v, "3.") {
+		return nil, fmt.Errorf("openapi: version %q is not supported, 3.x is required", v)
+	}
+	info, _ := p.get(root, "info")
+	spec := &Spec{
+		Title:   p.str(info, "title"),
+		Version: p.str(info, "version"),
+	}
+
+	paths, ok := p.get(root, "paths")
+	if !ok || !paths.IsMap() {
+		return nil, fmt.Errorf("openapi: paths is not specified")
+	}
+
+	seen := make(map[string]bool)
+	for _, path := range paths.Map().Keys() {
+		item, _ := paths.Map().Get(path)
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("openapi: path %s must start with /", path)
+		}
+		for _, method := range httpMethodList {
+			v, ok := p.get(item, method)
+			if !ok {
+				continue
+			}
+			op, err := p.operation(path, method, item, v)
+			if err != nil {
+				return nil, fmt.Errorf("openapi: %s %s: %s", method, path, err.Error())
+			}
+			if seen[op.Id] {
+				return nil, fmt.Errorf("openapi: operationId %s is duplicated", op.Id)
+			}
+			seen[op.Id] = true
+			spec.Operation = append(spec.Operation, op)
+		}
+	}
+
+	sort.SliceStable(
+		spec.Operation,
+		func(i, j int) bool {
+			return lessPath(spec.Operation[i].Path, spec.Operation[j].Path)
+		},
+	)
+	return spec, nil
+}
@@ -0,0 +1,185 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dianpeng/moons/pl"
+)
+
+// Violation is one failure of the request against the spec
+type Violation struct {
+	In      string
+	Name    string
+	Message string
+}
+
+func (v Violation) ToVal() pl.Val {
+	x := pl.NewValMap()
+	x.AddMap("in", pl.NewValStr(v.In))
+	x.AddMap("name", pl.NewValStr(v.Name))
+	x.AddMap("message", pl.NewValStr(v.Message))
+	return x
+}
+
+// converts the string value of the parameter by the type of its schema, the
+// value is left as string if it cannot be converted and the schema reports
+// the violation
+func convert(s string, t string) pl.Val {
+	switch t {
+	case "integer":
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return pl.NewValInt64(i)
+		}
+	case "number":
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return pl.NewValInt64(i)
+		}
+		if r, err := strconv.ParseFloat(s, 64); err == nil {
+			return pl.NewValReal(r)
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return pl.NewValBool(b)
+		}
+	}
+	return pl.NewValStr(s)
+}
+
+func (p *Param) raw(r *http.Request, vars map[string]string) ([]string, bool) {
+	switch p.In {
+	case "path":
+		v, ok := vars[p.Name]
+		return []string{v}, ok
+	case "query":
+		v, ok := r.URL.Query()[p.Name]
+		return v, ok && len(v) > 0
+	case "header":
+		v, ok := r.Header[http.CanonicalHeaderKey(p.Name)]
+		return v, ok && len(v) > 0
+	default:
+		c, err := r.Cookie(p.Name)
+		if err != nil {
+			return nil, false
+		}
+		return []string{c.Value}, true
+	}
+}
+
+func (p *Param) value(raw []string) pl.Val {
+	if p.Type != "array" {
+		return convert(raw[0], p.Type)
+	}
+
+	// array is either exploded, ie a=1&a=2, or comma separated
+	if len(raw) == 1 && (p.In != "query" || strings.Contains(raw[0], ",")) {
+		raw = strings.Split(raw[0], ",")
+	}
+	o := pl.NewValList()
+	for _, x := range raw {
+		o.AddList(convert(x, p.ItemType))
+	}
+	return o
+}
+
+func (p *Param) validate(v pl.Val, out *[]Violation) {
+	if p.Schema == nil {
+		return
+	}
+	errs := p.Schema.Validate(v)
+	for i := 0; i < errs.List().Length(); i++ {
+		x := errs.List().At(i)
+		e := x.Map()
+		path, _ := e.Get("path")
+		msg, _ := e.Get("message")
+		name := p.Name
+		if path.String() != "" {
+			name = name + path.String()
+		}
+		*out = append(*out, Violation{
+			In:      p.In,
+			Name:    name,
+			Message: msg.String(),
+		})
+	}
+}
+
+// Params collects the parameters of the request, the value is converted by
+// the schema type, and validated against the schema if validate is true. The
+// parameters are returned as a map of location to the map of name to value
+func (op *Operation) Params(
+	r *http.Request,
+	vars map[string]string,
+	validate bool,
+) (pl.Val, []Violation) {
+	o := pl.NewValMap()
+	for _, in := range []string{"path", "query", "header", "cookie"} {
+		o.AddMap(in, pl.NewValMap())
+	}
+
+	var out []Violation
+	for _, p := range op.Param {
+		raw, ok := p.raw(r, vars)
+		if !ok {
+			if validate && p.Required {
+				out = append(out, Violation{
+					In:      p.In,
+					Name:    p.Name,
+					Message: "required parameter is missing",
+				})
+			}
+			continue
+		}
+		v := p.value(raw)
+		if validate {
+			p.validate(v, &out)
+		}
+		x, _ := o.Map().Get(p.In)
+		x.AddMap(p.Name, v)
+	}
+	return o, out
+}
+
+// RequestBody decodes the JSON body and validates it against the schema if
+// validate is true. The body is null when it is empty or the operation has no
+// JSON body
+func (op *Operation) RequestBody(data []byte, validate bool) (pl.Val, []Violation) {
+	if len(data) == 0 {
+		if validate && op.BodyRequired {
+			return pl.NewValNull(), []Violation{
+				{In: "body", Message: "request body is required"},
+			}
+		}
+		return pl.NewValNull(), nil
+	}
+	if op.Body == nil {
+		return pl.NewValNull(), nil
+	}
+
+	v, err := pl.NewValFromJSON(string(data))
+	if err != nil {
+		return pl.NewValNull(), []Violation{
+			{In: "body", Message: fmt.Sprintf("invalid JSON: %s", err.Error())},
+		}
+	}
+	if !validate {
+		return v, nil
+	}
+
+	var out []Violation
+	errs := op.Body.Validate(v)
+	for i := 0; i < errs.List().Length(); i++ {
+		x := errs.List().At(i)
+		e := x.Map()
+		path, _ := e.Get("path")
+		msg, _ := e.Get("message")
+		out = append(out, Violation{
+			In:      "body",
+			Name:    path.String(),
+			Message: msg.String(),
+		})
+	}
+	return v, out
+}
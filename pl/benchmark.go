@@ -0,0 +1,128 @@
+package pl
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// Benchmark of the rule of a module, so the performance regression of the
+// policy is measurable. The rule is run repeatedly by one evaluator, the warmup
+// runs are not measured, and the cost per run is reported in time, memory
+// allocated and bytecode instructions executed.
+
+const defaultBenchmarkDuration = time.Second
+
+type BenchmarkOption struct {
+	// number of the runs before measuring
+	Warmup int
+
+	// number of the runs measured, 0 means running until the Duration passes
+	Iteration int
+
+	// how long to run when Iteration is 0, default 1 second
+	Duration time.Duration
+
+	// event context of the rule, ie $
+	Context Val
+
+	// evaluates the session scope before every run, as a new session does
+	Session bool
+}
+
+type BenchmarkResult struct {
+	Event string
+	N     int
+	Time  time.Duration
+
+	NsPerOp     int64
+	BytesPerOp  int64
+	AllocsPerOp int64
+	InstrPerOp  int64
+}
+
+// String formats the result in the form of go test -bench
+func (r *BenchmarkResult) String() string {
+	return fmt.Sprintf("%s\t%d\t%d ns/op\t%d B/op\t%d allocs/op\t%d instr/op",
+		r.Event, r.N, r.NsPerOp, r.BytesPerOp, r.AllocsPerOp, r.InstrPerOp)
+}
+
+func benchmarkRun(e *Evaluator, module *Module, event string, opt *BenchmarkOption) error {
+	if opt.Session {
+		if err := e.EvalSession(module); err != nil {
+			return err
+		}
+	}
+	_, err := e.EvalWithContext(event, opt.Context, module)
+	return err
+}
+
+// Benchmark runs the rule of the event against the context, the global and
+// session scopes are evaluated once before the warmup. Any error of the rule
+// fails the benchmark
+func Benchmark(module *Module, event string, context EvalContext, opt *BenchmarkOption) (*BenchmarkResult, error) {
+	if opt == nil {
+		opt = &BenchmarkOption{}
+	}
+	if !module.HaveEvent(event) {
+		return nil, fmt.Errorf("benchmark: rule %s is not found", event)
+	}
+	duration := opt.Duration
+	if duration <= 0 {
+		duration = defaultBenchmarkDuration
+	}
+
+	e := NewEvaluatorWithContext(context)
+	if err := e.EvalGlobal(module); err != nil {
+		return nil, err
+	}
+	if err := e.EvalSession(module); err != nil {
+		return nil, err
+	}
+	for i := 0; i < opt.Warmup; i++ {
+		if err := benchmarkRun(e, module, event, opt); err != nil {
+			return nil, err
+		}
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	steps := e.Steps()
+	start := time.Now()
+
+	n := 0
+	if opt.Iteration > 0 {
+		for ; n < opt.Iteration; n++ {
+			if err := benchmarkRun(e, module, event, opt); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		// runs in batches growing in size, so the clock is not read every run
+		for batch := 1; time.Since(start) < duration; batch *= 2 {
+			for i := 0; i < batch; i++ {
+				if err := benchmarkRun(e, module, event, opt); err != nil {
+					return nil, err
+				}
+			}
+			n += batch
+		}
+	}
+
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	r := &BenchmarkResult{
+		Event: event,
+		N:     n,
+		Time:  elapsed,
+	}
+	if n > 0 {
+		r.NsPerOp = elapsed.Nanoseconds() / int64(n)
+		r.BytesPerOp = int64(after.TotalAlloc-before.TotalAlloc) / int64(n)
+		r.AllocsPerOp = int64(after.Mallocs-before.Mallocs) / int64(n)
+		r.InstrPerOp = (e.Steps() - steps) / int64(n)
+	}
+	return r, nil
+}
@@ -0,0 +1,47 @@
+package pl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBenchmark(t *testing.T) {
+	assert := assert.New(t)
+
+	module, err := CompileModule(`
+rule sum {
+  let s = 0;
+  for let i = 0; i < $; i++ {
+    s += i;
+  }
+  return s;
+}
+
+rule fail {
+  foo();
+}
+`, nil)
+	assert.Nil(err)
+
+	small, err := Benchmark(module, "sum", NewNullEvalContext(), &BenchmarkOption{
+		Warmup:    10,
+		Iteration: 100,
+		Context:   NewValInt(10),
+	})
+	assert.Nil(err)
+	assert.Equal(100, small.N)
+	assert.True(small.InstrPerOp > 0)
+
+	large, err := Benchmark(module, "sum", NewNullEvalContext(), &BenchmarkOption{
+		Iteration: 100,
+		Context:   NewValInt(100),
+	})
+	assert.Nil(err)
+	assert.True(large.InstrPerOp > small.InstrPerOp)
+
+	_, err = Benchmark(module, "fail", NewNullEvalContext(), nil)
+	assert.NotNil(err)
+	_, err = Benchmark(module, "unknown", NewNullEvalContext(), nil)
+	assert.NotNil(err)
+}
@@ -0,0 +1,214 @@
+package pl
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Bind exposes an arbitrary go function as intrinsic module::name, or plain
+// name when module is empty, without writing wrapper per function. The
+// arguments from script are converted into the go parameter types with
+// Unmarshal, so struct, slice, map, pointer and time.Time parameters work as
+// long as the script passes compatible value, and the return value is
+// converted back with Marshal.
+//
+// The following leading parameters are not visible to script and are
+// injected by the binding instead, in any order:
+//
+//	*Evaluator, the evaluator calls the function
+//	context.Context, provided by EvalContext implementing GoContextProvider,
+//	otherwise context.Background()
+//
+// The function may be variadic, and may return nothing, a value, an error or
+// a value followed by an error. The optional doc string is recorded along
+// with the intrinsic.
+func Bind(module, name string, fn AnyFunc, doc ...string) error {
+	cname := name
+	if module != "" {
+		cname = modFuncName(module, name)
+	}
+	if getIntrinsicByName(cname) != nil {
+		return fmt.Errorf("pl.Bind: function %s already exists", cname)
+	}
+
+	b, err := newBinding(cname, fn)
+	if err != nil {
+		return fmt.Errorf("pl.Bind: %s", err.Error())
+	}
+
+	x := &IntrinsicInfo{
+		cname:     cname,
+		argproto:  MustNewFuncProto(cname, "%a*"),
+		rawfunc:   fn,
+		funcvalue: &b.fn,
+		doc:       strings.Join(doc, "\n"),
+	}
+	x.entry = b.call
+	addiindex(x)
+	intrinsicFunc = append(intrinsicFunc, x)
+	return nil
+}
+
+func MustBind(module, name string, fn AnyFunc, doc ...string) {
+	if err := Bind(module, name, fn, doc...); err != nil {
+		panic(err.Error())
+	}
+}
+
+// implemented by EvalContext which carries a go context, ie the context of
+// the request been processed, the context is passed to bound go function
+// which takes context.Context
+type GoContextProvider interface {
+	GoContext() context.Context
+}
+
+var (
+	bindEvalType    = reflect.TypeOf((*Evaluator)(nil))
+	bindContextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	bindErrorType   = reflect.TypeOf((*error)(nil)).Elem()
+	bindRegexpType  = reflect.TypeOf((*regexp.Regexp)(nil))
+)
+
+type binding struct {
+	name     string
+	fn       reflect.Value
+	inject   []reflect.Type
+	params   []reflect.Type
+	variadic reflect.Type
+	hasValue bool
+	hasError bool
+}
+
+func newBinding(name string, fn AnyFunc) (*binding, error) {
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func || fv.IsNil() {
+		return nil, fmt.Errorf("%s is not a function", name)
+	}
+	ft := fv.Type()
+
+	b := &binding{
+		name: name,
+		fn:   fv,
+	}
+
+	n := ft.NumIn()
+	if ft.IsVariadic() {
+		b.variadic = ft.In(n - 1).Elem()
+		n--
+	}
+
+	i := 0
+	for ; i < n; i++ {
+		t := ft.In(i)
+		if t != bindEvalType && t != bindContextType {
+			break
+		}
+		b.inject = append(b.inject, t)
+	}
+	for ; i < n; i++ {
+		b.params = append(b.params, ft.In(i))
+	}
+
+	switch ft.NumOut() {
+	case 0:
+		break
+	case 1:
+		if ft.Out(0) == bindErrorType {
+			b.hasError = true
+		} else {
+			b.hasValue = true
+		}
+	case 2:
+		if ft.Out(1) != bindErrorType {
+			return nil, fmt.Errorf("%s's second return value must be error", name)
+		}
+		b.hasValue = true
+		b.hasError = true
+	default:
+		return nil, fmt.Errorf("%s returns too many values", name)
+	}
+
+	return b, nil
+}
+
+func (b *binding) injectValue(e *Evaluator, t reflect.Type) reflect.Value {
+	if t == bindEvalType {
+		return reflect.ValueOf(e)
+	}
+
+	ctx := context.Background()
+	if e != nil {
+		if p, ok := e.Context.(GoContextProvider); ok {
+			if x := p.GoContext(); x != nil {
+				ctx = x
+			}
+		}
+	}
+	return reflect.ValueOf(&ctx).Elem()
+}
+
+func (b *binding) arg(v Val, t reflect.Type, idx int) (reflect.Value, error) {
+	x := reflect.New(t).Elem()
+	if err := unmarshalRoot(v, x); err != nil {
+		return x, fmt.Errorf("%s: argument %d, %s", b.name, idx+1, err.Error())
+	}
+	return x, nil
+}
+
+func (b *binding) call(e *Evaluator, _ string, args []Val) (val Val, err error) {
+	if len(args) < len(b.params) || (b.variadic == nil && len(args) > len(b.params)) {
+		if b.variadic == nil {
+			return NewValNull(), fmt.Errorf("%s: expect %d arguments, got %d",
+				b.name, len(b.params), len(args))
+		}
+		return NewValNull(), fmt.Errorf("%s: expect at least %d arguments, got %d",
+			b.name, len(b.params), len(args))
+	}
+
+	in := make([]reflect.Value, 0, len(b.inject)+len(args))
+	for _, t := range b.inject {
+		in = append(in, b.injectValue(e, t))
+	}
+	for i, a := range args {
+		t := b.variadic
+		if i < len(b.params) {
+			t = b.params[i]
+		}
+		x, err := b.arg(a, t, i)
+		if err != nil {
+			return NewValNull(), err
+		}
+		in = append(in, x)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			val = NewValNull()
+			err = fmt.Errorf("%s: %+v", b.name, r)
+		}
+	}()
+
+	out := b.fn.Call(in)
+
+	if b.hasError {
+		if x := out[len(out)-1]; !x.IsNil() {
+			return NewValNull(), fmt.Errorf("%s: %s", b.name, x.Interface().(error).Error())
+		}
+	}
+	if !b.hasValue {
+		return NewValNull(), nil
+	}
+
+	r := out[0]
+	if r.Type() == bindRegexpType && !r.IsNil() {
+		return NewValRegexp(r.Interface().(*regexp.Regexp)), nil
+	}
+	v, err := marshalValue(r, make(marshalSeen))
+	if err != nil {
+		return NewValNull(), fmt.Errorf("%s: return value, %s", b.name, err.Error())
+	}
+	return v, nil
+}
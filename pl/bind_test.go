@@ -0,0 +1,81 @@
+package pl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type bindPoint struct {
+	X int `pl:"x"`
+	Y int `pl:"y"`
+}
+
+func init() {
+	MustBind("bindtest", "add", func(a, b int) int {
+		return a + b
+	}, "add two integers")
+
+	MustBind("bindtest", "join", func(sep string, x ...string) string {
+		return strings.Join(x, sep)
+	})
+
+	MustBind("bindtest", "move", func(p bindPoint, dx int) (*bindPoint, error) {
+		if dx < 0 {
+			return nil, fmt.Errorf("negative")
+		}
+		return &bindPoint{X: p.X + dx, Y: p.Y}, nil
+	})
+
+	MustBind("bindtest", "ctx", func(ctx context.Context, e *Evaluator, name string) bool {
+		return ctx != nil && e != nil && name == "x"
+	})
+
+	MustBind("bindtest", "check", func(x bool) error {
+		if !x {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	})
+}
+
+func TestBind(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(testInt(`test { output => bindtest::add(1, 2); }`, 3))
+	assert.True(testString(`test { output => bindtest::join("-", "a", "b", "c"); }`, "a-b-c"))
+	assert.True(testString(`test { output => bindtest::join("-"); }`, ""))
+	assert.True(testInt(`test { output => bindtest::move({"x": 1, "y": 2}, 3).x; }`, 4))
+	assert.True(testBool(`test { output => bindtest::ctx("x"); }`, true))
+	assert.True(testNull(`test { output => bindtest::check(true); }`))
+	assert.True(testInt(`test { output => try bindtest::move({"x": 1}, -1) else 10; }`, 10))
+
+	for _, code := range []string{
+		`test { output => bindtest::add(1); }`,
+		`test { output => bindtest::add(1, 2, 3); }`,
+		`test { output => bindtest::add(1, "a"); }`,
+		`test { output => bindtest::join(); }`,
+		`test { output => bindtest::move(1, 2); }`,
+		`test { output => bindtest::check(false); }`,
+	} {
+		_, ok := test(code)
+		assert.False(ok, code)
+	}
+
+	assert.Equal("add two integers", getIntrinsicByName("bindtest::add").Doc())
+
+	assert.NotNil(Bind("bindtest", "add", func() {}))
+	assert.NotNil(Bind("bindtest", "bad0", 1))
+	assert.NotNil(Bind("bindtest", "bad1", func() (int, int) { return 1, 1 }))
+	assert.NotNil(Bind("bindtest", "bad2", func() (int, int, error) { return 1, 1, nil }))
+}
+
+func TestBindCycle(t *testing.T) {
+	assert := assert.New(t)
+
+	_, ok := test(`test { let m = {}; m["self"] = m; output => bindtest::move(m, 1); }`)
+	assert.False(ok)
+}
@@ -48,6 +48,7 @@ const (
 	bcStoreLocal   = 22
 	bcReserveLocal = 23
 	bcLoadRegexp   = 24
+	bcSlice        = 25
 
 	bcAction = 30
 
@@ -151,6 +152,10 @@ const (
 	// render template
 	bcTemplate = 200
 
+	// render template lazily, emitted when template is directly assigned to a
+	// body field, see template_stream.go
+	bcTemplateStream = 201
+
 	// halt the machine
 	bcHalt     = 250
 	bcNextRule = 251
@@ -222,6 +227,18 @@ type program struct {
 
 	// used when the program is a function, ie for capturing its upvalue
 	upvalue []upvalue
+
+	// index of the script functions called via bcSCall, the callee is pushed
+	// as an int constant and is not known from the bcSCall itself
+	scall []int
+
+	// function's argument names and doc comment, for introspection
+	argName []string
+	doc     string
+
+	// execution statistics when the program runs as the top frame, see
+	// Module.Stats
+	stats *ruleStats
 }
 
 func newProgram(p *Module, n string, t int) *program {
@@ -229,6 +246,7 @@ func newProgram(p *Module, n string, t int) *program {
 		module:   p,
 		name:     n,
 		progtype: t,
+		stats:    &ruleStats{},
 	}
 }
 
@@ -324,10 +342,23 @@ func (p *program) addTemplate(t string, c string, opt Val) (int, error) {
 }
 
 func (p *program) addRegexp(r string) (int, error) {
-	rexp, err := regexp.Compile(r)
+	var rexp *regexp.Regexp
+	var err error
+
+	if p.module != nil {
+		rexp, err = p.module.internRegexp(r)
+	} else {
+		rexp, err = regexp.Compile(r)
+	}
 	if err != nil {
 		return 0, err
 	}
+
+	for idx, x := range p.tbRegexp {
+		if x == rexp {
+			return idx, nil
+		}
+	}
 	idx := len(p.tbRegexp)
 	p.tbRegexp = append(p.tbRegexp, rexp)
 	return idx, nil
@@ -415,7 +446,7 @@ func (x *bytecode) dumpWithProgram(p *program) string {
 			return p.idxStr(arg)
 		case bcLoadRegexp:
 			return p.idxRegexp(arg).String()
-		case bcTemplate:
+		case bcTemplate, bcTemplateStream:
 			return "[template]"
 		default:
 			return "<unknown>"
@@ -445,7 +476,8 @@ func (x *bytecode) dump(resolver func(int, int) string) string {
 		bcAction,
 		bcDot,
 		bcLoadRegexp,
-		bcTemplate:
+		bcTemplate,
+		bcTemplateStream:
 
 		b.WriteString(fmt.Sprintf("%s(%d%s)", name, arg, wrapper(x.opcode, arg)))
 		break
@@ -544,6 +576,8 @@ func getBytecodeName(bc int) string {
 		return "dot"
 	case bcIndex:
 		return "index"
+	case bcSlice:
+		return "slice"
 	case bcLoadDollar:
 		return "load-dollar"
 	case bcLoadLocal:
@@ -660,6 +694,8 @@ func getBytecodeName(bc int) string {
 	// special functions
 	case bcTemplate:
 		return "template"
+	case bcTemplateStream:
+		return "template-stream"
 
 	case bcHalt:
 		return "halt"
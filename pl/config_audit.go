@@ -0,0 +1,113 @@
+package pl
+
+import (
+	"strings"
+)
+
+// Audit trail of the config phase. ConfigAudit wraps the EvalConfig of the
+// embedder and records every call made by EvalConfig, so what configuration a
+// deployed module actually produced can be inspected afterwards, ie
+//
+//   {"op": "property", "scope": "http_vhost", "name": "name", "value": "\"shop\""}
+//
+// The value is in JSON form, or the debug info of the value which cannot be
+// converted to JSON, ie a closure.
+
+const (
+	ConfigAuditPush     = "push"
+	ConfigAuditPop      = "pop"
+	ConfigAuditProperty = "property"
+	ConfigAuditCommand  = "command"
+)
+
+// ConfigAuditEntry is one call made during the config phase, the scope is the
+// path of the config scopes the call is made in, joined by dot
+type ConfigAuditEntry struct {
+	Op    string   `json:"op"`
+	Scope string   `json:"scope"`
+	Name  string   `json:"name,omitempty"`
+	Value string   `json:"value,omitempty"`
+	Attr  string   `json:"attr,omitempty"`
+	Args  []string `json:"args,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+type ConfigAudit struct {
+	config EvalConfig
+	scope  []string
+	entry  []ConfigAuditEntry
+}
+
+func NewConfigAudit(config EvalConfig) *ConfigAudit {
+	return &ConfigAudit{
+		config: config,
+	}
+}
+
+// Entries returns the recorded calls in order of evaluation
+func (c *ConfigAudit) Entries() []ConfigAuditEntry {
+	return append([]ConfigAuditEntry{}, c.entry...)
+}
+
+func configAuditValue(v Val) string {
+	if str, err := v.ToJSONString(); err == nil {
+		return str
+	}
+	return v.Info()
+}
+
+func (c *ConfigAudit) record(
+	op string,
+	name string,
+	value string,
+	attr Val,
+	args []Val,
+	err error,
+) {
+	x := ConfigAuditEntry{
+		Op:    op,
+		Scope: strings.Join(c.scope, "."),
+		Name:  name,
+		Value: value,
+	}
+	if !attr.IsNull() {
+		x.Attr = configAuditValue(attr)
+	}
+	for _, v := range args {
+		x.Args = append(x.Args, configAuditValue(v))
+	}
+	if err != nil {
+		x.Error = err.Error()
+	}
+	c.entry = append(c.entry, x)
+}
+
+func (c *ConfigAudit) PushConfig(e *Evaluator, name string, attr Val) error {
+	err := c.config.PushConfig(e, name, attr)
+	c.record(ConfigAuditPush, name, "", attr, nil, err)
+	if err == nil {
+		c.scope = append(c.scope, name)
+	}
+	return err
+}
+
+func (c *ConfigAudit) PopConfig(e *Evaluator) error {
+	err := c.config.PopConfig(e)
+	c.record(ConfigAuditPop, "", "", NewValNull(), nil, err)
+	if err == nil && len(c.scope) > 0 {
+		c.scope = c.scope[:len(c.scope)-1]
+	}
+	return err
+}
+
+func (c *ConfigAudit) ConfigProperty(e *Evaluator, name string, value Val, attr Val) error {
+	err := c.config.ConfigProperty(e, name, value, attr)
+	c.record(ConfigAuditProperty, name, configAuditValue(value), attr, nil, err)
+	return err
+}
+
+func (c *ConfigAudit) ConfigCommand(e *Evaluator, name string, args []Val, attr Val) error {
+	err := c.config.ConfigCommand(e, name, args, attr)
+	c.record(ConfigAuditCommand, name, "", attr, args, err)
+	return err
+}
@@ -0,0 +1,73 @@
+package pl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testConfig struct{}
+
+func (t *testConfig) PushConfig(_ *Evaluator, _ string, _ Val) error {
+	return nil
+}
+
+func (t *testConfig) PopConfig(_ *Evaluator) error {
+	return nil
+}
+
+func (t *testConfig) ConfigProperty(_ *Evaluator, name string, _ Val, _ Val) error {
+	if name == "bad" {
+		return fmt.Errorf("bad property")
+	}
+	return nil
+}
+
+func (t *testConfig) ConfigCommand(_ *Evaluator, _ string, _ []Val, _ Val) error {
+	return nil
+}
+
+func TestConfigAudit(t *testing.T) {
+	assert := assert.New(t)
+
+	module, err := CompileModule(`
+config server {
+  .name = "shop";
+  .port = 8080;
+  .tags = ["a", "b"];
+  request auth("basic", 1);
+}
+`, nil)
+	assert.Nil(err)
+
+	audit := NewConfigAudit(&testConfig{})
+	eval := NewEvaluatorSimple()
+	eval.Config = audit
+	assert.Nil(eval.EvalConfig(module))
+
+	list := audit.Entries()
+	assert.Equal(8, len(list))
+	assert.Equal(ConfigAuditEntry{Op: "push", Name: "server"}, list[0])
+	assert.Equal(ConfigAuditEntry{Op: "property", Scope: "server", Name: "name", Value: `"shop"`}, list[1])
+	assert.Equal(ConfigAuditEntry{Op: "property", Scope: "server", Name: "port", Value: "8080"}, list[2])
+	assert.Equal(`["a","b"]`, list[3].Value)
+	assert.Equal(ConfigAuditEntry{Op: "push", Scope: "server", Name: "request"}, list[4])
+	assert.Equal(ConfigAuditEntry{Op: "command", Scope: "server.request", Name: "auth", Args: []string{`"basic"`, "1"}}, list[5])
+	assert.Equal(ConfigAuditEntry{Op: "pop", Scope: "server.request"}, list[6])
+	assert.Equal(ConfigAuditEntry{Op: "pop", Scope: "server"}, list[7])
+
+	module, err = CompileModule(`
+config server {
+  .bad = 1;
+}
+`, nil)
+	assert.Nil(err)
+
+	audit = NewConfigAudit(&testConfig{})
+	eval.Config = audit
+	assert.NotNil(eval.EvalConfig(module))
+	list = audit.Entries()
+	assert.Equal(2, len(list))
+	assert.Equal("bad property", list[1].Error)
+}
@@ -6,12 +6,15 @@ import (
 	"log"
 	"math"
 	"strings"
+	"time"
 )
 
 const (
 	ConfigRule       = "@config"
 	SessionRule      = "@session"
 	GlobalRule       = "@global"
+	ShutdownRule     = "@shutdown"
+	HealthRule       = "@health"
 	defaultStackSize = 2048
 )
 
@@ -46,6 +49,14 @@ const (
 	EventContextStopAndClear = 2
 )
 
+// Evaluator capability flags. Capability gates intrinsic functions which reach
+// out to the hosting environment, ie process environment variable etc. A newly
+// created evaluator does not have any capability and the embedder must grant
+// them explicitly
+const (
+	CapabilityEnv = 1 << iota
+)
+
 type EventContext interface {
 	// called when an async event is invoked, and it has an error. If this
 	// function returns false, then event queue execution will be halt
@@ -122,6 +133,25 @@ type Evaluator struct {
 	curexcep     Val
 	eventQ       EventQueue
 	inEventQueue bool
+	capability   int
+	policy       *IntrinsicPolicy
+	clock        Clock
+
+	// the evaluator runs in parallel with others sharing the same context and
+	// session, ie q::pmap's worker, so any write to shared state is rejected
+	shared    bool
+	sharedRef *sharedRef
+
+	// closers to be closed once the session ends, see Cleanup
+	cleanup *evalCleanup
+
+	// evaluating the config or global scope, ie the module is being set up
+	setup bool
+
+	// number of the bytecodes executed so far, and the limit of it, 0 means
+	// no limit
+	steps     int64
+	stepLimit int64
 }
 
 type exception struct {
@@ -417,6 +447,28 @@ func (e *Evaluator) popExcep() {
 	e.curframe.excep = e.curframe.excep[:sz-1]
 }
 
+// find out the module that the current executing script belongs to by walking
+// the call frame backwards until a script frame is met. Native frame, ie the
+// intrinsic call, does not have any program associated with it
+func (e *Evaluator) curModule() *Module {
+	ff := &e.curframe
+	for !ff.isTop() {
+		if ff.prog != nil {
+			return ff.prog.module
+		}
+		pos := ff.framep + ff.farg + 1
+		if pos >= len(e.Stack) || !e.Stack[pos].isFrame() {
+			break
+		}
+		prev, ok := e.Stack[pos].frame().(*funcframe)
+		if !ok {
+			break
+		}
+		ff = prev
+	}
+	return nil
+}
+
 func (e *Evaluator) prevframepos() int {
 	// offset by 1 to skip the function's index on the local stack
 	return e.curframe.framep + e.curframe.farg + 1
@@ -474,6 +526,7 @@ func NewEvaluatorWithContext(context EvalContext) *Evaluator {
 		Session: nil,
 		Context: context,
 		eventQ:  &defEventQueue{},
+		cleanup: &evalCleanup{},
 	}
 }
 
@@ -492,7 +545,67 @@ func NewEvaluator(context EvalContext, config EvalConfig) *Evaluator {
 		Context: context,
 		Config:  config,
 		eventQ:  &defEventQueue{},
+		cleanup: &evalCleanup{},
+	}
+}
+
+func (e *Evaluator) SetCapability(c int) {
+	e.capability = c
+}
+
+// grants the capabilities in addition to the ones already granted
+func (e *Evaluator) AddCapability(c int) {
+	e.capability |= c
+}
+
+func (e *Evaluator) Capability() int {
+	return e.capability
+}
+
+func (e *Evaluator) HasCapability(c int) bool {
+	return e.capability&c == c
+}
+
+// restricts the intrinsic functions the script can use, nil means no
+// restriction, see IntrinsicPolicy
+func (e *Evaluator) SetPolicy(p *IntrinsicPolicy) {
+	e.policy = p
+}
+
+func (e *Evaluator) Policy() *IntrinsicPolicy {
+	return e.policy
+}
+
+// Clock is the source of the current time of the time:: functions, ie a fake
+// clock of the tests. Nil means the wall clock
+type Clock interface {
+	Now() time.Time
+}
+
+// Steps returns the number of the bytecodes executed by the evaluator so far
+func (e *Evaluator) Steps() int64 {
+	return e.steps
+}
+
+// once the limit is exceeded, every bytecode fails, so the error cannot be
+// swallowed by try and the execution is unwound to the top
+var errStepLimit = fmt.Errorf("step limit is exceeded")
+
+// SetStepLimit bounds the number of the bytecodes executed, ie Steps, the
+// evaluation fails once the limit is exceeded. 0 means no limit
+func (e *Evaluator) SetStepLimit(limit int64) {
+	e.stepLimit = limit
+}
+
+func (e *Evaluator) SetClock(c Clock) {
+	e.clock = c
+}
+
+func (e *Evaluator) now() time.Time {
+	if e.clock == nil {
+		return time.Now()
 	}
+	return e.clock.Now()
 }
 
 // stack manipulation
@@ -906,6 +1019,10 @@ func (e *Evaluator) runP(
 FUNC:
 	for ; ; pc++ {
 		bc := prog.bcList[pc]
+		e.steps++
+		if e.stepLimit > 0 && e.steps > e.stepLimit {
+			return rrErr(prog, pc, errStepLimit)
+		}
 
 		switch bc.opcode {
 		case bcAction:
@@ -1137,6 +1254,9 @@ FUNC:
 			)
 
 			fentry := intrinsicFunc[funcIndex.Int()]
+			if err := e.policy.check(fentry.cname); err != nil {
+				return rrErr(prog, pc, err)
+			}
 			r, err := fentry.entry(e, "$intrinsic$", arg)
 			if err != nil {
 				return rrErr(prog, pc, err)
@@ -1154,6 +1274,11 @@ FUNC:
 			if err != nil {
 				return rrErr(prog, pc, err)
 			}
+			if e.isShared(recv) {
+				if method, err = e.sharedMethod(recv, name, method); err != nil {
+					return rrErr(prog, pc, err)
+				}
+			}
 			e.push(method)
 
 			break
@@ -1249,8 +1374,8 @@ FUNC:
 				} else {
 					// method function
 					must(mfunc != nil, "method must existed")
-					if val, err := mfunc.entry(
-						mfunc.name,
+					if val, err := mfunc.Call(
+						e,
 						args,
 					); err != nil {
 						return rrErr(prog, pc, err)
@@ -1298,10 +1423,14 @@ FUNC:
 
 		case bcLoadUpvalue:
 			sfunc := e.curframe.mustSFunc()
+			e.markShared(sfunc.upvalue[bc.argument])
 			e.push(sfunc.upvalue[bc.argument])
 			break
 
 		case bcStoreUpvalue:
+			if e.shared {
+				return rrErrf(prog, pc, "cannot modify upvalue in parallel evaluation")
+			}
 			sfunc := e.curframe.mustSFunc()
 			sfunc.upvalue[bc.argument] = e.top0()
 			e.pop()
@@ -1335,17 +1464,24 @@ FUNC:
 			// loading intrinsic function always at first. Intrinsic function cannot
 			// be overwrite for now
 			if ii := getIntrinsicByName(vname); ii != nil {
+				if err := e.policy.check(ii.cname); err != nil {
+					return rrErr(prog, pc, err)
+				}
 				e.push(ii.toVal(e))
 			} else {
 				if val, err := e.Context.LoadVar(e, vname); err != nil {
 					return rrErr(prog, pc, err)
 				} else {
+					e.markShared(val)
 					e.push(val)
 				}
 			}
 			break
 
 		case bcStoreVar:
+			if e.shared {
+				return rrErrf(prog, pc, "cannot modify variable in parallel evaluation")
+			}
 			top := e.top0()
 			e.pop()
 
@@ -1366,16 +1502,37 @@ FUNC:
 			if err != nil {
 				return rrErr(prog, pc, err)
 			}
+			if e.isShared(ee) {
+				e.markShared(val)
+			}
 			e.popN(2)
 			e.push(val)
 			break
 
+		case bcSlice:
+			recv := e.top2()
+			start := e.top1()
+			end := e.top0()
+			val, err := recv.Slice(start, end)
+			if err != nil {
+				return rrErr(prog, pc, err)
+			}
+			if e.isShared(recv) {
+				e.markShared(val)
+			}
+			e.popN(3)
+			e.push(val)
+			break
+
 		case bcIndexSet:
 			recv := e.top2()
 			index := e.top1()
 			value := e.top0()
 			e.popN(3)
 
+			if err := e.checkMutable(recv); err != nil {
+				return rrErr(prog, pc, err)
+			}
 			if err := recv.IndexSet(index, value); err != nil {
 				return rrErr(prog, pc, err)
 			}
@@ -1388,6 +1545,9 @@ FUNC:
 			if err != nil {
 				return rrErr(prog, pc, err)
 			}
+			if e.isShared(ee) {
+				e.markShared(val)
+			}
 			e.pop()
 			e.push(val)
 			break
@@ -1396,8 +1556,20 @@ FUNC:
 			recv := e.top1()
 			value := e.top0()
 			e.popN(2)
+			field := prog.idxStr(bc.argument)
 
-			if err := recv.DotSet(prog.idxStr(bc.argument), value); err != nil {
+			if IsValTemplateStream(value) {
+				v, err := resolveTemplateStream(recv, field, value)
+				if err != nil {
+					return rrErr(prog, pc, err)
+				}
+				value = v
+			}
+
+			if err := e.checkMutable(recv); err != nil {
+				return rrErr(prog, pc, err)
+			}
+			if err := recv.DotSet(field, value); err != nil {
 				return rrErr(prog, pc, err)
 			}
 			break
@@ -1433,6 +1605,12 @@ FUNC:
 			e.push(NewValStr(data))
 			break
 
+		case bcTemplateStream:
+			ctx := e.top0()
+			e.pop()
+			e.push(NewValTemplateStream(prog.idxTemplate(bc.argument), ctx))
+			break
+
 		// session
 		case bcSetSession:
 			ctx := e.top0()
@@ -1444,11 +1622,15 @@ FUNC:
 			if len(e.Session) <= bc.argument {
 				return rrErrf(prog, pc, "session variable is not existed")
 			} else {
+				e.markShared(e.Session[bc.argument])
 				e.push(e.Session[bc.argument])
 			}
 			break
 
 		case bcStoreSession:
+			if e.shared {
+				return rrErrf(prog, pc, "cannot modify session variable in parallel evaluation")
+			}
 			if len(e.Session) <= bc.argument {
 				return rrErrf(prog, pc, "session variable is not existed")
 			} else {
@@ -1580,11 +1762,15 @@ FUNC:
 				return rrErrf(prog, pc, "global variable loading error, "+
 					"global variable is not existed")
 			} else {
+				e.markShared(val)
 				e.push(val)
 			}
 			break
 
 		case bcStoreGlobal:
+			if e.shared {
+				return rrErrf(prog, pc, "cannot modify global variable in parallel evaluation")
+			}
 			ctx := e.top0()
 			e.pop()
 			if !module.StoreGlobal(bc.argument, ctx) {
@@ -1875,6 +2061,13 @@ func (e *Evaluator) runRuleImpl(event Val, prog *program) (Val, error, bool) {
 	// mark the frame as top
 	e.curframe.markTop()
 
+	// leave the evaluator at top frame once done, so closure escaped from the
+	// rule can still be called by the native code afterwards
+	defer func() {
+		e.clearStack()
+		e.curframe.markTop()
+	}()
+
 	// Enter into the VM with a native function call marker. This serves as a
 	// frame marker to indicate the end of the script frame which will help us
 	// to terminate the frame walk
@@ -1931,11 +2124,19 @@ func (e *Evaluator) runRuleImpl(event Val, prog *program) (Val, error, bool) {
 	return NewValNull(), nil, false
 }
 
+// runs the rule and records its execution statistics
+func (e *Evaluator) runRuleStats(event Val, prog *program) (Val, error, bool) {
+	start := time.Now()
+	v, err, next := e.runRuleImpl(event, prog)
+	prog.stats.record(time.Since(start), err != nil)
+	return v, err, next
+}
+
 func (e *Evaluator) runRule(
 	event Val,
 	prog *program,
 ) (Val, error) {
-	v, err, _ := e.runRuleImpl(event, prog)
+	v, err, _ := e.runRuleStats(event, prog)
 	return v, err
 }
 
@@ -1945,7 +2146,7 @@ func (e *Evaluator) runRuleList(
 ) (Val, error) {
 
 	for _, prog := range progList {
-		v, err, next := e.runRuleImpl(event, prog)
+		v, err, next := e.runRuleStats(event, prog)
 		if next {
 			continue
 		} else {
@@ -2052,6 +2253,27 @@ func (e *Evaluator) runSIterRest(siter *scriptIter) (int, error) {
 	}
 }
 
+// wraps native code which may call back into the VM with an intrinsic frame.
+// Native code invoked by bytecode other than the call instructions, ie the
+// iterator protocol, does not have a native frame on the stack, so the script
+// function called by it will return into the script frame directly. On error,
+// the stack is left as is and the caller's unwinding will take care of it,
+// which is the same as an error raised inside of an intrinsic call.
+func (e *Evaluator) nativeFrameCall(fn func() (Val, error)) (Val, error) {
+	pc := e.curframe.pc
+	e.push(NewValNull())
+	e.prologue(ftypeIntrinsic, 0, nil, nil)
+
+	v, err := fn()
+	if err != nil {
+		return NewValNull(), err
+	}
+
+	e.popfuncframe(e.prevfuncframe())
+	e.curframe.pc = pc
+	return v, nil
+}
+
 // used by callback function, ie re-enter into the VM while a native call calls
 // back into the VM.
 // Due to the interleaved frame limitation, we cannot propagate the exception
@@ -2144,6 +2366,10 @@ func (e *Evaluator) EvalConfig(p *Module) error {
 	if e.Config == nil {
 		return fmt.Errorf("evaluator's Config is not set")
 	}
+	e.setup = true
+	defer func() {
+		e.setup = false
+	}()
 	_, err := e.runRule(NewValNull(), p.config)
 	return err
 }
@@ -2158,6 +2384,11 @@ func (e *Evaluator) EvalGlobal(p *Module) error {
 	}
 	p.global.globalVar = nil
 
+	e.setup = true
+	defer func() {
+		e.setup = false
+	}()
+
 	for _, prog := range p.global.globalProgram {
 		if _, err := e.runRule(NewValNull(), prog); err != nil {
 			return err
@@ -2208,6 +2439,21 @@ func (e *Evaluator) EvalWithContext(event string, context Val, p *Module) (Val,
 	}
 }
 
+// EvalTest runs the test block of the name, the test fails if an error is
+// raised, ie by the assert:: functions
+func (e *Evaluator) EvalTest(name string, p *Module) error {
+	defer func() {
+		e.drainEventQueue(p)
+	}()
+
+	prog := p.getTest(name)
+	if prog == nil {
+		return fmt.Errorf("test %s is not found", name)
+	}
+	_, err := e.runRule(NewValNull(), prog)
+	return err
+}
+
 // Notes, this must be used for evaluation of event queue event since inside of
 // this function, it will NOT issue event queue call again which prevent from
 // been called recursively
@@ -0,0 +1,51 @@
+package pl
+
+import (
+	"io"
+	"sync"
+)
+
+// resources which outlive the call creating them but not the session, ie the
+// branches of a tee'd stream backed by a temporary file. They are registered
+// with AddCloser and closed by Cleanup once the session ends, the runtime
+// hosting the evaluator is responsible for calling Cleanup. The list is shared
+// with q::pmap's workers, so the ones created by a worker are closed with the
+// session of the parent evaluator
+type evalCleanup struct {
+	sync.Mutex
+	closer []io.Closer
+}
+
+func (c *evalCleanup) add(x io.Closer) {
+	c.Lock()
+	defer c.Unlock()
+	c.closer = append(c.closer, x)
+}
+
+func (c *evalCleanup) run() error {
+	c.Lock()
+	closer := c.closer
+	c.closer = nil
+	c.Unlock()
+
+	// closed in reverse order of registration, the first error is returned
+	// after all of them are closed
+	var err error
+	for i := len(closer) - 1; i >= 0; i-- {
+		if cerr := closer[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// registers the closer to be closed by Cleanup
+func (e *Evaluator) AddCloser(x io.Closer) {
+	e.cleanup.add(x)
+}
+
+// closes all the closers registered so far, should be called once the session
+// ends
+func (e *Evaluator) Cleanup() error {
+	return e.cleanup.run()
+}
@@ -0,0 +1,43 @@
+package pl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStepLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	module, err := CompileModule(`
+rule spin {
+  for {
+    try {
+      for {}
+    } else {
+      let a = 1;
+    }
+  }
+}
+`, nil)
+	assert.Nil(err)
+
+	eval := NewEvaluatorSimple()
+	eval.SetStepLimit(1000)
+	_, err = eval.Eval("spin", module)
+	assert.NotNil(err)
+	assert.True(eval.Steps() > 1000)
+
+	assert.NotNil(FuzzEval(`rule a { for {} }`))
+	assert.Nil(FuzzEval(`rule a { let b = 1; }`))
+}
+
+func FuzzEvaluator(f *testing.F) {
+	if err := setC(f); err != nil {
+		f.Errorf("err: %s", err.Error())
+	}
+
+	f.Fuzz(func(t *testing.T, data string) {
+		FuzzEval(data)
+	})
+}
@@ -0,0 +1,203 @@
+package pl
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// evaluators running in parallel, ie q::pmap's workers and the pooled template
+// function evaluators, cannot store into upvalue, session, global or dynamic
+// variable, see scriptFunc.checkShareable. That alone does not stop them from
+// mutating the containers reachable from those variables, ie m[k] = v on a
+// session map. So every list, map, pair and non thread safe user value the
+// parallel evaluator reaches from the shared state is recorded, and mutating
+// any of them is rejected. Containers created by the evaluator itself are not
+// recorded and can be mutated freely.
+
+type sharedRef struct {
+	// values shared by all the parallel evaluators, ie the input list of
+	// q::pmap, it is read only once the evaluators start
+	base map[interface{}]bool
+
+	// values this evaluator reached from the session, context, upvalue or
+	// global variable, or from a shared user value
+	local map[interface{}]bool
+}
+
+func newSharedRef(base map[interface{}]bool) *sharedRef {
+	return &sharedRef{
+		base:  base,
+		local: make(map[interface{}]bool),
+	}
+}
+
+// identity of the mutable value, the thread safe user value needs no
+// tracking and only the pointer one can be tracked
+func sharedKey(v Val) (interface{}, bool) {
+	switch v.Type {
+	case ValList:
+		return v.List(), true
+	case ValMap:
+		return v.Map(), true
+	case ValPair:
+		return v.Pair(), true
+	case ValUsr:
+		u := v.Usr()
+		if u.IsThreadSafe() || reflect.TypeOf(u).Kind() != reflect.Ptr {
+			return nil, false
+		}
+		return u, true
+	default:
+		return nil, false
+	}
+}
+
+// records the value and all the values reachable from it into the set, the
+// visited values are skipped so cyclic containers terminate
+func markShared(set map[interface{}]bool, v Val) {
+	pending := []Val{v}
+	for len(pending) != 0 {
+		x := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		key, ok := sharedKey(x)
+		if !ok || set[key] {
+			continue
+		}
+		set[key] = true
+
+		switch x.Type {
+		case ValList:
+			pending = append(pending, x.List().Data...)
+		case ValMap:
+			x.Map().Foreach(func(_ string, val Val) bool {
+				pending = append(pending, val)
+				return true
+			})
+		case ValPair:
+			pending = append(pending, x.Pair().First, x.Pair().Second)
+		default:
+			break
+		}
+	}
+}
+
+func (s *sharedRef) has(v Val) bool {
+	key, ok := sharedKey(v)
+	if !ok {
+		return false
+	}
+	return s.base[key] || s.local[key]
+}
+
+// turns the evaluator into a parallel one, base is the shared values known
+// upfront, which may be nil
+func (e *Evaluator) setShared(base map[interface{}]bool) {
+	e.shared = true
+	e.sharedRef = newSharedRef(base)
+}
+
+// called with the value loaded from the shared state
+func (e *Evaluator) markShared(v Val) {
+	if e.shared {
+		markShared(e.sharedRef.local, v)
+	}
+}
+
+func (e *Evaluator) isShared(v Val) bool {
+	return e.shared && e.sharedRef.has(v)
+}
+
+// rejects the in place modification of the shared value
+func (e *Evaluator) checkMutable(v Val) error {
+	if e.isShared(v) {
+		return fmt.Errorf("cannot modify %s shared with other evaluators in parallel evaluation", v.Id())
+	}
+	return nil
+}
+
+// the methods of list and map which modify the receiver
+var mutatingMethod = map[int]map[string]bool{
+	ValList: {
+		"push_back": true,
+		"pop_back":  true,
+		"extend":    true,
+		"sort":      true,
+		"insert":    true,
+		"remove":    true,
+		"pop":       true,
+	},
+	ValMap: {
+		"set":         true,
+		"del":         true,
+		"delete":      true,
+		"merge":       true,
+		"set_ordered": true,
+	},
+}
+
+// method of the shared value, the mutating method of list and map and any
+// method of the non thread safe user value are rejected. The returned value
+// may share storage with the receiver, ie list's slice, so it is recorded as
+// shared as well
+func (e *Evaluator) sharedMethod(recv Val, name string, method Val) (Val, error) {
+	if recv.Type == ValUsr || mutatingMethod[recv.Type][name] {
+		return NewValNull(),
+			fmt.Errorf("cannot call method %s of %s shared with other evaluators in parallel evaluation",
+				name, recv.Id())
+	}
+	fn := method.Closure()
+	return Val{
+		Type: ValClosure,
+		vData: newEvalMethodFunc(
+			func(ev *Evaluator, _ string, args []Val) (Val, error) {
+				r, err := fn.Call(ev, args)
+				if err == nil {
+					ev.markShared(r)
+				}
+				return r, err
+			},
+			name,
+		),
+	}, nil
+}
+
+// context handed to the parallel evaluators, the context of the parent
+// evaluator is not thread safe so the access is serialized
+type lockedEvalContext struct {
+	sync.Mutex
+	ctx EvalContext
+}
+
+func newLockedEvalContext(ctx EvalContext) *lockedEvalContext {
+	return &lockedEvalContext{
+		ctx: ctx,
+	}
+}
+
+func (c *lockedEvalContext) LoadVar(e *Evaluator, name string) (Val, error) {
+	c.Lock()
+	defer c.Unlock()
+	return c.ctx.LoadVar(e, name)
+}
+
+func (c *lockedEvalContext) StoreVar(e *Evaluator, name string, v Val) error {
+	c.Lock()
+	defer c.Unlock()
+	return c.ctx.StoreVar(e, name, v)
+}
+
+func (c *lockedEvalContext) Action(e *Evaluator, name string, v Val) error {
+	c.Lock()
+	defer c.Unlock()
+	return c.ctx.Action(e, name, v)
+}
+
+func (c *lockedEvalContext) GoContext() context.Context {
+	if p, ok := c.ctx.(GoContextProvider); ok {
+		return p.GoContext()
+	}
+	return nil
+}
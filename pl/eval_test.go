@@ -2,7 +2,9 @@ package pl
 
 import (
 	"fmt"
+	"os"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/stretchr/testify/assert"
@@ -2099,3 +2101,261 @@ test {
 `, "1000"))
 
 }
+
+func TestEnv(t *testing.T) {
+	assert := assert.New(t)
+	os.Setenv("MOONS_TEST_ENV", "moons")
+
+	run := func(code string, capability int) (Val, error) {
+		rr := NewValNull()
+		ret := &rr
+		eval := NewEvaluatorWithContextCallback(
+			nil,
+			nil,
+			func(_ *Evaluator, aname string, aval Val) error {
+				if aname == "output" {
+					*ret = aval
+				}
+				return nil
+			})
+		eval.SetCapability(capability)
+
+		module, err := CompileModule(code, nil)
+		if err != nil {
+			return NewValNull(), err
+		}
+		_, err = eval.Eval("test", module)
+		return *ret, err
+	}
+
+	{
+		_, err := run(`test{ output => env::get("MOONS_TEST_ENV"); }`, 0)
+		assert.True(err != nil)
+	}
+	{
+		v, err := run(`test{ output => env::get("MOONS_TEST_ENV"); }`, CapabilityEnv)
+		assert.True(err == nil)
+		assert.True(v.IsString())
+		assert.Equal(v.String(), "moons")
+	}
+	{
+		v, err := run(`test{ output => env::get("MOONS_TEST_ENV_NOT_EXISTED", "def"); }`, CapabilityEnv)
+		assert.True(err == nil)
+		assert.Equal(v.String(), "def")
+	}
+	{
+		v, err := run(`test{ output => env::hostname(); }`, CapabilityEnv)
+		assert.True(err == nil)
+		assert.True(v.IsString())
+	}
+
+	// capability is granted on top of the existing ones
+	{
+		eval := NewEvaluatorSimple()
+		eval.AddCapability(0)
+		assert.False(eval.HasCapability(CapabilityEnv))
+		eval.AddCapability(CapabilityEnv)
+		assert.True(eval.HasCapability(CapabilityEnv))
+		eval.AddCapability(0)
+		assert.True(eval.HasCapability(CapabilityEnv))
+	}
+}
+
+func TestIntrinsicPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	run := func(code string, policy *IntrinsicPolicy) (Val, error) {
+		rr := NewValNull()
+		ret := &rr
+		eval := NewEvaluatorWithContextCallback(
+			nil,
+			nil,
+			func(_ *Evaluator, aname string, aval Val) error {
+				if aname == "output" {
+					*ret = aval
+				}
+				return nil
+			})
+		eval.SetPolicy(policy)
+
+		module, err := CompileModule(code, nil)
+		if err != nil {
+			return NewValNull(), err
+		}
+		_, err = eval.Eval("test", module)
+		return *ret, err
+	}
+
+	p := NewIntrinsicPolicy(nil, []string{"str", "to_int"})
+	assert.False(p.Allowed("str::upper"))
+	assert.False(p.Allowed("to_int"))
+	assert.True(p.Allowed("to_string"))
+	assert.True(p.Allowed("strx::upper"))
+
+	p = NewIntrinsicPolicy([]string{"str", "len"}, []string{"str::lower"})
+	assert.True(p.Allowed("str::upper"))
+	assert.True(p.Allowed("len"))
+	assert.False(p.Allowed("str::lower"))
+	assert.False(p.Allowed("to_int"))
+	assert.True((*IntrinsicPolicy)(nil).Allowed("to_int"))
+
+	{
+		v, err := run(`test{ output => len("abc"); }`, p)
+		assert.True(err == nil)
+		assert.Equal(int64(3), v.Int())
+	}
+	{
+		_, err := run(`test{ output => to_int("1"); }`, p)
+		assert.True(err != nil)
+	}
+	{
+		// loading the intrinsic as value is also checked
+		_, err := run(`test{ let f = to_int; output => f("1"); }`, p)
+		assert.True(err != nil)
+	}
+	{
+		_, err := run(`test{ output => to_int("1"); }`, NewIntrinsicPolicy([]string{"*"}, nil))
+		assert.True(err == nil)
+	}
+}
+
+func TestFS(t *testing.T) {
+	assert := assert.New(t)
+	fsys := fstest.MapFS{
+		"data/a.txt":  &fstest.MapFile{Data: []byte("hello")},
+		"data/b.txt":  &fstest.MapFile{Data: []byte("world")},
+		"data/c.json": &fstest.MapFile{Data: []byte(`{"a": 1, "b": [1.5, "x"]}`)},
+	}
+
+	run := func(code string) (Val, error) {
+		rr := NewValNull()
+		ret := &rr
+		eval := NewEvaluatorWithContextCallback(
+			nil,
+			nil,
+			func(_ *Evaluator, aname string, aval Val) error {
+				if aname == "output" {
+					*ret = aval
+				}
+				return nil
+			})
+
+		module, err := CompileModule(code, fsys)
+		if err != nil {
+			return NewValNull(), err
+		}
+		_, err = eval.Eval("test", module)
+		return *ret, err
+	}
+
+	{
+		v, err := run(`test{ output => fs::read("data/a.txt"); }`)
+		assert.True(err == nil)
+		assert.Equal(v.String(), "hello")
+	}
+	{
+		v, err := run(`test{ output => fs::exists("/data/b.txt"); }`)
+		assert.True(err == nil)
+		assert.True(v.Bool())
+	}
+	{
+		v, err := run(`test{ output => fs::exists("../data/b.txt"); }`)
+		assert.True(err == nil)
+		assert.False(v.Bool())
+	}
+	{
+		_, err := run(`test{ output => fs::read("../etc/passwd"); }`)
+		assert.True(err != nil)
+	}
+	{
+		v, err := run(`test{ output => fs::glob("data/*.txt"); }`)
+		assert.True(err == nil)
+		assert.Equal(v.List().Length(), 2)
+	}
+	{
+		v, err := run(`
+fn load() {
+  return fs::read_json("data/c.json");
+}
+test{ output => load().b[0]; }`)
+		assert.True(err == nil)
+		assert.True(v.IsReal())
+		assert.Equal(v.Real(), 1.5)
+	}
+}
+
+type testEvalMethodUsr struct {
+	UVal
+}
+
+func (t *testEvalMethodUsr) EvalMethod(e *Evaluator, name string, args []Val) (Val, error) {
+	if name != "apply" {
+		return NewValNull(), fmt.Errorf("unknown method %s", name)
+	}
+	return args[0].Closure().Call(e, args[1:])
+}
+
+func TestEvalMethodAndEscapedClosure(t *testing.T) {
+	assert := assert.New(t)
+
+	var out Val
+	eval := NewEvaluatorWithContextCallback(
+		func(_ *Evaluator, _ string) (Val, error) {
+			return NewValUsr(&testEvalMethodUsr{}), nil
+		},
+		nil,
+		func(_ *Evaluator, _ string, aval Val) error {
+			out = aval
+			return nil
+		})
+
+	module, err := CompileModule(`
+test {
+  output => x:apply(fn(a) { return a + 1; }, 1);
+}
+escape {
+  output => fn(a) { return a + "!"; };
+}
+`, nil)
+	assert.Nil(err)
+
+	_, err = eval.Eval("test", module)
+	assert.Nil(err)
+	assert.Equal(int64(2), out.Int())
+
+	// closure escaped from the rule is callable once the evaluation is done
+	_, err = eval.Eval("escape", module)
+	assert.Nil(err)
+	v, err := out.Closure().Call(eval, []Val{NewValStr("a")})
+	assert.Nil(err)
+	assert.Equal("a!", v.String())
+}
+
+type testEvalCloser struct {
+	name   string
+	closed *[]string
+}
+
+func (c *testEvalCloser) Close() error {
+	*c.closed = append(*c.closed, c.name)
+	return nil
+}
+
+func TestEvalCleanup(t *testing.T) {
+	assert := assert.New(t)
+
+	var closed []string
+	eval := NewEvaluatorSimple()
+	eval.AddCloser(&testEvalCloser{"a", &closed})
+
+	// the worker of q::pmap registers to its parent
+	w := eval.newWorker(eval.Context, nil)
+	w.AddCloser(&testEvalCloser{"b", &closed})
+
+	assert.Nil(eval.Cleanup())
+	assert.Equal([]string{"b", "a"}, closed)
+
+	// closed only once
+	assert.Nil(eval.Cleanup())
+	assert.Equal([]string{"b", "a"}, closed)
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
 )
 
 // this is a simple prototype checking mechanism to simplify the go side
@@ -54,6 +56,20 @@ import (
 // means the function accepts at least 2 arguments, one is integer, and the rest
 // are all maps
 
+// each argument, either a single descriptor or a group, can be followed by the
+// following decorators in order
+//
+// :name   -> human readable name of the argument, used in error message and
+//            the signature of the function
+// =value  -> default value, a number, a quoted string, true, false or null.
+//            argument with default value is optional
+// ?       -> the argument is optional
+//
+// optional arguments must be trailing arguments and cannot be mixed with the
+// variable length argument, the missing optional arguments can be filled with
+// their default value by CheckDefault.
+// example: %s:str%d:width=10(%s|%n):pad? accepts 1 to 3 arguments
+
 const (
 	PInt = iota
 	// for reflection usage
@@ -196,13 +212,74 @@ type protoelem struct {
 }
 
 type argp struct {
-	or []protoelem
+	or       []protoelem
+	name     string
+	optional bool
+	def      *Val
 }
 
 type argpcase struct {
 	d      []argp
 	noarg  bool
 	varlen bool
+
+	// # of leading arguments which are not optional
+	required int
+}
+
+// whether the case accepts alen arguments
+func (c *argpcase) arity(alen int) bool {
+	sz := len(c.d)
+	return sz == alen || (sz < alen && c.varlen) || (c.required <= alen && alen < sz)
+}
+
+// fill missing trailing arguments with the default value, stops at the first
+// missing argument which has no default value
+func (c *argpcase) fill(args []Val) []Val {
+	if len(args) >= len(c.d) || c.d[len(args)].def == nil {
+		return args
+	}
+	out := append([]Val{}, args...)
+	for i := len(args); i < len(c.d) && c.d[i].def != nil; i++ {
+		out = append(out, *c.d[i].def)
+	}
+	return out
+}
+
+func (c *argpcase) signature(name string) string {
+	b := new(bytes.Buffer)
+	b.WriteString(name)
+	b.WriteRune('(')
+	for idx, a := range c.d {
+		if idx != 0 {
+			b.WriteString(", ")
+		}
+		if a.optional && a.def == nil {
+			b.WriteRune('[')
+		}
+		if a.name != "" {
+			b.WriteString(a.name)
+			b.WriteString(": ")
+		}
+		b.WriteString(a.str())
+		if c.varlen && idx == len(c.d)-1 {
+			b.WriteString("...")
+		}
+		if a.def != nil {
+			b.WriteString(" = ")
+			if a.def.IsString() {
+				b.WriteString(strconv.Quote(a.def.String()))
+			} else {
+				str, _ := a.def.ToString()
+				b.WriteString(str)
+			}
+		}
+		if a.optional && a.def == nil {
+			b.WriteRune(']')
+		}
+	}
+	b.WriteRune(')')
+	return b.String()
 }
 
 type FuncProto struct {
@@ -319,6 +396,25 @@ func (p *argpcase) str() string {
 	return b.String()
 }
 
+// human readable signature of the function, one line per overload
+func (p *FuncProto) Signature() string {
+	if p.alwayspass {
+		return p.Name + "(...)"
+	}
+	if p.noarg {
+		return p.Name + "()"
+	}
+	var x []string
+	for _, c := range p.d {
+		if c.noarg {
+			x = append(x, p.Name+"()")
+		} else {
+			x = append(x, c.signature(p.Name))
+		}
+	}
+	return strings.Join(x, "\n")
+}
+
 func (p *FuncProto) Dump() string {
 	b := new(bytes.Buffer)
 	b.WriteString(fmt.Sprintf("descriptor> %s\n", p.Descriptor))
@@ -559,6 +655,120 @@ func (f *FuncProto) compT(rList []rune, cursor int, vlen *bool) (int, protoelem,
 	}, nil
 }
 
+func isProtoNameChar(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// default value literal, number, quoted string, true, false or null
+func (f *FuncProto) scanDefault(rList []rune, start int) (int, Val, error) {
+	sz := len(rList)
+	if start == sz {
+		return -1, NewValNull(), fmt.Errorf("expect default value after =")
+	}
+
+	c := rList[start]
+	switch {
+	case c == '\'' || c == '"':
+		cursor, str, err := f.scanTName(rList, start)
+		if err != nil {
+			return -1, NewValNull(), err
+		}
+		return cursor, NewValStr(str), nil
+
+	case c == '-' || c == '+' || (c >= '0' && c <= '9'):
+		cursor := start + 1
+		for cursor < sz && strings.ContainsRune("0123456789.eE+-", rList[cursor]) {
+			cursor++
+		}
+		lit := string(rList[start:cursor])
+		if i, err := strconv.ParseInt(lit, 10, 64); err == nil {
+			return cursor, NewValInt64(i), nil
+		}
+		if r, err := strconv.ParseFloat(lit, 64); err == nil {
+			return cursor, NewValReal(r), nil
+		}
+		return -1, NewValNull(), fmt.Errorf("invalid number %s as default value", lit)
+
+	default:
+		cursor := start
+		for cursor < sz && isProtoNameChar(rList[cursor]) {
+			cursor++
+		}
+		switch lit := string(rList[start:cursor]); lit {
+		case "true":
+			return cursor, NewValBool(true), nil
+		case "false":
+			return cursor, NewValBool(false), nil
+		case "null":
+			return cursor, NewValNull(), nil
+		default:
+			return -1, NewValNull(), fmt.Errorf("invalid default value %s", lit)
+		}
+	}
+}
+
+// decorators following the argument, ie :name, =default, ? and *
+func (f *FuncProto) compDecorator(rList []rune, cursor int, a *argp, vlen *bool) (int, error) {
+	sz := len(rList)
+
+	if cursor < sz && rList[cursor] == ':' {
+		cursor++
+		start := cursor
+		for cursor < sz && isProtoNameChar(rList[cursor]) {
+			cursor++
+		}
+		if start == cursor {
+			return -1, fmt.Errorf("expect argument name after :")
+		}
+		a.name = string(rList[start:cursor])
+	}
+
+	if cursor < sz && rList[cursor] == '=' {
+		cc, v, err := f.scanDefault(rList, cursor+1)
+		if err != nil {
+			return -1, err
+		}
+		cursor = cc
+
+		ok := false
+		for _, e := range a.or {
+			if f.check0(e, v, nil) {
+				ok = true
+				break
+			}
+			// integer literal is allowed for real argument
+			if v.IsInt() && f.check0(e, NewValReal(float64(v.Int())), nil) {
+				v = NewValReal(float64(v.Int()))
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return -1, fmt.Errorf("default value %s does not match argument type %s", v.Info(), a.str())
+		}
+		a.def = &v
+		a.optional = true
+	}
+
+	if cursor < sz && rList[cursor] == '?' {
+		cursor++
+		a.optional = true
+	}
+
+	if cursor < sz && rList[cursor] == '*' {
+		if a.optional {
+			return -1, fmt.Errorf("optional argument cannot have variable length")
+		}
+		*vlen = true
+		cursor++
+		if cursor < sz && rList[cursor] != '}' {
+			return -1, fmt.Errorf("nothing should be expeceted after *")
+		}
+	}
+
+	return cursor, nil
+}
+
 func (f *FuncProto) compCase(d string) (*argpcase, int, error) {
 	cursor := 0
 	rList := []rune(d)
@@ -597,9 +807,15 @@ LOOP:
 				argpc.noarg = true
 				// notes we do not push PNone into the type list
 			} else {
-				argpc.d = append(argpc.d, argp{
+				a := argp{
 					or: []protoelem{pelem},
-				})
+				}
+				cc, err := f.compDecorator(rList, cursor, &a, &argpc.varlen)
+				if err != nil {
+					return nil, -1, err
+				}
+				cursor = cc
+				argpc.d = append(argpc.d, a)
 			}
 
 			break
@@ -648,15 +864,41 @@ LOOP:
 				}
 			}
 
-			argpc.d = append(argpc.d, argp{
+			a := argp{
 				or: orlist,
-			})
+			}
+			var vlen bool
+			cc, err := f.compDecorator(rList, cursor, &a, &vlen)
+			if err != nil {
+				return nil, -1, err
+			}
+			if vlen {
+				return nil, -1, fmt.Errorf("or list cannot have variable length")
+			}
+			cursor = cc
+			argpc.d = append(argpc.d, a)
 			break
 
 		default:
 			return nil, -1, fmt.Errorf("unknown token %c, expect %% or (", c)
 		}
 	}
+
+	// optional arguments must be trailing
+	argpc.required = len(argpc.d)
+	for idx, a := range argpc.d {
+		if a.optional {
+			if argpc.required == len(argpc.d) {
+				argpc.required = idx
+			}
+		} else if argpc.required != len(argpc.d) {
+			return nil, -1, fmt.Errorf("argument %d cannot follow optional argument", idx+1)
+		}
+	}
+	if argpc.varlen && argpc.required != len(argpc.d) {
+		return nil, -1, fmt.Errorf("optional argument cannot be used with variable length argument")
+	}
+
 	return argpc, cursor, nil
 }
 
@@ -788,7 +1030,7 @@ func (f *FuncProto) check0(exp protoelem, got Val, c convoneval) (the_return boo
 	default:
 		if exp.opcode == PUsr {
 			if exp.tname != "" {
-				return exp.tname == got.Id()
+				return ValIsType(got, exp.tname)
 			} else {
 				return true
 			}
@@ -805,9 +1047,13 @@ func (f *FuncProto) check1(index int, exp *argp, got Val, conv convoneval) error
 		}
 	}
 
-	return fmt.Errorf("%d'th argument's type is invalided, "+
+	name := ""
+	if exp.name != "" {
+		name = fmt.Sprintf("(%s)", exp.name)
+	}
+	return fmt.Errorf("function(method) call: %s's %d'th argument%s's type is invalid, "+
 		"type input is %s but what we expect is %s",
-		index+1, got.Info(), exp.str())
+		f.Name, index+1, name, got.Info(), exp.str())
 }
 
 func (f *FuncProto) doCheck(d *argpcase, args []Val, conv convsliceval) (int, error) {
@@ -828,13 +1074,18 @@ func (f *FuncProto) doCheck(d *argpcase, args []Val, conv convsliceval) (int, er
 			// %s%a* allows at least 1 argument to represent the first string, and
 			// the second %a is an optional, ie we can have zero optional arguments
 			// example like format function
+		} else if alen >= d.required {
+			// trailing optional arguments are omitted
 		} else {
-			prefix := ""
+			prefix := " "
 			if d.varlen {
 				prefix = " at least "
+			} else if d.required != elen {
+				prefix = fmt.Sprintf(" %d to ", d.required)
+				elen = d.required
 			}
 			return alen, fmt.Errorf("function(method) call: %s expects%s%d arguments, "+
-				"but got %d arguments", f.Name, prefix, elen, alen)
+				"but got %d arguments, signature: %s", f.Name, prefix, elen, alen, d.signature(f.Name))
 		}
 	}
 
@@ -880,7 +1131,7 @@ func (f *FuncProto) doCheck(d *argpcase, args []Val, conv convsliceval) (int, er
 	if elen < alen {
 		if !d.varlen {
 			return 0, fmt.Errorf("function(method) call: %s expects %d argument, "+
-				"but got %d", f.Name, elen, alen)
+				"but got %d, signature: %s", f.Name, elen, alen, d.signature(f.Name))
 		}
 		danglingE := &d.d[elen-1]
 
@@ -930,26 +1181,51 @@ func (f *FuncProto) Check(args []Val) (int, error) {
 					"but got %d arguments", f.Name, alen)
 		}
 	}
-	var err error
-	found := false
+	_, err := f.match(args)
+	return alen, err
+}
+
+// find the case matches the arguments, when the function has no overload the
+// error of the only case is returned directly since it is more precise
+func (f *FuncProto) match(args []Val) (*argpcase, error) {
+	alen := len(args)
+
+	if len(f.d) == 1 {
+		c := f.d[0]
+		if _, err := f.doCheck(c, args, nil); err != nil {
+			return nil, err
+		}
+		if !c.arity(alen) {
+			return nil, fmt.Errorf("function(method) call: %s expects at least %d arguments, "+
+				"but got %d arguments, signature: %s", f.Name, len(c.d), alen, c.signature(f.Name))
+		}
+		return c, nil
+	}
 
 	for _, c := range f.d {
-		sz := len(c.d)
-		if sz == alen || (sz < alen && c.varlen) {
-			_, err = f.doCheck(c, args, nil)
-			if err == nil {
-				found = true
-				break
+		if c.arity(alen) {
+			if _, err := f.doCheck(c, args, nil); err == nil {
+				return c, nil
 			}
 		}
 	}
 
-	if found {
-		return alen, err
-	} else {
-		return alen, fmt.Errorf("function(method) call: %s invalid arguments, "+
-			"no matching argument type can be found", f.Name)
+	return nil, fmt.Errorf("function(method) call: %s invalid arguments, "+
+		"no matching argument type can be found, signature:\n%s", f.Name, f.Signature())
+}
+
+// check the arguments and fill the omitted trailing optional arguments with
+// their default value
+func (f *FuncProto) CheckDefault(args []Val) ([]Val, error) {
+	if f.alwayspass || f.noarg {
+		_, err := f.Check(args)
+		return args, err
 	}
+	c, err := f.match(args)
+	if err != nil {
+		return args, err
+	}
+	return c.fill(args), nil
 }
 
 // return nil when we cannot convert Val into reflect.Value (ie not supported)
@@ -1071,11 +1347,18 @@ func (f *FuncProto) Pack(args []Val) ([]reflect.Value, error) {
 	found := false
 
 	for _, c := range f.d {
-		sz := len(c.d)
-		if sz == alen || (sz < alen && c.varlen) {
+		if c.arity(alen) {
 			var oput []reflect.Value
 
-			if _, err = f.doCheck(c, args, func(o *reflect.Value) {
+			// reflection call requires all the arguments
+			fargs := c.fill(args)
+			if len(fargs) < len(c.d) && !c.varlen {
+				err = fmt.Errorf("function(method) call: %s, optional argument without default "+
+					"value cannot be omitted", f.Name)
+				continue
+			}
+
+			if _, err = f.doCheck(c, fargs, func(o *reflect.Value) {
 				oput = append(oput, *o)
 			}); err == nil {
 				found = true
@@ -2,6 +2,7 @@ package pl
 
 import (
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
 )
 
@@ -433,3 +434,73 @@ func TestCheck0(t *testing.T) {
 		assert.True(alen == 1)
 	}
 }
+
+func TestProtoOptional(t *testing.T) {
+	assert := assert.New(t)
+
+	a, err := NewFuncProto("pad", "%s:str%d:width=10(%s|%n):fill?")
+	assert.True(err == nil)
+	assert.Equal("pad(str: string, width: int = 10, [fill: string|null])", a.Signature())
+
+	for _, args := range [][]Val{
+		{NewValStr("a")},
+		{NewValStr("a"), NewValInt(1)},
+		{NewValStr("a"), NewValInt(1), NewValNull()},
+	} {
+		alen, err := a.Check(args)
+		assert.True(err == nil)
+		assert.Equal(len(args), alen)
+	}
+
+	_, err = a.Check(nil)
+	assert.True(err != nil)
+	_, err = a.Check([]Val{NewValStr("a"), NewValInt(1), NewValNull(), NewValNull()})
+	assert.True(err != nil)
+	_, err = a.Check([]Val{NewValStr("a"), NewValStr("b")})
+	assert.True(err != nil)
+	assert.True(strings.Contains(err.Error(), "(width)"))
+
+	// default filling stops at the optional argument without default
+	args, err := a.CheckDefault([]Val{NewValStr("a")})
+	assert.True(err == nil)
+	assert.Equal(2, len(args))
+	assert.Equal(int64(10), args[1].Int())
+
+	// default value literals
+	b, err := NewFuncProto("b", "%f:x=1%s:y='a b'%b:z=false(%l|%n):w=null")
+	assert.True(err == nil)
+	args, err = b.CheckDefault(nil)
+	assert.True(err == nil)
+	assert.Equal(4, len(args))
+	assert.Equal(1.0, args[0].Real())
+	assert.Equal("a b", args[1].String())
+	assert.False(args[2].Bool())
+	assert.True(args[3].IsNull())
+
+	// name with variable length
+	c, err := NewFuncProto("c", "%s:fmt%a:args*")
+	assert.True(err == nil)
+	assert.Equal("c(fmt: string, args: any...)", c.Signature())
+
+	// overload signature
+	d, err := NewFuncProto("d", "{%d:x}{%s:y%s:z}")
+	assert.True(err == nil)
+	assert.Equal("d(x: int)\nd(y: string, z: string)", d.Signature())
+	_, err = d.Check([]Val{NewValBool(true)})
+	assert.True(err != nil)
+	assert.True(strings.Contains(err.Error(), "d(y: string, z: string)"))
+
+	for _, x := range []string{
+		"%d?%s",
+		"%d?%a*",
+		"%d=%s",
+		"%d='a'",
+		"%d=yes",
+		"%d:",
+		"%d?*",
+		"(%d|%s):x*",
+	} {
+		_, err := NewFuncProto("e", x)
+		assert.True(err != nil, x)
+	}
+}
@@ -0,0 +1,88 @@
+package pl
+
+import (
+	"fmt"
+)
+
+// Harnesses of the fuzzing, ie go test -fuzz, of the parser and the evaluator.
+// The error of a malformed input is expected and returned, only a panic or a
+// hang is a bug. The input is bounded in size and the evaluation in bytecodes
+// executed, so the fuzzer does not get stuck in the infinite loop of the input
+
+const (
+	// inputs larger than this are ignored, the deeply nested one could exhaust
+	// the go stack of the recursive descent parser otherwise
+	FuzzMaxInput = 64 * 1024
+
+	// bytecodes executed by each rule evaluated
+	FuzzStepLimit = 100000
+)
+
+var errFuzzInput = fmt.Errorf("fuzz: input is too large")
+
+// the config of the fuzzing accepts every call
+type fuzzConfig struct{}
+
+func (fuzzConfig) PushConfig(_ *Evaluator, _ string, _ Val) error {
+	return nil
+}
+
+func (fuzzConfig) PopConfig(_ *Evaluator) error {
+	return nil
+}
+
+func (fuzzConfig) ConfigProperty(_ *Evaluator, _ string, _ Val, _ Val) error {
+	return nil
+}
+
+func (fuzzConfig) ConfigCommand(_ *Evaluator, _ string, _ []Val, _ Val) error {
+	return nil
+}
+
+// FuzzParse compiles the input
+func FuzzParse(input string) error {
+	if len(input) > FuzzMaxInput {
+		return errFuzzInput
+	}
+	_, err := CompileModule(input, nil)
+	return err
+}
+
+// FuzzEval compiles the input and evaluates its config, global and session
+// scopes, then every rule and test. The intrinsic functions reaching out to
+// the host, ie fs and env, are denied
+func FuzzEval(input string) error {
+	if len(input) > FuzzMaxInput {
+		return errFuzzInput
+	}
+	module, err := CompileModule(input, nil)
+	if err != nil {
+		return err
+	}
+
+	e := NewEvaluator(NewNullEvalContext(), fuzzConfig{})
+	e.SetPolicy(NewIntrinsicPolicy(nil, []string{"fs", "env"}))
+
+	// each step bounded by its own budget, the first error is returned
+	var first error
+	run := func(f func() error) {
+		e.SetStepLimit(e.Steps() + FuzzStepLimit)
+		if err := f(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	run(func() error { return e.EvalConfig(module) })
+	run(func() error { return e.EvalGlobal(module) })
+	run(func() error { return e.EvalSession(module) })
+	for _, rule := range module.Rules() {
+		run(func() error {
+			_, err := e.Eval(rule, module)
+			return err
+		})
+	}
+	for _, test := range module.Tests() {
+		run(func() error { return e.EvalTest(test, module) })
+	}
+	return first
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
+	"strings"
 )
 
 type AnyFunc interface{}
@@ -12,6 +13,7 @@ type IntrinsicInfo struct {
 	cname    string
 	pname    string
 	argproto *FuncProto
+	doc      string
 
 	// must be prototype of EvalCall, this is the entry of the vm interpreter
 	entry func(*Evaluator, string, []Val) (Val, error)
@@ -30,10 +32,20 @@ func (i *IntrinsicInfo) toVal(e *Evaluator) Val {
 	)
 }
 
+func (i *IntrinsicInfo) Doc() string {
+	return i.doc
+}
+
 func (i *IntrinsicInfo) Check(a []Val) (int, error) {
 	return i.argproto.Check(a)
 }
 
+// check the arguments and fill the omitted optional arguments with their
+// default value, see FuncProto.CheckDefault
+func (i *IntrinsicInfo) CheckDefault(a []Val) ([]Val, error) {
+	return i.argproto.CheckDefault(a)
+}
+
 var intrinsicIndex = make(map[string]*IntrinsicInfo)
 var intrinsicFunc []*IntrinsicInfo
 
@@ -190,11 +202,13 @@ func newiinfoEntry(
 	cname string,
 	pname string,
 	arg string,
-	entry IntrinsicCall) (*IntrinsicInfo, error) {
+	entry IntrinsicCall,
+	doc string) (*IntrinsicInfo, error) {
 
 	x := &IntrinsicInfo{
 		cname: cname,
 		pname: pname,
+		doc:   doc,
 	}
 
 	proto, err := NewFuncProto(cname, arg)
@@ -212,11 +226,13 @@ func newiinfoReflect(
 	cname string,
 	pname string,
 	arg string,
-	f AnyFunc) (*IntrinsicInfo, error) {
+	f AnyFunc,
+	doc string) (*IntrinsicInfo, error) {
 
 	x := &IntrinsicInfo{
 		cname: cname,
 		pname: pname,
+		doc:   doc,
 	}
 
 	proto, err := NewFuncProto(cname, arg)
@@ -237,12 +253,15 @@ func newiinfoReflect(
 	return x, nil
 }
 
-func addF(cn string, pn string, p string, entry IntrinsicCall) {
+// the optional doc is the description of the function listed by
+// ListIntrinsics, multiple doc strings are joined as lines
+func addF(cn string, pn string, p string, entry IntrinsicCall, doc ...string) {
 	x, err := newiinfoEntry(
 		cn,
 		pn,
 		p,
 		entry,
+		strings.Join(doc, "\n"),
 	)
 
 	musterr(cn, err)
@@ -250,13 +269,14 @@ func addF(cn string, pn string, p string, entry IntrinsicCall) {
 	intrinsicFunc = append(intrinsicFunc, x)
 }
 
-func addMF(m string, f string, pn string, p string, entry IntrinsicCall) {
+func addMF(m string, f string, pn string, p string, entry IntrinsicCall, doc ...string) {
 	mname := modFuncName(m, f)
 	x, err := newiinfoEntry(
 		mname,
 		pn,
 		p,
 		entry,
+		strings.Join(doc, "\n"),
 	)
 
 	musterr(mname, err)
@@ -269,12 +289,14 @@ func addrefF(
 	pn string,
 	p string,
 	f AnyFunc,
+	doc ...string,
 ) {
 	x, err := newiinfoReflect(
 		cn,
 		pn,
 		p,
 		f,
+		strings.Join(doc, "\n"),
 	)
 	musterr(cn, err)
 	addiindex(x)
@@ -287,6 +309,7 @@ func addrefMF(
 	pn string,
 	p string,
 	f AnyFunc,
+	doc ...string,
 ) {
 	mname := modFuncName(m, fn)
 	x, err := newiinfoReflect(
@@ -294,6 +317,7 @@ func addrefMF(
 		pn,
 		p,
 		f,
+		strings.Join(doc, "\n"),
 	)
 
 	musterr(mname, err)
@@ -312,20 +336,20 @@ func getIntrinsicByName(
 	}
 }
 
-func AddFunction(a0, a1, a2 string, entry IntrinsicCall) {
-	addF(a0, a1, a2, entry)
+func AddFunction(a0, a1, a2 string, entry IntrinsicCall, doc ...string) {
+	addF(a0, a1, a2, entry, doc...)
 }
 
-func AddModFunction(a0, a1, a2, a3 string, entry IntrinsicCall) {
-	addMF(a0, a1, a2, a3, entry)
+func AddModFunction(a0, a1, a2, a3 string, entry IntrinsicCall, doc ...string) {
+	addMF(a0, a1, a2, a3, entry, doc...)
 }
 
-func AddReflectionFunction(a0, a1, a2 string, f AnyFunc) {
-	addrefF(a0, a1, a2, f)
+func AddReflectionFunction(a0, a1, a2 string, f AnyFunc, doc ...string) {
+	addrefF(a0, a1, a2, f, doc...)
 }
 
-func AddModReflectionFunction(a0, a1, a2, a3 string, f AnyFunc) {
-	addrefMF(a0, a1, a2, a3, f)
+func AddModReflectionFunction(a0, a1, a2, a3 string, f AnyFunc, doc ...string) {
+	addrefMF(a0, a1, a2, a3, f, doc...)
 }
 
 // used by the compiler to generate ICall instructions
@@ -0,0 +1,80 @@
+package pl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// introspection of the callable functions, ie to print the builtin functions
+// from command line or to generate documentation page
+
+type FunctionDoc struct {
+	// module of the function, empty for global function and script function
+	Module string `pl:"module"`
+	Name   string `pl:"name"`
+
+	// descriptor of the function prototype, see func_proto.go, empty for
+	// function without prototype checking and script function
+	Proto     string `pl:"proto"`
+	Signature string `pl:"signature"`
+	Comment   string `pl:"comment"`
+
+	// whether the function is defined by script
+	Script bool `pl:"script"`
+}
+
+func splitFuncName(cname string) (string, string) {
+	if idx := strings.LastIndex(cname, "::"); idx != -1 {
+		return cname[:idx], cname[idx+2:]
+	}
+	return "", cname
+}
+
+// all the registered intrinsic functions, sorted by module and name
+func ListIntrinsics() []FunctionDoc {
+	o := make([]FunctionDoc, 0, len(intrinsicFunc))
+	for _, x := range intrinsicFunc {
+		m, n := splitFuncName(x.cname)
+		d := FunctionDoc{
+			Module:  m,
+			Name:    n,
+			Comment: x.doc,
+		}
+		if x.argproto != nil {
+			d.Proto = x.argproto.Descriptor
+			d.Signature = x.argproto.Signature()
+		}
+		o = append(o, d)
+	}
+	sortFunctionDoc(o)
+	return o
+}
+
+// all the named script functions defined inside of the module, the comment is
+// the line comments right before the fn keyword
+func (p *Module) ListFunctions() []FunctionDoc {
+	o := []FunctionDoc{}
+	for _, x := range p.fn {
+		if x.progtype != progFunc || strings.HasPrefix(x.name, "[anonymous_function_") {
+			continue
+		}
+		o = append(o, FunctionDoc{
+			Name:      x.name,
+			Signature: fmt.Sprintf("%s(%s)", x.name, strings.Join(x.argName, ", ")),
+			Comment:   x.doc,
+			Script:    true,
+		})
+	}
+	sortFunctionDoc(o)
+	return o
+}
+
+func sortFunctionDoc(o []FunctionDoc) {
+	sort.Slice(o, func(i, j int) bool {
+		if o[i].Module != o[j].Module {
+			return o[i].Module < o[j].Module
+		}
+		return o[i].Name < o[j].Name
+	})
+}
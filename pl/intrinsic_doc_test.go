@@ -0,0 +1,61 @@
+package pl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListFunctions(t *testing.T) {
+	assert := assert.New(t)
+
+	var add *FunctionDoc
+	for _, x := range ListIntrinsics() {
+		if x.Module == "bindtest" && x.Name == "add" {
+			xx := x
+			add = &xx
+		}
+	}
+	assert.NotNil(add)
+	assert.Equal("add two integers", add.Comment)
+	assert.Equal("%a*", add.Proto)
+	assert.False(add.Script)
+
+	// the builtin functions carry the doc passed to the registration helpers
+	comment := map[string]string{}
+	for _, x := range ListIntrinsics() {
+		if x.Module == "" {
+			comment[x.Name] = x.Comment
+		} else {
+			comment[modFuncName(x.Module, x.Name)] = x.Comment
+		}
+	}
+	assert.Equal("str::has_prefix(s, prefix), whether s begins with prefix", comment["str::has_prefix"])
+	assert.Equal("q::first(list), the first element, or the first of the pair", comment["q::first"])
+	assert.Equal("math::sqrt(x), the square root of x", comment["math::sqrt"])
+	assert.Equal("time::unix(), the current unix time in second", comment["time::unix"])
+	assert.Equal("compress::gzip(data, [level]), compresses the data by gzip", comment["compress::gzip"])
+	assert.Equal("len(v), the length of the string, list, map or pair", comment["len"])
+
+	module, err := CompileModule(`
+// add two numbers
+// and return the sum
+fn add(a, b) {
+  return a + b;
+}
+
+fn noop() {
+  let x = fn(y) { return y; };
+}
+`, nil)
+	assert.Nil(err)
+
+	fns := module.ListFunctions()
+	assert.Equal(2, len(fns))
+	assert.Equal("add", fns[0].Name)
+	assert.Equal("add(a, b)", fns[0].Signature)
+	assert.Equal("add two numbers\nand return the sum", fns[0].Comment)
+	assert.True(fns[0].Script)
+	assert.Equal("noop()", fns[1].Signature)
+	assert.Equal("", fns[1].Comment)
+}
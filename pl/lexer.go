@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
 	"unicode"
 )
 
@@ -196,6 +197,10 @@ type lexer struct {
 	// option
 	allowDRBra bool
 
+	// line comments right before the current token, used as doc comment of
+	// function
+	comment string
+
 	// internal shit
 	cursorStart int
 }
@@ -487,6 +492,16 @@ func (t *lexer) paddingSize(where int) int {
 	return where
 }
 
+func clampPos(x, lo, hi int) int {
+	if x < lo {
+		return lo
+	}
+	if x > hi {
+		return hi
+	}
+	return x
+}
+
 func (t *lexer) position() string {
 	line, col := t.pos()
 
@@ -507,12 +522,18 @@ func (t *lexer) position() string {
 
 	lb := t.nextLineBreak(t1)
 
-	if end < lb {
-		end = lb
-	}
+	// the malformed input may leave the cursors anywhere, keep the pieces within
+	// the input
+	n := len(t.input)
+	start = clampPos(start, 0, n)
+	lb = clampPos(lb, start, n)
+	end = clampPos(end, lb, n)
 
 	prefix := string(t.input[start:lb])
-	after := string(t.input[lb+1 : end])
+	after := ""
+	if lb < end {
+		after = string(t.input[lb+1 : end])
+	}
 
 	p0 := fmt.Sprintf(
 		"around line %d and column %d, near source code:\n%s",
@@ -872,6 +893,15 @@ func (t *lexer) tryQualifyId(keyword int) int {
 	}
 }
 
+// peeks the 2 tokens after the current one, the lexer is restored afterwards
+func (t *lexer) peek2() (int, int) {
+	saved := *t
+	ntk1 := t.next()
+	ntk2 := t.next()
+	*t = saved
+	return ntk1, ntk2
+}
+
 func (t *lexer) scanIdOrKeywordOrPrefixString(c rune) int {
 	if tk, ok := t.tryPrefixString(c); ok {
 		return tk
@@ -919,6 +949,15 @@ func (t *lexer) scanIdOrKeywordOrPrefixString(c rune) int {
 	return tkId
 }
 
+func (t *lexer) addComment(x string) {
+	x = strings.TrimSpace(x)
+	if t.comment == "" {
+		t.comment = x
+	} else {
+		t.comment = t.comment + "\n" + x
+	}
+}
+
 func (t *lexer) scanComment() {
 	for ; t.cursor < len(t.input); t.cursor++ {
 		c := t.input[t.cursor]
@@ -1074,6 +1113,7 @@ func (t *lexer) next() int {
 	defer func() {
 		t.saveDCursor(*pDCursor)
 	}()
+	t.comment = ""
 
 	for t.cursor < len(t.input) {
 		c := t.input[t.cursor]
@@ -1116,7 +1156,9 @@ func (t *lexer) next() int {
 				switch nc {
 				case '/':
 					t.cursor += 2
+					start := t.cursor
 					t.scanComment()
+					t.addComment(string(t.input[start:t.cursor]))
 					startDCursor = t.cursor
 					continue
 
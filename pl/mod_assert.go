@@ -2,6 +2,7 @@ package pl
 
 import (
 	"fmt"
+	"strings"
 )
 
 func assertVeq(lhs Val, rhs Val) (bool, string) {
@@ -118,6 +119,7 @@ func init() {
 				return NewValNull(), nil
 			}
 		},
+		"assert::yes(v, [msg]), fails unless v is true",
 	)
 
 	addMF(
@@ -142,6 +144,7 @@ func init() {
 				return NewValNull(), nil
 			}
 		},
+		"assert::no(v, [msg]), fails unless v is false",
 	)
 
 	addMF(
@@ -168,6 +171,7 @@ func init() {
 				return NewValNull(), nil
 			}
 		},
+		"assert::eq(a, b, [msg]), fails unless a equals b",
 	)
 
 	addMF(
@@ -194,6 +198,7 @@ func init() {
 				return NewValNull(), nil
 			}
 		},
+		"assert::ne(a, b, [msg]), fails if a equals b",
 	)
 
 	throwFunc := func(
@@ -233,6 +238,7 @@ func init() {
 				return NewValNull(), nil
 			}
 		},
+		"assert::pass(closure, [msg]), fails if the closure raises an error",
 	)
 
 	addMF(
@@ -250,5 +256,36 @@ func init() {
 				return NewValNull(), nil
 			}
 		},
+		"assert::throw(closure, [msg]), fails unless the closure raises an error",
+	)
+
+	// unlike assert::throw, the optional second argument is the text the error
+	// must contain instead of the message
+	addMF(
+		"assert",
+		"throws",
+		"",
+		"{%c}{%c%s}",
+		func(info *IntrinsicInfo, e *Evaluator, _ string, args []Val) (Val, error) {
+			alog, err := info.Check(args)
+			if err != nil {
+				return NewValNull(), err
+			}
+			_, err = args[0].Closure().Call(
+				e,
+				nil,
+			)
+			if err == nil {
+				return NewValNull(), fmt.Errorf("assert::throws failed]: no error is raised")
+			}
+			if alog == 2 && !strings.Contains(err.Error(), args[1].String()) {
+				return NewValNull(), fmt.Errorf("assert::throws failed]: error does not contain %s; error: %s",
+					args[1].String(),
+					err.Error(),
+				)
+			}
+			return NewValNull(), nil
+		},
+		"assert::throws(closure, [text]), fails unless the closure raises an error containing the text",
 	)
 }
@@ -23,6 +23,7 @@ func init() {
 			fmt.Println(buf.String())
 			return NewValNull(), nil
 		},
+		"dprint(...), prints the go type and value of the arguments, for debugging",
 	)
 
 	printFmt := func(args []Val) string {
@@ -45,6 +46,7 @@ func init() {
 			fmt.Print(printFmt(args))
 			return NewValNull(), nil
 		},
+		"print(...), prints the arguments to stdout",
 	)
 
 	addF(
@@ -55,6 +57,7 @@ func init() {
 			fmt.Println(printFmt(args))
 			return NewValNull(), nil
 		},
+		"println(...), prints the arguments to stdout followed by a newline",
 	)
 
 	addF(
@@ -74,6 +77,7 @@ func init() {
 			end := time.Now().UnixNano()
 			return NewValInt64(end - start), nil
 		},
+		"benchmark(closure), runs the closure and returns the elapsed time in nanosecond",
 	)
 
 	addF(
@@ -91,6 +95,7 @@ func init() {
 			}
 			return NewValStr(s), nil
 		},
+		"to_string(v), converts the value to string",
 	)
 
 	addF(
@@ -117,6 +122,7 @@ func init() {
 				}
 			}
 		},
+		"to_int(v, [default]), converts the value to int, the default is returned if the string cannot be parsed",
 	)
 
 	addF(
@@ -143,6 +149,7 @@ func init() {
 				}
 			}
 		},
+		"to_real(v, [default]), converts the value to real, the default is returned if the string cannot be parsed",
 	)
 
 	addF(
@@ -156,6 +163,7 @@ func init() {
 			}
 			return NewValBool(args[0].ToBoolean()), nil
 		},
+		"to_bool(v), the truthiness of the value",
 	)
 
 	addF(
@@ -169,6 +177,7 @@ func init() {
 			}
 			return NewValStr(args[0].TypeName()), nil
 		},
+		"type(v), the type name of the value",
 	)
 
 	addF(
@@ -182,6 +191,7 @@ func init() {
 			}
 			return NewValStr(args[0].Info()), nil
 		},
+		"info(v), the debug information of the value",
 	)
 
 	addF(
@@ -195,6 +205,7 @@ func init() {
 			}
 			return NewValStr(args[0].Id()), nil
 		},
+		"id(v), the identity of the value",
 	)
 
 	addF(
@@ -220,6 +231,7 @@ func init() {
 				return NewValInt(a.Map().Length()), nil
 			}
 		},
+		"len(v), the length of the string, list, map or pair",
 	)
 
 	addF(
@@ -245,5 +257,6 @@ func init() {
 				return NewValBool(a.Map().Length() == 0), nil
 			}
 		},
+		"empty(v), whether the string, list or map has no element",
 	)
 }
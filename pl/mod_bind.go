@@ -139,6 +139,7 @@ func init() {
 			}
 			return NewValUsr(&placeholder{}), nil
 		},
+		"new_placeholder(), the placeholder argument of bind",
 	)
 
 	addF(
@@ -156,5 +157,6 @@ func init() {
 				args[1:],
 			)
 		},
+		"bind(closure, ...), partial application, the placeholders are filled by the arguments of the call",
 	)
 }
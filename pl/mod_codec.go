@@ -14,6 +14,7 @@ func init() {
 		func(input string) string {
 			return base64.StdEncoding.EncodeToString([]byte(input))
 		},
+		"codec::b64_tostring(s), encodes the string as standard base64",
 	)
 	addrefMF(
 		"codec",
@@ -28,6 +29,7 @@ func init() {
 				return string(o), nil
 			}
 		},
+		"codec::b64_fromstring(s), decodes the standard base64 string",
 	)
 
 	addrefMF(
@@ -36,6 +38,7 @@ func init() {
 		"",
 		"%s",
 		url.QueryEscape,
+		"code::url_encode(s), escapes the string for URL query",
 	)
 	addrefMF(
 		"code",
@@ -43,6 +46,7 @@ func init() {
 		"",
 		"%s",
 		url.QueryUnescape,
+		"code::url_decode(s), unescapes the URL query string",
 	)
 
 	addrefMF(
@@ -51,6 +55,7 @@ func init() {
 		"",
 		"%s",
 		url.PathEscape,
+		"code::path_encode(s), escapes the string for URL path segment",
 	)
 	addrefMF(
 		"code",
@@ -58,5 +63,6 @@ func init() {
 		"",
 		"%s",
 		url.PathUnescape,
+		"code::path_decode(s), unescapes the URL path segment",
 	)
 }
@@ -0,0 +1,322 @@
+package pl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+// compression codec registry. The gzip and deflate codecs are builtin, the
+// deflate codec uses the zlib format as what HTTP Content-Encoding: deflate
+// means. Other codecs, ie br and zstd, can be registered by the embedder with
+// RegisterCodec and then become usable by compress::, decompress::, the stream
+// transforms and the compress response middleware
+//
+// Decompress buffers the whole output, so it is bounded, a small compressed
+// input, ie a zip bomb, could expand into gigabytes otherwise
+
+// default max size of the output of Decompress
+const DecompressMaxSize = 32 << 20
+
+type Codec interface {
+	// level is codec specific, -1 means the default level of the codec
+	NewWriter(w io.Writer, level int) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+type gzipCodec struct{}
+
+func (g *gzipCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, level)
+}
+
+func (g *gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+type deflateCodec struct{}
+
+func (d *deflateCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return zlib.NewWriterLevel(w, level)
+}
+
+func (d *deflateCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+var (
+	codecLock sync.RWMutex
+	codecMap  = map[string]Codec{
+		"gzip":    &gzipCodec{},
+		"deflate": &deflateCodec{},
+	}
+)
+
+func RegisterCodec(name string, c Codec) error {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	if _, ok := codecMap[name]; ok {
+		return fmt.Errorf("codec %s already registered", name)
+	}
+	codecMap[name] = c
+	return nil
+}
+
+func GetCodec(name string) Codec {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	return codecMap[name]
+}
+
+// name of all the registered codecs, sorted
+func CodecList() []string {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	o := []string{}
+	for k := range codecMap {
+		o = append(o, k)
+	}
+	sort.Strings(o)
+	return o
+}
+
+func mustCodec(name string) (Codec, error) {
+	c := GetCodec(name)
+	if c == nil {
+		return nil, fmt.Errorf("codec %s is not supported", name)
+	}
+	return c, nil
+}
+
+func Compress(name string, data []byte, level int) ([]byte, error) {
+	c, err := mustCodec(name)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	w, err := c.NewWriter(buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress the data, the output is bounded by DecompressMaxSize
+func Decompress(name string, data []byte) ([]byte, error) {
+	return DecompressLimit(name, data, DecompressMaxSize)
+}
+
+// DecompressLimit decompresses the data, and fails if the output is larger
+// than max bytes. A none positive max means DecompressMaxSize
+func DecompressLimit(name string, data []byte, max int64) ([]byte, error) {
+	if max <= 0 {
+		max = DecompressMaxSize
+	}
+	c, err := mustCodec(name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	o, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(o)) > max {
+		return nil, fmt.Errorf("decompressed data is larger than %d bytes", max)
+	}
+	return o, nil
+}
+
+// compresses the src lazily, the src is only read when the returned reader is
+// been read
+type compressReader struct {
+	src  io.Reader
+	w    io.WriteCloser
+	buf  bytes.Buffer
+	in   []byte
+	done bool
+}
+
+func (c *compressReader) Read(p []byte) (int, error) {
+	for c.buf.Len() == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		n, err := c.src.Read(c.in)
+		if n > 0 {
+			if _, werr := c.w.Write(c.in[:n]); werr != nil {
+				return 0, werr
+			}
+		}
+		if err == io.EOF {
+			if cerr := c.w.Close(); cerr != nil {
+				return 0, cerr
+			}
+			c.done = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	return c.buf.Read(p)
+}
+
+func NewCompressReader(name string, src io.Reader, level int) (io.Reader, error) {
+	c, err := mustCodec(name)
+	if err != nil {
+		return nil, err
+	}
+	x := &compressReader{
+		src: src,
+		in:  make([]byte, 4096),
+	}
+	w, err := c.NewWriter(&x.buf, level)
+	if err != nil {
+		return nil, err
+	}
+	x.w = w
+	return x, nil
+}
+
+// decompression reader normally reads the header once created, so it is
+// created on the first read
+type decompressReader struct {
+	codec Codec
+	src   io.Reader
+	r     io.ReadCloser
+}
+
+func (d *decompressReader) Read(p []byte) (int, error) {
+	if d.r == nil {
+		r, err := d.codec.NewReader(d.src)
+		if err != nil {
+			return 0, err
+		}
+		d.r = r
+	}
+	return d.r.Read(p)
+}
+
+func NewDecompressReader(name string, src io.Reader) (io.Reader, error) {
+	c, err := mustCodec(name)
+	if err != nil {
+		return nil, err
+	}
+	return &decompressReader{
+		codec: c,
+		src:   src,
+	}, nil
+}
+
+func init() {
+	for _, name := range []string{"gzip", "deflate"} {
+		codec := name
+		addMF(
+			"compress",
+			codec,
+			"",
+			"%s:data%d:level=-1",
+			func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+				args, err := info.CheckDefault(args)
+				if err != nil {
+					return NewValNull(), err
+				}
+				o, err := Compress(codec, []byte(args[0].String()), int(args[1].Int()))
+				if err != nil {
+					return NewValNull(), fmt.Errorf("compress::%s: %s", codec, err.Error())
+				}
+				return NewValStr(string(o)), nil
+			},
+			fmt.Sprintf("compress::%s(data, [level]), compresses the data by %s", codec, codec),
+		)
+		addMF(
+			"decompress",
+			codec,
+			"",
+			"%s:data%d:max=0",
+			func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+				args, err := info.CheckDefault(args)
+				if err != nil {
+					return NewValNull(), err
+				}
+				o, err := DecompressLimit(codec, []byte(args[0].String()), args[1].Int())
+				if err != nil {
+					return NewValNull(), fmt.Errorf("decompress::%s: %s", codec, err.Error())
+				}
+				return NewValStr(string(o)), nil
+			},
+			fmt.Sprintf("decompress::%s(data, [max]), decompresses the data by %s, max limits the output size", codec, codec),
+		)
+	}
+
+	// compress::codec(name, data, level), works with any registered codec
+	addMF(
+		"compress",
+		"codec",
+		"",
+		"%s:codec%s:data%d:level=-1",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			args, err := info.CheckDefault(args)
+			if err != nil {
+				return NewValNull(), err
+			}
+			o, err := Compress(args[0].String(), []byte(args[1].String()), int(args[2].Int()))
+			if err != nil {
+				return NewValNull(), fmt.Errorf("compress::codec: %s", err.Error())
+			}
+			return NewValStr(string(o)), nil
+		},
+		"compress::codec(name, data, [level]), compresses the data with any registered codec",
+	)
+
+	addMF(
+		"decompress",
+		"codec",
+		"",
+		"%s:codec%s:data%d:max=0",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			args, err := info.CheckDefault(args)
+			if err != nil {
+				return NewValNull(), err
+			}
+			o, err := DecompressLimit(args[0].String(), []byte(args[1].String()), args[2].Int())
+			if err != nil {
+				return NewValNull(), fmt.Errorf("decompress::codec: %s", err.Error())
+			}
+			return NewValStr(string(o)), nil
+		},
+		"decompress::codec(name, data, [max]), decompresses the data with any registered codec, max limits the output size",
+	)
+
+	addMF(
+		"compress",
+		"list",
+		"",
+		"%0",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			l := NewValList()
+			for _, x := range CodecList() {
+				l.AddList(NewValStr(x))
+			}
+			return l, nil
+		},
+		"compress::list(), the names of the registered codecs",
+	)
+}
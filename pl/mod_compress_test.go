@@ -0,0 +1,30 @@
+package pl
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecompressLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	data := bytes.Repeat([]byte{'x'}, 1024)
+	z, err := Compress("gzip", data, -1)
+	assert.Nil(err)
+
+	o, err := DecompressLimit("gzip", z, 1024)
+	assert.Nil(err)
+	assert.Equal(data, o)
+
+	_, err = DecompressLimit("gzip", z, 1023)
+	assert.NotNil(err)
+
+	// small input expanding beyond the default limit
+	bomb, err := Compress("deflate", make([]byte, DecompressMaxSize+1), 9)
+	assert.Nil(err)
+	assert.True(len(bomb) < 64*1024)
+	_, err = Decompress("deflate", bomb)
+	assert.NotNil(err)
+}
@@ -0,0 +1,66 @@
+package pl
+
+import (
+	"fmt"
+	"os"
+)
+
+// env module, exposing the process environment to the script. Since the
+// environment normally carries secrets, the module is only usable when the
+// evaluator has been granted CapabilityEnv
+
+func checkEnvCapability(e *Evaluator, name string) error {
+	if e == nil || !e.HasCapability(CapabilityEnv) {
+		return fmt.Errorf("%s: env capability is not granted", name)
+	}
+	return nil
+}
+
+func init() {
+	addMF(
+		"env",
+		"get",
+		"",
+		"{%s}{%s%a}",
+		func(info *IntrinsicInfo, e *Evaluator, _ string, args []Val) (Val, error) {
+			alog, err := info.Check(args)
+			if err != nil {
+				return NewValNull(), err
+			}
+			if err := checkEnvCapability(e, "env::get"); err != nil {
+				return NewValNull(), err
+			}
+
+			v, ok := os.LookupEnv(args[0].String())
+			if ok {
+				return NewValStr(v), nil
+			}
+			if alog == 2 {
+				return args[1], nil
+			}
+			return NewValNull(), nil
+		},
+		"env::get(name, [default]), the environment variable, or the default if it is not set",
+	)
+
+	addMF(
+		"env",
+		"hostname",
+		"",
+		"%0",
+		func(info *IntrinsicInfo, e *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			if err := checkEnvCapability(e, "env::hostname"); err != nil {
+				return NewValNull(), err
+			}
+			h, err := os.Hostname()
+			if err != nil {
+				return NewValNull(), err
+			}
+			return NewValStr(h), nil
+		},
+		"env::hostname(), the host name of the machine",
+	)
+}
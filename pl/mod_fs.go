@@ -0,0 +1,134 @@
+package pl
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// fs module, allowing script to load file shipped along with the application.
+// All the path is resolved inside of the module's bounded fs.FS, ie the
+// manifest's FS, and there's no way for the script to escape from it
+
+func fsOf(e *Evaluator, name string) (fs.FS, error) {
+	if e != nil {
+		if m := e.curModule(); m != nil && m.FS() != nil {
+			return m.FS(), nil
+		}
+	}
+	return nil, fmt.Errorf("%s: module is not bounded to any file system", name)
+}
+
+func fsPath(path string, name string) (string, error) {
+	p := strings.TrimPrefix(path, "/")
+	if p == "" {
+		p = "."
+	}
+	if !fs.ValidPath(p) {
+		return "", fmt.Errorf("%s: invalid path %s", name, path)
+	}
+	return p, nil
+}
+
+func fsReadFile(e *Evaluator, path string, name string) (string, error) {
+	fsys, err := fsOf(e, name)
+	if err != nil {
+		return "", err
+	}
+	p, err := fsPath(path, name)
+	if err != nil {
+		return "", err
+	}
+	data, err := fs.ReadFile(fsys, p)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", name, err.Error())
+	}
+	return string(data), nil
+}
+
+func init() {
+	addMF(
+		"fs",
+		"read",
+		"",
+		"%s",
+		func(info *IntrinsicInfo, e *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			data, err := fsReadFile(e, args[0].String(), "fs::read")
+			if err != nil {
+				return NewValNull(), err
+			}
+			return NewValStr(data), nil
+		},
+		"fs::read(path), reads the file of the vhost file system as string",
+	)
+
+	addMF(
+		"fs",
+		"read_json",
+		"",
+		"%s",
+		func(info *IntrinsicInfo, e *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			data, err := fsReadFile(e, args[0].String(), "fs::read_json")
+			if err != nil {
+				return NewValNull(), err
+			}
+			v, err := NewValFromJSON(data)
+			if err != nil {
+				return NewValNull(), fmt.Errorf("fs::read_json: %s", err.Error())
+			}
+			return v, nil
+		},
+		"fs::read_json(path), reads the file of the vhost file system and decodes it as JSON",
+	)
+
+	addMF(
+		"fs",
+		"exists",
+		"",
+		"%s",
+		func(info *IntrinsicInfo, e *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			fsys, err := fsOf(e, "fs::exists")
+			if err != nil {
+				return NewValNull(), err
+			}
+			p, err := fsPath(args[0].String(), "fs::exists")
+			if err != nil {
+				return NewValBool(false), nil
+			}
+			_, err = fs.Stat(fsys, p)
+			return NewValBool(err == nil), nil
+		},
+		"fs::exists(path), whether the file exists in the vhost file system",
+	)
+
+	addMF(
+		"fs",
+		"glob",
+		"",
+		"%s",
+		func(info *IntrinsicInfo, e *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			fsys, err := fsOf(e, "fs::glob")
+			if err != nil {
+				return NewValNull(), err
+			}
+			m, err := fs.Glob(fsys, strings.TrimPrefix(args[0].String(), "/"))
+			if err != nil {
+				return NewValNull(), fmt.Errorf("fs::glob: %s", err.Error())
+			}
+			return NewValStrList(m), nil
+		},
+		"fs::glob(pattern), the paths of the vhost file system matching the pattern",
+	)
+}
@@ -0,0 +1,131 @@
+package pl
+
+import (
+	"fmt"
+
+	"github.com/dianpeng/moons/util"
+)
+
+const (
+	GlobTypeId = ".glob"
+)
+
+var (
+	mpGlobMatch   = MustNewFuncProto(".glob.match", "%s")
+	mpGlobPattern = MustNewFuncProto(".glob.pattern", "%0")
+)
+
+func IsValGlob(v Val) bool {
+	return v.Id() == GlobTypeId
+}
+
+// glob matcher value, created by glob::compile
+type globMatcher struct {
+	g *util.Glob
+}
+
+func (g *globMatcher) Index(_ Val) (Val, error) {
+	return NewValNull(), fmt.Errorf("type: %s does not support index", g.Id())
+}
+
+func (g *globMatcher) IndexSet(_ Val, _ Val) error {
+	return fmt.Errorf("type: %s does not support index set", g.Id())
+}
+
+func (g *globMatcher) Dot(name string) (Val, error) {
+	switch name {
+	case "pattern":
+		return NewValStr(g.g.Pattern()), nil
+	default:
+		return NewValNull(), fmt.Errorf("type: %s does not support field %s", g.Id(), name)
+	}
+}
+
+func (g *globMatcher) DotSet(_ string, _ Val) error {
+	return fmt.Errorf("type: %s does not support dot set", g.Id())
+}
+
+func (g *globMatcher) Method(name string, args []Val) (Val, error) {
+	switch name {
+	case "match":
+		if _, err := mpGlobMatch.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		return NewValBool(g.g.Match(args[0].String())), nil
+
+	case "pattern":
+		if _, err := mpGlobPattern.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		return NewValStr(g.g.Pattern()), nil
+
+	default:
+		return NewValNull(), fmt.Errorf("%s method: %s is unknown", g.Id(), name)
+	}
+}
+
+func (g *globMatcher) ToString() (string, error) {
+	return g.g.Pattern(), nil
+}
+
+func (g *globMatcher) ToJSON() (Val, error) {
+	return NewValStr(g.g.Pattern()), nil
+}
+
+func (g *globMatcher) Id() string {
+	return GlobTypeId
+}
+
+func (g *globMatcher) Info() string {
+	return g.Id()
+}
+
+func (g *globMatcher) IsThreadSafe() bool {
+	return true
+}
+
+func (g *globMatcher) NewIterator() (Iter, error) {
+	return nil, fmt.Errorf("type: %s does not support iterator", g.Id())
+}
+
+func NewValGlob(g *util.Glob) Val {
+	return NewValUsr(&globMatcher{g: g})
+}
+
+func init() {
+	addMF(
+		"glob",
+		"compile",
+		"",
+		"%s",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			g, err := util.CompileGlob(args[0].String())
+			if err != nil {
+				return NewValNull(), fmt.Errorf("glob::compile: %s", err.Error())
+			}
+			return NewValGlob(g), nil
+		},
+		"glob::compile(pattern), compiles the glob pattern",
+	)
+
+	addMF(
+		"glob",
+		"match",
+		"",
+		"%s%s",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			g, err := util.CompileGlob(args[0].String())
+			if err != nil {
+				return NewValNull(), fmt.Errorf("glob::match: %s", err.Error())
+			}
+			return NewValBool(g.Match(args[1].String())), nil
+		},
+		"glob::match(pattern, s), whether the string matches the glob pattern",
+	)
+}
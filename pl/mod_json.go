@@ -0,0 +1,47 @@
+package pl
+
+import (
+	"fmt"
+)
+
+func init() {
+	addMF(
+		"json",
+		"encode",
+		"",
+		"%a",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			str, err := args[0].ToJSONString()
+			if err != nil {
+				return NewValNull(), fmt.Errorf("json::encode: %s", err.Error())
+			}
+			return NewValStr(str), nil
+		},
+		"json::encode(v), encodes the value as JSON string",
+	)
+
+	// json::decode(str, [ordered]), if ordered is true, all the JSON object is
+	// decoded as ordered map
+	addMF(
+		"json",
+		"decode",
+		"",
+		"{%s}{%s%b}",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			alog, err := info.Check(args)
+			if err != nil {
+				return NewValNull(), err
+			}
+			ordered := alog == 2 && args[1].Bool()
+			v, err := newValFromJSON(args[0].String(), ordered)
+			if err != nil {
+				return NewValNull(), fmt.Errorf("json::decode: %s", err.Error())
+			}
+			return v, nil
+		},
+		"json::decode(s, [ordered]), decodes the JSON string, objects are decoded as ordered map if ordered is true",
+	)
+}
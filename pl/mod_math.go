@@ -1,16 +1,237 @@
 package pl
 
 import (
+	"fmt"
 	"math"
 )
 
+func mathReal(v Val) float64 {
+	if v.Type == ValInt {
+		return float64(v.Int())
+	}
+	return v.Real()
+}
+
+// register an unary function which accepts both int and real as input and
+// always returns a real number
+func addMathF1(fn string, f func(float64) float64, doc string) {
+	addMF(
+		"math",
+		fn,
+		"",
+		"(%d|%f)",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			return NewValReal(f(mathReal(args[0]))), nil
+		},
+		doc,
+	)
+}
+
+// collect a list of numbers, used by the statistic functions
+func mathNumList(fn string, l *List) ([]float64, error) {
+	o := make([]float64, 0, l.Length())
+	for i := 0; i < l.Length(); i++ {
+		v := l.At(i)
+		if !v.IsNumber() {
+			return nil, fmt.Errorf("math::%s: element %d is not a number", fn, i)
+		}
+		o = append(o, mathReal(v))
+	}
+	return o, nil
+}
+
+func mathMean(x []float64) float64 {
+	sum := 0.0
+	for _, v := range x {
+		sum += v
+	}
+	return sum / float64(len(x))
+}
+
+// population standard deviation, x must not be empty
+func mathStddev(x []float64) float64 {
+	m := mathMean(x)
+	sum := 0.0
+	for _, v := range x {
+		sum += (v - m) * (v - m)
+	}
+	return math.Sqrt(sum / float64(len(x)))
+}
+
 func init() {
-	addrefMF(
+	addMF(
 		"math",
 		"abs",
 		"",
-		"%f",
-		math.Abs,
+		"(%d|%f)",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			if args[0].Type == ValInt {
+				v := args[0].Int()
+				if v < 0 {
+					v = -v
+				}
+				return NewValInt64(v), nil
+			}
+			return NewValReal(math.Abs(args[0].Real())), nil
+		},
+		"math::abs(x), the absolute value, int input yields int",
+	)
+
+	addMathF1("floor", math.Floor, "math::floor(x), the greatest integer value less than or equal to x")
+	addMathF1("ceil", math.Ceil, "math::ceil(x), the least integer value greater than or equal to x")
+	addMathF1("round", math.Round, "math::round(x), the nearest integer, rounding half away from zero")
+	addMathF1("trunc", math.Trunc, "math::trunc(x), the integer value of x")
+	addMathF1("sqrt", math.Sqrt, "math::sqrt(x), the square root of x")
+	addMathF1("cbrt", math.Cbrt, "math::cbrt(x), the cube root of x")
+	addMathF1("log", math.Log, "math::log(x), the natural logarithm of x")
+	addMathF1("log2", math.Log2, "math::log2(x), the binary logarithm of x")
+	addMathF1("log10", math.Log10, "math::log10(x), the decimal logarithm of x")
+	addMathF1("exp", math.Exp, "math::exp(x), e to the power of x")
+	addMathF1("sin", math.Sin, "math::sin(x), the sine of the radian x")
+	addMathF1("cos", math.Cos, "math::cos(x), the cosine of the radian x")
+	addMathF1("tan", math.Tan, "math::tan(x), the tangent of the radian x")
+	addMathF1("asin", math.Asin, "math::asin(x), the arcsine of x in radian")
+	addMathF1("acos", math.Acos, "math::acos(x), the arccosine of x in radian")
+	addMathF1("atan", math.Atan, "math::atan(x), the arctangent of x in radian")
+
+	addMF(
+		"math",
+		"atan2",
+		"",
+		"(%d|%f)(%d|%f)",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			return NewValReal(math.Atan2(mathReal(args[0]), mathReal(args[1]))), nil
+		},
+		"math::atan2(y, x), the arc tangent of y/x",
+	)
+
+	addrefMF(
+		"math",
+		"pi",
+		"",
+		"%0",
+		func() float64 {
+			return math.Pi
+		},
+		"math::pi(), the constant pi",
+	)
+
+	addrefMF(
+		"math",
+		"e",
+		"",
+		"%0",
+		func() float64 {
+			return math.E
+		},
+		"math::e(), the constant e",
+	)
+
+	// clamp(v, lo, hi), if all the input are int, then the result is int,
+	// otherwise a real number is returned
+	addMF(
+		"math",
+		"clamp",
+		"",
+		"(%d|%f)(%d|%f)(%d|%f)",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			if args[0].Type == ValInt && args[1].Type == ValInt && args[2].Type == ValInt {
+				v, lo, hi := args[0].Int(), args[1].Int(), args[2].Int()
+				if lo > hi {
+					return NewValNull(), fmt.Errorf("math::clamp: lower bound is larger than upper bound")
+				}
+				if v < lo {
+					v = lo
+				} else if v > hi {
+					v = hi
+				}
+				return NewValInt64(v), nil
+			}
+
+			v, lo, hi := mathReal(args[0]), mathReal(args[1]), mathReal(args[2])
+			if lo > hi {
+				return NewValNull(), fmt.Errorf("math::clamp: lower bound is larger than upper bound")
+			}
+			return NewValReal(math.Max(lo, math.Min(v, hi))), nil
+		},
+		"math::clamp(v, lo, hi), limits v into [lo, hi], int if all the inputs are int",
+	)
+
+	addMF(
+		"math",
+		"sum",
+		"",
+		"%l",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			x, err := mathNumList("sum", args[0].List())
+			if err != nil {
+				return NewValNull(), err
+			}
+			sum := 0.0
+			for _, v := range x {
+				sum += v
+			}
+			return NewValReal(sum), nil
+		},
+		"math::sum(list), the sum of the numbers",
+	)
+
+	addMF(
+		"math",
+		"mean",
+		"",
+		"%l",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			x, err := mathNumList("mean", args[0].List())
+			if err != nil {
+				return NewValNull(), err
+			}
+			if len(x) == 0 {
+				return NewValNull(), fmt.Errorf("math::mean: empty list")
+			}
+			return NewValReal(mathMean(x)), nil
+		},
+		"math::mean(list), the arithmetic mean of the numbers",
+	)
+
+	// population standard deviation
+	addMF(
+		"math",
+		"stddev",
+		"",
+		"%l",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			x, err := mathNumList("stddev", args[0].List())
+			if err != nil {
+				return NewValNull(), err
+			}
+			if len(x) == 0 {
+				return NewValNull(), fmt.Errorf("math::stddev: empty list")
+			}
+			return NewValReal(mathStddev(x)), nil
+		},
+		"math::stddev(list), the population standard deviation of the numbers",
 	)
 
 	addrefMF(
@@ -21,6 +242,7 @@ func init() {
 		func(v float64) bool {
 			return math.IsInf(v, 0)
 		},
+		"math::is_inf(x), whether x is infinity",
 	)
 
 	addrefMF(
@@ -29,6 +251,7 @@ func init() {
 		"",
 		"%f",
 		math.IsNaN,
+		"math::is_nan(x), whether x is NaN",
 	)
 
 	addrefMF(
@@ -37,6 +260,7 @@ func init() {
 		"",
 		"%f%f",
 		math.Max,
+		"math::max(a, b), the larger one",
 	)
 
 	addrefMF(
@@ -45,6 +269,7 @@ func init() {
 		"",
 		"%f%f",
 		math.Min,
+		"math::min(a, b), the smaller one",
 	)
 
 	addrefMF(
@@ -53,6 +278,7 @@ func init() {
 		"",
 		"%f%f",
 		math.Mod,
+		"math::mod(a, b), the remainder of a/b",
 	)
 
 	addrefMF(
@@ -61,6 +287,7 @@ func init() {
 		"",
 		"%f",
 		math.Modf,
+		"math::modf(x), the integer and fractional part as pair",
 	)
 
 	addrefMF(
@@ -69,6 +296,7 @@ func init() {
 		"",
 		"%f%f",
 		math.Pow,
+		"math::pow(x, y), x to the power of y",
 	)
 
 	addrefMF(
@@ -77,5 +305,6 @@ func init() {
 		"",
 		"%f",
 		math.Pow10,
+		"math::pow10(n), 10 to the power of n",
 	)
 }
@@ -1,7 +1,11 @@
 package pl
 
 import (
+	"container/heap"
 	"fmt"
+	"math"
+	"sort"
+	"strings"
 )
 
 // ---------------------------------------------------------------------------
@@ -629,20 +633,763 @@ func qCount(
 	}
 }
 
+// ---------------------------------------------------------------------------
+// 5) ordering
+//
+// The default ordering is total and deterministic across mixed types. Values
+// are firstly ordered by its rank, ie null < bool < number < string < others,
+// and then within the same rank. Int and real are compared numerically, and if
+// they are equal, int goes first. Other values are compared by its type id and
+// then its string representation.
+
+func qRank(v Val) int {
+	switch v.Type {
+	case ValNull:
+		return 0
+	case ValBool:
+		return 1
+	case ValInt, ValReal:
+		return 2
+	case ValStr:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func qCompare(a, b Val) int {
+	ra, rb := qRank(a), qRank(b)
+	if ra != rb {
+		return ra - rb
+	}
+
+	switch ra {
+	case 0:
+		return 0
+
+	case 1:
+		ba, bb := a.Bool(), b.Bool()
+		if ba == bb {
+			return 0
+		} else if !ba {
+			return -1
+		} else {
+			return 1
+		}
+
+	case 2:
+		if a.IsInt() && b.IsInt() {
+			ia, ib := a.Int(), b.Int()
+			if ia < ib {
+				return -1
+			} else if ia > ib {
+				return 1
+			}
+			return 0
+		}
+		fa, fb := qReal(a), qReal(b)
+		if fa < fb {
+			return -1
+		} else if fa > fb {
+			return 1
+		}
+		if a.Type == b.Type {
+			return 0
+		} else if a.IsInt() {
+			return -1
+		} else {
+			return 1
+		}
+
+	case 3:
+		return strings.Compare(a.String(), b.String())
+
+	default:
+		if c := strings.Compare(a.Id(), b.Id()); c != 0 {
+			return c
+		}
+		sa, _ := a.ToString()
+		sb, _ := b.ToString()
+		return strings.Compare(sa, sb)
+	}
+}
+
+func qReal(v Val) float64 {
+	if v.IsInt() {
+		return float64(v.Int())
+	}
+	return v.Real()
+}
+
+func qCopyList(l *List) []Val {
+	o := make([]Val, len(l.Data))
+	copy(o, l.Data)
+	return o
+}
+
+func qSort(
+	info *IntrinsicInfo,
+	_ *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	if _, err := info.Check(args); err != nil {
+		return NewValNull(), err
+	}
+
+	data := qCopyList(args[0].List())
+	sort.SliceStable(
+		data,
+		func(i, j int) bool {
+			return qCompare(data[i], data[j]) < 0
+		},
+	)
+	return NewValListRaw(data), nil
+}
+
+// q::sort_by(list, fn(a, b)), the comparator returns either a bool indicating
+// a is less than b, or an int which is negative when a is less than b
+func qSortBy(
+	info *IntrinsicInfo,
+	eval *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	if _, err := info.Check(args); err != nil {
+		return NewValNull(), err
+	}
+
+	data := qCopyList(args[0].List())
+	fn := args[1].Closure()
+	var err error
+
+	sort.SliceStable(
+		data,
+		func(i, j int) bool {
+			if err != nil {
+				return false
+			}
+			v, cerr := fn.Call(eval, []Val{data[i], data[j]})
+			if cerr != nil {
+				err = cerr
+				return false
+			}
+			switch v.Type {
+			case ValBool:
+				return v.Bool()
+			case ValInt:
+				return v.Int() < 0
+			default:
+				err = fmt.Errorf("q::sort_by comparator must return bool or int")
+				return false
+			}
+		},
+	)
+
+	if err != nil {
+		return NewValNull(), err
+	}
+	return NewValListRaw(data), nil
+}
+
+// ---------------------------------------------------------------------------
+// 6) grouping
+//
+// The key function is invoked with the same argument as q::map, ie (index,
+// value) for list and (key, value) for map. It must return a value which can
+// be converted to string, which is used as the group key.
+
+func qForeachKV(
+	a Val,
+	cb func(Val, Val) error,
+) error {
+	if a.IsList() {
+		for k, v := range a.List().Data {
+			if err := cb(NewValInt(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	must(a.IsMap(), "must be map")
+	var err error
+	a.Map().Foreach(
+		func(k string, v Val) bool {
+			err = cb(NewValStr(k), v)
+			return err == nil
+		},
+	)
+	return err
+}
+
+func qGroupKey(
+	name string,
+	eval *Evaluator,
+	fn Closure,
+	k Val,
+	v Val,
+) (string, error) {
+	key, err := fn.Call(eval, []Val{k, v})
+	if err != nil {
+		return "", err
+	}
+	str, err := key.ToString()
+	if err != nil {
+		return "", fmt.Errorf("%s key function must return string: %s", name, err.Error())
+	}
+	return str, nil
+}
+
+func qGroupBy(
+	info *IntrinsicInfo,
+	eval *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	if _, err := info.Check(args); err != nil {
+		return NewValNull(), err
+	}
+	fn := args[1].Closure()
+	output := NewValMap()
+	m := output.Map()
+
+	err := qForeachKV(
+		args[0],
+		func(k Val, v Val) error {
+			key, err := qGroupKey("q::group_by", eval, fn, k, v)
+			if err != nil {
+				return err
+			}
+			addMapResult(m, key, v)
+			return nil
+		},
+	)
+	if err != nil {
+		return NewValNull(), err
+	}
+	return output, nil
+}
+
+func qCountBy(
+	info *IntrinsicInfo,
+	eval *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	if _, err := info.Check(args); err != nil {
+		return NewValNull(), err
+	}
+	fn := args[1].Closure()
+	output := NewValMap()
+	m := output.Map()
+
+	err := qForeachKV(
+		args[0],
+		func(k Val, v Val) error {
+			key, err := qGroupKey("q::count_by", eval, fn, k, v)
+			if err != nil {
+				return err
+			}
+			cnt := int64(0)
+			if old, ok := m.Get(key); ok {
+				cnt = old.Int()
+			}
+			m.Set(key, NewValInt64(cnt+1))
+			return nil
+		},
+	)
+	if err != nil {
+		return NewValNull(), err
+	}
+	return output, nil
+}
+
+// ---------------------------------------------------------------------------
+// 7) structural helpers
+//
+// All the following helpers treat null input as an empty list, and always
+// return a new list without modifying the input.
+
+func qListOf(v Val) []Val {
+	if v.IsNull() {
+		return nil
+	}
+	return v.List().Data
+}
+
+// q::zip(l1, l2, ...), returns list of list, the length of output is the
+// length of the shortest input list
+func qZip(
+	info *IntrinsicInfo,
+	_ *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	if _, err := info.Check(args); err != nil {
+		return NewValNull(), err
+	}
+	o := NewValList()
+	if len(args) == 0 {
+		return o, nil
+	}
+
+	sz := -1
+	for _, x := range args {
+		if !x.IsList() && !x.IsNull() {
+			return NewValNull(), fmt.Errorf("q::zip expects list, got %s", x.Id())
+		}
+		l := len(qListOf(x))
+		if sz < 0 || l < sz {
+			sz = l
+		}
+	}
+
+	for i := 0; i < sz; i++ {
+		row := NewValList()
+		for _, x := range args {
+			row.AddList(qListOf(x)[i])
+		}
+		o.AddList(row)
+	}
+	return o, nil
+}
+
+// path is the lists being flattened, a list containing itself cannot be
+// flattened without a depth limit
+func qFlattenImpl(o Val, l []Val, depth int, path map[*List]bool) error {
+	for _, v := range l {
+		if v.IsList() && depth != 0 {
+			x := v.List()
+			if depth < 0 && path[x] {
+				return fmt.Errorf("q::flatten: list contains itself")
+			}
+			path[x] = true
+			if err := qFlattenImpl(o, x.Data, depth-1, path); err != nil {
+				return err
+			}
+			delete(path, x)
+		} else {
+			o.AddList(v)
+		}
+	}
+	return nil
+}
+
+// q::flatten(list, [depth]), flatten nested list, by default only one level
+// is flattened, a negative depth means flatten all
+func qFlatten(
+	info *IntrinsicInfo,
+	_ *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	alog, err := info.Check(args)
+	if err != nil {
+		return NewValNull(), err
+	}
+	depth := 1
+	if alog == 2 {
+		depth = int(args[1].Int())
+	}
+	o := NewValList()
+	path := make(map[*List]bool)
+	if args[0].IsList() {
+		path[args[0].List()] = true
+	}
+	if err := qFlattenImpl(o, qListOf(args[0]), depth, path); err != nil {
+		return NewValNull(), err
+	}
+	return o, nil
+}
+
+// q::unique(list), removes duplicated element and keeps the first occurrence.
+// Values of different type are never duplicated, ie 1 and 1.0 are both kept
+func qUnique(
+	info *IntrinsicInfo,
+	_ *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	if _, err := info.Check(args); err != nil {
+		return NewValNull(), err
+	}
+	o := NewValList()
+	seen := make(map[string]bool)
+	var composite []Val
+
+	for _, v := range qListOf(args[0]) {
+		switch v.Type {
+		case ValNull, ValInt, ValReal, ValStr, ValBool:
+			str, _ := v.ToString()
+			key := v.Id() + ":" + str
+			if !seen[key] {
+				seen[key] = true
+				o.AddList(v)
+			}
+
+		default:
+			dup := false
+			for _, x := range composite {
+				if ok, _ := assertVeq(x, v); ok {
+					dup = true
+					break
+				}
+			}
+			if !dup {
+				composite = append(composite, v)
+				o.AddList(v)
+			}
+		}
+	}
+	return o, nil
+}
+
+func qReverse(
+	info *IntrinsicInfo,
+	_ *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	if _, err := info.Check(args); err != nil {
+		return NewValNull(), err
+	}
+	l := qListOf(args[0])
+	o := make([]Val, len(l))
+	for i, v := range l {
+		o[len(l)-1-i] = v
+	}
+	return NewValListRaw(o), nil
+}
+
+// q::chunk(list, size), splits list into list of list with at most size
+// elements, the last chunk may be shorter
+func qChunk(
+	info *IntrinsicInfo,
+	_ *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	if _, err := info.Check(args); err != nil {
+		return NewValNull(), err
+	}
+	size := int(args[1].Int())
+	if size <= 0 {
+		return NewValNull(), fmt.Errorf("q::chunk size must be positive")
+	}
+
+	l := qListOf(args[0])
+	o := NewValList()
+	for i := 0; i < len(l); i += size {
+		end := i + size
+		if end > len(l) {
+			end = len(l)
+		}
+		c := make([]Val, end-i)
+		copy(c, l[i:end])
+		o.AddList(NewValListRaw(c))
+	}
+	return o, nil
+}
+
+// ---------------------------------------------------------------------------
+// 8) join
+//
+// q::join(left, right, left_keyfn, right_keyfn, [mode]) joins two lists by the
+// key returned by the key functions, the key function has the same signature
+// as the one used by q::group_by. Mode can be "inner", "left" or "outer", and
+// by default it is "inner". The output is a list of pair (left, right), with
+// null filled for the missing side. Output follows the order of left list, and
+// for outer join the unmatched right elements are appended in their order.
+
+func qJoin(
+	info *IntrinsicInfo,
+	eval *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	alog, err := info.Check(args)
+	if err != nil {
+		return NewValNull(), err
+	}
+
+	mode := "inner"
+	if alog == 5 {
+		mode = args[4].String()
+	}
+	switch mode {
+	case "inner", "left", "outer":
+		break
+	default:
+		return NewValNull(), fmt.Errorf("q::join unknown mode %s", mode)
+	}
+
+	left := args[0].List().Data
+	right := args[1].List().Data
+	lfn := args[2].Closure()
+	rfn := args[3].Closure()
+
+	// index the right side
+	rindex := make(map[string][]int)
+	for i, v := range right {
+		key, err := qGroupKey("q::join", eval, rfn, NewValInt(i), v)
+		if err != nil {
+			return NewValNull(), err
+		}
+		rindex[key] = append(rindex[key], i)
+	}
+
+	o := NewValList()
+	rmatched := make([]bool, len(right))
+
+	for i, v := range left {
+		key, err := qGroupKey("q::join", eval, lfn, NewValInt(i), v)
+		if err != nil {
+			return NewValNull(), err
+		}
+		if ridx, ok := rindex[key]; ok {
+			for _, j := range ridx {
+				rmatched[j] = true
+				o.AddList(NewValPair(v, right[j]))
+			}
+		} else if mode != "inner" {
+			o.AddList(NewValPair(v, NewValNull()))
+		}
+	}
+
+	if mode == "outer" {
+		for j, v := range right {
+			if !rmatched[j] {
+				o.AddList(NewValPair(NewValNull(), v))
+			}
+		}
+	}
+	return o, nil
+}
+
+// ---------------------------------------------------------------------------
+// 9) order statistics
+//
+// Similar to other aggregation functions, non-numeric elements are ignored and
+// null is returned when the list does not have any number.
+
+func qNumbers(l *List) []float64 {
+	o := make([]float64, 0, len(l.Data))
+	for _, v := range l.Data {
+		if v.IsNumber() {
+			o = append(o, qReal(v))
+		}
+	}
+	return o
+}
+
+// quick select, returns the kth smallest element, the input is reordered
+func qSelectKth(x []float64, k int) float64 {
+	lo, hi := 0, len(x)-1
+	for lo < hi {
+		// median of three as pivot to avoid worst case on sorted input
+		mid := lo + (hi-lo)/2
+		if x[mid] < x[lo] {
+			x[mid], x[lo] = x[lo], x[mid]
+		}
+		if x[hi] < x[lo] {
+			x[hi], x[lo] = x[lo], x[hi]
+		}
+		if x[hi] < x[mid] {
+			x[hi], x[mid] = x[mid], x[hi]
+		}
+		pivot := x[mid]
+
+		i, j := lo, hi
+		for i <= j {
+			for x[i] < pivot {
+				i++
+			}
+			for x[j] > pivot {
+				j--
+			}
+			if i <= j {
+				x[i], x[j] = x[j], x[i]
+				i++
+				j--
+			}
+		}
+		if k <= j {
+			hi = j
+		} else if k >= i {
+			lo = i
+		} else {
+			return x[k]
+		}
+	}
+	return x[k]
+}
+
+// percentile with linear interpolation between the closest ranks, p is in
+// range [0, 100]
+func qPercentileOf(x []float64, p float64) float64 {
+	rank := p / 100.0 * float64(len(x)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+
+	lv := qSelectKth(x, lo)
+	if hi == lo {
+		return lv
+	}
+	// after selection, all the elements after lo are larger or equal
+	hv := x[lo+1]
+	for _, v := range x[lo+1:] {
+		if v < hv {
+			hv = v
+		}
+	}
+	return lv + (hv-lv)*(rank-float64(lo))
+}
+
+func qPercentile(
+	info *IntrinsicInfo,
+	_ *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	if _, err := info.Check(args); err != nil {
+		return NewValNull(), err
+	}
+	p := qReal(args[1])
+	if p < 0 || p > 100 {
+		return NewValNull(), fmt.Errorf("q::percentile must be in range [0, 100]")
+	}
+	x := qNumbers(args[0].List())
+	if len(x) == 0 {
+		return NewValNull(), nil
+	}
+	return NewValReal(qPercentileOf(x, p)), nil
+}
+
+func qMedian(
+	info *IntrinsicInfo,
+	_ *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	if _, err := info.Check(args); err != nil {
+		return NewValNull(), err
+	}
+	x := qNumbers(args[0].List())
+	if len(x) == 0 {
+		return NewValNull(), nil
+	}
+	return NewValReal(qPercentileOf(x, 50)), nil
+}
+
+// population standard deviation, computed by math::stddev. Unlike math::stddev,
+// which fails on the non number element and the empty list, the non number
+// elements are skipped and null is returned when no number is left, as the
+// other aggregations of q do
+func qStddev(
+	info *IntrinsicInfo,
+	_ *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	if _, err := info.Check(args); err != nil {
+		return NewValNull(), err
+	}
+	x := qNumbers(args[0].List())
+	if len(x) == 0 {
+		return NewValNull(), nil
+	}
+	return NewValReal(mathStddev(x)), nil
+}
+
+// min heap used by top_k, ordered by qCompare
+type qValHeap []Val
+
+func (h qValHeap) Len() int            { return len(h) }
+func (h qValHeap) Less(i, j int) bool  { return qCompare(h[i], h[j]) < 0 }
+func (h qValHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *qValHeap) Push(x interface{}) { *h = append(*h, x.(Val)) }
+func (h *qValHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// q::top_k(list, k), returns the k largest numbers in descending order
+func qTopK(
+	info *IntrinsicInfo,
+	_ *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	if _, err := info.Check(args); err != nil {
+		return NewValNull(), err
+	}
+	k := int(args[1].Int())
+	if k < 0 {
+		return NewValNull(), fmt.Errorf("q::top_k k must be non-negative")
+	}
+
+	h := &qValHeap{}
+	if k > 0 {
+		for _, v := range args[0].List().Data {
+			if !v.IsNumber() {
+				continue
+			}
+			if h.Len() < k {
+				heap.Push(h, v)
+			} else if qCompare(v, (*h)[0]) > 0 {
+				(*h)[0] = v
+				heap.Fix(h, 0)
+			}
+		}
+	}
+
+	o := make([]Val, h.Len())
+	for i := len(o) - 1; i >= 0; i-- {
+		o[i] = heap.Pop(h).(Val)
+	}
+	return NewValListRaw(o), nil
+}
+
 func init() {
-	addMF("q", "first", "", "{%l}{%p}", qFirst)
-	addMF("q", "last", "", "{%l}{%p}", qLast)
-	addMF("q", "rest", "", "{%l}{%p}", qRest)
-	addMF("q", "select", "", "{%l}{%l%d*}{%m}{%m%s*}", qSelect)
-	addMF("q", "slice", "", "{%l%d}{%l%d%d}{%l%d%d%d}", qSlice)
-	addMF("q", "map", "", "{%l%c}{%m%c}", qMap)
-	addMF("q", "filter", "", "{%l%c}{%m%c}", qFilter)
-	addMF("q", "filter_not", "", "{%l%c}{%m%c}", qFilterNot)
+	addMF("q", "first", "", "{%l}{%p}", qFirst, "q::first(list), the first element, or the first of the pair")
+	addMF("q", "last", "", "{%l}{%p}", qLast, "q::last(list), the last element, or the second of the pair")
+	addMF("q", "rest", "", "{%l}{%p}", qRest, "q::rest(list), the list without its first element")
+	addMF("q", "select", "", "{%l}{%l%d*}{%m}{%m%s*}", qSelect, "q::select(list, index...) or q::select(map, key...), the elements of the indices or keys")
+	addMF("q", "slice", "", "{%l%d}{%l%d%d}{%l%d%d%d}", qSlice, "q::slice(list, start, [end], [step]), the sub list")
+	addMF("q", "map", "", "{%l%c}{%m%c}", qMap, "q::map(list, closure), the list of closure(index, value), or map of closure(key, value)")
+	addMF("q", "filter", "", "{%l%c}{%m%c}", qFilter, "q::filter(list, closure), the elements the closure returns true for")
+	addMF("q", "filter_not", "", "{%l%c}{%m%c}", qFilterNot, "q::filter_not(list, closure), the elements the closure returns false for")
+
+	// ordering
+	addMF("q", "sort", "", "%l", qSort, "q::sort(list), sorts the list in ascending order")
+	addMF("q", "sort_by", "", "%l%c", qSortBy, "q::sort_by(list, closure), sorts the list by the comparator which returns bool or int")
+
+	// grouping
+	addMF("q", "group_by", "", "{%l%c}{%m%c}", qGroupBy, "q::group_by(list, closure), map of the closure result to the list of elements")
+	addMF("q", "count_by", "", "{%l%c}{%m%c}", qCountBy, "q::count_by(list, closure), map of the closure result to the number of elements")
+
+	// structural
+	addMF("q", "zip", "", "{%0}{%a*}", qZip, "q::zip(list...), the list of tuples, as long as the shortest input")
+	addMF("q", "flatten", "", "{(%l|%n)}{(%l|%n)%d}", qFlatten, "q::flatten(list, [depth]), flattens the nested lists, negative depth flattens all")
+	addMF("q", "unique", "", "(%l|%n)", qUnique, "q::unique(list), removes duplicates and keeps the first occurrence")
+	addMF("q", "reverse", "", "(%l|%n)", qReverse, "q::reverse(list), the list in reverse order")
+	addMF("q", "chunk", "", "(%l|%n)%d", qChunk, "q::chunk(list, size), splits the list into lists of size")
+
+	// join
+	addMF("q", "join", "", "{%l%l%c%c}{%l%l%c%c%s}", qJoin, "q::join(left, right, lkey, rkey, [mode]), pairs of the elements whose keys equal, mode is inner, left or outer")
 
 	// aggregation
-	addMF("q", "min", "", "{%l}", qMin)
-	addMF("q", "max", "", "{%l}", qMax)
-	addMF("q", "sum", "", "{%l}", qSum)
-	addMF("q", "count", "", "{%l}", qCount)
-	addMF("q", "avg", "", "{%l}", qAvg)
+	addMF("q", "min", "", "{%l}", qMin, "q::min(list), the smallest element")
+	addMF("q", "max", "", "{%l}", qMax, "q::max(list), the largest element")
+	addMF("q", "sum", "", "{%l}", qSum, "q::sum(list), the sum of the numbers")
+	addMF("q", "count", "", "{%l}", qCount, "q::count(list), the number of elements")
+	addMF("q", "avg", "", "{%l}", qAvg, "q::avg(list), the average of the numbers")
+	addMF("q", "top_k", "", "%l%d", qTopK, "q::top_k(list, k), the k largest elements in descending order")
+	addMF("q", "percentile", "", "%l(%d|%f)", qPercentile, "q::percentile(list, p), the p-th percentile with p in [0, 100]")
+	addMF("q", "median", "", "%l", qMedian, "q::median(list), the median of the numbers")
+	addMF("q", "stddev", "", "%l", qStddev, "q::stddev(list), the population standard deviation of the numbers, the non number elements are skipped and null is returned if there is none")
 }
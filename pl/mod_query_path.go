@@ -0,0 +1,276 @@
+package pl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// deep selection by path, ie q::path(v, "a.b[2].c"). The path is a sequence
+// of segments, each one is one of the following:
+//
+//   1) name or ["quoted name"], selects the field of a map
+//   2) [n], selects the nth element of a list, negative index is relative to
+//      the end of the list
+//   3) * or [*], wildcard which selects all the values of a map or all the
+//      elements of a list
+//
+// Missing field or out of range index simply yields nothing instead of error.
+// When the path contains a wildcard the result is a list of all the selected
+// values, otherwise the single selected value is returned.
+
+const (
+	qPathKey = iota
+	qPathIndex
+	qPathWildcard
+)
+
+type qPathSeg struct {
+	kind  int
+	key   string
+	index int
+}
+
+type qPath struct {
+	seg      []qPathSeg
+	wildcard bool
+}
+
+func parseQPath(path string) (*qPath, error) {
+	p := &qPath{}
+	errf := func(format string, args ...interface{}) error {
+		return fmt.Errorf("invalid path %q: %s", path, fmt.Sprintf(format, args...))
+	}
+
+	idx := 0
+	for idx < len(path) {
+		c := path[idx]
+		switch c {
+		case '.':
+			if idx == 0 || idx+1 == len(path) || path[idx+1] == '.' || path[idx+1] == '[' {
+				return nil, errf("empty segment at %d", idx)
+			}
+			idx++
+
+		case '[':
+			end := strings.IndexByte(path[idx:], ']')
+			if end == -1 {
+				return nil, errf("[ is not closed")
+			}
+			body := strings.TrimSpace(path[idx+1 : idx+end])
+
+			if body == "*" {
+				p.seg = append(p.seg, qPathSeg{kind: qPathWildcard})
+				p.wildcard = true
+			} else if len(body) >= 2 && (body[0] == '"' || body[0] == '\'') &&
+				body[len(body)-1] == body[0] {
+				p.seg = append(p.seg, qPathSeg{kind: qPathKey, key: body[1 : len(body)-1]})
+			} else {
+				i, err := strconv.Atoi(body)
+				if err != nil {
+					return nil, errf("invalid index %q", body)
+				}
+				p.seg = append(p.seg, qPathSeg{kind: qPathIndex, index: i})
+			}
+			idx += end + 1
+
+		default:
+			start := idx
+			for idx < len(path) && path[idx] != '.' && path[idx] != '[' {
+				idx++
+			}
+			name := path[start:idx]
+			if name == "*" {
+				p.seg = append(p.seg, qPathSeg{kind: qPathWildcard})
+				p.wildcard = true
+			} else {
+				p.seg = append(p.seg, qPathSeg{kind: qPathKey, key: name})
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// select all the children of v with the segment
+func (s *qPathSeg) selectOf(v Val, output []Val) []Val {
+	switch s.kind {
+	case qPathKey:
+		if v.IsMap() {
+			if x, ok := v.Map().Get(s.key); ok {
+				output = append(output, x)
+			}
+		}
+
+	case qPathIndex:
+		if v.IsList() {
+			if i, ok := s.listIndex(v.List()); ok {
+				output = append(output, v.List().Data[i])
+			}
+		}
+
+	default:
+		if v.IsMap() {
+			m := v.Map()
+			for _, k := range m.Keys() {
+				x, _ := m.Get(k)
+				output = append(output, x)
+			}
+		} else if v.IsList() {
+			output = append(output, v.List().Data...)
+		}
+	}
+
+	return output
+}
+
+func (s *qPathSeg) listIndex(l *List) (int, bool) {
+	i := s.index
+	if i < 0 {
+		i += l.Length()
+	}
+	if i < 0 || i >= l.Length() {
+		return 0, false
+	}
+	return i, true
+}
+
+func (p *qPath) get(v Val) []Val {
+	cur := []Val{v}
+	for i := range p.seg {
+		var next []Val
+		for _, x := range cur {
+			next = p.seg[i].selectOf(x, next)
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		cur = next
+	}
+	return cur
+}
+
+// set the value at the end of the path, missing intermediate map field is
+// created automatically. Returns the number of assignments been performed
+// check is invoked with each container before it is modified
+func (p *qPath) set(v Val, val Val, check func(Val) error) (int, error) {
+	if len(p.seg) == 0 {
+		return 0, fmt.Errorf("empty path cannot be assigned")
+	}
+
+	cur := []Val{v}
+	for i := range p.seg[:len(p.seg)-1] {
+		s := &p.seg[i]
+		var next []Val
+		for _, x := range cur {
+			if s.kind == qPathKey && x.IsMap() && !x.Map().Has(s.key) {
+				if err := check(x); err != nil {
+					return 0, err
+				}
+				child := NewValMap()
+				x.Map().Set(s.key, child)
+				next = append(next, child)
+			} else {
+				next = s.selectOf(x, next)
+			}
+		}
+		cur = next
+	}
+
+	cnt := 0
+	last := &p.seg[len(p.seg)-1]
+	for _, x := range cur {
+		if x.IsMap() || x.IsList() {
+			if err := check(x); err != nil {
+				return cnt, err
+			}
+		}
+		switch last.kind {
+		case qPathKey:
+			if x.IsMap() {
+				x.Map().Set(last.key, val)
+				cnt++
+			}
+
+		case qPathIndex:
+			if x.IsList() {
+				i, ok := last.listIndex(x.List())
+				if !ok {
+					return cnt, fmt.Errorf("index %d out of range", last.index)
+				}
+				x.List().Data[i] = val
+				cnt++
+			}
+
+		default:
+			if x.IsMap() {
+				m := x.Map()
+				for _, k := range m.Keys() {
+					m.Set(k, val)
+					cnt++
+				}
+			} else if x.IsList() {
+				l := x.List()
+				for i := range l.Data {
+					l.Data[i] = val
+					cnt++
+				}
+			}
+		}
+	}
+	return cnt, nil
+}
+
+func qPathGet(
+	info *IntrinsicInfo,
+	_ *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	if _, err := info.Check(args); err != nil {
+		return NewValNull(), err
+	}
+	path, err := parseQPath(args[1].String())
+	if err != nil {
+		return NewValNull(), fmt.Errorf("q::path: %s", err.Error())
+	}
+
+	def := NewValNull()
+	if len(args) == 3 {
+		def = args[2]
+	}
+
+	r := path.get(args[0])
+	if path.wildcard {
+		return NewValListRaw(r), nil
+	}
+	if len(r) == 0 {
+		return def, nil
+	}
+	return r[0], nil
+}
+
+func qPathSet(
+	info *IntrinsicInfo,
+	eval *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	if _, err := info.Check(args); err != nil {
+		return NewValNull(), err
+	}
+	path, err := parseQPath(args[1].String())
+	if err != nil {
+		return NewValNull(), fmt.Errorf("q::path_set: %s", err.Error())
+	}
+	cnt, err := path.set(args[0], args[2], eval.checkMutable)
+	if err != nil {
+		return NewValNull(), fmt.Errorf("q::path_set: %s", err.Error())
+	}
+	return NewValInt(cnt), nil
+}
+
+func init() {
+	addMF("q", "path", "", "{%a%s}{%a%s%a}", qPathGet, "q::path(v, path, [default]), selects the values by path, ie \"a.b[2].c\"")
+	addMF("q", "path_set", "", "%a%s%a", qPathSet, "q::path_set(v, path, value), sets the value by path")
+}
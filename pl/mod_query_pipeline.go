@@ -0,0 +1,324 @@
+package pl
+
+import (
+	"fmt"
+)
+
+// lazy query pipeline, created by q::from. Each stage method returns a new
+// pipeline and nothing is evaluated until the pipeline is iterated or the
+// to_list method is invoked. Elements are pulled from the source one by one
+// via the iterator protocol, so no intermediate list is materialized.
+
+const (
+	QPipelineTypeId = ".q_pipeline"
+)
+
+const (
+	qStageMap = iota
+	qStageFilter
+	qStageTake
+	qStageSkip
+)
+
+var (
+	mpQPipelineMap    = MustNewFuncProto(".q_pipeline.map", "%c")
+	mpQPipelineFilter = MustNewFuncProto(".q_pipeline.filter", "%c")
+	mpQPipelineTake   = MustNewFuncProto(".q_pipeline.take", "%u")
+	mpQPipelineSkip   = MustNewFuncProto(".q_pipeline.skip", "%u")
+	mpQPipelineToList = MustNewFuncProto(".q_pipeline.to_list", "%0")
+)
+
+type qStage struct {
+	kind int
+	fn   Closure
+	n    int
+}
+
+type qPipeline struct {
+	eval   *Evaluator
+	src    Val
+	stages []qStage
+}
+
+type qPipelineIter struct {
+	p      *qPipeline
+	src    Iter
+	counts []int
+
+	primed bool
+	done   bool
+	has    bool
+	key    Val
+	val    Val
+}
+
+func (p *qPipeline) with(s qStage) Val {
+	stages := make([]qStage, 0, len(p.stages)+1)
+	stages = append(stages, p.stages...)
+	stages = append(stages, s)
+	return NewValUsr(&qPipeline{
+		eval:   p.eval,
+		src:    p.src,
+		stages: stages,
+	})
+}
+
+func (p *qPipeline) newIter() (*qPipelineIter, error) {
+	var src Iter
+	if p.src.IsIter() {
+		// script iterator, ie anonymous iterator, is not setup yet
+		src = p.src.Iter()
+		if err := src.SetUp(p.eval, nil); err != nil {
+			return nil, err
+		}
+	} else {
+		x, err := p.src.NewIterator()
+		if err != nil {
+			return nil, err
+		}
+		src = x
+	}
+
+	itr := &qPipelineIter{
+		p:      p,
+		src:    src,
+		counts: make([]int, len(p.stages)),
+	}
+	if err := itr.fetch(); err != nil {
+		return nil, err
+	}
+	return itr, nil
+}
+
+// pull element from the source until one element passes all the stages or
+// the source is exhausted
+func (q *qPipelineIter) fetch() error {
+	q.has = false
+
+	for !q.done {
+		if q.primed {
+			if _, err := q.src.Next(); err != nil {
+				return err
+			}
+		}
+		q.primed = true
+
+		if !q.src.Has() {
+			q.done = true
+			break
+		}
+		k, v, err := q.src.Deref()
+		if err != nil {
+			return err
+		}
+
+		pass, err := q.apply(k, &v)
+		if err != nil {
+			return err
+		}
+		if pass {
+			q.has = true
+			q.key = k
+			q.val = v
+			break
+		}
+	}
+
+	return nil
+}
+
+// the pipeline can be iterated by for loop directly, which invokes the stage
+// callback outside of any native call, see nativeFrameCall
+func (q *qPipelineIter) call(fn Closure, k Val, v Val) (Val, error) {
+	return q.p.eval.nativeFrameCall(
+		func() (Val, error) {
+			return fn.Call(q.p.eval, []Val{k, v})
+		},
+	)
+}
+
+func (q *qPipelineIter) apply(k Val, v *Val) (bool, error) {
+	for idx, s := range q.p.stages {
+		switch s.kind {
+		case qStageMap:
+			nv, err := q.call(s.fn, k, *v)
+			if err != nil {
+				return false, err
+			}
+			*v = nv
+
+		case qStageFilter:
+			r, err := q.call(s.fn, k, *v)
+			if err != nil {
+				return false, err
+			}
+			if !r.IsBool() {
+				return false, fmt.Errorf("q::from filter callback function must return bool")
+			}
+			if !r.Bool() {
+				return false, nil
+			}
+
+		case qStageSkip:
+			if q.counts[idx] < s.n {
+				q.counts[idx]++
+				return false, nil
+			}
+
+		default:
+			if q.counts[idx] >= s.n {
+				q.done = true
+				return false, nil
+			}
+			// once the limit is reached, nothing can pass this stage anymore, so
+			// stop pulling from the source after the current element
+			q.counts[idx]++
+			if q.counts[idx] == s.n {
+				q.done = true
+			}
+		}
+	}
+	return true, nil
+}
+
+func (q *qPipelineIter) SetUp(_ *Evaluator, _ []Val) error {
+	return nil
+}
+
+func (q *qPipelineIter) Has() bool {
+	return q.has
+}
+
+func (q *qPipelineIter) Next() (bool, error) {
+	if err := q.fetch(); err != nil {
+		return false, err
+	}
+	return q.has, nil
+}
+
+func (q *qPipelineIter) Deref() (Val, Val, error) {
+	if !q.has {
+		return NewValNull(), NewValNull(), fmt.Errorf("iterator out of bound")
+	}
+	return q.key, q.val, nil
+}
+
+func (p *qPipeline) Index(_ Val) (Val, error) {
+	return NewValNull(), fmt.Errorf("type: %s does not support index", p.Id())
+}
+
+func (p *qPipeline) IndexSet(_ Val, _ Val) error {
+	return fmt.Errorf("type: %s does not support index set", p.Id())
+}
+
+func (p *qPipeline) Dot(_ string) (Val, error) {
+	return NewValNull(), fmt.Errorf("type: %s does not support dot", p.Id())
+}
+
+func (p *qPipeline) DotSet(_ string, _ Val) error {
+	return fmt.Errorf("type: %s does not support dot set", p.Id())
+}
+
+func (p *qPipeline) Method(name string, args []Val) (Val, error) {
+	switch name {
+	case "map":
+		if _, err := mpQPipelineMap.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		return p.with(qStage{kind: qStageMap, fn: args[0].Closure()}), nil
+
+	case "filter":
+		if _, err := mpQPipelineFilter.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		return p.with(qStage{kind: qStageFilter, fn: args[0].Closure()}), nil
+
+	case "take":
+		if _, err := mpQPipelineTake.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		return p.with(qStage{kind: qStageTake, n: int(args[0].Int())}), nil
+
+	case "skip":
+		if _, err := mpQPipelineSkip.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		return p.with(qStage{kind: qStageSkip, n: int(args[0].Int())}), nil
+
+	case "to_list":
+		if _, err := mpQPipelineToList.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		itr, err := p.newIter()
+		if err != nil {
+			return NewValNull(), err
+		}
+		o := NewValList()
+		for itr.Has() {
+			o.AddList(itr.val)
+			if _, err := itr.Next(); err != nil {
+				return NewValNull(), err
+			}
+		}
+		return o, nil
+
+	default:
+		return NewValNull(), fmt.Errorf("%s method: %s is unknown", p.Id(), name)
+	}
+}
+
+func (p *qPipeline) ToString() (string, error) {
+	return fmt.Sprintf("[%s: %d stages]", p.Id(), len(p.stages)), nil
+}
+
+func (p *qPipeline) ToJSON() (Val, error) {
+	return MarshalVal(
+		map[string]interface{}{
+			"type":   p.Id(),
+			"stages": len(p.stages),
+		},
+	)
+}
+
+func (p *qPipeline) Id() string {
+	return QPipelineTypeId
+}
+
+func (p *qPipeline) Info() string {
+	return p.Id()
+}
+
+func (p *qPipeline) IsThreadSafe() bool {
+	return false
+}
+
+func (p *qPipeline) NewIterator() (Iter, error) {
+	return p.newIter()
+}
+
+func qFrom(
+	info *IntrinsicInfo,
+	eval *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	if _, err := info.Check(args); err != nil {
+		return NewValNull(), err
+	}
+	src := args[0]
+	switch src.Type {
+	case ValStr, ValList, ValMap, ValPair, ValIter, ValUsr:
+		break
+	default:
+		return NewValNull(), fmt.Errorf("q::from: type %s is not iterable", src.Id())
+	}
+
+	return NewValUsr(&qPipeline{
+		eval: eval,
+		src:  src,
+	}), nil
+}
+
+func init() {
+	addMF("q", "from", "", "%a", qFrom, "q::from(v), the lazy query pipeline of the iterable")
+}
@@ -0,0 +1,131 @@
+package pl
+
+import (
+	"fmt"
+	"sync"
+)
+
+// q::pmap(list, closure, [{workers: N}]), evaluates the closure against each
+// element of the list in parallel on a pool of evaluators and returns the
+// result list in the same order as input. The callback has the same signature
+// as q::map, ie (index, value).
+//
+// Since the closure is shared among workers, it must be a script closure and
+// free of shared mutable state, ie all its captured upvalues must be thread
+// safe and neither it nor the functions it calls is allowed to modify upvalue,
+// session, global or dynamic variable. This is validated before any evaluation
+// happens, and the workers reject such write of the closure only known at
+// runtime.
+
+const (
+	qPMapDefaultWorkers = 4
+	qPMapMaxWorkers     = 256
+)
+
+func qPMapValidate(fn Closure) (*scriptFunc, error) {
+	sfunc, ok := fn.(*scriptFunc)
+	if !ok {
+		return nil, fmt.Errorf("q::pmap callback must be script closure")
+	}
+	if err := sfunc.checkShareable(); err != nil {
+		return nil, fmt.Errorf("q::pmap callback %s", err.Error())
+	}
+	return sfunc, nil
+}
+
+func qPMapWorkers(opt Val) (int, error) {
+	workers := qPMapDefaultWorkers
+	if opt.IsMap() {
+		if v, ok := opt.Map().Get("workers"); ok {
+			if !v.IsInt() {
+				return 0, fmt.Errorf("q::pmap workers must be int")
+			}
+			workers = int(v.Int())
+		}
+	}
+	if workers <= 0 || workers > qPMapMaxWorkers {
+		return 0, fmt.Errorf("q::pmap workers must be in range [1, %d]", qPMapMaxWorkers)
+	}
+	return workers, nil
+}
+
+// worker evaluator, shares the context, config, session and cleanup list of the
+// parent evaluator. The session is only read by the callback, and the context, which
+// is not thread safe, is accessed under the lock shared by the workers
+func (e *Evaluator) newWorker(ctx EvalContext, base map[interface{}]bool) *Evaluator {
+	w := NewEvaluator(ctx, e.Config)
+	w.Session = e.Session
+	w.capability = e.capability
+	w.policy = e.policy
+	w.cleanup = e.cleanup
+	w.setShared(base)
+	return w
+}
+
+func qPMap(
+	info *IntrinsicInfo,
+	eval *Evaluator,
+	_ string,
+	args []Val,
+) (Val, error) {
+	alog, err := info.Check(args)
+	if err != nil {
+		return NewValNull(), err
+	}
+
+	sfunc, err := qPMapValidate(args[1].Closure())
+	if err != nil {
+		return NewValNull(), err
+	}
+
+	workers := qPMapDefaultWorkers
+	if alog == 3 {
+		if workers, err = qPMapWorkers(args[2]); err != nil {
+			return NewValNull(), err
+		}
+	}
+
+	input := args[0].List().Data
+	if len(input) < workers {
+		workers = len(input)
+	}
+
+	output := make([]Val, len(input))
+	errs := make([]error, len(input))
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+
+	// the elements may alias each other, so all of them are shared
+	base := make(map[interface{}]bool)
+	markShared(base, args[0])
+	ctx := newLockedEvalContext(eval.Context)
+
+	for i := 0; i < workers; i++ {
+		w := eval.newWorker(ctx, base)
+
+		wg.Add(1)
+		go func(w *Evaluator) {
+			defer wg.Done()
+			for idx := range jobs {
+				output[idx], errs[idx] = sfunc.Call(w, []Val{NewValInt(idx), input[idx]})
+			}
+		}(w)
+	}
+
+	for idx := range input {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	for idx, err := range errs {
+		if err != nil {
+			return NewValNull(), fmt.Errorf("q::pmap element(%d): %s", idx, err.Error())
+		}
+	}
+	return NewValListRaw(output), nil
+}
+
+func init() {
+	addMF("q", "pmap", "", "{%l%c}{%l%c%m}", qPMap, "q::pmap(list, closure, [options]), q::map evaluated in parallel, options.workers sets the pool size")
+}
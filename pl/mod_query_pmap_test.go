@@ -0,0 +1,209 @@
+package pl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// run with go test -race, the rejected callbacks would race on the shared
+// state otherwise
+func TestPMapShareable(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(testInt(`
+fn square(v) {
+  return v * v;
+}
+test {
+  let l = [1, 2, 3, 4, 5, 6, 7, 8];
+  output => (l | q::pmap(fn(k, v): square(v)))[7];
+}
+`, 64))
+
+	for _, code := range []string{
+		// session variable written by the function called
+		`
+session {
+  cnt = 0;
+}
+fn bump(v) {
+  cnt += 1;
+  return v;
+}
+test {
+  output => [1, 2, 3, 4] | q::pmap(fn(k, v): bump(v));
+}
+`,
+		// transitively
+		`
+session {
+  cnt = 0;
+}
+fn bump(v) {
+  cnt += 1;
+  return v;
+}
+fn call(v) {
+  return bump(v);
+}
+test {
+  output => [1, 2, 3, 4] | q::pmap(fn(k, v): call(v));
+}
+`,
+		// dynamic variable of the context
+		`
+test {
+  output => [1, 2, 3, 4] | q::pmap(fn(k, v) {
+    dyn = v;
+    return v;
+  });
+}
+`,
+		// nested closure modifying the captured upvalue
+		`
+test {
+  output => [1, 2, 3, 4] | q::pmap(fn(k, v) {
+    let f = fn() {
+      v = v + 1;
+      return v;
+    };
+    return f();
+  });
+}
+`,
+		// closure only known at runtime
+		`
+session {
+  cnt = 0;
+  inc = null;
+}
+fn bump() {
+  cnt += 1;
+  return cnt;
+}
+test {
+  inc = bump;
+  output => [1, 2, 3, 4] | q::pmap(fn(k, v): inc());
+}
+`,
+	} {
+		_, ok := test(code)
+		assert.False(ok, code)
+	}
+}
+
+// run with go test -race, mutating the containers shared with the parent
+// evaluator or among the workers must be rejected instead of racing
+func TestPMapSharedMutation(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, code := range []string{
+		// session map, by index, dot and method
+		`
+session {
+  m = {};
+}
+test {
+  output => [1, 2, 3, 4] | q::pmap(fn(k, v) {
+    m[to_string(v)] = v;
+    return v;
+  });
+}
+`,
+		`
+session {
+  m = {};
+}
+test {
+  output => [1, 2, 3, 4] | q::pmap(fn(k, v) {
+    m.x = v;
+    return v;
+  });
+}
+`,
+		`
+session {
+  m = {};
+}
+test {
+  output => [1, 2, 3, 4] | q::pmap(fn(k, v) {
+    m:set("x", v);
+    return v;
+  });
+}
+`,
+		// nested container of the session
+		`
+session {
+  m = {"l": []};
+}
+test {
+  output => [1, 2, 3, 4] | q::pmap(fn(k, v) {
+    m.l:push_back(v);
+    return v;
+  });
+}
+`,
+		// slice shares the storage of the session list
+		`
+session {
+  l = [1, 2, 3, 4];
+}
+test {
+  output => [1, 2, 3, 4] | q::pmap(fn(k, v) {
+    let s = l:slice(0, 1);
+    s:push_back(v);
+    return v;
+  });
+}
+`,
+		// the elements alias each other
+		`
+test {
+  let x = {};
+  output => [x, x, x, x] | q::pmap(fn(k, v) {
+    v.a = k;
+    return k;
+  });
+}
+`,
+		// intrinsic modifying its argument
+		`
+session {
+  m = {};
+}
+test {
+  output => [1, 2, 3, 4] | q::pmap(fn(k, v): q::path_set(m, "a.b", v));
+}
+`,
+	} {
+		_, ok := test(code)
+		assert.False(ok, code)
+	}
+
+	// reading the shared containers, including the cyclic one, and modifying
+	// the containers created by the callback are fine
+	v, ok := test(`
+session {
+  m = {"a": 1, "l": [1, 2]};
+}
+test {
+  m.self = m;
+  output => [1, 2, 3, 4] | q::pmap(fn(k, v) {
+    let r = {"v": v + m.a + m.self.l[1] + m.l:length()};
+    r.k = k;
+    r:set("x", m:get("a"));
+    let l = m.l:slice(0, 1);
+    return r.v + r.x + l[0];
+  });
+}
+`)
+	if assert.True(ok) {
+		out := []int64{}
+		for _, x := range v.List().Data {
+			out = append(out, x.Int())
+		}
+		assert.Equal([]int64{8, 9, 10, 11}, out)
+	}
+}
@@ -13,6 +13,7 @@ func init() {
 		"",
 		"%0",
 		rand.Float64,
+		"rand::real(), random real in [0, 1)",
 	)
 
 	addrefMF(
@@ -21,6 +22,7 @@ func init() {
 		"",
 		"%0",
 		rand.Int63,
+		"rand::int63(), random non-negative int",
 	)
 
 	addrefMF(
@@ -29,6 +31,7 @@ func init() {
 		"",
 		"%d",
 		util.RandomString,
+		"rand::str(n), random string of length n",
 	)
 
 	addrefMF(
@@ -37,5 +40,6 @@ func init() {
 		"",
 		"%0",
 		uuid.NewString,
+		"rand::uuid(), random UUID string",
 	)
 }
@@ -1,18 +1,38 @@
 package pl
 
 import (
+	"fmt"
 	"regexp"
+	"strings"
 )
 
 // heck, go's regexp library has too many crap
 
 func init() {
-	addrefMF(
+	// regexp::new reuses the regexp literal compiled by the module if the
+	// pattern is identical
+	addMF(
 		"regexp",
 		"new",
 		"",
 		"%s",
-		regexp.Compile,
+		func(info *IntrinsicInfo, e *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			pattern := args[0].String()
+			if m := e.curModule(); m != nil {
+				if rexp := m.cachedRegexp(pattern); rexp != nil {
+					return NewValRegexp(rexp), nil
+				}
+			}
+			rexp, err := regexp.Compile(pattern)
+			if err != nil {
+				return NewValNull(), fmt.Errorf("regexp::new: %s", err.Error())
+			}
+			return NewValRegexp(rexp), nil
+		},
+		"regexp::new(pattern), compiles the regexp",
 	)
 	addrefMF(
 		"regexp",
@@ -22,6 +42,7 @@ func init() {
 		func(r *regexp.Regexp, b string) string {
 			return string(r.Find([]byte(b)))
 		},
+		"regexp::find(re, s), the leftmost match",
 	)
 
 	valFromIAA := func(iaa [][]int) Val {
@@ -46,6 +67,7 @@ func init() {
 			}
 			return rr
 		},
+		"regexp::find_all(re, s, n), the successive matches, at most n if n >= 0",
 	)
 
 	addrefMF(
@@ -57,6 +79,7 @@ func init() {
 			x := r.FindAllIndex([]byte(b), n)
 			return valFromIAA(x)
 		},
+		"regexp::find_all_index(re, s, n), the ranges of the successive matches",
 	)
 
 	addrefMF(
@@ -67,6 +90,7 @@ func init() {
 		func(r *regexp.Regexp, b string) string {
 			return r.FindString(b)
 		},
+		"regexp::find_string(re, s), the leftmost match",
 	)
 
 	addrefMF(
@@ -77,6 +101,7 @@ func init() {
 		func(r *regexp.Regexp, b string) Val {
 			return NewValIntList(r.FindStringIndex(b))
 		},
+		"regexp::find_string_index(re, s), the range of the leftmost match",
 	)
 
 	addrefMF(
@@ -87,6 +112,7 @@ func init() {
 		func(r *regexp.Regexp, b string) bool {
 			return r.Match([]byte(b))
 		},
+		"regexp::match(re, s), whether the string contains a match",
 	)
 
 	addrefMF(
@@ -97,6 +123,7 @@ func init() {
 		func(r *regexp.Regexp, b string) bool {
 			return r.MatchString(b)
 		},
+		"regexp::match_string(re, s), whether the string contains a match",
 	)
 
 	addrefMF(
@@ -107,5 +134,121 @@ func init() {
 		func(r *regexp.Regexp, a, b string) string {
 			return string(r.ReplaceAll([]byte(a), []byte(b)))
 		},
+		"regexp::replace_all(re, s, repl), replaces the matches, repl can refer to groups by $n",
+	)
+
+	// submatch helper, returns null when nothing is matched
+	valFromSubmatch := func(x []string) Val {
+		if x == nil {
+			return NewValNull()
+		}
+		return NewValStrList(x)
+	}
+
+	addrefMF(
+		"regexp",
+		"find_submatch",
+		"",
+		"%r%s",
+		func(r *regexp.Regexp, b string) Val {
+			return valFromSubmatch(r.FindStringSubmatch(b))
+		},
+		"regexp::find_submatch(re, s), the leftmost match and its capture groups",
+	)
+
+	addrefMF(
+		"regexp",
+		"find_all_submatch",
+		"",
+		"%r%s%d",
+		func(r *regexp.Regexp, b string, n int) Val {
+			rr := NewValList()
+			for _, y := range r.FindAllStringSubmatch(b, n) {
+				rr.AddList(valFromSubmatch(y))
+			}
+			return rr
+		},
+		"regexp::find_all_submatch(re, s, n), the successive matches with their capture groups",
+	)
+
+	// named capture groups returned as a map, unmatched groups are set to empty
+	// string and null is returned if nothing is matched
+	addrefMF(
+		"regexp",
+		"find_named",
+		"",
+		"%r%s",
+		func(r *regexp.Regexp, b string) Val {
+			x := r.FindStringSubmatch(b)
+			if x == nil {
+				return NewValNull()
+			}
+			o := NewValMap()
+			for i, name := range r.SubexpNames() {
+				if name != "" {
+					o.AddMap(name, NewValStr(x[i]))
+				}
+			}
+			return o
+		},
+		"regexp::find_named(re, s), map of the named capture groups, null if nothing matches",
+	)
+
+	addrefMF(
+		"regexp",
+		"split",
+		"",
+		"%r%s%d",
+		func(r *regexp.Regexp, b string, n int) Val {
+			return NewValStrList(r.Split(b, n))
+		},
+		"regexp::split(re, s, n), splits the string by the matches",
+	)
+
+	// replace_func(re, s, closure), the closure is invoked with the matched
+	// string and the list of its capture groups, and it must return a string
+	// which is used as replacement
+	addMF(
+		"regexp",
+		"replace_func",
+		"",
+		"%r%s%c",
+		func(info *IntrinsicInfo, e *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			r := args[0].Regexp()
+			input := args[1].String()
+			closure := args[2].Closure()
+
+			buf := new(strings.Builder)
+			last := 0
+			for _, loc := range r.FindAllStringSubmatchIndex(input, -1) {
+				groups := make([]string, len(loc)/2)
+				for i := range groups {
+					if loc[2*i] >= 0 {
+						groups[i] = input[loc[2*i]:loc[2*i+1]]
+					}
+				}
+				cbArgs := []Val{
+					NewValStr(groups[0]),
+					NewValStrList(groups),
+				}
+				v, err := closure.Call(e, cbArgs)
+				if err != nil {
+					return NewValNull(), err
+				}
+				str, err := v.ToString()
+				if err != nil {
+					return NewValNull(), fmt.Errorf("regexp::replace_func: %s", err.Error())
+				}
+				buf.WriteString(input[last:loc[0]])
+				buf.WriteString(str)
+				last = loc[1]
+			}
+			buf.WriteString(input[last:])
+			return NewValStr(buf.String()), nil
+		},
+		"regexp::replace_func(re, s, closure), replaces each match with closure(match, groups)",
 	)
 }
@@ -0,0 +1,860 @@
+package pl
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSON schema validation. The schema is compiled once, typically at config
+// time, by schema::compile and the compiled schema can be used to validate
+// values many times. Validation never stops at the first failure, instead all
+// the violations are collected as a list of map with following fields:
+//
+//   1) path, the location of the violation, the syntax is same as q::path
+//   2) keyword, the schema keyword been violated
+//   3) message, human readable message
+//
+// Supported keywords are type, enum, const, properties, required,
+// additionalProperties, minProperties, maxProperties, items, minItems,
+// maxItems, uniqueItems, minLength, maxLength, pattern, minimum, maximum,
+// exclusiveMinimum, exclusiveMaximum, multipleOf, allOf, anyOf, oneOf, not
+// and local $ref, ie "#", "#/definitions/x" or "#/$defs/x".
+
+const (
+	SchemaTypeId = ".schema"
+
+	// max nesting of the schema applied during validation, a recursive schema
+	// could otherwise walk a deeply nested value until the go stack overflows
+	schemaMaxDepth = 256
+)
+
+var (
+	mpSchemaValidate = MustNewFuncProto(".schema.validate", "%a")
+	mpSchemaIsValid  = MustNewFuncProto(".schema.is_valid", "%a")
+)
+
+func IsValSchema(v Val) bool {
+	return v.Id() == SchemaTypeId
+}
+
+type schemaError struct {
+	path    string
+	keyword string
+	message string
+}
+
+type schemaNode struct {
+	// boolean schema, true accepts everything and false rejects everything
+	reject bool
+
+	types []string
+	enum  []Val
+	cnst  *Val
+
+	properties    map[string]*schemaNode
+	propertyOrder []string
+	required      []string
+	additional    *schemaNode
+	minProperties int
+	maxProperties int
+	items         *schemaNode
+	minItems      int
+	maxItems      int
+	uniqueItems   bool
+	minLength     int
+	maxLength     int
+	pattern       *regexp.Regexp
+	minimum       *float64
+	maximum       *float64
+	exclusiveMin  *float64
+	exclusiveMax  *float64
+	multipleOf    *float64
+	allOf         []*schemaNode
+	anyOf         []*schemaNode
+	oneOf         []*schemaNode
+	not           *schemaNode
+	ref           *schemaNode
+
+	// location of the schema, ie #.properties.a, used by compile error
+	where string
+}
+
+type schemaCompiler struct {
+	root Val
+	refs map[string]*schemaNode
+}
+
+type Schema struct {
+	source Val
+	root   *schemaNode
+}
+
+func schemaTypeName(v Val) string {
+	switch v.Type {
+	case ValNull:
+		return "null"
+	case ValBool:
+		return "boolean"
+	case ValInt:
+		return "integer"
+	case ValReal:
+		return "number"
+	case ValStr:
+		return "string"
+	case ValList:
+		return "array"
+	case ValMap:
+		return "object"
+	default:
+		return v.Id()
+	}
+}
+
+func schemaIsType(v Val, t string) bool {
+	switch t {
+	case "null":
+		return v.IsNull()
+	case "boolean":
+		return v.IsBool()
+	case "integer":
+		return v.IsInt() || (v.IsReal() && v.Real() == math.Trunc(v.Real()))
+	case "number":
+		return v.IsInt() || v.IsReal()
+	case "string":
+		return v.IsString()
+	case "array":
+		return v.IsList()
+	case "object":
+		return v.IsMap()
+	default:
+		return false
+	}
+}
+
+func schemaReal(v Val) (float64, bool) {
+	switch v.Type {
+	case ValInt:
+		return float64(v.Int()), true
+	case ValReal:
+		return v.Real(), true
+	default:
+		return 0, false
+	}
+}
+
+func schemaJoinKey(path, key string) string {
+	if strings.ContainsAny(key, ".[]") {
+		return fmt.Sprintf("%s['%s']", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func schemaJoinIndex(path string, idx int) string {
+	return fmt.Sprintf("%s[%d]", path, idx)
+}
+
+// compiler ------------------------------------------------------------------
+
+func (c *schemaCompiler) resolve(ref string) (*schemaNode, error) {
+	if n, ok := c.refs[ref]; ok {
+		return n, nil
+	}
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("$ref %s is not supported, only local reference is allowed", ref)
+	}
+
+	target := c.root
+	for _, seg := range strings.Split(strings.TrimPrefix(ref[1:], "/"), "/") {
+		if seg == "" {
+			continue
+		}
+		seg = strings.ReplaceAll(strings.ReplaceAll(seg, "~1", "/"), "~0", "~")
+		if !target.IsMap() {
+			return nil, fmt.Errorf("$ref %s cannot be resolved", ref)
+		}
+		x, ok := target.Map().Get(seg)
+		if !ok {
+			return nil, fmt.Errorf("$ref %s cannot be resolved", ref)
+		}
+		target = x
+	}
+
+	// register the node before compiling, so recursive reference works
+	n := &schemaNode{}
+	c.refs[ref] = n
+	if err := c.compileInto(n, target, ref); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (c *schemaCompiler) compile(v Val, where string) (*schemaNode, error) {
+	n := &schemaNode{}
+	if err := c.compileInto(n, v, where); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (c *schemaCompiler) compileList(v Val, where string) ([]*schemaNode, error) {
+	if !v.IsList() || v.List().Length() == 0 {
+		return nil, fmt.Errorf("%s must be a none empty list of schema", where)
+	}
+	var o []*schemaNode
+	for i, x := range v.List().Data {
+		n, err := c.compile(x, schemaJoinIndex(where, i))
+		if err != nil {
+			return nil, err
+		}
+		o = append(o, n)
+	}
+	return o, nil
+}
+
+func (c *schemaCompiler) compileInto(n *schemaNode, v Val, where string) error {
+	n.where = where
+	if v.IsBool() {
+		n.reject = !v.Bool()
+		n.minProperties, n.maxProperties = -1, -1
+		n.minItems, n.maxItems = -1, -1
+		n.minLength, n.maxLength = -1, -1
+		return nil
+	}
+	if !v.IsMap() {
+		return fmt.Errorf("%s: schema must be a map or boolean", where)
+	}
+
+	m := v.Map()
+
+	count := func(key string, out *int) error {
+		x, ok := m.Get(key)
+		if !ok {
+			*out = -1
+			return nil
+		}
+		if !x.IsInt() || x.Int() < 0 {
+			return fmt.Errorf("%s: %s must be none negative integer", where, key)
+		}
+		*out = int(x.Int())
+		return nil
+	}
+
+	number := func(key string, out **float64) error {
+		x, ok := m.Get(key)
+		if !ok {
+			return nil
+		}
+		r, ok := schemaReal(x)
+		if !ok {
+			return fmt.Errorf("%s: %s must be number", where, key)
+		}
+		*out = &r
+		return nil
+	}
+
+	if x, ok := m.Get("$ref"); ok {
+		if !x.IsString() {
+			return fmt.Errorf("%s: $ref must be string", where)
+		}
+		ref, err := c.resolve(x.String())
+		if err != nil {
+			return fmt.Errorf("%s: %s", where, err.Error())
+		}
+		n.ref = ref
+	}
+
+	if x, ok := m.Get("type"); ok {
+		var types []string
+		if x.IsString() {
+			types = []string{x.String()}
+		} else if x.IsList() {
+			for _, t := range x.List().Data {
+				if !t.IsString() {
+					return fmt.Errorf("%s: type must be string or list of string", where)
+				}
+				types = append(types, t.String())
+			}
+		} else {
+			return fmt.Errorf("%s: type must be string or list of string", where)
+		}
+		for _, t := range types {
+			switch t {
+			case "null", "boolean", "integer", "number", "string", "array", "object":
+				break
+			default:
+				return fmt.Errorf("%s: unknown type %s", where, t)
+			}
+		}
+		n.types = types
+	}
+
+	if x, ok := m.Get("enum"); ok {
+		if !x.IsList() {
+			return fmt.Errorf("%s: enum must be list", where)
+		}
+		n.enum = x.List().Data
+	}
+
+	if x, ok := m.Get("const"); ok {
+		n.cnst = &x
+	}
+
+	if x, ok := m.Get("properties"); ok {
+		if !x.IsMap() {
+			return fmt.Errorf("%s: properties must be map", where)
+		}
+		n.properties = make(map[string]*schemaNode)
+		n.propertyOrder = x.Map().Keys()
+		sort.Strings(n.propertyOrder)
+		for _, k := range n.propertyOrder {
+			pv, _ := x.Map().Get(k)
+			pn, err := c.compile(pv, schemaJoinKey(where+".properties", k))
+			if err != nil {
+				return err
+			}
+			n.properties[k] = pn
+		}
+	}
+
+	if x, ok := m.Get("required"); ok {
+		if !x.IsList() {
+			return fmt.Errorf("%s: required must be list of string", where)
+		}
+		for _, k := range x.List().Data {
+			if !k.IsString() {
+				return fmt.Errorf("%s: required must be list of string", where)
+			}
+			n.required = append(n.required, k.String())
+		}
+	}
+
+	if x, ok := m.Get("additionalProperties"); ok {
+		an, err := c.compile(x, where+".additionalProperties")
+		if err != nil {
+			return err
+		}
+		n.additional = an
+	}
+
+	if x, ok := m.Get("items"); ok {
+		in, err := c.compile(x, where+".items")
+		if err != nil {
+			return err
+		}
+		n.items = in
+	}
+
+	if x, ok := m.Get("uniqueItems"); ok {
+		if !x.IsBool() {
+			return fmt.Errorf("%s: uniqueItems must be boolean", where)
+		}
+		n.uniqueItems = x.Bool()
+	}
+
+	if x, ok := m.Get("pattern"); ok {
+		if !x.IsString() {
+			return fmt.Errorf("%s: pattern must be string", where)
+		}
+		re, err := regexp.Compile(x.String())
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern: %s", where, err.Error())
+		}
+		n.pattern = re
+	}
+
+	for _, kv := range []struct {
+		key string
+		out *int
+	}{
+		{"minProperties", &n.minProperties},
+		{"maxProperties", &n.maxProperties},
+		{"minItems", &n.minItems},
+		{"maxItems", &n.maxItems},
+		{"minLength", &n.minLength},
+		{"maxLength", &n.maxLength},
+	} {
+		if err := count(kv.key, kv.out); err != nil {
+			return err
+		}
+	}
+
+	for _, kv := range []struct {
+		key string
+		out **float64
+	}{
+		{"minimum", &n.minimum},
+		{"maximum", &n.maximum},
+		{"exclusiveMinimum", &n.exclusiveMin},
+		{"exclusiveMaximum", &n.exclusiveMax},
+		{"multipleOf", &n.multipleOf},
+	} {
+		if err := number(kv.key, kv.out); err != nil {
+			return err
+		}
+	}
+	if n.multipleOf != nil && *n.multipleOf <= 0 {
+		return fmt.Errorf("%s: multipleOf must be positive", where)
+	}
+
+	for _, kv := range []struct {
+		key string
+		out *[]*schemaNode
+	}{
+		{"allOf", &n.allOf},
+		{"anyOf", &n.anyOf},
+		{"oneOf", &n.oneOf},
+	} {
+		if x, ok := m.Get(kv.key); ok {
+			l, err := c.compileList(x, where+"."+kv.key)
+			if err != nil {
+				return err
+			}
+			*kv.out = l
+		}
+	}
+
+	if x, ok := m.Get("not"); ok {
+		nn, err := c.compile(x, where+".not")
+		if err != nil {
+			return err
+		}
+		n.not = nn
+	}
+
+	return nil
+}
+
+// a $ref cycle, ie {"$ref": "#"}, applies the same schema to the same value
+// again and never terminates. Only properties, additionalProperties and items
+// step into the value, the rest of the sub schemas are applied in place and a
+// cycle formed by them alone is rejected
+func schemaCheckCycle(root *schemaNode) error {
+	const (
+		white = iota
+		grey
+		black
+	)
+	color := make(map[*schemaNode]int)
+	var pending []*schemaNode
+
+	var walk func(*schemaNode) error
+	walk = func(n *schemaNode) error {
+		switch color[n] {
+		case grey:
+			return fmt.Errorf("%s: $ref is cyclic without consuming any input", n.where)
+		case black:
+			return nil
+		}
+		color[n] = grey
+
+		inplace := append([]*schemaNode{}, n.allOf...)
+		inplace = append(inplace, n.anyOf...)
+		inplace = append(inplace, n.oneOf...)
+		if n.ref != nil {
+			inplace = append(inplace, n.ref)
+		}
+		if n.not != nil {
+			inplace = append(inplace, n.not)
+		}
+		for _, x := range inplace {
+			if err := walk(x); err != nil {
+				return err
+			}
+		}
+
+		for _, k := range n.propertyOrder {
+			pending = append(pending, n.properties[k])
+		}
+		if n.additional != nil {
+			pending = append(pending, n.additional)
+		}
+		if n.items != nil {
+			pending = append(pending, n.items)
+		}
+		color[n] = black
+		return nil
+	}
+
+	pending = append(pending, root)
+	for len(pending) != 0 {
+		n := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if err := walk(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validation ----------------------------------------------------------------
+
+func (n *schemaNode) isValid(v Val, depth int) bool {
+	var errs []schemaError
+	n.validate(v, "", depth, &errs)
+	return len(errs) == 0
+}
+
+func (n *schemaNode) validate(v Val, path string, depth int, errs *[]schemaError) {
+	fail := func(keyword string, format string, args ...interface{}) {
+		*errs = append(*errs, schemaError{
+			path:    path,
+			keyword: keyword,
+			message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if depth > schemaMaxDepth {
+		fail("depth", "schema is nested deeper than %d", schemaMaxDepth)
+		return
+	}
+	depth++
+
+	if n.reject {
+		fail("false", "value is not allowed")
+		return
+	}
+
+	if n.ref != nil {
+		n.ref.validate(v, path, depth, errs)
+	}
+
+	if len(n.types) != 0 {
+		ok := false
+		for _, t := range n.types {
+			if schemaIsType(v, t) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			fail("type", "expect type %s, but got %s", strings.Join(n.types, " or "),
+				schemaTypeName(v))
+			// the rest of the type specific keywords are meaningless
+			return
+		}
+	}
+
+	if n.enum != nil {
+		ok := false
+		for _, x := range n.enum {
+			if eq, _ := assertVeq(v, x); eq {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			fail("enum", "value is not one of the enumerations")
+		}
+	}
+
+	if n.cnst != nil {
+		if eq, _ := assertVeq(v, *n.cnst); !eq {
+			fail("const", "value does not equal to the constant")
+		}
+	}
+
+	switch v.Type {
+	case ValMap:
+		n.validateMap(v.Map(), path, depth, errs, fail)
+	case ValList:
+		n.validateList(v.List(), path, depth, errs, fail)
+	case ValStr:
+		l := utf8.RuneCountInString(v.String())
+		if n.minLength >= 0 && l < n.minLength {
+			fail("minLength", "string length %d is less than %d", l, n.minLength)
+		}
+		if n.maxLength >= 0 && l > n.maxLength {
+			fail("maxLength", "string length %d is greater than %d", l, n.maxLength)
+		}
+		if n.pattern != nil && !n.pattern.MatchString(v.String()) {
+			fail("pattern", "string does not match pattern %s", n.pattern.String())
+		}
+	case ValInt, ValReal:
+		r, _ := schemaReal(v)
+		if n.minimum != nil && r < *n.minimum {
+			fail("minimum", "value %v is less than %v", r, *n.minimum)
+		}
+		if n.maximum != nil && r > *n.maximum {
+			fail("maximum", "value %v is greater than %v", r, *n.maximum)
+		}
+		if n.exclusiveMin != nil && r <= *n.exclusiveMin {
+			fail("exclusiveMinimum", "value %v is not greater than %v", r, *n.exclusiveMin)
+		}
+		if n.exclusiveMax != nil && r >= *n.exclusiveMax {
+			fail("exclusiveMaximum", "value %v is not less than %v", r, *n.exclusiveMax)
+		}
+		if n.multipleOf != nil {
+			q := r / *n.multipleOf
+			if math.Abs(q-math.Round(q)) > 1e-9 {
+				fail("multipleOf", "value %v is not multiple of %v", r, *n.multipleOf)
+			}
+		}
+	}
+
+	for _, x := range n.allOf {
+		x.validate(v, path, depth, errs)
+	}
+
+	if n.anyOf != nil {
+		ok := false
+		for _, x := range n.anyOf {
+			if x.isValid(v, depth) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			fail("anyOf", "value does not match any of the schemas")
+		}
+	}
+
+	if n.oneOf != nil {
+		cnt := 0
+		for _, x := range n.oneOf {
+			if x.isValid(v, depth) {
+				cnt++
+			}
+		}
+		if cnt != 1 {
+			fail("oneOf", "value matches %d schemas, expect exactly 1", cnt)
+		}
+	}
+
+	if n.not != nil && n.not.isValid(v, depth) {
+		fail("not", "value must not match the schema")
+	}
+}
+
+func (n *schemaNode) validateMap(
+	m *Map,
+	path string,
+	depth int,
+	errs *[]schemaError,
+	fail func(string, string, ...interface{}),
+) {
+	if n.minProperties >= 0 && m.Length() < n.minProperties {
+		fail("minProperties", "object has %d properties, less than %d", m.Length(),
+			n.minProperties)
+	}
+	if n.maxProperties >= 0 && m.Length() > n.maxProperties {
+		fail("maxProperties", "object has %d properties, greater than %d", m.Length(),
+			n.maxProperties)
+	}
+
+	for _, k := range n.required {
+		if !m.Has(k) {
+			fail("required", "missing required property %s", k)
+		}
+	}
+
+	for _, k := range n.propertyOrder {
+		if x, ok := m.Get(k); ok {
+			n.properties[k].validate(x, schemaJoinKey(path, k), depth, errs)
+		}
+	}
+
+	if n.additional != nil {
+		keys := m.Keys()
+		sort.Strings(keys)
+		for _, k := range keys {
+			if _, ok := n.properties[k]; ok {
+				continue
+			}
+			x, _ := m.Get(k)
+			if n.additional.reject {
+				*errs = append(*errs, schemaError{
+					path:    schemaJoinKey(path, k),
+					keyword: "additionalProperties",
+					message: fmt.Sprintf("additional property %s is not allowed", k),
+				})
+			} else {
+				n.additional.validate(x, schemaJoinKey(path, k), depth, errs)
+			}
+		}
+	}
+}
+
+func (n *schemaNode) validateList(
+	l *List,
+	path string,
+	depth int,
+	errs *[]schemaError,
+	fail func(string, string, ...interface{}),
+) {
+	if n.minItems >= 0 && l.Length() < n.minItems {
+		fail("minItems", "array has %d items, less than %d", l.Length(), n.minItems)
+	}
+	if n.maxItems >= 0 && l.Length() > n.maxItems {
+		fail("maxItems", "array has %d items, greater than %d", l.Length(), n.maxItems)
+	}
+
+	if n.uniqueItems {
+	DONE:
+		for i := 0; i < l.Length(); i++ {
+			for j := i + 1; j < l.Length(); j++ {
+				if eq, _ := assertVeq(l.Data[i], l.Data[j]); eq {
+					fail("uniqueItems", "array items %d and %d are duplicated", i, j)
+					break DONE
+				}
+			}
+		}
+	}
+
+	if n.items != nil {
+		for i, x := range l.Data {
+			n.items.validate(x, schemaJoinIndex(path, i), depth, errs)
+		}
+	}
+}
+
+// schema value --------------------------------------------------------------
+
+func CompileSchema(v Val) (*Schema, error) {
+	c := &schemaCompiler{
+		root: v,
+		refs: make(map[string]*schemaNode),
+	}
+	root := &schemaNode{}
+	c.refs["#"] = root
+	if err := c.compileInto(root, v, "#"); err != nil {
+		return nil, err
+	}
+	if err := schemaCheckCycle(root); err != nil {
+		return nil, err
+	}
+	return &Schema{
+		source: v,
+		root:   root,
+	}, nil
+}
+
+// validate the value and returns a list of violations, empty list means the
+// value is valid
+func (s *Schema) Validate(v Val) Val {
+	var errs []schemaError
+	s.root.validate(v, "", 0, &errs)
+
+	o := NewValList()
+	for _, e := range errs {
+		x := NewValMap()
+		x.AddMap("path", NewValStr(e.path))
+		x.AddMap("keyword", NewValStr(e.keyword))
+		x.AddMap("message", NewValStr(e.message))
+		o.AddList(x)
+	}
+	return o
+}
+
+func (s *Schema) Index(_ Val) (Val, error) {
+	return NewValNull(), fmt.Errorf("type: %s does not support index", s.Id())
+}
+
+func (s *Schema) IndexSet(_ Val, _ Val) error {
+	return fmt.Errorf("type: %s does not support index set", s.Id())
+}
+
+func (s *Schema) Dot(_ string) (Val, error) {
+	return NewValNull(), fmt.Errorf("type: %s does not support dot", s.Id())
+}
+
+func (s *Schema) DotSet(_ string, _ Val) error {
+	return fmt.Errorf("type: %s does not support dot set", s.Id())
+}
+
+func (s *Schema) Method(name string, args []Val) (Val, error) {
+	switch name {
+	case "validate":
+		if _, err := mpSchemaValidate.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		return s.Validate(args[0]), nil
+
+	case "is_valid":
+		if _, err := mpSchemaIsValid.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		return NewValBool(s.root.isValid(args[0], 0)), nil
+
+	default:
+		return NewValNull(), fmt.Errorf("%s method: %s is unknown", s.Id(), name)
+	}
+}
+
+func (s *Schema) ToString() (string, error) {
+	return fmt.Sprintf("[%s]", s.Id()), nil
+}
+
+func (s *Schema) ToJSON() (Val, error) {
+	return s.source, nil
+}
+
+func (s *Schema) Id() string {
+	return SchemaTypeId
+}
+
+func (s *Schema) Info() string {
+	return s.Id()
+}
+
+// compiled schema is immutable
+func (s *Schema) IsThreadSafe() bool {
+	return true
+}
+
+func (s *Schema) NewIterator() (Iter, error) {
+	return nil, fmt.Errorf("type: %s does not support iterator", s.Id())
+}
+
+func NewValSchema(s *Schema) Val {
+	return NewValUsr(s)
+}
+
+func init() {
+	// schema::compile(schema), the schema can be either a map or a JSON string
+	addMF(
+		"schema",
+		"compile",
+		"",
+		"(%m|%s|%b)",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			src := args[0]
+			if src.IsString() {
+				x, err := NewValFromJSON(src.String())
+				if err != nil {
+					return NewValNull(), fmt.Errorf("schema::compile: %s", err.Error())
+				}
+				src = x
+			}
+			s, err := CompileSchema(src)
+			if err != nil {
+				return NewValNull(), fmt.Errorf("schema::compile: %s", err.Error())
+			}
+			return NewValSchema(s), nil
+		},
+		"schema::compile(schema), compiles the schema from map or JSON string",
+	)
+
+	addMF(
+		"schema",
+		"validate",
+		"",
+		"%U['.schema']%a",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			return args[0].Usr().(*Schema).Validate(args[1]), nil
+		},
+		"schema::validate(schema, v), validates the value against the compiled schema",
+	)
+}
@@ -0,0 +1,71 @@
+package pl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func schemaFromJSON(t *testing.T, src string) Val {
+	v, err := NewValFromJSON(src)
+	if err != nil {
+		t.Fatalf("invalid json: %s", err.Error())
+	}
+	return v
+}
+
+func TestSchemaRefCycle(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, src := range []string{
+		`{"$ref": "#"}`,
+		`{"allOf": [{"$ref": "#"}]}`,
+		`{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"not": {"$ref": "#/$defs/a"}}},
+		  "$ref": "#/$defs/a"}`,
+	} {
+		_, err := CompileSchema(schemaFromJSON(t, src))
+		assert.Error(err, src)
+		if err != nil {
+			assert.Contains(err.Error(), "cyclic", src)
+		}
+	}
+
+	// recursion consuming the input is fine
+	s, err := CompileSchema(schemaFromJSON(t, `{
+		"type": "object",
+		"properties": {"child": {"$ref": "#"}},
+		"additionalProperties": {"items": {"$ref": "#"}}
+	}`))
+	assert.NoError(err)
+	assert.NotNil(s)
+	ok := s.Validate(schemaFromJSON(t, `{"child": {"x": [{}]}}`))
+	assert.Equal(0, ok.List().Length())
+	bad := s.Validate(schemaFromJSON(t, `{"child": {"child": 1}}`))
+	assert.Equal(1, bad.List().Length())
+}
+
+func TestSchemaDepthLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := CompileSchema(schemaFromJSON(t, `{
+		"type": "array",
+		"items": {"$ref": "#"}
+	}`))
+	assert.NoError(err)
+
+	shallow := NewValList()
+	shallow.AddList(NewValList())
+	assert.True(s.root.isValid(shallow, 0))
+
+	deep := NewValList()
+	for i := 0; i < schemaMaxDepth*4; i++ {
+		x := NewValList()
+		x.AddList(deep)
+		deep = x
+	}
+	errs := s.Validate(deep)
+	assert.Equal(1, errs.List().Length())
+	e, _ := errs.List().Data[0].Map().Get("keyword")
+	assert.Equal("depth", e.String())
+	assert.False(s.root.isValid(deep, 0))
+}
@@ -10,6 +10,7 @@ func init() {
 		"",
 		"%s%s",
 		strings.Compare,
+		"str::cmp(a, b), -1, 0 or 1 by comparing a with b",
 	)
 
 	addrefMF(
@@ -18,6 +19,7 @@ func init() {
 		"",
 		"%s%s",
 		strings.Contains,
+		"str::contains(s, sub), whether sub is within s",
 	)
 
 	addrefMF(
@@ -26,6 +28,7 @@ func init() {
 		"",
 		"%s%s",
 		strings.ContainsAny,
+		"str::contains_any(s, chars), whether any of the chars is within s",
 	)
 
 	addrefMF(
@@ -39,6 +42,7 @@ func init() {
 			}
 			return strings.ContainsRune(a, []rune(b)[0])
 		},
+		"str::contains_char(s, c), whether the first char of c is within s",
 	)
 
 	addrefMF(
@@ -47,6 +51,7 @@ func init() {
 		"",
 		"%s%s",
 		strings.Count,
+		"str::count(s, sub), the number of non-overlapping sub in s",
 	)
 
 	initModStrCut()
@@ -57,6 +62,7 @@ func init() {
 		"",
 		"%s%s",
 		strings.EqualFold,
+		"str::caseless_eq(a, b), case insensitive equality",
 	)
 
 	addrefMF(
@@ -65,6 +71,7 @@ func init() {
 		"",
 		"%s%s",
 		strings.HasPrefix,
+		"str::has_prefix(s, prefix), whether s begins with prefix",
 	)
 
 	addrefMF(
@@ -73,6 +80,7 @@ func init() {
 		"",
 		"%s%s",
 		strings.HasSuffix,
+		"str::has_suffix(s, suffix), whether s ends with suffix",
 	)
 
 	addrefMF(
@@ -81,6 +89,7 @@ func init() {
 		"",
 		"%s%s",
 		strings.Index,
+		"str::index(s, sub), the byte index of the first sub, or -1",
 	)
 	addrefMF(
 		"str",
@@ -88,6 +97,7 @@ func init() {
 		"",
 		"%s%s",
 		strings.IndexAny,
+		"str::index_any(s, chars), the byte index of the first of any chars, or -1",
 	)
 	addrefMF(
 		"str",
@@ -100,6 +110,7 @@ func init() {
 			}
 			return strings.IndexByte(a, []byte(b)[0])
 		},
+		"str::index_byte(s, c), the byte index of the first byte c, or -1",
 	)
 	addMF(
 		"str",
@@ -119,6 +130,7 @@ func init() {
 			}
 			return NewValStr(strings.Join(slist, args[1].String())), nil
 		},
+		"str::join(list, sep), concatenates the strings with sep",
 	)
 
 	addrefMF(
@@ -127,6 +139,7 @@ func init() {
 		"",
 		"%s%s",
 		strings.LastIndex,
+		"str::last_index(s, sub), the byte index of the last sub, or -1",
 	)
 	addrefMF(
 		"str",
@@ -134,6 +147,7 @@ func init() {
 		"",
 		"%s%s",
 		strings.LastIndexAny,
+		"str::last_index_any(s, chars), the byte index of the last of any chars, or -1",
 	)
 	addrefMF(
 		"str",
@@ -146,6 +160,7 @@ func init() {
 			}
 			return strings.LastIndexByte(a, []byte(b)[0])
 		},
+		"str::last_index_byte(s, c), the byte index of the last byte c, or -1",
 	)
 
 	addrefMF(
@@ -154,6 +169,7 @@ func init() {
 		"",
 		"%s%d",
 		strings.Repeat,
+		"str::repeat(s, n), s repeated n times",
 	)
 
 	addrefMF(
@@ -162,6 +178,7 @@ func init() {
 		"",
 		"%s%s%s%d",
 		strings.Replace,
+		"str::replace(s, old, new, n), replaces the first n old, all if n < 0",
 	)
 
 	{
@@ -174,6 +191,18 @@ func init() {
 			func(a, b string) Val {
 				return NewValStrList(strings.Split(a, b))
 			},
+			"str::split(s, sep), splits s around sep",
+		)
+
+		addrefMF(
+			"str",
+			"split_n",
+			"",
+			"%s%s%d",
+			func(a, b string, n int) Val {
+				return NewValStrList(strings.SplitN(a, b, n))
+			},
+			"str::split_n(s, sep, n), splits s around sep into at most n parts",
 		)
 
 		addrefMF(
@@ -184,6 +213,7 @@ func init() {
 			func(a, b string) Val {
 				return NewValStrList(strings.SplitAfter(a, b))
 			},
+			"str::split_after(s, sep), splits s after each sep",
 		)
 
 		addrefMF(
@@ -194,6 +224,7 @@ func init() {
 			func(a, b string, n int) Val {
 				return NewValStrList(strings.SplitAfterN(a, b, n))
 			},
+			"str::split_after_n(s, sep, n), splits s after each sep into at most n parts",
 		)
 	}
 
@@ -203,6 +234,7 @@ func init() {
 		"",
 		"%s",
 		strings.ToLower,
+		"str::to_lower(s), lower case",
 	)
 
 	addrefMF(
@@ -211,6 +243,7 @@ func init() {
 		"",
 		"%s",
 		strings.ToUpper,
+		"str::to_upper(s), upper case",
 	)
 
 	addrefMF(
@@ -219,6 +252,7 @@ func init() {
 		"",
 		"%s",
 		strings.ToTitle,
+		"str::to_title(s), title case",
 	)
 
 	addrefMF(
@@ -227,6 +261,7 @@ func init() {
 		"",
 		"%s%s",
 		strings.Trim,
+		"str::trim(s, chars), removes the leading and trailing chars",
 	)
 
 	addrefMF(
@@ -235,6 +270,7 @@ func init() {
 		"",
 		"%s%s",
 		strings.TrimLeft,
+		"str::trim_left(s, chars), removes the leading chars",
 	)
 
 	addrefMF(
@@ -243,6 +279,7 @@ func init() {
 		"",
 		"%s%s",
 		strings.TrimRight,
+		"str::trim_right(s, chars), removes the trailing chars",
 	)
 
 	addrefMF(
@@ -251,6 +288,7 @@ func init() {
 		"",
 		"%s%s",
 		strings.TrimPrefix,
+		"str::trim_prefix(s, prefix), removes the prefix if present",
 	)
 
 	addrefMF(
@@ -259,13 +297,17 @@ func init() {
 		"",
 		"%s%s",
 		strings.TrimSuffix,
+		"str::trim_suffix(s, suffix), removes the suffix if present",
 	)
 
 	addrefMF(
 		"str",
 		"trim_space",
 		"",
-		"%s%s",
+		"%s",
 		strings.TrimSpace,
+		"str::trim_space(s), removes the leading and trailing white space",
 	)
+
+	initModStrUnicode()
 }
@@ -21,5 +21,6 @@ func initModStrCut() {
 				return NewValNull()
 			}
 		},
+		"str::cut(s, sep), the pair of text before and after the first sep, or null",
 	)
 }
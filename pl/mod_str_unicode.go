@@ -0,0 +1,278 @@
+package pl
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// unicode aware string operations. All the index/length used by the following
+// functions are counted in rune instead of byte
+
+func runeClamp(v int, size int) int {
+	if v < 0 {
+		v = size + v
+		if v < 0 {
+			v = 0
+		}
+	}
+	if v > size {
+		v = size
+	}
+	return v
+}
+
+// the largest width str::pad_left and str::pad_right pad to
+const strPadMaxWidth = 1 << 20
+
+func strPad(s string, width int64, pad string, left bool) (string, error) {
+	if width > strPadMaxWidth {
+		return "", fmt.Errorf("width %d exceeds the limit %d", width, strPadMaxWidth)
+	}
+	if pad == "" {
+		pad = " "
+	}
+	l := utf8.RuneCountInString(s)
+	if l >= int(width) {
+		return s, nil
+	}
+
+	// exactly width-l runes of the pad, repeated from its beginning
+	n := int(width) - l
+	p := []rune(pad)
+	var b strings.Builder
+	b.Grow(len(s) + n*utf8.UTFMax)
+	if !left {
+		b.WriteString(s)
+	}
+	for i := 0; i < n; i++ {
+		b.WriteRune(p[i%len(p)])
+	}
+	if left {
+		b.WriteString(s)
+	}
+	return b.String(), nil
+}
+
+func strRuneIndex(s string, sub string, last bool) int {
+	var idx int
+	if last {
+		idx = strings.LastIndex(s, sub)
+	} else {
+		idx = strings.Index(s, sub)
+	}
+	if idx < 0 {
+		return -1
+	}
+	return utf8.RuneCountInString(s[:idx])
+}
+
+// classical dynamic programming edit distance, with 2 rows of cache
+func strLevenshtein(a, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+	if len(ra) == 0 {
+		return len(rb)
+	}
+	if len(rb) == 0 {
+		return len(ra)
+	}
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			x := prev[j] + 1
+			if y := cur[j-1] + 1; y < x {
+				x = y
+			}
+			if z := prev[j-1] + cost; z < x {
+				x = z
+			}
+			cur[j] = x
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func initModStrUnicode() {
+	addrefMF(
+		"str",
+		"rune_length",
+		"",
+		"%s",
+		utf8.RuneCountInString,
+		"str::rune_length(s), the number of runes",
+	)
+
+	addMF(
+		"str",
+		"substr",
+		"",
+		"{%s%d}{%s%d%d}",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			alog, err := info.Check(args)
+			if err != nil {
+				return NewValNull(), err
+			}
+			r := []rune(args[0].String())
+			start := runeClamp(int(args[1].Int()), len(r))
+			end := len(r)
+			if alog == 3 {
+				end = runeClamp(int(args[2].Int()), len(r))
+			}
+			if start >= end {
+				return NewValStr(""), nil
+			}
+			return NewValStr(string(r[start:end])), nil
+		},
+		"str::substr(s, start, [length]), the sub string by rune",
+	)
+
+	addrefMF(
+		"str",
+		"index_of",
+		"",
+		"%s%s",
+		func(a, b string) int {
+			return strRuneIndex(a, b, false)
+		},
+		"str::index_of(s, sub), the rune index of the first sub, or -1",
+	)
+
+	addrefMF(
+		"str",
+		"last_index_of",
+		"",
+		"%s%s",
+		func(a, b string) int {
+			return strRuneIndex(a, b, true)
+		},
+		"str::last_index_of(s, sub), the rune index of the last sub, or -1",
+	)
+
+	addrefMF(
+		"str",
+		"starts_with",
+		"",
+		"%s%s",
+		strings.HasPrefix,
+		"str::starts_with(s, prefix), whether s begins with prefix",
+	)
+
+	addrefMF(
+		"str",
+		"ends_with",
+		"",
+		"%s%s",
+		strings.HasSuffix,
+		"str::ends_with(s, suffix), whether s ends with suffix",
+	)
+
+	addMF(
+		"str",
+		"pad_left",
+		"",
+		"{%s%d}{%s%d%s}",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			alog, err := info.Check(args)
+			if err != nil {
+				return NewValNull(), err
+			}
+			pad := " "
+			if alog == 3 {
+				pad = args[2].String()
+			}
+			r, err := strPad(args[0].String(), args[1].Int(), pad, true)
+			if err != nil {
+				return NewValNull(), fmt.Errorf("str::pad_left: %s", err.Error())
+			}
+			return NewValStr(r), nil
+		},
+		"str::pad_left(s, width, [pad]), pads s on the left to width runes, width is at most 1048576",
+	)
+
+	addMF(
+		"str",
+		"pad_right",
+		"",
+		"{%s%d}{%s%d%s}",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			alog, err := info.Check(args)
+			if err != nil {
+				return NewValNull(), err
+			}
+			pad := " "
+			if alog == 3 {
+				pad = args[2].String()
+			}
+			r, err := strPad(args[0].String(), args[1].Int(), pad, false)
+			if err != nil {
+				return NewValNull(), fmt.Errorf("str::pad_right: %s", err.Error())
+			}
+			return NewValStr(r), nil
+		},
+		"str::pad_right(s, width, [pad]), pads s on the right to width runes, width is at most 1048576",
+	)
+
+	// case folding, suitable for caseless comparison and used as map key
+	addrefMF(
+		"str",
+		"fold",
+		"",
+		"%s",
+		func(a string) string {
+			return strings.ToLower(strings.ToUpper(a))
+		},
+		"str::fold(s), case folding, suitable for caseless comparison",
+	)
+
+	addrefMF(
+		"str",
+		"reverse",
+		"",
+		"%s",
+		func(a string) string {
+			r := []rune(a)
+			for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+				r[i], r[j] = r[j], r[i]
+			}
+			return string(r)
+		},
+		"str::reverse(s), reverses the runes",
+	)
+
+	addrefMF(
+		"str",
+		"chars",
+		"",
+		"%s",
+		func(a string) Val {
+			o := NewValList()
+			for _, r := range a {
+				o.AddList(NewValStr(string(r)))
+			}
+			return o
+		},
+		"str::chars(s), the list of runes as string",
+	)
+
+	addrefMF(
+		"str",
+		"levenshtein",
+		"",
+		"%s%s",
+		strLevenshtein,
+		"str::levenshtein(a, b), the edit distance by rune",
+	)
+}
@@ -0,0 +1,369 @@
+package pl
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/dianpeng/moons/util"
+)
+
+// runtime template compilation. Unlike the template expression, which is
+// compiled along with the script, the template source here is only known at
+// runtime, ie loaded from fs:: or from an upstream response. The compiled
+// templates are cached in a LRU of the module keyed by the engine and the hash
+// of the source and options, so rendering the same source repeatedly does not
+// recompile it, and a module cannot evict or observe the templates of others.
+
+const (
+	TemplateTypeId = ".template"
+
+	templateCacheSize = 256
+)
+
+var (
+	mpTemplateRender = MustNewFuncProto(".template.render", "{%0}{%a}")
+)
+
+func IsValTemplate(v Val) bool {
+	return v.Id() == TemplateTypeId
+}
+
+type compiledTemplate struct {
+	engine string
+	tpl    Template
+}
+
+func templateCacheKey(engine, source string, opt Val) (string, error) {
+	h := sha1.New()
+	if !opt.IsNull() {
+		o, err := opt.ToJSONString()
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte(o))
+	}
+	h.Write([]byte{0})
+	h.Write([]byte(source))
+	return engine + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// runtime template state of a module
+type moduleTemplate struct {
+	cache *util.LRU
+
+	// script closures registered by template::add_func, keyed by engine and
+	// then name. They are only visible to the templates compiled by the module
+	lock  sync.RWMutex
+	funcs map[string]map[string]interface{}
+}
+
+func newModuleTemplate() *moduleTemplate {
+	return &moduleTemplate{
+		cache: util.NewLRU(templateCacheSize),
+		funcs: make(map[string]map[string]interface{}),
+	}
+}
+
+func (mt *moduleTemplate) addFunc(engine, name string, fn interface{}) {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	if mt.funcs[engine] == nil {
+		mt.funcs[engine] = make(map[string]interface{})
+	}
+	mt.funcs[engine][name] = fn
+
+	// the cached template may be compiled without the function
+	mt.cache.Purge()
+}
+
+// snapshot of the functions of the engine, so the compiled template is not
+// affected by later registration
+func (mt *moduleTemplate) engineFuncs(engine string) map[string]interface{} {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	o := make(map[string]interface{}, len(mt.funcs[engine]))
+	for k, v := range mt.funcs[engine] {
+		o[k] = v
+	}
+	return o
+}
+
+// the runtime template state of the module the script belongs to, the
+// evaluator running outside of any module, ie native callback, does not cache
+func (e *Evaluator) moduleTemplate() *moduleTemplate {
+	if m := e.curModule(); m != nil {
+		return m.templates
+	}
+	return nil
+}
+
+func compileTemplate(mt *moduleTemplate, engine, source string, opt Val) (*compiledTemplate, error) {
+	var key string
+	if mt != nil {
+		k, err := templateCacheKey(engine, source, opt)
+		if err != nil {
+			return nil, err
+		}
+		if x, ok := mt.cache.Get(k); ok {
+			return x.(*compiledTemplate), nil
+		}
+		key = k
+	}
+
+	tpl := newTemplate(engine)
+	if tpl == nil {
+		return nil, fmt.Errorf("unsupported template type %s", engine)
+	}
+	if mt != nil {
+		switch x := tpl.(type) {
+		case *goTemplate:
+			x.funcs = mt.engineFuncs(engine)
+		case *pongoTemplate:
+			x.funcs = mt.engineFuncs(engine)
+		}
+	}
+	if err := tpl.Compile("runtime", source, opt); err != nil {
+		return nil, err
+	}
+
+	x := &compiledTemplate{
+		engine: engine,
+		tpl:    tpl,
+	}
+	if mt != nil {
+		mt.cache.Put(key, x)
+	}
+	return x, nil
+}
+
+func (t *compiledTemplate) Index(_ Val) (Val, error) {
+	return NewValNull(), fmt.Errorf("type: %s does not support index", t.Id())
+}
+
+func (t *compiledTemplate) IndexSet(_ Val, _ Val) error {
+	return fmt.Errorf("type: %s does not support index set", t.Id())
+}
+
+func (t *compiledTemplate) Dot(name string) (Val, error) {
+	switch name {
+	case "engine":
+		return NewValStr(t.engine), nil
+	default:
+		return NewValNull(), fmt.Errorf("type: %s does not support field %s", t.Id(), name)
+	}
+}
+
+func (t *compiledTemplate) DotSet(_ string, _ Val) error {
+	return fmt.Errorf("type: %s does not support dot set", t.Id())
+}
+
+func (t *compiledTemplate) Method(name string, args []Val) (Val, error) {
+	switch name {
+	case "render":
+		if _, err := mpTemplateRender.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		ctx := NewValNull()
+		if len(args) == 1 {
+			ctx = args[0]
+		}
+		data, err := t.tpl.Execute(ctx)
+		if err != nil {
+			return NewValNull(), err
+		}
+		return NewValStr(data), nil
+
+	default:
+		return NewValNull(), fmt.Errorf("%s method: %s is unknown", t.Id(), name)
+	}
+}
+
+func (t *compiledTemplate) ToString() (string, error) {
+	return fmt.Sprintf("[%s: %s]", t.Id(), t.engine), nil
+}
+
+func (t *compiledTemplate) ToJSON() (Val, error) {
+	return MarshalVal(
+		map[string]interface{}{
+			"type":   t.Id(),
+			"engine": t.engine,
+		},
+	)
+}
+
+func (t *compiledTemplate) Id() string {
+	return TemplateTypeId
+}
+
+func (t *compiledTemplate) Info() string {
+	return t.Id()
+}
+
+// compiled template is immutable and all the builtin engines can execute
+// concurrently
+func (t *compiledTemplate) IsThreadSafe() bool {
+	return true
+}
+
+func (t *compiledTemplate) NewIterator() (Iter, error) {
+	return nil, fmt.Errorf("type: %s does not support iterator", t.Id())
+}
+
+// script closure registered as template function. The template may execute
+// concurrently without any evaluator at hand, so the closure is invoked on a
+// pooled evaluator, which also means the closure must be shareable, see
+// scriptFunc.checkShareable. The pooled evaluator only inherits the policy of
+// the evaluator registers it, not its context, config or session, as the
+// template may be rendered long after, ie by another request. It is marked as
+// shared like the q::pmap workers, so the values it reaches from outside of
+// the call, ie the captured user values, cannot be modified
+type templateHelper struct {
+	name string
+	fn   *scriptFunc
+	pool sync.Pool
+}
+
+func newTemplateHelper(eval *Evaluator, name string, fn *scriptFunc) *templateHelper {
+	capability := eval.capability
+	policy := eval.policy
+	return &templateHelper{
+		name: name,
+		fn:   fn,
+		pool: sync.Pool{
+			New: func() interface{} {
+				w := NewEvaluator(NewNullEvalContext(), nil)
+				w.capability = capability
+				w.policy = policy
+				w.setShared(nil)
+				return w
+			},
+		},
+	}
+}
+
+func (h *templateHelper) call(args []interface{}) (interface{}, error) {
+	vargs := make([]Val, 0, len(args))
+	for _, a := range args {
+		v, err := MarshalVal(a)
+		if err != nil {
+			return nil, fmt.Errorf("template function %s: %s", h.name, err.Error())
+		}
+		vargs = append(vargs, v)
+	}
+
+	w := h.pool.Get().(*Evaluator)
+	r, err := h.fn.Call(w, vargs)
+	// each call is a session of its own
+	w.Cleanup()
+	h.pool.Put(w)
+	if err != nil {
+		return nil, fmt.Errorf("template function %s: %s", h.name, err.Error())
+	}
+	return toctx(r)
+}
+
+// go template function and pongo function, ie {{ name(x) }}
+func (h *templateHelper) goFunc(args ...interface{}) (interface{}, error) {
+	return h.call(args)
+}
+
+func init() {
+	// template::add_func(engine, name, closure), registers a script closure as
+	// template function of the module, ie function of go template and function
+	// of pongo. It is only allowed in the config or global scope, so the
+	// functions are settled before any request
+	addMF(
+		"template",
+		"add_func",
+		"",
+		"%s:engine%s:name%c:function",
+		func(info *IntrinsicInfo, eval *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			engine := args[0].String()
+			name := args[1].String()
+
+			if !eval.setup {
+				return NewValNull(),
+					fmt.Errorf("template::add_func: only allowed in config or global scope")
+			}
+			mt := eval.moduleTemplate()
+			if mt == nil {
+				return NewValNull(), fmt.Errorf("template::add_func: no module")
+			}
+
+			sfunc, ok := args[2].Closure().(*scriptFunc)
+			if !ok {
+				return NewValNull(), fmt.Errorf("template::add_func: function must be script closure")
+			}
+			if err := sfunc.checkShareable(); err != nil {
+				return NewValNull(), fmt.Errorf("template::add_func: function %s", err.Error())
+			}
+
+			switch engine {
+			case "go", "pongo":
+				break
+			default:
+				return NewValNull(),
+					fmt.Errorf("template::add_func: template engine %s does not support custom function", engine)
+			}
+			if err := checkTemplateFuncName(engine, name); err != nil {
+				return NewValNull(), fmt.Errorf("template::add_func: %s", err.Error())
+			}
+
+			h := newTemplateHelper(eval, name, sfunc)
+			mt.addFunc(engine, name, h.goFunc)
+			return NewValNull(), nil
+		},
+		"template::add_func(engine, name, closure), registers the closure as template function",
+	)
+
+	// template::compile(engine, source, [options])
+	addMF(
+		"template",
+		"compile",
+		"",
+		"%s:engine%s:source(%m|%n):options=null",
+		func(info *IntrinsicInfo, eval *Evaluator, _ string, args []Val) (Val, error) {
+			args, err := info.CheckDefault(args)
+			if err != nil {
+				return NewValNull(), err
+			}
+			t, err := compileTemplate(eval.moduleTemplate(), args[0].String(), args[1].String(), args[2])
+			if err != nil {
+				return NewValNull(), fmt.Errorf("template::compile: %s", err.Error())
+			}
+			return NewValUsr(t), nil
+		},
+		"template::compile(engine, source, [options]), compiles the template",
+	)
+
+	// template::render(engine, source, context, [options])
+	addMF(
+		"template",
+		"render",
+		"",
+		"%s:engine%s:source%a:context(%m|%n):options=null",
+		func(info *IntrinsicInfo, eval *Evaluator, _ string, args []Val) (Val, error) {
+			args, err := info.CheckDefault(args)
+			if err != nil {
+				return NewValNull(), err
+			}
+			t, err := compileTemplate(eval.moduleTemplate(), args[0].String(), args[1].String(), args[3])
+			if err != nil {
+				return NewValNull(), fmt.Errorf("template::render: %s", err.Error())
+			}
+			data, err := t.tpl.Execute(args[2])
+			if err != nil {
+				return NewValNull(), fmt.Errorf("template::render: %s", err.Error())
+			}
+			return NewValStr(data), nil
+		},
+		"template::render(engine, source, context, [options]), renders the template",
+	)
+}
@@ -4,74 +4,85 @@ import (
 	"time"
 )
 
-func init() {
-	addrefMF(
+// the time:: functions read the clock of the evaluator, ie the fake clock of
+// the tests, see Evaluator.SetClock
+func addTimeMF(name string, p string, doc string, f func(time.Time, []Val) Val) {
+	addMF(
 		"time",
-		"unix",
+		name,
 		"",
+		p,
+		func(info *IntrinsicInfo, e *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			return f(e.now(), args), nil
+		},
+		doc,
+	)
+}
+
+func init() {
+	addTimeMF(
+		"unix",
 		"%0",
-		func() int64 {
-			return time.Now().Unix()
+		"time::unix(), the current unix time in second",
+		func(now time.Time, _ []Val) Val {
+			return NewValInt64(now.Unix())
 		},
 	)
 
-	addrefMF(
-		"time",
+	addTimeMF(
 		"unix_milli",
-		"",
 		"%0",
-		func() int64 {
-			return time.Now().UnixMilli()
+		"time::unix_milli(), the current unix time in millisecond",
+		func(now time.Time, _ []Val) Val {
+			return NewValInt64(now.UnixMilli())
 		},
 	)
 
-	addrefMF(
-		"time",
+	addTimeMF(
 		"unix_micro",
-		"",
 		"%0",
-		func() int64 {
-			return time.Now().UnixMicro()
+		"time::unix_micro(), the current unix time in microsecond",
+		func(now time.Time, _ []Val) Val {
+			return NewValInt64(now.UnixMicro())
 		},
 	)
 
-	addrefMF(
-		"time",
+	addTimeMF(
 		"unix_nano",
-		"",
 		"%0",
-		func() int64 {
-			return time.Now().UnixNano()
+		"time::unix_nano(), the current unix time in nanosecond",
+		func(now time.Time, _ []Val) Val {
+			return NewValInt64(now.UnixNano())
 		},
 	)
 
-	addrefMF(
-		"time",
+	addTimeMF(
 		"now_format",
-		"",
 		"%s",
-		func(format string) string {
-			return time.Now().Format(format)
+		"time::now_format(layout), the current time formatted by the go time layout",
+		func(now time.Time, args []Val) Val {
+			return NewValStr(now.Format(args[0].String()))
 		},
 	)
 
-	addrefMF(
-		"time",
+	addTimeMF(
 		"http_date",
-		"",
 		"%0",
-		func() string {
-			return time.Now().Format(time.RFC3339)
+		"time::http_date(), the current time in RFC3339",
+		func(now time.Time, _ []Val) Val {
+			return NewValStr(now.Format(time.RFC3339))
 		},
 	)
 
-	addrefMF(
-		"time",
+	addTimeMF(
 		"http_datenano",
-		"",
 		"%0",
-		func() string {
-			return time.Now().Format(time.RFC3339Nano)
+		"time::http_datenano(), the current time in RFC3339 with nanosecond",
+		func(now time.Time, _ []Val) Val {
+			return NewValStr(now.Format(time.RFC3339Nano))
 		},
 	)
 }
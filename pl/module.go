@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io/fs"
+	"regexp"
 	"strings"
 	"sync"
 )
@@ -51,17 +52,56 @@ type Module struct {
 	// function name
 	fn []*program
 
+	// all the test blocks, they are not rules and only run by the test runner
+	tests []*program
+
 	// symbol info, used for instrumentation/debugging purpose
 	sinfo symbolInfo
+
+	// file system the module is loaded from, ie the manifest's FS. It may be
+	// nil if the module is compiled from a plain string
+	fs fs.FS
+
+	// interned regexp literal, identical pattern shares the same compiled
+	// regexp object across all the programs inside of the module. Only the
+	// compiler populates it, runtime just performs lookup
+	regexpCache map[string]*regexp.Regexp
+	regexpLock  sync.RWMutex
+
+	// runtime compiled templates, see template::compile
+	templates *moduleTemplate
 }
 
 func newModule() *Module {
 	return &Module{
-		global:   &globalState{},
-		eventMap: make(map[string][]*program),
+		global:      &globalState{},
+		eventMap:    make(map[string][]*program),
+		regexpCache: make(map[string]*regexp.Regexp),
+		templates:   newModuleTemplate(),
 	}
 }
 
+func (p *Module) internRegexp(r string) (*regexp.Regexp, error) {
+	p.regexpLock.Lock()
+	defer p.regexpLock.Unlock()
+
+	if rexp, ok := p.regexpCache[r]; ok {
+		return rexp, nil
+	}
+	rexp, err := regexp.Compile(r)
+	if err != nil {
+		return nil, err
+	}
+	p.regexpCache[r] = rexp
+	return rexp, nil
+}
+
+func (p *Module) cachedRegexp(r string) *regexp.Regexp {
+	p.regexpLock.RLock()
+	defer p.regexpLock.RUnlock()
+	return p.regexpCache[r]
+}
+
 func (p *Module) addSessionProgram(
 	prog *program,
 	nameList []string,
@@ -189,6 +229,11 @@ func (p *Module) GetFunction(name string) Val {
 	}
 }
 
+// File system that the module is bounded to, may be nil
+func (p *Module) FS() fs.FS {
+	return p.fs
+}
+
 func (p *Module) HasSession() bool {
 	return len(p.session) != 0
 }
@@ -225,6 +270,60 @@ func (p *Module) findEvent(name string) []*program {
 	}
 }
 
+// Rules returns the names of the rules in order of definition, the rule
+// defined more than once is listed once
+func (p *Module) Rules() []string {
+	o := []string{}
+	seen := make(map[string]bool)
+	for _, x := range p.p {
+		if !seen[x.name] {
+			seen[x.name] = true
+			o = append(o, x.name)
+		}
+	}
+	return o
+}
+
+// Functions returns the names of the script functions in order of
+// definition, the anonymous functions are not listed
+func (p *Module) Functions() []string {
+	o := []string{}
+	for _, x := range p.fn {
+		if !strings.HasPrefix(x.name, "[anonymous_function_") {
+			o = append(o, x.name)
+		}
+	}
+	return o
+}
+
+func (p *Module) getTest(name string) *program {
+	r, _ := p.getfromlist(name, p.tests)
+	return r
+}
+
+// Tests returns the names of the test blocks in order of definition
+func (p *Module) Tests() []string {
+	o := []string{}
+	for _, x := range p.tests {
+		o = append(o, x.name)
+	}
+	return o
+}
+
+// Disasm returns the bytecode of the function, the rules or the test of the
+// name, false if none is found
+func (p *Module) Disasm(name string) (string, bool) {
+	var b bytes.Buffer
+	for _, l := range [][]*program{p.fn, p.p, p.tests} {
+		for _, x := range l {
+			if x.name == name {
+				b.WriteString(x.dump())
+			}
+		}
+	}
+	return b.String(), b.Len() != 0
+}
+
 func (p *Module) Dump() string {
 	var b bytes.Buffer
 	b.WriteString("function> -------------------------------- \n")
@@ -251,6 +350,58 @@ func CompileModule(module string, fs fs.FS) (*Module, error) {
 	return po, nil
 }
 
+// ModuleHeader is the module declaration and the imports of a file, read
+// without compiling the file
+type ModuleHeader struct {
+	// name of the module declared, empty if the file is not a module
+	Name    string
+	Imports []string
+}
+
+// ParseModuleHeader reads the module declaration and the import statements at
+// the top of the input, the rest of the input is not parsed
+func ParseModuleHeader(input string) (*ModuleHeader, error) {
+	p := newParser(input, nil)
+	h := &ModuleHeader{}
+	p.l.next()
+
+	if p.l.token == tkModule {
+		if !p.l.expect(tkId) {
+			return nil, p.l.toError()
+		}
+		prefix := p.l.valueText
+		p.l.next()
+		mname, err := p.parseModSymbol(prefix)
+		if err != nil {
+			return nil, err
+		}
+		h.Name = mname.fullname()
+	}
+
+	for p.l.token == tkImport {
+		p.l.next()
+		if p.l.token == tkStr {
+			h.Imports = append(h.Imports, p.l.valueText)
+			p.l.next()
+			continue
+		}
+		if p.l.token != tkLPar {
+			return nil, p.err("expect a string as module path or '(' to start a group of " +
+				"paths after import")
+		}
+		p.l.next()
+		for p.l.token != tkRPar {
+			if !p.l.expectCurrent(tkStr) {
+				return nil, p.l.toError()
+			}
+			h.Imports = append(h.Imports, p.l.valueText)
+			p.l.next()
+		}
+		p.l.next()
+	}
+	return h, nil
+}
+
 func (g *globalState) size() int {
 	g.lock.RLock()
 	defer func() {
@@ -0,0 +1,144 @@
+package pl
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Execution statistics of the rules, ie which event dominates the CPU. Each
+// program run by the evaluator as the top frame, ie the rule of an event, the
+// config, the global and the session scope, counts its invocations, the time
+// spent and the failed ones. The statistics are shared by all the evaluators
+// running the module, so they are updated atomically.
+
+type ruleStats struct {
+	count  int64
+	errors int64
+	nanos  int64
+}
+
+func (s *ruleStats) record(d time.Duration, failed bool) {
+	atomic.AddInt64(&s.count, 1)
+	atomic.AddInt64(&s.nanos, int64(d))
+	if failed {
+		atomic.AddInt64(&s.errors, 1)
+	}
+}
+
+// RuleStats is the execution statistics of the rule of the name, the rule
+// defined more than once is summed up
+type RuleStats struct {
+	Name   string        `json:"name"`
+	Kind   string        `json:"kind"`
+	Count  int64         `json:"count"`
+	Errors int64         `json:"errors"`
+	Time   time.Duration `json:"nanos"`
+}
+
+// Average time of one invocation
+func (r *RuleStats) Average() time.Duration {
+	if r.Count == 0 {
+		return 0
+	}
+	return r.Time / time.Duration(r.Count)
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func progKindName(t int) string {
+	switch t {
+	case progRule:
+		return "rule"
+	case progSession:
+		return "scope"
+	case progConfig:
+		return "config"
+	default:
+		return "unknown"
+	}
+}
+
+func (p *Module) topPrograms() []*program {
+	o := []*program{}
+	if p.config != nil {
+		o = append(o, p.config)
+	}
+	o = append(o, p.global.globalProgram...)
+	o = append(o, p.session...)
+	return append(o, p.p...)
+}
+
+// Stats returns the execution statistics of the rules, the most expensive one
+// in total time comes first. The rule never run is not listed
+func (p *Module) Stats() []RuleStats {
+	o := []RuleStats{}
+	index := make(map[string]int)
+	for _, prog := range p.topPrograms() {
+		count := atomic.LoadInt64(&prog.stats.count)
+		if count == 0 {
+			continue
+		}
+		kind := progKindName(prog.progtype)
+		key := kind + ":" + prog.name
+		idx, ok := index[key]
+		if !ok {
+			idx = len(o)
+			index[key] = idx
+			o = append(o, RuleStats{
+				Name: prog.name,
+				Kind: kind,
+			})
+		}
+		o[idx].Count += count
+		o[idx].Errors += atomic.LoadInt64(&prog.stats.errors)
+		o[idx].Time += time.Duration(atomic.LoadInt64(&prog.stats.nanos))
+	}
+	sort.SliceStable(o, func(i, j int) bool {
+		return o[i].Time > o[j].Time
+	})
+	return o
+}
+
+// ResetStats clears the execution statistics of the rules
+func (p *Module) ResetStats() {
+	for _, prog := range p.topPrograms() {
+		atomic.StoreInt64(&prog.stats.count, 0)
+		atomic.StoreInt64(&prog.stats.errors, 0)
+		atomic.StoreInt64(&prog.stats.nanos, 0)
+	}
+}
+
+func init() {
+	addMF(
+		"runtime",
+		"stats",
+		"",
+		"%0",
+		func(info *IntrinsicInfo, e *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			m := e.curModule()
+			if m == nil {
+				return NewValNull(), fmt.Errorf("runtime::stats: no module is running")
+			}
+			o := NewValList()
+			for _, x := range m.Stats() {
+				r := NewValMap()
+				r.AddMap("name", NewValStr(x.Name))
+				r.AddMap("kind", NewValStr(x.Kind))
+				r.AddMap("count", NewValInt64(x.Count))
+				r.AddMap("errors", NewValInt64(x.Errors))
+				r.AddMap("time", NewValReal(durationMs(x.Time)))
+				r.AddMap("average", NewValReal(durationMs(x.Average())))
+				o.AddList(r)
+			}
+			return o, nil
+		},
+		"runtime::stats(), the execution statistics of the module rules",
+	)
+}
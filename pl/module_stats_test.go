@@ -0,0 +1,69 @@
+package pl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModuleStats(t *testing.T) {
+	assert := assert.New(t)
+
+	module, err := CompileModule(`
+rule fast {
+  let a = 1;
+}
+
+rule slow {
+  let a = [];
+  for let i = 0; i < 1000; i++ {
+    a:push_back(i);
+  }
+}
+
+rule fail {
+  foo();
+}
+
+rule report {
+  return runtime::stats();
+}
+`, nil)
+	assert.Nil(err)
+
+	eval := NewEvaluatorSimple()
+	for i := 0; i < 3; i++ {
+		_, err := eval.Eval("fast", module)
+		assert.Nil(err)
+	}
+	_, err = eval.Eval("slow", module)
+	assert.Nil(err)
+	_, err = eval.Eval("fail", module)
+	assert.NotNil(err)
+
+	stats := module.Stats()
+	assert.Equal(3, len(stats))
+	assert.Equal("slow", stats[0].Name)
+	assert.Equal("rule", stats[0].Kind)
+
+	byName := make(map[string]RuleStats)
+	for _, x := range stats {
+		byName[x.Name] = x
+	}
+	assert.Equal(int64(3), byName["fast"].Count)
+	assert.Equal(int64(0), byName["fast"].Errors)
+	assert.Equal(int64(1), byName["fail"].Count)
+	assert.Equal(int64(1), byName["fail"].Errors)
+
+	v, err := eval.Eval("report", module)
+	assert.Nil(err)
+	assert.True(v.IsList())
+	assert.Equal(3, v.List().Length())
+	first := v.List().At(0)
+	name, ok := first.Map().Get("name")
+	assert.True(ok)
+	assert.Equal("slow", name.String())
+
+	module.ResetStats()
+	assert.Equal(0, len(module.Stats()))
+}
@@ -0,0 +1,200 @@
+package pl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSON serialization of Val. Map in ordered mode keeps its insertion order,
+// otherwise keys are sorted to make the output deterministic.
+
+// parse a JSON document into Val. Integer number is kept as int and the rest of
+// number becomes real
+func NewValFromJSON(data string) (Val, error) {
+	return newValFromJSON(data, false)
+}
+
+// same as NewValFromJSON, but all the JSON object becomes ordered map which
+// preserves the key order of the document
+func NewValFromJSONOrdered(data string) (Val, error) {
+	return newValFromJSON(data, true)
+}
+
+func newValFromJSON(data string, ordered bool) (Val, error) {
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+
+	tk, err := dec.Token()
+	if err != nil {
+		return NewValNull(), err
+	}
+	v, err := jsonDecode(dec, tk, ordered)
+	if err != nil {
+		return NewValNull(), err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return NewValNull(), fmt.Errorf("invalid JSON, trailing data")
+	}
+	return v, nil
+}
+
+func jsonDecode(dec *json.Decoder, tk json.Token, ordered bool) (Val, error) {
+	switch v := tk.(type) {
+	case nil:
+		return NewValNull(), nil
+	case bool:
+		return NewValBool(v), nil
+	case string:
+		return NewValStr(v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return NewValInt64(i), nil
+		}
+		r, err := v.Float64()
+		if err != nil {
+			return NewValNull(), err
+		}
+		return NewValReal(r), nil
+
+	case json.Delim:
+		if v == '[' {
+			o := NewValList()
+			for dec.More() {
+				e, err := dec.Token()
+				if err != nil {
+					return NewValNull(), err
+				}
+				vv, err := jsonDecode(dec, e, ordered)
+				if err != nil {
+					return NewValNull(), err
+				}
+				o.AddList(vv)
+			}
+			_, err := dec.Token()
+			return o, err
+		}
+
+		must(v == '{', "must be object")
+		m := NewMap()
+		m.SetOrdered(ordered)
+		for dec.More() {
+			k, err := dec.Token()
+			if err != nil {
+				return NewValNull(), err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return NewValNull(), fmt.Errorf("JSON object's key must be string")
+			}
+			e, err := dec.Token()
+			if err != nil {
+				return NewValNull(), err
+			}
+			vv, err := jsonDecode(dec, e, ordered)
+			if err != nil {
+				return NewValNull(), err
+			}
+			m.Set(key, vv)
+		}
+		_, err := dec.Token()
+		return NewValMapFromMap(m), err
+
+	default:
+		return NewValNull(), fmt.Errorf("unknown JSON token %T", tk)
+	}
+}
+
+// serialize the Val into JSON document
+func (v *Val) ToJSONString() (string, error) {
+	b := new(strings.Builder)
+	if err := jsonEncode(b, *v); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func jsonEncodeStr(b *strings.Builder, s string) {
+	x, _ := json.Marshal(s)
+	b.Write(x)
+}
+
+func jsonEncode(b *strings.Builder, v Val) error {
+	switch v.Type {
+	case ValNull:
+		b.WriteString("null")
+	case ValBool:
+		b.WriteString(strconv.FormatBool(v.Bool()))
+	case ValInt:
+		b.WriteString(strconv.FormatInt(v.Int(), 10))
+	case ValReal:
+		r := v.Real()
+		if math.IsNaN(r) || math.IsInf(r, 0) {
+			return fmt.Errorf("real number %f cannot be serialized as JSON", r)
+		}
+		b.WriteString(strconv.FormatFloat(r, 'g', -1, 64))
+	case ValStr:
+		jsonEncodeStr(b, v.String())
+	case ValRegexp:
+		jsonEncodeStr(b, v.Regexp().String())
+
+	case ValPair:
+		p := v.Pair()
+		b.WriteByte('[')
+		if err := jsonEncode(b, p.First); err != nil {
+			return err
+		}
+		b.WriteByte(',')
+		if err := jsonEncode(b, p.Second); err != nil {
+			return err
+		}
+		b.WriteByte(']')
+
+	case ValList:
+		b.WriteByte('[')
+		for idx, x := range v.List().Data {
+			if idx != 0 {
+				b.WriteByte(',')
+			}
+			if err := jsonEncode(b, x); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+
+	case ValMap:
+		m := v.Map()
+		keys := m.Keys()
+		if !m.IsOrdered() {
+			sort.Strings(keys)
+		}
+		b.WriteByte('{')
+		for idx, k := range keys {
+			if idx != 0 {
+				b.WriteByte(',')
+			}
+			jsonEncodeStr(b, k)
+			b.WriteByte(':')
+			x, _ := m.Get(k)
+			if err := jsonEncode(b, x); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+
+	case ValUsr:
+		x, err := v.Usr().ToJSON()
+		if err != nil {
+			return err
+		}
+		return jsonEncode(b, x)
+
+	default:
+		return fmt.Errorf("type %s cannot be serialized as JSON", v.Id())
+	}
+	return nil
+}
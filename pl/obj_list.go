@@ -2,6 +2,7 @@ package pl
 
 import (
 	"fmt"
+	"sort"
 )
 
 var (
@@ -10,6 +11,12 @@ var (
 	mpListPopBack  = MustNewFuncProto("list.pop_back", "{%d}{%0}")
 	mpListExtend   = MustNewFuncProto("list.extend", "%l")
 	mpListSlice    = MustNewFuncProto("list.slice", "{%d}{%d%d}")
+	mpListSort     = MustNewFuncProto("list.sort", "{%0}{%b}")
+	mpListContains = MustNewFuncProto("list.contains", "%a")
+	mpListIndexOf  = MustNewFuncProto("list.index_of", "%a")
+	mpListInsert   = MustNewFuncProto("list.insert", "%d%a")
+	mpListRemove   = MustNewFuncProto("list.remove", "%d")
+	mpListPop      = MustNewFuncProto("list.pop", "%0")
 )
 
 type List struct {
@@ -79,7 +86,7 @@ func (l *List) Info() string {
 }
 
 func (l *List) Index(idx Val) (Val, error) {
-	i, err := idx.ToIndex()
+	i, err := idx.ToRelIndex(len(l.Data))
 	if err != nil {
 		return NewValNull(), err
 	}
@@ -89,8 +96,19 @@ func (l *List) Index(idx Val) (Val, error) {
 	return l.Data[i], nil
 }
 
+// returns a new list which contains elements in [start, end) range
+func (l *List) Slice(start, end Val) (Val, error) {
+	s, e, err := sliceRange(start, end, len(l.Data))
+	if err != nil {
+		return NewValNull(), err
+	}
+	data := make([]Val, e-s)
+	copy(data, l.Data[s:e])
+	return NewValListRaw(data), nil
+}
+
 func (l *List) IndexSet(idx Val, val Val) error {
-	i, err := idx.ToIndex()
+	i, err := idx.ToRelIndex(len(l.Data))
 	if err != nil {
 		return err
 	}
@@ -106,6 +124,24 @@ func (l *List) IndexSet(idx Val, val Val) error {
 	return nil
 }
 
+// returns index of the first element which equals to v, the comparison is deep
+// and type strict, ie 1 does not equal to 1.0
+func (l *List) IndexOf(v Val) int {
+	for idx, x := range l.Data {
+		if ok, _ := assertVeq(x, v); ok {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (l *List) checkIndex(name string, i int, size int) error {
+	if i < 0 || i >= size {
+		return fmt.Errorf("method: list:%s index %d out of range", name, i)
+	}
+	return nil
+}
+
 func (l *List) Method(name string, args []Val) (Val, error) {
 	switch name {
 	case "length":
@@ -181,6 +217,74 @@ func (l *List) Method(name string, args []Val) (Val, error) {
 		ret = l.Data[start:end]
 		return NewValListRaw(ret), nil
 
+	// sort the list in place, the order is same as q::sort
+	case "sort":
+		alog, err := mpListSort.Check(args)
+		if err != nil {
+			return NewValNull(), err
+		}
+		reverse := alog == 1 && args[0].Bool()
+		sort.SliceStable(
+			l.Data,
+			func(i, j int) bool {
+				if reverse {
+					return qCompare(l.Data[i], l.Data[j]) > 0
+				}
+				return qCompare(l.Data[i], l.Data[j]) < 0
+			},
+		)
+		return NewValListFromList(l), nil
+
+	case "contains":
+		if _, err := mpListContains.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		return NewValBool(l.IndexOf(args[0]) >= 0), nil
+
+	case "index_of":
+		if _, err := mpListIndexOf.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		return NewValInt(l.IndexOf(args[0])), nil
+
+	case "insert":
+		if _, err := mpListInsert.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		i := int(args[0].Int())
+		if err := l.checkIndex(name, i, len(l.Data)+1); err != nil {
+			return NewValNull(), err
+		}
+		l.Data = append(l.Data, NewValNull())
+		copy(l.Data[i+1:], l.Data[i:])
+		l.Data[i] = args[1]
+		return NewValListFromList(l), nil
+
+	// remove the element at the index and returns the removed element
+	case "remove":
+		if _, err := mpListRemove.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		i := int(args[0].Int())
+		if err := l.checkIndex(name, i, len(l.Data)); err != nil {
+			return NewValNull(), err
+		}
+		v := l.Data[i]
+		l.Data = append(l.Data[:i], l.Data[i+1:]...)
+		return v, nil
+
+	// remove the last element and returns it
+	case "pop":
+		if _, err := mpListPop.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		if len(l.Data) == 0 {
+			return NewValNull(), fmt.Errorf("method: list:pop on empty list")
+		}
+		v := l.Data[len(l.Data)-1]
+		l.Data = l.Data[:len(l.Data)-1]
+		return v, nil
+
 	default:
 		return NewValNull(), fmt.Errorf("method: list:%s is unknown", name)
 	}
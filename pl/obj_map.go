@@ -12,6 +12,15 @@ var (
 	mpMapTryGet = MustNewFuncProto("map.tryGet", "%s%a")
 	mpMapGet    = MustNewFuncProto("map.get", "%s")
 	mpMapHas    = MustNewFuncProto("map.has", "%s")
+
+	mpMapKeys       = MustNewFuncProto("map.keys", "%0")
+	mpMapValues     = MustNewFuncProto("map.values", "%0")
+	mpMapEntries    = MustNewFuncProto("map.entries", "%0")
+	mpMapMerge      = MustNewFuncProto("map.merge", "{%m}{%m%b}")
+	mpMapDelete     = MustNewFuncProto("map.delete", "%s")
+	mpMapGetOr      = MustNewFuncProto("map.get_or", "%s%a")
+	mpMapOrdered    = MustNewFuncProto("map.ordered", "%0")
+	mpMapSetOrdered = MustNewFuncProto("map.set_ordered", "%b")
 )
 
 type mapval struct {
@@ -30,6 +39,10 @@ type Map struct {
 	// in order to make map iterable, we will have to keep a list of keys that
 	// has been inserted into the map
 	key []mapkey
+
+	// insertion ordered mode, Foreach visits the keys in insertion order and
+	// JSON serialization preserves it. Otherwise the order is unspecified
+	ordered bool
 }
 
 type MapIter struct {
@@ -43,7 +56,7 @@ func (m *MapIter) SetUp(*Evaluator, []Val) error {
 }
 
 func (m *MapIter) init() {
-	l := m.m.Length()
+	l := len(m.m.key)
 
 	for m.index < l {
 		k := m.m.key[m.index]
@@ -55,11 +68,11 @@ func (m *MapIter) init() {
 }
 
 func (m *MapIter) Has() bool {
-	return m.index < m.m.Length()
+	return m.index < len(m.m.key)
 }
 
 func (m *MapIter) Next() (bool, error) {
-	l := m.m.Length()
+	l := len(m.m.key)
 	m.index++
 
 	for m.index < l {
@@ -103,6 +116,59 @@ func NewMap() *Map {
 	}
 }
 
+func NewOrderedMap() *Map {
+	return &Map{
+		data:    make(map[string]mapval),
+		ordered: true,
+	}
+}
+
+func (m *Map) IsOrdered() bool {
+	return m.ordered
+}
+
+func (m *Map) SetOrdered(o bool) {
+	m.ordered = o
+}
+
+// returns all the keys in insertion order
+func (m *Map) Keys() []string {
+	o := make([]string, 0, m.Length())
+	for _, k := range m.key {
+		if k.use {
+			o = append(o, k.key)
+		}
+	}
+	return o
+}
+
+// merge other map into this map, if deep is true then nested map with same
+// key is merged recursively instead of been replaced
+func (m *Map) Merge(other *Map, deep bool) {
+	m.merge(other, deep, make(map[[2]*Map]bool))
+}
+
+// the pair of maps already being merged is skipped, so the cyclic map
+// terminates
+func (m *Map) merge(other *Map, deep bool, visited map[[2]*Map]bool) {
+	key := [2]*Map{m, other}
+	if visited[key] {
+		return
+	}
+	visited[key] = true
+
+	for _, k := range other.Keys() {
+		v, _ := other.Get(k)
+		if deep && v.IsMap() {
+			if old, ok := m.Get(k); ok && old.IsMap() {
+				old.Map().merge(v.Map(), true, visited)
+				continue
+			}
+		}
+		m.Set(k, v)
+	}
+}
+
 func (m *Map) NewIter() Iter {
 	x := &MapIter{
 		m:     m,
@@ -115,6 +181,16 @@ func (m *Map) NewIter() Iter {
 
 func (m *Map) Foreach(f func(string, Val) bool) int {
 	cnt := 0
+	if m.ordered {
+		for _, k := range m.Keys() {
+			if !f(k, m.data[k].val) {
+				break
+			}
+			cnt++
+		}
+		return cnt
+	}
+
 	for k, v := range m.data {
 		if !f(k, v.val) {
 			break
@@ -149,18 +225,20 @@ func (m *Map) Set(key string, v Val) {
 	}
 }
 
+// compact the key slice by removing tombstones, insertion order is kept
 func (m *Map) tryKeyGC() {
-	if m.Length()*2 < cap(m.key) {
-		nkey := make([]mapkey, len(m.key), len(m.key))
-		for k, v := range m.data {
-			m.data[k] = mapval{
+	if m.Length()*2 < len(m.key) {
+		nkey := make([]mapkey, 0, m.Length())
+		for _, k := range m.key {
+			if !k.use {
+				continue
+			}
+			v := m.data[k.key]
+			m.data[k.key] = mapval{
 				val:   v.val,
 				index: len(nkey),
 			}
-			nkey = append(nkey, mapkey{
-				key: k,
-				use: true,
-			})
+			nkey = append(nkey, k)
 		}
 
 		m.key = nkey
@@ -172,8 +250,8 @@ func (m *Map) Del(key string) bool {
 	if ok {
 		m.key[x.index].use = false
 		m.key[x.index].key = ""
-		m.tryKeyGC()
 		delete(m.data, key)
+		m.tryKeyGC()
 	}
 	return ok
 }
@@ -282,8 +360,71 @@ func (m *Map) Method(name string, args []Val) (Val, error) {
 		ok := m.Has(args[0].String())
 		return NewValBool(ok), nil
 
+	case "keys":
+		if _, err := mpMapKeys.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		return NewValStrList(m.Keys()), nil
+
+	case "values":
+		if _, err := mpMapValues.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		o := NewValList()
+		for _, k := range m.Keys() {
+			o.AddList(m.data[k].val)
+		}
+		return o, nil
+
+	case "entries":
+		if _, err := mpMapEntries.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		o := NewValList()
+		for _, k := range m.Keys() {
+			o.AddList(NewValPair(NewValStr(k), m.data[k].val))
+		}
+		return o, nil
+
+	case "merge":
+		alog, err := mpMapMerge.Check(args)
+		if err != nil {
+			return NewValNull(), err
+		}
+		m.Merge(args[0].Map(), alog == 2 && args[1].Bool())
+		return NewValMapFromMap(m), nil
+
+	case "delete":
+		if _, err := mpMapDelete.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		return NewValBool(m.Del(args[0].String())), nil
+
+	case "get_or":
+		if _, err := mpMapGetOr.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		v, ok := m.Get(args[0].String())
+		if !ok {
+			return args[1], nil
+		}
+		return v, nil
+
+	case "ordered":
+		if _, err := mpMapOrdered.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		return NewValBool(m.ordered), nil
+
+	case "set_ordered":
+		if _, err := mpMapSetOrdered.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		m.SetOrdered(args[0].Bool())
+		return NewValMapFromMap(m), nil
+
 	default:
-		return NewValNull(), fmt.Errorf("method: list:%s is unknown", name)
+		return NewValNull(), fmt.Errorf("method: map:%s is unknown", name)
 	}
 }
 
@@ -2,30 +2,86 @@ package pl
 
 import (
 	"fmt"
+	"math"
 	"reflect"
+	"strings"
+	"time"
+)
+
+// Go value <-> Val conversion via reflection. Struct fields are converted
+// from/to map entries, the key is the field name or the name specified by the
+// pl tag, ie
+//
+//   type Foo struct {
+//     Name  string    `pl:"name"`
+//     Extra *Bar      `pl:"extra,omitempty"`
+//     Skip  int       `pl:"-"`
+//     When  time.Time `pl:"when"`
+//   }
+//
+// Unexported fields are ignored and fields of embedded struct without tag are
+// flattened into the outer struct. time.Time is converted into RFC3339 string
+// and can be unmarshaled from RFC3339 string or unix timestamp in seconds.
+// Val itself is kept as is in both direction. A cyclic value, ie a pointer
+// or a map containing itself, cannot be converted and results in an error.
+
+var (
+	marshalTimeType = reflect.TypeOf(time.Time{})
+	marshalValType  = reflect.TypeOf(Val{})
 )
 
 // quick go interface{} to pl.Val style
 func MarshalVal(v interface{}) (Val, error) {
-	vv := reflect.ValueOf(v)
-	return marshalValue(vv)
+	return Marshal(v)
+}
+
+func Marshal(v interface{}) (Val, error) {
+	return marshalValue(reflect.ValueOf(v), make(marshalSeen))
+}
+
+// identity of the pointer, map or slice being marshaled, the length tells
+// apart slices sharing the same backing array
+type marshalRef struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
 }
 
-func marshalValue(value reflect.Value) (Val, error) {
+type marshalSeen map[marshalRef]struct{}
+
+func marshalValue(value reflect.Value, seen marshalSeen) (Val, error) {
 	// 1. nil
-	if visnil(value) {
+	if !value.IsValid() || visnil(value) {
 		return NewValNull(), nil
 	}
 
-	// 2. byte array
-	if value.Kind() == reflect.Slice {
-		ev := value.Elem()
-		ifc := value.Interface()
-		if ev.Kind() == reflect.Uint8 {
-			barray, ok := ifc.([]byte)
-			must(ok, "must be convertable")
-			return NewValStr(string(barray)), nil
+	// 2. cycle, only the values currently on the path are tracked, so a shared
+	// but acyclic value is fine
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		ref := marshalRef{ptr: value.Pointer(), typ: value.Type()}
+		if value.Kind() == reflect.Slice {
+			ref.len = value.Len()
+		}
+		if _, ok := seen[ref]; ok {
+			return NewValNull(), fmt.Errorf("cyclic value of type %s cannot be converted to Val",
+				value.Type().String())
 		}
+		seen[ref] = struct{}{}
+		defer delete(seen, ref)
+	}
+
+	// 3. special types
+	switch value.Type() {
+	case marshalValType:
+		return value.Interface().(Val), nil
+	case marshalTimeType:
+		return NewValStr(value.Interface().(time.Time).Format(time.RFC3339Nano)), nil
+	}
+
+	// 4. byte array
+	if value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Uint8 {
+		return NewValStr(string(value.Bytes())), nil
 	}
 
 	switch value.Kind() {
@@ -59,30 +115,30 @@ func marshalValue(value reflect.Value) (Val, error) {
 		return NewValNull(), fmt.Errorf("internal type cannot be converted to Val")
 
 	case reflect.Slice:
-		return marshalSlice(value)
+		return marshalSlice(value, seen)
 
 	case reflect.Array:
-		return marshalArray(value)
+		return marshalArray(value, seen)
 
-	case reflect.Interface:
-		return marshalValue(value.Elem())
+	case reflect.Interface, reflect.Ptr:
+		return marshalValue(value.Elem(), seen)
 
 	case reflect.Map:
-		return marshalMap(value)
+		return marshalMap(value, seen)
 
 	case reflect.Struct:
-		return marshalStruct(value)
+		return marshalStruct(value, seen)
 
 	default:
 		return NewValNull(), fmt.Errorf("unknwon type")
 	}
 }
 
-func marshalSlice(v reflect.Value) (Val, error) {
+func marshalSlice(v reflect.Value, seen marshalSeen) (Val, error) {
 	o := NewValList()
 	l := v.Len()
 	for i := 0; i < l; i++ {
-		vv, err := marshalValue(v.Index(i))
+		vv, err := marshalValue(v.Index(i), seen)
 		if err != nil {
 			return NewValNull(), err
 		}
@@ -91,16 +147,16 @@ func marshalSlice(v reflect.Value) (Val, error) {
 	return o, nil
 }
 
-func marshalArray(v reflect.Value) (Val, error) {
-	return marshalSlice(v)
+func marshalArray(v reflect.Value, seen marshalSeen) (Val, error) {
+	return marshalSlice(v, seen)
 }
 
-func marshalMap(v reflect.Value) (Val, error) {
+func marshalMap(v reflect.Value, seen marshalSeen) (Val, error) {
 	m := NewValMap()
 	iter := v.MapRange()
 	for iter.Next() {
 		k := iter.Key()
-		kval, err := marshalValue(k)
+		kval, err := marshalValue(k, seen)
 		if err != nil {
 			return NewValNull(), err
 		}
@@ -109,7 +165,7 @@ func marshalMap(v reflect.Value) (Val, error) {
 		}
 
 		v := iter.Value()
-		vval, err := marshalValue(v)
+		vval, err := marshalValue(v, seen)
 		if err != nil {
 			return NewValNull(), err
 		}
@@ -119,17 +175,366 @@ func marshalMap(v reflect.Value) (Val, error) {
 	return m, nil
 }
 
-func marshalStruct(v reflect.Value) (Val, error) {
+// a struct field visible to marshaling
+type marshalField struct {
+	name      string
+	index     []int
+	omitempty bool
+}
+
+// collect the fields of struct type, embedded struct without tag are
+// flattened and outer fields shadow the inner ones
+func marshalFields(t reflect.Type) []marshalField {
+	out := []marshalField{}
+	seen := map[string]bool{}
+
+	var walk func(reflect.Type, []int)
+	walk = func(t reflect.Type, prefix []int) {
+		var embedded []reflect.StructField
+
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			tag, hasTag := sf.Tag.Lookup("pl")
+			if tag == "-" {
+				continue
+			}
+			if sf.Anonymous && !hasTag && sf.Type.Kind() == reflect.Struct {
+				embedded = append(embedded, sf)
+				continue
+			}
+			if sf.PkgPath != "" {
+				continue
+			}
+
+			name := sf.Name
+			opts := strings.Split(tag, ",")
+			if opts[0] != "" {
+				name = opts[0]
+			}
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			f := marshalField{
+				name:  name,
+				index: append(append([]int{}, prefix...), i),
+			}
+			for _, o := range opts[1:] {
+				if o == "omitempty" {
+					f.omitempty = true
+				}
+			}
+			out = append(out, f)
+		}
+
+		for _, sf := range embedded {
+			walk(sf.Type, append(append([]int{}, prefix...), sf.Index...))
+		}
+	}
+
+	walk(t, nil)
+	return out
+}
+
+func marshalIsEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Struct:
+		if v.Type() == marshalTimeType {
+			return v.Interface().(time.Time).IsZero()
+		}
+		return false
+	default:
+		return v.IsZero()
+	}
+}
+
+func marshalStruct(v reflect.Value, seen marshalSeen) (Val, error) {
 	m := NewValMap()
-	n := v.NumField()
-	t := v.Type()
-	for i := 0; i < n; i++ {
-		name := t.Field(i).Name
-		v, err := marshalValue(v.Index(i))
+	for _, f := range marshalFields(v.Type()) {
+		fv := v.FieldByIndex(f.index)
+		if f.omitempty && marshalIsEmpty(fv) {
+			continue
+		}
+		vv, err := marshalValue(fv, seen)
 		if err != nil {
-			return NewValNull(), err
+			return NewValNull(), fmt.Errorf("field %s: %s", f.name, err.Error())
 		}
-		m.AddMap(name, v)
+		m.AddMap(f.name, vv)
 	}
 	return m, nil
 }
+
+// Val to go value, the out must be a none nil pointer. Null value resets the
+// target into zero value, and map entry which has no corresponding struct
+// field is ignored. A cyclic value, ie a map containing itself, is rejected
+func Unmarshal(v Val, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("unmarshal target must be none nil pointer")
+	}
+	return unmarshalRoot(v, rv.Elem())
+}
+
+func unmarshalRoot(v Val, out reflect.Value) error {
+	// Val is kept as is, no need to walk it
+	if out.Type() != marshalValType {
+		if err := unmarshalCheckCycle(v, make(map[interface{}]struct{}), ""); err != nil {
+			return err
+		}
+	}
+	return unmarshalValue(v, out, "")
+}
+
+// the list, map and pair currently on the path are tracked, a shared but
+// acyclic value is fine
+func unmarshalCheckCycle(v Val, seen map[interface{}]struct{}, path string) error {
+	var ref interface{}
+	switch v.Type {
+	case ValList:
+		ref = v.List()
+	case ValMap:
+		ref = v.Map()
+	case ValPair:
+		ref = v.Pair()
+	default:
+		return nil
+	}
+	if _, ok := seen[ref]; ok {
+		if path == "" {
+			return fmt.Errorf("cannot unmarshal cyclic %s", v.Id())
+		}
+		return fmt.Errorf("cannot unmarshal cyclic %s at %s", v.Id(), path)
+	}
+	seen[ref] = struct{}{}
+	defer delete(seen, ref)
+
+	switch v.Type {
+	case ValList:
+		for i, x := range v.List().Data {
+			if err := unmarshalCheckCycle(x, seen, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case ValMap:
+		var err error
+		v.Map().Foreach(
+			func(key string, value Val) bool {
+				err = unmarshalCheckCycle(value, seen, unmarshalPath(path, key))
+				return err == nil
+			},
+		)
+		return err
+	default:
+		if err := unmarshalCheckCycle(v.Pair().First, seen, unmarshalPath(path, "first")); err != nil {
+			return err
+		}
+		return unmarshalCheckCycle(v.Pair().Second, seen, unmarshalPath(path, "second"))
+	}
+	return nil
+}
+
+func unmarshalPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+func unmarshalError(v Val, t reflect.Type, path string) error {
+	if path == "" {
+		return fmt.Errorf("cannot unmarshal %s into %s", v.Id(), t.String())
+	}
+	return fmt.Errorf("cannot unmarshal %s into %s at %s", v.Id(), t.String(), path)
+}
+
+func unmarshalValue(v Val, out reflect.Value, path string) error {
+	t := out.Type()
+
+	switch t {
+	case marshalValType:
+		out.Set(reflect.ValueOf(v))
+		return nil
+
+	case marshalTimeType:
+		switch v.Type {
+		case ValStr:
+			tm, err := time.Parse(time.RFC3339Nano, v.String())
+			if err != nil {
+				return fmt.Errorf("invalid time at %s: %s", path, err.Error())
+			}
+			out.Set(reflect.ValueOf(tm))
+			return nil
+		case ValInt:
+			out.Set(reflect.ValueOf(time.Unix(v.Int(), 0)))
+			return nil
+		case ValNull:
+			out.Set(reflect.Zero(t))
+			return nil
+		default:
+			return unmarshalError(v, t, path)
+		}
+	}
+
+	if v.IsNull() {
+		out.Set(reflect.Zero(t))
+		return nil
+	}
+
+	switch out.Kind() {
+	case reflect.Ptr:
+		if out.IsNil() {
+			out.Set(reflect.New(t.Elem()))
+		}
+		return unmarshalValue(v, out.Elem(), path)
+
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			return unmarshalError(v, t, path)
+		}
+		x, err := toctx(v)
+		if err != nil {
+			return unmarshalError(v, t, path)
+		}
+		out.Set(reflect.ValueOf(&x).Elem())
+		return nil
+
+	case reflect.Bool:
+		if v.Type != ValBool {
+			return unmarshalError(v, t, path)
+		}
+		out.SetBool(v.Bool())
+		return nil
+
+	case reflect.Int,
+		reflect.Int8,
+		reflect.Int16,
+		reflect.Int32,
+		reflect.Int64:
+		i, ok := unmarshalInt(v)
+		if !ok || out.OverflowInt(i) {
+			return unmarshalError(v, t, path)
+		}
+		out.SetInt(i)
+		return nil
+
+	case reflect.Uint,
+		reflect.Uint8,
+		reflect.Uint16,
+		reflect.Uint32,
+		reflect.Uint64:
+		i, ok := unmarshalInt(v)
+		if !ok || i < 0 || out.OverflowUint(uint64(i)) {
+			return unmarshalError(v, t, path)
+		}
+		out.SetUint(uint64(i))
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		switch v.Type {
+		case ValInt:
+			out.SetFloat(float64(v.Int()))
+		case ValReal:
+			out.SetFloat(v.Real())
+		default:
+			return unmarshalError(v, t, path)
+		}
+		return nil
+
+	case reflect.String:
+		if v.Type != ValStr {
+			return unmarshalError(v, t, path)
+		}
+		out.SetString(v.String())
+		return nil
+
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 && v.Type == ValStr {
+			out.SetBytes([]byte(v.String()))
+			return nil
+		}
+		if v.Type != ValList {
+			return unmarshalError(v, t, path)
+		}
+		data := v.List().Data
+		s := reflect.MakeSlice(t, len(data), len(data))
+		for i, x := range data {
+			if err := unmarshalValue(x, s.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		out.Set(s)
+		return nil
+
+	case reflect.Array:
+		if v.Type != ValList || v.List().Length() != out.Len() {
+			return unmarshalError(v, t, path)
+		}
+		for i, x := range v.List().Data {
+			if err := unmarshalValue(x, out.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		if v.Type != ValMap || t.Key().Kind() != reflect.String {
+			return unmarshalError(v, t, path)
+		}
+		m := reflect.MakeMap(t)
+		var err error
+		v.Map().Foreach(
+			func(key string, value Val) bool {
+				x := reflect.New(t.Elem()).Elem()
+				if err = unmarshalValue(value, x, unmarshalPath(path, key)); err != nil {
+					return false
+				}
+				m.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), x)
+				return true
+			},
+		)
+		if err != nil {
+			return err
+		}
+		out.Set(m)
+		return nil
+
+	case reflect.Struct:
+		if v.Type != ValMap {
+			return unmarshalError(v, t, path)
+		}
+		m := v.Map()
+		for _, f := range marshalFields(t) {
+			x, ok := m.Get(f.name)
+			if !ok {
+				continue
+			}
+			if err := unmarshalValue(x, out.FieldByIndex(f.index), unmarshalPath(path, f.name)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return unmarshalError(v, t, path)
+	}
+}
+
+func unmarshalInt(v Val) (int64, bool) {
+	switch v.Type {
+	case ValInt:
+		return v.Int(), true
+	case ValReal:
+		r := v.Real()
+		if r != math.Trunc(r) || r > math.MaxInt64 || r < math.MinInt64 {
+			return 0, false
+		}
+		return int64(r), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,202 @@
+package pl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type marshalInner struct {
+	X int `pl:"x"`
+}
+
+type marshalBase struct {
+	Id string `pl:"id"`
+}
+
+type marshalOuter struct {
+	marshalBase
+	Name    string          `pl:"name"`
+	Age     uint8           `pl:"age"`
+	Score   float64         `pl:"score"`
+	Tags    []string        `pl:"tags"`
+	Attr    map[string]int  `pl:"attr"`
+	Inner   marshalInner    `pl:"inner"`
+	Ptr     *marshalInner   `pl:"ptr,omitempty"`
+	List    []*marshalInner `pl:"list"`
+	When    time.Time       `pl:"when"`
+	Any     interface{}     `pl:"any"`
+	Raw     Val             `pl:"raw"`
+	Data    []byte          `pl:"data"`
+	Fixed   [2]int          `pl:"fixed"`
+	Skip    int             `pl:"-"`
+	NoTag   bool
+	private int
+	Nested  map[string][]bool `pl:"nested"`
+}
+
+func TestMarshal(t *testing.T) {
+	assert := assert.New(t)
+
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	in := marshalOuter{
+		marshalBase: marshalBase{Id: "id0"},
+		Name:        "a",
+		Age:         10,
+		Score:       1.5,
+		Tags:        []string{"x", "y"},
+		Attr:        map[string]int{"k": 1},
+		Inner:       marshalInner{X: 1},
+		List:        []*marshalInner{{X: 2}, nil},
+		When:        when,
+		Any:         map[string]interface{}{"a": 1},
+		Raw:         NewValStr("raw"),
+		Data:        []byte("bytes"),
+		Fixed:       [2]int{1, 2},
+		Skip:        10,
+		NoTag:       true,
+		private:     1,
+		Nested:      map[string][]bool{"a": {true}},
+	}
+
+	v, err := Marshal(&in)
+	assert.Nil(err)
+	assert.True(v.IsMap())
+
+	m := v.Map()
+	get := func(k string) *Val {
+		x, ok := m.Get(k)
+		assert.True(ok, k)
+		return &x
+	}
+
+	assert.Equal("id0", get("id").String())
+	assert.Equal("a", get("name").String())
+	assert.Equal(int64(10), get("age").Int())
+	assert.Equal(1.5, get("score").Real())
+	assert.Equal(2, get("tags").List().Length())
+	assert.Equal("2020-01-02T03:04:05Z", get("when").String())
+	assert.Equal("raw", get("raw").String())
+	assert.Equal("bytes", get("data").String())
+	assert.True(get("NoTag").Bool())
+	assert.True(get("list").List().Data[1].IsNull())
+	assert.False(m.Has("ptr"))
+	assert.False(m.Has("Skip"))
+	assert.False(m.Has("private"))
+
+	var out marshalOuter
+	assert.Nil(Unmarshal(v, &out))
+	in.Skip = 0
+	in.private = 0
+	in.Any = map[string]interface{}{"a": int64(1)}
+	assert.Equal(in, out)
+
+	// pointer allocation, number conversion and time from unix timestamp
+	src := NewValMap()
+	src.AddMap("ptr", NewValMap())
+	p, _ := src.Map().Get("ptr")
+	p.AddMap("x", NewValReal(3))
+	src.AddMap("score", NewValInt(2))
+	src.AddMap("when", NewValInt(10))
+	src.AddMap("unknown", NewValInt(1))
+
+	var out2 marshalOuter
+	assert.Nil(Unmarshal(src, &out2))
+	assert.Equal(3, out2.Ptr.X)
+	assert.Equal(2.0, out2.Score)
+	assert.Equal(int64(10), out2.When.Unix())
+
+	// errors
+	for _, x := range []struct {
+		key string
+		val Val
+	}{
+		{"name", NewValInt(1)},
+		{"age", NewValInt(256)},
+		{"age", NewValInt(-1)},
+		{"inner", NewValInt(1)},
+		{"tags", NewValListRaw([]Val{NewValInt(1)})},
+		{"fixed", NewValListRaw([]Val{NewValInt(1)})},
+		{"score", NewValStr("1")},
+		{"when", NewValStr("yesterday")},
+		{"ptr", NewValStr("x")},
+	} {
+		src := NewValMap()
+		src.AddMap(x.key, x.val)
+		var out marshalOuter
+		assert.NotNil(Unmarshal(src, &out), x.key)
+	}
+
+	assert.NotNil(Unmarshal(NewValInt(1), out))
+	assert.NotNil(Unmarshal(NewValInt(1), (*int)(nil)))
+
+	var i int
+	assert.NotNil(Unmarshal(NewValReal(1.5), &i))
+	assert.Nil(Unmarshal(NewValReal(2), &i))
+	assert.Equal(2, i)
+
+	_, err = Marshal(func() {})
+	assert.NotNil(err)
+	nv, err := Marshal(nil)
+	assert.Nil(err)
+	assert.True(nv.IsNull())
+}
+
+type marshalNode struct {
+	Name string       `pl:"name"`
+	Next *marshalNode `pl:"next"`
+}
+
+func TestMarshalCycle(t *testing.T) {
+	assert := assert.New(t)
+
+	// go value pointing to itself
+	n := &marshalNode{Name: "a"}
+	n.Next = n
+	_, err := Marshal(n)
+	assert.NotNil(err)
+
+	m := map[string]interface{}{}
+	m["self"] = m
+	_, err = Marshal(m)
+	assert.NotNil(err)
+
+	l := []interface{}{nil}
+	l[0] = l
+	_, err = Marshal(l)
+	assert.NotNil(err)
+
+	// shared but acyclic value is fine
+	shared := &marshalNode{Name: "b"}
+	v, err := Marshal([]*marshalNode{shared, shared})
+	assert.Nil(err)
+	assert.Equal(2, v.List().Length())
+
+	// val containing itself
+	src := NewValMap()
+	src.AddMap("name", NewValStr("a"))
+	src.AddMap("next", src)
+	var out marshalNode
+	assert.NotNil(Unmarshal(src, &out))
+
+	var any interface{}
+	assert.NotNil(Unmarshal(src, &any))
+
+	lst := NewValList()
+	lst.AddList(lst)
+	assert.NotNil(Unmarshal(lst, &any))
+
+	// kept as is
+	var raw Val
+	assert.Nil(Unmarshal(src, &raw))
+
+	sharedVal := NewValMap()
+	sharedVal.AddMap("name", NewValStr("c"))
+	pair := NewValList()
+	pair.AddList(sharedVal)
+	pair.AddList(sharedVal)
+	var nodes []marshalNode
+	assert.Nil(Unmarshal(pair, &nodes))
+	assert.Equal(2, len(nodes))
+}
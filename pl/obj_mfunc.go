@@ -5,15 +5,19 @@ import (
 )
 
 type methodFunc struct {
-	recv  Val
-	name  string
-	entry MethodFn
+	recv      Val
+	name      string
+	entry     MethodFn
+	evalEntry func(*Evaluator, string, []Val) (Val, error)
 }
 
 func (f *methodFunc) Call(
-	_ *Evaluator,
+	e *Evaluator,
 	args []Val,
 ) (Val, error) {
+	if f.evalEntry != nil {
+		return f.evalEntry(e, f.name, args)
+	}
 	return f.entry(f.name, args)
 }
 
@@ -50,3 +54,13 @@ func newMethodFunc(
 		name:  name,
 	}
 }
+
+func newEvalMethodFunc(
+	entry func(*Evaluator, string, []Val) (Val, error),
+	name string,
+) *methodFunc {
+	return &methodFunc{
+		evalEntry: entry,
+		name:      name,
+	}
+}
@@ -41,6 +41,57 @@ func (f *scriptFunc) ToString() (string, error) {
 	return f.Id(), nil
 }
 
+// check whether the function can be shared among evaluators running in
+// parallel, ie all its captured upvalues must be thread safe and neither it nor
+// any function it calls or creates is allowed to modify upvalue, session,
+// global or dynamic variable. Closure obtained at runtime, ie from session, is
+// not known here and is guarded by the evaluator instead
+func (f *scriptFunc) checkShareable() error {
+	for idx, uv := range f.upvalue {
+		if !uv.IsThreadSafe() {
+			return fmt.Errorf("captures upvalue(%d) of type %s which is not thread safe",
+				idx, uv.Id())
+		}
+	}
+
+	visited := make(map[*program]bool)
+	pending := []*program{f.prog}
+	for len(pending) != 0 {
+		p := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if visited[p] {
+			continue
+		}
+		visited[p] = true
+
+		where := ""
+		if p != f.prog {
+			where = fmt.Sprintf(" in function %s", p.name)
+		}
+
+		for _, bc := range p.bcList {
+			switch bc.opcode {
+			case bcStoreUpvalue:
+				return fmt.Errorf("cannot modify upvalue%s", where)
+			case bcStoreSession:
+				return fmt.Errorf("cannot modify session variable%s", where)
+			case bcStoreGlobal:
+				return fmt.Errorf("cannot modify global variable%s", where)
+			case bcStoreVar:
+				return fmt.Errorf("cannot modify variable%s", where)
+			case bcNewClosure, bcLoadIterator:
+				pending = append(pending, p.module.fn[bc.argument])
+			default:
+				break
+			}
+		}
+		for _, idx := range p.scall {
+			pending = append(pending, p.module.fn[idx])
+		}
+	}
+	return nil
+}
+
 func newScriptFunc(p *program) *scriptFunc {
 	return &scriptFunc{
 		prog:    p,
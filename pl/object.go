@@ -129,6 +129,13 @@ type Usr interface {
 	// support invocation operations, ie calling a user type
 }
 
+// Optionally implemented by Usr whose method needs the evaluator which invokes
+// it, ie the method calls back into script closure. When implemented, the
+// method call from script is dispatched to EvalMethod instead of Method
+type UsrEvalMethod interface {
+	EvalMethod(*Evaluator, string, []Val) (Val, error)
+}
+
 type Val struct {
 	Type  int
 	vData interface{}
@@ -662,6 +669,61 @@ func (v *Val) ToIndex() (int, error) {
 	}
 }
 
+// convert the value into an index of a sequence with the given size, negative
+// value is relative to the end of the sequence, ie -1 is the last element
+func (v *Val) ToRelIndex(size int) (int, error) {
+	if v.Type != ValInt {
+		return 0, fmt.Errorf("none integer type cannot be index")
+	}
+	i := int(v.Int())
+	if i < 0 {
+		i += size
+		if i < 0 {
+			return 0, fmt.Errorf("index out of range")
+		}
+	}
+	return i, nil
+}
+
+// convert the slice bound pair into [start, end) range of a sequence with the
+// given size. null means the bound is omitted, and the range is clamped into
+// the sequence just like python does
+func sliceRange(start, end Val, size int) (int, int, error) {
+	bound := func(x Val, def int) (int, error) {
+		switch x.Type {
+		case ValNull:
+			return def, nil
+		case ValInt:
+			i := int(x.Int())
+			if i < 0 {
+				i += size
+			}
+			if i < 0 {
+				i = 0
+			}
+			if i > size {
+				i = size
+			}
+			return i, nil
+		default:
+			return 0, fmt.Errorf("none integer type cannot be slice bound")
+		}
+	}
+
+	s, err := bound(start, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	e, err := bound(end, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	if e < s {
+		e = s
+	}
+	return s, e, nil
+}
+
 func (v *Val) ToString() (string, error) {
 	switch v.Type {
 	case ValInt:
@@ -708,7 +770,7 @@ func (v *Val) Index(idx Val) (Val, error) {
 		return NewValNull(), fmt.Errorf("cannot index regexp")
 
 	case ValStr:
-		i, err := idx.ToIndex()
+		i, err := idx.ToRelIndex(len(v.String()))
 		if err != nil {
 			return NewValNull(), err
 		}
@@ -734,6 +796,27 @@ func (v *Val) Index(idx Val) (Val, error) {
 	}
 }
 
+// slice the value with [start, end) range, bound can be null which means it is
+// omitted. Only string and list can be sliced, string is sliced by bytes just
+// like indexing
+func (v *Val) Slice(start, end Val) (Val, error) {
+	switch v.Type {
+	case ValStr:
+		str := v.String()
+		s, e, err := sliceRange(start, end, len(str))
+		if err != nil {
+			return NewValNull(), err
+		}
+		return NewValStr(str[s:e]), nil
+
+	case ValList:
+		return v.List().Slice(start, end)
+
+	default:
+		return NewValNull(), fmt.Errorf("cannot slice type: %s", v.Id())
+	}
+}
+
 func (v *Val) IndexSet(idx, val Val) error {
 	switch v.Type {
 	case ValStr, ValInt, ValReal, ValBool, ValNull, ValIter, ValClosure:
@@ -1053,6 +1136,12 @@ func (v *Val) MethodClosure(name string) (Val, error) {
 		break
 
 	case ValUsr:
+		if x, ok := v.Usr().(UsrEvalMethod); ok {
+			return Val{
+				Type:  ValClosure,
+				vData: newEvalMethodFunc(x.EvalMethod, name),
+			}, nil
+		}
 		return NewValMethodFunction(
 			v.methodUsr,
 			name,
@@ -262,14 +262,22 @@ type parser struct {
 	modModName    string
 	modImportPath string
 
+	// expression nesting level, used to tell slice bound from method call since
+	// both use ':'. While parsing the start bound of a slice, a ':' at the same
+	// expression level terminates the bound instead of starting a method call
+	exprLevel  int
+	sliceLevel int
+
 	// helpers
 	fs fs.FS
 }
 
 func newParser(input string, fs fs.FS) *parser {
+	m := newModule()
+	m.fs = fs
 	return &parser{
 		l:      newLexer(input),
-		module: newModule(),
+		module: m,
 		fs:     fs,
 	}
 }
@@ -917,9 +925,11 @@ LOOP:
 		tk := p.l.token
 		switch tk {
 		case tkFunction:
-			// eat the fn token, parseFunction expect after the fn keyword
+			// eat the fn token, parseFunction expect after the fn keyword, the
+			// comment right before fn is the doc of the function
+			doc := p.l.comment
 			p.l.next()
-			if _, err := p.parseFunction(false); err != nil {
+			if _, err := p.parseFunction(false, doc); err != nil {
 				return err
 			}
 			break
@@ -994,9 +1004,11 @@ LOOP:
 			break
 
 		case tkFunction:
-			// eat the fn token, parseFunction expect after the fn keyword
+			// eat the fn token, parseFunction expect after the fn keyword, the
+			// comment right before fn is the doc of the function
+			doc := p.l.comment
 			p.l.next()
-			if _, err := p.parseFunction(false); err != nil {
+			if _, err := p.parseFunction(false, doc); err != nil {
 				return err
 			}
 			break
@@ -1168,7 +1180,7 @@ func (p *parser) fnExists(
 	return p.module.getFn(fname) != nil
 }
 
-func (p *parser) parseFunction(anony bool) (string, error) {
+func (p *parser) parseFunction(anony bool, doc string) (string, error) {
 	funcName, err := p.getCallName(anony)
 	if err != nil {
 		return "", err
@@ -1179,6 +1191,7 @@ func (p *parser) parseFunction(anony bool) (string, error) {
 	}
 
 	prog := newProgram(p.module, funcName, progFunc)
+	prog.doc = doc
 	p.enterScopeTop(entryFunc, prog)
 	defer func() {
 		p.leaveScope()
@@ -1216,6 +1229,7 @@ func (p *parser) parseFunctionRest(prog *program) error {
 			if idx == symError {
 				return p.err("argument name duplicated")
 			}
+			prog.argName = append(prog.argName, p.l.valueText)
 			p.l.next()
 			argcnt++
 
@@ -1392,8 +1406,14 @@ func (p *parser) parseRule() error {
 	// parsing the module name, we accept few different types to ease user's
 	// own syntax flavor
 	if p.l.token == tkStr || p.l.token == tkId {
+		isId := p.l.token == tkId
 		name = p.l.valueText
 		p.l.next()
+
+		// test "name" { ... } is a test block instead of a rule named test
+		if isId && name == "test" && p.l.token == tkStr {
+			return p.parseTest()
+		}
 	} else if p.l.token == tkLSqr {
 		p.l.next()
 		if p.l.token != tkStr && p.l.token != tkId {
@@ -1411,11 +1431,12 @@ func (p *parser) parseRule() error {
 	}
 
 	// notes the name must be none empty and also must not start with @, which is
-	// builtin event name
+	// builtin event name, except the @shutdown and @health rules which are
+	// written by user
 	if name == "" {
 		return p.err("invalid rule name, cannot be empty string")
 	}
-	if name[0] == '@' {
+	if name[0] == '@' && name != ShutdownRule && name != HealthRule {
 		return p.err("invalid rule name, cannot start with @ which is builtin name")
 	}
 
@@ -1455,6 +1476,40 @@ func (p *parser) parseRule() error {
 	return nil
 }
 
+// parse the test block, ie test "name" { ... }. The test is not an event
+// handler, it is only run by the test runner, see Evaluator.EvalTest
+func (p *parser) parseTest() error {
+	name := p.l.valueText
+	p.l.next()
+	if name == "" {
+		return p.err("invalid test name, cannot be empty string")
+	}
+	if p.module.getTest(name) != nil {
+		return p.errf("test %s has already been existed", name)
+	}
+
+	prog := newProgram(p.module, name, progRule)
+	p.enterScopeTop(entryRule, prog)
+
+	p.mustAddLocalVar("#event")
+	p.mustAddLocalVar("#frame")
+	defer func() {
+		p.leaveScope()
+	}()
+
+	localR := prog.patch(p.l)
+
+	if err := p.parseBody(prog); err != nil {
+		return err
+	}
+	prog.emit0(p.l, bcHalt)
+
+	prog.localSize = p.stbl.topMaxLocal() - 1
+	prog.emit1At(p.l, localR, bcReserveLocal, p.stbl.topMaxLocal()-1)
+	p.module.tests = append(p.module.tests, prog)
+	return nil
+}
+
 // parse basic statement, ie
 
 // 1) a function call
@@ -1790,7 +1845,13 @@ func (p *parser) parseBasicStmt(prog *program, lexeme lexeme, popExpr bool) (boo
 				break
 
 			default:
-				// tk assign
+				// tk assign, template directly assigned to body field is rendered
+				// lazily, see template_stream.go
+				if st == suffixDot && prog.idxStr(lastbc.argument) == "body" {
+					if sz := len(prog.bcList); sz > 0 && prog.bcList[sz-1].opcode == bcTemplate {
+						prog.bcList[sz-1].opcode = bcTemplateStream
+					}
+				}
 				break
 			}
 		}
@@ -2697,9 +2758,35 @@ func (p *parser) parseBody(prog *program) error {
 
 // Expression parsing, using simple precedence climbing style way
 func (p *parser) parseExpr(prog *program) error {
+	p.exprLevel++
+	defer func() {
+		p.exprLevel--
+	}()
 	return p.parseTernary(prog)
 }
 
+// parse the optional bound of a slice expression, when the bound is omitted a
+// null is pushed instead
+func (p *parser) parseSliceBound(prog *program, term int) error {
+	if p.l.token == term {
+		prog.emit0(p.l, bcLoadNull)
+		return nil
+	}
+
+	// only the start bound is terminated by ':', method call is allowed inside
+	// of the end bound
+	prevLevel := p.sliceLevel
+	if term == tkColon {
+		p.sliceLevel = p.exprLevel + 1
+	} else {
+		p.sliceLevel = 0
+	}
+	defer func() {
+		p.sliceLevel = prevLevel
+	}()
+	return p.parseExpr(prog)
+}
+
 func (p *parser) parseTryExpr(prog *program) error {
 	parseChunk := func(prog *program) error {
 		if p.l.token == tkLBra {
@@ -3152,7 +3239,7 @@ func (p *parser) parsePrimary(prog *program, l lexeme) error {
 		break
 
 	case tkFunction:
-		funcName, err := p.parseFunction(true)
+		funcName, err := p.parseFunction(true, "")
 		if err != nil {
 			return err
 		}
@@ -3182,7 +3269,7 @@ func (p *parser) parsePrimary(prog *program, l lexeme) error {
 	case tkRegex:
 		idx, err := prog.addRegexp(l.sval)
 		if err != nil {
-			return err
+			return p.errf("invalid regexp literal: %s", err.Error())
 		}
 		prog.emit1(p.l, bcLoadRegexp, idx)
 		break
@@ -3220,6 +3307,11 @@ func (p *parser) parsePrimary(prog *program, l lexeme) error {
 
 	// intrinsic related expressions
 	case tkTemplate:
+		// template::xxx refers to the template module instead of the template
+		// expression
+		if p.l.token == tkScope {
+			return p.parsePrefixExpr(prog, tkId, "template")
+		}
 		return p.parseTemplate(prog)
 
 	default:
@@ -3525,6 +3617,7 @@ func (p *parser) patchAllCall() {
 					idx := e.prog.addInt(int64(scallIdx))
 					e.prog.emit1At(p.l, e.entryPos, bcLoadInt, idx)
 					e.prog.emit1At(p.l, e.callPos, bcSCall, e.arg)
+					e.prog.scall = append(e.prog.scall, scallIdx)
 				}
 			} else {
 				// okay, the variable here is unknow to us, now let's just issue it
@@ -3543,6 +3636,7 @@ const (
 	suffixIndex
 	suffixCall
 	suffixMethod
+	suffixSlice
 )
 
 func (p *parser) parseSuffixImpl(prog *program, lastType *int) error {
@@ -3566,10 +3660,22 @@ SUFFIX:
 		case tkLSqr:
 			*lastType = suffixIndex
 			p.l.next()
-			if err := p.parseExpr(prog); err != nil {
+
+			// index, ie a[i], or slice, ie a[start:end] with optional bounds
+			if err := p.parseSliceBound(prog, tkColon); err != nil {
 				return err
 			}
-			prog.emit0(p.l, bcIndex)
+			if p.l.token == tkColon {
+				*lastType = suffixSlice
+				p.l.next()
+				if err := p.parseSliceBound(prog, tkRSqr); err != nil {
+					return err
+				}
+				prog.emit0(p.l, bcSlice)
+			} else {
+				prog.emit0(p.l, bcIndex)
+			}
+
 			if p.l.token != tkRSqr {
 				return p.err("invalid expression, expect ] to close index")
 			}
@@ -3577,6 +3683,13 @@ SUFFIX:
 			break
 
 		case tkColon:
+			// inside of the start bound of a slice, the colon is the method
+			// call only when followed by id and (, ie l[idx:length()]
+			if p.sliceLevel != 0 && p.sliceLevel == p.exprLevel {
+				if ntk1, ntk2 := p.l.peek2(); ntk1 != tkId || ntk2 != tkLPar {
+					break SUFFIX
+				}
+			}
 			*lastType = suffixMethod
 
 			if !p.l.expect(tkId) {
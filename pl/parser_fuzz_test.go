@@ -5,7 +5,6 @@ package pl
 // part for simplicity.
 
 import (
-	"fmt"
 	"os"
 	pa "path"
 	"path/filepath"
@@ -13,17 +12,6 @@ import (
 	"testing"
 )
 
-func verifyParser(code string) bool {
-	p := newParser(code, nil)
-	_, err := p.parse()
-	if err != nil {
-		fmt.Printf("error: %s", err.Error())
-		fmt.Printf("code:\n%s\n", code)
-		return false
-	}
-	return true
-}
-
 var testPath = "assets/test"
 
 func getTestPath() string {
@@ -70,7 +58,6 @@ func FuzzParser(f *testing.F) {
 	}
 
 	f.Fuzz(func(t *testing.T, data string) {
-		t.Logf("code: %s", data)
-		verifyParser(data)
+		FuzzParse(data)
 	})
 }
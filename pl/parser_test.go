@@ -7,6 +7,7 @@ package pl
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
 )
 
@@ -238,3 +239,164 @@ module1{
 		assert.True(err == nil)
 	}
 }
+
+func TestParserRegexpLiteral(t *testing.T) {
+	assert := assert.New(t)
+	{
+		p := newParser(
+			`
+test {
+  let a = r"[a-z]+";
+  let b = r"[a-z]+";
+}
+fn foo() {
+  return r"[a-z]+";
+}
+`, nil)
+		m, err := p.parse()
+		assert.True(err == nil)
+		assert.Equal(1, len(m.regexpCache))
+
+		// identical literal shares the same compiled regexp
+		assert.Equal(1, len(m.p[0].tbRegexp))
+		assert.True(m.p[0].tbRegexp[0] == m.fn[0].tbRegexp[0])
+		assert.True(m.cachedRegexp("[a-z]+") == m.fn[0].tbRegexp[0])
+	}
+
+	{
+		p := newParser(
+			`
+test {
+  let a = r"[a-z";
+}
+`, nil)
+		_, err := p.parse()
+		assert.True(err != nil)
+		assert.True(strings.Contains(err.Error(), "invalid regexp literal"))
+		assert.True(strings.Contains(err.Error(), "line 3"))
+	}
+}
+
+func TestParserBuiltinRuleName(t *testing.T) {
+	assert := assert.New(t)
+	{
+		p := newParser(
+			`
+rule "@shutdown" {
+  println("bye");
+}
+`, nil)
+		m, err := p.parse()
+		assert.True(err == nil)
+		assert.True(m.HaveEvent(ShutdownRule))
+	}
+
+	{
+		p := newParser(
+			`
+rule "@health" {
+  return true;
+}
+`, nil)
+		m, err := p.parse()
+		assert.True(err == nil)
+		assert.True(m.HaveEvent(HealthRule))
+	}
+
+	{
+		p := newParser(
+			`
+rule "@session" {
+  println("bye");
+}
+`, nil)
+		_, err := p.parse()
+		assert.True(err != nil)
+		assert.True(strings.Contains(err.Error(), "cannot start with @"))
+	}
+}
+
+func TestModuleRulesAndFunctions(t *testing.T) {
+	assert := assert.New(t)
+	p := newParser(
+		`
+fn add(a, b) {
+  return a + b;
+}
+fn twice(a) {
+  let f = fn(x) { return x * 2; };
+  return f(a);
+}
+rule "http.request" {
+  println(add(1, 2));
+}
+rule "http.response" {
+  println("done");
+}
+rule "http.request" {
+  println("again");
+}
+`, nil)
+	m, err := p.parse()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal([]string{"http.request", "http.response"}, m.Rules())
+	assert.Equal([]string{"add", "twice"}, m.Functions())
+}
+
+func TestParseModuleHeader(t *testing.T) {
+	assert := assert.New(t)
+	{
+		h, err := ParseModuleHeader(`
+module shared::util
+import "lib/a.pl"
+import (
+  "lib/b.pl"
+  "lib/c.pl"
+)
+fn add(a, b) {
+  return a + b;
+}
+`)
+		assert.Nil(err)
+		assert.Equal("shared::util", h.Name)
+		assert.Equal([]string{"lib/a.pl", "lib/b.pl", "lib/c.pl"}, h.Imports)
+	}
+	{
+		h, err := ParseModuleHeader(`
+import "lib/a.pl"
+rule "http.request" {
+  println("hello");
+}
+`)
+		assert.Nil(err)
+		assert.Equal("", h.Name)
+		assert.Equal([]string{"lib/a.pl"}, h.Imports)
+	}
+	{
+		_, err := ParseModuleHeader(`import 1`)
+		assert.NotNil(err)
+	}
+}
+
+// inside of the start bound of a slice, the colon followed by id and ( is a
+// method call, otherwise it separates the bounds
+func TestParserSliceMethodCall(t *testing.T) {
+	assert := assert.New(t)
+	for _, x := range []struct {
+		code   string
+		expect int
+	}{
+		// method call of the index, ie l[s.length()]
+		{`test { let l = [1, 2, 3, 4]; let s = "ab"; output => l[s:length()]; }`, 3},
+		{`test { let l = [1, 2, 3, 4]; let s = "ab"; output => l[(s):length()]; }`, 3},
+		// slice
+		{`test { let l = [1, 2, 3, 4]; let n = 3; output => l[1:n]:length(); }`, 2},
+		{`test { let l = [1, 2, 3, 4]; let s = "ab"; output => l[1:s:length()]:length(); }`, 1},
+		{`test { let l = [1, 2, 3, 4]; output => l[1:]:length(); }`, 3},
+		{`test { let l = [1, 2, 3, 4]; output => l[:l:length()]:length(); }`, 4},
+	} {
+		assert.True(testInt(x.code, x.expect), x.code)
+	}
+}
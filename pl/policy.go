@@ -0,0 +1,66 @@
+package pl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IntrinsicPolicy restricts which intrinsic functions a script can reach, it is
+// used to host untrusted script, ie deny fs::, env:: and http:: for a tenant.
+// Each rule is either a module name, ie "fs", which matches all the functions
+// of the module, a full function name, ie "http::get" or "print", or "*" which
+// matches every function.
+//
+// A function is denied when it matches any Deny rule; otherwise when Allow is
+// not empty, the function must match one of the Allow rules. The policy is
+// enforced when the intrinsic function is called or loaded as a value.
+type IntrinsicPolicy struct {
+	Allow []string
+	Deny  []string
+}
+
+func NewIntrinsicPolicy(allow, deny []string) *IntrinsicPolicy {
+	return &IntrinsicPolicy{
+		Allow: allow,
+		Deny:  deny,
+	}
+}
+
+func policyRuleMatch(rule, name string) bool {
+	if rule == "*" || rule == name {
+		return true
+	}
+	m, _ := splitFuncName(name)
+	return m != "" && (rule == m || strings.HasPrefix(m, rule+"::"))
+}
+
+func policyMatch(rules []string, name string) bool {
+	for _, r := range rules {
+		if policyRuleMatch(r, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// checks whether the intrinsic function with full name, ie fs::read, is
+// allowed by the policy. A nil policy allows everything
+func (p *IntrinsicPolicy) Allowed(name string) bool {
+	if p == nil {
+		return true
+	}
+	if policyMatch(p.Deny, name) {
+		return false
+	}
+	if len(p.Allow) != 0 {
+		return policyMatch(p.Allow, name)
+	}
+	return true
+}
+
+func (p *IntrinsicPolicy) check(name string) error {
+	if !p.Allowed(name) {
+		return fmt.Errorf("intrinsic function %s is not allowed by policy", name)
+	}
+	return nil
+}
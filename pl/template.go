@@ -3,37 +3,128 @@ package pl
 import (
 	"bytes"
 	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
 
 	// go template
+	htemplate "html/template"
 	"text/template"
 
 	// pongo
 	"github.com/flosch/pongo2"
-
-	// markdown
-	"github.com/gomarkdown/markdown"
-	"github.com/gomarkdown/markdown/html"
 )
 
 type Template interface {
 	Compile(name, input string, opt Val) error
 	Execute(context Val) (string, error)
+
+	// render the template directly into the writer instead of buffering the
+	// whole output in a string, notes partial output may have been written
+	// when error is returned
+	ExecuteStream(context Val, w io.Writer) error
+}
+
+// helper to implement Execute on top of ExecuteStream
+func executeToString(t Template, ctx Val) (string, error) {
+	x := new(bytes.Buffer)
+	if err := t.ExecuteStream(ctx, x); err != nil {
+		return "", err
+	}
+	return x.String(), nil
 }
 
 type TemplateFactory interface {
 	Create() Template
 }
 
+// common template options, specified in the template selector, ie
+// template "go[left='<%', right='%>', escape='html']". Unknown options are
+// ignored and each engine picks up the options it supports. The left and
+// right options are the delimiters of the template action, and the escape
+// option, either a boolean or "html"/"none", controls whether the rendered
+// value is html escaped
+type templateOption struct {
+	left   string
+	right  string
+	escape bool
+}
+
+func parseTemplateOption(opt Val, def templateOption) (templateOption, error) {
+	o := def
+	if !opt.IsMap() {
+		return o, nil
+	}
+	m := opt.Map()
+
+	for _, kv := range []struct {
+		key string
+		out *string
+	}{
+		{"left", &o.left},
+		{"right", &o.right},
+	} {
+		if x, ok := m.Get(kv.key); ok {
+			if !x.IsString() || x.String() == "" {
+				return o, fmt.Errorf("template option %s must be none empty string", kv.key)
+			}
+			*kv.out = x.String()
+		}
+	}
+
+	if x, ok := m.Get("escape"); ok {
+		switch {
+		case x.IsBool():
+			o.escape = x.Bool()
+		case x.IsString() && x.String() == "html":
+			o.escape = true
+		case x.IsString() && x.String() == "none":
+			o.escape = false
+		default:
+			return o, fmt.Errorf("template option escape must be boolean, 'html' or 'none'")
+		}
+	}
+	return o, nil
+}
+
+func hasTemplateOption(opt Val, key string) bool {
+	return opt.IsMap() && opt.Map().Has(key)
+}
+
+// go template, when escape option is on, the html/template package is used
+// instead of text/template
 type goTemplate struct {
-	goT *template.Template
+	exec func(io.Writer, interface{}) error
+
+	// functions of the module, see template::add_func
+	funcs map[string]interface{}
 }
 
-func (t *goTemplate) Compile(name, input string, _ Val) error {
-	tp, err := template.New(name).Parse(input)
+func (t *goTemplate) Compile(name, input string, opt Val) error {
+	o, err := parseTemplateOption(opt, templateOption{left: "{{", right: "}}"})
 	if err != nil {
 		return err
 	}
-	t.goT = tp
+
+	funcs := goTemplateFuncs()
+	for k, v := range t.funcs {
+		funcs[k] = v
+	}
+	if o.escape {
+		tp, err := htemplate.New(name).Delims(o.left, o.right).Funcs(htemplate.FuncMap(funcs)).Parse(input)
+		if err != nil {
+			return err
+		}
+		t.exec = tp.Execute
+	} else {
+		tp, err := template.New(name).Delims(o.left, o.right).Funcs(funcs).Parse(input)
+		if err != nil {
+			return err
+		}
+		t.exec = tp.Execute
+	}
 	return nil
 }
 
@@ -111,38 +202,44 @@ func toctx(ctx Val) (interface{}, error) {
 }
 
 func (t *goTemplate) Execute(ctx Val) (string, error) {
-	x := new(bytes.Buffer)
-	if cctx, err := toctx(ctx); err != nil {
-		return "", err
-	} else if err := t.goT.Execute(x, cctx); err != nil {
-		return "", err
-	}
-	return x.String(), nil
+	return executeToString(t, ctx)
 }
 
-// for now markdown is static at all, ie no runtime rendering what's so ever
-type mdTemplate struct {
-	md string
+func (t *goTemplate) ExecuteStream(ctx Val, w io.Writer) error {
+	cctx, err := toctx(ctx)
+	if err != nil {
+		return err
+	}
+	return t.exec(w, cctx)
 }
 
-func (t *mdTemplate) Compile(_, input string, _ Val) error {
-	r := html.NewRenderer(
-		html.RendererOptions{Flags: html.CommonFlags})
+type pongoTemplate struct {
+	tpl *pongo2.Template
 
-	txt := markdown.ToHTML([]byte(input), nil, r)
-	t.md = string(txt)
-	return nil
+	// functions of the module, see template::add_func. They are exposed in the
+	// context, ie {{ name(x) }}, since pongo filter is process wide
+	funcs map[string]interface{}
 }
 
-func (t *mdTemplate) Execute(ctx Val) (string, error) {
-	return t.md, nil
-}
+func (t *pongoTemplate) Compile(_, input string, opt Val) error {
+	if hasTemplateOption(opt, "left") || hasTemplateOption(opt, "right") {
+		return fmt.Errorf("pongo template does not support custom delimiters")
+	}
 
-type pongoTemplate struct {
-	tpl *pongo2.Template
-}
+	// pongo only supports autoescape globally, so the per template escape
+	// option is implemented by wrapping the whole template with autoescape tag
+	if hasTemplateOption(opt, "escape") {
+		o, err := parseTemplateOption(opt, templateOption{})
+		if err != nil {
+			return err
+		}
+		mode := "off"
+		if o.escape {
+			mode = "on"
+		}
+		input = fmt.Sprintf("{%% autoescape %s %%}%s{%% endautoescape %%}", mode, input)
+	}
 
-func (t *pongoTemplate) Compile(_, input string, _ Val) error {
 	r, err := pongo2.FromString(input)
 	if err != nil {
 		return err
@@ -197,14 +294,126 @@ func (t *pongoTemplate) tocontext(v Val) (pongo2.Context, error) {
 	}
 }
 
+// the context value takes precedence over the function of the same name
+func (t *pongoTemplate) context(v Val) (pongo2.Context, error) {
+	p, err := t.tocontext(v)
+	if err != nil {
+		return nil, err
+	}
+	for k, fn := range t.funcs {
+		if _, ok := p[k]; !ok {
+			p[k] = fn
+		}
+	}
+	return p, nil
+}
+
 func (t *pongoTemplate) Execute(ctx Val) (string, error) {
-	cctx, err := t.tocontext(ctx)
+	cctx, err := t.context(ctx)
 	if err != nil {
 		return "", err
 	}
 	return t.tpl.Execute(cctx)
 }
 
+func (t *pongoTemplate) ExecuteStream(ctx Val, w io.Writer) error {
+	cctx, err := t.context(ctx)
+	if err != nil {
+		return err
+	}
+	return t.tpl.ExecuteWriterUnbuffered(cctx, w)
+}
+
+// raw text passthrough, the only supported syntax is placeholder, ie ${name},
+// which is substituted with the field of the context. The name can be dotted
+// path, ie ${a.b.c}, and missing field is substituted as empty string
+type rawTemplate struct {
+	seg    []rawSegment
+	escape bool
+}
+
+type rawSegment struct {
+	text string
+	name []string
+}
+
+func (t *rawTemplate) Compile(_, input string, opt Val) error {
+	o, err := parseTemplateOption(opt, templateOption{left: "${", right: "}"})
+	if err != nil {
+		return err
+	}
+	t.escape = o.escape
+
+	for len(input) != 0 {
+		start := strings.Index(input, o.left)
+		if start == -1 {
+			break
+		}
+		end := strings.Index(input[start+len(o.left):], o.right)
+		if end == -1 {
+			return fmt.Errorf("raw template placeholder is not closed")
+		}
+		name := strings.TrimSpace(input[start+len(o.left) : start+len(o.left)+end])
+		if name == "" {
+			return fmt.Errorf("raw template placeholder cannot be empty")
+		}
+
+		t.seg = append(t.seg,
+			rawSegment{text: input[:start]},
+			rawSegment{name: strings.Split(name, ".")},
+		)
+		input = input[start+len(o.left)+end+len(o.right):]
+	}
+	if len(input) != 0 {
+		t.seg = append(t.seg, rawSegment{text: input})
+	}
+	return nil
+}
+
+func (t *rawTemplate) Execute(ctx Val) (string, error) {
+	return executeToString(t, ctx)
+}
+
+func (t *rawTemplate) ExecuteStream(ctx Val, w io.Writer) error {
+	for _, s := range t.seg {
+		if s.name == nil {
+			if _, err := io.WriteString(w, s.text); err != nil {
+				return err
+			}
+			continue
+		}
+
+		v := ctx
+		for _, n := range s.name {
+			if !v.IsMap() {
+				v = NewValNull()
+				break
+			}
+			x, ok := v.Map().Get(n)
+			if !ok {
+				v = NewValNull()
+				break
+			}
+			v = x
+		}
+		if v.IsNull() {
+			continue
+		}
+
+		str, err := v.ToString()
+		if err != nil {
+			return err
+		}
+		if t.escape {
+			str = html.EscapeString(str)
+		}
+		if _, err := io.WriteString(w, str); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type gotempfac struct{}
 
 func (f *gotempfac) Create() Template {
@@ -223,6 +432,96 @@ func (f *pongotempfac) Create() Template {
 	return &pongoTemplate{}
 }
 
+type mustachetempfac struct{}
+
+func (f *mustachetempfac) Create() Template {
+	return &mustacheTemplate{}
+}
+
+type rawtempfac struct{}
+
+func (f *rawtempfac) Create() Template {
+	return &rawTemplate{}
+}
+
+// custom template functions, for go template it is the function which can be
+// invoked inside of the action, ie {{myfn .}}, and for pongo it is the filter,
+// ie {{ value|myfn }}. Notes, the function must be registered before the
+// template using it is compiled
+var (
+	templateFuncLock  sync.RWMutex
+	goTemplateFuncMap = make(template.FuncMap)
+	pongoFilterMap    = make(map[string]bool)
+)
+
+func goTemplateFuncs() template.FuncMap {
+	templateFuncLock.RLock()
+	defer templateFuncLock.RUnlock()
+
+	o := make(template.FuncMap, len(goTemplateFuncMap))
+	for k, v := range goTemplateFuncMap {
+		o[k] = v
+	}
+	return o
+}
+
+// Public interface to allow user to register custom function into template
+// engine. For go template, the fn must be a valid go template function, and
+// for pongo template, the fn must be pongo2.FilterFunction. Other engines do
+// not support custom function
+func AddTemplateFunc(engine, name string, fn interface{}) (err error) {
+	templateFuncLock.Lock()
+	defer templateFuncLock.Unlock()
+
+	switch engine {
+	case "go":
+		// validate the function, go template panics with invalid function
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("invalid go template function %s: %v", name, r)
+			}
+		}()
+		template.New("").Funcs(template.FuncMap{name: fn})
+		goTemplateFuncMap[name] = fn
+
+	case "pongo":
+		var filter pongo2.FilterFunction
+		switch f := fn.(type) {
+		case pongo2.FilterFunction:
+			filter = f
+		case func(*pongo2.Value, *pongo2.Value) (*pongo2.Value, *pongo2.Error):
+			filter = f
+		default:
+			return fmt.Errorf("pongo template function %s must be pongo2.FilterFunction", name)
+		}
+
+		if pongoFilterMap[name] {
+			err = pongo2.ReplaceFilter(name, filter)
+		} else {
+			err = pongo2.RegisterFilter(name, filter)
+		}
+		if err != nil {
+			return err
+		}
+		pongoFilterMap[name] = true
+
+	default:
+		return fmt.Errorf("template engine %s does not support custom function", engine)
+	}
+	return nil
+}
+
+var templateFuncName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// name of the template function registered by the script, it must be a valid
+// identifier of the engine
+func checkTemplateFuncName(engine, name string) error {
+	if !templateFuncName.MatchString(name) {
+		return fmt.Errorf("invalid %s template function name %s", engine, name)
+	}
+	return nil
+}
+
 // Public interface to allow user to register multiple different template engine
 // into PL language environment for customization
 var templatefacmap = make(map[string]TemplateFactory)
@@ -235,6 +534,8 @@ func init() {
 	AddTemplateFactory("go", &gotempfac{})
 	AddTemplateFactory("md", &mdtempfac{})
 	AddTemplateFactory("pongo", &pongotempfac{})
+	AddTemplateFactory("mustache", &mustachetempfac{})
+	AddTemplateFactory("raw", &rawtempfac{})
 }
 
 func newTemplate(t string) Template {
@@ -0,0 +1,311 @@
+package pl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gomarkdown/markdown"
+	mdhtml "github.com/gomarkdown/markdown/html"
+	mdparser "github.com/gomarkdown/markdown/parser"
+)
+
+// markdown template. Without any option the markdown source is converted into
+// html as is, with the common extensions. Optionally the source is first
+// expanded as a mustache template, with partials enabled, against the front
+// matter variables and the runtime context, and the html is rendered through
+// a layout template of another engine, which sees all the variables plus the
+// html as content.
+//
+// The front matter is an optional block at the very beginning of the source
+// surrounded by --- lines, each line is a key: value pair and the value is
+// parsed as JSON if it is valid JSON, otherwise it is kept as string.
+//
+// Options, besides the left, right delimiters of mustache, are following:
+//
+//   tables, fenced_code, autolink, strikethrough, footnotes, hard_line_break,
+//   heading_ids, boolean toggles of markdown extensions, by default the
+//   common extensions are enabled
+//
+//   mustache, boolean, expands the source as mustache template, default to
+//   false
+//
+//   front_matter, boolean, parses the front matter, default to false
+//
+//   escape, boolean, html escapes the interpolated value, ie {{x}}, default
+//   to true. {{{x}}} is never escaped
+//
+//   sanitize, boolean, drops raw html and unsafe links, ie javascript:, from
+//   the output, default to false. The interpolated value is markdown as well,
+//   so escaping alone does not stop a malicious link
+//
+//   layout, string, source of the layout template
+//
+//   layout_engine, string, engine of the layout template, default to go
+
+var mdExtensionOption = []struct {
+	name string
+	ext  mdparser.Extensions
+}{
+	{"tables", mdparser.Tables},
+	{"fenced_code", mdparser.FencedCode},
+	{"autolink", mdparser.Autolink},
+	{"strikethrough", mdparser.Strikethrough},
+	{"footnotes", mdparser.Footnotes},
+	{"hard_line_break", mdparser.HardLineBreak},
+	{"heading_ids", mdparser.HeadingIDs},
+}
+
+type mdTemplate struct {
+	matter Val
+	body   *mustacheTemplate
+	ext    mdparser.Extensions
+	flags  mdhtml.Flags
+	layout Template
+
+	// html of the body when it does not have any mustache tag
+	static   string
+	isStatic bool
+}
+
+// characters escapable by backslash in markdown
+const mdEscapeChars = "\\`*_{}[]()#+-.!:|&<>~^"
+
+// escape the value so it is rendered as plain text by markdown, ie the raw
+// html and the link syntax inside of it are not recognized
+func mdEscape(x string) string {
+	var b strings.Builder
+	for _, r := range x {
+		if r < 0x80 && strings.ContainsRune(mdEscapeChars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// split the front matter from the markdown source
+func parseMdFrontMatter(input string) (Val, string, error) {
+	matter := NewValMap()
+	if !strings.HasPrefix(input, "---\n") {
+		return matter, input, nil
+	}
+
+	rest := input[4:]
+	for {
+		eol := strings.IndexByte(rest, '\n')
+		line := rest
+		if eol != -1 {
+			line = rest[:eol]
+			rest = rest[eol+1:]
+		} else {
+			rest = ""
+		}
+		line = strings.TrimRight(line, " \t\r")
+
+		if line == "---" {
+			return matter, rest, nil
+		}
+		if eol == -1 {
+			return NewValNull(), "", fmt.Errorf("markdown front matter is not closed")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 {
+			return NewValNull(), "", fmt.Errorf("markdown front matter line %s is invalid", line)
+		}
+		key := strings.TrimSpace(line[:colon])
+		value := strings.TrimSpace(line[colon+1:])
+
+		if v, err := NewValFromJSON(value); err == nil {
+			matter.AddMap(key, v)
+		} else {
+			matter.AddMap(key, NewValStr(value))
+		}
+	}
+}
+
+func (t *mdTemplate) Compile(name, input string, opt Val) error {
+	t.ext = mdparser.CommonExtensions
+	t.flags = mdhtml.CommonFlags
+
+	o, err := parseTemplateOption(opt, templateOption{escape: true})
+	if err != nil {
+		return err
+	}
+
+	var layout, layoutEngine string
+	var useMustache, useFrontMatter bool
+	if opt.IsMap() {
+		m := opt.Map()
+		for _, x := range mdExtensionOption {
+			if v, ok := m.Get(x.name); ok {
+				if !v.IsBool() {
+					return fmt.Errorf("markdown option %s must be boolean", x.name)
+				}
+				if v.Bool() {
+					t.ext |= x.ext
+				} else {
+					t.ext &^= x.ext
+				}
+			}
+		}
+
+		sanitize := false
+		for _, x := range []struct {
+			key string
+			out *bool
+		}{
+			{"mustache", &useMustache},
+			{"front_matter", &useFrontMatter},
+			{"sanitize", &sanitize},
+		} {
+			if v, ok := m.Get(x.key); ok {
+				if !v.IsBool() {
+					return fmt.Errorf("markdown option %s must be boolean", x.key)
+				}
+				*x.out = v.Bool()
+			}
+		}
+		if sanitize {
+			t.flags |= mdhtml.SkipHTML | mdhtml.Safelink
+		}
+
+		for _, x := range []struct {
+			key string
+			out *string
+		}{
+			{"layout", &layout},
+			{"layout_engine", &layoutEngine},
+		} {
+			if v, ok := m.Get(x.key); ok {
+				if !v.IsString() {
+					return fmt.Errorf("markdown option %s must be string", x.key)
+				}
+				*x.out = v.String()
+			}
+		}
+	}
+
+	t.matter = NewValMap()
+	body := input
+	if useFrontMatter {
+		matter, rest, err := parseMdFrontMatter(input)
+		if err != nil {
+			return err
+		}
+		t.matter = matter
+		body = rest
+	}
+
+	if !useMustache {
+		t.isStatic = true
+		t.static = t.toHTML([]byte(body))
+		return t.compileLayout(name, layout, layoutEngine)
+	}
+
+	// the markdown body, the interpolated value is escaped as markdown instead
+	// of html, since the markdown renderer escapes the html entity again
+	bopt := NewValMap()
+	bopt.AddMap("escape", NewValBool(o.escape))
+	bopt.AddMap("partials", NewValBool(true))
+	for _, k := range []string{"left", "right"} {
+		if hasTemplateOption(opt, k) {
+			v, _ := opt.Map().Get(k)
+			bopt.AddMap(k, v)
+		}
+	}
+	t.body = &mustacheTemplate{
+		escaper: mdEscape,
+	}
+	if err := t.body.Compile(name, body, bopt); err != nil {
+		return err
+	}
+
+	t.isStatic = true
+	for _, n := range t.body.root {
+		if n.kind != mustacheText {
+			t.isStatic = false
+			break
+		}
+	}
+	if t.isStatic {
+		t.static = t.toHTML([]byte(body))
+	}
+	return t.compileLayout(name, layout, layoutEngine)
+}
+
+func (t *mdTemplate) compileLayout(name, layout, layoutEngine string) error {
+	if layout != "" || layoutEngine != "" {
+		if layoutEngine == "" {
+			layoutEngine = "go"
+		}
+		t.layout = newTemplate(layoutEngine)
+		if t.layout == nil {
+			return fmt.Errorf("markdown layout engine %s is unknown", layoutEngine)
+		}
+		if err := t.layout.Compile(name, layout, NewValNull()); err != nil {
+			return fmt.Errorf("markdown layout: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+func (t *mdTemplate) toHTML(src []byte) string {
+	p := mdparser.NewWithExtensions(t.ext)
+	r := mdhtml.NewRenderer(mdhtml.RendererOptions{Flags: t.flags})
+	return string(markdown.ToHTML(src, p, r))
+}
+
+func (t *mdTemplate) content(ctx Val) (string, error) {
+	if t.isStatic {
+		return t.static, nil
+	}
+	src := new(bytes.Buffer)
+	if err := t.body.render(src, t.body.root, []Val{t.matter, ctx}, 0); err != nil {
+		return "", err
+	}
+	return t.toHTML(src.Bytes()), nil
+}
+
+// context of the layout, front matter variables overridden by the runtime
+// context, plus the rendered html as content
+func (t *mdTemplate) layoutContext(ctx Val, content string) Val {
+	x := NewValMap()
+	t.matter.Map().Foreach(
+		func(k string, v Val) bool {
+			x.AddMap(k, v)
+			return true
+		},
+	)
+	if ctx.IsMap() {
+		ctx.Map().Foreach(
+			func(k string, v Val) bool {
+				x.AddMap(k, v)
+				return true
+			},
+		)
+	}
+	x.AddMap("content", NewValStr(content))
+	return x
+}
+
+func (t *mdTemplate) Execute(ctx Val) (string, error) {
+	return executeToString(t, ctx)
+}
+
+func (t *mdTemplate) ExecuteStream(ctx Val, w io.Writer) error {
+	content, err := t.content(ctx)
+	if err != nil {
+		return err
+	}
+	if t.layout != nil {
+		return t.layout.ExecuteStream(t.layoutContext(ctx, content), w)
+	}
+	_, err = io.WriteString(w, content)
+	return err
+}
@@ -0,0 +1,367 @@
+package pl
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	"github.com/dianpeng/moons/util"
+)
+
+// A mustache template engine working directly on Val, supports following
+// tags:
+//
+//   1) {{name}}, variable, html escaped unless escape option is off
+//   2) {{{name}}} or {{&name}}, unescaped variable
+//   3) {{#name}}...{{/name}}, section, rendered once for each element of a
+//      list or once with the value pushed as context when it is truthy
+//   4) {{^name}}...{{/name}}, inverted section, rendered when value is falsy
+//   5) {{! comment }}
+//   6) {{=<% %>=}}, change the delimiters
+//   7) {{> name}}, partial, only when partials option is on
+//
+// Name can be dotted, ie a.b.c, and {{.}} refers to the current context.
+// Partial is looked up from the context like a variable, its string value is
+// compiled as mustache template with the same options and rendered with the
+// current context. Standalone section, comment and delimiter tags do not leave
+// empty lines in the output.
+
+const (
+	mustacheText = iota
+	mustacheVar
+	mustacheRawVar
+	mustacheSection
+	mustacheInverted
+	mustachePartial
+
+	// max nesting level of partials, guards recursive partials
+	mustachePartialDepth = 16
+
+	// number of the compiled partials cached by each template
+	mustachePartialCacheSize = 16
+)
+
+type mustacheNode struct {
+	kind     int
+	text     string
+	name     []string
+	children []*mustacheNode
+}
+
+type mustacheTemplate struct {
+	root     []*mustacheNode
+	escape   bool
+	partials bool
+	opt      Val
+
+	// compiled partials keyed by the source, only when partials option is on
+	partialCache *util.LRU
+
+	// escaping of the variable when escape option is on, html escaping if nil
+	escaper func(string) string
+}
+
+func mustacheName(x string) []string {
+	if x == "." {
+		return nil
+	}
+	return strings.Split(x, ".")
+}
+
+func isMustacheBlank(x string) bool {
+	return strings.Trim(x, " \t\r") == ""
+}
+
+func (t *mustacheTemplate) Compile(_, input string, opt Val) error {
+	o, err := parseTemplateOption(opt, templateOption{left: "{{", right: "}}", escape: true})
+	if err != nil {
+		return err
+	}
+	t.escape = o.escape
+	t.opt = opt
+
+	if hasTemplateOption(opt, "partials") {
+		x, _ := opt.Map().Get("partials")
+		if !x.IsBool() {
+			return fmt.Errorf("mustache option partials must be boolean")
+		}
+		t.partials = x.Bool()
+	}
+	if t.partials {
+		t.partialCache = util.NewLRU(mustachePartialCacheSize)
+	}
+
+	type frame struct {
+		node *mustacheNode
+		name string
+	}
+
+	ld, rd := o.left, o.right
+	root := &mustacheNode{}
+	stack := []frame{{node: root}}
+	top := func() *mustacheNode {
+		return stack[len(stack)-1].node
+	}
+	addText := func(x string) {
+		if x != "" {
+			top().children = append(top().children, &mustacheNode{kind: mustacheText, text: x})
+		}
+	}
+
+	pos := 0
+	for pos < len(input) {
+		off := strings.Index(input[pos:], ld)
+		if off == -1 {
+			break
+		}
+		start := pos + off
+		cstart := start + len(ld)
+
+		// find the end of the tag, triple mustache needs an extra }
+		closing := rd
+		if strings.HasPrefix(input[cstart:], "{") {
+			closing = "}" + rd
+		}
+		cend := strings.Index(input[cstart:], closing)
+		if cend == -1 {
+			return fmt.Errorf("mustache tag at %d is not closed", start)
+		}
+		content := input[cstart : cstart+cend]
+		end := cstart + cend + len(closing)
+
+		tag := strings.TrimSpace(content)
+		if tag == "" {
+			return fmt.Errorf("mustache tag at %d is empty", start)
+		}
+		sigil := tag[0]
+		body := strings.TrimSpace(tag[1:])
+
+		// standalone tag, ie the only none blank content of the line
+		text := input[pos:start]
+		switch sigil {
+		case '#', '^', '/', '!', '=', '>':
+			lineStart := strings.LastIndexByte(input[:start], '\n') + 1
+			lineEnd := strings.IndexByte(input[end:], '\n')
+			if lineEnd == -1 {
+				lineEnd = len(input)
+			} else {
+				lineEnd += end
+			}
+			if lineStart >= pos &&
+				isMustacheBlank(input[lineStart:start]) &&
+				isMustacheBlank(input[end:lineEnd]) {
+				text = input[pos:lineStart]
+				end = lineEnd
+				if end < len(input) {
+					end++
+				}
+			}
+		}
+		addText(text)
+		pos = end
+
+		switch sigil {
+		case '!':
+			break
+
+		case '=':
+			if !strings.HasSuffix(body, "=") {
+				return fmt.Errorf("mustache set delimiter tag must end with =")
+			}
+			d := strings.Fields(strings.TrimSuffix(body, "="))
+			if len(d) != 2 {
+				return fmt.Errorf("mustache set delimiter tag must have 2 delimiters")
+			}
+			ld, rd = d[0], d[1]
+
+		case '#', '^':
+			kind := mustacheSection
+			if sigil == '^' {
+				kind = mustacheInverted
+			}
+			n := &mustacheNode{kind: kind, name: mustacheName(body)}
+			top().children = append(top().children, n)
+			stack = append(stack, frame{node: n, name: body})
+
+		case '/':
+			if len(stack) == 1 || stack[len(stack)-1].name != body {
+				return fmt.Errorf("mustache close tag %s is unmatched", body)
+			}
+			stack = stack[:len(stack)-1]
+
+		case '>':
+			if !t.partials {
+				return fmt.Errorf("mustache partial is not supported")
+			}
+			top().children = append(top().children, &mustacheNode{
+				kind: mustachePartial,
+				name: mustacheName(body),
+			})
+
+		case '{':
+			top().children = append(top().children, &mustacheNode{
+				kind: mustacheRawVar,
+				name: mustacheName(strings.TrimSpace(strings.TrimSuffix(body, "}"))),
+			})
+
+		case '&':
+			top().children = append(top().children, &mustacheNode{
+				kind: mustacheRawVar,
+				name: mustacheName(body),
+			})
+
+		default:
+			top().children = append(top().children, &mustacheNode{
+				kind: mustacheVar,
+				name: mustacheName(tag),
+			})
+		}
+	}
+	addText(input[pos:])
+
+	if len(stack) != 1 {
+		return fmt.Errorf("mustache section %s is not closed", stack[len(stack)-1].name)
+	}
+	t.root = root.children
+	return nil
+}
+
+// lookup the name from the context stack, the first component of the name is
+// searched from the innermost context to the outermost one and the rest of
+// the components are resolved against the found value
+func mustacheLookup(stack []Val, name []string) Val {
+	if len(name) == 0 {
+		return stack[len(stack)-1]
+	}
+
+	v := NewValNull()
+	found := false
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].IsMap() {
+			if x, ok := stack[i].Map().Get(name[0]); ok {
+				v = x
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return v
+	}
+
+	for _, n := range name[1:] {
+		if !v.IsMap() {
+			return NewValNull()
+		}
+		x, ok := v.Map().Get(n)
+		if !ok {
+			return NewValNull()
+		}
+		v = x
+	}
+	return v
+}
+
+func (t *mustacheTemplate) renderPartial(w io.Writer, n *mustacheNode, stack []Val, depth int) error {
+	if depth >= mustachePartialDepth {
+		return fmt.Errorf("mustache partial %s nests too deep", strings.Join(n.name, "."))
+	}
+	v := mustacheLookup(stack, n.name)
+	if v.IsNull() {
+		return nil
+	}
+	src, err := v.ToString()
+	if err != nil {
+		return err
+	}
+	pt, err := t.partial(src)
+	if err != nil {
+		return fmt.Errorf("mustache partial %s: %s", strings.Join(n.name, "."), err.Error())
+	}
+	return pt.render(w, pt.root, stack, depth+1)
+}
+
+// compile the partial with the same options, a partial rendered repeatedly is
+// only compiled once
+func (t *mustacheTemplate) partial(src string) (*mustacheTemplate, error) {
+	if x, ok := t.partialCache.Get(src); ok {
+		return x.(*mustacheTemplate), nil
+	}
+	p := &mustacheTemplate{
+		escaper: t.escaper,
+	}
+	if err := p.Compile("partial", src, t.opt); err != nil {
+		return nil, err
+	}
+	t.partialCache.Put(src, p)
+	return p, nil
+}
+
+func (t *mustacheTemplate) render(w io.Writer, nodes []*mustacheNode, stack []Val, depth int) error {
+	for _, n := range nodes {
+		switch n.kind {
+		case mustacheText:
+			if _, err := io.WriteString(w, n.text); err != nil {
+				return err
+			}
+
+		case mustacheVar, mustacheRawVar:
+			v := mustacheLookup(stack, n.name)
+			if v.IsNull() {
+				break
+			}
+			str, err := v.ToString()
+			if err != nil {
+				return err
+			}
+			if n.kind == mustacheVar && t.escape {
+				if t.escaper != nil {
+					str = t.escaper(str)
+				} else {
+					str = html.EscapeString(str)
+				}
+			}
+			if _, err := io.WriteString(w, str); err != nil {
+				return err
+			}
+
+		case mustacheSection:
+			v := mustacheLookup(stack, n.name)
+			if !v.ToBoolean() {
+				break
+			}
+			if v.IsList() {
+				for _, x := range v.List().Data {
+					if err := t.render(w, n.children, append(stack, x), depth); err != nil {
+						return err
+					}
+				}
+			} else if err := t.render(w, n.children, append(stack, v), depth); err != nil {
+				return err
+			}
+
+		case mustachePartial:
+			if err := t.renderPartial(w, n, stack, depth); err != nil {
+				return err
+			}
+
+		default:
+			v := mustacheLookup(stack, n.name)
+			if !v.ToBoolean() {
+				if err := t.render(w, n.children, stack, depth); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (t *mustacheTemplate) Execute(ctx Val) (string, error) {
+	return executeToString(t, ctx)
+}
+
+func (t *mustacheTemplate) ExecuteStream(ctx Val, w io.Writer) error {
+	return t.render(w, t.root, []Val{ctx}, 0)
+}
@@ -0,0 +1,205 @@
+package pl
+
+import (
+	"fmt"
+	"io"
+)
+
+// deferred template rendering. When a template expression is directly
+// assigned to a body field, ie resp.body = template "go", ctx, ```...```, the
+// compiler emits bcTemplateStream instead of bcTemplate and the template is
+// rendered directly into the body stream when it is consumed, so large output
+// does not need to be buffered in a string first.
+//
+// Only receiver implementing TemplateStreamSink accepts the deferred value,
+// for any other receiver the template is rendered into string at assignment,
+// which is exactly the same as normal template expression.
+
+const (
+	TemplateStreamTypeId = ".template_stream"
+)
+
+// implemented by Usr type which can consume TemplateStream in DotSet
+type TemplateStreamSink interface {
+	AcceptTemplateStream(field string) bool
+}
+
+type TemplateStream struct {
+	tpl Template
+	ctx Val
+}
+
+func IsValTemplateStream(v Val) bool {
+	return v.Id() == TemplateStreamTypeId
+}
+
+// the context is snapshotted since the stream is rendered later, possibly by
+// the reader's goroutine while the script keeps mutating the context
+func NewValTemplateStream(tpl Template, ctx Val) Val {
+	return NewValUsr(&TemplateStream{
+		tpl: tpl,
+		ctx: snapshotTemplateCtx(ctx),
+	})
+}
+
+// copies the lists, maps and pairs of the context recursively, the other
+// values are either immutable or passed to the template as is. The container
+// reached again, ie a cyclic or aliased one, reuses its copy
+func snapshotTemplateCtx(v Val) Val {
+	return snapshotTemplateVal(v, make(map[interface{}]Val))
+}
+
+func snapshotTemplateVal(v Val, visited map[interface{}]Val) Val {
+	switch v.Type {
+	case ValList:
+		l := v.List()
+		if x, ok := visited[l]; ok {
+			return x
+		}
+		o := make([]Val, len(l.Data))
+		r := NewValListRaw(o)
+		visited[l] = r
+		for i, x := range l.Data {
+			o[i] = snapshotTemplateVal(x, visited)
+		}
+		return r
+
+	case ValMap:
+		m := v.Map()
+		if x, ok := visited[m]; ok {
+			return x
+		}
+		o := NewMap()
+		o.SetOrdered(m.IsOrdered())
+		r := NewValMapFromMap(o)
+		visited[m] = r
+		for _, k := range m.Keys() {
+			x, _ := m.Get(k)
+			o.Set(k, snapshotTemplateVal(x, visited))
+		}
+		return r
+
+	case ValPair:
+		p := v.Pair()
+		if x, ok := visited[p]; ok {
+			return x
+		}
+		r := NewValPair(NewValNull(), NewValNull())
+		visited[p] = r
+		o := r.Pair()
+		o.First = snapshotTemplateVal(p.First, visited)
+		o.Second = snapshotTemplateVal(p.Second, visited)
+		return r
+
+	default:
+		return v
+	}
+}
+
+// render template into the writer
+func (t *TemplateStream) Render(w io.Writer) error {
+	return t.tpl.ExecuteStream(t.ctx, w)
+}
+
+func (t *TemplateStream) String() (string, error) {
+	return t.tpl.Execute(t.ctx)
+}
+
+// returns a reader of the rendered output, the rendering starts at the first
+// read in a separate goroutine and the output is piped back to the reader
+func (t *TemplateStream) NewReader() io.ReadCloser {
+	return &templateStreamReader{
+		t: t,
+	}
+}
+
+type templateStreamReader struct {
+	t  *TemplateStream
+	pr *io.PipeReader
+}
+
+func (r *templateStreamReader) Read(b []byte) (int, error) {
+	if r.pr == nil {
+		pr, pw := io.Pipe()
+		r.pr = pr
+		go func() {
+			pw.CloseWithError(r.t.Render(pw))
+		}()
+	}
+	return r.pr.Read(b)
+}
+
+func (r *templateStreamReader) Close() error {
+	if r.pr != nil {
+		// unblock the rendering goroutine if the reader is abandoned
+		return r.pr.Close()
+	}
+	return nil
+}
+
+func (t *TemplateStream) Index(_ Val) (Val, error) {
+	return NewValNull(), fmt.Errorf("type: %s does not support index", t.Id())
+}
+
+func (t *TemplateStream) IndexSet(_ Val, _ Val) error {
+	return fmt.Errorf("type: %s does not support index set", t.Id())
+}
+
+func (t *TemplateStream) Dot(_ string) (Val, error) {
+	return NewValNull(), fmt.Errorf("type: %s does not support dot", t.Id())
+}
+
+func (t *TemplateStream) DotSet(_ string, _ Val) error {
+	return fmt.Errorf("type: %s does not support dot set", t.Id())
+}
+
+func (t *TemplateStream) Method(name string, _ []Val) (Val, error) {
+	return NewValNull(), fmt.Errorf("%s method: %s is unknown", t.Id(), name)
+}
+
+func (t *TemplateStream) ToString() (string, error) {
+	return t.String()
+}
+
+func (t *TemplateStream) ToJSON() (Val, error) {
+	str, err := t.String()
+	if err != nil {
+		return NewValNull(), err
+	}
+	return NewValStr(str), nil
+}
+
+func (t *TemplateStream) Id() string {
+	return TemplateStreamTypeId
+}
+
+func (t *TemplateStream) Info() string {
+	return t.Id()
+}
+
+func (t *TemplateStream) IsThreadSafe() bool {
+	return false
+}
+
+func (t *TemplateStream) NewIterator() (Iter, error) {
+	return nil, fmt.Errorf("type: %s does not support iterator", t.Id())
+}
+
+// resolve the deferred template value for assignment, if the receiver cannot
+// consume the stream, the template is rendered into string
+func resolveTemplateStream(recv Val, field string, value Val) (Val, error) {
+	ts, ok := value.Usr().(*TemplateStream)
+	must(ok, "must be template stream")
+
+	if recv.Type == ValUsr {
+		if sink, ok := recv.Usr().(TemplateStreamSink); ok && sink.AcceptTemplateStream(field) {
+			return value, nil
+		}
+	}
+
+	str, err := ts.String()
+	if err != nil {
+		return NewValNull(), err
+	}
+	return NewValStr(str), nil
+}
@@ -0,0 +1,502 @@
+package pl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/flosch/pongo2"
+	"github.com/stretchr/testify/assert"
+)
+
+func testTemplate(engine string, opt Val, src string, ctx Val) (string, error) {
+	t := newTemplate(engine)
+	if t == nil {
+		panic("unknown template engine " + engine)
+	}
+	if err := t.Compile("test", src, opt); err != nil {
+		return "", err
+	}
+	return t.Execute(ctx)
+}
+
+func testTemplateOpt(kv ...interface{}) Val {
+	o := NewValMap()
+	for i := 0; i < len(kv); i += 2 {
+		switch v := kv[i+1].(type) {
+		case string:
+			o.AddMap(kv[i].(string), NewValStr(v))
+		case bool:
+			o.AddMap(kv[i].(string), NewValBool(v))
+		}
+	}
+	return o
+}
+
+func TestTemplateMustache(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := NewValMap()
+	ctx.AddMap("name", NewValStr("<b>"))
+	ctx.AddMap("list", NewValListRaw([]Val{NewValInt(1), NewValInt(2), NewValInt(3)}))
+	ctx.AddMap("empty", NewValList())
+	nested := NewValMap()
+	nested.AddMap("x", NewValStr("inner"))
+	ctx.AddMap("obj", nested)
+
+	run := func(src string, opt Val, expect string) {
+		out, err := testTemplate("mustache", opt, src, ctx)
+		assert.Nil(err, src)
+		assert.Equal(expect, out, src)
+	}
+
+	run("hello {{name}}!", NewValNull(), "hello &lt;b&gt;!")
+	run("hello {{{name}}} {{& name}}", NewValNull(), "hello <b> <b>")
+	run("{{missing}}|{{obj.x}}|{{obj.y}}", NewValNull(), "|inner|")
+	run("{{#list}}[{{.}}]{{/list}}", NewValNull(), "[1][2][3]")
+	run("{{#obj}}{{x}}-{{name}}{{/obj}}", NewValNull(), "inner-&lt;b&gt;")
+	run("{{^empty}}none{{/empty}}{{#empty}}x{{/empty}}", NewValNull(), "none")
+	run("a{{! comment }}b", NewValNull(), "ab")
+	run("{{=<% %>=}}<%name%> {{name}}", NewValNull(), "&lt;b&gt; {{name}}")
+	run("<%name%>", testTemplateOpt("left", "<%", "right", "%>"), "&lt;b&gt;")
+	run("{{name}}", testTemplateOpt("escape", false), "<b>")
+	run("[{{> name}}]", testTemplateOpt("partials", true, "escape", false), "[<b>]")
+
+	// standalone lines
+	run("begin\n  {{#list}}\n{{.}}\n  {{/list}}\nend\n", NewValNull(), "begin\n1\n2\n3\nend\n")
+
+	for _, src := range []string{
+		"{{#a}}",
+		"{{#a}}{{/b}}",
+		"{{/a}}",
+		"{{name",
+		"{{}}",
+		"{{> partial}}",
+		"{{=<%=}}",
+	} {
+		_, err := testTemplate("mustache", NewValNull(), src, ctx)
+		assert.NotNil(err, src)
+	}
+}
+
+func TestTemplateRaw(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := NewValMap()
+	ctx.AddMap("a", NewValInt(1))
+	inner := NewValMap()
+	inner.AddMap("c", NewValStr("<x>"))
+	ctx.AddMap("b", inner)
+
+	out, err := testTemplate("raw", NewValNull(), "a=${a}, c=${ b.c }, d=${d} {{a}}", ctx)
+	assert.Nil(err)
+	assert.Equal("a=1, c=<x>, d= {{a}}", out)
+
+	out, err = testTemplate("raw", testTemplateOpt("left", "%", "right", "%", "escape", "html"),
+		"%b.c%", ctx)
+	assert.Nil(err)
+	assert.Equal("&lt;x&gt;", out)
+
+	_, err = testTemplate("raw", NewValNull(), "${a", ctx)
+	assert.NotNil(err)
+	_, err = testTemplate("raw", NewValNull(), "${}", ctx)
+	assert.NotNil(err)
+	_, err = testTemplate("raw", testTemplateOpt("escape", "xml"), "", ctx)
+	assert.NotNil(err)
+}
+
+func TestTemplateOption(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := NewValMap()
+	ctx.AddMap("a", NewValStr("<a>"))
+
+	out, err := testTemplate("go", testTemplateOpt("left", "[[", "right", "]]"), "[[.a]]{{.a}}", ctx)
+	assert.Nil(err)
+	assert.Equal("<a>{{.a}}", out)
+
+	out, err = testTemplate("go", testTemplateOpt("escape", "html"), "{{.a}}", ctx)
+	assert.Nil(err)
+	assert.Equal("&lt;a&gt;", out)
+
+	out, err = testTemplate("pongo", testTemplateOpt("escape", true), "{{a}}", ctx)
+	assert.Nil(err)
+	assert.Equal("&lt;a&gt;", out)
+
+	out, err = testTemplate("pongo", testTemplateOpt("escape", false), "{{a}}", ctx)
+	assert.Nil(err)
+	assert.Equal("<a>", out)
+
+	_, err = testTemplate("pongo", testTemplateOpt("left", "[["), "{{a}}", ctx)
+	assert.NotNil(err)
+}
+
+func TestTemplateCache(t *testing.T) {
+	assert := assert.New(t)
+
+	mt := newModuleTemplate()
+	a, err := compileTemplate(mt, "go", "{{.a}}", NewValNull())
+	assert.Nil(err)
+	b, err := compileTemplate(mt, "go", "{{.a}}", NewValNull())
+	assert.Nil(err)
+	assert.True(a == b)
+
+	// each module has its own cache, and no cache without module
+	other, err := compileTemplate(newModuleTemplate(), "go", "{{.a}}", NewValNull())
+	assert.Nil(err)
+	assert.True(a != other)
+	none, err := compileTemplate(nil, "go", "{{.a}}", NewValNull())
+	assert.Nil(err)
+	assert.True(a != none)
+
+	// different engine, options or source are cached separately
+	c, err := compileTemplate(mt, "mustache", "{{.a}}", NewValNull())
+	assert.Nil(err)
+	assert.True(a != c)
+	d, err := compileTemplate(mt, "go", "{{.a}}", testTemplateOpt("escape", true))
+	assert.Nil(err)
+	assert.True(a != d)
+
+	for i := 0; i < templateCacheSize+10; i++ {
+		_, err := compileTemplate(mt, "raw", fmt.Sprintf("${a}%d", i), NewValNull())
+		assert.Nil(err)
+	}
+	assert.Equal(templateCacheSize, mt.cache.Len())
+}
+
+func TestTemplateFunc(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(AddTemplateFunc("go", "test_double", func(x int64) int64 { return x * 2 }))
+	assert.NotNil(AddTemplateFunc("go", "test_bad", 1))
+	assert.NotNil(AddTemplateFunc("mustache", "test_double", func() {}))
+
+	assert.Nil(AddTemplateFunc("pongo", "test_twice",
+		func(in *pongo2.Value, _ *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+			return pongo2.AsValue(in.String() + in.String()), nil
+		}))
+	assert.NotNil(AddTemplateFunc("pongo", "test_bad", func() {}))
+
+	// builtin filter cannot be replaced
+	assert.NotNil(AddTemplateFunc("pongo", "upper",
+		func(in *pongo2.Value, _ *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+			return in, nil
+		}))
+
+	ctx := NewValMap()
+	ctx.AddMap("a", NewValInt(21))
+	ctx.AddMap("s", NewValStr("ab"))
+
+	out, err := testTemplate("go", NewValNull(), "{{test_double .a}}", ctx)
+	assert.Nil(err)
+	assert.Equal("42", out)
+
+	out, err = testTemplate("pongo", NewValNull(), "{{ s|test_twice }}", ctx)
+	assert.Nil(err)
+	assert.Equal("abab", out)
+}
+
+// the script template function is only visible to its own module
+func TestTemplateAddFunc(t *testing.T) {
+	assert := assert.New(t)
+
+	render := func(m *Module) (Val, error) {
+		e := NewEvaluatorWithContext(NewNullEvalContext())
+		if err := e.EvalGlobal(m); err != nil {
+			return NewValNull(), err
+		}
+		var out Val
+		e.Context = NewCbEvalContext(nil, nil, func(_ *Evaluator, _ string, v Val) error {
+			out = v
+			return nil
+		})
+		_, err := e.Eval("test", m)
+		return out, err
+	}
+
+	a, err := CompileModule(`
+global {
+  f = template::add_func("go", "twice", fn(v): v * 2);
+}
+test {
+  output => template::render("go", "{{{twice .a}}", {"a": 21});
+}
+`, nil)
+	assert.Nil(err)
+	b, err := CompileModule(`
+test {
+  output => template::render("go", "{{{twice .a}}", {"a": 21});
+}
+`, nil)
+	assert.Nil(err)
+
+	out, err := render(a)
+	assert.Nil(err)
+	assert.Equal("42", out.String())
+
+	_, err = render(b)
+	assert.NotNil(err)
+
+	// not allowed outside of the config or global scope
+	c, err := CompileModule(`
+test {
+  output => template::add_func("go", "late", fn(v): v);
+}
+`, nil)
+	assert.Nil(err)
+	_, err = render(c)
+	assert.NotNil(err)
+}
+
+// the script template function runs on pooled evaluators when the template
+// is rendered concurrently, each call builds its own containers and only the
+// thread safe values can be captured
+func TestTemplateAddFuncConcurrent(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := CompileModule(`
+global {
+  ok = helpers();
+}
+fn helpers() {
+  let base = 3;
+  let sep = ",";
+  template::add_func("go", "sum", fn(v) {
+    let o = {"l": [v]};
+    for let i = 1; i <= base; i++ {
+      o.l:push_back(i);
+    }
+    o.total = q::sum(o.l);
+    return str::join([o.total:to_string(), o.l:length():to_string()], sep);
+  });
+  template::add_func("go", "boom", fn(v) {
+    assert::yes(false);
+  });
+  return true;
+}
+test {
+  output => template::render("go", "{{{sum .a}}", {"a": 4});
+}
+bad {
+  output => template::render("go", "{{{boom .a}}", {"a": 4});
+}
+`, nil)
+	if !assert.Nil(err) {
+		return
+	}
+	e := NewEvaluatorWithContext(NewNullEvalContext())
+	if !assert.Nil(e.EvalGlobal(m)) {
+		return
+	}
+
+	run := func(name string) (Val, error) {
+		var out Val
+		e := NewEvaluatorWithContext(NewCbEvalContext(nil, nil, func(_ *Evaluator, _ string, v Val) error {
+			out = v
+			return nil
+		}))
+		_, err := e.Eval(name, m)
+		return out, err
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				out, err := run("test")
+				assert.Nil(err)
+				assert.Equal("10,4", out.String())
+
+				_, err = run("bad")
+				assert.NotNil(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTemplateStream(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := NewValMap()
+	ctx.AddMap("a", NewValStr("x"))
+
+	for _, x := range [][2]string{
+		{"go", "{{.a}}-{{.a}}"},
+		{"pongo", "{{a}}-{{a}}"},
+		{"mustache", "{{a}}-{{a}}"},
+		{"raw", "${a}-${a}"},
+	} {
+		tpl := newTemplate(x[0])
+		assert.Nil(tpl.Compile("test", x[1], NewValNull()))
+
+		var b strings.Builder
+		assert.Nil(tpl.ExecuteStream(ctx, &b), x[0])
+		assert.Equal("x-x", b.String(), x[0])
+
+		// lazily rendered through reader
+		ts := NewValTemplateStream(tpl, ctx)
+		assert.True(IsValTemplateStream(ts))
+		r := ts.Usr().(*TemplateStream).NewReader()
+		data, err := ioutil.ReadAll(r)
+		assert.Nil(err)
+		assert.Nil(r.Close())
+		assert.Equal("x-x", string(data), x[0])
+	}
+
+	// rendering error is surfaced by the reader
+	tpl := newTemplate("go")
+	assert.Nil(tpl.Compile("test", "{{.a.b.c}}", NewValNull()))
+	ts := NewValTemplateStream(tpl, ctx)
+	r := ts.Usr().(*TemplateStream).NewReader()
+	_, err := ioutil.ReadAll(r)
+	assert.NotNil(err)
+
+	// the context is snapshotted, mutating it after the assignment neither
+	// changes the output nor races with the rendering goroutine
+	{
+		ctx := NewValMap()
+		list := NewValList()
+		list.AddList(NewValStr("a"))
+		ctx.AddMap("l", list)
+		ctx.AddMap("p", NewValPair(NewValStr("k"), NewValStr("v")))
+
+		tpl := newTemplate("go")
+		assert.Nil(tpl.Compile("test", "{{range .l}}{{.}}{{end}}-{{.p.first}}{{.p.second}}", NewValNull()))
+		ts := NewValTemplateStream(tpl, ctx)
+		r := ts.Usr().(*TemplateStream).NewReader()
+
+		done := make(chan string)
+		go func() {
+			data, _ := ioutil.ReadAll(r)
+			done <- string(data)
+		}()
+		for i := 0; i < 100; i++ {
+			list.AddList(NewValStr("b"))
+			ctx.AddMap("p", NewValNull())
+		}
+		assert.Equal("a-kv", <-done)
+		assert.Nil(r.Close())
+	}
+
+	// the cyclic context is snapshotted once, the copy keeps the cycle
+	{
+		ctx := NewValMap()
+		ctx.AddMap("name", NewValStr("x"))
+		ctx.AddMap("self", ctx)
+		list := NewValList()
+		list.AddList(list)
+		ctx.AddMap("l", list)
+
+		tpl := newTemplate("mustache")
+		assert.Nil(tpl.Compile("test", "{{self.self.name}}", NewValNull()))
+		ts := NewValTemplateStream(tpl, ctx)
+		snap := ts.Usr().(*TemplateStream).ctx
+		self, _ := snap.Map().Get("self")
+		assert.True(self.Map() == snap.Map())
+		assert.True(self.Map() != ctx.Map())
+		l, _ := snap.Map().Get("l")
+		assert.True(l.List().Data[0].List() == l.List())
+		out, err := ts.Usr().(*TemplateStream).String()
+		assert.Nil(err)
+		assert.Equal("x", out)
+	}
+
+	// only plain assignment to body field renders lazily
+	for _, x := range []struct {
+		code   string
+		stream bool
+	}{
+		{"test { let m = {}; m.body = template \"raw\", 1, ```EOF\nx\nEOF```; }", true},
+		{"test { let m = {}; m.data = template \"raw\", 1, ```EOF\nx\nEOF```; }", false},
+		{"test { let m = {}; m.body = \"a\" + template \"raw\", 1, ```EOF\nx\nEOF```; }", false},
+		{"test { let m = {}; m.body += template \"raw\", 1, ```EOF\nx\nEOF```; }", false},
+	} {
+		p := newParser(x.code, nil)
+		m, err := p.parse()
+		assert.Nil(err, x.code)
+		assert.Equal(x.stream, strings.Contains(m.Dump(), "template-stream"), x.code)
+	}
+}
+
+func TestTemplateMarkdown(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := NewValMap()
+	ctx.AddMap("name", NewValStr("moons"))
+	ctx.AddMap("intro", NewValStr("*{{name}}* intro"))
+
+	run := func(src string, opt Val, expect string) {
+		out, err := testTemplate("md", opt, src, ctx)
+		assert.Nil(err, src)
+		assert.Equal(expect, out, src)
+	}
+
+	// without option the source is plain markdown, as it used to be
+	mu := func(kv ...interface{}) Val {
+		return testTemplateOpt(append([]interface{}{"mustache", true, "front_matter", true}, kv...)...)
+	}
+	run("# hello", NewValNull(), "<h1>hello</h1>\n")
+	run("# {{name}}", NewValNull(), "<h1>{{name}}</h1>\n")
+	run("---\nx: 1\n---\n", NewValNull(), "<hr>\n\n<h2>x: 1</h2>\n")
+	run("<b>x</b>", NewValNull(), "<p><b>x</b></p>\n")
+	run("# {{name}}", mu(), "<h1>moons</h1>\n")
+
+	// front matter, overridden by runtime context
+	run("---\ntitle: \"doc\"\nname: other\ncount: 2\n---\n{{title}} {{name}} {{count}}",
+		mu(), "<p>doc moons 2</p>\n")
+	run("---\ntitle: t\n---\nx", testTemplateOpt("front_matter", true), "<p>x</p>\n")
+
+	// partial
+	run("{{> intro}}", mu(), "<p><em>moons</em> intro</p>\n")
+
+	// options
+	run("a~~b~~", testTemplateOpt("strikethrough", false), "<p>a~~b~~</p>\n")
+	run("<b>x</b>", testTemplateOpt("sanitize", true), "<p>x</p>\n")
+	run("# {{name}}", mu("layout", "<body>{{.content}}{{.name}}</body>"),
+		"<body><h1>moons</h1>\nmoons</body>")
+	run("---\ntitle: t\n---\nx", mu("layout", "{{title}}|{{{content}}}", "layout_engine", "mustache"),
+		"t|<p>x</p>\n")
+
+	for _, x := range []struct {
+		src string
+		opt Val
+	}{
+		{"---\ntitle: t\n", mu()},
+		{"---\ntitle\n---\n", mu()},
+		{"x", testTemplateOpt("tables", "yes")},
+		{"x", testTemplateOpt("mustache", "yes")},
+		{"x", testTemplateOpt("layout_engine", "none")},
+		{"{{#a}}", mu()},
+	} {
+		_, err := testTemplate("md", x.opt, x.src, ctx)
+		assert.NotNil(err, x.src)
+	}
+
+	// xss via the interpolated value is escaped and sanitized
+	xss := NewValMap()
+	xss.AddMap("x", NewValStr("<script>alert(1)</script>"))
+	xss.AddMap("link", NewValStr("[click](javascript:alert(1))"))
+	for _, src := range []string{"{{x}}", "{{{x}}}", "{{link}}", "{{{link}}}"} {
+		out, err := testTemplate("md", mu("sanitize", true), src, xss)
+		assert.Nil(err, src)
+		assert.NotContains(out, "<script", src)
+		assert.NotContains(out, "href=\"javascript:", src)
+	}
+	out, err := testTemplate("md", mu(), "{{x}}", xss)
+	assert.Nil(err)
+	assert.Equal("<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n", out)
+	out, err = testTemplate("md", mu("escape", false), "{{x}}", xss)
+	assert.Nil(err)
+	assert.Contains(out, "<script>")
+
+	// recursive partial
+	rec := NewValMap()
+	rec.AddMap("self", NewValStr("{{> self}}"))
+	_, err = testTemplate("md", mu(), "{{> self}}", rec)
+	assert.NotNil(err)
+}
@@ -0,0 +1,66 @@
+package pl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func TestTestBlock(t *testing.T) {
+	assert := assert.New(t)
+
+	module, err := CompileModule(`
+test {
+  return "rule";
+}
+
+test "passes" {
+  assert::eq(1 + 1, 2);
+  assert::throws(fn() { assert::eq(1, 2); }, "assert::eq");
+}
+
+test "fails" {
+  assert::throws(fn() { return 1; });
+}
+
+test "clock" {
+  assert::eq(time::unix(), 1000);
+}
+`, nil)
+	assert.Nil(err)
+	assert.Equal([]string{"passes", "fails", "clock"}, module.Tests())
+	assert.Equal([]string{"test"}, module.Rules())
+
+	code, ok := module.Disasm("passes")
+	assert.True(ok)
+	assert.Contains(code, ":program (passes)")
+	_, ok = module.Disasm("unknown")
+	assert.False(ok)
+
+	eval := NewEvaluatorSimple()
+	v, err := eval.Eval("test", module)
+	assert.Nil(err)
+	assert.Equal("rule", v.String())
+
+	assert.Nil(eval.EvalTest("passes", module))
+	assert.NotNil(eval.EvalTest("fails", module))
+	assert.NotNil(eval.EvalTest("unknown", module))
+
+	eval.SetClock(&testClock{now: time.Unix(1000, 0)})
+	assert.Nil(eval.EvalTest("clock", module))
+
+	_, err = CompileModule(`
+test "a" {}
+test "a" {}
+`, nil)
+	assert.NotNil(err)
+}
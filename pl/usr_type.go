@@ -0,0 +1,271 @@
+package pl
+
+import (
+	"fmt"
+	"sync"
+)
+
+// user type registry. Instead of implementing the whole Usr interface, an
+// embedder can declare a user type as a method table and register it with
+// RegisterUserType, then wrap any go value as the user type with
+// NewValUserType. Dot, DotSet, Method and the rest of the meta operations of
+// the value are dispatched through the table.
+//
+// A type can inherit another registered type by specifying its id as Parent,
+// the field, setter and method not found in the type's own table and the
+// unspecified meta operations are looked up from the parent chain. The value
+// of derived type is accepted wherever the parent type is expected, ie by
+// is(v, "parent") and the %U['parent'] function prototype.
+
+type UserMethod func(self interface{}, args []Val) (Val, error)
+type UserGetter func(self interface{}) (Val, error)
+type UserSetter func(self interface{}, value Val) error
+
+type MethodTable struct {
+	// id of the parent type, empty if no parent
+	Parent string
+
+	Methods map[string]UserMethod
+	Getters map[string]UserGetter
+	Setters map[string]UserSetter
+
+	// optional meta operations, inherited from parent when not specified
+	Index      func(self interface{}, index Val) (Val, error)
+	IndexSet   func(self interface{}, index Val, value Val) error
+	ToString   func(self interface{}) (string, error)
+	ToJSON     func(self interface{}) (Val, error)
+	Info       func(self interface{}) string
+	Iterator   func(self interface{}) (Iter, error)
+	ThreadSafe func(self interface{}) bool
+}
+
+type userType struct {
+	id     string
+	table  MethodTable
+	parent *userType
+}
+
+var (
+	userTypeLock sync.RWMutex
+	userTypeMap  = make(map[string]*userType)
+)
+
+func RegisterUserType(id string, table MethodTable) error {
+	userTypeLock.Lock()
+	defer userTypeLock.Unlock()
+
+	if id == "" {
+		return fmt.Errorf("user type id cannot be empty")
+	}
+	if _, ok := userTypeMap[id]; ok {
+		return fmt.Errorf("user type %s already registered", id)
+	}
+
+	t := &userType{
+		id:    id,
+		table: table,
+	}
+	if table.Parent != "" {
+		p, ok := userTypeMap[table.Parent]
+		if !ok {
+			return fmt.Errorf("user type %s's parent %s is not registered", id, table.Parent)
+		}
+		t.parent = p
+	}
+	userTypeMap[id] = t
+	return nil
+}
+
+func MustRegisterUserType(id string, table MethodTable) {
+	if err := RegisterUserType(id, table); err != nil {
+		panic(err.Error())
+	}
+}
+
+func getUserType(id string) *userType {
+	userTypeLock.RLock()
+	defer userTypeLock.RUnlock()
+	return userTypeMap[id]
+}
+
+// wraps go value as registered user type
+func NewValUserType(id string, self interface{}) (Val, error) {
+	t := getUserType(id)
+	if t == nil {
+		return NewValNull(), fmt.Errorf("user type %s is not registered", id)
+	}
+	return NewValUsr(&usrValue{
+		typ:  t,
+		self: self,
+	}), nil
+}
+
+func MustNewValUserType(id string, self interface{}) Val {
+	v, err := NewValUserType(id, self)
+	if err != nil {
+		panic(err.Error())
+	}
+	return v
+}
+
+// returns the wrapped go value of user type created by NewValUserType
+func UserTypeSelf(v Val) (interface{}, bool) {
+	if v.Type != ValUsr {
+		return nil, false
+	}
+	x, ok := v.Usr().(*usrValue)
+	if !ok {
+		return nil, false
+	}
+	return x.self, true
+}
+
+// checks whether the value is of the type, for user type the parent chain is
+// also checked, otherwise the type is compared with the value's type name or
+// id, ie is(1, "int"), is(f, "closure")
+func ValIsType(v Val, id string) bool {
+	if v.Type == ValUsr {
+		if x, ok := v.Usr().(*usrValue); ok {
+			for t := x.typ; t != nil; t = t.parent {
+				if t.id == id {
+					return true
+				}
+			}
+			return false
+		}
+		return v.Id() == id
+	}
+	return v.TypeName() == id || v.Id() == id
+}
+
+type usrValue struct {
+	typ  *userType
+	self interface{}
+}
+
+func (u *usrValue) method(name string) UserMethod {
+	for t := u.typ; t != nil; t = t.parent {
+		if m, ok := t.table.Methods[name]; ok {
+			return m
+		}
+	}
+	return nil
+}
+
+func (u *usrValue) getter(name string) UserGetter {
+	for t := u.typ; t != nil; t = t.parent {
+		if m, ok := t.table.Getters[name]; ok {
+			return m
+		}
+	}
+	return nil
+}
+
+func (u *usrValue) setter(name string) UserSetter {
+	for t := u.typ; t != nil; t = t.parent {
+		if m, ok := t.table.Setters[name]; ok {
+			return m
+		}
+	}
+	return nil
+}
+
+// lookup the first type in the chain satisfies the predicate
+func (u *usrValue) lookup(has func(*MethodTable) bool) *MethodTable {
+	for t := u.typ; t != nil; t = t.parent {
+		if has(&t.table) {
+			return &t.table
+		}
+	}
+	return nil
+}
+
+func (u *usrValue) Index(idx Val) (Val, error) {
+	if t := u.lookup(func(t *MethodTable) bool { return t.Index != nil }); t != nil {
+		return t.Index(u.self, idx)
+	}
+	return NewValNull(), fmt.Errorf("type: %s does not support index", u.Id())
+}
+
+func (u *usrValue) IndexSet(idx Val, val Val) error {
+	if t := u.lookup(func(t *MethodTable) bool { return t.IndexSet != nil }); t != nil {
+		return t.IndexSet(u.self, idx, val)
+	}
+	return fmt.Errorf("type: %s does not support index set", u.Id())
+}
+
+func (u *usrValue) Dot(name string) (Val, error) {
+	if g := u.getter(name); g != nil {
+		return g(u.self)
+	}
+	return NewValNull(), fmt.Errorf("type: %s does not support field %s", u.Id(), name)
+}
+
+func (u *usrValue) DotSet(name string, val Val) error {
+	if s := u.setter(name); s != nil {
+		return s(u.self, val)
+	}
+	return fmt.Errorf("type: %s does not support field %s set", u.Id(), name)
+}
+
+func (u *usrValue) Method(name string, args []Val) (Val, error) {
+	if m := u.method(name); m != nil {
+		return m(u.self, args)
+	}
+	return NewValNull(), fmt.Errorf("%s method: %s is unknown", u.Id(), name)
+}
+
+func (u *usrValue) ToString() (string, error) {
+	if t := u.lookup(func(t *MethodTable) bool { return t.ToString != nil }); t != nil {
+		return t.ToString(u.self)
+	}
+	return fmt.Sprintf("[%s]", u.Id()), nil
+}
+
+func (u *usrValue) ToJSON() (Val, error) {
+	if t := u.lookup(func(t *MethodTable) bool { return t.ToJSON != nil }); t != nil {
+		return t.ToJSON(u.self)
+	}
+	return NewValNull(), fmt.Errorf("type: %s does not support to json", u.Id())
+}
+
+func (u *usrValue) Id() string {
+	return u.typ.id
+}
+
+func (u *usrValue) Info() string {
+	if t := u.lookup(func(t *MethodTable) bool { return t.Info != nil }); t != nil {
+		return t.Info(u.self)
+	}
+	return u.Id()
+}
+
+func (u *usrValue) IsThreadSafe() bool {
+	if t := u.lookup(func(t *MethodTable) bool { return t.ThreadSafe != nil }); t != nil {
+		return t.ThreadSafe(u.self)
+	}
+	return false
+}
+
+func (u *usrValue) NewIterator() (Iter, error) {
+	if t := u.lookup(func(t *MethodTable) bool { return t.Iterator != nil }); t != nil {
+		return t.Iterator(u.self)
+	}
+	return nil, fmt.Errorf("type: %s does not support iterator", u.Id())
+}
+
+func init() {
+	// is(value, type), checks whether value is of the type, see ValIsType
+	addF(
+		"is",
+		"",
+		"%a%s",
+		func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+			if _, err := info.Check(args); err != nil {
+				return NewValNull(), err
+			}
+			return NewValBool(ValIsType(args[0], args[1].String())), nil
+		},
+		"is(v, type), whether the value is of the type",
+	)
+}
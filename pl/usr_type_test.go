@@ -0,0 +1,146 @@
+package pl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testAnimal struct {
+	name string
+}
+
+type testDog struct {
+	testAnimal
+	tricks int
+}
+
+func init() {
+	MustRegisterUserType("test.animal", MethodTable{
+		Methods: map[string]UserMethod{
+			"speak": func(_ interface{}, _ []Val) (Val, error) {
+				return NewValStr("..."), nil
+			},
+			"hello": func(self interface{}, _ []Val) (Val, error) {
+				return NewValStr("hi " + self.(*testDog).name), nil
+			},
+		},
+		Getters: map[string]UserGetter{
+			"name": func(self interface{}) (Val, error) {
+				return NewValStr(self.(*testDog).name), nil
+			},
+		},
+		ToString: func(self interface{}) (string, error) {
+			return "animal " + self.(*testDog).name, nil
+		},
+	})
+
+	MustRegisterUserType("test.dog", MethodTable{
+		Parent: "test.animal",
+		Methods: map[string]UserMethod{
+			"speak": func(_ interface{}, _ []Val) (Val, error) {
+				return NewValStr("woof"), nil
+			},
+		},
+		Getters: map[string]UserGetter{
+			"tricks": func(self interface{}) (Val, error) {
+				return NewValInt(self.(*testDog).tricks), nil
+			},
+		},
+		Setters: map[string]UserSetter{
+			"tricks": func(self interface{}, v Val) error {
+				if !v.IsInt() {
+					return fmt.Errorf("tricks must be int")
+				}
+				self.(*testDog).tricks = int(v.Int())
+				return nil
+			},
+		},
+	})
+
+	addF("test_dog_tricks", "", "%U['test.animal']", func(info *IntrinsicInfo, _ *Evaluator, _ string, args []Val) (Val, error) {
+		if _, err := info.Check(args); err != nil {
+			return NewValNull(), err
+		}
+		self, _ := UserTypeSelf(args[0])
+		return NewValInt(self.(*testDog).tricks), nil
+	})
+}
+
+func testUserType(code string, dog Val) (Val, error) {
+	rr := NewValNull()
+	eval := NewEvaluatorWithContextCallback(
+		func(_ *Evaluator, vname string) (Val, error) {
+			if vname == "dog" {
+				return dog, nil
+			}
+			return NewValNull(), fmt.Errorf("%s unknown var", vname)
+		},
+		nil,
+		func(_ *Evaluator, _ string, aval Val) error {
+			rr = aval
+			return nil
+		})
+
+	module, err := CompileModule(code, nil)
+	if err != nil {
+		return rr, err
+	}
+	if err := eval.EvalSession(module); err != nil {
+		return rr, err
+	}
+	_, err = eval.Eval("test", module)
+	return rr, err
+}
+
+func TestUserType(t *testing.T) {
+	assert := assert.New(t)
+
+	d := &testDog{testAnimal: testAnimal{name: "rex"}, tricks: 1}
+	dog := MustNewValUserType("test.dog", d)
+
+	self, ok := UserTypeSelf(dog)
+	assert.True(ok)
+	assert.True(self == d)
+	assert.Equal("test.dog", dog.Id())
+
+	run := func(code string) Val {
+		v, err := testUserType(code, dog)
+		assert.Nil(err, code)
+		return v
+	}
+
+	v := run(`test { output => dog:speak(); }`)
+	assert.Equal("woof", v.String())
+	v = run(`test { output => dog:hello(); }`)
+	assert.Equal("hi rex", v.String())
+	v = run(`test { output => dog.name + to_string(dog.tricks); }`)
+	assert.Equal("rex1", v.String())
+	v = run(`test { dog.tricks = 5; output => test_dog_tricks(dog); }`)
+	assert.Equal(int64(5), v.Int())
+	v = run(`test { output => [is(dog, "test.dog"), is(dog, "test.animal"), is(dog, "map"), is(1, "int"), is({}, "map")]; }`)
+	for i, x := range []bool{true, true, false, true, true} {
+		assert.Equal(x, v.List().Data[i].Bool(), i)
+	}
+	s, err := dog.ToString()
+	assert.Nil(err)
+	assert.Equal("animal rex", s)
+
+	for _, code := range []string{
+		`test { output => dog:bark(); }`,
+		`test { output => dog.age; }`,
+		`test { dog.name = "x"; }`,
+		`test { dog.tricks = "x"; }`,
+		`test { output => dog[0]; }`,
+		`test { output => test_dog_tricks(1); }`,
+	} {
+		_, err := testUserType(code, dog)
+		assert.NotNil(err, code)
+	}
+
+	assert.NotNil(RegisterUserType("test.dog", MethodTable{}))
+	assert.NotNil(RegisterUserType("test.cat", MethodTable{Parent: "test.none"}))
+	_, err = NewValUserType("test.none", nil)
+	assert.NotNil(err)
+}
@@ -0,0 +1,106 @@
+package pltest
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/dianpeng/moons/pl"
+)
+
+// Action is the action issued by the script, in order of issue
+type Action struct {
+	Name string
+	Val  pl.Val
+}
+
+// Context is the mock EvalContext of the tests. The variables are loaded from
+// and stored into Vars, the actions are recorded instead of performed. Besides
+// the variables, the test functions are bound, see NewTestFunction
+type Context struct {
+	Vars    map[string]pl.Val
+	Actions []Action
+
+	clock  *FakeClock
+	config *Config
+}
+
+// NewContext returns the context bound to the clock and the config, the clock
+// may be nil when the evaluator uses the wall clock
+func NewContext(clock *FakeClock, config *Config) *Context {
+	return &Context{
+		Vars:   make(map[string]pl.Val),
+		clock:  clock,
+		config: config,
+	}
+}
+
+func (c *Context) LoadVar(_ *pl.Evaluator, name string) (pl.Val, error) {
+	if v, ok := NewTestFunction(name, c); ok {
+		return v, nil
+	}
+	if v, ok := c.Vars[name]; ok {
+		return v, nil
+	}
+	return pl.NewValNull(), fmt.Errorf("pltest: unknown variable %s", name)
+}
+
+func (c *Context) StoreVar(_ *pl.Evaluator, name string, v pl.Val) error {
+	c.Vars[name] = v
+	return nil
+}
+
+func (c *Context) Action(_ *pl.Evaluator, name string, v pl.Val) error {
+	c.Actions = append(c.Actions, Action{
+		Name: name,
+		Val:  v,
+	})
+	return nil
+}
+
+// Reset forgets the actions and the variables stored by the previous test
+func (c *Context) Reset(vars map[string]pl.Val) {
+	c.Actions = nil
+	c.Vars = make(map[string]pl.Val)
+	for k, v := range vars {
+		c.Vars[k] = v
+	}
+}
+
+type acceptConfig struct{}
+
+func (acceptConfig) PushConfig(_ *pl.Evaluator, _ string, _ pl.Val) error {
+	return nil
+}
+
+func (acceptConfig) PopConfig(_ *pl.Evaluator) error {
+	return nil
+}
+
+func (acceptConfig) ConfigProperty(_ *pl.Evaluator, _ string, _ pl.Val, _ pl.Val) error {
+	return nil
+}
+
+func (acceptConfig) ConfigCommand(_ *pl.Evaluator, _ string, _ []pl.Val, _ pl.Val) error {
+	return nil
+}
+
+// Config is the mock EvalConfig, every call of the config block is accepted
+// and recorded, so the tests can check what the config block populates
+type Config struct {
+	*pl.ConfigAudit
+}
+
+func NewConfig() *Config {
+	return &Config{
+		ConfigAudit: pl.NewConfigAudit(acceptConfig{}),
+	}
+}
+
+// the entries as a list of maps, ie {"op": "property", "scope": "server", ...}
+func (c *Config) entriesVal() (pl.Val, error) {
+	data, err := json.Marshal(c.Entries())
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	return pl.NewValFromJSON(string(data))
+}
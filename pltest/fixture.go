@@ -0,0 +1,215 @@
+package pltest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/pl"
+)
+
+// Functions bound to the test blocks by the mock context, like the kv:: ones
+// of the runtime
+//
+//   fixture::request(method, url, [body], [header])  the HTTP request of hpl
+//   fixture::response(status, [body], [header])      the HTTP response of hpl
+//   clock::now()                                     the fake clock in ms
+//   clock::set(ms)                                   sets the fake clock
+//   clock::advance(ms)                               moves the fake clock on
+//   mock::actions()                                  the actions issued so
+//                                                    far, [{name, value}]
+//   mock::config_calls()                             the calls made by the
+//                                                    config block
+//
+// The header is a map of the header value, or list of values, by name, the
+// body other than string is written as JSON.
+
+var (
+	protoFixtureRequest  = pl.MustNewFuncProto("fixture::request", "{%s%s}{%s%s%a}{%s%s%a%m}")
+	protoFixtureResponse = pl.MustNewFuncProto("fixture::response", "{%d}{%d%a}{%d%a%m}")
+	protoClockNow        = pl.MustNewFuncProto("clock::now", "%0")
+	protoClockSet        = pl.MustNewFuncProto("clock::set", "%d")
+	protoClockAdvance    = pl.MustNewFuncProto("clock::advance", "%d")
+	protoMockActions     = pl.MustNewFuncProto("mock::actions", "%0")
+	protoMockConfig      = pl.MustNewFuncProto("mock::config_calls", "%0")
+)
+
+// NewRequest returns the HTTP request fixture of hpl, the header may be nil
+func NewRequest(method string, url string, body string, header http.Header) (pl.Val, error) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return hpl.NewRequestVal(req), nil
+}
+
+// NewResponse returns the HTTP response fixture of hpl, ie the one of the
+// upstream, the header may be nil
+func NewResponse(status int, body string, header http.Header) pl.Val {
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          http.NoBody,
+		ContentLength: int64(len(body)),
+	}
+	for k, v := range header {
+		resp.Header[k] = v
+	}
+	if body != "" {
+		resp.Body = io.NopCloser(strings.NewReader(body))
+	}
+	return hpl.NewResponseVal(resp)
+}
+
+func fixtureBody(name string, v pl.Val) (string, error) {
+	switch v.Type {
+	case pl.ValNull:
+		return "", nil
+	case pl.ValStr:
+		return v.String(), nil
+	default:
+		if str, err := v.ToJSONString(); err == nil {
+			return str, nil
+		}
+		return "", fmt.Errorf("%s: body must be string or JSON value", name)
+	}
+}
+
+func fixtureHeader(v pl.Val) (http.Header, error) {
+	hdr, err := hpl.NewHeaderValFromVal(v)
+	if err != nil {
+		return nil, err
+	}
+	return hdr.Usr().(*hpl.Header).HttpHeader(), nil
+}
+
+func fixtureRequest(_ *Context, args []pl.Val) (pl.Val, error) {
+	alog, err := protoFixtureRequest.Check(args)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	body := ""
+	if alog >= 3 {
+		if body, err = fixtureBody("fixture::request", args[2]); err != nil {
+			return pl.NewValNull(), err
+		}
+	}
+	var header http.Header
+	if alog == 4 {
+		if header, err = fixtureHeader(args[3]); err != nil {
+			return pl.NewValNull(), err
+		}
+	}
+	return NewRequest(args[0].String(), args[1].String(), body, header)
+}
+
+func fixtureResponse(_ *Context, args []pl.Val) (pl.Val, error) {
+	alog, err := protoFixtureResponse.Check(args)
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	body := ""
+	if alog >= 2 {
+		if body, err = fixtureBody("fixture::response", args[1]); err != nil {
+			return pl.NewValNull(), err
+		}
+	}
+	var header http.Header
+	if alog == 3 {
+		if header, err = fixtureHeader(args[2]); err != nil {
+			return pl.NewValNull(), err
+		}
+	}
+	return NewResponse(int(args[0].Int()), body, header), nil
+}
+
+// the context of the REPL has no fake clock, the wall clock is used instead
+var errNoClock = fmt.Errorf("clock:: functions need the fake clock of the tests")
+
+func clockNow(c *Context, args []pl.Val) (pl.Val, error) {
+	if _, err := protoClockNow.Check(args); err != nil {
+		return pl.NewValNull(), err
+	}
+	if c.clock == nil {
+		return pl.NewValNull(), errNoClock
+	}
+	return pl.NewValInt64(c.clock.Now().UnixMilli()), nil
+}
+
+func clockSet(c *Context, args []pl.Val) (pl.Val, error) {
+	if _, err := protoClockSet.Check(args); err != nil {
+		return pl.NewValNull(), err
+	}
+	if c.clock == nil {
+		return pl.NewValNull(), errNoClock
+	}
+	c.clock.Set(time.UnixMilli(args[0].Int()))
+	return pl.NewValNull(), nil
+}
+
+func clockAdvance(c *Context, args []pl.Val) (pl.Val, error) {
+	if _, err := protoClockAdvance.Check(args); err != nil {
+		return pl.NewValNull(), err
+	}
+	if c.clock == nil {
+		return pl.NewValNull(), errNoClock
+	}
+	c.clock.Advance(time.Duration(args[0].Int()) * time.Millisecond)
+	return pl.NewValNull(), nil
+}
+
+func mockActions(c *Context, args []pl.Val) (pl.Val, error) {
+	if _, err := protoMockActions.Check(args); err != nil {
+		return pl.NewValNull(), err
+	}
+	o := pl.NewValList()
+	for _, x := range c.Actions {
+		m := pl.NewValMap()
+		m.AddMap("name", pl.NewValStr(x.Name))
+		m.AddMap("value", x.Val)
+		o.AddList(m)
+	}
+	return o, nil
+}
+
+func mockConfig(c *Context, args []pl.Val) (pl.Val, error) {
+	if _, err := protoMockConfig.Check(args); err != nil {
+		return pl.NewValNull(), err
+	}
+	return c.config.entriesVal()
+}
+
+var testFunction = map[string]func(*Context, []pl.Val) (pl.Val, error){
+	"fixture::request":   fixtureRequest,
+	"fixture::response":  fixtureResponse,
+	"clock::now":         clockNow,
+	"clock::set":         clockSet,
+	"clock::advance":     clockAdvance,
+	"mock::actions":      mockActions,
+	"mock::config_calls": mockConfig,
+}
+
+// NewTestFunction returns the test function of the name bound to the context,
+// the false is returned if the name is not a test function
+func NewTestFunction(name string, c *Context) (pl.Val, bool) {
+	fn, ok := testFunction[name]
+	if !ok {
+		return pl.NewValNull(), false
+	}
+	return pl.NewValNativeFunction(
+		name,
+		func(args []pl.Val) (pl.Val, error) {
+			return fn(c, args)
+		},
+	), true
+}
@@ -0,0 +1,132 @@
+package pltest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// JUnit XML report of the suites, understood by most of the CI systems. The
+// error of the suite is reported as a test case named by the suite with an
+// error element
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+// the short message of the failure, ie without the source and the backtrace
+// the evaluator decorates the error with
+func failureMessage(failure string) string {
+	if i := strings.Index(failure, " has error: "); i >= 0 {
+		failure = failure[i+len(" has error: "):]
+	}
+	if i := strings.IndexByte(failure, '\n'); i >= 0 {
+		failure = failure[:i]
+	}
+	return failure
+}
+
+func indent(text string) string {
+	return "    " + strings.ReplaceAll(strings.TrimRight(text, "\n"), "\n", "\n    ")
+}
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func WriteJUnit(w io.Writer, suites []*Suite) error {
+	o := junitSuites{}
+	for _, s := range suites {
+		x := junitSuite{
+			Name:     s.Name,
+			Tests:    len(s.Results),
+			Failures: s.Failures(),
+			Time:     junitTime(s.Time),
+		}
+		if s.Error != "" {
+			x.Errors = 1
+			x.Cases = append(x.Cases, junitCase{
+				Name:      s.Name,
+				ClassName: s.Name,
+				Time:      junitTime(0),
+				Error: &junitFailure{
+					Message: failureMessage(s.Error),
+					Text:    s.Error,
+				},
+			})
+		}
+		for _, r := range s.Results {
+			c := junitCase{
+				Name:      r.Name,
+				ClassName: s.Name,
+				Time:      junitTime(r.Time),
+			}
+			if !r.Passed() {
+				c.Failure = &junitFailure{
+					Message: failureMessage(r.Failure),
+					Text:    r.Failure,
+				}
+			}
+			x.Cases = append(x.Cases, c)
+		}
+		o.Suites = append(o.Suites, x)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(o); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteText writes the report in the form of go test, one line per test and
+// the status of the suite at last
+func WriteText(w io.Writer, suites []*Suite) {
+	for _, s := range suites {
+		if s.Error != "" {
+			fmt.Fprintf(w, "--- ERROR: %s\n%s\n", s.Name, indent(s.Error))
+		}
+		for _, r := range s.Results {
+			if r.Passed() {
+				fmt.Fprintf(w, "--- PASS: %s (%.2fs)\n", r.Name, r.Time.Seconds())
+			} else {
+				fmt.Fprintf(w, "--- FAIL: %s (%.2fs)\n%s\n", r.Name, r.Time.Seconds(), indent(r.Failure))
+			}
+		}
+		status := "ok"
+		if !s.Passed() {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "%-4s\t%s\t%.3fs\n", status, s.Name, s.Time.Seconds())
+	}
+}
@@ -0,0 +1,159 @@
+package pltest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/dianpeng/moons/pl"
+)
+
+// Runner runs the test blocks of the modules, ie
+//
+//   test "strips the prefix" {
+//     let req = fixture::request("GET", "http://a.com/api/user");
+//     assert::eq(strip(req.url.path), "/user");
+//   }
+//
+// Each test is run by a new evaluator against the mock context and config, its
+// session scope is evaluated first and the fake clock starts at Now. The config
+// and global scope of the module are evaluated once before any test.
+
+// Result is the outcome of one test, the failure is empty once passed
+type Result struct {
+	Name    string
+	Time    time.Duration
+	Failure string
+}
+
+func (r *Result) Passed() bool {
+	return r.Failure == ""
+}
+
+// Suite is the tests of one module. The error is set when the module cannot
+// be compiled or its config or global scope fails, no test is run then
+type Suite struct {
+	Name    string
+	Time    time.Duration
+	Error   string
+	Results []Result
+}
+
+func (s *Suite) Failures() int {
+	cnt := 0
+	for _, x := range s.Results {
+		if !x.Passed() {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+func (s *Suite) Passed() bool {
+	return s.Error == "" && s.Failures() == 0
+}
+
+type Runner struct {
+	// start time of the fake clock of every test
+	Now time.Time
+
+	// variables of the mock context every test starts with, ie request
+	Vars map[string]pl.Val
+
+	// only the tests whose name matches are run, nil runs all
+	Filter *regexp.Regexp
+}
+
+func NewRunner() *Runner {
+	return &Runner{
+		Now:  time.Now(),
+		Vars: make(map[string]pl.Val),
+	}
+}
+
+// RunFile compiles the file and runs its tests, the imports are resolved
+// against the directory of the file
+func (r *Runner) RunFile(path string) *Suite {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return &Suite{
+			Name:  path,
+			Error: err.Error(),
+		}
+	}
+	module, err := pl.CompileModule(string(data), os.DirFS(filepath.Dir(path)))
+	if err != nil {
+		return &Suite{
+			Name:  path,
+			Error: err.Error(),
+		}
+	}
+	return r.RunModule(path, module)
+}
+
+func (r *Runner) RunModule(name string, module *pl.Module) *Suite {
+	start := time.Now()
+	s := &Suite{
+		Name: name,
+	}
+	defer func() {
+		s.Time = time.Since(start)
+	}()
+
+	clock := NewFakeClock(r.Now)
+	config := NewConfig()
+	ctx := NewContext(clock, config)
+	ctx.Reset(r.Vars)
+
+	eval := pl.NewEvaluator(ctx, config)
+	eval.SetClock(clock)
+	if err := eval.EvalConfig(module); err != nil {
+		s.Error = fmt.Sprintf("config: %s", err.Error())
+		return s
+	}
+	if err := eval.EvalGlobal(module); err != nil {
+		s.Error = fmt.Sprintf("global: %s", err.Error())
+		return s
+	}
+
+	for _, test := range module.Tests() {
+		if r.Filter != nil && !r.Filter.MatchString(test) {
+			continue
+		}
+		s.Results = append(s.Results, r.runTest(test, module, clock, ctx, config))
+	}
+	return s
+}
+
+func (r *Runner) runTest(
+	name string,
+	module *pl.Module,
+	clock *FakeClock,
+	ctx *Context,
+	config *Config,
+) (result Result) {
+	start := time.Now()
+	result.Name = name
+	defer func() {
+		if x := recover(); x != nil {
+			result.Failure = fmt.Sprintf("panic: %v", x)
+		}
+		result.Time = time.Since(start)
+	}()
+
+	clock.Set(r.Now)
+	ctx.Reset(r.Vars)
+
+	eval := pl.NewEvaluator(ctx, config)
+	eval.SetClock(clock)
+	if err := eval.EvalSession(module); err != nil {
+		result.Failure = fmt.Sprintf("session: %s", err.Error())
+		return
+	}
+	if err := eval.EvalTest(name, module); err != nil {
+		result.Failure = err.Error()
+	}
+	return
+}
@@ -0,0 +1,169 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Admission control by the number of in flight requests. At most MaxInFlight
+// requests are served at once, the request arriving when the limit is reached
+// waits in a bounded queue until a slot is released or the queue timeout is
+// reached. The request is rejected at once when the queue is full as well.
+
+const (
+	defaultQueueTimeout = time.Second
+	defaultRetryAfter   = time.Second
+)
+
+// ErrSaturated is returned when the request is not admitted, either the queue
+// is full or the request waits too long
+var ErrSaturated = errors.New("ratelimit: too many requests in flight")
+
+type ConcurrencyOption struct {
+	MaxInFlight int
+
+	// max number of waiting requests, 0 means the request is rejected as soon
+	// as the limit is reached
+	MaxQueue int
+
+	// max time the request waits in the queue, default is 1 second
+	QueueTimeout time.Duration
+
+	// suggested delay of the client once rejected, default is 1 second
+	RetryAfter time.Duration
+}
+
+type ConcurrencyStats struct {
+	Limit    int
+	InFlight int
+	MaxQueue int
+	Queued   int
+
+	// counters since the limiter is created
+	Admitted int64
+	Rejected int64
+	TimedOut int64
+}
+
+type Concurrency struct {
+	// accessed atomically, kept first for the 64 bit alignment
+	queued   int64
+	admitted int64
+	rejected int64
+	timedOut int64
+
+	option ConcurrencyOption
+	slot   chan struct{}
+}
+
+func NewConcurrency(option *ConcurrencyOption) (*Concurrency, error) {
+	o := *option
+	if o.MaxInFlight <= 0 {
+		return nil, fmt.Errorf("ratelimit: max_inflight must be positive")
+	}
+	if o.MaxQueue < 0 {
+		return nil, fmt.Errorf("ratelimit: queue must not be negative")
+	}
+	if o.QueueTimeout <= 0 {
+		o.QueueTimeout = defaultQueueTimeout
+	}
+	if o.RetryAfter <= 0 {
+		o.RetryAfter = defaultRetryAfter
+	}
+	return &Concurrency{
+		option: o,
+		slot:   make(chan struct{}, o.MaxInFlight),
+	}, nil
+}
+
+func (c *Concurrency) Option() ConcurrencyOption {
+	return c.option
+}
+
+func (c *Concurrency) release() {
+	<-c.slot
+}
+
+// Acquire admits the request, the returned function must be called once the
+// request is done. The request waits in the queue when the limit is reached
+func (c *Concurrency) Acquire(ctx context.Context) (func(), error) {
+	select {
+	case c.slot <- struct{}{}:
+		atomic.AddInt64(&c.admitted, 1)
+		return c.release, nil
+	default:
+		break
+	}
+
+	if atomic.AddInt64(&c.queued, 1) > int64(c.option.MaxQueue) {
+		atomic.AddInt64(&c.queued, -1)
+		atomic.AddInt64(&c.rejected, 1)
+		return nil, ErrSaturated
+	}
+	defer atomic.AddInt64(&c.queued, -1)
+
+	timer := time.NewTimer(c.option.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case c.slot <- struct{}{}:
+		atomic.AddInt64(&c.admitted, 1)
+		return c.release, nil
+	case <-timer.C:
+		atomic.AddInt64(&c.timedOut, 1)
+		return nil, ErrSaturated
+	case <-ctx.Done():
+		atomic.AddInt64(&c.rejected, 1)
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Concurrency) Stats() ConcurrencyStats {
+	return ConcurrencyStats{
+		Limit:    c.option.MaxInFlight,
+		InFlight: len(c.slot),
+		MaxQueue: c.option.MaxQueue,
+		Queued:   int(atomic.LoadInt64(&c.queued)),
+		Admitted: atomic.LoadInt64(&c.admitted),
+		Rejected: atomic.LoadInt64(&c.rejected),
+		TimedOut: atomic.LoadInt64(&c.timedOut),
+	}
+}
+
+// concurrency limiters are registered by name, ie the vhost name or the vhost
+// and service name, so the occupancy can be inspected
+var (
+	concurrencyLock     sync.Mutex
+	concurrencyRegistry = make(map[string]*Concurrency)
+)
+
+// RegisterConcurrency stores the limiter under the name, the one registered
+// before is replaced, ie the vhost is recreated
+func RegisterConcurrency(name string, c *Concurrency) {
+	concurrencyLock.Lock()
+	defer concurrencyLock.Unlock()
+	concurrencyRegistry[name] = c
+}
+
+func FindConcurrency(name string) *Concurrency {
+	concurrencyLock.Lock()
+	defer concurrencyLock.Unlock()
+	return concurrencyRegistry[name]
+}
+
+// ConcurrencyNames returns the names of the registered limiters in order
+func ConcurrencyNames() []string {
+	concurrencyLock.Lock()
+	defer concurrencyLock.Unlock()
+	o := make([]string, 0, len(concurrencyRegistry))
+	for name := range concurrencyRegistry {
+		o = append(o, name)
+	}
+	sort.Strings(o)
+	return o
+}
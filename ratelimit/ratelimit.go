@@ -0,0 +1,297 @@
+package ratelimit
+
+import (
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+// Keyed rate limiter shared by all sessions. Each key, ie client ip, has its
+// own state and the number of keys is bounded, the least recently used key is
+// evicted when the bound is reached. Two algorithms are supported:
+//
+//   token_bucket, the bucket holds at most burst tokens and is refilled by
+//   rate tokens per second, each request takes one token
+//
+//   sliding_window, at most rate * window requests are admitted within any
+//   window, the count of the window is approximated by weighting the count of
+//   the previous fixed window by its overlap
+
+const (
+	TokenBucket   = "token_bucket"
+	SlidingWindow = "sliding_window"
+)
+
+const (
+	defaultMaxKeys = 100000
+	shardSize      = 16
+)
+
+type Option struct {
+	Algorithm string
+
+	// requests per second, and the capacity of the bucket
+	Rate  float64
+	Burst int
+
+	// window of the sliding window algorithm, default is 1 second
+	Window time.Duration
+
+	// max number of keys tracked, default is 100000
+	MaxKeys int
+}
+
+type Result struct {
+	Allowed bool
+
+	// max requests admitted at once, and how many are left
+	Limit     int
+	Remaining int
+
+	// when the next request can be admitted if it is rejected, and when the
+	// limiter is fully replenished
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+type state struct {
+	key string
+
+	// token bucket
+	tokens float64
+	last   time.Time
+
+	// sliding window
+	start time.Time
+	count int
+	prev  int
+}
+
+type shard struct {
+	sync.Mutex
+	lru   *list.List
+	entry map[string]*list.Element
+}
+
+type Limiter struct {
+	option Option
+	limit  int
+	shard  [shardSize]shard
+}
+
+func New(option *Option) (*Limiter, error) {
+	o := *option
+	if o.Algorithm == "" {
+		o.Algorithm = TokenBucket
+	}
+	if o.Rate <= 0 || math.IsInf(o.Rate, 0) || math.IsNaN(o.Rate) {
+		return nil, fmt.Errorf("ratelimit: rate must be positive")
+	}
+	if o.MaxKeys <= 0 {
+		o.MaxKeys = defaultMaxKeys
+	}
+
+	l := &Limiter{}
+	switch o.Algorithm {
+	case TokenBucket:
+		if o.Burst <= 0 {
+			o.Burst = int(math.Max(1, math.Ceil(o.Rate)))
+		}
+		l.limit = o.Burst
+	case SlidingWindow:
+		if o.Window <= 0 {
+			o.Window = time.Second
+		}
+		l.limit = int(o.Rate * o.Window.Seconds())
+		if l.limit < 1 {
+			return nil, fmt.Errorf("ratelimit: rate * window must be at least 1")
+		}
+	default:
+		return nil, fmt.Errorf("ratelimit: unknown algorithm %s", o.Algorithm)
+	}
+
+	l.option = o
+	for i := range l.shard {
+		l.shard[i].lru = list.New()
+		l.shard[i].entry = make(map[string]*list.Element)
+	}
+	return l, nil
+}
+
+func (l *Limiter) Option() Option {
+	return l.option
+}
+
+// Limit is the max number of requests admitted at once
+func (l *Limiter) Limit() int {
+	return l.limit
+}
+
+// Len is the number of keys being tracked
+func (l *Limiter) Len() int {
+	n := 0
+	for i := range l.shard {
+		s := &l.shard[i]
+		s.Lock()
+		n += s.lru.Len()
+		s.Unlock()
+	}
+	return n
+}
+
+func (l *Limiter) shardOf(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &l.shard[h.Sum32()%shardSize]
+}
+
+func (l *Limiter) stateOf(s *shard, key string, now time.Time) *state {
+	if e, ok := s.entry[key]; ok {
+		s.lru.MoveToFront(e)
+		return e.Value.(*state)
+	}
+
+	x := &state{
+		key:    key,
+		tokens: float64(l.limit),
+		last:   now,
+		start:  now,
+	}
+	s.entry[key] = s.lru.PushFront(x)
+
+	// the bound is per shard so eviction never crosses the lock
+	for s.lru.Len() > l.option.MaxKeys/shardSize+1 {
+		e := s.lru.Back()
+		s.lru.Remove(e)
+		delete(s.entry, e.Value.(*state).key)
+	}
+	return x
+}
+
+// Allow takes one request of the key
+func (l *Limiter) Allow(key string) Result {
+	return l.AllowN(key, 1, time.Now())
+}
+
+// AllowN takes n requests of the key at the time, nothing is taken if they
+// are not all admitted
+func (l *Limiter) AllowN(key string, n int, now time.Time) Result {
+	s := l.shardOf(key)
+	s.Lock()
+	defer s.Unlock()
+
+	x := l.stateOf(s, key, now)
+	if l.option.Algorithm == TokenBucket {
+		return l.tokenBucket(x, n, now)
+	}
+	return l.slidingWindow(x, n, now)
+}
+
+func (l *Limiter) tokenBucket(x *state, n int, now time.Time) Result {
+	rate := l.option.Rate
+	if elapsed := now.Sub(x.last).Seconds(); elapsed > 0 {
+		x.tokens = math.Min(float64(l.limit), x.tokens+elapsed*rate)
+		x.last = now
+	}
+
+	r := Result{Limit: l.limit}
+	if x.tokens >= float64(n) {
+		x.tokens -= float64(n)
+		r.Allowed = true
+	} else {
+		r.RetryAfter = seconds((float64(n) - x.tokens) / rate)
+	}
+	r.Remaining = int(math.Floor(x.tokens))
+	r.Reset = seconds((float64(l.limit) - x.tokens) / rate)
+	return r
+}
+
+func (l *Limiter) slidingWindow(x *state, n int, now time.Time) Result {
+	window := l.option.Window
+
+	// rolls the fixed window forward, the previous count is dropped if more
+	// than one window has passed
+	if elapsed := now.Sub(x.start); elapsed >= window {
+		if elapsed >= 2*window {
+			x.prev = 0
+		} else {
+			x.prev = x.count
+		}
+		x.count = 0
+		x.start = x.start.Add(elapsed / window * window)
+	}
+
+	weight := 1 - float64(now.Sub(x.start))/float64(window)
+	used := float64(x.prev)*weight + float64(x.count)
+
+	r := Result{Limit: l.limit}
+	if used+float64(n) <= float64(l.limit) {
+		x.count += n
+		used += float64(n)
+		r.Allowed = true
+	} else {
+		// the weighted previous count decays linearly, the request fits once
+		// enough of it has decayed or, failing that, roughly when the next
+		// window starts
+		r.RetryAfter = x.start.Add(window).Sub(now)
+		if x.prev > 0 {
+			need := used + float64(n) - float64(l.limit)
+			d := time.Duration(need / float64(x.prev) * float64(window))
+			if d < r.RetryAfter && float64(x.count+n) <= float64(l.limit) {
+				r.RetryAfter = d
+			}
+		}
+	}
+	r.Remaining = int(math.Max(0, math.Floor(float64(l.limit)-used)))
+
+	// the current count weighs on the next window as well
+	switch {
+	case x.count > 0:
+		r.Reset = x.start.Add(2 * window).Sub(now)
+	case x.prev > 0:
+		r.Reset = x.start.Add(window).Sub(now)
+	}
+	return r
+}
+
+// Reset forgets the state of the key
+func (l *Limiter) Reset(key string) {
+	s := l.shardOf(key)
+	s.Lock()
+	defer s.Unlock()
+	if e, ok := s.entry[key]; ok {
+		s.lru.Remove(e)
+		delete(s.entry, key)
+	}
+}
+
+func seconds(v float64) time.Duration {
+	return time.Duration(math.Ceil(v * float64(time.Second)))
+}
+
+// limiters are registered by name so the services of a process share them
+var (
+	registryLock sync.Mutex
+	registry     = make(map[string]*Limiter)
+)
+
+// Register stores the limiter under the name unless one is registered already,
+// the registered one is returned
+func Register(name string, l *Limiter) *Limiter {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if x, ok := registry[name]; ok {
+		return x
+	}
+	registry[name] = l
+	return l
+}
+
+func Find(name string) *Limiter {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	return registry[name]
+}
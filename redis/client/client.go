@@ -0,0 +1,327 @@
+package client
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pooled client of an external redis server. The client is safe for
+// concurrent use, each command or pipeline takes a connection from the pool
+// exclusively and puts it back once the replies are read
+
+// Backend is what the commands are sent to, the client of a server or the
+// in-memory keyspace of the redis vhost. The reply is string, Status, int64,
+// nil, Error or []interface{}
+type Backend interface {
+	Addr() string
+	Pipeline(cmds [][]string) ([]interface{}, error)
+	Do(args ...string) (interface{}, error)
+	Close()
+}
+
+type Option struct {
+	Username string
+	Password string
+	DB       int
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// max number of connections, and how long to wait for one when all of
+	// them are in use
+	PoolSize    int
+	PoolTimeout time.Duration
+
+	// idle connection older than IdleTimeout is closed instead of reused
+	IdleTimeout time.Duration
+
+	TLS                bool
+	InsecureSkipVerify bool
+}
+
+const (
+	defaultPoolSize    = 10
+	defaultDialTimeout = 5 * time.Second
+	defaultPoolTimeout = 5 * time.Second
+	defaultIdleTimeout = 5 * time.Minute
+)
+
+type conn struct {
+	c        net.Conn
+	r        *bufio.Reader
+	w        *bufio.Writer
+	lastUsed time.Time
+}
+
+type Client struct {
+	addr   string
+	option Option
+
+	idle  chan *conn
+	token chan struct{}
+
+	closeLock sync.Mutex
+	closed    bool
+}
+
+// ParseURL parses redis://[user:password@]host:port[/db], the rediss scheme
+// enables TLS. The option is updated by the url and the address is returned
+func ParseURL(raw string, option *Option) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		option.TLS = true
+	default:
+		return "", fmt.Errorf("redisclient: unsupported scheme %s", u.Scheme)
+	}
+	if u.User != nil {
+		if p, ok := u.User.Password(); ok {
+			option.Username = u.User.Username()
+			option.Password = p
+		} else {
+			option.Password = u.User.Username()
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil {
+			return "", fmt.Errorf("redisclient: invalid db %s", db)
+		}
+		option.DB = n
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	return host, nil
+}
+
+// NewClient creates the client of the address, which is host:port or a redis
+// url, see ParseURL. No connection is made until the first command
+func NewClient(addr string, option *Option) (*Client, error) {
+	o := Option{}
+	if option != nil {
+		o = *option
+	}
+	if strings.Contains(addr, "://") {
+		a, err := ParseURL(addr, &o)
+		if err != nil {
+			return nil, err
+		}
+		addr = a
+	}
+	if o.PoolSize <= 0 {
+		o.PoolSize = defaultPoolSize
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = defaultDialTimeout
+	}
+	if o.PoolTimeout <= 0 {
+		o.PoolTimeout = defaultPoolTimeout
+	}
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = defaultIdleTimeout
+	}
+
+	return &Client{
+		addr:   addr,
+		option: o,
+		idle:   make(chan *conn, o.PoolSize),
+		token:  make(chan struct{}, o.PoolSize),
+	}, nil
+}
+
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+func (c *Client) dial() (*conn, error) {
+	d := &net.Dialer{Timeout: c.option.DialTimeout}
+	var nc net.Conn
+	var err error
+	if c.option.TLS {
+		host, _, _ := net.SplitHostPort(c.addr)
+		nc, err = tls.DialWithDialer(d, "tcp", c.addr, &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: c.option.InsecureSkipVerify,
+		})
+	} else {
+		nc, err = d.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cn := &conn{
+		c: nc,
+		r: bufio.NewReader(nc),
+		w: bufio.NewWriter(nc),
+	}
+
+	// authenticates and selects the db once per connection
+	init := [][]string{}
+	if c.option.Password != "" {
+		if c.option.Username != "" {
+			init = append(init, []string{"AUTH", c.option.Username, c.option.Password})
+		} else {
+			init = append(init, []string{"AUTH", c.option.Password})
+		}
+	}
+	if c.option.DB != 0 {
+		init = append(init, []string{"SELECT", strconv.Itoa(c.option.DB)})
+	}
+	if len(init) != 0 {
+		replies, err := c.roundTrip(cn, init)
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+		for _, r := range replies {
+			if e, ok := r.(Error); ok {
+				nc.Close()
+				return nil, e
+			}
+		}
+	}
+	return cn, nil
+}
+
+func (c *Client) get() (*conn, error) {
+	select {
+	case c.token <- struct{}{}:
+	case <-time.After(c.option.PoolTimeout):
+		return nil, fmt.Errorf("redisclient: pool timeout, all connections are in use")
+	}
+
+	for {
+		select {
+		case cn := <-c.idle:
+			if time.Since(cn.lastUsed) > c.option.IdleTimeout {
+				cn.c.Close()
+				continue
+			}
+			return cn, nil
+		default:
+			cn, err := c.dial()
+			if err != nil {
+				<-c.token
+				return nil, err
+			}
+			return cn, nil
+		}
+	}
+}
+
+// puts the connection back, the broken one is closed
+func (c *Client) put(cn *conn, broken bool) {
+	defer func() { <-c.token }()
+
+	c.closeLock.Lock()
+	closed := c.closed
+	c.closeLock.Unlock()
+
+	if broken || closed {
+		cn.c.Close()
+		return
+	}
+	cn.lastUsed = time.Now()
+	select {
+	case c.idle <- cn:
+	default:
+		cn.c.Close()
+	}
+}
+
+func (c *Client) roundTrip(cn *conn, cmds [][]string) ([]interface{}, error) {
+	if c.option.WriteTimeout > 0 {
+		cn.c.SetWriteDeadline(time.Now().Add(c.option.WriteTimeout))
+	} else {
+		cn.c.SetWriteDeadline(time.Time{})
+	}
+	for _, cmd := range cmds {
+		if err := writeCommand(cn.w, cmd); err != nil {
+			return nil, err
+		}
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	if c.option.ReadTimeout > 0 {
+		cn.c.SetReadDeadline(time.Now().Add(c.option.ReadTimeout))
+	} else {
+		cn.c.SetReadDeadline(time.Time{})
+	}
+	o := make([]interface{}, 0, len(cmds))
+	for range cmds {
+		v, err := readReply(cn.r)
+		if err != nil {
+			return nil, err
+		}
+		o = append(o, v)
+	}
+	return o, nil
+}
+
+// Pipeline sends all the commands in one write and reads all the replies, the
+// error reply is kept as Error in the result
+func (c *Client) Pipeline(cmds [][]string) ([]interface{}, error) {
+	if len(cmds) == 0 {
+		return []interface{}{}, nil
+	}
+	c.closeLock.Lock()
+	closed := c.closed
+	c.closeLock.Unlock()
+	if closed {
+		return nil, fmt.Errorf("redisclient: client is closed")
+	}
+
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	o, err := c.roundTrip(cn, cmds)
+	c.put(cn, err != nil)
+	return o, err
+}
+
+// Do sends the command and returns its reply, the error reply is returned as
+// Error
+func (c *Client) Do(args ...string) (interface{}, error) {
+	o, err := c.Pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := o[0].(Error); ok {
+		return nil, e
+	}
+	return o[0], nil
+}
+
+// Close closes the idle connections, the in use connections are closed once
+// they are put back
+func (c *Client) Close() {
+	c.closeLock.Lock()
+	c.closed = true
+	c.closeLock.Unlock()
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.c.Close()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"sync"
+)
+
+// Pool of the clients keyed by address, the client is created on the first
+// use of its address with the option of the pool. The address is host:port or
+// a redis url, so the credential and db are per address
+
+type Pool struct {
+	option Option
+
+	sync.Mutex
+	client map[string]*Client
+	closed bool
+}
+
+func NewPool(option *Option) *Pool {
+	p := &Pool{
+		client: make(map[string]*Client),
+	}
+	if option != nil {
+		p.option = *option
+	}
+	return p
+}
+
+// Get returns the client of the address, the same client is returned for the
+// same address until the pool is closed
+func (p *Pool) Get(addr string) (*Client, error) {
+	p.Lock()
+	defer p.Unlock()
+	if c, ok := p.client[addr]; ok {
+		return c, nil
+	}
+	c, err := NewClient(addr, &p.option)
+	if err != nil {
+		return nil, err
+	}
+	if p.closed {
+		c.Close()
+	} else {
+		p.client[addr] = c
+	}
+	return c, nil
+}
+
+// Addrs returns the address of the clients created
+func (p *Pool) Addrs() []string {
+	p.Lock()
+	defer p.Unlock()
+	o := make([]string, 0, len(p.client))
+	for k := range p.client {
+		o = append(o, k)
+	}
+	return o
+}
+
+// Close closes all the clients, the client got after it is closed already
+func (p *Pool) Close() {
+	p.Lock()
+	defer p.Unlock()
+	p.closed = true
+	for _, c := range p.client {
+		c.Close()
+	}
+	p.client = make(map[string]*Client)
+}
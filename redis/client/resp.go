@@ -0,0 +1,119 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// RESP2 encoding of command and decoding of reply
+
+// Error is the error reply of the server, unlike the connection error the
+// connection is still usable after it
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Status is the simple string reply, ie OK, kept apart from the bulk string so
+// the reply is relayed as it is
+type Status string
+
+const maxBulkSize = 512 << 20
+
+func writeCommand(w *bufio.Writer, args []string) error {
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(len(args)))
+	w.WriteString("\r\n")
+	for _, a := range args {
+		w.WriteByte('$')
+		w.WriteString(strconv.Itoa(len(a)))
+		w.WriteString("\r\n")
+		w.WriteString(a)
+		if _, err := w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redisclient: malformed reply line")
+	}
+	return line[:len(line)-2], nil
+}
+
+// reads one reply, the value is string, Status, int64, nil, Error or
+// []interface{}
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, fmt.Errorf("redisclient: empty reply line")
+	}
+
+	switch line[0] {
+	case '+':
+		return Status(line[1:]), nil
+
+	case '-':
+		return Error(line[1:]), nil
+
+	case ':':
+		v, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redisclient: malformed integer reply")
+		}
+		return v, nil
+
+	case '$':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil || n > maxBulkSize {
+			return nil, fmt.Errorf("redisclient: malformed bulk reply")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		if b[n] != '\r' || b[n+1] != '\n' {
+			return nil, fmt.Errorf("redisclient: malformed bulk reply")
+		}
+		return string(b[:n]), nil
+
+	case '*':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil || n > maxBulkSize {
+			return nil, fmt.Errorf("redisclient: malformed array reply")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		o := make([]interface{}, 0, n)
+		for i := int64(0); i < n; i++ {
+			v, err := readReply(r)
+			if err != nil {
+				return nil, err
+			}
+			o = append(o, v)
+		}
+		return o, nil
+
+	default:
+		return nil, fmt.Errorf("redisclient: unknown reply type %q", line[0])
+	}
+}
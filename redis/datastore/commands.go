@@ -0,0 +1,998 @@
+package datastore
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dianpeng/moons/redis/client"
+	"github.com/dianpeng/moons/util"
+)
+
+// commands of the store. The arity counts the command name as redis does,
+// the negative one is the minimum number of arguments. The function gets the
+// arguments without the name and returns the reply
+
+type command struct {
+	arity int
+	fn    func(*Store, []string) interface{}
+}
+
+var commandTable map[string]command
+
+func init() {
+	commandTable = map[string]command{
+		// connection and keys
+		"PING":     {-1, cmdPing},
+		"ECHO":     {2, cmdEcho},
+		"DEL":      {-2, cmdDel},
+		"UNLINK":   {-2, cmdDel},
+		"EXISTS":   {-2, cmdExists},
+		"EXPIRE":   {3, cmdExpire},
+		"PEXPIRE":  {3, cmdPExpire},
+		"TTL":      {2, cmdTTL},
+		"PTTL":     {2, cmdPTTL},
+		"PERSIST":  {2, cmdPersist},
+		"TYPE":     {2, cmdType},
+		"KEYS":     {2, cmdKeys},
+		"RENAME":   {3, cmdRename},
+		"DBSIZE":   {1, cmdDBSize},
+		"FLUSHDB":  {-1, cmdFlush},
+		"FLUSHALL": {-1, cmdFlush},
+
+		// string
+		"GET":    {2, cmdGet},
+		"SET":    {-3, cmdSet},
+		"SETNX":  {3, cmdSetNX},
+		"SETEX":  {4, cmdSetEX},
+		"PSETEX": {4, cmdPSetEX},
+		"GETSET": {3, cmdGetSet},
+		"GETDEL": {2, cmdGetDel},
+		"MGET":   {-2, cmdMGet},
+		"MSET":   {-3, cmdMSet},
+		"INCR":   {2, cmdIncr},
+		"DECR":   {2, cmdDecr},
+		"INCRBY": {3, cmdIncrBy},
+		"DECRBY": {3, cmdDecrBy},
+		"APPEND": {3, cmdAppend},
+		"STRLEN": {2, cmdStrlen},
+
+		// hash
+		"HSET":    {-4, cmdHSet},
+		"HMSET":   {-4, cmdHMSet},
+		"HSETNX":  {4, cmdHSetNX},
+		"HGET":    {3, cmdHGet},
+		"HMGET":   {-3, cmdHMGet},
+		"HDEL":    {-3, cmdHDel},
+		"HEXISTS": {3, cmdHExists},
+		"HLEN":    {2, cmdHLen},
+		"HKEYS":   {2, cmdHKeys},
+		"HVALS":   {2, cmdHVals},
+		"HGETALL": {2, cmdHGetAll},
+		"HINCRBY": {4, cmdHIncrBy},
+
+		// list
+		"LPUSH":  {-3, cmdLPush},
+		"RPUSH":  {-3, cmdRPush},
+		"LPOP":   {2, cmdLPop},
+		"RPOP":   {2, cmdRPop},
+		"LLEN":   {2, cmdLLen},
+		"LRANGE": {4, cmdLRange},
+		"LINDEX": {3, cmdLIndex},
+		"LSET":   {4, cmdLSet},
+		"LTRIM":  {4, cmdLTrim},
+
+		// set
+		"SADD":      {-3, cmdSAdd},
+		"SREM":      {-3, cmdSRem},
+		"SMEMBERS":  {2, cmdSMembers},
+		"SISMEMBER": {3, cmdSIsMember},
+		"SCARD":     {2, cmdSCard},
+		"SINTER":    {-2, cmdSInter},
+		"SUNION":    {-2, cmdSUnion},
+	}
+}
+
+var replyOK = client.Status("OK")
+
+func parseInt(x string) (int64, bool) {
+	v, err := strconv.ParseInt(x, 10, 64)
+	return v, err == nil
+}
+
+// v+by, false if it overflows
+func addInt(v, by int64) (int64, bool) {
+	if (by > 0 && v > math.MaxInt64-by) || (by < 0 && v < math.MinInt64-by) {
+		return 0, false
+	}
+	return v + by, true
+}
+
+// expire time of the unit as duration, false if it overflows
+func toDuration(v int64, unit time.Duration) (time.Duration, bool) {
+	max := int64(math.MaxInt64 / unit)
+	if v > max || v < -max {
+		return 0, false
+	}
+	return time.Duration(v) * unit, true
+}
+
+// -----------------------------------------------------------------------------
+// connection and keys
+
+func cmdPing(_ *Store, args []string) interface{} {
+	switch len(args) {
+	case 0:
+		return client.Status("PONG")
+	case 1:
+		return args[0]
+	default:
+		return client.Error("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func cmdEcho(_ *Store, args []string) interface{} {
+	return args[0]
+}
+
+func cmdDel(s *Store, args []string) interface{} {
+	n := int64(0)
+	for _, k := range args {
+		if s.lookup(k) != nil {
+			s.remove(k)
+			n++
+		}
+	}
+	return n
+}
+
+func cmdExists(s *Store, args []string) interface{} {
+	n := int64(0)
+	for _, k := range args {
+		if s.lookup(k) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Store) setExpire(key string, d time.Duration) interface{} {
+	if s.lookup(key) == nil {
+		return int64(0)
+	}
+	if d <= 0 {
+		s.remove(key)
+	} else {
+		s.expire[key] = s.now.Add(d)
+	}
+	return int64(1)
+}
+
+func cmdExpire(s *Store, args []string) interface{} {
+	v, ok := parseInt(args[1])
+	if !ok {
+		return client.Error(errNotInt)
+	}
+	d, ok := toDuration(v, time.Second)
+	if !ok {
+		return client.Error(errExpire)
+	}
+	return s.setExpire(args[0], d)
+}
+
+func cmdPExpire(s *Store, args []string) interface{} {
+	v, ok := parseInt(args[1])
+	if !ok {
+		return client.Error(errNotInt)
+	}
+	d, ok := toDuration(v, time.Millisecond)
+	if !ok {
+		return client.Error(errExpire)
+	}
+	return s.setExpire(args[0], d)
+}
+
+func (s *Store) ttl(key string, unit time.Duration) interface{} {
+	if s.lookup(key) == nil {
+		return int64(-2)
+	}
+	t, ok := s.expire[key]
+	if !ok {
+		return int64(-1)
+	}
+	d := t.Sub(s.now)
+	return int64((d + unit - 1) / unit)
+}
+
+func cmdTTL(s *Store, args []string) interface{} {
+	return s.ttl(args[0], time.Second)
+}
+
+func cmdPTTL(s *Store, args []string) interface{} {
+	return s.ttl(args[0], time.Millisecond)
+}
+
+func cmdPersist(s *Store, args []string) interface{} {
+	if s.lookup(args[0]) == nil {
+		return int64(0)
+	}
+	if _, ok := s.expire[args[0]]; !ok {
+		return int64(0)
+	}
+	delete(s.expire, args[0])
+	return int64(1)
+}
+
+func cmdType(s *Store, args []string) interface{} {
+	e := s.lookup(args[0])
+	if e == nil {
+		return client.Status("none")
+	}
+	return client.Status(e.kind)
+}
+
+func cmdKeys(s *Store, args []string) interface{} {
+	// the malformed pattern, ie unclosed [, only matches itself
+	g, err := util.CompileGlobSeparator(args[0], util.GlobNoSeparator)
+	match := func(k string) bool {
+		if err != nil {
+			return k == args[0]
+		}
+		return g.Match(k)
+	}
+
+	o := []string{}
+	for k := range s.data {
+		if s.expired(k) {
+			s.remove(k)
+			continue
+		}
+		if match(k) {
+			o = append(o, k)
+		}
+	}
+	sort.Strings(o)
+	return stringList(o)
+}
+
+func cmdRename(s *Store, args []string) interface{} {
+	e := s.lookup(args[0])
+	if e == nil {
+		return client.Error(errNoKey)
+	}
+	t, ok := s.expire[args[0]]
+	s.remove(args[0])
+	s.remove(args[1])
+	s.data[args[1]] = e
+	if ok {
+		s.expire[args[1]] = t
+	}
+	return replyOK
+}
+
+func cmdDBSize(s *Store, _ []string) interface{} {
+	return int64(len(s.data))
+}
+
+func cmdFlush(s *Store, _ []string) interface{} {
+	s.data = make(map[string]*entry)
+	s.expire = make(map[string]time.Time)
+	return replyOK
+}
+
+// -----------------------------------------------------------------------------
+// string
+
+func cmdGet(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindString)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return nil
+	}
+	return e.str
+}
+
+// SET key value [EX seconds|PX milliseconds|KEEPTTL] [NX|XX] [GET]
+func cmdSet(s *Store, args []string) interface{} {
+	key, value := args[0], args[1]
+	var ttl time.Duration
+	keepTTL, nx, xx, get := false, false, false, false
+
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "EX", "PX":
+			if i+1 == len(args) || ttl != 0 || keepTTL {
+				return client.Error(errSyntax)
+			}
+			v, ok := parseInt(args[i+1])
+			if !ok {
+				return client.Error(errNotInt)
+			}
+			unit := time.Millisecond
+			if strings.ToUpper(args[i]) == "EX" {
+				unit = time.Second
+			}
+			if ttl, ok = toDuration(v, unit); !ok || v <= 0 {
+				return client.Error(errExpire)
+			}
+			i++
+		case "KEEPTTL":
+			keepTTL = true
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GET":
+			get = true
+		default:
+			return client.Error(errSyntax)
+		}
+	}
+	if (nx && xx) || (keepTTL && ttl != 0) {
+		return client.Error(errSyntax)
+	}
+
+	var old interface{}
+	e := s.lookup(key)
+	if e != nil && get {
+		if e.kind != kindString {
+			return client.Error(errWrongType)
+		}
+		old = e.str
+	}
+	if (nx && e != nil) || (xx && e == nil) {
+		if get {
+			return old
+		}
+		return nil
+	}
+
+	if e == nil || e.kind != kindString {
+		if e == nil && s.maxKeys > 0 && len(s.data) >= s.maxKeys {
+			return client.Error(errFull)
+		}
+		s.data[key] = &entry{kind: kindString}
+	}
+	s.data[key].str = value
+	if ttl != 0 {
+		s.expire[key] = s.now.Add(ttl)
+	} else if !keepTTL {
+		delete(s.expire, key)
+	}
+
+	if get {
+		return old
+	}
+	return replyOK
+}
+
+func cmdSetNX(s *Store, args []string) interface{} {
+	if r := cmdSet(s, []string{args[0], args[1], "NX"}); r == nil {
+		return int64(0)
+	} else if e, ok := r.(client.Error); ok {
+		return e
+	}
+	return int64(1)
+}
+
+func cmdSetEX(s *Store, args []string) interface{} {
+	return cmdSet(s, []string{args[0], args[2], "EX", args[1]})
+}
+
+func cmdPSetEX(s *Store, args []string) interface{} {
+	return cmdSet(s, []string{args[0], args[2], "PX", args[1]})
+}
+
+func cmdGetSet(s *Store, args []string) interface{} {
+	return cmdSet(s, []string{args[0], args[1], "GET"})
+}
+
+func cmdGetDel(s *Store, args []string) interface{} {
+	r := cmdGet(s, args)
+	if _, ok := r.(string); ok {
+		s.remove(args[0])
+	}
+	return r
+}
+
+func cmdMGet(s *Store, args []string) interface{} {
+	o := make([]interface{}, 0, len(args))
+	for _, k := range args {
+		e := s.lookup(k)
+		if e == nil || e.kind != kindString {
+			o = append(o, nil)
+		} else {
+			o = append(o, e.str)
+		}
+	}
+	return o
+}
+
+func cmdMSet(s *Store, args []string) interface{} {
+	if len(args)%2 != 0 {
+		return client.Error("ERR wrong number of arguments for 'mset' command")
+	}
+	for i := 0; i < len(args); i += 2 {
+		if r, ok := cmdSet(s, args[i:i+2]).(client.Error); ok {
+			return r
+		}
+	}
+	return replyOK
+}
+
+func (s *Store) incrBy(key string, by int64) interface{} {
+	e, err := s.lookupKind(key, kindString)
+	if err != nil {
+		return err
+	}
+	v := int64(0)
+	if e != nil {
+		var ok bool
+		if v, ok = parseInt(e.str); !ok {
+			return client.Error(errNotInt)
+		}
+	}
+	v, ok := addInt(v, by)
+	if !ok {
+		return client.Error(errOverflow)
+	}
+	if e == nil {
+		if e, err = s.lookupOrCreate(key, kindString); err != nil {
+			return err
+		}
+	}
+	e.str = strconv.FormatInt(v, 10)
+	return v
+}
+
+func cmdIncr(s *Store, args []string) interface{} {
+	return s.incrBy(args[0], 1)
+}
+
+func cmdDecr(s *Store, args []string) interface{} {
+	return s.incrBy(args[0], -1)
+}
+
+func cmdIncrBy(s *Store, args []string) interface{} {
+	v, ok := parseInt(args[1])
+	if !ok {
+		return client.Error(errNotInt)
+	}
+	return s.incrBy(args[0], v)
+}
+
+func cmdDecrBy(s *Store, args []string) interface{} {
+	v, ok := parseInt(args[1])
+	if !ok || v == math.MinInt64 {
+		return client.Error(errNotInt)
+	}
+	return s.incrBy(args[0], -v)
+}
+
+func cmdAppend(s *Store, args []string) interface{} {
+	e, err := s.lookupOrCreate(args[0], kindString)
+	if err != nil {
+		return err
+	}
+	e.str += args[1]
+	return int64(len(e.str))
+}
+
+func cmdStrlen(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindString)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return int64(0)
+	}
+	return int64(len(e.str))
+}
+
+// -----------------------------------------------------------------------------
+// hash
+
+func cmdHSet(s *Store, args []string) interface{} {
+	if len(args)%2 != 1 {
+		return client.Error("ERR wrong number of arguments for 'hset' command")
+	}
+	e, err := s.lookupOrCreate(args[0], kindHash)
+	if err != nil {
+		return err
+	}
+	n := int64(0)
+	for i := 1; i < len(args); i += 2 {
+		if _, ok := e.hash[args[i]]; !ok {
+			n++
+		}
+		e.hash[args[i]] = args[i+1]
+	}
+	return n
+}
+
+func cmdHMSet(s *Store, args []string) interface{} {
+	if r, ok := cmdHSet(s, args).(client.Error); ok {
+		return r
+	}
+	return replyOK
+}
+
+func cmdHSetNX(s *Store, args []string) interface{} {
+	e, err := s.lookupOrCreate(args[0], kindHash)
+	if err != nil {
+		return err
+	}
+	if _, ok := e.hash[args[1]]; ok {
+		return int64(0)
+	}
+	e.hash[args[1]] = args[2]
+	return int64(1)
+}
+
+func cmdHGet(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindHash)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return nil
+	}
+	if v, ok := e.hash[args[1]]; ok {
+		return v
+	}
+	return nil
+}
+
+func cmdHMGet(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindHash)
+	if err != nil {
+		return err
+	}
+	o := make([]interface{}, 0, len(args)-1)
+	for _, f := range args[1:] {
+		if e == nil {
+			o = append(o, nil)
+		} else if v, ok := e.hash[f]; ok {
+			o = append(o, v)
+		} else {
+			o = append(o, nil)
+		}
+	}
+	return o
+}
+
+func cmdHDel(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindHash)
+	if err != nil || e == nil {
+		if err != nil {
+			return err
+		}
+		return int64(0)
+	}
+	n := int64(0)
+	for _, f := range args[1:] {
+		if _, ok := e.hash[f]; ok {
+			delete(e.hash, f)
+			n++
+		}
+	}
+	s.dropEmpty(args[0], e)
+	return n
+}
+
+func cmdHExists(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindHash)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return int64(0)
+	}
+	if _, ok := e.hash[args[1]]; ok {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+func cmdHLen(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindHash)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return int64(0)
+	}
+	return int64(len(e.hash))
+}
+
+// fields of the hash in order, so the reply is stable
+func (e *entry) fields() []string {
+	o := make([]string, 0, len(e.hash))
+	for k := range e.hash {
+		o = append(o, k)
+	}
+	sort.Strings(o)
+	return o
+}
+
+func cmdHKeys(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindHash)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return []interface{}{}
+	}
+	return stringList(e.fields())
+}
+
+func cmdHVals(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindHash)
+	if err != nil {
+		return err
+	}
+	o := []interface{}{}
+	if e != nil {
+		for _, k := range e.fields() {
+			o = append(o, e.hash[k])
+		}
+	}
+	return o
+}
+
+func cmdHGetAll(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindHash)
+	if err != nil {
+		return err
+	}
+	o := []interface{}{}
+	if e != nil {
+		for _, k := range e.fields() {
+			o = append(o, k, e.hash[k])
+		}
+	}
+	return o
+}
+
+func cmdHIncrBy(s *Store, args []string) interface{} {
+	by, ok := parseInt(args[2])
+	if !ok {
+		return client.Error(errNotInt)
+	}
+	e, err := s.lookupOrCreate(args[0], kindHash)
+	if err != nil {
+		return err
+	}
+	v := int64(0)
+	if x, ok := e.hash[args[1]]; ok {
+		if v, ok = parseInt(x); !ok {
+			return client.Error("ERR hash value is not an integer")
+		}
+	}
+	if v, ok = addInt(v, by); !ok {
+		return client.Error(errOverflow)
+	}
+	e.hash[args[1]] = strconv.FormatInt(v, 10)
+	return v
+}
+
+// -----------------------------------------------------------------------------
+// list
+
+func cmdLPush(s *Store, args []string) interface{} {
+	e, err := s.lookupOrCreate(args[0], kindList)
+	if err != nil {
+		return err
+	}
+	for _, v := range args[1:] {
+		e.list = append([]string{v}, e.list...)
+	}
+	return int64(len(e.list))
+}
+
+func cmdRPush(s *Store, args []string) interface{} {
+	e, err := s.lookupOrCreate(args[0], kindList)
+	if err != nil {
+		return err
+	}
+	e.list = append(e.list, args[1:]...)
+	return int64(len(e.list))
+}
+
+func (s *Store) pop(key string, left bool) interface{} {
+	e, err := s.lookupKind(key, kindList)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return nil
+	}
+	var v string
+	if left {
+		v, e.list = e.list[0], e.list[1:]
+	} else {
+		v, e.list = e.list[len(e.list)-1], e.list[:len(e.list)-1]
+	}
+	s.dropEmpty(key, e)
+	return v
+}
+
+func cmdLPop(s *Store, args []string) interface{} {
+	return s.pop(args[0], true)
+}
+
+func cmdRPop(s *Store, args []string) interface{} {
+	return s.pop(args[0], false)
+}
+
+func cmdLLen(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindList)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return int64(0)
+	}
+	return int64(len(e.list))
+}
+
+// resolves the redis style range, the negative index counts from the end,
+// returns the empty range as start > stop
+func listRange(n int, start, stop int64) (int, int) {
+	if start < 0 {
+		start += int64(n)
+	}
+	if stop < 0 {
+		stop += int64(n)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= int64(n) {
+		stop = int64(n) - 1
+	}
+	return int(start), int(stop)
+}
+
+func cmdLRange(s *Store, args []string) interface{} {
+	start, ok1 := parseInt(args[1])
+	stop, ok2 := parseInt(args[2])
+	if !ok1 || !ok2 {
+		return client.Error(errNotInt)
+	}
+	e, err := s.lookupKind(args[0], kindList)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return []interface{}{}
+	}
+	i, j := listRange(len(e.list), start, stop)
+	if i > j {
+		return []interface{}{}
+	}
+	return stringList(e.list[i : j+1])
+}
+
+func (e *entry) listIndex(x string) (int, interface{}) {
+	v, ok := parseInt(x)
+	if !ok {
+		return 0, client.Error(errNotInt)
+	}
+	if v < 0 {
+		v += int64(len(e.list))
+	}
+	if v < 0 || v >= int64(len(e.list)) {
+		return -1, nil
+	}
+	return int(v), nil
+}
+
+func cmdLIndex(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindList)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return nil
+	}
+	i, err := e.listIndex(args[1])
+	if err != nil {
+		return err
+	}
+	if i < 0 {
+		return nil
+	}
+	return e.list[i]
+}
+
+func cmdLSet(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindList)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return client.Error(errNoKey)
+	}
+	i, err := e.listIndex(args[1])
+	if err != nil {
+		return err
+	}
+	if i < 0 {
+		return client.Error("ERR index out of range")
+	}
+	e.list[i] = args[2]
+	return replyOK
+}
+
+func cmdLTrim(s *Store, args []string) interface{} {
+	start, ok1 := parseInt(args[1])
+	stop, ok2 := parseInt(args[2])
+	if !ok1 || !ok2 {
+		return client.Error(errNotInt)
+	}
+	e, err := s.lookupKind(args[0], kindList)
+	if err != nil || e == nil {
+		if err != nil {
+			return err
+		}
+		return replyOK
+	}
+	i, j := listRange(len(e.list), start, stop)
+	if i > j {
+		e.list = nil
+	} else {
+		e.list = append([]string{}, e.list[i:j+1]...)
+	}
+	s.dropEmpty(args[0], e)
+	return replyOK
+}
+
+// -----------------------------------------------------------------------------
+// set
+
+func cmdSAdd(s *Store, args []string) interface{} {
+	e, err := s.lookupOrCreate(args[0], kindSet)
+	if err != nil {
+		return err
+	}
+	n := int64(0)
+	for _, m := range args[1:] {
+		if _, ok := e.set[m]; !ok {
+			e.set[m] = struct{}{}
+			n++
+		}
+	}
+	return n
+}
+
+func cmdSRem(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindSet)
+	if err != nil || e == nil {
+		if err != nil {
+			return err
+		}
+		return int64(0)
+	}
+	n := int64(0)
+	for _, m := range args[1:] {
+		if _, ok := e.set[m]; ok {
+			delete(e.set, m)
+			n++
+		}
+	}
+	s.dropEmpty(args[0], e)
+	return n
+}
+
+// members of the set in order, so the reply is stable
+func members(set map[string]struct{}) []interface{} {
+	o := make([]string, 0, len(set))
+	for k := range set {
+		o = append(o, k)
+	}
+	sort.Strings(o)
+	return stringList(o)
+}
+
+func cmdSMembers(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindSet)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return []interface{}{}
+	}
+	return members(e.set)
+}
+
+func cmdSIsMember(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindSet)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return int64(0)
+	}
+	if _, ok := e.set[args[1]]; ok {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+func cmdSCard(s *Store, args []string) interface{} {
+	e, err := s.lookupKind(args[0], kindSet)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return int64(0)
+	}
+	return int64(len(e.set))
+}
+
+// sets of the keys, the key not existed is the empty set
+func (s *Store) sets(keys []string) ([]map[string]struct{}, interface{}) {
+	o := make([]map[string]struct{}, 0, len(keys))
+	for _, k := range keys {
+		e, err := s.lookupKind(k, kindSet)
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			o = append(o, map[string]struct{}{})
+		} else {
+			o = append(o, e.set)
+		}
+	}
+	return o, nil
+}
+
+func cmdSInter(s *Store, args []string) interface{} {
+	sets, err := s.sets(args)
+	if err != nil {
+		return err
+	}
+	o := make(map[string]struct{})
+	for m := range sets[0] {
+		all := true
+		for _, x := range sets[1:] {
+			if _, ok := x[m]; !ok {
+				all = false
+				break
+			}
+		}
+		if all {
+			o[m] = struct{}{}
+		}
+	}
+	return members(o)
+}
+
+func cmdSUnion(s *Store, args []string) interface{} {
+	sets, err := s.sets(args)
+	if err != nil {
+		return err
+	}
+	o := make(map[string]struct{})
+	for _, x := range sets {
+		for m := range x {
+			o[m] = struct{}{}
+		}
+	}
+	return members(o)
+}
+
+// -----------------------------------------------------------------------------
+
+func stringList(x []string) []interface{} {
+	o := make([]interface{}, 0, len(x))
+	for _, v := range x {
+		o = append(o, v)
+	}
+	return o
+}
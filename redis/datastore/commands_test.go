@@ -0,0 +1,182 @@
+package datastore
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dianpeng/moons/redis/client"
+	"github.com/stretchr/testify/assert"
+)
+
+type cmdCase struct {
+	cmd   []string
+	reply interface{}
+}
+
+func runCases(t *testing.T, s *Store, cases []cmdCase) {
+	for _, c := range cases {
+		o, err := s.Pipeline([][]string{c.cmd})
+		assert.Nil(t, err)
+		assert.Equal(t, c.reply, o[0], "%v", c.cmd)
+	}
+}
+
+func TestString(t *testing.T) {
+	runCases(t, newStore("test"), []cmdCase{
+		{[]string{"GET", "a"}, nil},
+		{[]string{"SET", "a", "1"}, replyOK},
+		{[]string{"GET", "a"}, "1"},
+		{[]string{"SET", "a", "2", "NX"}, nil},
+		{[]string{"SET", "b", "2", "XX"}, nil},
+		{[]string{"SET", "a", "2", "GET"}, "1"},
+		{[]string{"SET", "a", "2", "NX", "XX"}, client.Error(errSyntax)},
+		{[]string{"SET", "a", "2", "EX"}, client.Error(errSyntax)},
+		{[]string{"SET", "a", "2", "BOGUS"}, client.Error(errSyntax)},
+		{[]string{"SETNX", "a", "3"}, int64(0)},
+		{[]string{"SETNX", "c", "3"}, int64(1)},
+		{[]string{"GETSET", "c", "4"}, "3"},
+		{[]string{"GETDEL", "c"}, "4"},
+		{[]string{"EXISTS", "a", "c"}, int64(1)},
+		{[]string{"APPEND", "a", "34"}, int64(3)},
+		{[]string{"STRLEN", "a"}, int64(3)},
+		{[]string{"MSET", "x", "1", "y"}, client.Error("ERR wrong number of arguments for 'mset' command")},
+		{[]string{"MSET", "x", "1", "y", "2"}, replyOK},
+		{[]string{"MGET", "x", "nope", "y"}, []interface{}{"1", nil, "2"}},
+		{[]string{"GET"}, client.Error("ERR wrong number of arguments for 'get' command")},
+		{[]string{"NOPE"}, client.Error("ERR unknown command 'NOPE'")},
+	})
+}
+
+func TestIncr(t *testing.T) {
+	max := strconv.FormatInt(9223372036854775807, 10)
+	min := strconv.FormatInt(-9223372036854775808, 10)
+	runCases(t, newStore("test"), []cmdCase{
+		{[]string{"INCR", "a"}, int64(1)},
+		{[]string{"INCRBY", "a", "10"}, int64(11)},
+		{[]string{"DECRBY", "a", "20"}, int64(-9)},
+		{[]string{"DECR", "a"}, int64(-10)},
+		{[]string{"INCRBY", "a", "x"}, client.Error(errNotInt)},
+		{[]string{"DECRBY", "a", min}, client.Error(errNotInt)},
+		{[]string{"SET", "a", max}, replyOK},
+		{[]string{"INCR", "a"}, client.Error(errOverflow)},
+		{[]string{"GET", "a"}, max},
+		{[]string{"INCRBY", "a", min}, int64(-1)},
+		{[]string{"SET", "a", min}, replyOK},
+		{[]string{"DECR", "a"}, client.Error(errOverflow)},
+		{[]string{"SET", "s", "abc"}, replyOK},
+		{[]string{"INCR", "s"}, client.Error(errNotInt)},
+		{[]string{"HSET", "h", "f", "1"}, int64(1)},
+		{[]string{"INCR", "h"}, client.Error(errWrongType)},
+	})
+}
+
+func TestHash(t *testing.T) {
+	max := strconv.FormatInt(9223372036854775807, 10)
+	runCases(t, newStore("test"), []cmdCase{
+		{[]string{"HSET", "h", "a", "1", "b", "2"}, int64(2)},
+		{[]string{"HGET", "h", "a"}, "1"},
+		{[]string{"HGET", "h", "nope"}, nil},
+		{[]string{"HLEN", "h"}, int64(2)},
+		{[]string{"HINCRBY", "h", "a", "5"}, int64(6)},
+		{[]string{"HINCRBY", "h", "c", "-5"}, int64(-5)},
+		{[]string{"HINCRBY", "h", "a", "x"}, client.Error(errNotInt)},
+		{[]string{"HSET", "h", "s", "abc"}, int64(1)},
+		{[]string{"HINCRBY", "h", "s", "1"}, client.Error("ERR hash value is not an integer")},
+		{[]string{"HSET", "h", "m", max}, int64(1)},
+		{[]string{"HINCRBY", "h", "m", "1"}, client.Error(errOverflow)},
+		{[]string{"HGET", "h", "m"}, max},
+		{[]string{"HINCRBY", "h", "c", "-9223372036854775804"}, client.Error(errOverflow)},
+		{[]string{"HDEL", "h", "a", "b", "c", "s", "m"}, int64(5)},
+		{[]string{"EXISTS", "h"}, int64(0)},
+		{[]string{"SET", "k", "v"}, replyOK},
+		{[]string{"HINCRBY", "k", "a", "1"}, client.Error(errWrongType)},
+	})
+}
+
+func TestExpire(t *testing.T) {
+	s := newStore("test")
+	runCases(t, s, []cmdCase{
+		{[]string{"SET", "a", "1", "EX", "0"}, client.Error(errExpire)},
+		{[]string{"SET", "a", "1", "PX", "-1"}, client.Error(errExpire)},
+		{[]string{"SET", "a", "1", "EX", "9223372036854775807"}, client.Error(errExpire)},
+		{[]string{"SET", "a", "1", "PX", "9223372036854775807"}, client.Error(errExpire)},
+		{[]string{"SETEX", "a", "9223372036854775807", "1"}, client.Error(errExpire)},
+		{[]string{"EXISTS", "a"}, int64(0)},
+		{[]string{"SET", "a", "1", "EX", "100"}, replyOK},
+		{[]string{"TTL", "a"}, int64(100)},
+		{[]string{"SET", "a", "1", "EX", "10", "KEEPTTL"}, client.Error(errSyntax)},
+		{[]string{"SET", "a", "2", "KEEPTTL"}, replyOK},
+		{[]string{"TTL", "a"}, int64(100)},
+		{[]string{"EXPIRE", "a", "9223372036854775807"}, client.Error(errExpire)},
+		{[]string{"PEXPIRE", "a", "-9223372036854775807"}, client.Error(errExpire)},
+		{[]string{"TTL", "a"}, int64(100)},
+		{[]string{"PERSIST", "a"}, int64(1)},
+		{[]string{"TTL", "a"}, int64(-1)},
+		{[]string{"TTL", "nope"}, int64(-2)},
+		{[]string{"EXPIRE", "a", "-1"}, int64(1)},
+		{[]string{"EXISTS", "a"}, int64(0)},
+		{[]string{"SET", "b", "1", "PX", "1"}, replyOK},
+	})
+
+	time.Sleep(5 * time.Millisecond)
+	runCases(t, s, []cmdCase{
+		{[]string{"GET", "b"}, nil},
+	})
+}
+
+func TestMulti(t *testing.T) {
+	runCases(t, newStore("test"), []cmdCase{
+		{[]string{"EXEC"}, client.Error("ERR EXEC without MULTI")},
+	})
+
+	s := newStore("test")
+	o, err := s.Pipeline([][]string{
+		{"MULTI"},
+		{"SET", "a", "1"},
+		{"INCR", "a"},
+		{"EXEC"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{
+		client.Status("OK"),
+		client.Status("QUEUED"),
+		client.Status("QUEUED"),
+		[]interface{}{replyOK, int64(2)},
+	}, o)
+
+	o, err = s.Pipeline([][]string{
+		{"MULTI"},
+		{"SET", "a"},
+		{"EXEC"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, client.Error("EXECABORT Transaction discarded because of previous errors."), o[2])
+}
+
+func TestMaxKeys(t *testing.T) {
+	s := newStore("test")
+	s.maxKeys = 1
+	runCases(t, s, []cmdCase{
+		{[]string{"SET", "a", "1"}, replyOK},
+		{[]string{"SET", "a", "2"}, replyOK},
+		{[]string{"SET", "b", "1"}, client.Error(errFull)},
+		{[]string{"HSET", "h", "f", "1"}, client.Error(errFull)},
+	})
+}
+
+func TestKeys(t *testing.T) {
+	long := strings.Repeat("a", 64)
+	runCases(t, newStore("test"), []cmdCase{
+		{[]string{"MSET", "user:1", "a", "user:2", "b", "user/3", "c", "[x", "d"}, replyOK},
+		{[]string{"KEYS", "*"}, []interface{}{"[x", "user/3", "user:1", "user:2"}},
+		{[]string{"KEYS", "user:*"}, []interface{}{"user:1", "user:2"}},
+		{[]string{"KEYS", "user*"}, []interface{}{"user/3", "user:1", "user:2"}},
+		{[]string{"KEYS", "user?[^2]"}, []interface{}{"user/3", "user:1"}},
+		{[]string{"KEYS", "[x"}, []interface{}{"[x"}},
+		{[]string{"SET", long, "1"}, replyOK},
+		// the pattern which backtracks exponentially without memoization
+		{[]string{"KEYS", strings.Repeat("*a", 32) + "b"}, []interface{}{}},
+	})
+}
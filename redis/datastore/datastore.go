@@ -0,0 +1,309 @@
+package datastore
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dianpeng/moons/redis/client"
+)
+
+// In-memory keyspace of the redis vhost, which implements the common string,
+// hash, list and set commands with TTL. The store is a client.Backend, so the
+// script reaches it the same way as a real redis server and the vhost replies
+// the command with it when no rule does.
+//
+// The store is named by the vhost and outlives the reload of the vhost. The
+// commands of one pipeline run under the lock of the store, so MULTI/EXEC of
+// the pipeline is atomic. The expired key is removed when it is touched, and a
+// few of them are swept once in a while as redis does
+
+const (
+	kindString = "string"
+	kindHash   = "hash"
+	kindList   = "list"
+	kindSet    = "set"
+)
+
+const (
+	errWrongType = "WRONGTYPE Operation against a key holding the wrong kind of value"
+	errNotInt    = "ERR value is not an integer or out of range"
+	errSyntax    = "ERR syntax error"
+	errNoKey     = "ERR no such key"
+	errFull      = "OOM keyspace is full, key is not added"
+	errExpire    = "ERR invalid expire time"
+	errOverflow  = "ERR increment or decrement would overflow"
+)
+
+const (
+	sweepInterval = time.Second
+	sweepCount    = 20
+)
+
+type entry struct {
+	kind string
+	str  string
+	hash map[string]string
+	list []string
+	set  map[string]struct{}
+}
+
+type Store struct {
+	name    string
+	maxKeys int
+
+	sync.Mutex
+	data      map[string]*entry
+	expire    map[string]time.Time
+	lastSweep time.Time
+	now       time.Time
+}
+
+var (
+	storeLock sync.Mutex
+	stores    = make(map[string]*Store)
+)
+
+// Get returns the store of the name, created on first use. The max number of
+// keys is updated on each call, zero means unlimited
+func Get(name string, maxKeys int) *Store {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	s, ok := stores[name]
+	if !ok {
+		s = newStore(name)
+		stores[name] = s
+	}
+	s.Lock()
+	s.maxKeys = maxKeys
+	s.Unlock()
+	return s
+}
+
+func newStore(name string) *Store {
+	return &Store{
+		name:   name,
+		data:   make(map[string]*entry),
+		expire: make(map[string]time.Time),
+	}
+}
+
+// Supports returns whether the command is implemented by the store
+func Supports(name string) bool {
+	switch name {
+	case "MULTI", "EXEC", "DISCARD":
+		return true
+	}
+	_, ok := commandTable[name]
+	return ok
+}
+
+func (s *Store) Addr() string {
+	return fmt.Sprintf("keyspace:%s", s.name)
+}
+
+// Close does nothing, the store is kept for the vhost of the same name
+func (s *Store) Close() {
+}
+
+func (s *Store) Len() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.data)
+}
+
+// Pipeline runs the commands in order, the error reply is kept as
+// client.Error in the result. MULTI queues the following commands until EXEC,
+// which replies the array of their replies
+func (s *Store) Pipeline(cmds [][]string) ([]interface{}, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.now = time.Now()
+	s.sweep()
+
+	o := make([]interface{}, 0, len(cmds))
+	var tx [][]string
+	inTx := false
+	aborted := false
+
+	for _, cmd := range cmds {
+		if len(cmd) == 0 {
+			return nil, fmt.Errorf("keyspace: empty command")
+		}
+		name := strings.ToUpper(cmd[0])
+		switch name {
+		case "MULTI":
+			if inTx {
+				o = append(o, client.Error("ERR MULTI calls can not be nested"))
+				continue
+			}
+			inTx, aborted, tx = true, false, nil
+			o = append(o, client.Status("OK"))
+
+		case "DISCARD":
+			if !inTx {
+				o = append(o, client.Error("ERR DISCARD without MULTI"))
+				continue
+			}
+			inTx = false
+			o = append(o, client.Status("OK"))
+
+		case "EXEC":
+			if !inTx {
+				o = append(o, client.Error("ERR EXEC without MULTI"))
+				continue
+			}
+			inTx = false
+			if aborted {
+				o = append(o, client.Error("EXECABORT Transaction discarded because of previous errors."))
+				continue
+			}
+			r := make([]interface{}, 0, len(tx))
+			for _, x := range tx {
+				r = append(r, s.run(x))
+			}
+			o = append(o, r)
+
+		default:
+			if !inTx {
+				o = append(o, s.run(cmd))
+				continue
+			}
+			if e := check(name, cmd); e != nil {
+				aborted = true
+				o = append(o, e)
+				continue
+			}
+			tx = append(tx, cmd)
+			o = append(o, client.Status("QUEUED"))
+		}
+	}
+	return o, nil
+}
+
+// Do runs the command, the error reply is returned as client.Error
+func (s *Store) Do(args ...string) (interface{}, error) {
+	o, err := s.Pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := o[0].(client.Error); ok {
+		return nil, e
+	}
+	return o[0], nil
+}
+
+// checks the name and the number of arguments of the command
+func check(name string, cmd []string) interface{} {
+	c, ok := commandTable[name]
+	if !ok {
+		return client.Error(fmt.Sprintf("ERR unknown command '%s'", cmd[0]))
+	}
+	if (c.arity > 0 && len(cmd) != c.arity) || (c.arity < 0 && len(cmd) < -c.arity) {
+		return client.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+	}
+	return nil
+}
+
+func (s *Store) run(cmd []string) interface{} {
+	name := strings.ToUpper(cmd[0])
+	if e := check(name, cmd); e != nil {
+		return e
+	}
+	return commandTable[name].fn(s, cmd[1:])
+}
+
+// -----------------------------------------------------------------------------
+// keys, the caller holds the lock
+
+func (s *Store) expired(key string) bool {
+	t, ok := s.expire[key]
+	return ok && !s.now.Before(t)
+}
+
+// returns the live entry of the key, the expired one is removed
+func (s *Store) lookup(key string) *entry {
+	e, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+	if s.expired(key) {
+		s.remove(key)
+		return nil
+	}
+	return e
+}
+
+// returns the entry of the kind, nil if not existed, or the WRONGTYPE error
+func (s *Store) lookupKind(key string, kind string) (*entry, interface{}) {
+	e := s.lookup(key)
+	if e == nil {
+		return nil, nil
+	}
+	if e.kind != kind {
+		return nil, client.Error(errWrongType)
+	}
+	return e, nil
+}
+
+// returns the entry of the kind, created if not existed
+func (s *Store) lookupOrCreate(key string, kind string) (*entry, interface{}) {
+	e, err := s.lookupKind(key, kind)
+	if err != nil || e != nil {
+		return e, err
+	}
+	if s.maxKeys > 0 && len(s.data) >= s.maxKeys {
+		return nil, client.Error(errFull)
+	}
+	e = &entry{kind: kind}
+	switch kind {
+	case kindHash:
+		e.hash = make(map[string]string)
+	case kindSet:
+		e.set = make(map[string]struct{})
+	}
+	s.data[key] = e
+	return e, nil
+}
+
+func (s *Store) remove(key string) {
+	delete(s.data, key)
+	delete(s.expire, key)
+}
+
+// removes the hash, list or set left empty, as redis does
+func (s *Store) dropEmpty(key string, e *entry) {
+	n := 0
+	switch e.kind {
+	case kindHash:
+		n = len(e.hash)
+	case kindList:
+		n = len(e.list)
+	case kindSet:
+		n = len(e.set)
+	default:
+		return
+	}
+	if n == 0 {
+		s.remove(key)
+	}
+}
+
+// removes some of the expired keys
+func (s *Store) sweep() {
+	if s.now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = s.now
+	n := 0
+	for k, t := range s.expire {
+		if n == sweepCount {
+			break
+		}
+		n++
+		if !s.now.Before(t) {
+			s.remove(k)
+		}
+	}
+}
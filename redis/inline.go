@@ -0,0 +1,268 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+)
+
+// Inline commands, ie "SET key "hello world"" typed by telnet. The connection
+// accepted is read through inlineConn, which passes the RESP command as is and
+// translates the inline one into RESP before redcon reads it, so the inline
+// command is split exactly as redis does, with the double quoted argument of
+// the escapes \n, \r, \t, \b, \a, \\ and \xHH, and the single quoted one of \'
+// only. The blank line is skipped, the line of unbalanced quotes is replied
+// with the protocol error and the line longer than 64KB closes the connection.
+
+const (
+	inlineMaxSize = 64 * 1024
+
+	errInlineTooBig     = "-ERR Protocol error: too big inline request\r\n"
+	errInlineUnbalanced = "-ERR Protocol error: unbalanced quotes in request\r\n"
+)
+
+var (
+	errInlineQuotes = errors.New("unbalanced quotes")
+	errInlineClosed = errors.New("too big inline request")
+)
+
+type inlineListener struct {
+	net.Listener
+}
+
+func newInlineListener(ln net.Listener) net.Listener {
+	return &inlineListener{
+		Listener: ln,
+	}
+}
+
+func (l *inlineListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &inlineConn{
+		Conn: c,
+		rd:   bufio.NewReaderSize(c, inlineMaxSize),
+	}, nil
+}
+
+type inlineConn struct {
+	net.Conn
+	rd *bufio.Reader
+
+	// translated bytes not read yet
+	out []byte
+
+	// bulk headers and bytes of the RESP command not read yet
+	bulks int
+	data  int
+}
+
+func (c *inlineConn) Read(b []byte) (int, error) {
+	for len(c.out) == 0 {
+		if c.data > 0 {
+			if len(b) > c.data {
+				b = b[:c.data]
+			}
+			n, err := c.rd.Read(b)
+			c.data -= n
+			return n, err
+		}
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+func (c *inlineConn) line() ([]byte, error) {
+	line, err := c.rd.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		c.Conn.Write([]byte(errInlineTooBig))
+		return nil, errInlineClosed
+	}
+	return line, err
+}
+
+// reads the next line of the command, the malformed RESP is passed as is for
+// redcon to reply the error
+func (c *inlineConn) next() error {
+	if c.bulks > 0 {
+		line, err := c.line()
+		if err != nil {
+			return err
+		}
+		c.out = append(c.out[:0], line...)
+		c.bulks--
+		if n, ok := respLen(line, '$'); ok && n >= 0 {
+			c.data = n + 2
+		} else {
+			c.bulks = 0
+		}
+		return nil
+	}
+
+	first, err := c.rd.Peek(1)
+	if err != nil {
+		return err
+	}
+	line, err := c.line()
+	if err != nil {
+		return err
+	}
+	if first[0] == '*' {
+		c.out = append(c.out[:0], line...)
+		if n, ok := respLen(line, '*'); ok && n > 0 {
+			c.bulks = n
+		}
+		return nil
+	}
+
+	args, err := splitInline(bytes.TrimRight(line, "\r\n"))
+	if err != nil {
+		c.Conn.Write([]byte(errInlineUnbalanced))
+		return nil
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	c.out = append(c.out[:0], '*')
+	c.out = strconv.AppendInt(c.out, int64(len(args)), 10)
+	c.out = append(c.out, '\r', '\n')
+	for _, x := range args {
+		c.out = append(c.out, '$')
+		c.out = strconv.AppendInt(c.out, int64(len(x)), 10)
+		c.out = append(c.out, '\r', '\n')
+		c.out = append(c.out, x...)
+		c.out = append(c.out, '\r', '\n')
+	}
+	return nil
+}
+
+// the length of "*<n>\r\n" or "$<n>\r\n"
+func respLen(line []byte, prefix byte) (int, bool) {
+	if len(line) < 4 || line[0] != prefix || line[len(line)-2] != '\r' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(line[1 : len(line)-2]))
+	return n, err == nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// splits the inline command as sdssplitargs of redis
+func splitInline(line []byte) ([][]byte, error) {
+	o := [][]byte{}
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return o, nil
+		}
+
+		arg := []byte{}
+		inq, insq, done := false, false, false
+		for !done {
+			switch {
+			case inq:
+				if i == len(line) {
+					return nil, errInlineQuotes
+				}
+				c := line[i]
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' &&
+					isHex(line[i+2]) && isHex(line[i+3]):
+					arg = append(arg, hexValue(line[i+2])*16+hexValue(line[i+3]))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, line[i])
+					}
+				case c == '"':
+					// the closing quote must be followed by a space or
+					// nothing
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errInlineQuotes
+					}
+					done = true
+				default:
+					arg = append(arg, c)
+				}
+
+			case insq:
+				if i == len(line) {
+					return nil, errInlineQuotes
+				}
+				c := line[i]
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					arg = append(arg, '\'')
+				case c == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errInlineQuotes
+					}
+					done = true
+				default:
+					arg = append(arg, c)
+				}
+
+			default:
+				if i == len(line) {
+					done = true
+					break
+				}
+				switch c := line[i]; {
+				case isSpace(c):
+					done = true
+				case c == '"':
+					inq = true
+				case c == '\'':
+					insq = true
+				default:
+					arg = append(arg, c)
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		o = append(o, arg)
+	}
+}
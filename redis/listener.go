@@ -1,12 +1,16 @@
 package redis
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"time"
 
 	"crypto/tls"
+	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"unsafe"
 
@@ -25,22 +29,46 @@ type listenerConfig struct {
 	TLSKey         string `json:"tls_key"`
 	TLSCertificate string `json:"tls_certificate"`
 	IdleTimeout    int64  `json:"idle_timeout"`
+
+	// TLS termination, only in JSON form, preferred over the tls_key and
+	// tls_certificate
+	TLS *server.TLSConfig `json:"tls"`
+
+	// PROXY protocol of the L4 balancer, only in JSON form
+	ProxyProtocol *server.ProxyProtocolConfig `json:"proxy_protocol"`
 }
 
 type redconServer interface {
 	SetAcceptError(
 		func(error),
 	)
-	ListenAndServe() error
+	Serve(net.Listener) error
+	Close() error
 }
 
 type listener struct {
+	// accessed atomically, kept first for the 64 bit alignment
+	inflight int64
+	draining int32
+
 	name       string
+	endpoint   string
 	server     redconServer
+	tls        *server.TLS
+	proxy      *server.ProxyProtocol
 	clientPool *util.HClientPool
 	vhost      *server.VHost
+
+	// listening socket, set once the listener runs
+	socketLock sync.Mutex
+	socket     *server.DrainListener
 }
 
+const (
+	drainPollInterval = 10 * time.Millisecond
+	errShuttingDown   = "ERR server is shutting down"
+)
+
 type fac struct{}
 
 type clearRedconServer struct {
@@ -48,7 +76,8 @@ type clearRedconServer struct {
 }
 
 type tlsRedconServer struct {
-	s *redcon.TLSServer
+	s      *redcon.TLSServer
+	config *tls.Config
 }
 
 func mkClearServer(
@@ -61,18 +90,28 @@ func mkClearServer(
 
 func mkTLSServer(
 	s *redcon.TLSServer,
+	config *tls.Config,
 ) *tlsRedconServer {
 	return &tlsRedconServer{
-		s: s,
+		s:      s,
+		config: config,
 	}
 }
 
-func (x *clearRedconServer) ListenAndServe() error {
-	return x.s.ListenAndServe()
+func (x *clearRedconServer) Serve(ln net.Listener) error {
+	return x.s.Serve(newInlineListener(ln))
+}
+
+func (x *tlsRedconServer) Serve(ln net.Listener) error {
+	return x.s.Serve(newInlineListener(tls.NewListener(ln, x.config)))
+}
+
+func (x *clearRedconServer) Close() error {
+	return x.s.Close()
 }
 
-func (x *tlsRedconServer) ListenAndServe() error {
-	return x.s.ListenAndServe()
+func (x *tlsRedconServer) Close() error {
+	return x.s.Close()
 }
 
 func (x *clearRedconServer) SetAcceptError(
@@ -160,6 +199,16 @@ func (l *listener) onEvent(
 	conn redcon.Conn,
 	cmd redcon.Command,
 ) {
+	// counted before checking the draining flag, so the shutdown either sees
+	// the command in flight or the command sees the flag
+	atomic.AddInt64(&l.inflight, 1)
+	defer atomic.AddInt64(&l.inflight, -1)
+	if atomic.LoadInt32(&l.draining) == 1 {
+		conn.WriteError(errShuttingDown)
+		conn.Close()
+		return
+	}
+
 	vhs := l.vhs()
 	if vhs != nil {
 		(*vhs).OnEvent(conn, cmd)
@@ -172,6 +221,11 @@ func (l *listener) onEvent(
 func (l *listener) onAccept(
 	conn redcon.Conn,
 ) bool {
+	if atomic.LoadInt32(&l.draining) == 1 {
+		conn.WriteError(errShuttingDown)
+		return false
+	}
+
 	vhs := l.vhs()
 	if vhs != nil {
 		return (*vhs).OnAccept(conn)
@@ -195,28 +249,53 @@ func newListener(c *listenerConfig) (*listener, error) {
 	var s redconServer
 
 	l := &listener{
-		name: c.Name,
+		name:     c.Name,
+		endpoint: c.Endpoint,
 	}
 
-	if c.TLSKey != "" && c.TLSCertificate != "" {
-		cer, err := tls.X509KeyPair(
-			[]byte(c.TLSKey),
-			[]byte(c.TLSCertificate),
-		)
+	if c.ProxyProtocol != nil {
+		p, err := server.NewProxyProtocol(c.ProxyProtocol)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("listener %s: %s", c.Name, err.Error())
 		}
+		l.proxy = p
+	}
 
+	// tls_key and tls_certificate are the files of the single certificate,
+	// the shorthand of the "tls" field
+	tc := c.TLS
+	if tc == nil && c.TLSKey != "" && c.TLSCertificate != "" {
+		tc = &server.TLSConfig{
+			Certificate: []server.TLSCertificate{
+				{
+					Cert: c.TLSCertificate,
+					Key:  c.TLSKey,
+				},
+			},
+		}
+	}
+
+	var config *tls.Config
+	if tc != nil {
+		t, err := server.NewTLS(tc)
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: %s", c.Name, err.Error())
+		}
+		l.tls = t
+		config = t.Config
+	}
+
+	if config != nil {
 		s = mkTLSServer(
 			redcon.NewServerTLS(
 				c.Endpoint,
 				l.onEvent,
 				l.onAccept,
 				l.onClose,
-				&tls.Config{
-					Certificates: []tls.Certificate{cer},
-				},
-			))
+				config,
+			),
+			config,
+		)
 	} else {
 		s = mkClearServer(
 			redcon.NewServer(
@@ -272,11 +351,12 @@ func (l *listener) AddVHost(x server.VHost) error {
 	return nil
 }
 
-func (l *listener) UpdateVHost(x server.VHost) {
+func (l *listener) UpdateVHost(x server.VHost) error {
 	atomic.StorePointer(
 		(*unsafe.Pointer)(unsafe.Pointer(&l.vhost)),
 		unsafe.Pointer(&x),
 	)
+	return nil
 }
 
 func (l *listener) RemoveVHost(n string) {
@@ -298,14 +378,60 @@ func (l *listener) RemoveVHost(n string) {
 
 func (l *listener) GetVHost(name string) server.VHost {
 	x := l.vhs()
-	if x.Name() == name {
+	if x != nil && x.Name() == name {
 		return x
 	}
 	return nil
 }
 
 func (l *listener) Run() error {
-	return l.server.ListenAndServe()
+	ln, err := server.Listen(l.name, l.endpoint)
+	if err != nil {
+		return err
+	}
+	if l.proxy != nil {
+		ln = l.proxy.NewListener(ln)
+	}
+	socket := server.NewDrainListener(ln)
+	l.socketLock.Lock()
+	l.socket = socket
+	l.socketLock.Unlock()
+	return l.server.Serve(socket)
+}
+
+// Shutdown waits the commands in flight to finish, the new connection and
+// command are rejected meanwhile, then closes all the connections
+func (l *listener) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&l.draining, 1)
+
+	// the socket may be handed over to the new process, which accepts the
+	// connections from now on
+	l.socketLock.Lock()
+	if l.socket != nil {
+		l.socket.Stop()
+	}
+	l.socketLock.Unlock()
+
+	var err error
+	tick := time.NewTicker(drainPollInterval)
+	defer tick.Stop()
+	for err == nil && atomic.LoadInt64(&l.inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-tick.C:
+			break
+		}
+	}
+
+	l.server.Close()
+	if l.tls != nil {
+		l.tls.Close()
+	}
+	if vhs := l.vhs(); vhs != nil {
+		vhs.Shutdown()
+	}
+	return err
 }
 
 func init() {
@@ -32,6 +32,7 @@ type ConnStatus interface {
 
 type conn struct {
 	c        redcon.Conn
+	info     *ConnInfo
 	didWrite bool // whether this object perform write or not
 	didClose bool
 }
@@ -66,9 +67,9 @@ func (c *conn) IndexSet(
 }
 
 func (c *conn) Dot(
-	_ string,
+	name string,
 ) (pl.Val, error) {
-	return pl.NewValNull(), fmt.Errorf("%s index: unsupported operation", c.Id())
+	return c.dot(name)
 }
 
 func (c *conn) DotSet(
@@ -87,6 +88,8 @@ func (c *conn) ToJSON() (pl.Val, error) {
 		map[string]interface{}{
 			"type":       c.Id(),
 			"remoteAddr": c.Conn().RemoteAddr(),
+			"id":         c.info.ID,
+			"proto":      c.info.Proto,
 		},
 	)
 }
@@ -176,7 +179,7 @@ func (c *conn) Method(name string, arg []pl.Val) (pl.Val, error) {
 		if _, err := methodProtoConnWriteNull.Check(arg); err != nil {
 			return pl.NewValNull(), nil
 		}
-		c.c.WriteNull()
+		WriteNull(c.c, c.info)
 		c.didWrite = true
 		return pl.NewValNull(), nil
 
@@ -201,20 +204,32 @@ func (c *conn) Method(name string, arg []pl.Val) (pl.Val, error) {
 		c.didWrite = true
 		return pl.NewValNull(), nil
 
+	case "proxy":
+		return c.proxy(arg)
+
+	case "proxyExec":
+		return c.proxyExec(arg)
+
 	default:
-		break
+		if v, ok, err := c.resp3Method(name, arg); ok {
+			return v, err
+		}
 	}
 
 	return pl.NewValNull(), fmt.Errorf("%s method %s: unknown method", c.Id(), name)
 }
 
-func newConnection(c redcon.Conn) *conn {
+func newConnection(c redcon.Conn, info *ConnInfo) *conn {
+	if info == nil {
+		info = NewConnInfo(0)
+	}
 	return &conn{
-		c: c,
+		c:    c,
+		info: info,
 	}
 }
 
-func NewConnectionVal(c redcon.Conn) (pl.Val, ConnStatus) {
-	conn := newConnection(c)
+func NewConnectionVal(c redcon.Conn, info *ConnInfo) (pl.Val, ConnStatus) {
+	conn := newConnection(c, info)
 	return pl.NewValUsr(conn), conn
 }
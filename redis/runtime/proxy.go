@@ -0,0 +1,122 @@
+package runtime
+
+import (
+	"fmt"
+
+	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/redis/client"
+	ru "github.com/dianpeng/moons/redis/util"
+	"github.com/tidwall/redcon"
+)
+
+// Proxy of the commands to a backend redis server. The client is got from the
+// pool of the vhost by redis::client(addr), and conn:proxy(client, cmd) sends
+// the command and relays the reply of the backend to the connection as it is,
+// including the error reply. conn:proxyExec(client, [cmd, ...]) sends the
+// commands as one MULTI/EXEC transaction of the backend and relays the reply
+// of EXEC, which fits the redis.:exec rule
+
+var (
+	fnProtoRedisClient  = pl.MustNewFuncProto("redis::client", "%s")
+	fnProtoRedisKeySlot = pl.MustNewFuncProto("redis::keyslot", "%s")
+
+	methodProtoConnProxy     = pl.MustNewFuncProto("redis.conn.proxy", "{%U%U}{%U%l}")
+	methodProtoConnProxyExec = pl.MustNewFuncProto("redis.conn.proxyExec", "%U%l")
+)
+
+func (h *Runtime) fnRedisClient(args []pl.Val) (pl.Val, error) {
+	if _, err := fnProtoRedisClient.Check(args); err != nil {
+		return pl.NewValNull(), err
+	}
+	if h.resource == nil {
+		return pl.NewValNull(), fmt.Errorf("redis::client, redis client factory is not setup")
+	}
+	c, err := h.resource.GetRedisClient(args[0].String())
+	if err != nil {
+		return pl.NewValNull(), fmt.Errorf("redis::client, %s", err.Error())
+	}
+	return hpl.NewPooledRedisClientVal(c), nil
+}
+
+func fnRedisKeySlot(args []pl.Val) (pl.Val, error) {
+	if _, err := fnProtoRedisKeySlot.Check(args); err != nil {
+		return pl.NewValNull(), err
+	}
+	return pl.NewValInt(ru.KeySlot(args[0].String())), nil
+}
+
+func proxyClient(name string, v pl.Val) (client.Backend, error) {
+	if !hpl.ValIsRedisClient(v) {
+		return nil, fmt.Errorf("%s, the 1st argument must be redis client", name)
+	}
+	return v.Usr().(*hpl.RedisClient).Client(), nil
+}
+
+// WriteReply writes the reply read by the client or the keyspace
+func WriteReply(c redcon.Conn, info *ConnInfo, r interface{}) {
+	switch x := r.(type) {
+	case string:
+		c.WriteBulkString(x)
+	case client.Status:
+		c.WriteString(string(x))
+	case client.Error:
+		c.WriteError(string(x))
+	case int64:
+		c.WriteInt64(x)
+	case []interface{}:
+		c.WriteArray(len(x))
+		for _, e := range x {
+			WriteReply(c, info, e)
+		}
+	default:
+		WriteNull(c, info)
+	}
+}
+
+func (c *conn) proxy(arg []pl.Val) (pl.Val, error) {
+	if _, err := methodProtoConnProxy.Check(arg); err != nil {
+		return pl.NewValNull(), err
+	}
+	cl, err := proxyClient("redis.conn.proxy", arg[0])
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	cmd, err := hpl.RedisCommandArgs("redis.conn.proxy", arg[1:])
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	r, err := cl.Pipeline([][]string{cmd})
+	if err != nil {
+		return pl.NewValNull(), fmt.Errorf("redis.conn.proxy, %s", err.Error())
+	}
+	WriteReply(c.c, c.info, r[0])
+	c.didWrite = true
+	return pl.NewValNull(), nil
+}
+
+func (c *conn) proxyExec(arg []pl.Val) (pl.Val, error) {
+	if _, err := methodProtoConnProxyExec.Check(arg); err != nil {
+		return pl.NewValNull(), err
+	}
+	cl, err := proxyClient("redis.conn.proxyExec", arg[0])
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	cmds, err := hpl.RedisCommandList("redis.conn.proxyExec", arg[1])
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	tx := make([][]string, 0, len(cmds)+2)
+	tx = append(tx, []string{"MULTI"})
+	tx = append(tx, cmds...)
+	tx = append(tx, []string{"EXEC"})
+
+	r, err := cl.Pipeline(tx)
+	if err != nil {
+		return pl.NewValNull(), fmt.Errorf("redis.conn.proxyExec, %s", err.Error())
+	}
+	WriteReply(c.c, c.info, r[len(r)-1])
+	c.didWrite = true
+	return pl.NewValNull(), nil
+}
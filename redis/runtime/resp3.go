@@ -0,0 +1,238 @@
+package runtime
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dianpeng/moons/pl"
+	"github.com/tidwall/redcon"
+)
+
+// RESP3 of the connection. The protocol is negotiated by HELLO and kept in the
+// ConnInfo of the connection, the write methods of conn encode the reply with
+// the RESP3 type, ie map, double, boolean and push, and fall back to the
+// RESP2 form when the connection is still RESP2, ie the map is written as the
+// flat array of key and value
+
+const (
+	Proto2 = 2
+	Proto3 = 3
+)
+
+// ConnInfo is the metadata of the connection kept by the vhost
+type ConnInfo struct {
+	ID       int64
+	Proto    int
+	Name     string
+	User     string
+	Tracking bool
+	Created  time.Time
+
+	// map of the script, which lives from the accept to the close of the
+	// connection, ie the db selected by the script. It is conn.state since
+	// session is the keyword of PL
+	State pl.Val
+}
+
+func NewConnInfo(id int64) *ConnInfo {
+	return &ConnInfo{
+		ID:      id,
+		Proto:   Proto2,
+		Created: time.Now(),
+		State:   pl.NewValMap(),
+	}
+}
+
+func (i *ConnInfo) IsResp3() bool {
+	return i != nil && i.Proto == Proto3
+}
+
+var (
+	methodProtoConnWriteMap    = pl.MustNewFuncProto("redis.conn.writeMap", "%m")
+	methodProtoConnWriteDouble = pl.MustNewFuncProto("redis.conn.writeDouble", "%f")
+	methodProtoConnWriteBool   = pl.MustNewFuncProto("redis.conn.writeBool", "%b")
+	methodProtoConnWritePush   = pl.MustNewFuncProto("redis.conn.writePush", "%l")
+	methodProtoConnWriteValue  = pl.MustNewFuncProto("redis.conn.writeValue", "%a")
+	methodProtoConnInvalidate  = pl.MustNewFuncProto("redis.conn.invalidate", "{%l}{%n}")
+)
+
+// WriteNull writes the null of the protocol
+func WriteNull(c redcon.Conn, info *ConnInfo) {
+	if info.IsResp3() {
+		c.WriteRaw([]byte("_\r\n"))
+	} else {
+		c.WriteNull()
+	}
+}
+
+// WriteMap writes the header of the map of n pairs, followed by the keys and
+// values
+func WriteMap(c redcon.Conn, info *ConnInfo, n int) {
+	if info.IsResp3() {
+		c.WriteRaw([]byte("%" + strconv.Itoa(n) + "\r\n"))
+	} else {
+		c.WriteArray(n * 2)
+	}
+}
+
+func writeDouble(c redcon.Conn, info *ConnInfo, v float64) {
+	str := strconv.FormatFloat(v, 'g', -1, 64)
+	if info.IsResp3() {
+		c.WriteRaw([]byte("," + str + "\r\n"))
+	} else {
+		c.WriteBulkString(str)
+	}
+}
+
+func writeBool(c redcon.Conn, info *ConnInfo, v bool) {
+	if info.IsResp3() {
+		if v {
+			c.WriteRaw([]byte("#t\r\n"))
+		} else {
+			c.WriteRaw([]byte("#f\r\n"))
+		}
+	} else if v {
+		c.WriteInt(1)
+	} else {
+		c.WriteInt(0)
+	}
+}
+
+func writePushHeader(c redcon.Conn, info *ConnInfo, n int) {
+	if info.IsResp3() {
+		c.WriteRaw([]byte(">" + strconv.Itoa(n) + "\r\n"))
+	} else {
+		c.WriteArray(n)
+	}
+}
+
+// writes the script value as the reply of its type, the nested list and map
+// are written recursively
+func writeVal(c redcon.Conn, info *ConnInfo, v pl.Val) error {
+	switch {
+	case v.IsNull():
+		WriteNull(c, info)
+	case v.IsString():
+		c.WriteBulkString(v.String())
+	case v.IsInt():
+		c.WriteInt64(v.Int())
+	case v.IsReal():
+		writeDouble(c, info, v.Real())
+	case v.IsBool():
+		writeBool(c, info, v.Bool())
+	case v.IsList():
+		l := v.List()
+		c.WriteArray(l.Length())
+		for i := 0; i < l.Length(); i++ {
+			if err := writeVal(c, info, l.At(i)); err != nil {
+				return err
+			}
+		}
+	case v.IsMap():
+		return writeMapVal(c, info, v)
+	default:
+		str, err := v.ToString()
+		if err != nil {
+			return fmt.Errorf("value of type %s cannot be written", v.Info())
+		}
+		c.WriteBulkString(str)
+	}
+	return nil
+}
+
+func writeMapVal(c redcon.Conn, info *ConnInfo, v pl.Val) error {
+	m := v.Map()
+	WriteMap(c, info, m.Length())
+	var err error
+	m.Foreach(
+		func(key string, val pl.Val) bool {
+			c.WriteBulkString(key)
+			err = writeVal(c, info, val)
+			return err == nil
+		},
+	)
+	return err
+}
+
+func (c *conn) dot(name string) (pl.Val, error) {
+	info := c.info
+	switch name {
+	case "id":
+		return pl.NewValInt64(info.ID), nil
+	case "proto":
+		return pl.NewValInt(info.Proto), nil
+	case "name":
+		return pl.NewValStr(info.Name), nil
+	case "user":
+		return pl.NewValStr(info.User), nil
+	case "tracking":
+		return pl.NewValBool(info.Tracking), nil
+	case "remoteAddr":
+		return pl.NewValStr(c.c.RemoteAddr()), nil
+	case "state":
+		return info.State, nil
+	case "age":
+		return pl.NewValInt64(int64(time.Since(info.Created) / time.Second)), nil
+	default:
+		return pl.NewValNull(), fmt.Errorf("%s: unknown field %s", c.Id(), name)
+	}
+}
+
+// the write methods of RESP3, returns false if the name is not one of them
+func (c *conn) resp3Method(name string, arg []pl.Val) (pl.Val, bool, error) {
+	var err error
+	switch name {
+	case "writeMap":
+		if _, err = methodProtoConnWriteMap.Check(arg); err == nil {
+			err = writeMapVal(c.c, c.info, arg[0])
+		}
+
+	case "writeDouble":
+		if _, err = methodProtoConnWriteDouble.Check(arg); err == nil {
+			writeDouble(c.c, c.info, arg[0].Real())
+		}
+
+	case "writeBool":
+		if _, err = methodProtoConnWriteBool.Check(arg); err == nil {
+			writeBool(c.c, c.info, arg[0].Bool())
+		}
+
+	case "writeValue":
+		if _, err = methodProtoConnWriteValue.Check(arg); err == nil {
+			err = writeVal(c.c, c.info, arg[0])
+		}
+
+	// push is out of band, it is not the reply of the command
+	case "writePush":
+		if _, err = methodProtoConnWritePush.Check(arg); err != nil {
+			break
+		}
+		l := arg[0].List()
+		writePushHeader(c.c, c.info, l.Length())
+		for i := 0; i < l.Length() && err == nil; i++ {
+			err = writeVal(c.c, c.info, l.At(i))
+		}
+		return pl.NewValNull(), true, err
+
+	// invalidate([key, ...]) or invalidate(null) pushes the invalidation
+	// message of client tracking, null flushes all the keys
+	case "invalidate":
+		if _, err = methodProtoConnInvalidate.Check(arg); err != nil {
+			break
+		}
+		writePushHeader(c.c, c.info, 2)
+		c.c.WriteBulkString("invalidate")
+		err = writeVal(c.c, c.info, arg[0])
+		return pl.NewValNull(), true, err
+
+	default:
+		return pl.NewValNull(), false, nil
+	}
+
+	if err != nil {
+		return pl.NewValNull(), true, fmt.Errorf("%s method %s: %s", c.Id(), name, err.Error())
+	}
+	c.didWrite = true
+	return pl.NewValNull(), true, nil
+}
@@ -6,12 +6,18 @@ import (
 
 	"github.com/dianpeng/moons/alog"
 	"github.com/dianpeng/moons/hpl"
+	"github.com/dianpeng/moons/kv"
 	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/redis/client"
 )
 
 type Resource interface {
 	// special function used for exposing other utilities
 	hpl.HttpClientFactory
+	hpl.RedisClientFactory
+
+	// keyspace of the vhost, nil if not enabled
+	GetKeyspace() client.Backend
 }
 
 type Runtime struct {
@@ -21,6 +27,12 @@ type Runtime struct {
 	conn     pl.Val
 	log      pl.Val
 	resource Resource
+
+	// namespace of kv:: functions
+	kvNamespace string
+
+	// level of log:: functions, ie the one of the vhost
+	logLevel *alog.Level
 }
 
 func NewRuntime() *Runtime {
@@ -89,11 +101,47 @@ func (p *Runtime) loadFnVar(_ *pl.Evaluator, n string) (pl.Val, bool) {
 			},
 		), true
 
+	// redis::client(addr), the client of the vhost pool, see proxy.go
+	case "redis::client":
+		return pl.NewValNativeFunction(
+			"redis::client",
+			p.fnRedisClient,
+		), true
+
+	// redis::keyslot(key), the hash slot of redis cluster
+	case "redis::keyslot":
+		return pl.NewValNativeFunction(
+			"redis::keyslot",
+			fnRedisKeySlot,
+		), true
+
+	case "http::fetch_all":
+		return pl.NewValNativeFunction(
+			"http::fetch_all",
+			func(args []pl.Val) (pl.Val, error) {
+				return p.fnHttp(args, hpl.FnHttpFetchAll)
+			},
+		), true
+
 	default:
 		break
 	}
 
-	return pl.NewValNull(), false
+	if v, ok := hpl.NewLogFunction(n, p.logLevel, p.log); ok {
+		return v, true
+	}
+	return hpl.NewKVFunction(n, kv.Default.Namespace(p.kvNamespace))
+}
+
+// SetKVNamespace sets the namespace of the kv:: functions, ie the vhost name
+func (p *Runtime) SetKVNamespace(ns string) {
+	p.kvNamespace = ns
+}
+
+// SetLogLevel sets the level of the log:: functions, ie the level of the vhost
+// which is adjustable at runtime
+func (p *Runtime) SetLogLevel(level *alog.Level) {
+	p.logLevel = level
 }
 
 func (p *Runtime) loadVar(
@@ -110,6 +158,11 @@ func (p *Runtime) loadVar(
 		return p.conn, nil
 	case "log":
 		return p.log, nil
+	case "keyspace":
+		if ks := p.resource.GetKeyspace(); ks != nil {
+			return hpl.NewPooledRedisClientVal(ks), nil
+		}
+		return pl.NewValNull(), nil
 	default:
 		break
 	}
@@ -155,6 +208,7 @@ func (h *Runtime) OnGlobal(resource Resource) error {
 		return fmt.Errorf("Runtime engine does not have any module binded")
 	}
 	h.resource = resource
+	h.log = pl.NewValNull()
 
 	h.Eval.Context = pl.NewCbEvalContext(
 		h.globalLoadVar,
@@ -218,6 +272,37 @@ func (h *Runtime) OnInit(
 	return h.Eval.EvalSession(h.Module)
 }
 
+// OnShutdown runs the @shutdown rule of the module once the listener is
+// drained, the rule runs outside of any connection so only the functions are
+// visible to it
+func (h *Runtime) OnShutdown(resource Resource) error {
+	return h.OnModuleEvent(pl.ShutdownRule, pl.NewValNull(), resource)
+}
+
+// OnModuleEvent runs the event which comes from outside of any connection, ie
+// the reload of the manifest, only the functions are visible to the rule
+func (h *Runtime) OnModuleEvent(name string, context pl.Val, resource Resource) error {
+	if h.Module == nil {
+		return fmt.Errorf("Runtime engine does not have any module binded")
+	}
+	h.resource = resource
+	h.log = pl.NewValNull()
+
+	h.Eval.Context = pl.NewCbEvalContext(
+		h.globalLoadVar,
+		h.globalStoreVar,
+		h.globalAction,
+	)
+	_, err := h.Emit(name, context)
+	return err
+}
+
+// closes the resources registered by the session, ie the branches of a tee'd
+// stream, should be called once the session is done
+func (h *Runtime) Cleanup() error {
+	return h.Eval.Cleanup()
+}
+
 func (h *Runtime) Emit(
 	name string,
 	context pl.Val,
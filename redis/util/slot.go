@@ -0,0 +1,50 @@
+package util
+
+// Hash slot of redis cluster, which is CRC16 (XMODEM) of the key modulo 16384.
+// Only the part inside of the first {...} of the key is hashed when it is not
+// empty, so the keys of the same hash tag are in the same slot
+
+const SlotCount = 16384
+
+var crc16Table [256]uint16
+
+func init() {
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+func crc16(key string) uint16 {
+	crc := uint16(0)
+	for i := 0; i < len(key); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^key[i]]
+	}
+	return crc
+}
+
+// KeySlot returns the hash slot of the key
+func KeySlot(key string) int {
+	for i := 0; i < len(key); i++ {
+		if key[i] != '{' {
+			continue
+		}
+		for j := i + 1; j < len(key); j++ {
+			if key[j] == '}' {
+				if j > i+1 {
+					key = key[i+1 : j]
+				}
+				return int(crc16(key)) % SlotCount
+			}
+		}
+		break
+	}
+	return int(crc16(key)) % SlotCount
+}
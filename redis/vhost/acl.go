@@ -0,0 +1,194 @@
+package vhost
+
+import (
+	"fmt"
+	"strings"
+
+	ru "github.com/dianpeng/moons/redis/util"
+	"github.com/dianpeng/moons/util"
+)
+
+// ACL of the authenticated identity, given by the redis.:auth rule as the redis
+// ACL rules, ie "+@hash -hdel ~cache:*". The command rules are applied in order
+// and the last one matching the command decides, so "-@all +get" allows GET
+// only. The category is the one of the redis.:<category> event, ie @hash or
+// @string, plus @all. The key patterns are the glob of KEYS, the command with
+// keys is allowed only if all of its keys match one of the patterns. The
+// patterns are compiled when the ACL is parsed
+//
+//   +<command>, -<command>    allows or denies the command
+//   +@<category>, -@<category> allows or denies the category
+//   allcommands, nocommands   alias of +@all and -@all
+//   ~<pattern>                allows the keys of the pattern
+//   allkeys, resetkeys        alias of ~*, and removes all the patterns
+
+type aclRule struct {
+	allow    bool
+	category string
+	command  string
+}
+
+type acl struct {
+	rules []aclRule
+	keys  []*util.Glob
+}
+
+const (
+	errNoPermKey = "NOPERM No permissions to access a key"
+)
+
+func parseACL(list []string) (*acl, error) {
+	o := &acl{}
+	for _, x := range list {
+		for _, r := range strings.Fields(x) {
+			if err := o.add(r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return o, nil
+}
+
+func (a *acl) add(r string) error {
+	switch strings.ToLower(r) {
+	case "allcommands":
+		r = "+@all"
+	case "nocommands":
+		r = "-@all"
+	case "allkeys":
+		r = "~*"
+	case "resetkeys":
+		a.keys = nil
+		return nil
+	}
+
+	switch r[0] {
+	case '~':
+		g, err := util.CompileGlobSeparator(r[1:], util.GlobNoSeparator)
+		if err != nil {
+			return fmt.Errorf("redis_vhost: invalid ACL key pattern %s: %s", r, err.Error())
+		}
+		a.keys = append(a.keys, g)
+		return nil
+	case '+', '-':
+		break
+	default:
+		return fmt.Errorf("redis_vhost: unknown ACL rule %s", r)
+	}
+
+	rule := aclRule{allow: r[0] == '+'}
+	name := r[1:]
+	if strings.HasPrefix(name, "@") {
+		rule.category = strings.ToLower(name[1:])
+	} else {
+		rule.command = strings.ToUpper(name)
+	}
+	if name == "" || name == "@" {
+		return fmt.Errorf("redis_vhost: invalid ACL rule %s", r)
+	}
+	a.rules = append(a.rules, rule)
+	return nil
+}
+
+// checks the command, returns the error message if it is not allowed
+func (a *acl) check(user string, name string, args [][]byte) string {
+	category := ru.CommandCategoryName(name)
+	allow := false
+	for _, r := range a.rules {
+		if r.command == name ||
+			r.category == "all" ||
+			(r.category != "" && r.category == category) {
+			allow = r.allow
+		}
+	}
+	if !allow {
+		return fmt.Sprintf(
+			"NOPERM User %s has no permissions to run the '%s' command",
+			user,
+			strings.ToLower(name),
+		)
+	}
+
+	for _, k := range commandKeys(name, args) {
+		ok := false
+		for _, p := range a.keys {
+			if p.Match(k) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return errNoPermKey
+		}
+	}
+	return ""
+}
+
+// commands whose arguments are all keys, or every other one is
+var (
+	aclAllKeys = map[string]bool{
+		"DEL":     true,
+		"UNLINK":  true,
+		"EXISTS":  true,
+		"TOUCH":   true,
+		"MGET":    true,
+		"WATCH":   true,
+		"SINTER":  true,
+		"SUNION":  true,
+		"SDIFF":   true,
+		"PFCOUNT": true,
+	}
+	aclPairKeys = map[string]bool{
+		"MSET":   true,
+		"MSETNX": true,
+	}
+	aclTwoKeys = map[string]bool{
+		"RENAME":    true,
+		"RENAMENX":  true,
+		"COPY":      true,
+		"SMOVE":     true,
+		"LMOVE":     true,
+		"RPOPLPUSH": true,
+	}
+	aclNoKeys = map[string]bool{
+		"KEYS":      true,
+		"SCAN":      true,
+		"RANDOMKEY": true,
+		"WAIT":      true,
+	}
+)
+
+// keys of the command, the command of the data categories takes its 1st
+// argument as the key unless it is one of the tables above
+func commandKeys(name string, args [][]byte) []string {
+	args = args[1:]
+	o := []string{}
+	switch {
+	case aclNoKeys[name]:
+		return nil
+	case aclAllKeys[name]:
+		for _, x := range args {
+			o = append(o, string(x))
+		}
+	case aclPairKeys[name]:
+		for i := 0; i < len(args); i += 2 {
+			o = append(o, string(args[i]))
+		}
+	case aclTwoKeys[name]:
+		for i := 0; i < len(args) && i < 2; i++ {
+			o = append(o, string(args[i]))
+		}
+	default:
+		switch ru.CommandCategory(name) {
+		case ru.RedisCommandPubSub,
+			ru.RedisCommandScript,
+			ru.RedisCommandTransaction,
+			ru.RedisCommandUnknown:
+			return nil
+		}
+		if len(args) != 0 {
+			o = append(o, string(args[0]))
+		}
+	}
+	return o
+}
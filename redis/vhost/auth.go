@@ -0,0 +1,129 @@
+package vhost
+
+import (
+	"github.com/dianpeng/moons/pl"
+
+	"github.com/tidwall/redcon"
+)
+
+// AUTH of the connection. Once the module has the redis.:auth rule, the
+// connection must authenticate by AUTH [username] password, or by HELLO with
+// AUTH, before any other command. The rule gets {user, password}, the user is
+// "default" if not given, and returns
+//
+//   true                    the identity is allowed to run any command
+//   string or list          the ACL rules of the identity, see acl.go
+//   false or null           the credential is wrong
+//
+// Without the rule, AUTH goes to the rules of the module as usual.
+
+const (
+	eventAuth = "redis.:auth"
+
+	errNoAuth    = "NOAUTH Authentication required."
+	errWrongPass = "WRONGPASS invalid username-password pair or user is disabled."
+	errNoAuthCfg = "ERR AUTH called without any password configured for the default user"
+)
+
+// commands allowed before the connection is authenticated
+var authExempt = map[string]bool{
+	"AUTH":  true,
+	"HELLO": true,
+	"QUIT":  true,
+	"RESET": true,
+}
+
+func (s *serviceHandler) authRequired() bool {
+	return s.runtime.Module.HaveEvent(eventAuth)
+}
+
+// checks the authentication and the ACL of the command, returns the error
+// message if it is not allowed
+func (s *serviceHandler) authorize(
+	conn redcon.Conn,
+	cmd redcon.Command,
+	name string,
+) string {
+	if authExempt[name] || !s.authRequired() {
+		return ""
+	}
+	state := connStateOf(conn)
+	if state.acl == nil {
+		return errNoAuth
+	}
+	return state.acl.check(state.info.User, name, cmd.Args)
+}
+
+// AUTH [username] password, returns false if the module handles AUTH itself
+func (s *serviceHandler) auth(
+	conn redcon.Conn,
+	cmd redcon.Command,
+) bool {
+	if !s.authRequired() {
+		return false
+	}
+	switch len(cmd.Args) {
+	case 2:
+		s.authWrite(conn, "default", string(cmd.Args[1]))
+	case 3:
+		s.authWrite(conn, string(cmd.Args[1]), string(cmd.Args[2]))
+	default:
+		conn.WriteError(errArgs("auth"))
+	}
+	return true
+}
+
+func (s *serviceHandler) authWrite(conn redcon.Conn, user, password string) {
+	if msg := s.authenticate(conn, user, password); msg != "" {
+		conn.WriteError(msg)
+	} else {
+		conn.WriteString("OK")
+	}
+}
+
+// runs the redis.:auth rule and keeps the identity of the connection on
+// success, returns the error message otherwise
+func (s *serviceHandler) authenticate(
+	conn redcon.Conn,
+	user string,
+	password string,
+) string {
+	if !s.authRequired() {
+		return errNoAuthCfg
+	}
+
+	ctx := pl.NewValMap()
+	ctx.AddMap("user", pl.NewValStr(user))
+	ctx.AddMap("password", pl.NewValStr(password))
+
+	v, err := s.call(conn, eventAuth, ctx)
+	if err != nil {
+		return "ERR " + err.Error()
+	}
+
+	var rules []string
+	switch {
+	case v.IsBool() && v.Bool():
+		rules = []string{"+@all", "~*"}
+	case v.IsString():
+		rules = []string{v.String()}
+	case v.IsList():
+		for _, x := range v.List().Data {
+			if !x.IsString() {
+				return "ERR " + eventAuth + " must return the ACL rules as string"
+			}
+			rules = append(rules, x.String())
+		}
+	default:
+		return errWrongPass
+	}
+
+	a, err := parseACL(rules)
+	if err != nil {
+		return "ERR " + err.Error()
+	}
+	state := connStateOf(conn)
+	state.acl = a
+	state.info.User = user
+	return ""
+}
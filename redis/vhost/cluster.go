@@ -0,0 +1,392 @@
+package vhost
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/redis/runtime"
+	ru "github.com/dianpeng/moons/redis/util"
+
+	"github.com/tidwall/redcon"
+)
+
+// Redis cluster compatibility. The slot map is configured by .cluster of the
+// redis_vhost config, ie
+//
+//   .cluster = [
+//     {"host": "10.0.0.1", "port": 6379, "slots": [0, 8191], "self": true},
+//     {"host": "10.0.0.2", "port": 6379, "slots": [[8192, 12287], [12288, 16383]]}
+//   ];
+//
+// The node of "self" is served by this vhost. The command whose keys are in
+// the slot of another node is replied with MOVED, or with ASK when the node has
+// "ask": true, ie its slots are being migrated, and the command after ASKING
+// is served once regardless. The keys of different slots are replied with
+// CROSSSLOT. CLUSTER SLOTS, SHARDS, NODES, INFO, MYID and KEYSLOT are replied
+// from the slot map, the other CLUSTER subcommands go to the rules.
+
+type ClusterNode struct {
+	ID    string
+	Host  string
+	Port  int
+	Slots [][2]int
+	Self  bool
+	Ask   bool
+}
+
+func (n *ClusterNode) addr() string {
+	return net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+}
+
+type clusterMap struct {
+	nodes []ClusterNode
+
+	// index of the node owning the slot, -1 if not assigned
+	slot [ru.SlotCount]int16
+}
+
+const (
+	errCrossSlot = "CROSSSLOT Keys in request don't hash to the same slot"
+)
+
+func clusterSlotRange(v pl.Val) ([2]int, error) {
+	if !v.IsList() || v.List().Length() != 2 {
+		return [2]int{}, fmt.Errorf("slots must be [start, end] or list of it")
+	}
+	start, end := v.List().At(0), v.List().At(1)
+	if !start.IsInt() || !end.IsInt() {
+		return [2]int{}, fmt.Errorf("slots must be [start, end] or list of it")
+	}
+	o := [2]int{int(start.Int()), int(end.Int())}
+	if o[0] < 0 || o[1] >= ru.SlotCount || o[0] > o[1] {
+		return o, fmt.Errorf("slot range [%d, %d] is invalid", o[0], o[1])
+	}
+	return o, nil
+}
+
+func parseClusterNode(v pl.Val) (ClusterNode, error) {
+	o := ClusterNode{}
+	if !v.IsMap() {
+		return o, fmt.Errorf("node must be map")
+	}
+	var err error
+	v.Map().Foreach(
+		func(key string, val pl.Val) bool {
+			switch key {
+			case "id":
+				if !val.IsString() {
+					err = fmt.Errorf("id must be string")
+				} else {
+					o.ID = val.String()
+				}
+			case "host":
+				if !val.IsString() {
+					err = fmt.Errorf("host must be string")
+				} else {
+					o.Host = val.String()
+				}
+			case "port":
+				if !val.IsInt() {
+					err = fmt.Errorf("port must be int")
+				} else {
+					o.Port = int(val.Int())
+				}
+			case "self":
+				if !val.IsBool() {
+					err = fmt.Errorf("self must be bool")
+				} else {
+					o.Self = val.Bool()
+				}
+			case "ask":
+				if !val.IsBool() {
+					err = fmt.Errorf("ask must be bool")
+				} else {
+					o.Ask = val.Bool()
+				}
+			case "slots":
+				if !val.IsList() {
+					err = fmt.Errorf("slots must be list")
+					break
+				}
+				if val.List().Length() == 0 {
+					break
+				}
+				if first := val.List().At(0); first.IsInt() {
+					var r [2]int
+					if r, err = clusterSlotRange(val); err == nil {
+						o.Slots = append(o.Slots, r)
+					}
+					break
+				}
+				for _, x := range val.List().Data {
+					var r [2]int
+					if r, err = clusterSlotRange(x); err != nil {
+						break
+					}
+					o.Slots = append(o.Slots, r)
+				}
+			default:
+				err = fmt.Errorf("unknown field %s", key)
+			}
+			return err == nil
+		},
+	)
+	if err != nil {
+		return o, err
+	}
+	if o.Host == "" || o.Port == 0 {
+		return o, fmt.Errorf("host and port are required")
+	}
+	if o.ID == "" {
+		h := sha1.Sum([]byte(o.addr()))
+		o.ID = hex.EncodeToString(h[:])
+	}
+	return o, nil
+}
+
+func parseClusterConfig(v pl.Val) ([]ClusterNode, error) {
+	if !v.IsList() {
+		return nil, fmt.Errorf("redis_vhost.cluster: must be list of node")
+	}
+	o := []ClusterNode{}
+	for i, x := range v.List().Data {
+		n, err := parseClusterNode(x)
+		if err != nil {
+			return nil, fmt.Errorf("redis_vhost.cluster: %dth node, %s", i, err.Error())
+		}
+		o = append(o, n)
+	}
+	return o, nil
+}
+
+func newClusterMap(nodes []ClusterNode) (*clusterMap, error) {
+	c := &clusterMap{
+		nodes: nodes,
+	}
+	for i := range c.slot {
+		c.slot[i] = -1
+	}
+	for i, n := range nodes {
+		for _, r := range n.Slots {
+			for s := r[0]; s <= r[1]; s++ {
+				if c.slot[s] != -1 {
+					return nil, fmt.Errorf("redis_vhost.cluster: slot %d is assigned to both %s and %s",
+						s, nodes[c.slot[s]].addr(), n.addr())
+				}
+				c.slot[s] = int16(i)
+			}
+		}
+	}
+	return c, nil
+}
+
+// checks whether the command is served by this node, returns the redirection
+// otherwise
+func (c *clusterMap) redirect(name string, args [][]byte, asking bool) string {
+	keys := commandKeys(name, args)
+	if len(keys) == 0 {
+		return ""
+	}
+	slot := ru.KeySlot(keys[0])
+	for _, k := range keys[1:] {
+		if ru.KeySlot(k) != slot {
+			return errCrossSlot
+		}
+	}
+	idx := c.slot[slot]
+	if idx < 0 {
+		return "CLUSTERDOWN Hash slot not served"
+	}
+	n := &c.nodes[idx]
+	if n.Self || asking {
+		return ""
+	}
+	if n.Ask {
+		return fmt.Sprintf("ASK %d %s", slot, n.addr())
+	}
+	return fmt.Sprintf("MOVED %d %s", slot, n.addr())
+}
+
+type clusterRange struct {
+	start, end int
+	node       *ClusterNode
+}
+
+// slot ranges of all the nodes in order
+func (c *clusterMap) ranges() []clusterRange {
+	o := []clusterRange{}
+	for i := range c.nodes {
+		for _, r := range c.nodes[i].Slots {
+			o = append(o, clusterRange{r[0], r[1], &c.nodes[i]})
+		}
+	}
+	sort.Slice(o, func(i, j int) bool {
+		return o[i].start < o[j].start
+	})
+	return o
+}
+
+func (c *clusterMap) assigned() int {
+	n := 0
+	for _, x := range c.slot {
+		if x >= 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// the redirection of the command and the cluster commands, returns false if
+// the command goes on as usual
+func (s *serviceHandler) onCluster(
+	conn redcon.Conn,
+	cmd redcon.Command,
+	name string,
+) bool {
+	c := s.vhost.cluster
+	if c == nil {
+		return false
+	}
+	state := connStateOf(conn)
+	asking := state.asking
+	state.asking = false
+
+	switch name {
+	case "ASKING":
+		state.asking = true
+		conn.WriteString("OK")
+		return true
+	case "READONLY", "READWRITE":
+		conn.WriteString("OK")
+		return true
+	case "CLUSTER":
+		return s.cluster(conn, cmd)
+	}
+
+	if msg := c.redirect(name, cmd.Args, asking); msg != "" {
+		if tx := state.tx; tx != nil {
+			tx.aborted = true
+		}
+		conn.WriteError(msg)
+		return true
+	}
+	return false
+}
+
+func writeClusterNode(conn redcon.Conn, n *ClusterNode) {
+	conn.WriteArray(3)
+	conn.WriteBulkString(n.Host)
+	conn.WriteInt(n.Port)
+	conn.WriteBulkString(n.ID)
+}
+
+func (s *serviceHandler) cluster(
+	conn redcon.Conn,
+	cmd redcon.Command,
+) bool {
+	if len(cmd.Args) < 2 {
+		return false
+	}
+	c := s.vhost.cluster
+	info := connStateOf(conn).info
+
+	switch strings.ToUpper(string(cmd.Args[1])) {
+	case "SLOTS":
+		r := c.ranges()
+		conn.WriteArray(len(r))
+		for _, x := range r {
+			conn.WriteArray(3)
+			conn.WriteInt(x.start)
+			conn.WriteInt(x.end)
+			writeClusterNode(conn, x.node)
+		}
+
+	case "SHARDS":
+		conn.WriteArray(len(c.nodes))
+		for i := range c.nodes {
+			n := &c.nodes[i]
+			runtime.WriteMap(conn, info, 2)
+			conn.WriteBulkString("slots")
+			conn.WriteArray(len(n.Slots) * 2)
+			for _, r := range n.Slots {
+				conn.WriteInt(r[0])
+				conn.WriteInt(r[1])
+			}
+			conn.WriteBulkString("nodes")
+			conn.WriteArray(1)
+			runtime.WriteMap(conn, info, 7)
+			conn.WriteBulkString("id")
+			conn.WriteBulkString(n.ID)
+			conn.WriteBulkString("port")
+			conn.WriteInt(n.Port)
+			conn.WriteBulkString("ip")
+			conn.WriteBulkString(n.Host)
+			conn.WriteBulkString("endpoint")
+			conn.WriteBulkString(n.Host)
+			conn.WriteBulkString("role")
+			conn.WriteBulkString("master")
+			conn.WriteBulkString("replication-offset")
+			conn.WriteInt(0)
+			conn.WriteBulkString("health")
+			conn.WriteBulkString("online")
+		}
+
+	case "NODES":
+		b := &strings.Builder{}
+		for i := range c.nodes {
+			n := &c.nodes[i]
+			flags := "master"
+			if n.Self {
+				flags = "myself,master"
+			}
+			fmt.Fprintf(b, "%s %s@%d %s - 0 0 %d connected", n.ID, n.addr(), n.Port+10000, flags, i+1)
+			for _, r := range n.Slots {
+				if r[0] == r[1] {
+					fmt.Fprintf(b, " %d", r[0])
+				} else {
+					fmt.Fprintf(b, " %d-%d", r[0], r[1])
+				}
+			}
+			b.WriteString("\n")
+		}
+		conn.WriteBulkString(b.String())
+
+	case "INFO":
+		state := "ok"
+		if c.assigned() != ru.SlotCount {
+			state = "fail"
+		}
+		conn.WriteBulkString(fmt.Sprintf(
+			"cluster_enabled:1\r\ncluster_state:%s\r\ncluster_slots_assigned:%d\r\n"+
+				"cluster_slots_ok:%d\r\ncluster_slots_pfail:0\r\ncluster_slots_fail:0\r\n"+
+				"cluster_known_nodes:%d\r\ncluster_size:%d\r\n",
+			state, c.assigned(), c.assigned(), len(c.nodes), len(c.nodes),
+		))
+
+	case "MYID":
+		for i := range c.nodes {
+			if c.nodes[i].Self {
+				conn.WriteBulkString(c.nodes[i].ID)
+				return true
+			}
+		}
+		conn.WriteError("ERR no node of the cluster is served by this vhost")
+
+	case "KEYSLOT":
+		if len(cmd.Args) != 3 {
+			conn.WriteError(errArgs("cluster|keyslot"))
+		} else {
+			conn.WriteInt(ru.KeySlot(string(cmd.Args[2])))
+		}
+
+	default:
+		return false
+	}
+	return true
+}
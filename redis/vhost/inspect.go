@@ -0,0 +1,79 @@
+package vhost
+
+import (
+	"fmt"
+
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/server"
+)
+
+// server.Inspector of the vhost, the results are in JSON form. The redis vhost
+// has no service and no upstream checked, and is drained by its listener
+
+func (v *VHost) Inspect() interface{} {
+	module := map[string]interface{}{}
+	if v.Module != nil {
+		module["rules"] = v.Module.Rules()
+		module["functions"] = v.Module.Functions()
+	}
+	return map[string]interface{}{
+		"name":     v.Config.Name,
+		"type":     v.ListenerType(),
+		"listener": v.Config.Listener,
+		"comment":  v.Config.Comment,
+		"module":   module,
+	}
+}
+
+func (v *VHost) Bytecode(service string) (string, error) {
+	if service != "" {
+		return "", fmt.Errorf("redis_vhost has no service")
+	}
+	return v.Module.Dump(), nil
+}
+
+func (v *VHost) ConfigAudit(service string) ([]pl.ConfigAuditEntry, error) {
+	if service != "" {
+		return nil, fmt.Errorf("redis_vhost has no service")
+	}
+	return v.configAudit, nil
+}
+
+func (v *VHost) RuleStats() []server.RuleStats {
+	o := []server.RuleStats{}
+	if v.Module == nil {
+		return o
+	}
+	for _, x := range v.Module.Stats() {
+		o = append(o, server.RuleStats{
+			RuleStats: x,
+		})
+	}
+	return o
+}
+
+func (v *VHost) ResetRuleStats() {
+	if v.Module != nil {
+		v.Module.ResetStats()
+	}
+}
+
+func (v *VHost) Health() interface{} {
+	return map[string]interface{}{}
+}
+
+func (v *VHost) Metrics() interface{} {
+	o := map[string]interface{}{
+		"idleSession":    v.servicePool.idleSize(),
+		"httpClientPool": v.clientPool.Stats(),
+		"redisClient":    v.redisPool.Addrs(),
+		"commands":       v.stats.toJSON(),
+		"logSink":        v.sink.Stats(),
+		"logSample":      v.sampler.Stats(),
+		"logLevel":       v.logLevel.String(),
+	}
+	if v.keyspace != nil {
+		o["keyspaceKeys"] = v.keyspace.Len()
+	}
+	return o
+}
@@ -0,0 +1,147 @@
+package vhost
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/dianpeng/moons/alog"
+	"github.com/tidwall/redcon"
+)
+
+// access log provider of the event of a redis connection, the fields of http
+// are not available, the duration is in millisecond
+type logProvider struct {
+	vhost   *VHost
+	conn    redcon.Conn
+	startTs time.Time
+}
+
+func newLogProvider(vhost *VHost, conn redcon.Conn) *logProvider {
+	return &logProvider{
+		vhost:   vhost,
+		conn:    conn,
+		startTs: time.Now(),
+	}
+}
+
+func (l *logProvider) FormatStartTime(
+	fmt string,
+) string {
+	return l.startTs.Format(fmt)
+}
+
+func (l *logProvider) ReqHeaderBytes() (int64, bool) {
+	return 0, false
+}
+
+func (l *logProvider) BytesReceived() (int64, bool) {
+	return 0, false
+}
+
+func (l *logProvider) ResponseHeadersBytes() (int64, bool) {
+	return 0, false
+}
+
+func (l *logProvider) ResponseTrailersBytes() (int64, bool) {
+	return 0, false
+}
+
+func (l *logProvider) BytesSent() (int64, bool) {
+	return 0, false
+}
+
+func (l *logProvider) Duration() (int64, bool) {
+	return int64(time.Since(l.startTs) / time.Millisecond), true
+}
+
+func (l *logProvider) RequestDuration() (int64, bool) {
+	return 0, false
+}
+
+func (l *logProvider) ResponseDuration() (int64, bool) {
+	return 0, false
+}
+
+func (l *logProvider) ConnectionTerminationDetails() (string, bool) {
+	return "", false
+}
+
+func (l *logProvider) ConnectionId() (string, bool) {
+	return strconv.FormatInt(connStateOf(l.conn).info.ID, 10), true
+}
+
+func (l *logProvider) VirtualHost() (string, bool) {
+	return l.vhost.Config.Name, true
+}
+
+func (l *logProvider) RouterInfo(_ alog.FormatParam) (string, bool) {
+	return "", false
+}
+
+func (l *logProvider) Req(_ alog.FormatParam) (string, bool) {
+	return "", false
+}
+
+func (l *logProvider) Resp(_ alog.FormatParam) (string, bool) {
+	return "", false
+}
+
+func (l *logProvider) URI(_ alog.FormatParam) (string, bool) {
+	return "", false
+}
+
+func (l *logProvider) Trailer(_ alog.FormatParam) (string, bool) {
+	return "", false
+}
+
+func (l *logProvider) ResponseCode() (int64, bool) {
+	return 0, false
+}
+
+func (l *logProvider) ResponseCodeDetail() (string, bool) {
+	return "", false
+}
+
+func (l *logProvider) RequestMiddleware(_ alog.FormatParam) (string, bool) {
+	return "", false
+}
+
+func (l *logProvider) ResponseMiddleware(_ alog.FormatParam) (string, bool) {
+	return "", false
+}
+
+func (l *logProvider) ApplicationMiddleware(_ alog.FormatParam) (string, bool) {
+	return "", false
+}
+
+func (l *logProvider) Host() (string, bool) {
+	return "", false
+}
+
+func (l *logProvider) ServiceName() (string, bool) {
+	return l.vhost.Config.Name, true
+}
+
+func (l *logProvider) ClientIp() (string, bool) {
+	addr := l.conn.RemoteAddr()
+	if addr == "" {
+		return "", false
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host, true
+	}
+	return addr, true
+}
+
+func (l *logProvider) Protocol() (string, bool) {
+	return "redis", true
+}
+
+func (l *logProvider) Scheme() (string, bool) {
+	return "", false
+}
+
+func (l *logProvider) RequestId() (string, bool) {
+	return "", false
+}
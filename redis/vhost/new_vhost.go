@@ -5,6 +5,7 @@ import (
 	"github.com/dianpeng/moons/hpl"
 	"github.com/dianpeng/moons/manifest"
 	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/redis/client"
 	"github.com/dianpeng/moons/redis/runtime"
 	"io/fs"
 	"net/http"
@@ -21,6 +22,14 @@ func (c *constHttpClientFactory) GetHttpClient(url string) (hpl.HttpClient, erro
 	}, nil
 }
 
+func (c *constHttpClientFactory) GetRedisClient(_ string) (*client.Client, error) {
+	return nil, fmt.Errorf("redis client is not allowed during initialization")
+}
+
+func (c *constHttpClientFactory) GetKeyspace() client.Backend {
+	return nil
+}
+
 func initmodule(x string, config pl.EvalConfig, fs fs.FS) (*pl.Module, error) {
 	p, err := pl.CompileModule(x, fs)
 	if err != nil {
@@ -72,7 +81,8 @@ func initVHost(
 		config: vhostConfig,
 	}
 
-	p, err := initmodule(string(vhostSource), vhostConfigBuilder, fsp)
+	audit := pl.NewConfigAudit(vhostConfigBuilder)
+	p, err := initmodule(string(vhostSource), audit, fsp)
 	if err != nil {
 		return nil, wrapErr(
 			"redis_vhost",
@@ -82,7 +92,12 @@ func initVHost(
 		)
 	}
 
-	return vhostConfig.Compose(p)
+	vhost, err := vhostConfig.Compose(p)
+	if err != nil {
+		return nil, err
+	}
+	vhost.configAudit = audit.Entries()
+	return vhost, nil
 }
 
 func CreateVHost(
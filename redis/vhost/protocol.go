@@ -0,0 +1,215 @@
+package vhost
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dianpeng/moons/redis/runtime"
+
+	"github.com/tidwall/redcon"
+)
+
+// HELLO, RESET and the CLIENT subcommands of the connection metadata, which are
+// replied by the vhost, and AUTH, see auth.go. HELLO negotiates the protocol, RESP2 or RESP3, and
+// replies the server info as a map. CLIENT ID, SETNAME, GETNAME, INFO and
+// TRACKING work on the runtime.ConnInfo of the connection, which is visible to
+// the rule as the fields of conn, ie conn.proto. The other CLIENT subcommands
+// go to the rules as usual. They are run at once even inside of MULTI.
+
+const (
+	errNoProto     = "NOPROTO unsupported protocol version"
+	errHelloNoAuth = "NOAUTH HELLO must be called with the client already authenticated, " +
+		"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate " +
+		"the client and select the RESP protocol version at the same time"
+)
+
+func (s *serviceHandler) onProtocol(
+	conn redcon.Conn,
+	cmd redcon.Command,
+	name string,
+) bool {
+	switch name {
+	case "AUTH":
+		return s.auth(conn, cmd)
+	case "HELLO":
+		s.hello(conn, cmd)
+		return true
+	case "CLIENT":
+		return s.client(conn, cmd)
+	case "SLOWLOG":
+		return s.slowlog(conn, cmd)
+	case "RESET":
+		s.reset(conn)
+		return true
+	default:
+		return false
+	}
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func (s *serviceHandler) hello(
+	conn redcon.Conn,
+	cmd redcon.Command,
+) {
+	info := connStateOf(conn).info
+	proto := info.Proto
+	setName := ""
+	hasName := false
+	var user, password string
+	hasAuth := false
+
+	args := cmd.Args[1:]
+	if len(args) != 0 {
+		switch string(args[0]) {
+		case "2":
+			proto = runtime.Proto2
+		case "3":
+			proto = runtime.Proto3
+		default:
+			conn.WriteError(errNoProto)
+			return
+		}
+		for i := 1; i < len(args); i++ {
+			switch strings.ToUpper(string(args[i])) {
+			case "AUTH":
+				if i+2 >= len(args) {
+					conn.WriteError(errSyntax)
+					return
+				}
+				user, password = string(args[i+1]), string(args[i+2])
+				hasAuth = true
+				i += 2
+			case "SETNAME":
+				if i+1 == len(args) {
+					conn.WriteError(errSyntax)
+					return
+				}
+				setName = string(args[i+1])
+				hasName = true
+				i++
+			default:
+				conn.WriteError(errSyntax)
+				return
+			}
+		}
+	}
+
+	if hasAuth {
+		if msg := s.authenticate(conn, user, password); msg != "" {
+			conn.WriteError(msg)
+			return
+		}
+	} else if s.authRequired() && connStateOf(conn).acl == nil {
+		conn.WriteError(errHelloNoAuth)
+		return
+	}
+
+	info.Proto = proto
+	if hasName {
+		info.Name = setName
+	}
+
+	runtime.WriteMap(conn, info, 7)
+	conn.WriteBulkString("server")
+	conn.WriteBulkString("moons")
+	conn.WriteBulkString("version")
+	conn.WriteBulkString("7.0.0")
+	conn.WriteBulkString("proto")
+	conn.WriteInt(info.Proto)
+	conn.WriteBulkString("id")
+	conn.WriteInt64(info.ID)
+	conn.WriteBulkString("mode")
+	if s.vhost.cluster != nil {
+		conn.WriteBulkString("cluster")
+	} else {
+		conn.WriteBulkString("standalone")
+	}
+	conn.WriteBulkString("role")
+	conn.WriteBulkString("master")
+	conn.WriteBulkString("modules")
+	conn.WriteArray(0)
+}
+
+// the CLIENT subcommands of the metadata, returns false for the others
+func (s *serviceHandler) client(
+	conn redcon.Conn,
+	cmd redcon.Command,
+) bool {
+	if len(cmd.Args) < 2 {
+		return false
+	}
+	info := connStateOf(conn).info
+	args := cmd.Args[2:]
+
+	switch sub := strings.ToUpper(string(cmd.Args[1])); sub {
+	case "ID":
+		conn.WriteInt64(info.ID)
+
+	case "GETNAME":
+		if info.Name == "" {
+			runtime.WriteNull(conn, info)
+		} else {
+			conn.WriteBulkString(info.Name)
+		}
+
+	case "SETNAME":
+		if len(args) != 1 {
+			conn.WriteError(errArgs("client|setname"))
+		} else if strings.ContainsAny(string(args[0]), " \n") {
+			conn.WriteError("ERR Client names cannot contain spaces, newlines or special characters.")
+		} else {
+			info.Name = string(args[0])
+			conn.WriteString("OK")
+		}
+
+	case "INFO":
+		conn.WriteBulkString(
+			fmt.Sprintf("id=%d addr=%s name=%s user=%s resp=%d tracking=%t\n",
+				info.ID, conn.RemoteAddr(), info.Name, info.User, info.Proto, info.Tracking),
+		)
+
+	// the options of tracking, ie BCAST and PREFIX, are accepted but the
+	// invalidation is pushed by the rule, see conn:invalidate
+	case "TRACKING":
+		if len(args) == 0 {
+			conn.WriteError(errArgs("client|tracking"))
+			break
+		}
+		switch strings.ToUpper(string(args[0])) {
+		case "ON":
+			info.Tracking = true
+			conn.WriteString("OK")
+		case "OFF":
+			info.Tracking = false
+			conn.WriteString("OK")
+		default:
+			conn.WriteError(errSyntax)
+		}
+
+	default:
+		return false
+	}
+	return true
+}
+
+// RESET brings the connection back to the state of its accept, the
+// transaction is discarded, the protocol is RESP2, the name, tracking and
+// ASKING are cleared and the connection has to authenticate again. conn.state
+// of the script is kept
+func (s *serviceHandler) reset(conn redcon.Conn) {
+	state := connStateOf(conn)
+	state.tx = nil
+	state.acl = nil
+	state.asking = false
+
+	info := state.info
+	info.Proto = runtime.Proto2
+	info.Name = ""
+	info.User = ""
+	info.Tracking = false
+	conn.WriteString("RESET")
+}
+
+func errArgs(name string) string {
+	return fmt.Sprintf("ERR wrong number of arguments for '%s' command", name)
+}
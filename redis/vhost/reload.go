@@ -0,0 +1,25 @@
+package vhost
+
+import (
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/server"
+	"github.com/dianpeng/moons/util"
+)
+
+// OnManifestReloaded emits server.ManifestReloadedEvent into the vhost module
+// once the vhost is swapped in by the manifest watcher
+func (x *VHost) OnManifestReloaded(files []string) {
+	if x.Module == nil || !x.Module.HaveEvent(server.ManifestReloadedEvent) {
+		return
+	}
+	context := pl.NewValMap()
+	context.AddMap("vhost", pl.NewValStr(x.Config.Name))
+	context.AddMap("files", pl.NewValStrList(files))
+
+	h := x.getServiceHandler()
+	defer h.finish()
+	if err := h.runtime.OnModuleEvent(server.ManifestReloadedEvent, context, h); err != nil {
+		util.Errorf("redis_vhost %s: event %s failed: %s",
+			x.Config.Name, server.ManifestReloadedEvent, err.Error())
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"github.com/dianpeng/moons/g"
 	"github.com/dianpeng/moons/hpl"
 	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/redis/client"
+	"github.com/dianpeng/moons/redis/datastore"
 	"github.com/dianpeng/moons/redis/runtime"
 	ru "github.com/dianpeng/moons/redis/util"
 	"github.com/dianpeng/moons/util"
@@ -14,6 +16,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -92,6 +95,9 @@ func newServiceHandler(vhost *VHost) *serviceHandler {
 		runtime: runtime.NewRuntimeWithModule(vhost.Module),
 		vhost:   vhost,
 	}
+	h.runtime.Eval.AddCapability(vhost.capability())
+	h.runtime.SetKVNamespace(vhost.Config.Name)
+	h.runtime.SetLogLevel(vhost.logLevel)
 	return h
 }
 
@@ -104,7 +110,19 @@ func (s *serviceHandler) GetHttpClient(url string) (hpl.HttpClient, error) {
 	return &c, nil
 }
 
+func (s *serviceHandler) GetRedisClient(addr string) (*client.Client, error) {
+	return s.vhost.redisPool.Get(addr)
+}
+
+func (s *serviceHandler) GetKeyspace() client.Backend {
+	if s.vhost.keyspace == nil {
+		return nil
+	}
+	return s.vhost.keyspace
+}
+
 func (s *serviceHandler) finish() {
+	s.runtime.Cleanup()
 	if s.activeHttpClient != nil {
 		for _, c := range s.activeHttpClient {
 			s.vhost.clientPool.Put(*c)
@@ -113,6 +131,19 @@ func (s *serviceHandler) finish() {
 	}
 }
 
+// computes the custom fields of the access log of the event and uploads it,
+// the failure of the field is logged and leaves the field null
+func (s *serviceHandler) uploadLog(log *alog.Log, p *logProvider) {
+	if err := hpl.EvalLogFields(
+		s.runtime.Eval,
+		s.vhost.Config.LogFields,
+		log,
+	); err != nil {
+		util.Errorf("redis_vhost %s: %s", s.vhost.Config.Name, err.Error())
+	}
+	s.vhost.uploadLog(log, p)
+}
+
 func (s *serviceHandler) err(
 	c redcon.Conn,
 	event string,
@@ -123,112 +154,207 @@ func (s *serviceHandler) err(
 	)
 }
 
-func (s *serviceHandler) onEmptyCommand(
-	_ redcon.Conn,
-	_ redcon.Command,
+// writes the error of the event as the reply and marks its access log failed
+func (s *serviceHandler) fail(
+	c redcon.Conn,
+	log *alog.Log,
+	event string,
+	err error,
 ) {
+	log.Failed = true
+	s.err(c, event, err)
 }
 
 func (s *serviceHandler) onEvent(
 	conn redcon.Conn,
 	cmd redcon.Command,
 ) {
-	log := alog.NewLog(s.vhost.LogFormat)
+	defer s.finish()
 
+	cmdName := strings.ToUpper(string(cmd.Args[0]))
+	start := time.Now()
 	defer func() {
-		s.vhost.uploadLog(&log, nil)
-		s.finish()
+		s.vhost.stats.record(conn, cmd, cmdName, time.Since(start))
 	}()
 
-	cmdVal := runtime.NewCommandVal(
-		&cmd,
-	)
+	if msg := s.authorize(conn, cmd, cmdName); msg != "" {
+		if tx := connStateOf(conn).tx; tx != nil {
+			tx.aborted = true
+		}
+		conn.WriteError(msg)
+		return
+	}
+	if s.onProtocol(conn, cmd, cmdName) {
+		return
+	}
+	if s.onCluster(conn, cmd, cmdName) {
+		return
+	}
+	if s.onTransaction(conn, cmd, cmdName) {
+		return
+	}
+	s.dispatch(conn, cmd)
+}
 
-	connVal, connStatus := runtime.NewConnectionVal(
-		conn,
-	)
+// events of the command, from the most specific to the wildcard
+func commandEvents(cmdName string) (string, string) {
+	return fmt.Sprintf("redis.%s", cmdName),
+		fmt.Sprintf("redis.:%s", ru.CommandCategoryName(cmdName))
+}
 
-	defer func() {
-		if connStatus.DidWrite() && !connStatus.DidClose() {
-			s.onEmptyCommand(conn, cmd)
-		}
-	}()
+// whether any rule of the module handles the command
+func (s *serviceHandler) handlesByRule(cmdName string) bool {
+	cmdEvent, cmdCatEvent := commandEvents(cmdName)
+	return s.runtime.Module.HaveEvent(cmdEvent) ||
+		s.runtime.Module.HaveEvent(cmdCatEvent) ||
+		s.runtime.Module.HaveEvent(eventCommand)
+}
+
+// whether the command is handled by the rule or by the keyspace
+func (s *serviceHandler) handles(cmdName string) bool {
+	return s.handlesByRule(cmdName) ||
+		(s.vhost.keyspace != nil && datastore.Supports(cmdName))
+}
 
+// runs the command by the rule of the module, the command the rule does not
+// reply is replied by the keyspace if any, the command not supported by the
+// keyspace is replied with the error of unknown command. Returns whether the
+// reply is written
+func (s *serviceHandler) dispatch(
+	conn redcon.Conn,
+	cmd redcon.Command,
+) bool {
 	cmdName := strings.ToUpper(string(cmd.Args[0]))
-	cmdCatEvent := fmt.Sprintf("redis.:%s", ru.CommandCategoryName(cmdName))
-	cmdEvent := fmt.Sprintf("redis.%s", cmdName)
+	cmdEvent, cmdCatEvent := commandEvents(cmdName)
 
-	var err error
+	// 1) highest priority, ie the most specific event trigger
+	// 2) lower priority, ie the command category event trigger
+	// 3) lastly, use wildcard event trigger to capture the event
+	event := eventCommand
+	if s.runtime.Module.HaveEvent(cmdEvent) {
+		event = cmdEvent
+	} else if s.runtime.Module.HaveEvent(cmdCatEvent) {
+		event = cmdCatEvent
+	}
 
-	if err = s.runtime.OnInit(
+	if s.run(conn, event, runtime.NewCommandVal(&cmd)) {
+		return true
+	}
+	if s.vhost.keyspace == nil {
+		return false
+	}
+	s.keyspace(conn, [][]string{commandArgs(cmd)})
+	return true
+}
+
+func commandArgs(cmd redcon.Command) []string {
+	o := make([]string, 0, len(cmd.Args))
+	for _, x := range cmd.Args {
+		o = append(o, string(x))
+	}
+	return o
+}
+
+// runs the commands by the keyspace and writes the reply of the last one
+func (s *serviceHandler) keyspace(conn redcon.Conn, cmds [][]string) {
+	r, err := s.vhost.keyspace.Pipeline(cmds)
+	if err != nil {
+		s.err(conn, "keyspace", err)
+		return
+	}
+	runtime.WriteReply(conn, connStateOf(conn).info, r[len(r)-1])
+}
+
+// runs the event of the connection, returns whether the rule wrote the reply,
+// the error is written as the reply
+func (s *serviceHandler) run(
+	conn redcon.Conn,
+	event string,
+	context pl.Val,
+) bool {
+	log := alog.NewLog(s.vhost.LogFormat)
+	defer s.uploadLog(&log, newLogProvider(s.vhost, conn))
+
+	connVal, connStatus := runtime.NewConnectionVal(
+		conn,
+		connStateOf(conn).info,
+	)
+
+	if err := s.runtime.OnInit(
 		connVal,
 		s,
 		&log,
 	); err != nil {
-		s.err(
+		s.fail(
 			conn,
+			&log,
 			"@init",
 			err,
 		)
-		return
+		return true
 	}
 
-	// 1) highest priority, ie the most specific event trigger
-	if s.runtime.Module.HaveEvent(cmdEvent) {
-		if _, err = s.runtime.Emit(
-			cmdEvent,
-			cmdVal,
-		); err != nil {
-			s.err(
-				conn,
-				cmdEvent,
-				err,
-			)
-		}
-		return
-	}
-
-	// 2) lower priority, ie the command category event trigger
-	if s.runtime.Module.HaveEvent(cmdCatEvent) {
-		if _, err = s.runtime.Emit(
-			cmdCatEvent,
-			cmdVal,
-		); err != nil {
-			s.err(
-				conn,
-				cmdEvent,
-				err,
-			)
-		}
-		return
-	}
-
-	// 3) lastly, use wildcard event trigger to capture the event
-	if _, err = s.runtime.Emit(
-		eventCommand,
-		cmdVal,
+	if _, err := s.runtime.Emit(
+		event,
+		context,
 	); err != nil {
-		s.err(
+		s.fail(
 			conn,
-			cmdEvent,
+			&log,
+			event,
 			err,
 		)
-		return
+		return true
 	}
+	return connStatus.DidWrite()
+}
+
+// runs the event of the connection and returns the value of the rule, the
+// error is returned rather than written
+func (s *serviceHandler) call(
+	conn redcon.Conn,
+	event string,
+	context pl.Val,
+) (pl.Val, error) {
+	log := alog.NewLog(s.vhost.LogFormat)
+	defer s.uploadLog(&log, newLogProvider(s.vhost, conn))
+
+	connVal, _ := runtime.NewConnectionVal(
+		conn,
+		connStateOf(conn).info,
+	)
+	if err := s.runtime.OnInit(
+		connVal,
+		s,
+		&log,
+	); err != nil {
+		log.Failed = true
+		return pl.NewValNull(), err
+	}
+	v, err := s.runtime.Emit(
+		event,
+		context,
+	)
+	if err != nil {
+		log.Failed = true
+	}
+	return v, err
 }
 
 func (s *serviceHandler) onAccept(
 	conn redcon.Conn,
 ) bool {
 	log := alog.NewLog(s.vhost.LogFormat)
+	logP := newLogProvider(s.vhost, conn)
 
 	defer func() {
-		s.vhost.uploadLog(&log, nil)
+		s.uploadLog(&log, logP)
 		s.finish()
 	}()
 
 	connVal, _ := runtime.NewConnectionVal(
 		conn,
+		connStateOf(conn).info,
 	)
 
 	var err error
@@ -238,8 +364,9 @@ func (s *serviceHandler) onAccept(
 		s,
 		&log,
 	); err != nil {
-		s.err(
+		s.fail(
 			conn,
+			&log,
 			"@init",
 			err,
 		)
@@ -250,8 +377,9 @@ func (s *serviceHandler) onAccept(
 		eventAccept,
 		pl.NewValNull(),
 	); err != nil {
-		s.err(
+		s.fail(
 			conn,
+			&log,
 			eventAccept,
 			err,
 		)
@@ -270,14 +398,19 @@ func (s *serviceHandler) onClose(
 	connErr error,
 ) {
 	log := alog.NewLog(s.vhost.LogFormat)
+	logP := newLogProvider(s.vhost, conn)
 
 	defer func() {
-		s.vhost.uploadLog(&log, nil)
+		s.uploadLog(&log, logP)
 		s.finish()
+
+		// the state of the connection, ie conn.state, ends with the close
+		conn.SetContext(nil)
 	}()
 
 	connVal, _ := runtime.NewConnectionVal(
 		conn,
+		connStateOf(conn).info,
 	)
 
 	var err error
@@ -291,8 +424,9 @@ func (s *serviceHandler) onClose(
 		s,
 		&log,
 	); err != nil {
-		s.err(
+		s.fail(
 			conn,
+			&log,
 			"@init",
 			err,
 		)
@@ -303,8 +437,9 @@ func (s *serviceHandler) onClose(
 		eventClose,
 		ctx,
 	); err != nil {
-		s.err(
+		s.fail(
 			conn,
+			&log,
 			eventClose,
 			err,
 		)
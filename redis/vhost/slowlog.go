@@ -0,0 +1,261 @@
+package vhost
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ru "github.com/dianpeng/moons/redis/util"
+
+	"github.com/tidwall/redcon"
+)
+
+// Latency of the commands of the vhost. Each command is timed from its
+// arrival to the end of its reply, including the rules run by it, and is
+// counted into the histogram of its category, ie string or hash. The command
+// slower than .slowlog_log_slower_than, in microseconds, is kept in the
+// slowlog, a ring of the last .slowlog_max_len entries, which is read by
+// SLOWLOG GET, LEN and RESET as redis does. The negative threshold turns the
+// slowlog off. Both are visible in the metrics of the vhost.
+
+const (
+	slowlogMaxArgc   = 32
+	slowlogMaxArgLen = 128
+)
+
+// upper bound of the buckets in microseconds, the last bucket is +Inf
+var latencyBuckets = []int64{
+	100, 250, 500, 1000, 2500, 5000, 10000, 25000, 50000, 100000, 250000, 500000, 1000000,
+}
+
+type latencyHistogram struct {
+	count   int64
+	sum     int64
+	buckets []int64
+}
+
+func (h *latencyHistogram) add(us int64) {
+	h.count++
+	h.sum += us
+	for i, b := range latencyBuckets {
+		if us <= b {
+			h.buckets[i]++
+			return
+		}
+	}
+}
+
+// the buckets are cumulative, ie the count of the command not slower than
+// the bound
+func (h *latencyHistogram) toJSON() interface{} {
+	buckets := map[string]int64{}
+	n := int64(0)
+	for i, b := range latencyBuckets {
+		n += h.buckets[i]
+		buckets[strconv.FormatInt(b, 10)] = n
+	}
+	buckets["+Inf"] = h.count
+	return map[string]interface{}{
+		"count":   h.count,
+		"sum":     h.sum,
+		"buckets": buckets,
+	}
+}
+
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration int64
+	args     []string
+	addr     string
+	name     string
+}
+
+type commandStats struct {
+	sync.Mutex
+	category map[string]*latencyHistogram
+
+	threshold int64
+	maxLen    int
+	slowlog   []slowlogEntry
+	head      int
+	nextID    int64
+}
+
+func newCommandStats(threshold int64, maxLen int) *commandStats {
+	return &commandStats{
+		category:  make(map[string]*latencyHistogram),
+		threshold: threshold,
+		maxLen:    maxLen,
+	}
+}
+
+func slowlogArgs(args [][]byte) []string {
+	n := len(args)
+	if n > slowlogMaxArgc {
+		n = slowlogMaxArgc - 1
+	}
+	o := make([]string, 0, n+1)
+	for _, x := range args[:n] {
+		if len(x) > slowlogMaxArgLen {
+			o = append(o, string(x[:slowlogMaxArgLen])+
+				"... ("+strconv.Itoa(len(x)-slowlogMaxArgLen)+" more bytes)")
+		} else {
+			o = append(o, string(x))
+		}
+	}
+	if n != len(args) {
+		o = append(o, "... ("+strconv.Itoa(len(args)-n)+" more arguments)")
+	}
+	return o
+}
+
+func (s *commandStats) record(
+	conn redcon.Conn,
+	cmd redcon.Command,
+	name string,
+	elapsed time.Duration,
+) {
+	us := int64(elapsed / time.Microsecond)
+	category := ru.CommandCategoryName(name)
+
+	s.Lock()
+	defer s.Unlock()
+
+	h, ok := s.category[category]
+	if !ok {
+		h = &latencyHistogram{
+			buckets: make([]int64, len(latencyBuckets)),
+		}
+		s.category[category] = h
+	}
+	h.add(us)
+
+	if s.threshold < 0 || us < s.threshold || s.maxLen <= 0 {
+		return
+	}
+	e := slowlogEntry{
+		id:       s.nextID,
+		time:     time.Now(),
+		duration: us,
+		args:     slowlogArgs(cmd.Args),
+		addr:     conn.RemoteAddr(),
+		name:     connStateOf(conn).info.Name,
+	}
+	s.nextID++
+	if len(s.slowlog) < s.maxLen {
+		s.slowlog = append(s.slowlog, e)
+	} else {
+		s.slowlog[s.head] = e
+		s.head = (s.head + 1) % s.maxLen
+	}
+}
+
+// entries of the slowlog from the newest, at most n of them, n < 0 for all
+func (s *commandStats) entries(n int) []slowlogEntry {
+	s.Lock()
+	defer s.Unlock()
+	size := len(s.slowlog)
+	if n < 0 || n > size {
+		n = size
+	}
+	o := make([]slowlogEntry, 0, n)
+	for i := 0; i < n; i++ {
+		o = append(o, s.slowlog[(s.head+size-1-i)%size])
+	}
+	return o
+}
+
+func (s *commandStats) length() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.slowlog)
+}
+
+func (s *commandStats) reset() {
+	s.Lock()
+	defer s.Unlock()
+	s.slowlog = nil
+	s.head = 0
+}
+
+func (s *commandStats) toJSON() interface{} {
+	latency := map[string]interface{}{}
+	s.Lock()
+	for k, v := range s.category {
+		latency[k] = v.toJSON()
+	}
+	s.Unlock()
+
+	slowlog := []interface{}{}
+	for _, e := range s.entries(-1) {
+		slowlog = append(slowlog, map[string]interface{}{
+			"id":       e.id,
+			"time":     e.time.Unix(),
+			"duration": e.duration,
+			"args":     e.args,
+			"addr":     e.addr,
+			"name":     e.name,
+		})
+	}
+	return map[string]interface{}{
+		"latency": latency,
+		"slowlog": slowlog,
+	}
+}
+
+// SLOWLOG GET [count], LEN and RESET, the other subcommands go to the rules
+func (s *serviceHandler) slowlog(
+	conn redcon.Conn,
+	cmd redcon.Command,
+) bool {
+	if len(cmd.Args) < 2 {
+		return false
+	}
+	stats := s.vhost.stats
+
+	switch strings.ToUpper(string(cmd.Args[1])) {
+	case "GET":
+		n := 10
+		switch len(cmd.Args) {
+		case 2:
+			break
+		case 3:
+			v, err := strconv.Atoi(string(cmd.Args[2]))
+			if err != nil || v < -1 {
+				conn.WriteError("ERR count should be greater than or equal to -1")
+				return true
+			}
+			n = v
+		default:
+			conn.WriteError(errArgs("slowlog|get"))
+			return true
+		}
+		entries := stats.entries(n)
+		conn.WriteArray(len(entries))
+		for _, e := range entries {
+			conn.WriteArray(6)
+			conn.WriteInt64(e.id)
+			conn.WriteInt64(e.time.Unix())
+			conn.WriteInt64(e.duration)
+			conn.WriteArray(len(e.args))
+			for _, x := range e.args {
+				conn.WriteBulkString(x)
+			}
+			conn.WriteBulkString(e.addr)
+			conn.WriteBulkString(e.name)
+		}
+
+	case "LEN":
+		conn.WriteInt(stats.length())
+
+	case "RESET":
+		stats.reset()
+		conn.WriteString("OK")
+
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package vhost
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/redis/runtime"
+
+	"github.com/tidwall/redcon"
+)
+
+// MULTI/EXEC/DISCARD of the connection. The commands after MULTI are queued
+// and replied QUEUED, the command which cannot be queued, ie no rule of the
+// module handles it, is replied with an error and aborts the transaction so
+// its EXEC is replied EXECABORT. EXEC delivers the whole transaction to the
+// module as one event, eventExec, whose context is the list of the queued
+// commands, and the rule writes the reply of EXEC. Without the rule, each queued command is run as if
+// it is sent alone and EXEC is replied with the array of their replies.
+//
+// With the keyspace, the transaction whose commands are not handled by any
+// rule runs by the keyspace as one MULTI/EXEC, so it is atomic.
+//
+// WATCH is not supported.
+
+const (
+	eventExec = "redis.:exec"
+
+	errMultiNested  = "ERR MULTI calls can not be nested"
+	errExecNoMulti  = "ERR EXEC without MULTI"
+	errDiscNoMulti  = "ERR DISCARD without MULTI"
+	errExecAbort    = "EXECABORT Transaction discarded because of previous errors."
+	errWatchInMulti = "ERR WATCH inside MULTI is not allowed"
+	errSyntax       = "ERR syntax error"
+)
+
+type transaction struct {
+	queued  []redcon.Command
+	aborted bool
+}
+
+// state of the connection kept as the context of redcon.Conn
+type connState struct {
+	tx   *transaction
+	info *runtime.ConnInfo
+
+	// ACL of the authenticated identity, nil if not authenticated
+	acl *acl
+
+	// ASKING is sent, the next command is not redirected
+	asking bool
+}
+
+var connID int64
+
+func connStateOf(conn redcon.Conn) *connState {
+	if x, ok := conn.Context().(*connState); ok {
+		return x
+	}
+	x := &connState{
+		info: runtime.NewConnInfo(atomic.AddInt64(&connID, 1)),
+	}
+	conn.SetContext(x)
+	return x
+}
+
+// the arguments of the command refer to the read buffer of the connection,
+// which is reused by the next command
+func copyCommand(cmd redcon.Command) redcon.Command {
+	o := redcon.Command{
+		Raw:  append([]byte{}, cmd.Raw...),
+		Args: make([][]byte, 0, len(cmd.Args)),
+	}
+	for _, x := range cmd.Args {
+		o.Args = append(o.Args, append([]byte{}, x...))
+	}
+	return o
+}
+
+// handles the command of the transaction, returns false if the command is not
+// part of any transaction and is run as usual
+func (s *serviceHandler) onTransaction(
+	conn redcon.Conn,
+	cmd redcon.Command,
+	name string,
+) bool {
+	state := connStateOf(conn)
+
+	switch name {
+	case "MULTI":
+		if state.tx != nil {
+			conn.WriteError(errMultiNested)
+			return true
+		}
+		state.tx = &transaction{}
+		conn.WriteString("OK")
+		return true
+
+	case "DISCARD":
+		if state.tx == nil {
+			conn.WriteError(errDiscNoMulti)
+			return true
+		}
+		state.tx = nil
+		conn.WriteString("OK")
+		return true
+
+	case "EXEC":
+		tx := state.tx
+		if tx == nil {
+			conn.WriteError(errExecNoMulti)
+			return true
+		}
+		state.tx = nil
+		if tx.aborted {
+			conn.WriteError(errExecAbort)
+			return true
+		}
+		s.exec(conn, tx)
+		return true
+
+	default:
+		break
+	}
+
+	tx := state.tx
+	if tx == nil {
+		return false
+	}
+	if name == "WATCH" {
+		conn.WriteError(errWatchInMulti)
+		return true
+	}
+	if !s.handles(name) {
+		tx.aborted = true
+		conn.WriteError("ERR unknown command '" + string(cmd.Args[0]) + "'")
+		return true
+	}
+	tx.queued = append(tx.queued, copyCommand(cmd))
+	conn.WriteString("QUEUED")
+	return true
+}
+
+func (s *serviceHandler) exec(
+	conn redcon.Conn,
+	tx *transaction,
+) {
+	if s.runtime.Module.HaveEvent(eventExec) {
+		list := pl.NewValList()
+		for i := range tx.queued {
+			list.AddList(runtime.NewCommandVal(&tx.queued[i]))
+		}
+		s.run(conn, eventExec, list)
+		return
+	}
+
+	// the transaction no rule takes part in runs by the keyspace atomically
+	if s.vhost.keyspace != nil {
+		byRule := false
+		cmds := [][]string{{"MULTI"}}
+		for _, cmd := range tx.queued {
+			byRule = byRule || s.handlesByRule(strings.ToUpper(string(cmd.Args[0])))
+			cmds = append(cmds, commandArgs(cmd))
+		}
+		if !byRule {
+			s.keyspace(conn, append(cmds, []string{"EXEC"}))
+			return
+		}
+	}
+
+	conn.WriteArray(len(tx.queued))
+	for _, cmd := range tx.queued {
+		if !s.dispatch(conn, cmd) {
+			conn.WriteNull()
+		}
+	}
+}
@@ -2,6 +2,9 @@ package vhost
 
 import (
 	"fmt"
+
+	"github.com/dianpeng/moons/alog"
+	"github.com/dianpeng/moons/hpl"
 	"github.com/dianpeng/moons/pl"
 )
 
@@ -43,3 +46,46 @@ func propSetInt64(
 	*ptr = v.Int()
 	return nil
 }
+
+// capabilities granted to the evaluators of the vhost, see pl.CapabilityEnv
+func (x *VHost) capability() int {
+	if x.Config.AllowEnv {
+		return pl.CapabilityEnv
+	}
+	return 0
+}
+
+func propSetBool(
+	v pl.Val,
+	ptr *bool,
+	name string,
+) error {
+	if !v.IsBool() {
+		return fmt.Errorf("%s: set field error, value is not bool", name)
+	}
+
+	*ptr = v.Bool()
+	return nil
+}
+
+// accepts one access log sink map or list of them, see hpl.NewSinkOptionFromVal
+func propSetLogSink(
+	v pl.Val,
+	ptr *[]*alog.SinkOption,
+	name string,
+) error {
+	list := []pl.Val{v}
+	if v.IsList() {
+		list = v.List().Data
+	}
+	o := []*alog.SinkOption{}
+	for _, x := range list {
+		sink, err := hpl.NewSinkOptionFromVal(x)
+		if err != nil {
+			return fmt.Errorf("%s: set field error, %s", name, err.Error())
+		}
+		o = append(o, sink)
+	}
+	*ptr = o
+	return nil
+}
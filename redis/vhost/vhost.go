@@ -2,11 +2,15 @@ package vhost
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/dianpeng/moons/alog"
 	"github.com/dianpeng/moons/g"
+	"github.com/dianpeng/moons/hpl"
 	"github.com/dianpeng/moons/manifest"
 	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/redis/client"
+	"github.com/dianpeng/moons/redis/datastore"
 	"github.com/dianpeng/moons/server"
 	"github.com/dianpeng/moons/util"
 	"github.com/tidwall/redcon"
@@ -19,10 +23,42 @@ type VHostConfig struct {
 	Listener  string
 	LogFormat string
 
+	// whether script is allowed to access process environment via env::
+	AllowEnv bool
+
+	// encoding of the access log, text, json or logfmt, and the custom fields
+	// computed at the end of the event, see alog/structured.go
+	LogEncoding string
+	LogFields   []hpl.LogField
+
+	// sinks the access log is shipped to, see alog/sink.go
+	LogSink []*alog.SinkOption
+
+	// sampling of the access log, nil keeps all the records, and the initial
+	// level of the log:: functions, see alog/sample.go and alog/level.go
+	LogSample *alog.SampleOption
+	LogLevel  string
+
 	SessionCacheSize           int
 	HttpClientPoolMaxSize      int64
 	HttpClientPoolTimeout      int64
 	HttpClientPoolMaxDrainSize int64
+
+	// clients of redis::client, the size is per backend address
+	RedisClientPoolSize int
+	RedisClientTimeout  int64
+
+	// in-memory keyspace replying the commands no rule replies, see
+	// redis/datastore
+	Keyspace        bool
+	KeyspaceMaxKeys int
+
+	// slot map of redis cluster, see cluster.go
+	Cluster []ClusterNode
+
+	// slowlog, see slowlog.go, the threshold is in microseconds
+	SlowlogLogSlowerThan int64
+	SlowlogMaxLen        int
 }
 
 type VHost struct {
@@ -30,7 +66,17 @@ type VHost struct {
 	Module      *pl.Module
 	LogFormat   *alog.Format
 	clientPool  *util.HClientPool
+	redisPool   *client.Pool
+	keyspace    *datastore.Store
+	cluster     *clusterMap
+	stats       *commandStats
+	sink        alog.SinkGroup
+	sampler     *alog.Sampler
+	logLevel    *alog.Level
 	servicePool servicePool
+
+	// calls made by the config phase of the vhost module
+	configAudit []pl.ConfigAuditEntry
 }
 
 type VHostConfigBuilder struct {
@@ -38,7 +84,22 @@ type VHostConfigBuilder struct {
 	config     *VHostConfig
 }
 
-func (x *VHost) uploadLog(_ *alog.Log, _ alog.Provider) {
+// uploads the record kept by the sampler, redis has no trigger header
+func (x *VHost) uploadLog(l *alog.Log, p alog.Provider) {
+	if x.sampler.Keep(l, false) {
+		x.sink.Upload(l, p)
+	}
+}
+
+// LogLevel is the level of the log:: functions of the vhost
+func (x *VHost) LogLevel() string {
+	return x.logLevel.String()
+}
+
+// SetLogLevel changes the level of the log:: functions, the level is back to
+// the one of the config once the vhost is reloaded
+func (x *VHost) SetLogLevel(name string) error {
+	return x.logLevel.Set(name)
 }
 
 func (x *VHost) OnAccept(
@@ -64,6 +125,30 @@ func (x *VHost) OnClose(
 	handler.onClose(conn, err)
 }
 
+// Retire closes the redis client pool and the access log sinks once the
+// commands in flight have had the time to finish on the handler they took
+func (x *VHost) Retire() {
+	time.AfterFunc(g.ShutdownTimeout*time.Second, func() {
+		x.redisPool.Close()
+		x.sink.Close()
+	})
+}
+
+// Shutdown runs the @shutdown rule of the vhost module, the failure is only
+// logged since the vhost is going away anyway
+func (x *VHost) Shutdown() {
+	defer x.sink.Close()
+	defer x.redisPool.Close()
+	if x.Module == nil || !x.Module.HaveEvent(pl.ShutdownRule) {
+		return
+	}
+	h := x.getServiceHandler()
+	defer h.finish()
+	if err := h.runtime.OnShutdown(h); err != nil {
+		util.Errorf("redis_vhost %s: %s failed: %s", x.Config.Name, pl.ShutdownRule, err.Error())
+	}
+}
+
 func (x *VHost) getServiceHandler() *serviceHandler {
 	h := x.servicePool.get()
 	if h != nil {
@@ -105,7 +190,7 @@ func (config *VHostConfig) Compose(p *pl.Module) (*VHost, error) {
 			g.VHostLogFormat,
 		)
 
-		logf, err := alog.CompileFormat(logFormat)
+		logf, err := alog.CompileFormatEncoding(logFormat, config.LogEncoding)
 		if err != nil {
 			return nil, err
 		}
@@ -121,6 +206,62 @@ func (config *VHostConfig) Compose(p *pl.Module) (*VHost, error) {
 		util.NotZeroInt64(config.HttpClientPoolMaxDrainSize, g.VHostHttpClientPoolMaxDrainSize),
 	)
 
+	timeout := time.Duration(
+		util.NotZeroInt64(config.RedisClientTimeout, g.VHostRedisClientTimeout),
+	) * time.Second
+	poolSize := config.RedisClientPoolSize
+	if poolSize == 0 {
+		poolSize = g.VHostRedisClientPoolSize
+	}
+	vhost.redisPool = client.NewPool(&client.Option{
+		PoolSize:     poolSize,
+		PoolTimeout:  timeout,
+		DialTimeout:  timeout,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	})
+
+	if len(config.Cluster) != 0 {
+		c, err := newClusterMap(config.Cluster)
+		if err != nil {
+			return nil, err
+		}
+		vhost.cluster = c
+	}
+
+	level, err := alog.NewLevel(config.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	vhost.logLevel = level
+
+	if config.LogSample != nil {
+		sampler, err := alog.NewSampler(config.LogSample)
+		if err != nil {
+			return nil, err
+		}
+		vhost.sampler = sampler
+	}
+
+	sink, err := alog.NewSinkGroup(config.LogSink)
+	if err != nil {
+		return nil, err
+	}
+	vhost.sink = sink
+
+	slowlogMaxLen := config.SlowlogMaxLen
+	if slowlogMaxLen == 0 {
+		slowlogMaxLen = g.VHostRedisSlowlogMaxLen
+	}
+	vhost.stats = newCommandStats(
+		util.NotZeroInt64(config.SlowlogLogSlowerThan, g.VHostRedisSlowlogLogSlowerThan),
+		slowlogMaxLen,
+	)
+
+	if config.Keyspace {
+		vhost.keyspace = datastore.Get(config.Name, config.KeyspaceMaxKeys)
+	}
+
 	vhost.servicePool = newServicePool(
 		int(config.SessionCacheSize),
 	)
@@ -190,6 +331,50 @@ func (x *VHostConfigBuilder) ConfigProperty(
 			"redis_vhost.LogFormat",
 		)
 
+	case "log_encoding":
+		return propSetString(
+			value,
+			&x.config.LogEncoding,
+			"redis_vhost.LogEncoding",
+		)
+
+	case "log_fields":
+		f, err := hpl.ParseLogFields(value, "redis_vhost.log_fields")
+		if err != nil {
+			return err
+		}
+		x.config.LogFields = f
+		return nil
+
+	case "allow_env":
+		return propSetBool(
+			value,
+			&x.config.AllowEnv,
+			"redis_vhost.allow_env",
+		)
+
+	case "log_sink":
+		return propSetLogSink(
+			value,
+			&x.config.LogSink,
+			"redis_vhost.log_sink",
+		)
+
+	case "log_sample":
+		option, err := hpl.NewSampleOptionFromVal(value)
+		if err != nil {
+			return fmt.Errorf("redis_vhost.log_sample: set field error, %s", err.Error())
+		}
+		x.config.LogSample = option
+		return nil
+
+	case "log_level":
+		return propSetString(
+			value,
+			&x.config.LogLevel,
+			"redis_vhost.log_level",
+		)
+
 	case "session_cache_size":
 		return propSetInt(
 			value,
@@ -218,6 +403,56 @@ func (x *VHostConfigBuilder) ConfigProperty(
 			"redis_vhost.HttpClientPoolMaxDrainSize",
 		)
 
+	case "redis_client_pool_size":
+		return propSetInt(
+			value,
+			&x.config.RedisClientPoolSize,
+			"redis_vhost.RedisClientPoolSize",
+		)
+
+	case "redis_client_timeout":
+		return propSetInt64(
+			value,
+			&x.config.RedisClientTimeout,
+			"redis_vhost.RedisClientTimeout",
+		)
+
+	case "keyspace":
+		return propSetBool(
+			value,
+			&x.config.Keyspace,
+			"redis_vhost.Keyspace",
+		)
+
+	case "keyspace_max_keys":
+		return propSetInt(
+			value,
+			&x.config.KeyspaceMaxKeys,
+			"redis_vhost.KeyspaceMaxKeys",
+		)
+
+	case "cluster":
+		c, err := parseClusterConfig(value)
+		if err != nil {
+			return err
+		}
+		x.config.Cluster = c
+		return nil
+
+	case "slowlog_log_slower_than":
+		return propSetInt64(
+			value,
+			&x.config.SlowlogLogSlowerThan,
+			"redis_vhost.SlowlogLogSlowerThan",
+		)
+
+	case "slowlog_max_len":
+		return propSetInt(
+			value,
+			&x.config.SlowlogMaxLen,
+			"redis_vhost.SlowlogMaxLen",
+		)
+
 	default:
 		break
 	}
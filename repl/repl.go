@@ -0,0 +1,481 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dianpeng/moons/manifest"
+	"github.com/dianpeng/moons/pl"
+	"github.com/dianpeng/moons/pltest"
+)
+
+// Interactive shell of PL for debugging the policies. The definitions entered,
+// ie fn, rule, test and the global scope, are kept, and any other input is an
+// expression or statements compiled along with them into a rule and run at
+// once, the value of the expression is printed. The variables assigned without
+// let, ie x = 1, are kept by the context across the inputs, so they form the
+// session of the shell. The global and session scopes are evaluated again for
+// every input since the input is compiled into a new module.
+//
+// The input continues on the next line until the brackets are balanced. The
+// commands start with colon, see :help.
+
+// name of the rule the input is compiled into
+const inputRule = "[repl]"
+
+const help = `:help                      prints this help
+:quit                      leaves the shell
+:load source [file]        loads the main file, or the file of the name, of the manifest
+:reset                     forgets the definitions, the variables and the loaded module
+:defs                      prints the definitions
+:vars                      prints the variables
+:rules                     lists the rules, the functions and the tests
+:type expr                 prints the type of the expression
+:disasm [name|expr]        prints the bytecode of the function, the rule, the test or the expression
+:emit rule [expr]          runs the rule with the expression as event, ie $
+:test [name]               runs the tests
+`
+
+type REPL struct {
+	out io.Writer
+
+	// import, global and session scopes, which must be at the top
+	header []string
+	defs   []string
+
+	// the loaded module and the fs of its manifest
+	base     string
+	baseName string
+	fs       fs.FS
+
+	ctx    *pltest.Context
+	config *pltest.Config
+	eval   *pl.Evaluator
+}
+
+func New(out io.Writer) *REPL {
+	r := &REPL{
+		out: out,
+	}
+	r.Reset()
+	return r
+}
+
+// Reset forgets everything entered and loaded so far
+func (r *REPL) Reset() {
+	r.header = nil
+	r.defs = nil
+	r.base = ""
+	r.baseName = ""
+	r.fs = nil
+	r.config = pltest.NewConfig()
+	r.ctx = pltest.NewContext(nil, r.config)
+	r.eval = pl.NewEvaluator(r.ctx, r.config)
+}
+
+func (r *REPL) source(extra string) string {
+	o := []string{}
+	o = append(o, r.header...)
+	if r.base != "" {
+		o = append(o, r.base)
+	}
+	o = append(o, r.defs...)
+	if extra != "" {
+		o = append(o, extra)
+	}
+	return strings.Join(o, "\n")
+}
+
+// compiles the definitions and the extra code, then evaluates the global and
+// session scopes of the module
+func (r *REPL) compile(extra string) (*pl.Module, error) {
+	m, err := pl.CompileModule(r.source(extra), r.fs)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.eval.EvalGlobal(m); err != nil {
+		return nil, err
+	}
+	if err := r.eval.EvalSession(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Load loads the main file of the manifest of the source, or the file of the
+// name, ie a service file, as the module the inputs are compiled along with.
+// The definitions entered so far are kept
+func (r *REPL) Load(source string, file string) error {
+	m, err := manifest.NewManifestFromSource(source, "http")
+	if err != nil {
+		return err
+	}
+	if file == "" {
+		file = m.Main
+	}
+	data, err := fs.ReadFile(m.FS, file)
+	if err != nil {
+		return err
+	}
+
+	oldBase, oldName, oldFS := r.base, r.baseName, r.fs
+	r.base, r.baseName, r.fs = string(data), file, m.FS
+	module, err := r.compile("")
+	if err == nil {
+		err = r.evalConfig(module)
+	}
+	if err != nil {
+		r.base, r.baseName, r.fs = oldBase, oldName, oldFS
+		return err
+	}
+	return nil
+}
+
+// the config block is evaluated against the mock config once loaded, so the
+// calls can be checked by mock::config_calls()
+func (r *REPL) evalConfig(m *pl.Module) error {
+	config := pltest.NewConfig()
+	r.config.ConfigAudit = config.ConfigAudit
+	return r.eval.EvalConfig(m)
+}
+
+func firstWord(input string) string {
+	i := strings.IndexFunc(input, func(c rune) bool {
+		return !(c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9'))
+	})
+	if i < 0 {
+		return input
+	}
+	return input[:i]
+}
+
+// whether the input is a definition, and whether it must be at the top
+func definition(input string) (bool, bool) {
+	switch firstWord(input) {
+	case "import", "global", "session":
+		return true, true
+	case "fn", "iter", "rule", "config":
+		return true, false
+	case "test":
+		// test "name" { ... } only, test alone is the rule shorthand
+		rest := strings.TrimSpace(input[len("test"):])
+		return strings.HasPrefix(rest, "\"") || strings.HasPrefix(rest, "'") || strings.HasPrefix(rest, "{"), false
+	default:
+		return false, false
+	}
+}
+
+func (r *REPL) define(input string, top bool) error {
+	if top {
+		r.header = append(r.header, input)
+	} else {
+		r.defs = append(r.defs, input)
+	}
+	m, err := r.compile("")
+	if err == nil && strings.HasPrefix(input, "config") {
+		err = r.evalConfig(m)
+	}
+	if err != nil {
+		if top {
+			r.header = r.header[:len(r.header)-1]
+		} else {
+			r.defs = r.defs[:len(r.defs)-1]
+		}
+	}
+	return err
+}
+
+func exprRule(input string) string {
+	return fmt.Sprintf("rule %q {\nreturn (\n%s\n);\n}", inputRule, strings.TrimSuffix(input, ";"))
+}
+
+// the semicolon of the last statement may be omitted, ie x = 1
+func stmtRule(input string) string {
+	if !strings.HasSuffix(input, ";") && !strings.HasSuffix(input, "}") {
+		input += ";"
+	}
+	return fmt.Sprintf("rule %q {\n%s\n}", inputRule, input)
+}
+
+// compiles the input as an expression, or as statements if it is not one
+func (r *REPL) compileInput(input string) (*pl.Module, bool, error) {
+	if m, err := r.compile(exprRule(input)); err == nil {
+		return m, true, nil
+	}
+	m, err := r.compile(stmtRule(input))
+	return m, false, err
+}
+
+func (r *REPL) evalExpr(input string) (pl.Val, error) {
+	m, err := r.compile(exprRule(input))
+	if err != nil {
+		return pl.NewValNull(), err
+	}
+	return r.eval.Eval(inputRule, m)
+}
+
+// Show formats the value for printing, JSON if the value can be converted
+func Show(v pl.Val) string {
+	if str, err := v.ToJSONString(); err == nil {
+		return str
+	}
+	return v.Info()
+}
+
+func (r *REPL) printf(format string, args ...interface{}) {
+	fmt.Fprintf(r.out, format, args...)
+}
+
+// Eval handles one complete input, ie a definition, an expression, statements
+// or a command. False is returned once the shell should quit
+func (r *REPL) Eval(input string) (bool, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return true, nil
+	}
+	if strings.HasPrefix(input, ":") {
+		return r.command(input)
+	}
+
+	if ok, top := definition(input); ok {
+		return true, r.define(input, top)
+	}
+
+	m, expr, err := r.compileInput(input)
+	if err != nil {
+		return true, err
+	}
+	v, err := r.eval.Eval(inputRule, m)
+	if err != nil {
+		return true, err
+	}
+	if expr {
+		r.printf("%s\n", Show(v))
+	}
+	return true, nil
+}
+
+func (r *REPL) module() (*pl.Module, error) {
+	return r.compile("")
+}
+
+func (r *REPL) command(input string) (bool, error) {
+	name := firstWord(input[1:])
+	arg := strings.TrimSpace(input[1+len(name):])
+
+	switch name {
+	case "help", "h":
+		r.printf("%s", help)
+
+	case "quit", "q":
+		return false, nil
+
+	case "reset":
+		r.Reset()
+
+	case "load":
+		args := strings.Fields(arg)
+		if len(args) == 0 || len(args) > 2 {
+			return true, fmt.Errorf(":load source [file]")
+		}
+		file := ""
+		if len(args) == 2 {
+			file = args[1]
+		}
+		if err := r.Load(args[0], file); err != nil {
+			return true, err
+		}
+		r.printf("loaded %s\n", r.baseName)
+
+	case "defs":
+		r.printf("%s\n", r.source(""))
+
+	case "vars":
+		keys := []string{}
+		for k := range r.ctx.Vars {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			r.printf("%s = %s\n", k, Show(r.ctx.Vars[k]))
+		}
+
+	case "rules":
+		m, err := r.module()
+		if err != nil {
+			return true, err
+		}
+		r.printf("rules: %s\n", strings.Join(m.Rules(), ", "))
+		r.printf("functions: %s\n", strings.Join(m.Functions(), ", "))
+		r.printf("tests: %s\n", strings.Join(m.Tests(), ", "))
+
+	case "type":
+		if arg == "" {
+			return true, fmt.Errorf(":type expr")
+		}
+		v, err := r.evalExpr(arg)
+		if err != nil {
+			return true, err
+		}
+		r.printf("%s\n", v.Id())
+
+	case "disasm":
+		return true, r.disasm(arg)
+
+	case "emit":
+		return true, r.emit(arg)
+
+	case "test":
+		return true, r.test(arg)
+
+	default:
+		return true, fmt.Errorf("unknown command :%s, see :help", name)
+	}
+	return true, nil
+}
+
+func (r *REPL) disasm(arg string) error {
+	m, err := r.module()
+	if err != nil {
+		return err
+	}
+	if arg == "" {
+		r.printf("%s", m.Dump())
+		return nil
+	}
+	if code, ok := m.Disasm(arg); ok {
+		r.printf("%s", code)
+		return nil
+	}
+
+	m, _, err = r.compileInput(arg)
+	if err != nil {
+		return err
+	}
+	code, _ := m.Disasm(inputRule)
+	r.printf("%s", code)
+	return nil
+}
+
+// runs the rule with the synthetic event, ie :emit auth {"user": "a"}
+func (r *REPL) emit(arg string) error {
+	rule := firstWord(arg)
+	if rule == "" {
+		return fmt.Errorf(":emit rule [expr]")
+	}
+	event := pl.NewValNull()
+	if expr := strings.TrimSpace(arg[len(rule):]); expr != "" {
+		v, err := r.evalExpr(expr)
+		if err != nil {
+			return err
+		}
+		event = v
+	}
+
+	m, err := r.module()
+	if err != nil {
+		return err
+	}
+	if !m.HaveEvent(rule) {
+		return fmt.Errorf("rule %s is not found", rule)
+	}
+	r.ctx.Actions = nil
+	v, err := r.eval.EvalWithContext(rule, event, m)
+	if err != nil {
+		return err
+	}
+	for _, x := range r.ctx.Actions {
+		r.printf("action %s => %s\n", x.Name, Show(x.Val))
+	}
+	r.printf("%s\n", Show(v))
+	return nil
+}
+
+// runs the tests, or the test of the name, against the fake clock of the
+// runner rather than the context of the shell
+func (r *REPL) test(arg string) error {
+	m, err := pl.CompileModule(r.source(""), r.fs)
+	if err != nil {
+		return err
+	}
+	runner := pltest.NewRunner()
+	if arg != "" {
+		runner.Filter = regexp.MustCompile("^" + regexp.QuoteMeta(arg) + "$")
+	}
+	s := runner.RunModule("repl", m)
+	if arg != "" && s.Error == "" && len(s.Results) == 0 {
+		return fmt.Errorf("test %s is not found", arg)
+	}
+	pltest.WriteText(r.out, []*pltest.Suite{s})
+	return nil
+}
+
+// Complete tells whether the input is complete, ie the brackets outside of
+// the strings and the comments are balanced
+func Complete(input string) bool {
+	depth := 0
+	for i := 0; i < len(input); i++ {
+		switch c := input[i]; c {
+		case '"', '\'', '`':
+			j := i + 1
+			for ; j < len(input) && input[j] != c; j++ {
+				if input[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(input) {
+				return false
+			}
+			i = j
+		case '/':
+			if i+1 < len(input) && input[i+1] == '/' {
+				for i < len(input) && input[i] != '\n' {
+					i++
+				}
+			} else if i+1 < len(input) && input[i+1] == '*' {
+				end := strings.Index(input[i+2:], "*/")
+				if end < 0 {
+					return false
+				}
+				i += end + 3
+			}
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		}
+	}
+	return depth <= 0
+}
+
+// Run reads the inputs line by line until EOF or :quit, the prompt is written
+// before every line
+func (r *REPL) Run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	buf := []string{}
+	r.printf(">>> ")
+	for scanner.Scan() {
+		buf = append(buf, scanner.Text())
+		input := strings.Join(buf, "\n")
+		if !Complete(input) {
+			r.printf("... ")
+			continue
+		}
+		buf = buf[:0]
+
+		more, err := r.Eval(input)
+		if err != nil {
+			r.printf("error: %s\n", err.Error())
+		}
+		if !more {
+			return nil
+		}
+		r.printf(">>> ")
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,14 @@
+config service {
+  .name = "websocket_echo";
+  .router = "[GET]/websocket/echo";
+
+  application websocket("websocket", "chat");
+}
+
+// the handler owns the connection, it is closed once the handler returns
+rule "websocket" {
+  for let _, msg = $.websocket {
+    $.websocket:write(msg);
+  }
+  println("websocket closed with code: ", $.websocket.closeCode);
+}
@@ -0,0 +1,419 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dianpeng/moons/util"
+	"golang.org/x/crypto/acme"
+)
+
+// ACME provisions and renews the certificates of the hosts by the "acme"
+// field of the TLS config, ie
+//
+//   {
+//     "host": ["example.com", "www.example.com"],
+//     "email": "ops@example.com",
+//     "directory": "https://acme-v02.api.letsencrypt.org/directory",
+//     "store": "dir:/var/lib/moons/acme",
+//     "challenge": ["tls-alpn-01", "http-01"],
+//     "renew_before": 30
+//   }
+//
+// The certificates and the account key are kept by the cert store, the ones
+// found in the store are served at once and the missing or expiring ones are
+// obtained in background. The TLS-ALPN-01 challenge is answered by the TLS
+// listener itself, and the HTTP-01 challenge by any plain http listener, which
+// must be reachable by port 80 of the host.
+
+type ACMEConfig struct {
+	Host      []string `json:"host"`
+	Email     string   `json:"email"`
+	Directory string   `json:"directory"`
+	Store     string   `json:"store"`
+	Challenge []string `json:"challenge"`
+
+	// days before the expiry to renew, default is 30
+	RenewBefore int64 `json:"renew_before"`
+}
+
+const (
+	ACMEChallengePath = "/.well-known/acme-challenge/"
+
+	acmeChallengeHTTP = "http-01"
+	acmeChallengeALPN = "tls-alpn-01"
+	acmeAccountKey    = "acme_account.key"
+	acmeRenewBefore   = 30 * 24 * time.Hour
+	acmeCheckInterval = 12 * time.Hour
+	acmeRetry         = 10 * time.Minute
+	acmeTimeout       = 5 * time.Minute
+)
+
+type acmeManager struct {
+	config      ACMEConfig
+	store       CertStore
+	host        map[string]bool
+	renewBefore time.Duration
+
+	clientLock sync.Mutex
+	client     *acme.Client
+
+	// certificates of the hosts, and the pending challenges
+	lock  sync.RWMutex
+	cert  map[string]*tls.Certificate
+	alpn  map[string]*tls.Certificate
+	token map[string]string
+
+	done chan struct{}
+}
+
+// managers answering the HTTP-01 challenge
+var (
+	acmeLock     sync.RWMutex
+	acmeManagers = make(map[*acmeManager]bool)
+)
+
+func newACMEManager(c *ACMEConfig) (*acmeManager, error) {
+	if len(c.Host) == 0 {
+		return nil, fmt.Errorf("acme: host is not specified")
+	}
+	if c.Store == "" {
+		return nil, fmt.Errorf("acme: store is not specified")
+	}
+	store, err := NewCertStore(c.Store)
+	if err != nil {
+		return nil, fmt.Errorf("acme: %s", err.Error())
+	}
+
+	m := &acmeManager{
+		config:      *c,
+		store:       store,
+		host:        make(map[string]bool),
+		renewBefore: acmeRenewBefore,
+		cert:        make(map[string]*tls.Certificate),
+		alpn:        make(map[string]*tls.Certificate),
+		token:       make(map[string]string),
+		done:        make(chan struct{}),
+	}
+	for _, h := range c.Host {
+		m.host[strings.ToLower(h)] = true
+	}
+	if c.RenewBefore > 0 {
+		m.renewBefore = time.Duration(c.RenewBefore) * 24 * time.Hour
+	}
+	if len(m.config.Challenge) == 0 {
+		m.config.Challenge = []string{acmeChallengeALPN, acmeChallengeHTTP}
+	}
+	for _, x := range m.config.Challenge {
+		if x != acmeChallengeALPN && x != acmeChallengeHTTP {
+			return nil, fmt.Errorf("acme: unknown challenge %s", x)
+		}
+	}
+
+	// the certificates stored are served before the first renewal
+	for h := range m.host {
+		if cert, err := m.load(h); err == nil {
+			m.cert[h] = cert
+		}
+	}
+
+	acmeLock.Lock()
+	acmeManagers[m] = true
+	acmeLock.Unlock()
+
+	go m.run()
+	return m, nil
+}
+
+func (m *acmeManager) close() {
+	acmeLock.Lock()
+	delete(acmeManagers, m)
+	acmeLock.Unlock()
+	close(m.done)
+}
+
+func (m *acmeManager) has(host string) bool {
+	return m.host[strings.ToLower(host)]
+}
+
+func (m *acmeManager) certificate(host string) *tls.Certificate {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.cert[strings.ToLower(host)]
+}
+
+func (m *acmeManager) challengeCert(host string) (*tls.Certificate, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if c, ok := m.alpn[strings.ToLower(host)]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("acme: no pending challenge of %s", host)
+}
+
+// ACMEChallengeResponse returns the key authorization of the HTTP-01
+// challenge of the request to the host, if the challenge is pending
+func ACMEChallengeResponse(host string, path string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	token := strings.TrimPrefix(path, ACMEChallengePath)
+
+	acmeLock.RLock()
+	defer acmeLock.RUnlock()
+	for m := range acmeManagers {
+		if !m.has(host) {
+			continue
+		}
+		m.lock.RLock()
+		resp, ok := m.token[token]
+		m.lock.RUnlock()
+		if ok {
+			return resp, true
+		}
+	}
+	return "", false
+}
+
+func isACMEChallenge(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+}
+
+// renews the certificates until the manager is closed
+func (m *acmeManager) run() {
+	for {
+		next := acmeCheckInterval
+		for h := range m.host {
+			if !m.expiring(h) {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), acmeTimeout)
+			err := m.obtain(ctx, h)
+			cancel()
+			if err != nil {
+				util.Errorf("acme: certificate of %s: %s", h, err.Error())
+				next = acmeRetry
+			} else {
+				util.Infof("acme: certificate of %s is obtained", h)
+			}
+		}
+
+		timer := time.NewTimer(next)
+		select {
+		case <-m.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+			break
+		}
+	}
+}
+
+func (m *acmeManager) expiring(host string) bool {
+	c := m.certificate(host)
+	return c == nil || time.Until(c.Leaf.NotAfter) < m.renewBefore
+}
+
+func (m *acmeManager) load(host string) (*tls.Certificate, error) {
+	data, err := m.store.Get(context.Background(), host)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// returns the registered client, the account key is created once and kept by
+// the store
+func (m *acmeManager) acmeClient(ctx context.Context) (*acme.Client, error) {
+	m.clientLock.Lock()
+	defer m.clientLock.Unlock()
+	if m.client != nil {
+		return m.client, nil
+	}
+
+	var key *ecdsa.PrivateKey
+	data, err := m.store.Get(ctx, acmeAccountKey)
+	switch err {
+	case nil:
+		b, _ := pem.Decode(data)
+		if b == nil {
+			return nil, fmt.Errorf("invalid account key in store")
+		}
+		if key, err = x509.ParseECPrivateKey(b.Bytes); err != nil {
+			return nil, err
+		}
+	case ErrCertStoreMiss:
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		if err := m.store.Put(ctx, acmeAccountKey, data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: m.config.Directory,
+	}
+	account := &acme.Account{}
+	if m.config.Email != "" {
+		account.Contact = []string{"mailto:" + m.config.Email}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, err
+	}
+	m.client = client
+	return client, nil
+}
+
+// obtains the certificate of the host by a new order and stores it
+func (m *acmeManager) obtain(ctx context.Context, host string) error {
+	client, err := m.acmeClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(host))
+	if err != nil {
+		return err
+	}
+	for _, u := range order.AuthzURLs {
+		z, err := client.GetAuthorization(ctx, u)
+		if err != nil {
+			return err
+		}
+		if z.Status == acme.StatusValid {
+			continue
+		}
+		if err := m.solve(ctx, client, z, host); err != nil {
+			return err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(
+		rand.Reader,
+		&x509.CertificateRequest{DNSNames: []string{host}},
+		key,
+	)
+	if err != nil {
+		return err
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return err
+	}
+
+	// the key and the chain are stored as one PEM
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+	for _, x := range der {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: x})...)
+	}
+	if err := m.store.Put(ctx, host, data); err != nil {
+		return err
+	}
+
+	cert, err := m.load(host)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	m.cert[host] = cert
+	m.lock.Unlock()
+	return nil
+}
+
+// answers one challenge of the authorization, in the preferred order of the
+// challenge types
+func (m *acmeManager) solve(
+	ctx context.Context,
+	client *acme.Client,
+	z *acme.Authorization,
+	host string,
+) error {
+	var chal *acme.Challenge
+	for _, t := range m.config.Challenge {
+		for _, c := range z.Challenges {
+			if c.Type == t {
+				chal = c
+				break
+			}
+		}
+		if chal != nil {
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("no supported challenge is offered")
+	}
+
+	switch chal.Type {
+	case acmeChallengeHTTP:
+		resp, err := client.HTTP01ChallengeResponse(chal.Token)
+		if err != nil {
+			return err
+		}
+		m.lock.Lock()
+		m.token[chal.Token] = resp
+		m.lock.Unlock()
+		defer func() {
+			m.lock.Lock()
+			delete(m.token, chal.Token)
+			m.lock.Unlock()
+		}()
+
+	default:
+		cert, err := client.TLSALPN01ChallengeCert(chal.Token, host)
+		if err != nil {
+			return err
+		}
+		m.lock.Lock()
+		m.alpn[host] = &cert
+		m.lock.Unlock()
+		defer func() {
+			m.lock.Lock()
+			delete(m.alpn, host)
+			m.lock.Unlock()
+		}()
+	}
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return err
+	}
+	_, err := client.WaitAuthorization(ctx, z.URI)
+	return err
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CertStore keeps the certificates and the account key of ACME, so they
+// survive the restart. The store is created by name from the "store" field of
+// the ACME config in form of name:argument, ie dir:/var/lib/moons/acme
+type CertStore interface {
+	// Get returns ErrCertStoreMiss if the key is not found
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, data []byte) error
+	Delete(ctx context.Context, key string) error
+}
+
+var ErrCertStoreMiss = errors.New("cert store: key is not found")
+
+type CertStoreFactory func(argument string) (CertStore, error)
+
+var (
+	certStoreLock sync.Mutex
+	certStoreFac  = make(map[string]CertStoreFactory)
+)
+
+func RegisterCertStore(name string, f CertStoreFactory) {
+	certStoreLock.Lock()
+	defer certStoreLock.Unlock()
+	certStoreFac[name] = f
+}
+
+// NewCertStore creates the store from name:argument
+func NewCertStore(spec string) (CertStore, error) {
+	name, arg := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		name, arg = spec[:i], spec[i+1:]
+	}
+
+	certStoreLock.Lock()
+	f, ok := certStoreFac[name]
+	certStoreLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("cert store: %s is unknown", name)
+	}
+	return f(arg)
+}
+
+// stores each key as a file of the dir, which is only accessible by owner
+type dirCertStore struct {
+	dir string
+}
+
+func newDirCertStore(dir string) (CertStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("cert store: dir must be specified")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("cert store: %s", err.Error())
+	}
+	return &dirCertStore{dir: dir}, nil
+}
+
+func (d *dirCertStore) path(key string) string {
+	return filepath.Join(d.dir, filepath.Clean("/"+key))
+}
+
+func (d *dirCertStore) Get(_ context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(d.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrCertStoreMiss
+	}
+	return data, err
+}
+
+func (d *dirCertStore) Put(_ context.Context, key string, data []byte) error {
+	// written aside and renamed, so the reader never sees the partial file
+	p := d.path(key)
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (d *dirCertStore) Delete(_ context.Context, key string) error {
+	err := os.Remove(d.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// keeps the keys in memory, lost once the process exits
+type memoryCertStore struct {
+	lock sync.Mutex
+	data map[string][]byte
+}
+
+func (m *memoryCertStore) Get(_ context.Context, key string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	data, ok := m.data[key]
+	if !ok {
+		return nil, ErrCertStoreMiss
+	}
+	return data, nil
+}
+
+func (m *memoryCertStore) Put(_ context.Context, key string, data []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.data[key] = data
+	return nil
+}
+
+func (m *memoryCertStore) Delete(_ context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+func init() {
+	RegisterCertStore("dir", newDirCertStore)
+	RegisterCertStore("memory", func(_ string) (CertStore, error) {
+		return &memoryCertStore{
+			data: make(map[string][]byte),
+		}, nil
+	})
+}
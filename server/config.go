@@ -0,0 +1,432 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dianpeng/moons/g"
+	"github.com/dianpeng/moons/manifest"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Server config file, in YAML or JSON, which is an alternative of the command
+// line flags, ie
+//
+//   listeners:
+//     - {type: http, name: web, endpoint: ":8080", read_timeout: 20}
+//     - {type: admin, name: admin, endpoint: "127.0.0.1:9901"}
+//   vhosts:
+//     - {type: http, main: ./site/main.pl}
+//   limits:
+//     kv_max_size: 67108864
+//     kv_max_entries: 1048576
+//     shutdown_timeout: 30
+//   log:
+//     level: info
+//     output: /var/log/moons.log
+//   watch_interval: 2
+//
+// The listener takes the same fields as its JSON form of the -listener flag.
+// The file is validated as a whole before anything is created, the error is
+// positioned as file:line:column. The main of vhost is the same as the
+// -http_dir and -redis_dir flags, which is the main file, the bundle or the
+// remote source of the manifest, the relative path is relative to the dir of
+// the config file
+
+type Config struct {
+	Listener []ListenerConfig
+	VHost    []VHostConfig
+	Limit    LimitConfig
+	Log      LogConfig
+
+	// seconds between the stamps of the manifests watched, 0 disables the
+	// watch, see watch.go
+	WatchInterval int64
+}
+
+type VHostConfig struct {
+	Type string
+
+	// main file, bundle or remote location of the manifest, see
+	// manifest.NewManifestFromSource
+	Main string
+
+	// file:line:column of the vhost in the config file
+	Pos string
+}
+
+type LimitConfig struct {
+	KVMaxSize       int64
+	KVMaxEntries    int
+	ShutdownTimeout int64
+}
+
+type LogConfig struct {
+	// one of debug, info, warn and error
+	Level string
+
+	// file the log is appended to, empty for stderr
+	Output string
+}
+
+// ConfigError is the error of the config file with its position
+type ConfigError struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+var logLevels = []string{
+	"debug",
+	"info",
+	"warn",
+	"error",
+}
+
+type configParser struct {
+	file string
+}
+
+func (p *configParser) errorf(n *yaml.Node, format string, args ...interface{}) error {
+	return &ConfigError{
+		File:   p.file,
+		Line:   n.Line,
+		Column: n.Column,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (p *configParser) pos(n *yaml.Node) string {
+	return fmt.Sprintf("%s:%d:%d", p.file, n.Line, n.Column)
+}
+
+func kindName(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "list"
+	case yaml.AliasNode:
+		return "alias"
+	default:
+		if n.Tag == "!!null" {
+			return "null"
+		}
+		return "scalar"
+	}
+}
+
+// fields of the mapping node, the unknown and duplicated field is rejected
+func (p *configParser) mapping(
+	n *yaml.Node,
+	what string,
+	allowed []string,
+) (map[string]*yaml.Node, error) {
+	if n.Kind != yaml.MappingNode {
+		return nil, p.errorf(n, "%s must be object, but got %s", what, kindName(n))
+	}
+	o := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		if allowed != nil && !contains(allowed, k.Value) {
+			return nil, p.errorf(k, "unknown field %s of %s", k.Value, what)
+		}
+		if _, ok := o[k.Value]; ok {
+			return nil, p.errorf(k, "duplicated field %s of %s", k.Value, what)
+		}
+		o[k.Value] = v
+	}
+	return o, nil
+}
+
+func (p *configParser) sequence(n *yaml.Node, what string) ([]*yaml.Node, error) {
+	if n.Kind != yaml.SequenceNode {
+		return nil, p.errorf(n, "%s must be list, but got %s", what, kindName(n))
+	}
+	return n.Content, nil
+}
+
+func (p *configParser) str(n *yaml.Node, what string) (string, error) {
+	if n.Kind != yaml.ScalarNode || n.Tag != "!!str" {
+		return "", p.errorf(n, "%s must be string", what)
+	}
+	return n.Value, nil
+}
+
+func (p *configParser) integer(n *yaml.Node, what string) (int64, error) {
+	if n.Kind != yaml.ScalarNode || n.Tag != "!!int" {
+		return 0, p.errorf(n, "%s must be integer", what)
+	}
+	v, err := strconv.ParseInt(n.Value, 0, 64)
+	if err != nil {
+		return 0, p.errorf(n, "%s is invalid integer: %s", what, err.Error())
+	}
+	if v < 0 {
+		return 0, p.errorf(n, "%s cannot be negative", what)
+	}
+	return v, nil
+}
+
+func contains(l []string, x string) bool {
+	for _, v := range l {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *configParser) parse(data []byte) (*Config, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %s", p.file, err.Error())
+	}
+	c := &Config{
+		Limit: LimitConfig{
+			KVMaxSize:       g.KVMaxSize,
+			KVMaxEntries:    g.KVMaxEntries,
+			ShutdownTimeout: g.ShutdownTimeout,
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+	}
+	if len(doc.Content) == 0 {
+		return c, nil
+	}
+
+	root, err := p.mapping(doc.Content[0], "config", []string{
+		"listeners",
+		"vhosts",
+		"limits",
+		"log",
+		"watch_interval",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := root["listeners"]; ok {
+		if err := p.parseListeners(n, c); err != nil {
+			return nil, err
+		}
+	}
+	if n, ok := root["vhosts"]; ok {
+		if err := p.parseVHosts(n, c); err != nil {
+			return nil, err
+		}
+	}
+	if n, ok := root["limits"]; ok {
+		if err := p.parseLimits(n, &c.Limit); err != nil {
+			return nil, err
+		}
+	}
+	if n, ok := root["log"]; ok {
+		if err := p.parseLog(n, &c.Log); err != nil {
+			return nil, err
+		}
+	}
+	if n, ok := root["watch_interval"]; ok {
+		if c.WatchInterval, err = p.integer(n, "watch_interval"); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (p *configParser) parseListeners(n *yaml.Node, c *Config) error {
+	list, err := p.sequence(n, "listeners")
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for _, x := range list {
+		// the fields specific to the listener type are checked by its factory
+		field, err := p.mapping(x, "listener", nil)
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"type", "name", "endpoint"} {
+			if _, ok := field[k]; !ok {
+				return p.errorf(x, "listener must specify %s", k)
+			}
+		}
+		t, err := p.str(field["type"], "listener type")
+		if err != nil {
+			return err
+		}
+		f := GetListenerFactory(t)
+		if f == nil {
+			return p.errorf(field["type"], "unknown listener type: %s", t)
+		}
+		name, err := p.str(field["name"], "listener name")
+		if err != nil {
+			return err
+		}
+		if names[name] {
+			return p.errorf(field["name"], "duplicated listener %s", name)
+		}
+		names[name] = true
+		if _, err := p.str(field["endpoint"], "listener endpoint"); err != nil {
+			return err
+		}
+
+		var v interface{}
+		if err := x.Decode(&v); err != nil {
+			return p.errorf(x, "listener %s: %s", name, err.Error())
+		}
+		js, err := json.Marshal(v)
+		if err != nil {
+			return p.errorf(x, "listener %s: %s", name, err.Error())
+		}
+		lc, err := f.ParseConfigJSON(string(js))
+		if err != nil {
+			return p.errorf(x, "listener %s: %s", name, err.Error())
+		}
+		c.Listener = append(c.Listener, lc)
+	}
+	return nil
+}
+
+func (p *configParser) parseVHosts(n *yaml.Node, c *Config) error {
+	list, err := p.sequence(n, "vhosts")
+	if err != nil {
+		return err
+	}
+	for _, x := range list {
+		field, err := p.mapping(x, "vhost", []string{"type", "main"})
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"type", "main"} {
+			if _, ok := field[k]; !ok {
+				return p.errorf(x, "vhost must specify %s", k)
+			}
+		}
+		t, err := p.str(field["type"], "vhost type")
+		if err != nil {
+			return err
+		}
+		if GetVHostFactory(t) == nil {
+			return p.errorf(field["type"], "unknown vhost type: %s", t)
+		}
+		main, err := p.str(field["main"], "vhost main")
+		if err != nil {
+			return err
+		}
+		if !manifest.IsRemoteSource(main) {
+			file, option := main, ""
+			if i := strings.LastIndex(main, "#"); i >= 0 {
+				file, option = main[:i], main[i:]
+			}
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(filepath.Dir(p.file), file)
+			}
+			if st, err := os.Stat(file); err != nil || st.IsDir() {
+				return p.errorf(field["main"], "vhost main file %s is not existed", file)
+			}
+			main = file + option
+		}
+		c.VHost = append(c.VHost, VHostConfig{
+			Type: t,
+			Main: main,
+			Pos:  p.pos(x),
+		})
+	}
+	return nil
+}
+
+func (p *configParser) parseLimits(n *yaml.Node, l *LimitConfig) error {
+	field, err := p.mapping(n, "limits", []string{
+		"kv_max_size",
+		"kv_max_entries",
+		"shutdown_timeout",
+	})
+	if err != nil {
+		return err
+	}
+	if x, ok := field["kv_max_size"]; ok {
+		if l.KVMaxSize, err = p.integer(x, "kv_max_size"); err != nil {
+			return err
+		}
+	}
+	if x, ok := field["kv_max_entries"]; ok {
+		v, err := p.integer(x, "kv_max_entries")
+		if err != nil {
+			return err
+		}
+		l.KVMaxEntries = int(v)
+	}
+	if x, ok := field["shutdown_timeout"]; ok {
+		if l.ShutdownTimeout, err = p.integer(x, "shutdown_timeout"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *configParser) parseLog(n *yaml.Node, l *LogConfig) error {
+	field, err := p.mapping(n, "log", []string{"level", "output"})
+	if err != nil {
+		return err
+	}
+	if x, ok := field["level"]; ok {
+		if l.Level, err = p.str(x, "log level"); err != nil {
+			return err
+		}
+		if !contains(logLevels, l.Level) {
+			return p.errorf(x, "unknown log level %s", l.Level)
+		}
+	}
+	if x, ok := field["output"]; ok {
+		if l.Output, err = p.str(x, "log output"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseConfig parses the config of the content, the file name is only used in
+// the error
+func ParseConfig(file string, data []byte) (*Config, error) {
+	p := &configParser{
+		file: file,
+	}
+	return p.parse(data)
+}
+
+func LoadConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %s", err.Error())
+	}
+	return ParseConfig(path, data)
+}
+
+// NewServerFromConfig creates the server with the listeners of the config and
+// adds the vhosts of it, the vhost failed is reported with its position
+func NewServerFromConfig(c *Config) (*Server, error) {
+	s, err := NewServer(c.Listener)
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range c.VHost {
+		m, err := manifest.NewManifestFromSource(x.Main, x.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", x.Pos, err.Error())
+		}
+		if err := s.AddVirtualHost(m); err != nil {
+			return nil, fmt.Errorf("%s: %s", x.Pos, err.Error())
+		}
+	}
+	return s, nil
+}
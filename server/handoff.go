@@ -0,0 +1,295 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// Zero downtime restart by handing the listening sockets over to a new
+// process. The process restarting execs the same binary with the same
+// arguments, the listening sockets are passed as the extra files and named by
+// the environment variable MOONS_LISTEN_FDS, ie "http,redis" means fd 3 is the
+// socket of listener http and fd 4 is the one of listener redis. The new
+// process listens on the inherited sockets instead of binding again, and once
+// it serves it signals the old process, whose pid is passed by the environment
+// variable MOONS_PARENT_PID, with SIGTERM, which then stops
+// accepting and drains as the graceful shutdown. The connections waiting in
+// the accept queue are accepted by the new process, so none is dropped.
+
+const (
+	listenFdsEnv = "MOONS_LISTEN_FDS"
+	parentPidEnv = "MOONS_PARENT_PID"
+)
+
+// first fd of the extra files, after stdin, stdout and stderr
+const listenFdStart = 3
+
+var (
+	listenLock sync.Mutex
+
+	// sockets opened by the listeners, keyed by the listener name
+	listening = make(map[string]net.Listener)
+
+	// sockets inherited from the old process and not yet taken by listener
+	inherited     map[string]*os.File
+	inheritedOnce sync.Once
+	isInherited   bool
+
+	// pid of the old process, it is only signaled while it is still the
+	// parent, since the parent may have died and the pid reused
+	parentPid int
+
+	// result of each Listen, the server notifies the old process once all the
+	// listeners listen
+	listenResult = make(chan error, 64)
+)
+
+func inheritedFiles() map[string]*os.File {
+	inheritedOnce.Do(func() {
+		inherited = make(map[string]*os.File)
+		env := os.Getenv(listenFdsEnv)
+		ppid := os.Getenv(parentPidEnv)
+		os.Unsetenv(parentPidEnv)
+		if env == "" {
+			return
+		}
+		// the process started by this one gets its own list
+		os.Unsetenv(listenFdsEnv)
+		isInherited = true
+		parentPid, _ = strconv.Atoi(ppid)
+
+		for i, name := range strings.Split(env, ",") {
+			fd := listenFdStart + i
+			inherited[name] = os.NewFile(uintptr(fd), "listener-"+name)
+		}
+	})
+	return inherited
+}
+
+// unix:///path is the unix domain socket of the path, otherwise the tcp
+// address
+const unixEndpointPrefix = "unix://"
+
+// Listen opens the listening socket of the listener by its endpoint, the
+// socket inherited from the old process is taken if there is one of the
+// listener name
+func Listen(name string, endpoint string) (net.Listener, error) {
+	network, addr := "tcp", endpoint
+	if strings.HasPrefix(endpoint, unixEndpointPrefix) {
+		network, addr = "unix", strings.TrimPrefix(endpoint, unixEndpointPrefix)
+	}
+	ln, err := listen(name, network, addr)
+	select {
+	case listenResult <- err:
+	default:
+	}
+	return ln, err
+}
+
+func listen(name string, network string, addr string) (net.Listener, error) {
+	listenLock.Lock()
+	defer listenLock.Unlock()
+
+	var ln net.Listener
+	var err error
+
+	files := inheritedFiles()
+	if f, ok := files[name]; ok {
+		delete(files, name)
+		ln, err = net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: cannot inherit socket: %s", name, err.Error())
+		}
+		// the socket file is removed once this process stops serving it
+		if x, ok := ln.(*net.UnixListener); ok {
+			x.SetUnlinkOnClose(true)
+		}
+	} else {
+		if network == "unix" {
+			removeStaleSocket(addr)
+		}
+		ln, err = net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listening[name] = ln
+	return ln, nil
+}
+
+// the socket file left by the process not exiting cleanly is removed, unless
+// some process still listens on it
+func removeStaleSocket(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+// Inherited returns whether the process is started by restart of the old one
+func Inherited() bool {
+	inheritedFiles()
+	return isInherited
+}
+
+// DrainListener stops accepting once stopped, so the connections not accepted
+// yet are left to the new process taking over the socket, but holds the
+// accept loop of the server until it is closed, since the server closes all
+// its connections or quits serving as soon as the accept fails
+type DrainListener struct {
+	net.Listener
+	stopped int32
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func NewDrainListener(ln net.Listener) *DrainListener {
+	return &DrainListener{
+		Listener: ln,
+		closed:   make(chan struct{}),
+	}
+}
+
+// Stop closes the socket, the accept blocks until the listener is closed
+func (d *DrainListener) Stop() {
+	atomic.StoreInt32(&d.stopped, 1)
+	d.Listener.Close()
+}
+
+func (d *DrainListener) Accept() (net.Conn, error) {
+	c, err := d.Listener.Accept()
+	if err != nil && atomic.LoadInt32(&d.stopped) == 1 {
+		<-d.closed
+		return nil, net.ErrClosed
+	}
+	return c, err
+}
+
+func (d *DrainListener) Close() error {
+	d.once.Do(func() {
+		close(d.closed)
+	})
+	if atomic.LoadInt32(&d.stopped) == 1 {
+		return nil
+	}
+	return d.Listener.Close()
+}
+
+type listenerFile interface {
+	File() (*os.File, error)
+}
+
+// Restart starts the new process of the same binary and arguments, which
+// takes over the listening sockets, and returns its pid. The current process
+// keeps serving until the new one signals it, so it is safe to restart again
+// if the new process fails to start
+func (s *Server) Restart() (int, error) {
+	s.stopLock.Lock()
+	stopping := s.stopping
+	s.stopLock.Unlock()
+	if stopping {
+		return 0, fmt.Errorf("restart: server is shutting down")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("restart: %s", err.Error())
+	}
+
+	listenLock.Lock()
+	defer listenLock.Unlock()
+
+	names := make([]string, 0, len(listening))
+	for name := range listening {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	defer func() {
+		for _, f := range files[listenFdStart:] {
+			f.Close()
+		}
+	}()
+
+	for _, name := range names {
+		x, ok := listening[name].(listenerFile)
+		if !ok {
+			return 0, fmt.Errorf("restart: socket of listener %s cannot be handed over", name)
+		}
+		f, err := x.File()
+		if err != nil {
+			return 0, fmt.Errorf("restart: socket of listener %s: %s", name, err.Error())
+		}
+		files = append(files, f)
+	}
+
+	env := []string{}
+	for _, x := range os.Environ() {
+		if !strings.HasPrefix(x, listenFdsEnv+"=") && !strings.HasPrefix(x, parentPidEnv+"=") {
+			env = append(env, x)
+		}
+	}
+	env = append(env,
+		listenFdsEnv+"="+strings.Join(names, ","),
+		parentPidEnv+"="+strconv.Itoa(os.Getpid()),
+	)
+
+	p, err := os.StartProcess(exe, os.Args, &os.ProcAttr{
+		Env:   env,
+		Files: files,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("restart: %s", err.Error())
+	}
+
+	// the socket file is served by the new process from now on
+	for _, ln := range listening {
+		if x, ok := ln.(*net.UnixListener); ok {
+			x.SetUnlinkOnClose(false)
+		}
+	}
+	return p.Pid, nil
+}
+
+// tells the old process the new one serves once all the listeners listen,
+// so it starts draining. The old process keeps serving if any listener fails.
+// Nothing is signaled unless the old process is still the parent, otherwise
+// the pid may belong to an unrelated process by now
+func (s *Server) notifyParent() {
+	if !Inherited() {
+		return
+	}
+	for range s.listener {
+		if err := <-listenResult; err != nil {
+			fmt.Printf("error: the old process is kept serving: %s\n", err.Error())
+			return
+		}
+	}
+	ppid := os.Getppid()
+	if parentPid == 0 || parentPid != ppid {
+		fmt.Printf("error: the old process %d is not the parent any more, not signaled\n", parentPid)
+		return
+	}
+	p, err := os.FindProcess(ppid)
+	if err != nil {
+		return
+	}
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		fmt.Printf("error: cannot signal the old process %d: %s\n", ppid, err.Error())
+	}
+}
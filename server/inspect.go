@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dianpeng/moons/pl"
+)
+
+// Introspection of the running server, used by the admin listener. The vhost
+// exposes its internals by implementing the optional interfaces below, the
+// vhost not implementing them is only listed.
+
+// Inspector is implemented by the vhost whose internals can be inspected, the
+// results are in JSON form
+type Inspector interface {
+	// services, routes and modules of the vhost
+	Inspect() interface{}
+
+	// dump of the compiled bytecode of the vhost module, or of the service
+	// module if the service name is not empty
+	Bytecode(service string) (string, error)
+
+	// health of the upstreams checked by the vhost
+	Health() interface{}
+
+	// counters of the vhost, ie the http client pool and the limiters
+	Metrics() interface{}
+}
+
+// Drainer is implemented by the vhost which can be drained, the drained vhost
+// rejects the new requests and waits the ones in flight to finish, until it is
+// resumed or replaced by reload
+type Drainer interface {
+	Drain(context.Context) error
+	Resume()
+	Draining() bool
+}
+
+// LogLeveler is implemented by the vhost whose level of the diagnostic lines
+// of script, ie log::info, can be changed at runtime
+type LogLeveler interface {
+	LogLevel() string
+	SetLogLevel(string) error
+}
+
+// RuleStats is the execution statistics of one rule of the vhost, the service
+// is empty for the rule of the vhost module
+type RuleStats struct {
+	Service string
+	pl.RuleStats
+}
+
+// Profiler is implemented by the vhost whose modules record the execution
+// statistics of the rules, see pl.Module.Stats. The statistics start over
+// once the vhost is reloaded
+type Profiler interface {
+	RuleStats() []RuleStats
+	ResetRuleStats()
+}
+
+// SortRuleStats sorts the statistics by the total time, the most expensive
+// one comes first
+func SortRuleStats(list []RuleStats) {
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Time > list[j].Time
+	})
+}
+
+// ConfigAuditor is implemented by the vhost recording the calls made by the
+// config phase of its modules, see pl.ConfigAudit
+type ConfigAuditor interface {
+	// the calls of the vhost module, or of the service module if the service
+	// name is not empty
+	ConfigAudit(service string) ([]pl.ConfigAuditEntry, error)
+}
+
+// ServerListener is implemented by the listener working on the server itself,
+// ie the admin listener, the server is attached once all the listeners are
+// created
+type ServerListener interface {
+	AttachServer(*Server)
+}
+
+func (s *Server) Listeners() []Listener {
+	return append([]Listener{}, s.listener...)
+}
+
+// VHosts returns the vhosts added from manifest, in the order of adding
+func (s *Server) VHosts() []VHost {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	o := make([]VHost, 0, len(s.hosted))
+	for _, h := range s.hosted {
+		o = append(o, h.vhost)
+	}
+	return o
+}
+
+// InspectVHost calls the function with the vhost of the name, the vhost is not
+// retired by reload meanwhile
+func (s *Server) InspectVHost(name string, f func(VHost) error) error {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	for _, h := range s.hosted {
+		if h.vhost.Name() == name {
+			return f(h.vhost)
+		}
+	}
+	return fmt.Errorf("vhost %s is not existed", name)
+}
+
+func (s *Server) drainer(name string) (Drainer, error) {
+	var d Drainer
+	err := s.InspectVHost(name, func(v VHost) error {
+		x, ok := v.(Drainer)
+		if !ok {
+			return fmt.Errorf("vhost %s cannot be drained", name)
+		}
+		d = x
+		return nil
+	})
+	return d, err
+}
+
+// DrainVHost drains the vhost of the name until the requests in flight finish
+// or the timeout is reached, the vhost keeps rejecting after the timeout
+func (s *Server) DrainVHost(name string, timeout time.Duration) error {
+	d, err := s.drainer(name)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := d.Drain(ctx); err != nil {
+		return fmt.Errorf("vhost %s is not drained: %s", name, err.Error())
+	}
+	return nil
+}
+
+func (s *Server) ResumeVHost(name string) error {
+	d, err := s.drainer(name)
+	if err != nil {
+		return err
+	}
+	d.Resume()
+	return nil
+}
@@ -1,12 +1,21 @@
 package server
 
+import (
+	"context"
+)
+
 type Listener interface {
 	Name() string
 	Type() string
 
 	AddVHost(VHost) error
-	UpdateVHost(VHost)
+	UpdateVHost(VHost) error
 	RemoveVHost(string)
 	GetVHost(string) VHost
 	Run() error
+
+	// Shutdown stops accepting, drains the in flight sessions until the
+	// context is done and then closes the listener, the vhosts of the listener
+	// are shut down at last
+	Shutdown(context.Context) error
 }
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dianpeng/moons/util"
+)
+
+// HAProxy PROXY protocol, by the "proxy_protocol" field of the listener
+// config in JSON, ie
+//
+//   {
+//     "trusted": ["10.0.0.0/8"],
+//     "timeout": 5
+//   }
+//
+// Each connection accepted must start with the PROXY header of version 1 or
+// 2, which carries the address of the real client, so the remote address of
+// the connection is the client instead of the L4 balancer. The connection
+// from a source not trusted, or without the header, is closed. The header is
+// read aside from the accept loop, so the slow client never blocks others.
+// The trusted sources are required, any source is trusted only when
+// "trust_all" is true.
+
+type ProxyProtocolConfig struct {
+	// sources allowed to send the header
+	Trusted []string `json:"trusted"`
+
+	// allows any source to send the header, instead of the trusted ones
+	TrustAll bool `json:"trust_all"`
+
+	// seconds to read the header, default is 5
+	Timeout int64 `json:"timeout"`
+}
+
+const (
+	proxyHeaderTimeout = 5 * time.Second
+
+	// the longest v1 header, ie "PROXY TCP6 <ip> <ip> <port> <port>\r\n"
+	proxyV1MaxLen = 107
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocol is created from ProxyProtocolConfig, the listening socket is
+// wrapped by it to parse the header of the connections accepted
+type ProxyProtocol struct {
+	trusted  *util.CIDRSet
+	trustAll bool
+	timeout  time.Duration
+}
+
+func NewProxyProtocol(c *ProxyProtocolConfig) (*ProxyProtocol, error) {
+	trusted, err := util.NewCIDRSet(c.Trusted)
+	if err != nil {
+		return nil, fmt.Errorf("proxy_protocol: trusted: %s", err.Error())
+	}
+	if trusted.Empty() && !c.TrustAll {
+		return nil, fmt.Errorf("proxy_protocol: trusted is required, or set trust_all to trust any source")
+	}
+	p := &ProxyProtocol{
+		trusted:  trusted,
+		trustAll: c.TrustAll,
+		timeout:  proxyHeaderTimeout,
+	}
+	if c.Timeout > 0 {
+		p.timeout = time.Second * time.Duration(c.Timeout)
+	}
+	return p, nil
+}
+
+func (p *ProxyProtocol) NewListener(ln net.Listener) net.Listener {
+	x := &proxyListener{
+		Listener: ln,
+		proto:    p,
+		ready:    make(chan net.Conn),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go x.run()
+	return x
+}
+
+func (p *ProxyProtocol) trustedSource(addr net.Addr) bool {
+	if p.trustAll {
+		return true
+	}
+	// the peer of the unix socket is local
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return true
+	}
+	return p.trusted.Contains(tcp.IP)
+}
+
+type proxyListener struct {
+	net.Listener
+	proto *ProxyProtocol
+
+	ready  chan net.Conn
+	closed chan struct{}
+
+	// closed by Close, the connection whose header is read afterwards is
+	// closed instead of waiting for Accept which may never be called
+	done    chan struct{}
+	err     error
+	pending sync.WaitGroup
+	once    sync.Once
+}
+
+func (p *proxyListener) run() {
+	for {
+		c, err := p.Listener.Accept()
+		if err != nil {
+			// the connections whose header is being read are still handed to
+			// the server
+			p.pending.Wait()
+			p.err = err
+			close(p.closed)
+			return
+		}
+		p.pending.Add(1)
+		go p.handshake(c)
+	}
+}
+
+func (p *proxyListener) handshake(c net.Conn) {
+	defer p.pending.Done()
+
+	if !p.proto.trustedSource(c.RemoteAddr()) {
+		util.Warnf("proxy_protocol: %s is not trusted", c.RemoteAddr().String())
+		c.Close()
+		return
+	}
+
+	c.SetReadDeadline(time.Now().Add(p.proto.timeout))
+	x, err := readProxyHeader(c)
+	if err != nil {
+		util.Warnf("proxy_protocol: %s: %s", c.RemoteAddr().String(), err.Error())
+		c.Close()
+		return
+	}
+	c.SetReadDeadline(time.Time{})
+
+	select {
+	case p.ready <- x:
+		break
+	case <-p.done:
+		c.Close()
+	}
+}
+
+func (p *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-p.ready:
+		return c, nil
+	case <-p.closed:
+		return nil, p.err
+	}
+}
+
+func (p *proxyListener) Close() error {
+	var err error
+	p.once.Do(func() {
+		close(p.done)
+		err = p.Listener.Close()
+	})
+	return err
+}
+
+// connection whose addresses are the ones of the PROXY header, the bytes
+// buffered while reading the header are read first
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func readProxyHeader(c net.Conn) (net.Conn, error) {
+	r := bufio.NewReaderSize(c, 512)
+	x := &proxyConn{
+		Conn:   c,
+		r:      r,
+		remote: c.RemoteAddr(),
+		local:  c.LocalAddr(),
+	}
+
+	sig, err := r.Peek(5)
+	if err != nil {
+		return nil, err
+	}
+	if string(sig) == "PROXY" {
+		err = readProxyV1(r, x)
+	} else {
+		err = readProxyV2(r, x)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readProxyV1(r *bufio.Reader, x *proxyConn) error {
+	var line []byte
+	for len(line) < proxyV1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return fmt.Errorf("v1 header is too long")
+	}
+
+	f := strings.Fields(string(line))
+	if len(f) >= 2 && f[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6") {
+		return fmt.Errorf("invalid v1 header")
+	}
+	src, err := proxyTCPAddr(f[2], f[4])
+	if err != nil {
+		return err
+	}
+	dst, err := proxyTCPAddr(f[3], f[5])
+	if err != nil {
+		return err
+	}
+	x.remote, x.local = src, dst
+	return nil
+}
+
+func proxyTCPAddr(ip string, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("invalid address %s", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %s", port)
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+func readProxyV2(r *bufio.Reader, x *proxyConn) error {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	if !bytes.Equal(hdr[:12], proxyV2Signature) {
+		return fmt.Errorf("header is missing")
+	}
+	if hdr[12]>>4 != 2 {
+		return fmt.Errorf("unknown v2 version %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return err
+	}
+
+	// the LOCAL command is sent by the balancer itself, ie health check
+	switch hdr[12] & 0xf {
+	case 0:
+		return nil
+	case 1:
+		break
+	default:
+		return fmt.Errorf("unknown v2 command %d", hdr[12]&0xf)
+	}
+
+	port := func(b []byte) int {
+		return int(binary.BigEndian.Uint16(b))
+	}
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		if len(body) < 12 {
+			return fmt.Errorf("invalid v2 ipv4 address")
+		}
+		x.remote = &net.TCPAddr{IP: net.IP(body[0:4]), Port: port(body[8:])}
+		x.local = &net.TCPAddr{IP: net.IP(body[4:8]), Port: port(body[10:])}
+	case 2: // AF_INET6
+		if len(body) < 36 {
+			return fmt.Errorf("invalid v2 ipv6 address")
+		}
+		x.remote = &net.TCPAddr{IP: net.IP(body[0:16]), Port: port(body[32:])}
+		x.local = &net.TCPAddr{IP: net.IP(body[16:32]), Port: port(body[34:])}
+	default:
+		// AF_UNSPEC and AF_UNIX carry no address of the client
+		break
+	}
+	return nil
+}
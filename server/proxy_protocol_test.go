@@ -0,0 +1,203 @@
+package server
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// reads the header written by the peer of a pipe
+func testProxyHeader(header []byte) (net.Conn, error) {
+	c, peer := net.Pipe()
+	go func() {
+		peer.Write(header)
+		peer.Write([]byte("data"))
+		peer.Close()
+	}()
+	return readProxyHeader(c)
+}
+
+func proxyV2Header(cmd byte, family byte, body []byte) []byte {
+	o := append([]byte{}, proxyV2Signature...)
+	o = append(o, 0x20|cmd, family<<4|1, 0, 0)
+	binary.BigEndian.PutUint16(o[14:], uint16(len(body)))
+	return append(o, body...)
+}
+
+func TestProxyProtocolV1(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := testProxyHeader([]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"))
+	if assert.Nil(err) {
+		assert.Equal("192.168.0.1:56324", c.RemoteAddr().String())
+		assert.Equal("192.168.0.11:443", c.LocalAddr().String())
+		data, _ := ioutil.ReadAll(c)
+		assert.Equal("data", string(data))
+	}
+
+	c, err = testProxyHeader([]byte("PROXY TCP6 ::1 ::2 1 2\r\n"))
+	if assert.Nil(err) {
+		assert.Equal("[::1]:1", c.RemoteAddr().String())
+	}
+
+	// the addresses of the connection are kept
+	c, err = testProxyHeader([]byte("PROXY UNKNOWN\r\n"))
+	if assert.Nil(err) {
+		assert.Equal("pipe", c.RemoteAddr().String())
+	}
+
+	for _, x := range []string{
+		"PROXY TCP4 192.168.0.1 192.168.0.11 56324\r\n",
+		"PROXY UDP4 192.168.0.1 192.168.0.11 56324 443\r\n",
+		"PROXY TCP4 nope 192.168.0.11 56324 443\r\n",
+		"PROXY TCP4 192.168.0.1 192.168.0.11 56324 99999\r\n",
+		"PROXY TCP4 192.168.0.1 192.168.0.11 56324 443",
+		"PROXY " + string(make([]byte, proxyV1MaxLen)) + "\r\n",
+		"GET / HTTP/1.1\r\n\r\n",
+	} {
+		_, err := testProxyHeader([]byte(x))
+		assert.NotNil(err, x)
+	}
+}
+
+func TestProxyProtocolV2(t *testing.T) {
+	assert := assert.New(t)
+
+	ipv4 := []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x1f, 0x90, 0x01, 0xbb}
+	c, err := testProxyHeader(proxyV2Header(1, 1, ipv4))
+	if assert.Nil(err) {
+		assert.Equal("10.0.0.1:8080", c.RemoteAddr().String())
+		assert.Equal("10.0.0.2:443", c.LocalAddr().String())
+		data, _ := ioutil.ReadAll(c)
+		assert.Equal("data", string(data))
+	}
+
+	ipv6 := make([]byte, 36)
+	ipv6[15], ipv6[31], ipv6[33], ipv6[35] = 1, 2, 80, 81
+	c, err = testProxyHeader(proxyV2Header(1, 2, ipv6))
+	if assert.Nil(err) {
+		assert.Equal("[::1]:80", c.RemoteAddr().String())
+		assert.Equal("[::2]:81", c.LocalAddr().String())
+	}
+
+	// LOCAL command and AF_UNSPEC keep the addresses of the connection
+	for _, x := range [][]byte{
+		proxyV2Header(0, 1, ipv4),
+		proxyV2Header(1, 0, nil),
+	} {
+		c, err = testProxyHeader(x)
+		if assert.Nil(err) {
+			assert.Equal("pipe", c.RemoteAddr().String())
+		}
+	}
+
+	badVersion := proxyV2Header(1, 1, ipv4)
+	badVersion[12] = 0x11
+	for _, x := range [][]byte{
+		badVersion,
+		proxyV2Header(2, 1, ipv4),
+		proxyV2Header(1, 1, ipv4[:8]),
+		proxyV2Header(1, 2, ipv4),
+		proxyV2Header(1, 1, ipv4)[:20],
+		append([]byte("\r\n\r\n\x00\r\nQUIT!"), make([]byte, 16)...),
+	} {
+		_, err := testProxyHeader(x)
+		assert.NotNil(err)
+	}
+}
+
+func TestProxyProtocolConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewProxyProtocol(&ProxyProtocolConfig{})
+	assert.NotNil(err)
+	_, err = NewProxyProtocol(&ProxyProtocolConfig{Trusted: []string{"nope"}})
+	assert.NotNil(err)
+
+	p, err := NewProxyProtocol(&ProxyProtocolConfig{Trusted: []string{"10.0.0.0/8"}})
+	if assert.Nil(err) {
+		assert.True(p.trustedSource(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}))
+		assert.False(p.trustedSource(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}))
+		assert.True(p.trustedSource(&net.UnixAddr{Name: "/tmp/x.sock", Net: "unix"}))
+	}
+
+	p, err = NewProxyProtocol(&ProxyProtocolConfig{TrustAll: true})
+	if assert.Nil(err) {
+		assert.True(p.trustedSource(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}))
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := NewProxyProtocol(&ProxyProtocolConfig{Trusted: []string{"127.0.0.1"}})
+	assert.Nil(err)
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(err) {
+		return
+	}
+	ln := p.NewListener(raw)
+
+	client, err := net.Dial("tcp", raw.Addr().String())
+	if !assert.Nil(err) {
+		return
+	}
+	defer client.Close()
+	client.Write([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 1000 2000\r\n"))
+
+	c, err := ln.Accept()
+	if assert.Nil(err) {
+		assert.Equal("1.2.3.4:1000", c.RemoteAddr().String())
+		c.Close()
+	}
+	ln.Close()
+	_, err = ln.Accept()
+	assert.NotNil(err)
+}
+
+// the header completed after Close is not handed to Accept, the connection
+// is closed and the listener stops
+func TestProxyProtocolCloseDuringHandshake(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := NewProxyProtocol(&ProxyProtocolConfig{TrustAll: true})
+	assert.Nil(err)
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(err) {
+		return
+	}
+	ln := p.NewListener(raw).(*proxyListener)
+
+	client, err := net.Dial("tcp", raw.Addr().String())
+	if !assert.Nil(err) {
+		return
+	}
+	defer client.Close()
+
+	// the header is incomplete when the listener is closed
+	client.Write([]byte("PROXY TCP4 1.2.3.4 "))
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(ln.Close())
+	client.Write([]byte("5.6.7.8 1000 2000\r\n"))
+
+	select {
+	case <-ln.closed:
+		break
+	case <-time.After(5 * time.Second):
+		assert.Fail("listener does not stop")
+		return
+	}
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = client.Read(make([]byte, 1))
+	assert.NotNil(err)
+	if ne, ok := err.(net.Error); ok {
+		assert.False(ne.Timeout())
+	}
+	_, err = ln.Accept()
+	assert.NotNil(err)
+}
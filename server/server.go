@@ -1,8 +1,11 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/dianpeng/moons/manifest"
 
@@ -13,11 +16,28 @@ import (
 type Server struct {
 	listener []Listener
 	wg       sync.WaitGroup
+
+	// vhosts added from manifest, in the order of adding, which are recreated
+	// by reload
+	reloadLock sync.Mutex
+	hosted     []hostedVHost
+
+	// closed once the shutdown finishes, Run returns after it
+	stopLock sync.Mutex
+	stopping bool
+	stopped  chan struct{}
+}
+
+type hostedVHost struct {
+	manifest *manifest.Manifest
+	vhost    VHost
 }
 
 // create a new server with corresponding
 func NewServer(cfgList []ListenerConfig) (*Server, error) {
-	s := &Server{}
+	s := &Server{
+		stopped: make(chan struct{}),
+	}
 	for _, x := range cfgList {
 		f := GetListenerFactory(x.TypeName())
 		if f == nil {
@@ -29,6 +49,11 @@ func NewServer(cfgList []ListenerConfig) (*Server, error) {
 		}
 		s.listener = append(s.listener, l)
 	}
+	for _, l := range s.listener {
+		if x, ok := l.(ServerListener); ok {
+			x.AttachServer(s)
+		}
+	}
 	return s, nil
 }
 
@@ -41,29 +66,163 @@ func (s *Server) getListener(x string) Listener {
 	return nil
 }
 
-func (s *Server) AddVirtualHost(
+// creates the vhost of the manifest and checks its listener
+func (s *Server) newVHost(
 	config *manifest.Manifest,
-) error {
+) (VHost, Listener, error) {
 	fac := GetVHostFactory(config.Type)
 	if fac == nil {
-		return fmt.Errorf("listener: unknown manifest type %s", config.Type)
+		return nil, nil, fmt.Errorf("listener: unknown manifest type %s", config.Type)
 	}
 	vhost, err := fac.New(config)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	if vhost.ListenerType() != config.Type {
-		return fmt.Errorf("listener: mismatched listener type %s and vhost type %s",
+		vhost.Retire()
+		return nil, nil, fmt.Errorf("listener: mismatched listener type %s and vhost type %s",
 			vhost.ListenerType(),
 			config.Type,
 		)
 	}
 
-	if listener := s.getListener(vhost.ListenerName()); listener == nil {
-		return fmt.Errorf("listener: %s is not existed", vhost.ListenerName())
-	} else {
-		return listener.AddVHost(vhost)
+	listener := s.getListener(vhost.ListenerName())
+	if listener == nil {
+		vhost.Retire()
+		return nil, nil, fmt.Errorf("listener: %s is not existed", vhost.ListenerName())
+	}
+	return vhost, listener, nil
+}
+
+func (s *Server) AddVirtualHost(
+	config *manifest.Manifest,
+) error {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	vhost, listener, err := s.newVHost(config)
+	if err != nil {
+		return err
+	}
+	if err := listener.AddVHost(vhost); err != nil {
+		vhost.Retire()
+		return err
+	}
+	s.hosted = append(s.hosted, hostedVHost{
+		manifest: config,
+		vhost:    vhost,
+	})
+	return nil
+}
+
+// Reload loads the manifests of the vhosts again and swaps the recreated
+// vhosts in. All the vhosts are created and validated before any of them is
+// swapped, so a broken manifest leaves the running vhosts untouched. The
+// sessions started before the swap finish on the old vhost, the new sessions
+// are served by the new one. The vhost cannot be renamed or moved to another
+// listener by reload
+func (s *Server) Reload() error {
+	s.stopLock.Lock()
+	stopping := s.stopping
+	s.stopLock.Unlock()
+	if stopping {
+		return fmt.Errorf("reload: server is shutting down")
+	}
+
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	next := make([]hostedVHost, 0, len(s.hosted))
+	retire := func() {
+		for _, x := range next {
+			x.vhost.Retire()
+		}
+	}
+
+	for _, h := range s.hosted {
+		x, err := s.recreate(h)
+		if err != nil {
+			retire()
+			return err
+		}
+		next = append(next, x)
+	}
+
+	// swap, the vhost failed to be swapped keeps the old one serving
+	var failed []string
+	for i := range s.hosted {
+		if err := s.swap(i, next[i]); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("reload: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// ReloadVHost reloads the manifest of the vhost of the name and swaps the
+// recreated vhost in, the other vhosts are untouched
+func (s *Server) ReloadVHost(name string) error {
+	s.stopLock.Lock()
+	stopping := s.stopping
+	s.stopLock.Unlock()
+	if stopping {
+		return fmt.Errorf("reload: server is shutting down")
+	}
+
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	for i, h := range s.hosted {
+		if h.vhost.Name() != name {
+			continue
+		}
+		x, err := s.recreate(h)
+		if err != nil {
+			return err
+		}
+		if err := s.swap(i, x); err != nil {
+			return fmt.Errorf("reload: %s", err.Error())
+		}
+		return nil
+	}
+	return fmt.Errorf("reload: vhost %s is not existed", name)
+}
+
+// loads the manifest of the hosted vhost again and creates the vhost of it,
+// the vhost is not swapped in yet
+func (s *Server) recreate(h hostedVHost) (hostedVHost, error) {
+	m, err := h.manifest.Reload()
+	if err != nil {
+		return hostedVHost{}, fmt.Errorf("reload: vhost %s: %s", h.vhost.Name(), err.Error())
+	}
+	vhost, _, err := s.newVHost(m)
+	if err != nil {
+		return hostedVHost{}, fmt.Errorf("reload: vhost %s: %s", h.vhost.Name(), err.Error())
+	}
+	if vhost.Name() != h.vhost.Name() || vhost.ListenerName() != h.vhost.ListenerName() {
+		vhost.Retire()
+		return hostedVHost{}, fmt.Errorf("reload: vhost %s cannot be renamed or moved to another listener",
+			h.vhost.Name())
+	}
+	return hostedVHost{
+		manifest: m,
+		vhost:    vhost,
+	}, nil
+}
+
+// swaps the recreated vhost in place of the i-th hosted one, the old vhost is
+// retired. The recreated vhost failed to be swapped is retired instead
+func (s *Server) swap(i int, x hostedVHost) error {
+	h := s.hosted[i]
+	if err := s.getListener(x.vhost.ListenerName()).UpdateVHost(x.vhost); err != nil {
+		x.vhost.Retire()
+		return fmt.Errorf("vhost %s: %s", h.vhost.Name(), err.Error())
 	}
+	h.vhost.Retire()
+	s.hosted[i] = x
+	return nil
 }
 
 // run all the listener
@@ -71,6 +230,7 @@ func (s *Server) Run() {
 	s.wg.Add(len(s.listener))
 
 	for _, vv := range s.listener {
+		vv := vv
 		go func() {
 			defer s.wg.Done()
 			err := vv.Run()
@@ -80,8 +240,47 @@ func (s *Server) Run() {
 		}()
 	}
 
+	go s.notifyParent()
+
 	fmt.Printf("Server has been started")
 	s.wg.Wait()
+
+	// the listener returns once it stops accepting, wait for the draining
+	s.stopLock.Lock()
+	stopping := s.stopping
+	s.stopLock.Unlock()
+	if stopping {
+		<-s.stopped
+	}
+}
+
+// Shutdown stops all the listeners at once, the in flight sessions are
+// drained until the timeout and the connections left are closed then
+func (s *Server) Shutdown(timeout time.Duration) {
+	s.stopLock.Lock()
+	if s.stopping {
+		s.stopLock.Unlock()
+		return
+	}
+	s.stopping = true
+	s.stopLock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(s.listener))
+	for _, l := range s.listener {
+		l := l
+		go func() {
+			defer wg.Done()
+			if err := l.Shutdown(ctx); err != nil {
+				fmt.Printf("error: listener %s shutdown: %s\n", l.Name(), err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+	close(s.stopped)
 }
 
 func (s *Server) AddVHost(
@@ -117,9 +316,10 @@ func (s *Server) RemoveVHost(
 
 func (s *Server) UpdateVHost(
 	vhost VHost,
-) {
+) error {
 	l := s.getListener(vhost.ListenerName())
-	if l != nil {
-		l.UpdateVHost(vhost)
+	if l == nil {
+		return fmt.Errorf("listener %s is not existed", vhost.ListenerName())
 	}
+	return l.UpdateVHost(vhost)
 }
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/dianpeng/moons/util"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/ocsp"
+)
+
+// TLS termination of the listener, the config is the "tls" field of the
+// listener config in JSON, ie
+//
+//   {
+//     "certificate": [{"cert": "a.pem", "key": "a.key"}],
+//     "min_version": "1.2",
+//     "cipher_suite": ["TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"],
+//     "client_ca": "ca.pem",
+//     "client_auth": "require",
+//     "ocsp_stapling": true
+//   }
+//
+// The certificate is selected by the SNI of the client hello, the first one
+// supporting the hello is used, otherwise the first one. The OCSP response of
+// the certificate is fetched from its responder and refreshed in background.
+
+type TLSCertificate struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+type TLSConfig struct {
+	Certificate  []TLSCertificate `json:"certificate"`
+	MinVersion   string           `json:"min_version"`
+	CipherSuite  []string         `json:"cipher_suite"`
+	ClientCA     string           `json:"client_ca"`
+	ClientAuth   string           `json:"client_auth"`
+	OCSPStapling bool             `json:"ocsp_stapling"`
+
+	// certificates provisioned by ACME, served before the ones above
+	ACME *ACMEConfig `json:"acme"`
+}
+
+const (
+	ocspTimeout      = 10 * time.Second
+	ocspRetry        = 5 * time.Minute
+	ocspMinRefresh   = time.Minute
+	ocspMaxRefresh   = 12 * time.Hour
+	ocspMaxRespBytes = 1 << 20
+)
+
+var tlsVersion = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsClientAuth = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require_any":        tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require":            tls.RequireAndVerifyClientCert,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+func tlsCipherSuite(name string) (uint16, bool) {
+	for _, x := range tls.CipherSuites() {
+		if x.Name == name {
+			return x.ID, true
+		}
+	}
+	for _, x := range tls.InsecureCipherSuites() {
+		if x.Name == name {
+			return x.ID, true
+		}
+	}
+	return 0, false
+}
+
+// one certificate, the OCSP staple is refreshed by swapping the certificate
+type tlsCert struct {
+	cert atomic.Value // *tls.Certificate
+}
+
+func (c *tlsCert) get() *tls.Certificate {
+	return c.cert.Load().(*tls.Certificate)
+}
+
+// TLS is the TLS termination created from TLSConfig, it must be closed once
+// the listener is shut down to stop the OCSP refreshing
+type TLS struct {
+	Config *tls.Config
+	cert   []*tlsCert
+	acme   *acmeManager
+	done   chan struct{}
+}
+
+func NewTLS(c *TLSConfig) (*TLS, error) {
+	if len(c.Certificate) == 0 && c.ACME == nil {
+		return nil, fmt.Errorf("tls: certificate is not specified")
+	}
+
+	t := &TLS{
+		done: make(chan struct{}),
+	}
+	for _, x := range c.Certificate {
+		cert, err := tls.LoadX509KeyPair(x.Cert, x.Key)
+		if err != nil {
+			return nil, fmt.Errorf("tls: certificate %s: %s", x.Cert, err.Error())
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("tls: certificate %s: %s", x.Cert, err.Error())
+		}
+		cert.Leaf = leaf
+
+		tc := &tlsCert{}
+		tc.cert.Store(&cert)
+		t.cert = append(t.cert, tc)
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: t.getCertificate,
+	}
+
+	if c.MinVersion != "" {
+		v, ok := tlsVersion[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("tls: unknown min_version %s", c.MinVersion)
+		}
+		config.MinVersion = v
+	}
+
+	for _, name := range c.CipherSuite {
+		id, ok := tlsCipherSuite(name)
+		if !ok {
+			return nil, fmt.Errorf("tls: unknown cipher_suite %s", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+
+	if c.ClientCA != "" {
+		data, err := os.ReadFile(c.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("tls: client_ca: %s", err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("tls: client_ca %s has no certificate", c.ClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if c.ClientAuth != "" {
+		v, ok := tlsClientAuth[c.ClientAuth]
+		if !ok {
+			return nil, fmt.Errorf("tls: unknown client_auth %s", c.ClientAuth)
+		}
+		if v >= tls.VerifyClientCertIfGiven && config.ClientCAs == nil {
+			return nil, fmt.Errorf("tls: client_auth %s requires client_ca", c.ClientAuth)
+		}
+		config.ClientAuth = v
+	}
+
+	if c.ACME != nil {
+		m, err := newACMEManager(c.ACME)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %s", err.Error())
+		}
+		t.acme = m
+		config.NextProtos = append(config.NextProtos, acme.ALPNProto)
+	}
+
+	t.Config = config
+
+	if c.OCSPStapling {
+		for _, x := range t.cert {
+			go t.staple(x)
+		}
+	}
+	return t, nil
+}
+
+// selects the certificate by the SNI of the client hello
+func (t *TLS) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if t.acme != nil {
+		if isACMEChallenge(hello) {
+			return t.acme.challengeCert(hello.ServerName)
+		}
+		if c := t.acme.certificate(hello.ServerName); c != nil {
+			return c, nil
+		}
+	}
+
+	if len(t.cert) == 0 {
+		return nil, fmt.Errorf("tls: certificate of %s is not available", hello.ServerName)
+	}
+	for _, x := range t.cert {
+		c := x.get()
+		if hello.ServerName != "" && c.Leaf.VerifyHostname(hello.ServerName) != nil {
+			continue
+		}
+		if hello.SupportsCertificate(c) == nil {
+			return c, nil
+		}
+	}
+	return t.cert[0].get(), nil
+}
+
+func (t *TLS) Close() {
+	if t.acme != nil {
+		t.acme.close()
+	}
+	close(t.done)
+}
+
+// keeps the OCSP staple of the certificate fresh until the TLS is closed
+func (t *TLS) staple(x *tlsCert) {
+	for {
+		next := ocspRetry
+		cert := x.get()
+
+		resp, update, err := fetchOCSP(cert)
+		if err != nil {
+			util.Warnf("tls: OCSP of certificate %s: %s", cert.Leaf.Subject.CommonName, err.Error())
+		} else {
+			c := *cert
+			c.OCSPStaple = resp
+			x.cert.Store(&c)
+
+			next = time.Until(update) / 2
+			if next < ocspMinRefresh {
+				next = ocspMinRefresh
+			}
+			if next > ocspMaxRefresh {
+				next = ocspMaxRefresh
+			}
+		}
+
+		timer := time.NewTimer(next)
+		select {
+		case <-t.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+			break
+		}
+	}
+}
+
+// fetches the OCSP response of the certificate from the responder of it, and
+// returns the response along with its next update time
+func fetchOCSP(cert *tls.Certificate) ([]byte, time.Time, error) {
+	leaf := cert.Leaf
+	if len(leaf.OCSPServer) == 0 {
+		return nil, time.Time{}, fmt.Errorf("certificate has no OCSP responder")
+	}
+	if len(cert.Certificate) < 2 {
+		return nil, time.Time{}, fmt.Errorf("issuer certificate is not in the chain")
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	client := &http.Client{
+		Timeout: ocspTimeout,
+	}
+	hresp, err := client.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("responder replies status %d", hresp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(hresp.Body, ocspMaxRespBytes))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	resp, err := ocsp.ParseResponseForCert(data, leaf, issuer)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if resp.Status != ocsp.Good {
+		return nil, time.Time{}, fmt.Errorf("certificate status is %s", ocspStatus(resp.Status))
+	}
+	return data, resp.NextUpdate, nil
+}
+
+func ocspStatus(s int) string {
+	switch s {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
@@ -8,6 +8,10 @@ type VHost interface {
 	ListenerName() string
 	ListenerType() string
 	Name() string
+
+	// Retire stops the background work of the vhost replaced by reload, the
+	// sessions in flight still finish on it
+	Retire()
 }
 
 type VHostFactory interface {
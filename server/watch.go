@@ -0,0 +1,118 @@
+package server
+
+import (
+	"time"
+
+	"github.com/dianpeng/moons/manifest"
+	"github.com/dianpeng/moons/util"
+)
+
+// Watching of the manifests loaded from local dir. The files of each manifest
+// are stamped every interval, once any of them changes the vhost is reloaded
+// alone and swapped in as ReloadVHost does, the other vhosts are untouched. The
+// vhost swapped in is told by ManifestReloadedEvent. The manifest failed to
+// reload, ie a syntax error in the middle of editing, leaves the running vhost
+// serving and is retried once the files change again
+
+// ManifestReloadedEvent is emitted into the module of the vhost swapped in by
+// the watcher, the context is {"vhost": name, "files": [changed files]}
+const ManifestReloadedEvent = "manifest.:reloaded"
+
+// ReloadNotifier is implemented by the vhost which is told once it is swapped
+// in by the watcher
+type ReloadNotifier interface {
+	OnManifestReloaded(files []string)
+}
+
+type watchState struct {
+	manifest *manifest.Manifest
+	stamp    manifest.Stamp
+}
+
+// WatchManifest watches the manifests until the server shuts down, it returns
+// at once
+func (s *Server) WatchManifest(interval time.Duration) {
+	go s.watch(interval)
+}
+
+func (s *Server) watch(interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	state := make(map[string]*watchState)
+	for {
+		s.watchOnce(state)
+		select {
+		case <-s.stopped:
+			return
+		case <-tick.C:
+			break
+		}
+	}
+}
+
+func (s *Server) hostedManifests() map[string]*manifest.Manifest {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	o := make(map[string]*manifest.Manifest)
+	for _, h := range s.hosted {
+		if h.manifest.Watchable() {
+			o[h.vhost.Name()] = h.manifest
+		}
+	}
+	return o
+}
+
+func (s *Server) watchOnce(state map[string]*watchState) {
+	for name, m := range s.hostedManifests() {
+		stamp, err := m.Stamp()
+		if err != nil {
+			util.Warnf("watch: vhost %s: %s", name, err.Error())
+			continue
+		}
+
+		// the manifest swapped in by other than the watcher, ie the reload of
+		// SIGHUP, is stamped again
+		x, ok := state[name]
+		if !ok || x.manifest != m {
+			state[name] = &watchState{
+				manifest: m,
+				stamp:    stamp,
+			}
+			continue
+		}
+
+		files := stamp.Diff(x.stamp)
+		if len(files) == 0 {
+			continue
+		}
+		x.stamp = stamp
+
+		if err := s.ReloadVHost(name); err != nil {
+			util.Errorf("watch: %s", err.Error())
+			continue
+		}
+		util.Infof("watch: vhost %s is reloaded, changed %v", name, files)
+
+		// the stamp is kept for the manifest swapped in
+		if nm := s.hostedManifests()[name]; nm != nil {
+			x.manifest = nm
+		}
+		s.notifyReloaded(name, files)
+	}
+}
+
+func (s *Server) notifyReloaded(name string, files []string) {
+	s.reloadLock.Lock()
+	var v VHost
+	for _, h := range s.hosted {
+		if h.vhost.Name() == name {
+			v = h.vhost
+		}
+	}
+	s.reloadLock.Unlock()
+
+	if x, ok := v.(ReloadNotifier); ok {
+		x.OnManifestReloaded(files)
+	}
+}
@@ -0,0 +1,55 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// CIDRSet is a set of networks, the address without prefix length is the
+// network of the address alone
+type CIDRSet struct {
+	net []*net.IPNet
+}
+
+func NewCIDRSet(cidr []string) (*CIDRSet, error) {
+	s := &CIDRSet{}
+	for _, c := range cidr {
+		c = strings.TrimSpace(c)
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %s", c)
+			}
+			if x := ip.To4(); x != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		s.net = append(s.net, n)
+	}
+	return s, nil
+}
+
+func (s *CIDRSet) Empty() bool {
+	return len(s.net) == 0
+}
+
+// Contains tells whether the address is in any network of the set, the IPv4
+// mapped IPv6 address matches the IPv4 networks
+func (s *CIDRSet) Contains(ip net.IP) bool {
+	if x := ip.To4(); x != nil {
+		ip = x
+	}
+	for _, n := range s.net {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
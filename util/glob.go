@@ -0,0 +1,246 @@
+package util
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// A simple glob matcher, supports following meta characters:
+//
+//   1) *, matches any sequence of characters except the separator '/'
+//   2) **, matches any sequence of characters including the separator
+//   3) ?, matches exactly one character except the separator
+//   4) [abc], [a-z], [!a-z] or [^a-z], character class
+//   5) \x, escape the following character
+//
+// The matching is performed on rune level, ie unicode aware.
+
+const (
+	globLit = iota
+	globAny
+	globStar
+	globDoubleStar
+	globClass
+)
+
+const GlobSeparator = '/'
+
+// separator of the glob whose * and ? match any character, ie the key pattern
+// of redis
+const GlobNoSeparator = -1
+
+type globRange struct {
+	lo rune
+	hi rune
+}
+
+type globToken struct {
+	kind   int
+	lit    rune
+	negate bool
+	class  []globRange
+}
+
+type Glob struct {
+	pattern string
+	sep     rune
+	token   []globToken
+}
+
+func (g *globToken) matchClass(r rune) bool {
+	in := false
+	for _, x := range g.class {
+		if r >= x.lo && r <= x.hi {
+			in = true
+			break
+		}
+	}
+	return in != g.negate
+}
+
+func parseGlobClass(p []rune, idx int) (globToken, int, error) {
+	tk := globToken{
+		kind: globClass,
+	}
+	if idx < len(p) && (p[idx] == '!' || p[idx] == '^') {
+		tk.negate = true
+		idx++
+	}
+
+	first := true
+	for idx < len(p) {
+		c := p[idx]
+		if c == ']' && !first {
+			if len(tk.class) == 0 {
+				return tk, idx, fmt.Errorf("empty character class")
+			}
+			return tk, idx + 1, nil
+		}
+		first = false
+
+		if c == '\\' {
+			idx++
+			if idx == len(p) {
+				break
+			}
+			c = p[idx]
+		}
+		idx++
+
+		// range
+		if idx+1 < len(p) && p[idx] == '-' && p[idx+1] != ']' {
+			hi := p[idx+1]
+			idx += 2
+			if hi < c {
+				return tk, idx, fmt.Errorf("invalid character class range %c-%c", c, hi)
+			}
+			tk.class = append(tk.class, globRange{lo: c, hi: hi})
+		} else {
+			tk.class = append(tk.class, globRange{lo: c, hi: c})
+		}
+	}
+	return tk, idx, fmt.Errorf("character class is not closed")
+}
+
+func CompileGlob(pattern string) (*Glob, error) {
+	return CompileGlobSeparator(pattern, GlobSeparator)
+}
+
+// CompileGlobSeparator compiles the glob with the separator which * and ?
+// do not match, GlobNoSeparator makes * behave as **
+func CompileGlobSeparator(pattern string, sep rune) (*Glob, error) {
+	p := []rune(pattern)
+	g := &Glob{
+		pattern: pattern,
+		sep:     sep,
+	}
+
+	for idx := 0; idx < len(p); {
+		c := p[idx]
+		switch c {
+		case '*':
+			if idx+1 < len(p) && p[idx+1] == '*' {
+				g.token = append(g.token, globToken{kind: globDoubleStar})
+				idx += 2
+				for idx < len(p) && p[idx] == '*' {
+					idx++
+				}
+			} else {
+				g.token = append(g.token, globToken{kind: globStar})
+				idx++
+			}
+
+		case '?':
+			g.token = append(g.token, globToken{kind: globAny})
+			idx++
+
+		case '[':
+			tk, next, err := parseGlobClass(p, idx+1)
+			if err != nil {
+				return nil, fmt.Errorf("glob pattern %s: %s", pattern, err.Error())
+			}
+			g.token = append(g.token, tk)
+			idx = next
+
+		case '\\':
+			if idx+1 == len(p) {
+				return nil, fmt.Errorf("glob pattern %s: dangling escape", pattern)
+			}
+			g.token = append(g.token, globToken{kind: globLit, lit: p[idx+1]})
+			idx += 2
+
+		default:
+			g.token = append(g.token, globToken{kind: globLit, lit: c})
+			idx++
+		}
+	}
+
+	return g, nil
+}
+
+func MustCompileGlob(pattern string) *Glob {
+	g, err := CompileGlob(pattern)
+	if err != nil {
+		panic(err.Error())
+	}
+	return g
+}
+
+// returns true when the pattern does not have any meta characters
+func IsGlobLiteral(pattern string) bool {
+	for _, c := range pattern {
+		switch c {
+		case '*', '?', '[', '\\':
+			return false
+		}
+	}
+	return true
+}
+
+func (g *Glob) Pattern() string {
+	return g.pattern
+}
+
+func (g *Glob) Match(s string) bool {
+	input := make([]rune, 0, utf8.RuneCountInString(s))
+	for _, r := range s {
+		input = append(input, r)
+	}
+
+	// failed states, (token, input) pair, to avoid exponential backtracking
+	failed := make(map[int]bool)
+	return g.match(0, input, 0, failed)
+}
+
+func (g *Glob) match(ti int, s []rune, si int, failed map[int]bool) bool {
+	key := ti*(len(s)+1) + si
+	if failed[key] {
+		return false
+	}
+
+	for ti < len(g.token) {
+		tk := &g.token[ti]
+
+		switch tk.kind {
+		case globStar, globDoubleStar:
+			for i := si; i <= len(s); i++ {
+				if g.match(ti+1, s, i, failed) {
+					return true
+				}
+				if i < len(s) && tk.kind == globStar && s[i] == g.sep {
+					break
+				}
+			}
+			failed[key] = true
+			return false
+
+		default:
+			if si == len(s) {
+				failed[key] = true
+				return false
+			}
+			r := s[si]
+			ok := false
+			switch tk.kind {
+			case globLit:
+				ok = r == tk.lit
+			case globAny:
+				ok = r != g.sep
+			case globClass:
+				ok = tk.matchClass(r)
+			}
+			if !ok {
+				failed[key] = true
+				return false
+			}
+			ti++
+			si++
+		}
+	}
+
+	if si != len(s) {
+		failed[key] = true
+		return false
+	}
+	return true
+}
@@ -1,12 +1,18 @@
 package util
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"sync"
 	"time"
+
+	"github.com/dianpeng/moons/balancer"
+	"github.com/dianpeng/moons/breaker"
 )
 
 type HClient struct {
@@ -15,21 +21,274 @@ type HClient struct {
 	req    *http.Request
 	resp   *http.Response
 	err    error
+
+	// option of the client, ie retry settings, maybe nil
+	option *HClientOption
+
+	// the pooled client when the Client is overridden by per call option, it is
+	// restored when the client is put back to the pool
+	base *http.Client
+
+	// balancer of the upstream group when the host of the url names one
+	balancer balancer.Balancer
+
+	// headers added to every request unless the request has them, ie the
+	// request id of the session, cleared when the client is put back
+	header http.Header
+
+	// called with each attempt of the requests, cleared when the client is put
+	// back
+	onAttempt func(HClientAttempt)
+}
+
+// HClientAttempt is one attempt of a request to the upstream, either Status or
+// Error is set
+type HClientAttempt struct {
+	Upstream string
+	Status   int
+	Error    string
+	Duration time.Duration
+}
+
+func (h *HClient) Option() *HClientOption {
+	return h.option
+}
+
+// SetHeader adds the header to every request issued by the client unless the
+// request has it already
+func (h *HClient) SetHeader(key string, value string) {
+	if h.header == nil {
+		h.header = http.Header{}
+	}
+	h.header.Set(key, value)
+}
+
+// SetAttemptHook sets the function called with each attempt of the requests
+// issued by the client, it may be called concurrently by the clients sharing
+// the hook
+func (h *HClient) SetAttemptHook(fn func(HClientAttempt)) {
+	h.onAttempt = fn
 }
 
 func (h *HClient) Do(req *http.Request) (*http.Response, error) {
+	return h.do(h.Client, req)
+}
+
+// same as Do but the jar is applied to the request and all its redirections,
+// the pooled client itself is not modified
+func (h *HClient) DoWithJar(req *http.Request, jar http.CookieJar) (*http.Response, error) {
+	c := *h.Client
+	c.Jar = jar
+	return h.do(&c, req)
+}
+
+func (h *HClient) do(c *http.Client, req *http.Request) (*http.Response, error) {
+	if h.option != nil && h.option.ExpectContinueTimeout > 0 &&
+		hasRequestBody(req) && req.Header.Get("Expect") == "" {
+		req.Header.Set("Expect", "100-continue")
+	}
+	for key, value := range h.header {
+		if req.Header.Get(key) == "" {
+			req.Header[key] = value
+		}
+	}
+
 	h.req = req
-	resp, err := h.Client.Do(req)
+	resp, err := h.doRetry(c, req)
 	if err != nil {
 		h.err = err
 	} else {
 		h.err = nil
 		h.resp = resp
 	}
+	return resp, err
+}
+
+// sends the request to the upstream picked from the group, the request of the
+// caller is not modified. The upstreams already tried by the request are
+// avoided unless all of them are tried
+func (h *HClient) pick(req *http.Request, tried map[string]bool) (*http.Request, func()) {
+	key := ""
+	if h.option != nil {
+		key = h.option.BalanceKey
+	}
+
+	var addr string
+	var done func()
+	for i, n := 0, len(h.balancer.Upstream()); ; i++ {
+		k := key
+		if i > 0 && k != "" {
+			// the ring hash picks the same upstream for the same key
+			k = key + "#" + strconv.Itoa(i)
+		}
+		addr, done = h.balancer.Pick(k)
+		if !tried[addr] || i >= n {
+			break
+		}
+		done()
+	}
+
+	u := *req.URL
+	u.Host = addr
+	r := req.WithContext(req.Context())
+	r.URL = &u
+	return r, done
+}
+
+// the call of the upstream finishes when its body is closed
+type attemptBody struct {
+	io.ReadCloser
+	done func()
+}
+
+func (b *attemptBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
+
+var errPerTryTimeout = errors.New("per try timeout")
+
+// issues one attempt of the request, against the upstream picked from the
+// group if any. The per try timeout covers the time until the response header
+// is received
+func (h *HClient) attempt(c *http.Client, req *http.Request, tried map[string]bool) (*http.Response, error) {
+	r := req
+	finish := []func(){}
+	if h.balancer != nil {
+		var done func()
+		r, done = h.pick(req, tried)
+		finish = append(finish, done)
+	}
+
+	var timer *time.Timer
+	if h.option != nil && h.option.PerTryTimeout > 0 {
+		ctx, cancel := context.WithCancel(r.Context())
+		r = r.WithContext(ctx)
+		timer = time.AfterFunc(h.option.PerTryTimeout, cancel)
+		finish = append(finish, cancel)
+	}
+
+	start := time.Now()
+	resp, err := breakerDo(c, r)
+	if timer != nil && !timer.Stop() && req.Context().Err() == nil {
+		if err == nil {
+			resp.Body.Close()
+		}
+		resp, err = nil, errPerTryTimeout
+	}
+	tried[r.URL.Host] = true
+	h.record(r.URL.Host, resp, err, time.Since(start))
+
+	done := func() {
+		for _, f := range finish {
+			f()
+		}
+	}
+	if err != nil {
+		done()
+	} else if len(finish) > 0 {
+		resp.Body = &attemptBody{ReadCloser: resp.Body, done: done}
+	}
+	return resp, err
+}
+
+func (h *HClient) record(addr string, resp *http.Response, err error, d time.Duration) {
+	if h.onAttempt == nil {
+		return
+	}
+	a := HClientAttempt{
+		Upstream: addr,
+		Duration: d,
+	}
+	if err != nil {
+		a.Error = err.Error()
+	} else {
+		a.Status = resp.StatusCode
+	}
+	h.onAttempt(a)
+}
+
+// issues the request through the circuit breaker of the upstream host, if the
+// host has one. Transport error and 5xx are failures, the request canceled by
+// the caller is not counted
+func breakerDo(c *http.Client, req *http.Request) (*http.Response, error) {
+	b := breaker.Get(req.URL.Host)
+	if b == nil {
+		return c.Do(req)
+	}
+	done, err := b.Allow()
+	if err != nil {
+		return nil, err
+	}
 
+	resp, err := c.Do(req)
+	if err == nil {
+		done(resp.StatusCode < 500)
+	} else if req.Context().Err() == nil {
+		done(false)
+	}
 	return resp, err
 }
 
+// retries the failed attempt on the other upstream of the group, if any
+func (h *HClient) doRetry(c *http.Client, req *http.Request) (*http.Response, error) {
+	tried := make(map[string]bool)
+	if h.option == nil || h.option.Retry <= 0 || !h.option.canRetryRequest(req) {
+		return h.attempt(c, req, tried)
+	}
+
+	attempt := 0
+	for {
+		resp, err := h.attempt(c, req, tried)
+		if attempt >= h.option.Retry || !h.option.shouldRetryResponse(req, resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			io.CopyN(io.Discard, resp.Body, 4096)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(h.option.backoff(attempt))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+			break
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		attempt++
+	}
+}
+
+// creates a client which is not managed by any pool
+func NewHClient(u *url.URL, option *HClientOption) (HClient, error) {
+	c := &http.Client{}
+	if option != nil {
+		c.Timeout = option.Timeout
+		if option.hasTransportOption() {
+			t, err := option.NewTransport()
+			if err != nil {
+				return HClient{}, err
+			}
+			c.Transport = t
+		}
+	}
+	return HClient{
+		Client: c,
+		URL:    u,
+		option: option,
+	}, nil
+}
+
 type clientList []HClient
 type pool map[string]clientList
 
@@ -57,9 +316,76 @@ type HClientPool struct {
 	newSize       int64
 	drainProduce  int64
 	drainConsume  int64
+
+	// per destination option, keyed by host of the url or * as default
+	option map[string]*HClientOption
+
+	// transports shared by the clients with same transport option
+	transport map[string]*http.Transport
+
+	// upstream groups, the url whose host is a group name is sent to one of
+	// the upstreams picked by the group's balancer
+	upstream map[string]balancer.Balancer
 	sync.Mutex
 }
 
+// sets option for the destination, dest is either the host(with port) of the
+// url or * for all the destinations. Must be called before the pool is used
+func (h *HClientPool) SetOption(dest string, option *HClientOption) {
+	h.Lock()
+	defer h.Unlock()
+	h.option[dest] = option
+}
+
+// sets the balancer of the upstream group. Must be called before the pool is
+// used
+func (h *HClientPool) SetUpstream(name string, b balancer.Balancer) {
+	h.Lock()
+	defer h.Unlock()
+	h.upstream[name] = b
+}
+
+func (h *HClientPool) upstreamOf(u *url.URL) balancer.Balancer {
+	h.Lock()
+	defer h.Unlock()
+	return h.upstream[u.Host]
+}
+
+func (h *HClientPool) optionOf(u *url.URL) *HClientOption {
+	h.Lock()
+	defer h.Unlock()
+	o := h.option["*"].Merge(nil)
+	if x, ok := h.option[u.Hostname()]; ok {
+		o = o.Merge(x)
+	}
+	if x, ok := h.option[u.Host]; ok {
+		o = o.Merge(x)
+	}
+	if o.Timeout == 0 {
+		o.Timeout = time.Duration(h.clientTimeout) * time.Second
+	}
+	return o
+}
+
+func (h *HClientPool) transportOf(o *HClientOption) (http.RoundTripper, error) {
+	if !o.hasTransportOption() {
+		return nil, nil
+	}
+	key := o.transportKey()
+
+	h.Lock()
+	defer h.Unlock()
+	if t, ok := h.transport[key]; ok {
+		return t, nil
+	}
+	t, err := o.NewTransport()
+	if err != nil {
+		return nil, err
+	}
+	h.transport[key] = t
+	return t, nil
+}
+
 func (h *HClientPool) Stats() interface{} {
 	o := make(map[string]interface{})
 	{
@@ -114,12 +440,22 @@ func (h *HClientPool) tryCreate(url *url.URL) (HClient, error) {
 		h.newSize++
 		h.Unlock()
 	}
+	option := h.optionOf(url)
+	t, err := h.transportOf(option)
+	if err != nil {
+		return HClient{}, err
+	}
 	c := &http.Client{
-		Timeout: time.Duration(h.clientTimeout) * time.Second,
+		Timeout: option.Timeout,
+	}
+	if t != nil {
+		c.Transport = t
 	}
 	return HClient{
-		Client: c,
-		URL:    url,
+		Client:   c,
+		URL:      url,
+		option:   option,
+		balancer: h.upstreamOf(url),
 	}, nil
 }
 
@@ -154,6 +490,30 @@ func (h *HClientPool) Get(rawStr string) (HClient, error) {
 	}
 }
 
+// gets a client whose option is overridden by the per call option, the client
+// still goes back to the pool with its original settings
+func (h *HClientPool) GetWithOption(rawStr string, option *HClientOption) (HClient, error) {
+	c, err := h.Get(rawStr)
+	if err != nil || option == nil {
+		return c, err
+	}
+
+	o := c.option.Merge(option)
+	x := *c.Client
+	x.Timeout = o.Timeout
+	if option.hasTransportOption() {
+		t, err := h.transportOf(o)
+		if err != nil {
+			return HClient{}, err
+		}
+		x.Transport = t
+	}
+	c.base = c.Client
+	c.Client = &x
+	c.option = o
+	return c, nil
+}
+
 func (h *HClientPool) tryDrain(c HClient) {
 	if c.resp == nil {
 		return
@@ -175,6 +535,13 @@ func (h *HClientPool) shouldPut() bool {
 }
 
 func (h *HClientPool) Put(c HClient) bool {
+	c.header = nil
+	c.onAttempt = nil
+	if c.base != nil {
+		c.Client = c.base
+		c.base = nil
+		c.option = h.optionOf(c.URL)
+	}
 	if h.shouldPut() {
 		h.tryDrain(c)
 		return true
@@ -233,6 +600,9 @@ func NewHClientPool(name string, maxPoolSize int64, clientTimeout int64, maxDrai
 		maxPoolSize:   maxPoolSize,
 		maxDrainSize:  maxDrain,
 		clientTimeout: clientTimeout,
+		option:        make(map[string]*HClientOption),
+		transport:     make(map[string]*http.Transport),
+		upstream:      make(map[string]balancer.Balancer),
 	}
 
 	go c.doDrain(maxDrain)
@@ -0,0 +1,312 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dianpeng/moons/breaker"
+)
+
+// Options of the http client, either configured per destination on the pool
+// or passed per call. Zero value of each field means not set, ie inherits from
+// the less specific option or uses the default of net/http
+type HClientOption struct {
+	// overall timeout of the request including redirection and reading body
+	Timeout time.Duration
+
+	// timeout of establishing the connection
+	ConnectTimeout time.Duration
+
+	// timeout of waiting for the response header after the request is written
+	ReadTimeout time.Duration
+
+	// when set, request with body is sent with Expect: 100-continue and the
+	// body is only sent after the upstream accepts it or the timeout elapses
+	ExpectContinueTimeout time.Duration
+
+	IdleConnTimeout     time.Duration
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+
+	// retry budget of a single request, the request is retried at most Retry
+	// times on network error or 502/503/504. The backoff starts from
+	// RetryBackoff and doubles each time, capped by RetryMaxBackoff
+	Retry           int
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+
+	// status retried instead of 502/503/504, each is a status code or class,
+	// ie "503" or "5xx"
+	RetryOn []string
+
+	// retries the request of non idempotent method, ie POST, as well
+	RetryNonIdempotent bool
+
+	// timeout of each attempt until the response header is received, the
+	// attempt timed out is retried
+	PerTryTimeout time.Duration
+
+	// tls settings
+	InsecureSkipVerify bool
+	ServerName         string
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+
+	// key of the call for the ring hash balancer of the upstream group
+	BalanceKey string
+}
+
+const (
+	hclientDefaultRetryBackoff    = 100 * time.Millisecond
+	hclientDefaultRetryMaxBackoff = 5 * time.Second
+)
+
+// returns a new option, fields of x override the ones of o when set
+func (o *HClientOption) Merge(x *HClientOption) *HClientOption {
+	var r HClientOption
+	if o != nil {
+		r = *o
+	}
+	if x == nil {
+		return &r
+	}
+
+	if x.Timeout != 0 {
+		r.Timeout = x.Timeout
+	}
+	if x.ConnectTimeout != 0 {
+		r.ConnectTimeout = x.ConnectTimeout
+	}
+	if x.ReadTimeout != 0 {
+		r.ReadTimeout = x.ReadTimeout
+	}
+	if x.ExpectContinueTimeout != 0 {
+		r.ExpectContinueTimeout = x.ExpectContinueTimeout
+	}
+	if x.IdleConnTimeout != 0 {
+		r.IdleConnTimeout = x.IdleConnTimeout
+	}
+	if x.MaxIdleConns != 0 {
+		r.MaxIdleConns = x.MaxIdleConns
+	}
+	if x.MaxIdleConnsPerHost != 0 {
+		r.MaxIdleConnsPerHost = x.MaxIdleConnsPerHost
+	}
+	if x.MaxConnsPerHost != 0 {
+		r.MaxConnsPerHost = x.MaxConnsPerHost
+	}
+	if x.Retry != 0 {
+		r.Retry = x.Retry
+	}
+	if x.RetryBackoff != 0 {
+		r.RetryBackoff = x.RetryBackoff
+	}
+	if x.RetryMaxBackoff != 0 {
+		r.RetryMaxBackoff = x.RetryMaxBackoff
+	}
+	if x.RetryOn != nil {
+		r.RetryOn = x.RetryOn
+	}
+	if x.RetryNonIdempotent {
+		r.RetryNonIdempotent = true
+	}
+	if x.PerTryTimeout != 0 {
+		r.PerTryTimeout = x.PerTryTimeout
+	}
+	if x.InsecureSkipVerify {
+		r.InsecureSkipVerify = true
+	}
+	if x.ServerName != "" {
+		r.ServerName = x.ServerName
+	}
+	if x.CAFile != "" {
+		r.CAFile = x.CAFile
+	}
+	if x.CertFile != "" {
+		r.CertFile = x.CertFile
+	}
+	if x.KeyFile != "" {
+		r.KeyFile = x.KeyFile
+	}
+	if x.BalanceKey != "" {
+		r.BalanceKey = x.BalanceKey
+	}
+	return &r
+}
+
+// key of the fields which affect the transport, options with same key share
+// one transport and its connection pool
+func (o *HClientOption) transportKey() string {
+	return fmt.Sprintf("%d;%d;%d;%d;%d;%d;%d;%t;%s;%s;%s;%s",
+		o.ConnectTimeout,
+		o.ReadTimeout,
+		o.ExpectContinueTimeout,
+		o.IdleConnTimeout,
+		o.MaxIdleConns,
+		o.MaxIdleConnsPerHost,
+		o.MaxConnsPerHost,
+		o.InsecureSkipVerify,
+		o.ServerName,
+		o.CAFile,
+		o.CertFile,
+		o.KeyFile,
+	)
+}
+
+func (o *HClientOption) hasTransportOption() bool {
+	return o.transportKey() != (&HClientOption{}).transportKey()
+}
+
+func (o *HClientOption) tlsConfig() (*tls.Config, error) {
+	if !o.InsecureSkipVerify && o.ServerName == "" && o.CAFile == "" && o.CertFile == "" {
+		return nil, nil
+	}
+
+	c := &tls.Config{
+		InsecureSkipVerify: o.InsecureSkipVerify,
+		ServerName:         o.ServerName,
+	}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca file %s does not contain valid certificate", o.CAFile)
+		}
+		c.RootCAs = pool
+	}
+
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// creates a transport based on the default transport of net/http
+func (o *HClientOption) NewTransport() (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if o.ConnectTimeout != 0 {
+		d := &net.Dialer{
+			Timeout:   o.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}
+		t.DialContext = d.DialContext
+		t.TLSHandshakeTimeout = o.ConnectTimeout
+	}
+	if o.ReadTimeout != 0 {
+		t.ResponseHeaderTimeout = o.ReadTimeout
+	}
+	if o.ExpectContinueTimeout != 0 {
+		t.ExpectContinueTimeout = o.ExpectContinueTimeout
+	}
+	if o.IdleConnTimeout != 0 {
+		t.IdleConnTimeout = o.IdleConnTimeout
+	}
+	if o.MaxIdleConns != 0 {
+		t.MaxIdleConns = o.MaxIdleConns
+	}
+	if o.MaxIdleConnsPerHost != 0 {
+		t.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+	}
+	if o.MaxConnsPerHost != 0 {
+		t.MaxConnsPerHost = o.MaxConnsPerHost
+	}
+
+	tc, err := o.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tc != nil {
+		t.TLSClientConfig = tc
+	}
+	return t, nil
+}
+
+func (o *HClientOption) backoff(attempt int) time.Duration {
+	b := o.RetryBackoff
+	if b == 0 {
+		b = hclientDefaultRetryBackoff
+	}
+	max := o.RetryMaxBackoff
+	if max == 0 {
+		max = hclientDefaultRetryMaxBackoff
+	}
+	for i := 0; i < attempt && b < max; i++ {
+		b *= 2
+	}
+	if b > max {
+		b = max
+	}
+	return b
+}
+
+func isIdempotentMethod(m string) bool {
+	switch m {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions,
+		http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// whether the request can be issued again, the body must be replayable. Only
+// the idempotent request is retried unless RetryNonIdempotent is set
+func (o *HClientOption) canRetryRequest(req *http.Request) bool {
+	if !o.RetryNonIdempotent && !isIdempotentMethod(req.Method) {
+		return false
+	}
+	return !hasRequestBody(req) || req.GetBody != nil
+}
+
+// whether the status is retried, 502, 503 and 504 by default
+func (o *HClientOption) retryStatus(status int) bool {
+	if len(o.RetryOn) == 0 {
+		switch status {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		default:
+			return false
+		}
+	}
+	code := strconv.Itoa(status)
+	class := code[:1] + "xx"
+	for _, x := range o.RetryOn {
+		if x == code || x == class {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *HClientOption) shouldRetryResponse(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		// the rejection of the open breaker does not go away by retry
+		return !errors.Is(err, breaker.ErrOpen)
+	}
+	return o.retryStatus(resp.StatusCode)
+}
+
+func hasRequestBody(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody
+}
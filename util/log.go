@@ -0,0 +1,67 @@
+package util
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// Leveled log of the background work, ie health check, certificate renewal
+// and the failure of the events not bound to any request. The level is
+// process wide and can be changed at runtime, ie by the admin API. The log
+// below the level is dropped.
+
+const (
+	LogDebug = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelName = []string{
+	"debug",
+	"info",
+	"warn",
+	"error",
+}
+
+var logLevel int32 = LogInfo
+
+// SetLogLevel changes the level by its name, one of debug, info, warn and
+// error
+func SetLogLevel(name string) error {
+	for i, x := range logLevelName {
+		if x == name {
+			atomic.StoreInt32(&logLevel, int32(i))
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log level %s", name)
+}
+
+func LogLevel() string {
+	return logLevelName[atomic.LoadInt32(&logLevel)]
+}
+
+func logf(level int32, format string, args ...interface{}) {
+	if level < atomic.LoadInt32(&logLevel) {
+		return
+	}
+	log.Printf("["+logLevelName[level]+"] "+format, args...)
+}
+
+func Debugf(format string, args ...interface{}) {
+	logf(LogDebug, format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	logf(LogInfo, format, args...)
+}
+
+func Warnf(format string, args ...interface{}) {
+	logf(LogWarn, format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	logf(LogError, format, args...)
+}
@@ -0,0 +1,74 @@
+package util
+
+import (
+	"container/list"
+	"sync"
+)
+
+// A simple thread safe LRU cache with string key. Once the cache is full, the
+// least recently used entry is evicted when a new entry is added.
+type LRU struct {
+	lock     sync.Mutex
+	capacity int
+	ll       *list.List
+	m        map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+func NewLRU(capacity int) *LRU {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &LRU{
+		capacity: capacity,
+		ll:       list.New(),
+		m:        make(map[string]*list.Element),
+	}
+}
+
+func (l *LRU) Get(key string) (interface{}, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	e, ok := l.m[key]
+	if !ok {
+		return nil, false
+	}
+	l.ll.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+func (l *LRU) Put(key string, value interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if e, ok := l.m[key]; ok {
+		e.Value.(*lruEntry).value = value
+		l.ll.MoveToFront(e)
+		return
+	}
+
+	l.m[key] = l.ll.PushFront(&lruEntry{key: key, value: value})
+	for l.ll.Len() > l.capacity {
+		last := l.ll.Back()
+		l.ll.Remove(last)
+		delete(l.m, last.Value.(*lruEntry).key)
+	}
+}
+
+func (l *LRU) Len() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.ll.Len()
+}
+
+func (l *LRU) Purge() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.ll.Init()
+	l.m = make(map[string]*list.Element)
+}
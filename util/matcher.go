@@ -1,11 +1,10 @@
 package util
 
-import (
-	"strings"
-)
-
 type Matcher func(string, string) bool
 
+// ToMatcher converts the input pattern into a matcher function. The matcher
+// is invoked as m(input, pattern), the pattern is a glob pattern, see Glob
+// for more information.
 func ToMatcher(
 	pattern string,
 ) Matcher {
@@ -16,20 +15,15 @@ func ToMatcher(
 		}
 	}
 
-	// prefix and suffix cases
-	prefixWildcard := strings.HasPrefix(pattern, "*")
-	suffixWildcard := strings.HasSuffix(pattern, "*")
-
-	if prefixWildcard && suffixWildcard {
-		return strings.Contains
-	} else if prefixWildcard {
-		return strings.HasPrefix
-	} else if suffixWildcard {
-		return strings.HasSuffix
-	} else {
-		// exact matching
+	// exact matching, or the pattern is malformed
+	g, err := CompileGlob(pattern)
+	if IsGlobLiteral(pattern) || err != nil {
 		return func(a, b string) bool {
 			return a == b
 		}
 	}
+
+	return func(a, _ string) bool {
+		return g.Match(a)
+	}
 }
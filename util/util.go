@@ -70,3 +70,15 @@ func NotZeroStr(v string, d string) string {
 	}
 	return v
 }
+
+// HttpProtocol returns the protocol negotiated by the request in the ALPN
+// name, ie http/1.1, h2, and h2c for the http/2 over cleartext
+func HttpProtocol(r *http.Request) string {
+	if r.ProtoMajor == 2 {
+		if r.TLS != nil {
+			return "h2"
+		}
+		return "h2c"
+	}
+	return fmt.Sprintf("http/%d.%d", r.ProtoMajor, r.ProtoMinor)
+}
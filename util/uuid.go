@@ -0,0 +1,27 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NewUUIDv7 generates a version 7 UUID, ie the unix time in milliseconds
+// followed by random bits, so the ids are roughly sorted by the creation time
+func NewUUIDv7() string {
+	var u uuid.UUID
+	if _, err := rand.Read(u[6:]); err != nil {
+		return uuid.NewString()
+	}
+
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(u[0:6], ts[2:])
+
+	u[6] = (u[6] & 0x0f) | 0x70 // version 7
+	u[8] = (u[8] & 0x3f) | 0x80 // variant RFC 4122
+	return u.String()
+}
@@ -0,0 +1,274 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// takes over the connection of a http transaction, ie http.Hijacker
+type HijackFunc func() (net.Conn, *bufio.ReadWriter, error)
+
+func headerContainsToken(h http.Header, name string, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, x := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(x), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checks whether the request is a valid websocket opening handshake
+func CheckUpgradeRequest(r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return fmt.Errorf("websocket: method must be GET")
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") {
+		return fmt.Errorf("websocket: Connection header must contain upgrade")
+	}
+	if !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		return fmt.Errorf("websocket: Upgrade header must be websocket")
+	}
+	if r.Header.Get("Sec-Websocket-Version") != "13" {
+		return fmt.Errorf("websocket: unsupported version")
+	}
+	if r.Header.Get("Sec-Websocket-Key") == "" {
+		return fmt.Errorf("websocket: Sec-WebSocket-Key is missing")
+	}
+	return nil
+}
+
+// picks the first subprotocol of the server's list which is requested by the
+// client, empty if none matches
+func selectSubprotocol(r *http.Request, subprotocols []string) string {
+	for _, x := range subprotocols {
+		if headerContainsToken(r.Header, "Sec-Websocket-Protocol", x) {
+			return x
+		}
+	}
+	return ""
+}
+
+// Upgrade finishes the server side handshake on the hijacked connection. The
+// request is supposed to be checked by CheckUpgradeRequest already
+func Upgrade(r *http.Request, hijack HijackFunc, subprotocols []string) (*Conn, error) {
+	if err := CheckUpgradeRequest(r); err != nil {
+		return nil, err
+	}
+
+	conn, brw, err := hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	// the client is not allowed to send frames before the handshake is done,
+	// so anything buffered is a protocol violation
+	if brw.Reader.Buffered() > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: client sent data before handshake")
+	}
+
+	proto := selectSubprotocol(r, subprotocols)
+
+	b := strings.Builder{}
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	b.WriteString("Upgrade: websocket\r\n")
+	b.WriteString("Connection: Upgrade\r\n")
+	b.WriteString("Sec-WebSocket-Accept: ")
+	b.WriteString(acceptKey(r.Header.Get("Sec-Websocket-Key")))
+	b.WriteString("\r\n")
+	if proto != "" {
+		b.WriteString("Sec-WebSocket-Protocol: ")
+		b.WriteString(proto)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("\r\n")
+
+	// the deadline set by the http server is removed, the connection is long
+	// lived from now on
+	conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write([]byte(b.String())); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newConn(conn, brw.Reader, false, proto), nil
+}
+
+// UpgradeResponseWriter upgrades with the hijacker of the http.ResponseWriter
+func UpgradeResponseWriter(w http.ResponseWriter, r *http.Request, subprotocols []string) (*Conn, error) {
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, fmt.Errorf("websocket: response writer does not support hijack")
+	}
+	return Upgrade(r, h.Hijack, subprotocols)
+}
+
+// option of the client side handshake
+type DialOption struct {
+	Header       http.Header
+	Subprotocols []string
+	Timeout      time.Duration
+	TLSConfig    *tls.Config
+}
+
+func newClientKey() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b[:]), nil
+}
+
+// Dial connects to the websocket server, the url scheme is one of ws, wss,
+// http and https. The handshake response is returned as well, its body is
+// always empty
+func Dial(rawurl string, option *DialOption) (*Conn, *http.Response, error) {
+	if option == nil {
+		option = &DialOption{}
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secure := false
+	switch u.Scheme {
+	case "ws", "http":
+		break
+	case "wss", "https":
+		secure = true
+	default:
+		return nil, nil, fmt.Errorf("websocket: unsupported url scheme %s", u.Scheme)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		if secure {
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	timeout := option.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	dialer := &net.Dialer{
+		Timeout: timeout,
+	}
+
+	var conn net.Conn
+	if secure {
+		cfg := option.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		} else {
+			cfg = cfg.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, cfg)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, resp, err := clientHandshake(conn, u, option, timeout)
+	if err != nil {
+		conn.Close()
+		return nil, resp, err
+	}
+	return c, resp, nil
+}
+
+func clientHandshake(conn net.Conn, u *url.URL, option *DialOption, timeout time.Duration) (*Conn, *http.Response, error) {
+	key, err := newClientKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hu := *u
+	if hu.Scheme == "ws" {
+		hu.Scheme = "http"
+	} else if hu.Scheme == "wss" {
+		hu.Scheme = "https"
+	}
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &hu,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	for k, v := range option.Header {
+		if k == "Host" && len(v) > 0 {
+			req.Host = v[0]
+			continue
+		}
+		req.Header[k] = append([]string(nil), v...)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(option.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(option.Subprotocols, ", "))
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := req.Write(conn); err != nil {
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, resp, fmt.Errorf("websocket: handshake failed with status %d", resp.StatusCode)
+	}
+	if !headerContainsToken(resp.Header, "Upgrade", "websocket") ||
+		!headerContainsToken(resp.Header, "Connection", "upgrade") {
+		return nil, resp, fmt.Errorf("websocket: invalid handshake response")
+	}
+	if resp.Header.Get("Sec-Websocket-Accept") != acceptKey(key) {
+		return nil, resp, fmt.Errorf("websocket: invalid Sec-WebSocket-Accept")
+	}
+
+	proto := resp.Header.Get("Sec-Websocket-Protocol")
+	if proto != "" {
+		found := false
+		for _, x := range option.Subprotocols {
+			if x == proto {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, resp, fmt.Errorf("websocket: server selected unknown subprotocol %s", proto)
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+	return newConn(conn, br, true, proto), resp, nil
+}
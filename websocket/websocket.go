@@ -0,0 +1,494 @@
+package websocket
+
+// A minimal websocket (RFC 6455) implementation used by the http framework
+// and the hpl script. It supports text/binary message with fragmentation,
+// ping/pong and the closing handshake. Extensions, ie permessage-deflate, are
+// not negotiated.
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	continuationFrame = 0
+)
+
+// close code defined by RFC 6455 section 7.4.1
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseAbnormalClosure  = 1006
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+)
+
+const (
+	DefaultMaxMessageSize = 1 << 20
+
+	maxControlPayload = 125
+	closeWaitTimeout  = time.Second
+	acceptGUID        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// returned by ReadMessage once the close frame is received or the connection
+// is closed locally
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with code %d %s", e.Code, e.Reason)
+}
+
+var (
+	errWriteClosed = errors.New("websocket: write after close")
+)
+
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool
+
+	// protects the writing side, control frames can be sent while other
+	// message is written, ie pong from the reading goroutine
+	wmu sync.Mutex
+
+	maxMessageSize int64
+	subprotocol    string
+
+	sentClose  bool
+	recvClose  bool
+	closeError *CloseError
+	readError  error
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, client bool, subprotocol string) *Conn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &Conn{
+		conn:           conn,
+		br:             br,
+		client:         client,
+		maxMessageSize: DefaultMaxMessageSize,
+		subprotocol:    subprotocol,
+	}
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// max size of a reassembled message, exceeding it closes the connection with
+// 1009. Zero or negative means no limit
+func (c *Conn) SetMaxMessageSize(sz int64) {
+	c.maxMessageSize = sz
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// whether the closing handshake is started by either side
+func (c *Conn) IsClosed() bool {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.sentClose || c.recvClose
+}
+
+// the close status received from the peer, nil if not yet received
+func (c *Conn) CloseStatus() *CloseError {
+	return c.closeError
+}
+
+// frame writing ---------------------------------------------------------------
+func (c *Conn) writeFrame(op int, fin bool, data []byte) error {
+	hdr := make([]byte, 0, 14)
+	b0 := byte(op)
+	if fin {
+		b0 |= 0x80
+	}
+	hdr = append(hdr, b0)
+
+	var mbit byte
+	if c.client {
+		mbit = 0x80
+	}
+
+	sz := len(data)
+	switch {
+	case sz <= 125:
+		hdr = append(hdr, mbit|byte(sz))
+	case sz <= 0xffff:
+		hdr = append(hdr, mbit|126, 0, 0)
+		binary.BigEndian.PutUint16(hdr[2:], uint16(sz))
+	default:
+		hdr = append(hdr, mbit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(hdr[2:], uint64(sz))
+	}
+
+	// frames sent by client must be masked, the data is copied since the
+	// caller still owns it
+	if c.client {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		hdr = append(hdr, key[:]...)
+		masked := make([]byte, sz)
+		for i := 0; i < sz; i++ {
+			masked[i] = data[i] ^ key[i&3]
+		}
+		data = masked
+	}
+
+	if _, err := c.conn.Write(append(hdr, data...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writes the message as a single frame
+func (c *Conn) WriteMessage(op int, data []byte) error {
+	switch op {
+	case TextMessage, BinaryMessage:
+		break
+	case PingMessage, PongMessage:
+		if len(data) > maxControlPayload {
+			return fmt.Errorf("websocket: control frame payload too large")
+		}
+	default:
+		return fmt.Errorf("websocket: invalid message type %d", op)
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.sentClose {
+		return errWriteClosed
+	}
+	return c.writeFrame(op, true, data)
+}
+
+func (c *Conn) writeClose(code int, reason string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.sentClose {
+		return nil
+	}
+	c.sentClose = true
+
+	var payload []byte
+	if code != CloseNoStatusReceived {
+		if len(reason) > maxControlPayload-2 {
+			reason = reason[:maxControlPayload-2]
+		}
+		payload = make([]byte, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		copy(payload[2:], reason)
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(closeWaitTimeout))
+	return c.writeFrame(CloseMessage, true, payload)
+}
+
+// frame reading ---------------------------------------------------------------
+type frame struct {
+	fin     bool
+	op      int
+	payload []byte
+}
+
+func (c *Conn) protocolError(code int, msg string) error {
+	c.writeClose(code, msg)
+	c.conn.Close()
+	c.readError = fmt.Errorf("websocket: %s", msg)
+	return c.readError
+}
+
+func (c *Conn) readFrame(remain int64) (frame, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		fin: hdr[0]&0x80 != 0,
+		op:  int(hdr[0] & 0x0f),
+	}
+	if hdr[0]&0x70 != 0 {
+		return f, c.protocolError(CloseProtocolError, "reserved bit set")
+	}
+
+	masked := hdr[1]&0x80 != 0
+	if masked == c.client {
+		return f, c.protocolError(CloseProtocolError, "invalid frame mask")
+	}
+
+	sz := int64(hdr[1] & 0x7f)
+	switch sz {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return f, err
+		}
+		sz = int64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return f, err
+		}
+		sz = int64(binary.BigEndian.Uint64(b[:]))
+		if sz < 0 {
+			return f, c.protocolError(CloseProtocolError, "invalid frame length")
+		}
+	}
+
+	if f.op >= CloseMessage {
+		// 0xB-0xF are reserved for further control frames
+		if f.op > PongMessage {
+			return f, c.protocolError(CloseProtocolError, "unknown opcode")
+		}
+		if !f.fin || sz > maxControlPayload {
+			return f, c.protocolError(CloseProtocolError, "invalid control frame")
+		}
+	} else if remain >= 0 && sz > remain {
+		return f, c.protocolError(CloseMessageTooBig, "message too big")
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, key[:]); err != nil {
+			return f, err
+		}
+	}
+
+	f.payload = make([]byte, sz)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return f, err
+	}
+	if masked {
+		for i := range f.payload {
+			f.payload[i] ^= key[i&3]
+		}
+	}
+	return f, nil
+}
+
+// handles the control frame, returns error when the close frame is received
+func (c *Conn) handleControl(f frame) error {
+	switch f.op {
+	case PingMessage:
+		c.wmu.Lock()
+		if !c.sentClose {
+			c.writeFrame(PongMessage, true, f.payload)
+		}
+		c.wmu.Unlock()
+		return nil
+
+	case PongMessage:
+		return nil
+
+	case CloseMessage:
+		ce := &CloseError{
+			Code: CloseNoStatusReceived,
+		}
+		if len(f.payload) == 1 {
+			return c.protocolError(CloseProtocolError, "invalid close payload")
+		}
+		if len(f.payload) >= 2 {
+			ce.Code = int(binary.BigEndian.Uint16(f.payload))
+			ce.Reason = string(f.payload[2:])
+		}
+		c.wmu.Lock()
+		c.recvClose = true
+		c.wmu.Unlock()
+		c.closeError = ce
+		c.readError = ce
+
+		// echoes the close frame back and finishes the connection
+		c.writeClose(ce.Code, "")
+		c.conn.Close()
+		return ce
+
+	default:
+		return c.protocolError(CloseProtocolError, "unknown opcode")
+	}
+}
+
+// reads the next data message, control frames are handled internally. Once the
+// peer closes, *CloseError is returned
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	if c.readError != nil {
+		return 0, nil, c.readError
+	}
+
+	op := -1
+	var msg []byte
+
+	for {
+		remain := int64(-1)
+		if c.maxMessageSize > 0 {
+			remain = c.maxMessageSize - int64(len(msg))
+		}
+
+		f, err := c.readFrame(remain)
+		if err != nil {
+			if c.readError == nil {
+				c.readError = err
+			}
+			return 0, nil, err
+		}
+
+		if f.op >= CloseMessage {
+			if err := c.handleControl(f); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		switch f.op {
+		case TextMessage, BinaryMessage:
+			if op != -1 {
+				return 0, nil, c.protocolError(CloseProtocolError, "unexpected data frame")
+			}
+			op = f.op
+		case continuationFrame:
+			if op == -1 {
+				return 0, nil, c.protocolError(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.protocolError(CloseProtocolError, "unknown opcode")
+		}
+
+		msg = append(msg, f.payload...)
+		if f.fin {
+			break
+		}
+	}
+
+	if op == TextMessage && !utf8.Valid(msg) {
+		return 0, nil, c.protocolError(CloseInvalidPayload, "invalid utf8 text")
+	}
+	return op, msg, nil
+}
+
+// starts the closing handshake with the code and reason, waits a short while
+// for the peer's close frame and then closes the connection
+func (c *Conn) CloseWithCode(code int, reason string) error {
+	err := c.writeClose(code, reason)
+
+	if c.readError == nil {
+		c.conn.SetReadDeadline(time.Now().Add(closeWaitTimeout))
+		for {
+			if _, _, rerr := c.ReadMessage(); rerr != nil {
+				break
+			}
+		}
+	}
+	if c.closeError == nil {
+		c.readError = &CloseError{
+			Code:   code,
+			Reason: reason,
+		}
+	}
+
+	if cerr := c.conn.Close(); err == nil && !isClosedConnError(cerr) {
+		err = cerr
+	}
+	return err
+}
+
+func (c *Conn) Close() error {
+	return c.CloseWithCode(CloseNormalClosure, "")
+}
+
+func isClosedConnError(err error) bool {
+	return err == nil || errors.Is(err, net.ErrClosed)
+}
+
+// Proxy relays the messages between a and b in both directions until either
+// side closes, the close status is forwarded to the other side. Both
+// connections are closed when it returns
+func Proxy(a *Conn, b *Conn) error {
+	errc := make(chan error, 2)
+	go func() { errc <- relay(a, b) }()
+	go func() { errc <- relay(b, a) }()
+
+	err := <-errc
+
+	// the other direction is finished by the close frame echoed back, or the
+	// wait timeout
+	a.conn.SetReadDeadline(time.Now().Add(closeWaitTimeout))
+	b.conn.SetReadDeadline(time.Now().Add(closeWaitTimeout))
+	<-errc
+
+	a.conn.Close()
+	b.conn.Close()
+
+	if _, ok := err.(*CloseError); ok {
+		return nil
+	}
+	return err
+}
+
+// relays messages from src to dst, once src is closed the close frame is
+// forwarded to dst without waiting for the reply since the reply is read by
+// the other direction
+func relay(src *Conn, dst *Conn) error {
+	for {
+		op, data, err := src.ReadMessage()
+		if err != nil {
+			code, reason := CloseGoingAway, ""
+			if ce, ok := err.(*CloseError); ok {
+				code, reason = ce.Code, ce.Reason
+			}
+			dst.writeClose(code, reason)
+			return err
+		}
+		if err := dst.WriteMessage(op, data); err != nil {
+			src.writeClose(CloseGoingAway, "")
+			return err
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testMask = [4]byte{0x12, 0x34, 0x56, 0x78}
+
+// raw frame of the opcode, masked as a client frame if mask is set
+func rawFrame(fin bool, op int, payload []byte, mask bool) []byte {
+	b0 := byte(op)
+	if fin {
+		b0 |= 0x80
+	}
+	o := []byte{b0}
+
+	var mbit byte
+	if mask {
+		mbit = 0x80
+	}
+	sz := len(payload)
+	switch {
+	case sz <= 125:
+		o = append(o, mbit|byte(sz))
+	case sz <= 0xffff:
+		o = append(o, mbit|126, byte(sz>>8), byte(sz))
+	default:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(sz))
+		o = append(append(o, mbit|127), b[:]...)
+	}
+
+	if !mask {
+		return append(o, payload...)
+	}
+	o = append(o, testMask[:]...)
+	for i, x := range payload {
+		o = append(o, x^testMask[i&3])
+	}
+	return o
+}
+
+func clientFrame(fin bool, op int, payload string) []byte {
+	return rawFrame(fin, op, []byte(payload), true)
+}
+
+func closePayload(code int, reason string) string {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(code))
+	return string(b[:]) + reason
+}
+
+type readResult struct {
+	op    int
+	msg   []byte
+	err   error
+	reply []byte
+}
+
+// writes the raw frames to the server side connection and reads one message
+// from it, the reply is what the server sent back
+func serve(maxSize int64, frames ...[]byte) readResult {
+	a, b := net.Pipe()
+	c := newConn(a, nil, false, "")
+	c.SetMaxMessageSize(maxSize)
+
+	go func() {
+		for _, f := range frames {
+			if _, err := b.Write(f); err != nil {
+				return
+			}
+		}
+	}()
+
+	replyc := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(b)
+		replyc <- data
+	}()
+
+	r := readResult{}
+	r.op, r.msg, r.err = c.ReadMessage()
+	a.Close()
+	r.reply = <-replyc
+	b.Close()
+	return r
+}
+
+// close code of the first close frame in the reply, -1 if none
+func replyCloseCode(reply []byte) int {
+	for len(reply) >= 2 {
+		op := int(reply[0] & 0x0f)
+		sz := int(reply[1] & 0x7f)
+		if len(reply) < 2+sz {
+			break
+		}
+		if op == CloseMessage {
+			if sz < 2 {
+				return CloseNoStatusReceived
+			}
+			return int(binary.BigEndian.Uint16(reply[2:]))
+		}
+		reply = reply[2+sz:]
+	}
+	return -1
+}
+
+func TestReadMessage(t *testing.T) {
+	euro := "€"
+
+	cases := []struct {
+		name    string
+		maxSize int64
+		frames  [][]byte
+		op      int
+		msg     string
+		code    int
+	}{
+		{
+			name:   "text",
+			frames: [][]byte{clientFrame(true, TextMessage, "hello")},
+			op:     TextMessage,
+			msg:    "hello",
+			code:   -1,
+		},
+		{
+			name:   "binary",
+			frames: [][]byte{clientFrame(true, BinaryMessage, "\xff\x00")},
+			op:     BinaryMessage,
+			msg:    "\xff\x00",
+			code:   -1,
+		},
+		{
+			name:   "medium length",
+			frames: [][]byte{clientFrame(true, BinaryMessage, string(bytes.Repeat([]byte{'x'}, 300)))},
+			op:     BinaryMessage,
+			msg:    string(bytes.Repeat([]byte{'x'}, 300)),
+			code:   -1,
+		},
+		{
+			name: "fragmented",
+			frames: [][]byte{
+				clientFrame(false, TextMessage, "he"),
+				clientFrame(false, continuationFrame, "l"),
+				clientFrame(true, continuationFrame, "lo"),
+			},
+			op:   TextMessage,
+			msg:  "hello",
+			code: -1,
+		},
+		{
+			name: "fragmented with control frame",
+			frames: [][]byte{
+				clientFrame(false, TextMessage, "he"),
+				clientFrame(true, PingMessage, "p"),
+				clientFrame(true, PongMessage, ""),
+				clientFrame(true, continuationFrame, "llo"),
+			},
+			op:   TextMessage,
+			msg:  "hello",
+			code: -1,
+		},
+		{
+			name: "rune across fragments",
+			frames: [][]byte{
+				clientFrame(false, TextMessage, euro[:1]),
+				clientFrame(true, continuationFrame, euro[1:]),
+			},
+			op:   TextMessage,
+			msg:  euro,
+			code: -1,
+		},
+		{
+			name:   "unmasked client frame",
+			frames: [][]byte{rawFrame(true, TextMessage, []byte("hello"), false)},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "reserved bit",
+			frames: [][]byte{append([]byte{0x80 | 0x40 | TextMessage}, clientFrame(true, TextMessage, "x")[1:]...)},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "continuation without start",
+			frames: [][]byte{clientFrame(true, continuationFrame, "x")},
+			code:   CloseProtocolError,
+		},
+		{
+			name: "data frame within fragmented message",
+			frames: [][]byte{
+				clientFrame(false, TextMessage, "a"),
+				clientFrame(true, TextMessage, "b"),
+			},
+			code: CloseProtocolError,
+		},
+		{
+			name:   "reserved data opcode",
+			frames: [][]byte{clientFrame(true, 3, "x")},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "reserved control opcode 0xb",
+			frames: [][]byte{clientFrame(true, 0xb, "x")},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "reserved control opcode 0xf",
+			frames: [][]byte{clientFrame(true, 0xf, "")},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "fragmented control frame",
+			frames: [][]byte{clientFrame(false, PingMessage, "x")},
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "control frame too large",
+			frames: [][]byte{clientFrame(true, PingMessage, string(bytes.Repeat([]byte{'x'}, 126)))},
+			code:   CloseProtocolError,
+		},
+		{
+			name:    "oversize frame",
+			maxSize: 8,
+			frames:  [][]byte{clientFrame(true, BinaryMessage, "123456789")},
+			code:    CloseMessageTooBig,
+		},
+		{
+			name:    "oversize fragmented message",
+			maxSize: 8,
+			frames: [][]byte{
+				clientFrame(false, BinaryMessage, "12345"),
+				clientFrame(true, continuationFrame, "6789"),
+			},
+			code: CloseMessageTooBig,
+		},
+		{
+			name:    "max size",
+			maxSize: 8,
+			frames: [][]byte{
+				clientFrame(false, BinaryMessage, "1234"),
+				clientFrame(true, continuationFrame, "5678"),
+			},
+			op:   BinaryMessage,
+			msg:  "12345678",
+			code: -1,
+		},
+		{
+			name:   "invalid utf8",
+			frames: [][]byte{clientFrame(true, TextMessage, "\xff\xfe")},
+			code:   CloseInvalidPayload,
+		},
+		{
+			name: "truncated rune",
+			frames: [][]byte{
+				clientFrame(false, TextMessage, "a"),
+				clientFrame(true, continuationFrame, euro[:2]),
+			},
+			code: CloseInvalidPayload,
+		},
+		{
+			name:   "close payload of one byte",
+			frames: [][]byte{clientFrame(true, CloseMessage, "x")},
+			code:   CloseProtocolError,
+		},
+	}
+
+	for _, c := range cases {
+		r := serve(c.maxSize, c.frames...)
+		if c.code == -1 {
+			if assert.Nil(t, r.err, c.name) {
+				assert.Equal(t, c.op, r.op, c.name)
+				assert.Equal(t, c.msg, string(r.msg), c.name)
+			}
+		} else {
+			assert.NotNil(t, r.err, c.name)
+		}
+		assert.Equal(t, c.code, replyCloseCode(r.reply), c.name)
+	}
+}
+
+func TestPing(t *testing.T) {
+	r := serve(0,
+		clientFrame(true, PingMessage, "abc"),
+		clientFrame(true, TextMessage, "x"),
+	)
+	assert.Nil(t, r.err)
+	assert.Equal(t, rawFrame(true, PongMessage, []byte("abc"), false), r.reply[:5])
+}
+
+func TestClose(t *testing.T) {
+	r := serve(0, clientFrame(true, CloseMessage, closePayload(CloseGoingAway, "bye")))
+	assert.Equal(t, &CloseError{Code: CloseGoingAway, Reason: "bye"}, r.err)
+	assert.Equal(t, CloseGoingAway, replyCloseCode(r.reply))
+
+	r = serve(0, clientFrame(true, CloseMessage, ""))
+	assert.Equal(t, &CloseError{Code: CloseNoStatusReceived}, r.err)
+	assert.Equal(t, CloseNoStatusReceived, replyCloseCode(r.reply))
+}
+
+func TestRoundTrip(t *testing.T) {
+	a, b := net.Pipe()
+	server := newConn(a, nil, false, "")
+	client := newConn(b, nil, true, "")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			op, msg, err := server.ReadMessage()
+			if err != nil {
+				return
+			}
+			if server.WriteMessage(op, msg) != nil {
+				return
+			}
+		}
+	}()
+
+	large := bytes.Repeat([]byte{'z'}, 70000)
+	for _, m := range []struct {
+		op   int
+		data []byte
+	}{
+		{TextMessage, []byte("hello")},
+		{BinaryMessage, []byte{0, 1, 2}},
+		{BinaryMessage, large},
+		{TextMessage, []byte{}},
+	} {
+		errc := make(chan error, 1)
+		go func() { errc <- client.WriteMessage(m.op, m.data) }()
+		op, msg, err := client.ReadMessage()
+		assert.Nil(t, <-errc)
+		assert.Nil(t, err)
+		assert.Equal(t, m.op, op)
+		assert.Equal(t, string(m.data), string(msg))
+	}
+
+	assert.Nil(t, client.Close())
+	<-done
+	assert.Equal(t, &CloseError{Code: CloseNormalClosure}, server.CloseStatus())
+	assert.Equal(t, errWriteClosed, client.WriteMessage(TextMessage, []byte("x")))
+}